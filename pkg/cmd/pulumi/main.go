@@ -22,6 +22,7 @@ import (
 
 	"github.com/pulumi/pulumi/pkg/v3/version"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
 )
 
 func panicHandler() {
@@ -39,13 +40,17 @@ func panicHandler() {
 		fmt.Fprintf(os.Stderr, "Operating System: %s\n", runtime.GOOS)
 		fmt.Fprintf(os.Stderr, "Panic:            %s\n\n", panicPayload)
 		fmt.Fprintln(os.Stderr, stack)
+		contract.IgnoreError(workspace.RemoveDecryptedPlugins())
 		os.Exit(1)
 	}
 }
 
 func main() {
 	defer panicHandler()
-	if err := NewPulumiCmd().Execute(); err != nil {
+	err := NewPulumiCmd().Execute()
+	// Plugins decrypted from an encrypted plugin cache are only kept in cleartext while the CLI runs.
+	contract.IgnoreError(workspace.RemoveDecryptedPlugins())
+	if err != nil {
 		_, err = fmt.Fprintf(os.Stderr, "An error occurred: %v\n", err)
 		contract.IgnoreError(err)
 		os.Exit(1)
@@ -35,6 +35,7 @@ import (
 	"github.com/pulumi/pulumi/pkg/v3/backend/state"
 	"github.com/pulumi/pulumi/pkg/v3/engine"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/plugin"
@@ -242,6 +243,15 @@ func runNew(args newArgs) error {
 	fmt.Printf("Created project '%s'\n", args.name)
 	fmt.Println()
 
+	// Download the plugins the template needs while the rest of the project is set up, so the first update doesn't
+	// have to wait for them. Installs that are interrupted are redone when the plugins are next needed.
+	var prefetch *workspace.PluginPrefetch
+	if !args.generateOnly && !args.offline {
+		if prefetch, err = workspace.PrefetchTemplatePlugins(template, workspace.BatchInstallOptions{}); err != nil {
+			return err
+		}
+	}
+
 	// Load the project, update the name & description, remove the template section, and save it.
 	proj, root, err := readProject()
 	if err != nil {
@@ -324,6 +334,12 @@ func runNew(args newArgs) error {
 		}
 	}
 
+	if prefetch != nil {
+		if err := prefetch.Wait(); err != nil {
+			cmdutil.Diag().Warningf(diag.Message("", "could not download the template's plugins: %v"), err)
+		}
+	}
+
 	fmt.Println(
 		opts.Color.Colorize(
 			colors.BrightGreen+colors.Bold+"Your new project is ready to go!"+colors.Reset) +
@@ -46,6 +46,8 @@ func newPluginCmd() *cobra.Command {
 	cmd.AddCommand(newPluginInstallCmd())
 	cmd.AddCommand(newPluginLsCmd())
 	cmd.AddCommand(newPluginRmCmd())
+	cmd.AddCommand(newPluginShareCmd())
+	cmd.AddCommand(newPluginSearchReportCmd())
 
 	return cmd
 }
@@ -18,16 +18,20 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"strings"
+	"sync"
 
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
 
 	"github.com/blang/semver"
 
 	"github.com/spf13/cobra"
+	survey "gopkg.in/AlecAivazis/survey.v1"
+	surveycore "gopkg.in/AlecAivazis/survey.v1/core"
 
 	"github.com/pulumi/pulumi/pkg/v3/backend/display"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
 )
@@ -37,6 +41,10 @@ func newPluginInstallCmd() *cobra.Command {
 	var exact bool
 	var file string
 	var reinstall bool
+	var concurrency int
+	var bundlePath string
+	var dryRun bool
+	var variant string
 
 	var cmd = &cobra.Command{
 		Use:   "install [KIND NAME [VERSION]]",
@@ -47,18 +55,53 @@ func newPluginInstallCmd() *cobra.Command {
 			"This command is used manually install plugins required by your program.  It may\n" +
 			"be run either with a specific KIND, NAME, and VERSION, or by omitting these and\n" +
 			"letting Pulumi compute the set of plugins that may be required by the current\n" +
-			"project. If specified VERSION cannot be a range: it must be a specific number.\n" +
+			"project. If specified, VERSION may be a specific number or a range, such as\n" +
+			"'>=5.0.0 <6.0.0', to install the latest published version in the range.\n" +
 			"\n" +
 			"If you let Pulumi compute the set to download, it is conservative and may end up\n" +
-			"downloading more plugins than is strictly necessary.",
+			"downloading more plugins than is strictly necessary.\n" +
+			"\n" +
+			"With --file, the plugin is installed from a local tarball instead of downloaded. Its\n" +
+			"KIND, NAME and VERSION must all be given, since the tarball doesn't record them.\n" +
+			"\n" +
+			"With --bundle, every plugin listed in a bundle manifest, read from a file or URL,\n" +
+			"is installed instead.\n" +
+			"\n" +
+			"With --dry-run, the URLs each plugin would be downloaded from are printed instead,\n" +
+			"without sending any requests, to check the configured mirrors and overrides.\n" +
+			"\n" +
+			"With --variant, the tarball given with --file is installed as an alternate build of\n" +
+			"the plugin version, such as a debug build, alongside its release build. Variants are\n" +
+			"used instead of the release build when selected with PULUMI_PLUGIN_VARIANTS, e.g.\n" +
+			"PULUMI_PLUGIN_VARIANTS=aws=debug.",
 		Run: cmdutil.RunFunc(func(cmd *cobra.Command, args []string) error {
 			displayOpts := display.Options{
 				Color: cmdutil.GetGlobalColorization(),
 			}
 
+			if variant != "" {
+				if file == "" || len(args) < 3 {
+					return errors.New("--variant requires a specific plugin VERSION and --file (-f)")
+				}
+				if err := workspace.ValidatePluginVariant(variant); err != nil {
+					return err
+				}
+			}
+
 			// Parse the kind, name, and version, if specified.
 			var installs []workspace.PluginInfo
-			if len(args) > 0 {
+			if bundlePath != "" {
+				if len(args) > 0 || file != "" {
+					return errors.New("--bundle can't be combined with a specific plugin or --file (-f)")
+				}
+				bundle, err := workspace.LoadPluginBundle(bundlePath)
+				if err != nil {
+					return err
+				}
+				logging.V(1).Infof("installing the %d plugins of bundle %s v%s", len(bundle.Plugins), bundle.Name,
+					bundle.Version)
+				installs = bundle.Plugins
+			} else if len(args) > 0 {
 				if !workspace.IsPluginKind(args[0]) {
 					return fmt.Errorf("unrecognized plugin kind: %s", args[0])
 				} else if len(args) < 2 {
@@ -66,11 +109,15 @@ func newPluginInstallCmd() *cobra.Command {
 				}
 
 				var version *semver.Version
+				var versionRange string
 				if len(args) == 3 {
 					parsedVersion, err := semver.ParseTolerant(args[2])
 					version = &parsedVersion
 					if err != nil {
-						return fmt.Errorf("invalid plugin semver: %w", err)
+						if _, rangeErr := semver.ParseRange(args[2]); rangeErr != nil || file != "" {
+							return fmt.Errorf("invalid plugin semver: %w", err)
+						}
+						version, versionRange = nil, args[2]
 					}
 				}
 
@@ -78,11 +125,27 @@ func newPluginInstallCmd() *cobra.Command {
 					Kind:              workspace.PluginKind(args[0]),
 					Name:              args[1],
 					Version:           version,
+					VersionRange:      versionRange,
 					PluginDownloadURL: serverURL, // If empty, will use default plugin source.
+					Variant:           variant,
+				}
+
+				// If we have a range of versions, resolve it to the latest published version in it.
+				if versionRange != "" && !dryRun {
+					resolved, err := pluginInfo.ResolveVersionRange()
+					if err != nil {
+						return err
+					}
+					pluginInfo = resolved
+				}
+
+				// Tarballs don't say which version of the plugin they are, so it must be given.
+				if file != "" && version == nil {
+					return errors.New("--file (-f) requires a specific plugin VERSION")
 				}
 
 				// If we don't have a version try to look one up
-				if version == nil {
+				if version == nil && versionRange == "" && !dryRun {
 					latestVersion, err := pluginInfo.GetLatestVersion()
 					if err != nil {
 						return err
@@ -110,14 +173,26 @@ func newPluginInstallCmd() *cobra.Command {
 				}
 			}
 
-			// Now for each kind, name, version pair, download it from the release website, and install it.
+			if dryRun {
+				return printPluginDownloadURLs(installs)
+			}
+
+			// Plugins whose license requires acceptance ask for it when we can prompt, and fail otherwise.
+			if cmdutil.Interactive() {
+				workspace.PluginLicensePrompt = func(info workspace.PluginInfo, license workspace.PluginLicense) (bool, error) {
+					return promptForPluginLicense(info, license, displayOpts)
+				}
+			}
+
+			// Skip plugins that already exist, unless --reinstall was passed.  Note that by default we accept plugins
+			// with >= constraints, unless --exact was passed which requires ==.
+			var pending []workspace.PluginInfo
 			for _, install := range installs {
 				label := fmt.Sprintf("[%s plugin %s]", install.Kind, install)
-
-				// If the plugin already exists, don't download it unless --reinstall was passed.  Note that
-				// by default we accept plugins with >= constraints, unless --exact was passed which requires ==.
 				if !reinstall {
-					if exact {
+					// Variants are only ever matched exactly, since lookups of the release build don't see them. Nor are
+					// versions resolved from a range, since newer versions may be outside it.
+					if exact || install.Variant != "" || install.VersionRange != "" {
 						if workspace.HasPlugin(install) {
 							logging.V(1).Infof("%s skipping install (existing == match)", label)
 							continue
@@ -129,34 +204,91 @@ func newPluginInstallCmd() *cobra.Command {
 						}
 					}
 				}
+				pending = append(pending, install)
+			}
+
+			batchOpts := workspace.BatchInstallOptions{Concurrency: concurrency}
+			parallelism, err := batchOpts.GetConcurrency()
+			if err != nil {
+				return err
+			}
+			// Progress bars can't share the terminal, so they're only shown when plugins are installed one at a time.
+			progress := parallelism == 1 || len(pending) == 1
+
+			// Now for each kind, name, version pair, download it from the release website, and install it.
+			var lock sync.Mutex
+			var skipped []string
+			var aborted error
+			err = workspace.InstallPluginBatch(pending, batchOpts, func(install workspace.PluginInfo) error {
+				label := fmt.Sprintf("[%s plugin %s]", install.Kind, install)
+				lock.Lock()
+				abort := aborted
+				lock.Unlock()
+				if abort != nil {
+					return workspace.ErrInstallSkipped
+				}
 
 				cmdutil.Diag().Infoerrf(
 					diag.Message("", "%s installing"), label)
 
+				if file != "" {
+					logging.V(1).Infof("%s installing tarball from %s", label, file)
+					err := install.InstallFromFile(file, reinstall, workspace.DefaultPluginInstallProgress())
+					if err != nil {
+						return workspace.WithPluginErrorHelp(install, fmt.Errorf("installing %s from %s: %w", label, file, err))
+					}
+					return nil
+				}
+
 				// If we got here, actually try to do the download.
-				var source string
-				var tarball io.ReadCloser
-				var err error
-				if file == "" {
-					var size int64
-					if tarball, size, err = install.Download(); err != nil {
-						return fmt.Errorf("%s downloading from %s: %w", label, install.PluginDownloadURL, err)
+				tarball, size, err := downloadPlugin(install, label, displayOpts)
+				if err != nil {
+					// Carry on with the rest of the plugins, unless the user chose to abort.
+					var abortErr *abortedPluginInstallError
+					if errors.As(err, &abortErr) {
+						lock.Lock()
+						aborted = err
+						lock.Unlock()
 					}
+					return err
+				}
+				if tarball == nil {
+					lock.Lock()
+					skipped = append(skipped, label)
+					lock.Unlock()
+					return workspace.ErrInstallSkipped
+				}
+				if progress {
 					tarball = workspace.ReadCloserProgressBar(tarball, size, "Downloading plugin", displayOpts.Color)
-				} else {
-					source = file
-					logging.V(1).Infof("%s opening tarball from %s", label, file)
-					if tarball, err = os.Open(file); err != nil {
-						return fmt.Errorf("opening file %s: %w", source, err)
+				}
+
+				// Downloads that turn out to be corrupt are downloaded again.
+				redownload := func() (io.ReadCloser, error) {
+					tarball, size, err := install.Download()
+					if err != nil || !progress {
+						return tarball, err
 					}
+					return workspace.ReadCloserProgressBar(tarball, size, "Downloading plugin", displayOpts.Color), nil
 				}
 				logging.V(1).Infof("%s installing tarball ...", label)
-				if err = install.Install(tarball, reinstall); err != nil {
-					return fmt.Errorf("installing %s from %s: %w", label, source, err)
+				err = install.InstallWithRedownload(tarball, redownload, reinstall, workspace.DefaultPluginInstallProgress())
+				if err != nil {
+					return workspace.WithPluginErrorHelp(install, fmt.Errorf("installing %s: %w", label, err))
 				}
-			}
+				return nil
+			})
 
-			return nil
+			if len(skipped) > 0 {
+				cmdutil.Diag().Warningf(diag.Message("", "skipped installing %s"), strings.Join(skipped, ", "))
+			}
+			// A lone plugin, or an aborted batch, reports its own error rather than the batch's.
+			var batchErr *workspace.BatchInstallError
+			if aborted != nil {
+				return aborted
+			} else if errors.As(err, &batchErr) && len(pending) == 1 {
+				return batchErr.Failed[0].Err
+			}
+			return err
 		}),
 	}
 
@@ -166,8 +298,170 @@ func newPluginInstallCmd() *cobra.Command {
 		"exact", false, "Force installation of an exact version match (usually >= is accepted)")
 	cmd.PersistentFlags().StringVarP(&file,
 		"file", "f", "", "Install a plugin from a tarball file, instead of downloading it")
+	cmd.PersistentFlags().StringVar(&variant,
+		"variant", "", "Install the tarball given with --file as an alternate build of the plugin, such as \"debug\"")
 	cmd.PersistentFlags().BoolVar(&reinstall,
 		"reinstall", false, "Reinstall a plugin even if it already exists")
+	cmd.PersistentFlags().IntVar(&concurrency,
+		"concurrency", 0, "The most plugins to download and install at once; defaults to "+
+			"PULUMI_PLUGIN_INSTALL_CONCURRENCY, or a number based on the CPUs of this machine")
+	cmd.PersistentFlags().BoolVar(&dryRun,
+		"dry-run", false, "Print the URLs the plugins would be downloaded from, without downloading them")
+	cmd.PersistentFlags().StringVar(&bundlePath,
+		"bundle", "", "Install the plugins listed in a bundle manifest, from a file or URL")
 
 	return cmd
 }
+
+// printPluginDownloadURLs prints the URLs each plugin would be requested from to download it for this machine, or to
+// look up its latest version if it doesn't have one, without sending any requests.
+func printPluginDownloadURLs(installs []workspace.PluginInfo) error {
+	platform := workspace.HostPlatform()
+	for _, install := range installs {
+		var urls []string
+		var err error
+		if install.Version == nil {
+			fmt.Printf("%s plugin %s (latest version lookup):\n", install.Kind, install.Name)
+			urls, err = workspace.PluginLatestVersionURLs(install)
+		} else {
+			fmt.Printf("%s plugin %s:\n", install.Kind, install)
+			urls, err = workspace.PluginDownloadURLs(install, platform)
+		}
+		if err != nil {
+			return err
+		}
+		for _, url := range urls {
+			fmt.Printf("  %s\n", url)
+		}
+	}
+	return nil
+}
+
+// The choices offered when a plugin download fails in an interactive session.
+const (
+	retryPluginDownloadChoice = "Retry the download"
+	nextPluginMirrorChoice    = "Try the next mirror"
+	skipPluginChoice          = "Skip this plugin"
+	abortPluginInstallChoice  = "Abort"
+)
+
+// downloadPlugin downloads the given plugin. If the download fails in an interactive session, the user may retry it,
+// try the plugin's next mirror, or skip the plugin instead of aborting the install; otherwise the error is returned.
+// The returned reader is nil if the plugin was skipped.
+func downloadPlugin(install workspace.PluginInfo, label string, opts display.Options) (io.ReadCloser, int64, error) {
+	mirrors := install.Mirrors()
+	skip := 0
+	for {
+		tarball, size, err := install.DownloadFromMirror(skip)
+		if err == nil {
+			return tarball, size, nil
+		}
+		err = workspace.WithPluginErrorHelp(install,
+			fmt.Errorf("%s downloading from %s: %w", label, install.PluginDownloadURL, err))
+		if !cmdutil.Interactive() {
+			return nil, -1, err
+		}
+
+		next := ""
+		if skip < len(mirrors) {
+			next = "the default plugin source"
+			if skip+1 < len(mirrors) {
+				next = mirrors[skip+1]
+			}
+		}
+		choice, promptErr := promptForPluginDownloadFailure(label, err, next, opts)
+		if promptErr != nil {
+			return nil, -1, &abortedPluginInstallError{err: err}
+		}
+		switch choice {
+		case retryPluginDownloadChoice:
+			logging.V(1).Infof("%s retrying download", label)
+		case nextPluginMirrorChoice:
+			skip++
+			logging.V(1).Infof("%s retrying download from %s", label, next)
+		case skipPluginChoice:
+			return nil, -1, nil
+		default:
+			return nil, -1, &abortedPluginInstallError{err: err}
+		}
+	}
+}
+
+// abortedPluginInstallError is returned when the user aborts an install after a plugin download fails.
+type abortedPluginInstallError struct {
+	err error
+}
+
+func (err *abortedPluginInstallError) Error() string {
+	return err.err.Error()
+}
+
+func (err *abortedPluginInstallError) Unwrap() error {
+	return err.err
+}
+
+// pluginPromptLock keeps the prompts of plugins installed concurrently from running at the same time.
+var pluginPromptLock sync.Mutex
+
+// promptForPluginDownloadFailure reports a failed plugin download and asks the user what to do about it. The next
+// mirror is only offered if next, its name, is set.
+func promptForPluginDownloadFailure(label string, downloadErr error, next string,
+	opts display.Options) (string, error) {
+	pluginPromptLock.Lock()
+	defer pluginPromptLock.Unlock()
+
+	surveycore.DisableColor = true
+	surveycore.QuestionIcon = ""
+	surveycore.SelectFocusIcon = opts.Color.Colorize(colors.BrightGreen + ">" + colors.Reset)
+
+	cmdutil.Diag().Errorf(diag.RawMessage("", downloadErr.Error()))
+
+	options := []string{retryPluginDownloadChoice}
+	if next != "" {
+		options = append(options, fmt.Sprintf("%s (%s)", nextPluginMirrorChoice, next))
+	}
+	options = append(options, skipPluginChoice, abortPluginInstallChoice)
+
+	prompt := label + " download failed. What would you like to do?"
+
+	var option string
+	cmdutil.EndKeypadTransmitMode()
+	if err := survey.AskOne(&survey.Select{
+		Message:  opts.Color.Colorize(colors.SpecPrompt + prompt + colors.Reset),
+		Options:  options,
+		PageSize: len(options),
+		Default:  retryPluginDownloadChoice,
+	}, &option, nil); err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(option, nextPluginMirrorChoice) {
+		return nextPluginMirrorChoice, nil
+	}
+	return option, nil
+}
+
+// promptForPluginLicense asks the user whether they accept the license of the given plugin.
+func promptForPluginLicense(info workspace.PluginInfo, license workspace.PluginLicense,
+	opts display.Options) (bool, error) {
+	pluginPromptLock.Lock()
+	defer pluginPromptLock.Unlock()
+
+	surveycore.DisableColor = true
+	surveycore.QuestionIcon = ""
+	surveycore.SelectFocusIcon = opts.Color.Colorize(colors.BrightGreen + ">" + colors.Reset)
+
+	name := license.Name
+	if license.URL != "" {
+		name = fmt.Sprintf("%s (%s)", name, license.URL)
+	}
+	prompt := fmt.Sprintf("%s plugin %s is distributed under %s. Do you accept its terms?", info.Kind, info, name)
+
+	accepted := false
+	cmdutil.EndKeypadTransmitMode()
+	if err := survey.AskOne(&survey.Confirm{
+		Message: opts.Color.Colorize(colors.SpecPrompt + prompt + colors.Reset),
+	}, &accepted, nil); err != nil {
+		return false, err
+	}
+	return accepted, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+func newPluginSearchReportCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "search-report",
+		Short: "List the plugins of the current project that legacy plugin search resolves differently",
+		Long: "List the plugins of the current project that legacy plugin search resolves differently.\n" +
+			"\n" +
+			"Legacy plugin search, enabled by the deprecated PULUMI_ENABLE_LEGACY_PLUGIN_SEARCH,\n" +
+			"uses the newest installed version of a plugin at or above the version the project\n" +
+			"requests, rather than that version. It will be removed. This command lists the\n" +
+			"plugins of the current project that would resolve to another version without it,\n" +
+			"so you can install the versions the project requests before you stop using it.",
+		Args: cmdutil.NoArgs,
+		Run: cmdutil.RunFunc(func(cmd *cobra.Command, args []string) error {
+			plugins, err := getProjectPlugins()
+			if err != nil {
+				return fmt.Errorf("loading project plugins: %w", err)
+			}
+			differences, err := workspace.ReportLegacyPluginSearch(plugins)
+			if err != nil {
+				return err
+			}
+			if len(differences) == 0 {
+				fmt.Println("Every plugin of the project resolves the same way without legacy plugin search.")
+				return nil
+			}
+
+			rows := []cmdutil.TableRow{}
+			missing := false
+			for _, d := range differences {
+				modern := "not installed"
+				if d.Modern != nil {
+					modern = d.Modern.Version.String()
+				} else {
+					missing = true
+				}
+				rows = append(rows, cmdutil.TableRow{
+					Columns: []string{d.Plugin.Name, string(d.Plugin.Kind), d.Plugin.Version.String(),
+						d.Legacy.Version.String(), modern},
+				})
+			}
+			cmdutil.PrintTable(cmdutil.Table{
+				Headers: []string{"NAME", "KIND", "REQUESTED", "LEGACY", "WITHOUT LEGACY"},
+				Rows:    rows,
+			})
+			if missing {
+				fmt.Printf("\n")
+				fmt.Printf("Install the versions that aren't installed with `pulumi plugin install` before you stop " +
+					"using legacy plugin search.\n")
+			}
+			return nil
+		}),
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+func newPluginShareCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "share",
+		Short: "Share downloaded plugins with other CLIs on the local network",
+		Long: "Share downloaded plugins with other CLIs on the local network.\n" +
+			"\n" +
+			"This command serves the plugin tarballs kept in the plugin cache to other Pulumi\n" +
+			"CLIs on the local network that have PULUMI_PLUGIN_PEER_CACHE=true set, until it\n" +
+			"is interrupted. Those CLIs only use a shared tarball if it matches the digest\n" +
+			"published by the plugin's source. Tarballs are kept when plugins are downloaded\n" +
+			"with PULUMI_PLUGIN_PEER_CACHE or PULUMI_PLUGIN_DELTA_UPDATES set.",
+		Args: cmdutil.NoArgs,
+		Run: cmdutil.RunFunc(func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithCancel(commandContext())
+			defer cancel()
+
+			sigint := make(chan os.Signal, 1)
+			signal.Notify(sigint, os.Interrupt)
+			defer signal.Stop(sigint)
+			go func() {
+				select {
+				case <-sigint:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			fmt.Println("Sharing plugins on the local network; press ^C to stop.")
+			return workspace.ServePluginPeerCache(ctx)
+		}),
+	}
+}
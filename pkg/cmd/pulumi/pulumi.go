@@ -139,6 +139,15 @@ func NewPulumiCmd() *cobra.Command {
 				}()
 			}
 
+			// Move the plugins bundled with the CLI into the plugin cache, so they're versioned and garbage collected
+			// like any other plugin. This only happens the first time each version of the CLI runs. Developer builds
+			// keep using the plugins next to them.
+			if curVer, err := semver.ParseTolerant(version.Version); err == nil && !isDevVersion(curVer) {
+				if _, err := workspace.MigrateBundledPlugins(curVer); err != nil {
+					logging.V(5).Infof("could not migrate bundled plugins: %v", err)
+				}
+			}
+
 			return nil
 		}),
 		PersistentPostRun: func(cmd *cobra.Command, args []string) {
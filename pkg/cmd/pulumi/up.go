@@ -29,11 +29,13 @@ import (
 	"github.com/pulumi/pulumi/pkg/v3/engine"
 	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
 	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/result"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
 )
@@ -446,6 +448,10 @@ func newUpCmd() *cobra.Command {
 				opts.Display.SuppressPermalink = true
 			}
 
+			if !jsonDisplay {
+				warnAmbientPluginConflicts()
+			}
+
 			if len(args) > 0 {
 				return upTemplateNameOrURL(args[0], opts)
 			}
@@ -576,6 +582,19 @@ func newUpCmd() *cobra.Command {
 	return cmd
 }
 
+// warnAmbientPluginConflicts warns about plugins on $PATH that will be used by the update instead of newer versions in
+// the plugin cache.
+func warnAmbientPluginConflicts() {
+	conflicts, err := workspace.GetAmbientPluginConflicts()
+	if err != nil {
+		logging.V(5).Infof("could not check for ambient plugin conflicts: %v", err)
+		return
+	}
+	for _, conflict := range conflicts {
+		cmdutil.Diag().Warningf(diag.RawMessage("" /*urn*/, conflict.Message()))
+	}
+}
+
 // validatePolicyPackConfig validates the `--policy-pack-config` and `--policy-pack` flags. These two flags are
 // order-dependent, e.g., the first `--policy-pack-config` flag value corresponds to the first `--policy-pack`
 // flag value, and so on for the second, third, etc. An error is returned if `--policy-pack-config` is specified
@@ -169,8 +169,8 @@ func newDeployment(ctx *Context, info *deploymentContext, opts deploymentOptions
 		depl, err = deploy.NewDeployment(
 			plugctx, target, target.Snapshot, opts.Plan, source, localPolicyPackPaths, dryRun, ctx.BackendClient)
 	} else {
-		_, defaultProviderInfo, pluginErr := installPlugins(proj, pwd, main, target, plugctx,
-			false /*returnInstallErrors*/)
+		_, defaultProviderInfo, pluginErr := installPlugins(opts.pluginAcquirer(), proj, pwd, main, target,
+			plugctx, false /*returnInstallErrors*/)
 		if pluginErr != nil {
 			return nil, pluginErr
 		}
@@ -71,9 +71,12 @@ func newDestroySource(
 	}
 
 	// Like Update, if we're missing plugins, attempt to download the missing plugins.
-	if err := ensurePluginsAreInstalled(plugins); err != nil {
+	if err := ensurePluginsAreInstalled(opts.pluginAcquirer(), plugins); err != nil {
 		logging.V(7).Infof("newDestroySource(): failed to install missing plugins: %v", err)
 	}
+	if err := preflightPlugins(opts, target); err != nil {
+		return nil, err
+	}
 
 	// We don't need the language plugin, since destroy doesn't run code, so we will leave that out.
 	if err := ensurePluginsAreLoaded(plugctx, plugins, plugin.AnalyzerPlugins); err != nil {
@@ -15,14 +15,16 @@
 package engine
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"sort"
-
-	"golang.org/x/sync/errgroup"
+	"strings"
 
 	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
 	"github.com/pulumi/pulumi/pkg/v3/resource/deploy/providers"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/plugin"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
@@ -98,63 +100,219 @@ func gatherPluginsFromProgram(plugctx *plugin.Context, prog plugin.ProgInfo) (pl
 // and the plugins specified in the deployment manifest.
 func gatherPluginsFromSnapshot(plugctx *plugin.Context, target *deploy.Target) (pluginSet, error) {
 	logging.V(preparePluginLog).Infof("gatherPluginsFromSnapshot(): gathering plugins from snapshot")
-	set := newPluginSet()
 	if target == nil || target.Snapshot == nil {
 		logging.V(preparePluginLog).Infof("gatherPluginsFromSnapshot(): no snapshot available, skipping")
-		return set, nil
+		return newPluginSet(), nil
+	}
+	return gatherPluginsFromSnapshotResources(target.Snapshot)
+}
+
+// gatherPluginsFromSnapshotResources returns the set of plugins required by the first-class providers saved in the
+// snapshot.
+func gatherPluginsFromSnapshotResources(snap *deploy.Snapshot) (pluginSet, error) {
+	set := newPluginSet()
+	for _, res := range snap.Resources {
+		if err := addProviderPlugin(set, res.URN, res.Inputs); err != nil {
+			return set, err
+		}
 	}
-	for _, res := range target.Snapshot.Resources {
-		urn := res.URN
-		if !providers.IsProviderType(urn.Type()) {
-			logging.V(preparePluginVerboseLog).Infof("gatherPluginsFromSnapshot(): skipping %q, not a provider", urn)
+	return set, nil
+}
+
+// gatherPluginsFromPlan returns the set of plugins required by the first-class providers the plan expects to
+// register.
+func gatherPluginsFromPlan(plan *deploy.Plan) (pluginSet, error) {
+	logging.V(preparePluginLog).Infof("gatherPluginsFromPlan(): gathering plugins from plan")
+	set := newPluginSet()
+	for urn, rp := range plan.ResourcePlans {
+		if rp.Goal == nil {
 			continue
 		}
-		pkg := providers.GetProviderPackage(urn.Type())
-		version, err := providers.GetProviderVersion(res.Inputs)
-		if err != nil {
+		if err := addProviderPlugin(set, urn, rp.Goal.CheckedInputs); err != nil {
 			return set, err
 		}
-		downloadURL, err := providers.GetProviderDownloadURL(res.Inputs)
+	}
+	return set, nil
+}
+
+// addProviderPlugin adds the plugin required by the first-class provider with the given URN and inputs to set. Other
+// resources are skipped.
+func addProviderPlugin(set pluginSet, urn resource.URN, inputs resource.PropertyMap) error {
+	if !providers.IsProviderType(urn.Type()) {
+		logging.V(preparePluginVerboseLog).Infof("addProviderPlugin(): skipping %q, not a provider", urn)
+		return nil
+	}
+	pkg := providers.GetProviderPackage(urn.Type())
+	version, err := providers.GetProviderVersion(inputs)
+	if err != nil {
+		return err
+	}
+	downloadURL, err := providers.GetProviderDownloadURL(inputs)
+	if err != nil {
+		return err
+	}
+	logging.V(preparePluginLog).Infof(
+		"addProviderPlugin(): plugin %s %s is required by first-class provider %q", pkg, version, urn)
+	set.Add(workspace.PluginInfo{
+		Name:              pkg.String(),
+		Kind:              workspace.ResourcePlugin,
+		Version:           version,
+		PluginDownloadURL: downloadURL,
+	})
+	return nil
+}
+
+// PluginRequirements describes the plugins required by the providers of a deployment, and which of them are
+// available.
+type PluginRequirements struct {
+	// Present are the required plugins that are available.
+	Present []workspace.PluginInfo
+	// Missing are the required plugins that aren't available.
+	Missing []workspace.PluginInfo
+	// DefaultProviders are the plugins that would be selected for default providers, by package.
+	DefaultProviders map[tokens.Package]workspace.PluginInfo
+}
+
+// GetPluginRequirements returns the plugins required by the first-class providers referenced by the snapshot and the
+// plan, either of which may be nil, and which of them the acquirer has available. A nil acquirer means
+// DefaultPluginAcquirer. Missing plugins aren't installed.
+func GetPluginRequirements(acquirer PluginAcquirer, snap *deploy.Snapshot,
+	plan *deploy.Plan) (*PluginRequirements, error) {
+	if acquirer == nil {
+		acquirer = DefaultPluginAcquirer
+	}
+
+	plugins := newPluginSet()
+	if snap != nil {
+		snapshotPlugins, err := gatherPluginsFromSnapshotResources(snap)
+		if err != nil {
+			return nil, err
+		}
+		plugins = plugins.Union(snapshotPlugins)
+	}
+	if plan != nil {
+		planPlugins, err := gatherPluginsFromPlan(plan)
 		if err != nil {
-			return set, err
+			return nil, err
 		}
-		logging.V(preparePluginLog).Infof(
-			"gatherPluginsFromSnapshot(): plugin %s %s is required by first-class provider %q", pkg, version, urn)
-		set.Add(workspace.PluginInfo{
-			Name:              pkg.String(),
-			Kind:              workspace.ResourcePlugin,
-			Version:           version,
-			PluginDownloadURL: downloadURL,
-		})
+		plugins = plugins.Union(planPlugins)
 	}
-	return set, nil
+
+	reqs := &PluginRequirements{DefaultProviders: computeDefaultProviderPlugins(newPluginSet(), plugins)}
+	for _, plug := range plugins.Values() {
+		if path, err := acquirer.GetPluginPath(plug); err == nil && path != "" {
+			reqs.Present = append(reqs.Present, plug)
+		} else {
+			reqs.Missing = append(reqs.Missing, plug)
+		}
+	}
+	sort.Sort(workspace.SortedPluginInfo(reqs.Present))
+	sort.Sort(workspace.SortedPluginInfo(reqs.Missing))
+	return reqs, nil
+}
+
+// Check returns a *MissingPluginsError if any of the required plugins are missing.
+func (reqs *PluginRequirements) Check() error {
+	if len(reqs.Missing) == 0 {
+		return nil
+	}
+	return &MissingPluginsError{Missing: reqs.Missing}
+}
+
+// MissingPluginsError is returned by a plugin preflight check when plugins the deployment requires are missing.
+type MissingPluginsError struct {
+	// Missing are the plugins that are missing.
+	Missing []workspace.PluginInfo
+}
+
+func (err *MissingPluginsError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d required plugin(s) are missing:", len(err.Missing))
+	for _, plug := range err.Missing {
+		fmt.Fprintf(&b, "\n  - %s plugin %s", plug.Kind, plug)
+	}
+	return b.String()
+}
+
+// Is matches workspace.ErrNotFound.
+func (err *MissingPluginsError) Is(target error) bool {
+	return target == workspace.ErrNotFound
+}
+
+// preflightPlugins fails if the options request a plugin preflight check and plugins required by the target's
+// snapshot or the plan are missing.
+func preflightPlugins(opts deploymentOptions, target *deploy.Target) error {
+	if !opts.PluginPreflight {
+		return nil
+	}
+	var snap *deploy.Snapshot
+	if target != nil {
+		snap = target.Snapshot
+	}
+	reqs, err := GetPluginRequirements(opts.pluginAcquirer(), snap, opts.Plan)
+	if err != nil {
+		return err
+	}
+	return reqs.Check()
+}
+
+// PluginAcquirer acquires the plugins a deployment needs before the plugin host loads them. The engine uses
+// DefaultPluginAcquirer unless UpdateOptions sets another, which lets embedders acquire plugins differently, for
+// example from a pre-provisioned read-only cache or through a remote execution agent.
+type PluginAcquirer interface {
+	// ResolvePlugin returns the plugin with its version filled in, if it was left unspecified.
+	ResolvePlugin(info workspace.PluginInfo) (workspace.PluginInfo, error)
+	// EnsurePluginInstalled installs the resolved plugin, unless it is already available.
+	EnsurePluginInstalled(info workspace.PluginInfo) error
+	// GetPluginPath returns the path of the plugin, or "" if it isn't available. A nil version matches any version.
+	GetPluginPath(info workspace.PluginInfo) (string, error)
+}
+
+// DefaultPluginAcquirer acquires plugins the way the CLI does: it looks for them in the workspace and downloads any
+// that are missing from their plugin download URL, showing the download's progress.
+var DefaultPluginAcquirer PluginAcquirer = workspacePluginAcquirer{}
+
+// pluginAcquirer returns the PluginAcquirer the options select.
+func (opts UpdateOptions) pluginAcquirer() PluginAcquirer {
+	if opts.PluginAcquirer != nil {
+		return opts.PluginAcquirer
+	}
+	return DefaultPluginAcquirer
 }
 
 // ensurePluginsAreInstalled inspects all plugins in the plugin set and, if any plugins are not currently installed,
-// uses the given backend client to install them. Installations are processed in parallel, though
+// uses the given acquirer to install them. Installations are processed in parallel, though
 // ensurePluginsAreInstalled does not return until all installations are completed.
-func ensurePluginsAreInstalled(plugins pluginSet) error {
+func ensurePluginsAreInstalled(acquirer PluginAcquirer, plugins pluginSet) error {
 	logging.V(preparePluginLog).Infof("ensurePluginsAreInstalled(): beginning")
-	var installTasks errgroup.Group
+	var installs []workspace.PluginInfo
 	for _, plug := range plugins.Values() {
-		_, path, err := workspace.GetPluginPath(plug.Kind, plug.Name, plug.Version)
+		path, err := acquirer.GetPluginPath(plug)
 		if err == nil && path != "" {
 			logging.V(preparePluginLog).Infof(
 				"ensurePluginsAreInstalled(): plugin %s %s already installed", plug.Name, plug.Version)
 			continue
 		}
-
-		// Launch an install task asynchronously and add it to the current error group.
-		info := plug // don't close over the loop induction variable
-		installTasks.Go(func() error {
-			logging.V(preparePluginLog).Infof(
-				"ensurePluginsAreInstalled(): plugin %s %s not installed, doing install", info.Name, info.Version)
-			return installPlugin(info)
-		})
+		installs = append(installs, plug)
 	}
 
-	err := installTasks.Wait()
+	// Install the missing plugins concurrently. A failed install doesn't stop the others, so the error reports every
+	// plugin that needs attention at once.
+	err := workspace.InstallPluginBatch(installs, workspace.BatchInstallOptions{}, func(info workspace.PluginInfo) error {
+		logging.V(preparePluginLog).Infof(
+			"ensurePluginsAreInstalled(): plugin %s %s not installed, doing install", info.Name, info.Version)
+		resolved, err := acquirer.ResolvePlugin(info)
+		if err != nil {
+			return err
+		}
+		return acquirer.EnsurePluginInstalled(resolved)
+	})
 	logging.V(preparePluginLog).Infof("ensurePluginsAreInstalled(): completed")
+
+	var batchErr *workspace.BatchInstallError
+	if errors.As(err, &batchErr) && len(batchErr.Failed) == 1 && len(batchErr.Installed) == 0 {
+		return batchErr.Failed[0].Err
+	}
 	return err
 }
 
@@ -164,46 +322,66 @@ func ensurePluginsAreLoaded(plugctx *plugin.Context, plugins pluginSet, kinds pl
 	return plugctx.Host.EnsurePlugins(plugins.Values(), kinds)
 }
 
-// installPlugin installs a plugin from the given backend client.
-func installPlugin(plugin workspace.PluginInfo) error {
-	logging.V(preparePluginLog).Infof("installPlugin(%s, %s): beginning install", plugin.Name, plugin.Version)
-	if plugin.Kind == workspace.LanguagePlugin {
-		logging.V(preparePluginLog).Infof(
-			"installPlugin(%s, %s): is a language plugin, skipping install", plugin.Name, plugin.Version)
-		return nil
+// workspacePluginAcquirer is the DefaultPluginAcquirer.
+type workspacePluginAcquirer struct{}
+
+func (workspacePluginAcquirer) GetPluginPath(info workspace.PluginInfo) (string, error) {
+	_, path, err := workspace.GetPluginPath(info.Kind, info.Name, info.Version)
+	return path, err
+}
+
+func (workspacePluginAcquirer) ResolvePlugin(plugin workspace.PluginInfo) (workspace.PluginInfo, error) {
+	// Language plugins are bundled with the CLI, so there's nothing to resolve.
+	if plugin.Kind == workspace.LanguagePlugin || plugin.Version != nil {
+		return plugin, nil
 	}
 
 	// If we don't have a version yet try and call GetLatestVersion to fill it in
-	if plugin.Version == nil {
-		logging.V(preparePluginVerboseLog).Infof(
-			"installPlugin(%s): version not specified, trying to lookup latest version", plugin.Name)
+	logging.V(preparePluginVerboseLog).Infof(
+		"ResolvePlugin(%s): version not specified, trying to lookup latest version", plugin.Name)
+	version, err := plugin.GetLatestVersion()
+	if err != nil {
+		return plugin, fmt.Errorf("could not get latest version for plugin %s: %w", plugin.Name, err)
+	}
+	plugin.Version = version
+	return plugin, nil
+}
 
-		version, err := plugin.GetLatestVersion()
-		if err != nil {
-			return fmt.Errorf("could not get latest version for plugin %s: %w", plugin.Name, err)
-		}
-		plugin.Version = version
+func (workspacePluginAcquirer) EnsurePluginInstalled(plugin workspace.PluginInfo) error {
+	logging.V(preparePluginLog).Infof("EnsurePluginInstalled(%s, %s): beginning install", plugin.Name, plugin.Version)
+	if plugin.Kind == workspace.LanguagePlugin {
+		logging.V(preparePluginLog).Infof(
+			"EnsurePluginInstalled(%s, %s): is a language plugin, skipping install", plugin.Name, plugin.Version)
+		return nil
 	}
+	contract.Requiref(plugin.Version != nil, "plugin", "version must be resolved")
 
 	logging.V(preparePluginVerboseLog).Infof(
-		"installPlugin(%s, %s): initiating download", plugin.Name, plugin.Version)
+		"EnsurePluginInstalled(%s, %s): initiating download", plugin.Name, plugin.Version)
 	stream, size, err := plugin.Download()
 	if err != nil {
-		return err
+		return workspace.WithPluginErrorHelp(plugin, err)
 	}
 
 	fmt.Fprintf(os.Stderr, "[%s plugin %s-%s] installing\n", plugin.Kind, plugin.Name, plugin.Version)
 	stream = workspace.ReadCloserProgressBar(stream, size, "Downloading plugin", cmdutil.GetGlobalColorization())
 
 	logging.V(preparePluginVerboseLog).Infof(
-		"installPlugin(%s, %s): extracting tarball to installation directory", plugin.Name, plugin.Version)
-	if err := plugin.Install(stream, false); err != nil {
-		return fmt.Errorf("installing plugin; run `pulumi plugin install %s %s v%s` to retry manually: %w",
-			plugin.Kind, plugin.Name, plugin.Version, err)
-
+		"EnsurePluginInstalled(%s, %s): extracting tarball to installation directory", plugin.Name, plugin.Version)
+	redownload := func() (io.ReadCloser, error) {
+		stream, size, err := plugin.Download()
+		if err != nil {
+			return nil, err
+		}
+		return workspace.ReadCloserProgressBar(
+			stream, size, "Downloading plugin", cmdutil.GetGlobalColorization()), nil
+	}
+	err = plugin.InstallWithRedownload(stream, redownload, false, workspace.DefaultPluginInstallProgress())
+	if err != nil {
+		return workspace.WithPluginErrorHelp(plugin, fmt.Errorf("installing plugin: %w", err))
 	}
 
-	logging.V(7).Infof("installPlugin(%s, %s): successfully installed", plugin.Name, plugin.Version)
+	logging.V(7).Infof("EnsurePluginInstalled(%s, %s): successfully installed", plugin.Name, plugin.Version)
 	return nil
 }
 
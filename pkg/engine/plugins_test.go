@@ -15,11 +15,16 @@
 package engine
 
 import (
+	"errors"
+	"sync"
 	"testing"
 
 	"github.com/blang/semver"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
 )
@@ -161,3 +166,122 @@ func TestDefaultProviderPluginsSorting(t *testing.T) {
 		"foo": plugin2,
 	}, result)
 }
+
+type testPluginAcquirer struct {
+	m         sync.Mutex
+	installed map[string]*semver.Version
+	resolved  []string
+}
+
+func (a *testPluginAcquirer) ResolvePlugin(info workspace.PluginInfo) (workspace.PluginInfo, error) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.resolved = append(a.resolved, info.Name)
+	if info.Version == nil {
+		info.Version = mustMakeVersion("2.0.0")
+	}
+	return info, nil
+}
+
+func (a *testPluginAcquirer) EnsurePluginInstalled(info workspace.PluginInfo) error {
+	a.m.Lock()
+	defer a.m.Unlock()
+	if info.Name == "broken" {
+		return errors.New("no such plugin")
+	}
+	a.installed[info.Name] = info.Version
+	return nil
+}
+
+func (a *testPluginAcquirer) GetPluginPath(info workspace.PluginInfo) (string, error) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	if version, has := a.installed[info.Name]; has && (info.Version == nil || info.Version.EQ(*version)) {
+		return "/plugins/" + info.Name, nil
+	}
+	return "", nil
+}
+
+func TestEnsurePluginsAreInstalledUsesAcquirer(t *testing.T) {
+	t.Parallel()
+
+	acquirer := &testPluginAcquirer{installed: map[string]*semver.Version{"aws": mustMakeVersion("1.0.0")}}
+	plugins := newPluginSet()
+	plugins.Add(workspace.PluginInfo{Name: "aws", Kind: workspace.ResourcePlugin, Version: mustMakeVersion("1.0.0")})
+	plugins.Add(workspace.PluginInfo{Name: "random", Kind: workspace.ResourcePlugin})
+	require.NoError(t, ensurePluginsAreInstalled(acquirer, plugins))
+
+	// Only the missing plugin is resolved and installed.
+	assert.Equal(t, []string{"random"}, acquirer.resolved)
+	assert.Equal(t, mustMakeVersion("2.0.0"), acquirer.installed["random"])
+
+	plugins.Add(workspace.PluginInfo{Name: "broken", Kind: workspace.ResourcePlugin})
+	acquirer.resolved = nil
+	err := ensurePluginsAreInstalled(acquirer, plugins)
+	assert.EqualError(t, err, "no such plugin")
+	assert.Equal(t, []string{"broken"}, acquirer.resolved)
+}
+
+func TestPluginAcquirerOption(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, DefaultPluginAcquirer, UpdateOptions{}.pluginAcquirer())
+	acquirer := &testPluginAcquirer{}
+	assert.Equal(t, acquirer, UpdateOptions{PluginAcquirer: acquirer}.pluginAcquirer())
+}
+
+func TestGetPluginRequirements(t *testing.T) {
+	t.Parallel()
+
+	providerURN := func(pkg string) resource.URN {
+		return resource.NewURN("stack", "proj", "", tokens.Type("pulumi:providers:"+pkg), "default")
+	}
+	snap := &deploy.Snapshot{Resources: []*resource.State{
+		{URN: providerURN("aws"), Inputs: resource.PropertyMap{"version": resource.NewStringProperty("1.0.0")}},
+		{URN: resource.NewURN("stack", "proj", "", "aws:s3/bucket:Bucket", "bucket")},
+	}}
+	plan := &deploy.Plan{ResourcePlans: map[resource.URN]*deploy.ResourcePlan{
+		providerURN("random"): {Goal: &deploy.GoalPlan{
+			CheckedInputs: resource.PropertyMap{"version": resource.NewStringProperty("2.0.0")},
+		}},
+		providerURN("aws"): {},
+	}}
+	acquirer := &testPluginAcquirer{installed: map[string]*semver.Version{"aws": mustMakeVersion("1.0.0")}}
+
+	reqs, err := GetPluginRequirements(acquirer, snap, plan)
+	require.NoError(t, err)
+	aws := workspace.PluginInfo{Name: "aws", Kind: workspace.ResourcePlugin, Version: mustMakeVersion("1.0.0")}
+	random := workspace.PluginInfo{Name: "random", Kind: workspace.ResourcePlugin, Version: mustMakeVersion("2.0.0")}
+	assert.Equal(t, []workspace.PluginInfo{aws}, reqs.Present)
+	assert.Equal(t, []workspace.PluginInfo{random}, reqs.Missing)
+	assert.Equal(t, map[tokens.Package]workspace.PluginInfo{"aws": aws, "random": random}, reqs.DefaultProviders)
+
+	err = reqs.Check()
+	var missing *MissingPluginsError
+	require.True(t, errors.As(err, &missing))
+	assert.Equal(t, reqs.Missing, missing.Missing)
+	assert.True(t, errors.Is(err, workspace.ErrNotFound))
+	assert.EqualError(t, err, "1 required plugin(s) are missing:\n  - resource plugin random-2.0.0")
+
+	acquirer.installed["random"] = mustMakeVersion("2.0.0")
+	reqs, err = GetPluginRequirements(acquirer, snap, plan)
+	require.NoError(t, err)
+	assert.Empty(t, reqs.Missing)
+	assert.NoError(t, reqs.Check())
+}
+
+func TestPreflightPlugins(t *testing.T) {
+	t.Parallel()
+
+	plan := &deploy.Plan{ResourcePlans: map[resource.URN]*deploy.ResourcePlan{
+		resource.NewURN("stack", "proj", "", "pulumi:providers:random", "default"): {Goal: &deploy.GoalPlan{}},
+	}}
+	acquirer := &testPluginAcquirer{installed: map[string]*semver.Version{}}
+
+	opts := deploymentOptions{UpdateOptions: UpdateOptions{PluginAcquirer: acquirer, Plan: plan}}
+	assert.NoError(t, preflightPlugins(opts, nil))
+
+	opts.PluginPreflight = true
+	var missing *MissingPluginsError
+	assert.True(t, errors.As(preflightPlugins(opts, nil), &missing))
+}
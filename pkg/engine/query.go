@@ -28,10 +28,11 @@ import (
 )
 
 type QueryOptions struct {
-	Events      eventEmitter // the channel to write events from the engine to.
-	Diag        diag.Sink    // the sink to use for diag'ing.
-	StatusDiag  diag.Sink    // the sink to use for diag'ing status messages.
-	host        plugin.Host  // the plugin host to use for this query.
+	Events      eventEmitter   // the channel to write events from the engine to.
+	Diag        diag.Sink      // the sink to use for diag'ing.
+	StatusDiag  diag.Sink      // the sink to use for diag'ing status messages.
+	host        plugin.Host    // the plugin host to use for this query.
+	acquirer    PluginAcquirer // the plugin acquirer to use for this query.
 	pwd, main   string
 	plugctx     *plugin.Context
 	tracingSpan opentracing.Span
@@ -82,6 +83,7 @@ func Query(ctx *Context, q QueryInfo, opts UpdateOptions) result.Result {
 		Diag:        diag,
 		StatusDiag:  statusDiag,
 		host:        opts.Host,
+		acquirer:    opts.pluginAcquirer(),
 		pwd:         pwd,
 		main:        main,
 		plugctx:     plugctx,
@@ -92,7 +94,7 @@ func Query(ctx *Context, q QueryInfo, opts UpdateOptions) result.Result {
 func newQuerySource(cancel context.Context, client deploy.BackendClient, q QueryInfo,
 	opts QueryOptions) (deploy.QuerySource, error) {
 
-	allPlugins, defaultProviderVersions, err := installPlugins(q.GetProject(), opts.pwd, opts.main,
+	allPlugins, defaultProviderVersions, err := installPlugins(opts.acquirer, q.GetProject(), opts.pwd, opts.main,
 		nil, opts.plugctx, false /*returnInstallErrors*/)
 	if err != nil {
 		return nil, err
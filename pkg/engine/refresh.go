@@ -74,9 +74,12 @@ func newRefreshSource(client deploy.BackendClient, opts deploymentOptions, proj
 	}
 
 	// Like Update, if we're missing plugins, attempt to download the missing plugins.
-	if err := ensurePluginsAreInstalled(plugins); err != nil {
+	if err := ensurePluginsAreInstalled(opts.pluginAcquirer(), plugins); err != nil {
 		logging.V(7).Infof("newRefreshSource(): failed to install missing plugins: %v", err)
 	}
+	if err := preflightPlugins(opts, target); err != nil {
+		return nil, err
+	}
 
 	// Just return an error source. Refresh doesn't use its source.
 	return deploy.NewErrorSource(proj.Name), nil
@@ -145,6 +145,13 @@ type UpdateOptions struct {
 	// the plugin host to use for this update
 	Host plugin.Host
 
+	// the plugin acquirer to use for this update, or nil to use DefaultPluginAcquirer
+	PluginAcquirer PluginAcquirer
+
+	// true if the deployment should fail before any resource operations start when plugins required by the
+	// snapshot or the plan are still missing after installing them.
+	PluginPreflight bool
+
 	// The plan to use for the update, if any.
 	Plan *deploy.Plan
 
@@ -204,11 +211,11 @@ func Update(u UpdateInfo, ctx *Context, opts UpdateOptions, dryRun bool) (
 // RunInstallPlugins calls installPlugins and just returns the error (avoids having to export pluginSet).
 func RunInstallPlugins(
 	proj *workspace.Project, pwd, main string, target *deploy.Target, plugctx *plugin.Context) error {
-	_, _, err := installPlugins(proj, pwd, main, target, plugctx, true /*returnInstallErrors*/)
+	_, _, err := installPlugins(DefaultPluginAcquirer, proj, pwd, main, target, plugctx, true /*returnInstallErrors*/)
 	return err
 }
 
-func installPlugins(
+func installPlugins(acquirer PluginAcquirer,
 	proj *workspace.Project, pwd, main string, target *deploy.Target,
 	plugctx *plugin.Context, returnInstallErrors bool) (pluginSet, map[tokens.Package]workspace.PluginInfo, error) {
 
@@ -244,7 +251,7 @@ func installPlugins(
 	// Note that this is purely a best-effort thing. If we can't install missing plugins, just proceed; we'll fail later
 	// with an error message indicating exactly what plugins are missing. If `returnInstallErrors` is set, then return
 	// the error.
-	if err := ensurePluginsAreInstalled(allPlugins); err != nil {
+	if err := ensurePluginsAreInstalled(acquirer, allPlugins); err != nil {
 		if returnInstallErrors {
 			return nil, nil, err
 		}
@@ -374,11 +381,14 @@ func newUpdateSource(
 	// Step 1: Install and load plugins.
 	//
 
-	allPlugins, defaultProviderVersions, err := installPlugins(proj, pwd, main, target,
+	allPlugins, defaultProviderVersions, err := installPlugins(opts.pluginAcquirer(), proj, pwd, main, target,
 		plugctx, false /*returnInstallErrors*/)
 	if err != nil {
 		return nil, err
 	}
+	if err := preflightPlugins(opts, target); err != nil {
+		return nil, err
+	}
 
 	// Once we've installed all of the plugins we need, make sure that all analyzers and language plugins are
 	// loaded up and ready to go. Provider plugins are loaded lazily by the provider registry and thus don't
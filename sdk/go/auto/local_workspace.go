@@ -639,6 +639,12 @@ func NewLocalWorkspace(ctx context.Context, opts ...LocalWorkspaceOption) (Works
 			return nil, errors.Wrap(err, "failed to set environment values")
 		}
 	}
+	if lwOpts.PluginUserAgent != "" {
+		l.SetEnvVar(workspace.PluginUserAgentEnvVar, lwOpts.PluginUserAgent)
+	}
+	if lwOpts.PluginAttribution != "" {
+		l.SetEnvVar(workspace.PluginAttributionEnvVar, lwOpts.PluginAttribution)
+	}
 
 	return l, nil
 }
@@ -664,6 +670,10 @@ type localWorkspaceOptions struct {
 	// EnvVars is a map of environment values scoped to the workspace.
 	// These values will be passed to all Workspace and Stack level commands.
 	EnvVars map[string]string
+	// PluginUserAgent is appended to the User-Agent of the plugin downloads of commands.
+	PluginUserAgent string
+	// PluginAttribution is sent with the plugin downloads of commands.
+	PluginAttribution string
 }
 
 // LocalWorkspaceOption is used to customize and configure a LocalWorkspace at initialization time.
@@ -783,6 +793,22 @@ func EnvVars(envvars map[string]string) LocalWorkspaceOption {
 	})
 }
 
+// PluginUserAgent is a product, such as "my-ci/2.1", appended to the User-Agent of the plugin downloads of
+// Workspace and Stack level commands, so the operators of plugin mirrors can tell which systems send them.
+func PluginUserAgent(product string) LocalWorkspaceOption {
+	return localWorkspaceOption(func(lo *localWorkspaceOptions) {
+		lo.PluginUserAgent = product
+	})
+}
+
+// PluginAttribution is sent in the X-Pulumi-Attribution header of the plugin downloads of Workspace and Stack level
+// commands, such as the team or pipeline running them.
+func PluginAttribution(attribution string) LocalWorkspaceOption {
+	return localWorkspaceOption(func(lo *localWorkspaceOptions) {
+		lo.PluginAttribution = attribution
+	})
+}
+
 // NewStackLocalSource creates a Stack backed by a LocalWorkspace created on behalf of the user,
 // from the specified WorkDir. This Workspace will pick up
 // any available Settings files (Pulumi.yaml, Pulumi.<stack>.yaml).
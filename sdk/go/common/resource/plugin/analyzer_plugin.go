@@ -54,15 +54,21 @@ var _ Analyzer = (*analyzer)(nil)
 // could not be found by name on the PATH, or an error occurs while creating the child process, an error is returned.
 func NewAnalyzer(host Host, ctx *Context, name tokens.QName) (Analyzer, error) {
 	// Load the plugin's path by using the standard workspace logic.
-	_, path, err := workspace.GetPluginPath(
-		workspace.AnalyzerPlugin, strings.Replace(string(name), tokens.QNameDelimiter, "_", -1), nil)
+	pluginName := strings.Replace(string(name), tokens.QNameDelimiter, "_", -1)
+	resolution, err := workspace.ResolvePlugin(workspace.AnalyzerPlugin, pluginName, nil)
 	if err != nil {
 		return nil, rpcerror.Convert(err)
 	}
+	path := resolution.Path
 	contract.Assert(path != "")
 
+	env, err := withPluginEnvironment(resolution, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	plug, err := newPlugin(ctx, ctx.Pwd, path, fmt.Sprintf("%v (analyzer)", name),
-		[]string{host.ServerAddr(), ctx.Pwd}, nil /*env*/)
+		[]string{host.ServerAddr(), ctx.Pwd}, env)
 	if err != nil {
 		return nil, err
 	}
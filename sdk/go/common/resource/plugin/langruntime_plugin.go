@@ -49,11 +49,12 @@ type langhost struct {
 func NewLanguageRuntime(host Host, ctx *Context, runtime string,
 	options map[string]interface{}) (LanguageRuntime, error) {
 
-	_, path, err := workspace.GetPluginPath(
-		workspace.LanguagePlugin, strings.Replace(runtime, tokens.QNameDelimiter, "_", -1), nil)
+	name := strings.Replace(runtime, tokens.QNameDelimiter, "_", -1)
+	resolution, err := workspace.ResolvePlugin(workspace.LanguagePlugin, name, nil)
 	if err != nil {
 		return nil, err
 	}
+	path := resolution.Path
 
 	contract.Assert(path != "")
 
@@ -62,7 +63,12 @@ func NewLanguageRuntime(host Host, ctx *Context, runtime string,
 		return nil, err
 	}
 
-	plug, err := newPlugin(ctx, ctx.Pwd, path, runtime, args, nil /*env*/)
+	env, err := withPluginEnvironment(resolution, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	plug, err := newPlugin(ctx, ctx.Pwd, path, runtime, args, env)
 	if err != nil {
 		return nil, err
 	}
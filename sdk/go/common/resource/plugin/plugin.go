@@ -21,6 +21,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -41,6 +42,7 @@ import (
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/rpcutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
 )
 
 // PulumiPluginJSON represents additional information about a package's associated Pulumi plugin.
@@ -172,6 +174,22 @@ func dialPlugin(port, bin, prefix string) (*grpc.ClientConn, error) {
 	return conn, nil
 }
 
+// withPluginEnvironment appends the environment variables recorded for the resolved plugin to env. If the plugin has
+// any and env is nil, they are appended to the current process's environment.
+func withPluginEnvironment(resolution *workspace.PluginResolution, env []string) ([]string, error) {
+	pluginEnv, err := resolution.Environment()
+	if err != nil {
+		return nil, err
+	}
+	if len(pluginEnv) == 0 {
+		return env, nil
+	}
+	if env == nil {
+		env = os.Environ()
+	}
+	return append(env, pluginEnv...), nil
+}
+
 func newPlugin(ctx *Context, pwd, bin, prefix string, args, env []string, options ...otgrpc.Option) (*plugin, error) {
 	if logging.V(9) {
 		var argstr string
@@ -292,6 +310,17 @@ func newPlugin(ctx *Context, pwd, bin, prefix string, args, env []string, option
 	return plug, nil
 }
 
+// pluginCommand returns the command used to launch the plugin at bin. PowerShell scripts can't be executed directly, so
+// they are launched through `powershell -File`, under the execution policy of the user and machine; every other kind
+// of plugin, including `.cmd` and `.bat` scripts on Windows, is executed as-is.
+func pluginCommand(bin string, args ...string) *exec.Cmd {
+	if strings.EqualFold(filepath.Ext(bin), ".ps1") {
+		psArgs := append([]string{"-NoProfile", "-NonInteractive", "-File", bin}, args...)
+		return exec.Command("powershell", psArgs...)
+	}
+	return exec.Command(bin, args...)
+}
+
 // execPlugin starts the plugin executable.
 func execPlugin(bin string, pluginArgs []string, pwd string, env []string) (*plugin, error) {
 	args := buildPluginArguments(pluginArgumentOptions{
@@ -302,7 +331,7 @@ func execPlugin(bin string, pluginArgs []string, pwd string, env []string) (*plu
 		logToStderr:     logging.LogToStderr,
 		verbose:         logging.Verbose,
 	})
-	cmd := exec.Command(bin, args...)
+	cmd := pluginCommand(bin, args...)
 	cmdutil.RegisterProcessGroup(cmd)
 	cmd.Dir = pwd
 	if len(env) > 0 {
@@ -55,3 +55,15 @@ func TestLogFlowArgumentPropagation(t *testing.T) {
 		tracingEndpoint: "127.0.0.1:6007",
 	}), []string{"--logtostderr", "-v=9", "--tracing", "127.0.0.1:6007", "127.0.0.1:12345"})
 }
+
+func TestPluginCommandPowerShell(t *testing.T) {
+	t.Parallel()
+
+	cmd := pluginCommand(`C:\plugins\pulumi-resource-foo.ps1`, "127.0.0.1:12345")
+	assert.Equal(t, []string{
+		"powershell", "-NoProfile", "-NonInteractive", "-File", `C:\plugins\pulumi-resource-foo.ps1`, "127.0.0.1:12345",
+	}, cmd.Args)
+
+	cmd = pluginCommand("/plugins/pulumi-resource-foo", "127.0.0.1:12345")
+	assert.Equal(t, []string{"/plugins/pulumi-resource-foo", "127.0.0.1:12345"}, cmd.Args)
+}
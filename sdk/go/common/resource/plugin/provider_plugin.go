@@ -107,11 +107,12 @@ func NewProvider(host Host, ctx *Context, pkg tokens.Package, version *semver.Ve
 		}
 	} else {
 		// Load the plugin's path by using the standard workspace logic.
-		_, path, err := workspace.GetPluginPath(
-			workspace.ResourcePlugin, strings.Replace(string(pkg), tokens.QNameDelimiter, "_", -1), version)
+		name := strings.Replace(string(pkg), tokens.QNameDelimiter, "_", -1)
+		resolution, err := workspace.ResolvePlugin(workspace.ResourcePlugin, name, version)
 		if err != nil {
 			return nil, err
 		}
+		path := resolution.Path
 
 		contract.Assert(path != "")
 
@@ -120,6 +121,9 @@ func NewProvider(host Host, ctx *Context, pkg tokens.Package, version *semver.Ve
 		for k, v := range options {
 			env = append(env, fmt.Sprintf("PULUMI_RUNTIME_%s=%v", strings.ToUpper(k), v))
 		}
+		if env, err = withPluginEnvironment(resolution, env); err != nil {
+			return nil, err
+		}
 
 		plug, err = newPlugin(ctx, ctx.Pwd, path, prefix,
 			[]string{host.ServerAddr()}, env, otgrpc.SpanDecorator(decorateProviderSpans))
@@ -0,0 +1,238 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/pgzip"
+	"github.com/pkg/errors"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// SymlinkPolicy is what extraction does with the symbolic links in an archive.
+type SymlinkPolicy int
+
+const (
+	// RejectSymlinks fails the extraction with a *SymlinkError if the archive contains a symbolic link.
+	RejectSymlinks SymlinkPolicy = iota
+	// SkipSymlinks leaves symbolic links out of the extracted files.
+	SkipSymlinks
+	// AllowContainedSymlinks extracts symbolic links whose targets are inside the directory being extracted into,
+	// and fails the extraction with a *SymlinkError for any others.
+	AllowContainedSymlinks
+)
+
+// ExtractOptions limits what is extracted from an archive. Regardless of the options, no entry is ever written outside
+// the directory being extracted into.
+type ExtractOptions struct {
+	// Symlinks is what to do with symbolic links. Defaults to RejectSymlinks.
+	Symlinks SymlinkPolicy
+	// MaxFileSize, if set, is the largest a file in the archive may be, in bytes.
+	MaxFileSize int64
+	// MaxFiles, if set, is the most entries the archive may have.
+	MaxFiles int
+	// Readahead, if set, pipelines extraction: the archive is read in a goroutine of its own, and decompressed by a
+	// parallel gzip reader, each up to this many bytes ahead of the next stage, so that downloading, decompressing and
+	// writing files to disk overlap.
+	Readahead int64
+}
+
+// UnsafePathError is returned when an archive entry's name is an absolute path, or would be extracted outside the
+// directory being extracted into.
+type UnsafePathError struct {
+	// Name is the name of the entry.
+	Name string
+}
+
+func (err *UnsafePathError) Error() string {
+	return fmt.Sprintf("archive entry %s would be extracted outside of the destination directory", err.Name)
+}
+
+// SymlinkError is returned when an archive contains a symbolic link its extraction's SymlinkPolicy doesn't allow.
+type SymlinkError struct {
+	// Name is the name of the link.
+	Name string
+	// Target is the path the link points to.
+	Target string
+}
+
+func (err *SymlinkError) Error() string {
+	return fmt.Sprintf("archive entry %s is a symbolic link to %s, which isn't allowed", err.Name, err.Target)
+}
+
+// LimitError is returned when an archive exceeds one of its extraction's limits.
+type LimitError struct {
+	// Name is the name of the entry that exceeded the limit.
+	Name string
+	// Limit is the limit that was exceeded: "file size" or "file count".
+	Limit string
+	// Max is the limit's value.
+	Max int64
+}
+
+func (err *LimitError) Error() string {
+	return fmt.Sprintf("archive entry %s exceeds the %s limit of %d", err.Name, err.Limit, err.Max)
+}
+
+// ExtractTGZ uncompresses a .tar.gz/.tgz file into a specific directory, rejecting symbolic links.
+func ExtractTGZ(r io.Reader, dir string) error {
+	return ExtractTGZWithOptions(r, dir, ExtractOptions{})
+}
+
+// ExtractTGZWithOptions uncompresses a .tar.gz/.tgz file into a specific directory, within the given limits.
+func ExtractTGZWithOptions(r io.Reader, dir string, opts ExtractOptions) error {
+	var uncompressed io.ReadCloser
+	var err error
+	if opts.Readahead > 0 {
+		compressed := newReadahead(r, opts.Readahead)
+		defer contract.IgnoreClose(compressed)
+		// pgzip decompresses into a bounded number of blocks in goroutines of its own, checksumming them separately,
+		// so decompression runs alongside reading the archive and writing its files.
+		uncompressed, err = pgzip.NewReaderN(compressed, readaheadBlockSize, readaheadBlocks(opts.Readahead))
+	} else {
+		uncompressed, err = gzip.NewReader(r)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "uncompressing")
+	}
+	defer contract.IgnoreClose(uncompressed)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrapf(err, "extracting dir %s", dir)
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return errors.Wrapf(err, "extracting dir %s", dir)
+	}
+
+	tr := tar.NewReader(uncompressed)
+	for count := 1; ; count++ {
+		header, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errors.Wrapf(err, "extracting")
+		}
+		if opts.MaxFiles > 0 && count > opts.MaxFiles {
+			return &LimitError{Name: header.Name, Limit: "file count", Max: int64(opts.MaxFiles)}
+		}
+
+		if err = extractFile(tr, header, root, opts); err != nil {
+			return err
+		}
+	}
+}
+
+// within returns true if path is root or inside it.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// entryPath returns the path the archive entry with the given name is extracted to, after checking it's inside root.
+// The entry's parent directories are created, and if they're reached through symbolic links extracted earlier, the
+// path they resolve to is checked too.
+func entryPath(root, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || strings.HasPrefix(name, "/") {
+		return "", &UnsafePathError{Name: name}
+	}
+	path := filepath.Join(root, clean)
+	if !within(root, path) {
+		return "", &UnsafePathError{Name: name}
+	}
+
+	// Some tools (notably `npm pack`) don't list directories individually, so if a file is in a directory that
+	// doesn't exist, we need to create it here.
+	parent := filepath.Dir(path)
+	if err := os.MkdirAll(parent, 0700); err != nil {
+		return "", errors.Wrapf(err, "extracting dir %s", parent)
+	}
+	realParent, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return "", errors.Wrapf(err, "extracting dir %s", parent)
+	}
+	if !within(root, realParent) {
+		return "", &UnsafePathError{Name: name}
+	}
+	return filepath.Join(realParent, filepath.Base(path)), nil
+}
+
+func extractFile(r *tar.Reader, header *tar.Header, root string, opts ExtractOptions) error {
+	path, err := entryPath(root, header.Name)
+	if err != nil {
+		return err
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		// Create any directories as needed.
+		if _, err := os.Stat(path); err != nil {
+			if err = os.MkdirAll(path, 0700); err != nil {
+				return errors.Wrapf(err, "extracting dir %s", path)
+			}
+		}
+	case tar.TypeReg:
+		if opts.MaxFileSize > 0 && header.Size > opts.MaxFileSize {
+			return &LimitError{Name: header.Name, Limit: "file size", Max: opts.MaxFileSize}
+		}
+
+		// Expand files into the target directory. Files already extracted are replaced, rather than written through
+		// if they're symbolic links.
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(path); err != nil {
+				return errors.Wrapf(err, "replacing symbolic link %s", path)
+			}
+		}
+		dst, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.FileMode(header.Mode))
+		if err != nil {
+			return errors.Wrapf(err, "opening file %s for extraction", path)
+		}
+		defer contract.IgnoreClose(dst)
+
+		// The size of each file is limited by the tar header it was declared with, and MaxFileSize if it's set.
+		// nolint:gosec
+		if _, err = io.Copy(dst, r); err != nil {
+			return errors.Wrapf(err, "untarring file %s", path)
+		}
+	case tar.TypeSymlink:
+		switch opts.Symlinks {
+		case SkipSymlinks:
+			return nil
+		case AllowContainedSymlinks:
+			target := filepath.FromSlash(header.Linkname)
+			if filepath.IsAbs(target) || filepath.VolumeName(target) != "" ||
+				!within(root, filepath.Join(filepath.Dir(path), target)) {
+				return &SymlinkError{Name: header.Name, Target: header.Linkname}
+			}
+			if err := os.Symlink(target, path); err != nil {
+				return errors.Wrapf(err, "creating symbolic link %s", path)
+			}
+		default:
+			return &SymlinkError{Name: header.Name, Target: header.Linkname}
+		}
+	default:
+		return errors.Errorf("unexpected plugin file type %s (%v)", header.Name, header.Typeflag)
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTGZ returns a .tar.gz holding the given entries. Entries with a link target are symbolic links.
+func testTGZ(t *testing.T, entries ...tar.Header) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, header := range entries {
+		header := header
+		data := []byte("data")
+		if header.Linkname != "" {
+			header.Typeflag, data = tar.TypeSymlink, nil
+		} else {
+			header.Typeflag = tar.TypeReg
+		}
+		header.Mode, header.Size = 0600, int64(len(data))
+		require.NoError(t, tw.WriteHeader(&header))
+		_, err := tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func TestExtractTGZRejectsUnsafePaths(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"../evil", "a/../../evil", "/etc/evil"} {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "plugin")
+			err := ExtractTGZ(bytes.NewReader(testTGZ(t, tar.Header{Name: name})), dir)
+			var pathErr *UnsafePathError
+			require.True(t, errors.As(err, &pathErr), "expected %v to be an *UnsafePathError", err)
+			assert.Equal(t, name, pathErr.Name)
+			_, err = os.Stat(filepath.Join(parent, "evil"))
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestExtractTGZSymlinks(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symbolic links requires elevated privileges on Windows")
+	}
+
+	tgz := testTGZ(t, tar.Header{Name: "bin/plugin"}, tar.Header{Name: "plugin", Linkname: "bin/plugin"})
+
+	err := ExtractTGZ(bytes.NewReader(tgz), t.TempDir())
+	var linkErr *SymlinkError
+	require.True(t, errors.As(err, &linkErr), "expected %v to be a *SymlinkError", err)
+	assert.Equal(t, &SymlinkError{Name: "plugin", Target: "bin/plugin"}, linkErr)
+
+	dir := t.TempDir()
+	require.NoError(t, ExtractTGZWithOptions(bytes.NewReader(tgz), dir, ExtractOptions{Symlinks: SkipSymlinks}))
+	_, err = os.Lstat(filepath.Join(dir, "plugin"))
+	assert.True(t, os.IsNotExist(err))
+
+	dir = t.TempDir()
+	require.NoError(t, ExtractTGZWithOptions(bytes.NewReader(tgz), dir, ExtractOptions{Symlinks: AllowContainedSymlinks}))
+	b, err := ioutil.ReadFile(filepath.Join(dir, "plugin"))
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(b))
+
+	// Links out of the directory are rejected, even when contained links are allowed.
+	for _, target := range []string{"..", "../../etc", "/etc"} {
+		tgz := testTGZ(t, tar.Header{Name: "out", Linkname: target})
+		err := ExtractTGZWithOptions(bytes.NewReader(tgz), t.TempDir(), ExtractOptions{Symlinks: AllowContainedSymlinks})
+		assert.True(t, errors.As(err, &linkErr), "expected %v to be a *SymlinkError", err)
+	}
+
+	// Files aren't written through links that lead out of the directory.
+	parent := t.TempDir()
+	dir = filepath.Join(parent, "plugin")
+	require.NoError(t, os.MkdirAll(dir, 0700))
+	require.NoError(t, os.Symlink(parent, filepath.Join(dir, "out")))
+	err = ExtractTGZ(bytes.NewReader(testTGZ(t, tar.Header{Name: "out/evil"})), dir)
+	var pathErr *UnsafePathError
+	assert.True(t, errors.As(err, &pathErr), "expected %v to be an *UnsafePathError", err)
+	_, err = os.Stat(filepath.Join(parent, "evil"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExtractTGZLimits(t *testing.T) {
+	t.Parallel()
+
+	tgz := testTGZ(t, tar.Header{Name: "a"}, tar.Header{Name: "b"}, tar.Header{Name: "c"})
+	opts := ExtractOptions{MaxFiles: 3, MaxFileSize: 4}
+	require.NoError(t, ExtractTGZWithOptions(bytes.NewReader(tgz), t.TempDir(), opts))
+
+	err := ExtractTGZWithOptions(bytes.NewReader(tgz), t.TempDir(), ExtractOptions{MaxFiles: 2})
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr), "expected %v to be a *LimitError", err)
+	assert.Equal(t, &LimitError{Name: "c", Limit: "file count", Max: 2}, limitErr)
+
+	err = ExtractTGZWithOptions(bytes.NewReader(tgz), t.TempDir(), ExtractOptions{MaxFileSize: 3})
+	require.True(t, errors.As(err, &limitErr), "expected %v to be a *LimitError", err)
+	assert.Equal(t, &LimitError{Name: "a", Limit: "file size", Max: 3}, limitErr)
+}
+
+func TestExtractTGZPipelined(t *testing.T) {
+	t.Parallel()
+
+	// A file larger than the readahead, so that each stage of the pipeline waits on the next.
+	large := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, data := range map[string][]byte{"large": large, "small": []byte("data")} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data))}))
+		_, err := tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	dir := t.TempDir()
+	r := bytes.NewReader(buf.Bytes())
+	require.NoError(t, ExtractTGZWithOptions(r, dir, ExtractOptions{Readahead: readaheadBlockSize}))
+	b, err := ioutil.ReadFile(filepath.Join(dir, "large"))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(large, b))
+	b, err = ioutil.ReadFile(filepath.Join(dir, "small"))
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(b))
+
+	// Corrupt archives fail as they do without pipelining.
+	corrupt := append([]byte{}, buf.Bytes()...)
+	corrupt = corrupt[:len(corrupt)/2]
+	err = ExtractTGZWithOptions(bytes.NewReader(corrupt), t.TempDir(), ExtractOptions{Readahead: readaheadBlockSize})
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), "unexpected error %v", err)
+}
@@ -0,0 +1,128 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive
+
+import (
+	"io"
+	"sync"
+)
+
+// readaheadBlockSize is the size of the blocks a readahead reader reads its source in.
+const readaheadBlockSize = 256 << 10
+
+// readahead reads from its source in a goroutine of its own, staying up to a fixed number of blocks ahead of its
+// reader, so that producing the data and consuming it overlap.
+type readahead struct {
+	// filled holds the blocks read from the source, in order. It's closed once the source fails or ends.
+	filled chan []byte
+	// free holds the blocks the reader is done with, to be filled again.
+	free chan []byte
+	// err is why the source stopped, which is io.EOF if it ended. It's set before filled is closed.
+	err error
+
+	block []byte
+	rest  []byte
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// readaheadBlocks returns how many blocks of readaheadBlockSize fit in size bytes, rounding up, and at least one.
+func readaheadBlocks(size int64) int {
+	blocks := int((size + readaheadBlockSize - 1) / readaheadBlockSize)
+	if blocks < 1 {
+		blocks = 1
+	}
+	return blocks
+}
+
+// newReadahead starts reading r into at most size bytes of blocks.
+func newReadahead(r io.Reader, size int64) *readahead {
+	blocks := readaheadBlocks(size)
+	ra := &readahead{
+		filled: make(chan []byte, blocks),
+		free:   make(chan []byte, blocks+1),
+		done:   make(chan struct{}),
+	}
+	for i := 0; i <= blocks; i++ {
+		ra.free <- make([]byte, readaheadBlockSize)
+	}
+	ra.wg.Add(1)
+	go ra.fill(r)
+	return ra
+}
+
+// fill reads blocks from r until it fails or ends, or the reader is closed.
+func (ra *readahead) fill(r io.Reader) {
+	defer ra.wg.Done()
+	defer close(ra.filled)
+	for {
+		var block []byte
+		select {
+		case block = <-ra.free:
+		case <-ra.done:
+			return
+		}
+
+		n, err := 0, error(nil)
+		for n < len(block) && err == nil {
+			var read int
+			read, err = r.Read(block[n:])
+			n += read
+		}
+		if n > 0 {
+			select {
+			case ra.filled <- block[:n]:
+			case <-ra.done:
+				return
+			}
+		}
+		if err != nil {
+			ra.err = err
+			return
+		}
+	}
+}
+
+func (ra *readahead) Read(p []byte) (int, error) {
+	select {
+	case <-ra.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	for len(ra.rest) == 0 {
+		if ra.block != nil {
+			ra.free <- ra.block[:cap(ra.block)]
+			ra.block = nil
+		}
+		block, ok := <-ra.filled
+		if !ok {
+			return 0, ra.err
+		}
+		ra.block, ra.rest = block, block
+	}
+	n := copy(p, ra.rest)
+	ra.rest = ra.rest[n:]
+	return n, nil
+}
+
+// Close stops reading from the source, and waits for the read in progress, if any, to finish, so the source can be
+// used again once Close returns.
+func (ra *readahead) Close() error {
+	ra.closeOnce.Do(func() { close(ra.done) })
+	ra.wg.Wait()
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadahead(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("0123456789"), readaheadBlockSize/2)
+	ra := newReadahead(iotest.HalfReader(bytes.NewReader(data)), 2*readaheadBlockSize)
+	b, err := ioutil.ReadAll(ra)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, b))
+	require.NoError(t, ra.Close())
+
+	// The source's errors are returned once the data read before them has been.
+	failing := io.MultiReader(bytes.NewReader(data), iotest.ErrReader(io.ErrUnexpectedEOF))
+	ra = newReadahead(failing, readaheadBlockSize)
+	b, err = ioutil.ReadAll(ra)
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), "unexpected error %v", err)
+	assert.True(t, bytes.Equal(data, b))
+	require.NoError(t, ra.Close())
+}
+
+func TestReadaheadClose(t *testing.T) {
+	t.Parallel()
+
+	// Once closed, the source isn't read from anymore, so whatever it holds beyond what was read ahead is left.
+	data := bytes.Repeat([]byte("x"), 8*readaheadBlockSize)
+	src := bytes.NewReader(data)
+	ra := newReadahead(src, readaheadBlockSize)
+	_, err := ra.Read(make([]byte, 10))
+	require.NoError(t, err)
+	require.NoError(t, ra.Close())
+	assert.Greater(t, src.Len(), 0)
+
+	_, err = ra.Read(make([]byte, 10))
+	assert.Equal(t, io.ErrClosedPipe, err)
+}
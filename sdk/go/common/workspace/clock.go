@@ -0,0 +1,58 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"time"
+)
+
+// Clock tells the time. The times the workspace records, such as when plugins were installed and last used, are read
+// from a Clock, so tests can control them and embedders replaying an operation can freeze them.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// SystemClock is the Clock that tells the system's time.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FixedClock is a Clock that is stopped at a time.
+type FixedClock time.Time
+
+func (clock FixedClock) Now() time.Time {
+	return time.Time(clock)
+}
+
+// now returns the current time according to the context's clock.
+func (ctx *Context) now() time.Time {
+	if ctx.Clock != nil {
+		return ctx.Clock.Now()
+	}
+	return SystemClock.Now()
+}
+
+// now returns the current time according to the clock of the context the plugin was set up by.
+func (info PluginInfo) now() time.Time {
+	if info.ctx != nil {
+		return info.ctx.now()
+	}
+	return SystemClock.Now()
+}
@@ -0,0 +1,56 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestContextClock(t *testing.T) {
+	t.Setenv("PULUMI_IGNORE_AMBIENT_PLUGINS", "true")
+
+	// File systems only update the access times of files that were last accessed before they were modified, or
+	// more than a day ago, so the plugin is used after it's installed, and recently.
+	used := time.Now().Truncate(time.Second)
+	installed := used.Add(-time.Hour)
+
+	ctx := &Context{Home: t.TempDir(), Clock: FixedClock(installed)}
+	_, info := newMockPlugin(t)
+	info.PluginDir = ""
+	info, err := ctx.Plugin(info)
+	require.NoError(t, err)
+	require.NoError(t, info.Install(ioutil.NopCloser(bytes.NewReader(mockPluginTGZ(t))), false))
+
+	plugins, err := ctx.GetPluginsWithMetadata()
+	require.NoError(t, err)
+	require.Len(t, plugins, 1)
+	assert.True(t, installed.Equal(plugins[0].InstallTime), "installed at %v, not %v", plugins[0].InstallTime, installed)
+
+	ctx.Clock = FixedClock(used)
+	_, _, err = ctx.GetPluginPath(info.Kind, info.Name, info.Version)
+	require.NoError(t, err)
+	plugins, err = ctx.GetPluginsWithMetadata()
+	require.NoError(t, err)
+	require.Len(t, plugins, 1)
+	assert.True(t, installed.Equal(plugins[0].InstallTime), "installed at %v, not %v", plugins[0].InstallTime, installed)
+	assert.True(t, used.Equal(plugins[0].LastUsedTime), "last used at %v, not %v", plugins[0].LastUsedTime, used)
+}
@@ -0,0 +1,164 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"net/http"
+	"path/filepath"
+)
+
+// Context specifies where the workspace keeps its files, overriding `PULUMI_HOME` and the current user's home
+// directory. It lets a single process, such as a program using the Automation API or a test, keep separate plugin
+// caches for separate operations. The zero value uses the same directories as the package-level functions.
+type Context struct {
+	// Home is the Pulumi home directory. If empty, `PULUMI_HOME` or `~/.pulumi` is used.
+	Home string
+	// PluginDir is the directory plugins are installed into. If empty, the plugins directory in Home is used.
+	PluginDir string
+	// HTTPClient is the client plugin sources send their requests with. Embedders can wrap its Transport to add
+	// authentication or caching. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	// DownloadMiddleware wraps the requests plugin sources send with the context's HTTP client, inside any middleware
+	// registered with RegisterPluginDownloadMiddleware. The first middleware is outermost.
+	DownloadMiddleware []PluginDownloadMiddleware
+	// PluginSource, if set, returns the source a plugin is downloaded from, and its latest version looked up with,
+	// instead of the one its download URL and the configured mirrors would pick. Tests can use it to serve plugins
+	// without a network.
+	PluginSource func(info PluginInfo) PluginSource
+	// Clock tells the time that is recorded when plugins are installed and used. If nil, SystemClock is used.
+	Clock Clock
+	// Logger receives the diagnostics of the context's plugin operations, including those of the plugins it sets up
+	// with Plugin. Diagnostics that aren't tied to a context, such as those of plugin sources, go to DefaultLogger.
+	// If nil, DefaultLogger is used.
+	Logger Logger
+	// LegacyPluginSearch matches plugins in the plugin cache against the versions they're requested at as older
+	// versions of Pulumi did: the newest installed version at or above a requested version is used, rather than that
+	// version. It's deprecated, and will be removed; ReportLegacyPluginSearch lists the plugins it resolves
+	// differently. The deprecated PULUMI_ENABLE_LEGACY_PLUGIN_SEARCH also enables it.
+	LegacyPluginSearch bool
+	// Prereleases makes GetLatestVersion, and the resolution of version ranges, consider prereleases: the highest
+	// version a plugin's source publishes is used, even if it's a prerelease, so users can opt in to beta plugins. Only
+	// sources that can list their versions publish prereleases this way. PULUMI_PLUGIN_PRERELEASE also enables it.
+	Prereleases bool
+	// UserAgent is appended to the User-Agent of the requests plugin sources send, so the operators of mirrors can
+	// tell which systems send them. It's a list of products, such as `my-ci/2.1`. If empty,
+	// PULUMI_PLUGIN_USER_AGENT is used.
+	UserAgent string
+	// Attribution is sent in the X-Pulumi-Attribution header of the requests plugin sources send, such as the team or
+	// pipeline that sends them. If empty, PULUMI_PLUGIN_ATTRIBUTION is used.
+	Attribution string
+}
+
+// GetPulumiHomeDir returns the path of the Pulumi home directory.
+func (ctx *Context) GetPulumiHomeDir() (string, error) {
+	if ctx.Home != "" {
+		return ctx.Home, nil
+	}
+	return GetPulumiHomeDir()
+}
+
+// GetPulumiPath returns the path to a file or directory under the Pulumi home directory.
+func (ctx *Context) GetPulumiPath(elem ...string) (string, error) {
+	homeDir, err := ctx.GetPulumiHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{homeDir}, elem...)...), nil
+}
+
+// GetPluginDir returns the directory in which plugins are managed.
+func (ctx *Context) GetPluginDir() (string, error) {
+	if ctx.PluginDir != "" {
+		return ctx.PluginDir, nil
+	}
+	return ctx.GetPulumiPath(PluginDir)
+}
+
+// Plugin returns info set up to be installed into, and removed from, the context's directory for plugins of its kind.
+// Plugins with an explicit PluginDir are returned as is.
+func (ctx *Context) Plugin(info PluginInfo) (PluginInfo, error) {
+	info.ctx = ctx
+	if info.PluginDir != "" || (ctx.Home == "" && ctx.PluginDir == "") {
+		return info, nil
+	}
+	dir, err := ctx.GetPluginKindDir(info.Kind)
+	if err != nil {
+		return info, err
+	}
+	info.PluginDir = dir
+	return info, nil
+}
+
+// pluginCacheDir returns the plugin directory the plugin is installed in: its PluginDir, or else its context's
+// directory for plugins of its kind.
+func (info PluginInfo) pluginCacheDir() (string, error) {
+	if info.PluginDir != "" {
+		return info.PluginDir, nil
+	}
+	return info.context().GetPluginKindDir(info.Kind)
+}
+
+// context returns the context that set the plugin up, or the zero context if none did.
+func (info PluginInfo) context() *Context {
+	if info.ctx != nil {
+		return info.ctx
+	}
+	return &Context{}
+}
+
+// GetPlugins returns the plugins installed in the context's plugin directory, without size info and last accessed
+// metadata, like the package-level GetPlugins.
+func (ctx *Context) GetPlugins() ([]PluginInfo, error) {
+	ctx.maybeMaintainPlugins()
+	return ctx.getPlugins(true /* skipMetadata */)
+}
+
+// GetPluginsWithMetadata returns the plugins installed in the context's plugin directory with metadata about size and
+// last access, like the package-level GetPluginsWithMetadata.
+func (ctx *Context) GetPluginsWithMetadata() ([]PluginInfo, error) {
+	ctx.maybeMaintainPlugins()
+	return ctx.getPlugins(false /* skipMetadata */)
+}
+
+func (ctx *Context) getPlugins(skipMetadata bool) ([]PluginInfo, error) {
+	// To get the list of plugins, simply scan the directories in the usual place.
+	dirs, err := ctx.getPluginDirs()
+	if err != nil {
+		return nil, err
+	}
+	var plugins []PluginInfo
+	for _, dir := range dirs {
+		found, err := getPlugins(dir, skipMetadata)
+		if err != nil {
+			return nil, err
+		}
+		// Plugins found outside the directory their kind is installed into by default need to remember where they are.
+		for _, plugin := range found {
+			defaultDir, err := GetPluginKindDir(plugin.Kind)
+			if err != nil {
+				return nil, err
+			}
+			if dir != defaultDir {
+				plugin.PluginDir = dir
+			}
+			plugins = append(plugins, plugin)
+		}
+	}
+	fallbackPlugins, err := getFallbackPlugins(dirs[0], skipMetadata)
+	if err != nil {
+		return nil, err
+	}
+	return append(plugins, fallbackPlugins...), nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContextDirs(t *testing.T) {
+	t.Parallel()
+
+	ctx := &Context{Home: "/home/pulumi"}
+	dir, err := ctx.GetPluginDir()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("/home/pulumi", PluginDir), dir)
+	path, err := ctx.GetPulumiPath(WorkspaceDir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("/home/pulumi", WorkspaceDir), path)
+
+	ctx = &Context{Home: "/home/pulumi", PluginDir: "/plugins"}
+	dir, err = ctx.GetPluginDir()
+	require.NoError(t, err)
+	assert.Equal(t, "/plugins", dir)
+
+	_, info := newMockPlugin(t)
+	explicit, err := ctx.Plugin(info)
+	require.NoError(t, err)
+	assert.Equal(t, info.PluginDir, explicit.PluginDir, "explicit plugin directories are kept")
+
+	info.PluginDir = ""
+	info, err = ctx.Plugin(info)
+	require.NoError(t, err)
+	assert.Equal(t, "/plugins", info.PluginDir)
+}
+
+func TestContextIsolatesPlugins(t *testing.T) {
+	t.Parallel()
+
+	first, second := &Context{Home: t.TempDir()}, &Context{Home: t.TempDir()}
+	_, info := newMockPlugin(t)
+	info.PluginDir = ""
+	info, err := first.Plugin(info)
+	require.NoError(t, err)
+	require.NoError(t, info.Install(ioutil.NopCloser(bytes.NewReader(mockPluginTGZ(t))), false))
+
+	plugins, err := first.GetPlugins()
+	require.NoError(t, err)
+	require.Len(t, plugins, 1)
+	assert.Equal(t, info.PluginDir, plugins[0].PluginDir)
+	dir, path, err := first.GetPluginPath(ResourcePlugin, "mock", info.Version)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(first.Home, PluginDir, info.Dir()), dir)
+	assert.Equal(t, dir, filepath.Dir(path))
+
+	plugins, err = second.GetPlugins()
+	require.NoError(t, err)
+	assert.Empty(t, plugins)
+	_, _, err = second.GetPluginPath(ResourcePlugin, "mock", info.Version)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
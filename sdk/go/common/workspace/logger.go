@@ -0,0 +1,122 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+// LogFields are structured details about a log message, such as the kind, name and version of the plugin it concerns.
+type LogFields map[string]interface{}
+
+// Logger receives the diagnostics of plugin lookups, downloads and installs, e.g. so a program using the Automation
+// API can send them to its own logging pipeline.
+type Logger interface {
+	// Logf logs a message at a glog-style verbosity level, where higher levels are more detailed.
+	Logf(level int, fields LogFields, format string, args ...interface{})
+	// Warningf logs a problem the user should be told about.
+	Warningf(fields LogFields, format string, args ...interface{})
+}
+
+// DefaultLogger receives the diagnostics of plugin operations that aren't made through a Context with a Logger. It
+// writes them to the CLI's logs with the logging package, adding their fields to the end of each message.
+var DefaultLogger Logger = glogLogger{}
+
+type glogLogger struct{}
+
+func (glogLogger) Logf(level int, fields LogFields, format string, args ...interface{}) {
+	if v := logging.V(glog.Level(level)); v {
+		v.Infof("%s%s", fmt.Sprintf(format, args...), formatLogFields(fields))
+	}
+}
+
+func (glogLogger) Warningf(fields LogFields, format string, args ...interface{}) {
+	logging.Warningf("%s%s", fmt.Sprintf(format, args...), formatLogFields(fields))
+}
+
+// formatLogFields formats fields as ` key=value` pairs, sorted by key.
+func formatLogFields(fields LogFields) string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&sb, " %s=%v", key, fields[key])
+	}
+	return sb.String()
+}
+
+// logger returns the context's logger.
+func (ctx *Context) logger() Logger {
+	if ctx.Logger != nil {
+		return ctx.Logger
+	}
+	return DefaultLogger
+}
+
+// logf logs a message with the context's logger.
+func (ctx *Context) logf(level int, format string, args ...interface{}) {
+	ctx.logger().Logf(level, nil, format, args...)
+}
+
+// logf logs a message about the plugin, with the logger of the context that set it up.
+func (info PluginInfo) logf(level int, format string, args ...interface{}) {
+	info.logger().Logf(level, info.logFields(), format, args...)
+}
+
+// warnf logs a problem with the plugin, with the logger of the context that set it up.
+func (info PluginInfo) warnf(format string, args ...interface{}) {
+	info.logger().Warningf(info.logFields(), format, args...)
+}
+
+func (info PluginInfo) logger() Logger {
+	if info.ctx != nil {
+		return info.ctx.logger()
+	}
+	return DefaultLogger
+}
+
+// logFields returns the fields that identify the plugin in log messages.
+func (info PluginInfo) logFields() LogFields {
+	fields := LogFields{"kind": info.Kind, "name": info.Name}
+	if info.Version != nil {
+		fields["version"] = info.Version.String()
+	}
+	return fields
+}
+
+// logf logs a message with DefaultLogger. The fields identify the plugin the message concerns, if any.
+func logf(level int, fields LogFields, format string, args ...interface{}) {
+	DefaultLogger.Logf(level, fields, format, args...)
+}
+
+// warnf logs a problem with DefaultLogger.
+func warnf(fields LogFields, format string, args ...interface{}) {
+	DefaultLogger.Warningf(fields, format, args...)
+}
+
+// sourceLogFields returns the fields that identify the plugin a source downloads in log messages.
+func sourceLogFields(kind PluginKind, name string) LogFields {
+	return LogFields{"kind": kind, "name": name}
+}
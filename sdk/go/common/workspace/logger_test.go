@@ -0,0 +1,97 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type logEntry struct {
+	level   int // -1 for warnings
+	fields  LogFields
+	message string
+}
+
+type recordingLogger struct {
+	m       sync.Mutex
+	entries []logEntry
+}
+
+func (l *recordingLogger) Logf(level int, fields LogFields, format string, args ...interface{}) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.entries = append(l.entries, logEntry{level, fields, fmt.Sprintf(format, args...)})
+}
+
+func (l *recordingLogger) Warningf(fields LogFields, format string, args ...interface{}) {
+	l.Logf(-1, fields, format, args...)
+}
+
+func (l *recordingLogger) find(substr string) (logEntry, bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	for _, entry := range l.entries {
+		if strings.Contains(entry.message, substr) {
+			return entry, true
+		}
+	}
+	return logEntry{}, false
+}
+
+func TestFormatLogFields(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "", formatLogFields(nil))
+	assert.Equal(t, " kind=resource name=aws version=5.1.0",
+		formatLogFields(LogFields{"version": "5.1.0", "name": "aws", "kind": ResourcePlugin}))
+}
+
+func TestContextLogger(t *testing.T) {
+	t.Parallel()
+
+	logger := &recordingLogger{}
+	ctx := &Context{PluginDir: t.TempDir(), Logger: logger}
+
+	// A tarball without the plugin's executable installs with a warning.
+	tgz, err := createTGZ(map[string][]byte{"README.md": nil})
+	require.NoError(t, err)
+
+	v := semver.MustParse("1.0.0")
+	info, err := ctx.Plugin(PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &v})
+	require.NoError(t, err)
+	require.NoError(t, info.Install(ioutil.NopCloser(bytes.NewReader(tgz)), false))
+
+	entry, ok := logger.find("no pulumi-resource-mock executable was found")
+	require.True(t, ok, "missing warning in %v", logger.entries)
+	assert.Equal(t, -1, entry.level)
+	assert.Equal(t, LogFields{"kind": ResourcePlugin, "name": "mock", "version": "1.0.0"}, entry.fields)
+
+	// Each context logs to its own logger.
+	other := &recordingLogger{}
+	(&Context{Logger: other}).logf(1, "hello")
+	_, ok = other.find("hello")
+	assert.True(t, ok)
+	_, ok = logger.find("hello")
+	assert.False(t, ok)
+}
@@ -15,8 +15,8 @@
 package workspace
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -29,40 +29,45 @@ import (
 	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/blang/semver"
 	"github.com/cheggaaa/pb"
 	"github.com/djherbis/times"
-	"github.com/pkg/errors"
 
 	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/archive"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/fsutil"
-	"github.com/pulumi/pulumi/sdk/v3/go/common/util/httputil"
-	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/version"
-	"github.com/pulumi/pulumi/sdk/v3/nodejs/npm"
-	"github.com/pulumi/pulumi/sdk/v3/python"
 )
 
 const (
 	windowsGOOS = "windows"
 )
 
-var (
-	enableLegacyPluginBehavior = os.Getenv("PULUMI_ENABLE_LEGACY_PLUGIN_SEARCH") != ""
-)
-
 // pluginDownloadURLOverrides is a variable instead of a constant so it can be set using the `-X` `ldflag` at build
 // time, if necessary. When non-empty, it's parsed into `pluginDownloadURLOverridesParsed` in `init()`. The expected
 // format is `regexp=URL`, and multiple pairs can be specified separated by commas, e.g. `regexp1=URL1,regexp2=URL2`.
 //
 // For example, when set to "^foo.*=https://foo,^bar.*=https://bar", plugin names that start with "foo" will use
 // https://foo as the download URL and names that start with "bar" will use https://bar.
+//
+// The regexp may be prefixed by a plugin kind and a slash, and followed by an `@` and a semver range, so the override
+// only applies to plugins of that kind, or with a version in that range. For example,
+// "resource/aws@>=5.0.0=https://mirror" overrides the download URL of versions 5.0.0 and later of the aws resource
+// provider, but not of a language host of the same name. Overrides with a version range don't apply to plugins whose
+// version isn't known yet.
+//
+// The regexp ends at the `=` before the URL's scheme, so it may contain `=` itself, and its version range starts at
+// its last `@`. Regexps that contain an `@`, or an `=` followed by a scheme and `://`, or that are used with URLs
+// without a scheme, can be quoted like Go strings instead, with the kind and version range outside the quotes, e.g.
+// `resource/"^org@.*$"@>=5.0.0=https://mirror`.
+//
+// PluginDownloadURLOverridesEnvVar and PluginConfigFile configure more overrides at runtime, which are matched first.
 var pluginDownloadURLOverrides string
 
 // pluginDownloadURLOverridesParsed is the parsed array from `pluginDownloadURLOverrides`.
@@ -70,17 +75,25 @@ var pluginDownloadURLOverridesParsed pluginDownloadOverrideArray
 
 // pluginDownloadURLOverride represents a plugin download URL override, parsed from `pluginDownloadURLOverrides`.
 type pluginDownloadURLOverride struct {
-	reg *regexp.Regexp // The regex used to match against the plugin's name.
-	url string         // The URL to use for the matched plugin.
+	kind     PluginKind     // The kind of plugin the override applies to, or "" for every kind.
+	reg      *regexp.Regexp // The regex used to match against the plugin's name.
+	versions semver.Range   // The range of versions the override applies to, or nil for every version.
+	url      string         // The URL to use for the matched plugin.
 }
 
 // pluginDownloadOverrideArray represents an array of overrides.
 type pluginDownloadOverrideArray []pluginDownloadURLOverride
 
-// get returns the URL and true if name matches an override's regular expression,
-// otherwise an empty string and false.
-func (overrides pluginDownloadOverrideArray) get(name string) (string, bool) {
+// get returns the URL and true if the plugin matches an override's kind, regular expression and version range,
+// otherwise an empty string and false. The version is nil if it isn't known.
+func (overrides pluginDownloadOverrideArray) get(kind PluginKind, name string, version *semver.Version) (string, bool) {
 	for _, override := range overrides {
+		if override.kind != "" && override.kind != kind {
+			continue
+		}
+		if override.versions != nil && (version == nil || !override.versions(*version)) {
+			continue
+		}
 		if override.reg.MatchString(name) {
 			return override.url, true
 		}
@@ -95,35 +108,122 @@ func init() {
 	}
 }
 
-// parsePluginDownloadURLOverrides parses an overrides string with the expected format `regexp1=URL1,regexp2=URL2`.
+// overrideURLSchemeRegexp matches the `=` that separates the selector of a download URL override from a URL with a
+// scheme.
+var overrideURLSchemeRegexp = regexp.MustCompile(`=[A-Za-z][A-Za-z0-9+.-]*://`)
+
+// parsePluginDownloadURLOverrides parses an overrides string with the expected format `regexp1=URL1,regexp2=URL2`,
+// where each regexp may be prefixed by `kind/` and followed by `@range`, and may be quoted.
 func parsePluginDownloadURLOverrides(overrides string) (pluginDownloadOverrideArray, error) {
 	var result pluginDownloadOverrideArray
 	if overrides == "" {
 		return result, nil
 	}
 	for _, pair := range strings.Split(overrides, ",") {
-		split := strings.Split(pair, "=")
-		if len(split) != 2 || split[0] == "" || split[1] == "" {
+		override, err := parsePluginDownloadURLOverride(pair)
+		if errors.Is(err, errInvalidOverrideFormat) {
 			return nil, fmt.Errorf("expected format to be \"regexp1=URL1,regexp2=URL2\"; got %q", overrides)
-		}
-		reg, err := regexp.Compile(split[0])
-		if err != nil {
+		} else if err != nil {
 			return nil, err
 		}
-		result = append(result, pluginDownloadURLOverride{
-			reg: reg,
-			url: split[1],
-		})
+		result = append(result, override)
 	}
 	return result, nil
 }
 
+// errInvalidOverrideFormat is returned by parsePluginDownloadURLOverride for overrides that lack a part.
+var errInvalidOverrideFormat = errors.New("invalid plugin download URL override")
+
+// parsePluginDownloadURLOverride parses a single download URL override, of the form `[kind/]regexp[@range]=URL`.
+func parsePluginDownloadURLOverride(pair string) (pluginDownloadURLOverride, error) {
+	var override pluginDownloadURLOverride
+	rest := pair
+	if slash := strings.Index(rest, "/"); slash >= 0 && IsPluginKind(rest[:slash]) {
+		override.kind, rest = PluginKind(rest[:slash]), rest[slash+1:]
+	}
+
+	var selector, url string
+	if strings.HasPrefix(rest, `"`) {
+		// Quoted regexps may contain anything. The version range and URL follow the quotes.
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return override, fmt.Errorf("plugin download URL override %q has an unterminated quoted regexp", pair)
+		}
+		selector, err = strconv.Unquote(quoted)
+		contract.AssertNoError(err)
+		rest = rest[len(quoted):]
+		if strings.HasPrefix(rest, "@") {
+			// The range may contain `=` itself, so it ends at the first `=` that leaves a valid range before it.
+			found := false
+			for i := strings.Index(rest, "="); i >= 0 && !found; {
+				if versions, err := semver.ParseRange(rest[1:i]); err == nil {
+					override.versions, rest, found = versions, rest[i:], true
+				} else if next := strings.Index(rest[i+1:], "="); next >= 0 {
+					i += next + 1
+				} else {
+					i = -1
+				}
+			}
+			if !found {
+				return override, fmt.Errorf("invalid version range in plugin download URL override %q", pair)
+			}
+		}
+		if !strings.HasPrefix(rest, "=") {
+			return override, fmt.Errorf("plugin download URL override %q must have `=` after its quoted regexp", pair)
+		}
+		url = rest[1:]
+	} else {
+		var err error
+		if selector, url, err = splitPluginDownloadURLOverride(rest); err != nil {
+			return override, err
+		}
+		if at := strings.LastIndex(selector, "@"); at >= 0 {
+			versions, err := semver.ParseRange(selector[at+1:])
+			if err != nil {
+				return override, fmt.Errorf("invalid version range in plugin download URL override %q: %w", pair, err)
+			}
+			override.versions, selector = versions, selector[:at]
+		}
+	}
+	if selector == "" || url == "" {
+		return override, errInvalidOverrideFormat
+	}
+
+	reg, err := regexp.Compile(selector)
+	if err != nil {
+		return override, err
+	}
+	override.reg, override.url = reg, url
+	return override, nil
+}
+
+// splitPluginDownloadURLOverride splits an unquoted download URL override into its selector and URL, which are empty
+// if the override doesn't have them.
+func splitPluginDownloadURLOverride(pair string) (string, string, error) {
+	// Version ranges and regexps may contain `=` themselves, so URLs with a scheme start at the `=` before it.
+	switch seps := overrideURLSchemeRegexp.FindAllStringIndex(pair, -1); len(seps) {
+	case 0:
+		if strings.Count(pair, "=") != 1 {
+			return "", "", nil
+		}
+		sep := strings.Index(pair, "=")
+		return pair[:sep], pair[sep+1:], nil
+	case 1:
+		return pair[:seps[0][0]], pair[seps[0][0]+1:], nil
+	default:
+		return "", "", fmt.Errorf("plugin download URL override %q has more than one `=` followed by a URL scheme; "+
+			"quote its selector, as in `\"regexp\"=URL`", pair)
+	}
+}
+
 // MissingError is returned by functions that attempt to load plugins if a plugin can't be located.
 type MissingError struct {
 	// Info contains information about the plugin that was not found.
 	Info PluginInfo
 	// includeAmbient is true if we search $PATH for this plugin
 	includeAmbient bool
+	// InstalledVersions are the other versions of the plugin that are installed, if a specific version was requested.
+	InstalledVersions []semver.Version
 }
 
 // NewMissingError allocates a new error indicating the given plugin info was not found.
@@ -134,6 +234,29 @@ func NewMissingError(info PluginInfo, includeAmbient bool) error {
 	}
 }
 
+// newMissingErrorWithInstalled returns a MissingError for the given plugin, listing the versions of it among the
+// installed plugins.
+func newMissingErrorWithInstalled(info PluginInfo, includeAmbient bool, installed []PluginInfo) error {
+	err := &MissingError{
+		Info:           info,
+		includeAmbient: includeAmbient,
+	}
+	if info.Version != nil {
+		for _, plugin := range installed {
+			if plugin.Kind == info.Kind && plugin.Name == info.Name && plugin.Version != nil {
+				err.InstalledVersions = append(err.InstalledVersions, *plugin.Version)
+			}
+		}
+		semver.Sort(err.InstalledVersions)
+	}
+	return err
+}
+
+// Is matches ErrNotFound.
+func (err *MissingError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
 func (err *MissingError) Error() string {
 	includePath := ""
 	if err.includeAmbient {
@@ -142,8 +265,8 @@ func (err *MissingError) Error() string {
 
 	if err.Info.Version != nil {
 		return fmt.Sprintf("no %[1]s plugin 'pulumi-%[1]s-%[2]s' found in the workspace at version v%[3]s%[4]s, "+
-			"install the plugin using `pulumi plugin install %[1]s %[2]s v%[3]s`",
-			err.Info.Kind, err.Info.Name, err.Info.Version, includePath)
+			"install the plugin using `pulumi plugin install %[1]s %[2]s v%[3]s`%[5]s",
+			err.Info.Kind, err.Info.Name, err.Info.Version, includePath, err.installedVersionsText())
 	}
 
 	return fmt.Sprintf("no %[1]s plugin 'pulumi-%[1]s-%[2]s' found in the workspace%[3]s, "+
@@ -151,6 +274,27 @@ func (err *MissingError) Error() string {
 		err.Info.Kind, err.Info.Name, includePath)
 }
 
+// installedVersionsText describes the other installed versions of the plugin, and which of them are compatible with
+// the requested version: those with the same major version that are no older.
+func (err *MissingError) installedVersionsText() string {
+	if len(err.InstalledVersions) == 0 {
+		return ""
+	}
+	var installed, compatible []string
+	for _, v := range err.InstalledVersions {
+		installed = append(installed, "v"+v.String())
+		if v.Major == err.Info.Version.Major && v.GTE(*err.Info.Version) {
+			compatible = append(compatible, "v"+v.String())
+		}
+	}
+	if len(compatible) == 0 {
+		return fmt.Sprintf("; other installed versions: %s (none is compatible with v%s)",
+			strings.Join(installed, ", "), err.Info.Version)
+	}
+	return fmt.Sprintf("; other installed versions: %s (compatible with v%s: %s)",
+		strings.Join(installed, ", "), err.Info.Version, strings.Join(compatible, ", "))
+}
+
 // PluginSource deals with downloading a specific version of a plugin, or looking up the latest version of it.
 type PluginSource interface {
 	// Download fetches an io.ReadCloser for this plugin and also returns the size of the response (if known).
@@ -182,12 +326,12 @@ func (source *getPulumiSource) Download(
 	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
 	serverURL := "https://get.pulumi.com/releases/plugins"
 
-	logging.V(1).Infof("%s downloading from %s", source.name, serverURL)
+	logf(1, sourceLogFields(source.kind, source.name), "%s downloading from %s", source.name, serverURL)
 
-	serverURL = interpolateURL(serverURL, version, opSy, arch)
+	serverURL = interpolateURL(serverURL, source.kind, source.name, version, opSy, arch)
 	serverURL = strings.TrimSuffix(serverURL, "/")
 
-	logging.V(1).Infof("%s downloading from %s", source.name, serverURL)
+	logf(1, sourceLogFields(source.kind, source.name), "%s downloading from %s", source.name, serverURL)
 	endpoint := fmt.Sprintf("%s/%s",
 		serverURL,
 		url.QueryEscape(fmt.Sprintf("pulumi-%s-%s-v%s-%s-%s.tar.gz", source.kind, source.name, version.String(), opSy, arch)))
@@ -216,7 +360,7 @@ func newGithubSource(organization, name string, kind PluginKind) *githubSource {
 	// envvar we made up we check to see if it's set here and log a warning. This can be removed after a few
 	// releases.
 	if os.Getenv("GITHUB_PERSONAL_ACCESS_TOKEN") != "" {
-		logging.Warningf("GITHUB_PERSONAL_ACCESS_TOKEN is no longer used for Github authentication, set GITHUB_TOKEN instead")
+		warnf(nil, "GITHUB_PERSONAL_ACCESS_TOKEN is no longer used for Github authentication, set GITHUB_TOKEN instead")
 	}
 
 	return &githubSource{
@@ -224,7 +368,7 @@ func newGithubSource(organization, name string, kind PluginKind) *githubSource {
 		name:         name,
 		kind:         kind,
 
-		token: os.Getenv("GITHUB_TOKEN"),
+		token: githubReleaseToken(organization),
 	}
 }
 
@@ -237,7 +381,7 @@ func (source *githubSource) GetLatestVersion(
 	releaseURL := fmt.Sprintf(
 		"https://api.github.com/repos/%s/pulumi-%s/releases/latest",
 		source.organization, source.name)
-	logging.V(9).Infof("plugin GitHub releases url: %s", releaseURL)
+	logf(9, sourceLogFields(source.kind, source.name), "plugin GitHub releases url: %s", releaseURL)
 	req, err := buildHTTPRequest(releaseURL, source.token)
 	if err != nil {
 		return nil, err
@@ -249,7 +393,7 @@ func (source *githubSource) GetLatestVersion(
 	}
 	jsonBody, err := ioutil.ReadAll(resp)
 	if err != nil {
-		return nil, fmt.Errorf("cannot unmarshal github response len(%d): %s", length, err.Error())
+		return nil, fmt.Errorf("cannot unmarshal github response len(%d): %w", length, err)
 	}
 	release := struct {
 		TagName string `json:"tag_name"`
@@ -265,13 +409,54 @@ func (source *githubSource) GetLatestVersion(
 	return &parsedVersion, nil
 }
 
+// ListVersions implements versionListingSource, using the tags of the repository's releases. Drafts aren't listed.
+func (source *githubSource) ListVersions(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, error) {
+	const perPage = 100
+	var versions []semver.Version
+	for page := 1; ; page++ {
+		releasesURL := fmt.Sprintf("https://api.github.com/repos/%s/pulumi-%s/releases?per_page=%d&page=%d",
+			source.organization, source.name, perPage, page)
+		logf(9, sourceLogFields(source.kind, source.name), "plugin GitHub releases url: %s", releasesURL)
+		req, err := buildHTTPRequest(releasesURL, source.token)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		resp, _, err := getHTTPResponse(req)
+		if err != nil {
+			return nil, err
+		}
+		jsonBody, err := ioutil.ReadAll(resp)
+		contract.IgnoreClose(resp)
+		if err != nil {
+			return nil, err
+		}
+		var releases []struct {
+			TagName string `json:"tag_name"`
+			Draft   bool   `json:"draft"`
+		}
+		if err := json.Unmarshal(jsonBody, &releases); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal github response: %w", err)
+		}
+		for _, release := range releases {
+			if version, err := semver.ParseTolerant(release.TagName); err == nil && !release.Draft {
+				versions = append(versions, version)
+			}
+		}
+		if len(releases) < perPage {
+			return versions, nil
+		}
+	}
+}
+
 func (source *githubSource) Download(
 	version semver.Version, opSy string, arch string,
 	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
 	if !source.HasAuthentication() {
 		// If we're not using authentication we can just download from the release/download URL
 
-		logging.V(1).Infof(
+		logf(1, sourceLogFields(source.kind, source.name),
 			"%s downloading from github.com/%s/pulumi-%s/releases",
 			source.name, source.organization, source.name)
 
@@ -292,7 +477,7 @@ func (source *githubSource) Download(
 	releaseURL := fmt.Sprintf(
 		"https://api.github.com/repos/%s/pulumi-%s/releases/tags/v%s",
 		source.organization, source.name, version.String())
-	logging.V(9).Infof("plugin GitHub releases url: %s", releaseURL)
+	logf(9, sourceLogFields(source.kind, source.name), "plugin GitHub releases url: %s", releaseURL)
 
 	req, err := buildHTTPRequest(releaseURL, source.token)
 	if err != nil {
@@ -305,7 +490,8 @@ func (source *githubSource) Download(
 	}
 	jsonBody, err := ioutil.ReadAll(resp)
 	if err != nil {
-		logging.V(9).Infof("cannot unmarshal github response len(%d): %s", length, err.Error())
+		logf(9, sourceLogFields(source.kind, source.name),
+			"cannot unmarshal github response len(%d): %s", length, err.Error())
 		return nil, -1, err
 	}
 	release := struct {
@@ -316,8 +502,8 @@ func (source *githubSource) Download(
 	}{}
 	err = json.Unmarshal(jsonBody, &release)
 	if err != nil {
-		logging.V(9).Infof("github json response: %s", jsonBody)
-		logging.V(9).Infof("cannot unmarshal github response: %s", err.Error())
+		logf(9, sourceLogFields(source.kind, source.name), "github json response: %s", jsonBody)
+		logf(9, sourceLogFields(source.kind, source.name), "cannot unmarshal github response: %s", err.Error())
 		return nil, -1, err
 	}
 	assetURL := ""
@@ -327,12 +513,14 @@ func (source *githubSource) Download(
 		}
 	}
 	if assetURL == "" {
-		logging.V(9).Infof("github json response: %s", jsonBody)
-		logging.V(9).Infof("plugin asset '%s' not found", assetName)
-		return nil, -1, errors.Errorf("plugin asset '%s' not found", assetName)
+		logf(9, sourceLogFields(source.kind, source.name), "github json response: %s", jsonBody)
+		logf(9, sourceLogFields(source.kind, source.name), "plugin asset '%s' not found", assetName)
+		return nil, -1, classifyPluginError(ErrNotFound, fmt.Errorf(
+			"plugin asset '%s' not found: release v%s of github.com/%s/pulumi-%s exists but has no asset for this platform",
+			assetName, version, source.organization, source.name))
 	}
 
-	logging.V(1).Infof("%s downloading from %s", source.name, assetURL)
+	logf(1, sourceLogFields(source.kind, source.name), "%s downloading from %s", source.name, assetURL)
 
 	req, err = buildHTTPRequest(assetURL, source.token)
 	if err != nil {
@@ -342,7 +530,8 @@ func (source *githubSource) Download(
 	return getHTTPResponse(req)
 }
 
-// pluginURLSource can download a plugin from a given PluginDownloadURL, it doesn't support GetLatestVersion
+// pluginURLSource can download a plugin from a given PluginDownloadURL. It finds the plugin's latest version in the
+// pluginURLIndexFile at the root of the URL, if the server hosts one.
 type pluginURLSource struct {
 	name              string
 	kind              PluginKind
@@ -359,116 +548,258 @@ func newPluginURLSource(name string, kind PluginKind, pluginDownloadURL string)
 
 func (source *pluginURLSource) GetLatestVersion(
 	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
-	return nil, errors.New("GetLatestVersion is not supported for plugins using PluginDownloadURL")
+	indexURL, index, err := source.fetchIndex(getHTTPResponse)
+	if err != nil {
+		return nil, err
+	}
+	latest := index.latestVersion(source.kind, source.name)
+	if latest == nil {
+		return nil, fmt.Errorf("the index of %s plugin %s at %s lists no released versions",
+			source.kind, source.name, indexURL)
+	}
+	return latest, nil
+}
+
+// ListVersions implements versionListingSource, using the versions the pluginURLIndexFile lists.
+func (source *pluginURLSource) ListVersions(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, error) {
+	_, index, err := source.fetchIndex(getHTTPResponse)
+	if err != nil {
+		return nil, err
+	}
+	return index.versions(source.kind, source.name), nil
+}
+
+// fetchIndex fetches the pluginURLIndexFile at the root of the PluginDownloadURL, returning the URL it was fetched
+// from alongside it.
+func (source *pluginURLSource) fetchIndex(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (string, PluginIndex, error) {
+	indexURL, err := pluginURLIndexURL(source.pluginDownloadURL, source.kind, source.name)
+	if err != nil {
+		return "", PluginIndex{}, err
+	}
+	token, err := pluginBackendAccessToken(indexURL)
+	if err != nil {
+		logf(5, sourceLogFields(source.kind, source.name), "fetching %s without credentials: %v", indexURL, err)
+	}
+	index, err := fetchPluginIndex(indexURL, token, getHTTPResponse)
+	if err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+			return "", PluginIndex{}, fmt.Errorf("GetLatestVersion is not supported for plugins using "+
+				"PluginDownloadURL unless the server hosts an index of their versions at %s", indexURL)
+		}
+		return "", PluginIndex{}, err
+	}
+	return indexURL, index, nil
 }
 
 func (source *pluginURLSource) Download(
 	version semver.Version, opSy string, arch string,
 	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
-	serverURL := source.pluginDownloadURL
-	logging.V(1).Infof("%s downloading from %s", source.name, serverURL)
+	serverURL, err := expandURLEnv(source.pluginDownloadURL)
+	if err != nil {
+		return nil, -1, err
+	}
+	logf(1, sourceLogFields(source.kind, source.name), "%s downloading from %s", source.name, serverURL)
 
-	serverURL = interpolateURL(serverURL, version, opSy, arch)
-	serverURL = strings.TrimSuffix(serverURL, "/")
+	serverURL, file := splitPluginURL(serverURL, source.kind, source.name, version, opSy, arch)
 
-	logging.V(1).Infof("%s downloading from %s", source.name, serverURL)
-	endpoint := fmt.Sprintf("%s/%s",
-		serverURL,
-		url.QueryEscape(fmt.Sprintf("pulumi-%s-%s-v%s-%s-%s.tar.gz", source.kind, source.name, version.String(), opSy, arch)))
+	logf(1, sourceLogFields(source.kind, source.name), "%s downloading from %s", source.name, serverURL)
+	endpoint := fmt.Sprintf("%s/%s", serverURL, url.QueryEscape(file))
+	if i := strings.IndexAny(serverURL, "?#"); i >= 0 {
+		endpoint = fmt.Sprintf("%s/%s%s", serverURL[:i], url.QueryEscape(file), serverURL[i:])
+	}
 
-	req, err := buildHTTPRequest(endpoint, "")
+	// Plugins hosted by a Pulumi backend the user is logged in to are downloaded with its access token.
+	token, err := pluginBackendAccessToken(endpoint)
+	if err != nil {
+		logf(5, sourceLogFields(source.kind, source.name), "downloading %s without credentials: %v", endpoint, err)
+	}
+
+	req, err := buildHTTPRequest(endpoint, token)
 	if err != nil {
 		return nil, -1, err
 	}
 	return getHTTPResponse(req)
 }
 
-// fallbackSource handles our current complicated default logic of trying the pulumi public github, then maybe
-// the users private github, then get.pulumi.com
+// fallbackSource tries each of the default plugin sources in turn. Unless PluginDefaultSourcesEnvVar or
+// PluginConfigFile configure others, that's our current complicated default logic of trying the pulumi public github,
+// then maybe the users private github, then get.pulumi.com.
 type fallbackSource struct {
-	name string
-	kind PluginKind
+	name    string
+	kind    PluginKind
+	sources []string // the configured default sources, or nil to use the built-in ones.
+	owners  []string // the configured GitHub owners of private releases, in the order they're tried.
 }
 
-func newFallbackSource(name string, kind PluginKind) *fallbackSource {
+func newFallbackSource(name string, kind PluginKind, sources, owners []string) *fallbackSource {
 	return &fallbackSource{
-		name: name,
-		kind: kind,
+		name:    name,
+		kind:    kind,
+		sources: sources,
+		owners:  owners,
 	}
 }
 
-func (source *fallbackSource) GetLatestVersion(
-	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
-	// Try and get this package from public pulumi github
-	public := newGithubSource("pulumi", source.name, source.kind)
-	version, err := public.GetLatestVersion(getHTTPResponse)
-	if err == nil {
-		return version, nil
+// fallbackEntry is one of the sources a fallbackSource tries, with the label it's described by in errors.
+type fallbackEntry struct {
+	label  string
+	source PluginSource
+}
+
+// entries returns the sources to try, in order.
+func (source *fallbackSource) entries() []fallbackEntry {
+	sources := source.sources
+	if sources == nil {
+		sources = []string{PluginSourceGitHub}
+		// Try the releases of private GitHub owners, if any are configured or we're in experimental mode.
+		if _, ok := os.LookupEnv("PULUMI_EXPERIMENTAL"); ok || len(source.owners) > 0 {
+			sources = append(sources, PluginSourcePrivateGitHub)
+		}
+		sources = append(sources, PluginSourceGetPulumi)
+	}
+
+	var entries []fallbackEntry
+	for _, name := range sources {
+		if name == PluginSourcePrivateGitHub {
+			entries = append(entries, source.privateGitHubEntries()...)
+		} else {
+			entries = append(entries, source.entry(name))
+		}
 	}
+	return entries
+}
 
-	// Are we in experimental mode? Try a users private github release
-	if _, ok := os.LookupEnv("PULUMI_EXPERIMENTAL"); ok {
+// privateGitHubEntries returns the sources of the releases of each of the configured GitHub owners, in order.
+func (source *fallbackSource) privateGitHubEntries() []fallbackEntry {
+	if len(source.owners) == 0 {
+		entry := fallbackEntry{label: "private github"}
 		// Check if we have a repo owner set
-		repoOwner := os.Getenv("GITHUB_REPOSITORY_OWNER")
-		var privateErr error
-		if repoOwner == "" {
-			privateErr = errors.New("ENV[GITHUB_REPOSITORY_OWNER] not set")
+		if repoOwner := os.Getenv("GITHUB_REPOSITORY_OWNER"); repoOwner == "" {
+			entry.source = &errorSource{err: fmt.Errorf("neither %s nor ENV[GITHUB_REPOSITORY_OWNER] is set",
+				PluginGitHubOwnersEnvVar)}
+		} else if private := newGithubSource(repoOwner, source.name, source.kind); !private.HasAuthentication() {
+			entry.source = &errorSource{err: errors.New("no GitHub authentication information provided")}
 		} else {
-			private := newGithubSource(repoOwner, source.name, source.kind)
-			if !private.HasAuthentication() {
-				privateErr = errors.New("no GitHub authentication information provided")
-			} else {
-				version, privateErr = private.GetLatestVersion(getHTTPResponse)
-				if privateErr == nil {
-					return version, nil
-				}
-			}
+			entry.source = private
+		}
+		return []fallbackEntry{entry}
+	}
+
+	// Configured owners may release publicly, so they're tried even without a token.
+	entries := make([]fallbackEntry, len(source.owners))
+	for i, owner := range source.owners {
+		entries[i] = fallbackEntry{
+			label:  owner + " github",
+			source: newGithubSource(owner, source.name, source.kind),
 		}
+	}
+	return entries
+}
+
+// entry returns the source a default source names.
+func (source *fallbackSource) entry(name string) fallbackEntry {
+	switch name {
+	case PluginSourceGitHub:
+		return fallbackEntry{label: "Pulumi github", source: newGithubSource("pulumi", source.name, source.kind)}
+	case PluginSourceGetPulumi:
+		return fallbackEntry{label: "get.pulumi.com", source: newGetPulumiSource(source.name, source.kind)}
+	default:
+		// Mirrors are served like a PluginDownloadURL.
+		info := PluginInfo{Name: source.name, Kind: source.kind, PluginDownloadURL: name}
+		return fallbackEntry{label: name, source: info.getSource(nil)}
+	}
+}
 
-		logging.V(1).Infof("cannot find plugin %s on private GitHub releases: %s", source.name, privateErr.Error())
+// versionError returns the error from looking up the plugin's versions in each of the given sources, or in none.
+func (source *fallbackSource) versionError(entries []fallbackEntry, errs []error) error {
+	switch len(errs) {
+	case 0:
+		return fmt.Errorf("none of the default sources of %s plugin %s can look up its versions",
+			source.kind, source.name)
+	case 1:
+		return errs[0]
+	}
+	var others strings.Builder
+	for i, err := range errs[1:] {
+		fmt.Fprintf(&others, "\nand from %s: %s", entries[i+1].label, err.Error())
+	}
+	return fmt.Errorf("error getting version from %s: %w%s", entries[0].label, errs[0], others.String())
+}
 
-		return nil, fmt.Errorf(
-			"error getting version from Pulumi github: %w\nand from private github: %s",
-			err, privateErr.Error())
+func (source *fallbackSource) GetLatestVersion(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
+	var tried []fallbackEntry
+	var errs []error
+	for _, entry := range source.entries() {
+		// get.pulumi.com can't look up versions, only download them.
+		if _, ok := entry.source.(*getPulumiSource); ok {
+			continue
+		}
+		version, err := entry.source.GetLatestVersion(getHTTPResponse)
+		if err == nil {
+			return version, nil
+		}
+		logf(1, sourceLogFields(source.kind, source.name),
+			"cannot find the latest version of plugin %s on %s: %s", source.name, entry.label, err.Error())
+		tried, errs = append(tried, entry), append(errs, err)
 	}
+	return nil, source.versionError(tried, errs)
+}
 
-	return nil, err
+// ListVersions implements versionListingSource, using the versions of the first default source that lists them.
+func (source *fallbackSource) ListVersions(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, error) {
+	var tried []fallbackEntry
+	var errs []error
+	for _, entry := range source.entries() {
+		if _, ok := entry.source.(*getPulumiSource); ok {
+			continue
+		}
+		versions, err := listSourceVersions(entry.source, getHTTPResponse)
+		if err == nil {
+			return versions, nil
+		}
+		tried, errs = append(tried, entry), append(errs, err)
+	}
+	return nil, source.versionError(tried, errs)
 }
 
 func (source *fallbackSource) Download(
 	version semver.Version, opSy string, arch string,
 	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
-	// Try and get this package from public pulumi github
-	public := newGithubSource("pulumi", source.name, source.kind)
-	resp, length, err := public.Download(version, opSy, arch, getHTTPResponse)
-	if err == nil {
-		return resp, length, nil
-	}
-
-	// Are we in experimental mode? Try a private github release
-	if _, ok := os.LookupEnv("PULUMI_EXPERIMENTAL"); ok {
-		// Check if we have a repo owner set
-		repoOwner := os.Getenv("GITHUB_REPOSITORY_OWNER")
-		if repoOwner == "" {
-			err = errors.New("ENV[GITHUB_REPOSITORY_OWNER] not set")
-		} else {
-			private := newGithubSource(repoOwner, source.name, source.kind)
-			if !private.HasAuthentication() {
-				err = errors.New("no GitHub authentication information provided")
-			} else {
-				resp, length, err := private.Download(version, opSy, arch, getHTTPResponse)
-				if err == nil {
-					return resp, length, nil
-				}
+	entries := source.entries()
+	if len(entries) == 0 {
+		return nil, -1, fmt.Errorf("no default sources are configured for %s plugin %s", source.kind, source.name)
+	}
+	downloadErr := &DownloadError{Kind: source.kind, Name: source.name, Version: version}
+	for _, entry := range entries {
+		attempt := DownloadAttempt{Source: entry.label}
+		resp, length, err := entry.source.Download(version, opSy, arch,
+			func(req *http.Request) (io.ReadCloser, int64, error) {
+				attempt.URL = req.URL.String()
+				return getHTTPResponse(req)
+			})
+		if err == nil {
+			return resp, length, nil
+		}
+		logf(1, sourceLogFields(source.kind, source.name),
+			"cannot find plugin %s on %s: %s", source.name, entry.label, err.Error())
+
+		// Every source's error is returned, so the reason the download failed on the first isn't hidden.
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) {
+			attempt.StatusCode = httpErr.StatusCode
+			if httpErr.URL != "" {
+				attempt.URL = httpErr.URL
 			}
 		}
-
-		logging.V(1).Infof("cannot find plugin %s on private GitHub releases: %s", source.name, err.Error())
+		attempt.Err = err
+		downloadErr.Attempts = append(downloadErr.Attempts, attempt)
 	}
-
-	// Fallback to get.pulumi.com
-	pulumi := newGetPulumiSource(source.name, source.kind)
-	return pulumi.Download(version, opSy, arch, getHTTPResponse)
+	return nil, -1, downloadErr
 }
 
 // PluginInfo provides basic information about a plugin.  Each plugin gets installed into a system-wide
@@ -479,11 +810,15 @@ type PluginInfo struct {
 	Path              string          // the path that a plugin was loaded from.
 	Kind              PluginKind      // the kind of the plugin (language, resource, etc).
 	Version           *semver.Version // the plugin's semantic version, if present.
+	VersionRange      string          // if Version isn't set, a range of versions to download the latest of.
 	Size              int64           // the size of the plugin, in bytes.
 	InstallTime       time.Time       // the time the plugin was installed.
 	LastUsedTime      time.Time       // the last time the plugin was used.
 	PluginDownloadURL string          // an optional server to use when downloading this plugin.
 	PluginDir         string          // if set, will be used as the root plugin dir instead of ~/.pulumi/plugins.
+	Variant           string          // an alternate build of the plugin's version, such as "debug", if any.
+
+	ctx *Context // the context that set the plugin up, if any.
 }
 
 // Dir gets the expected plugin directory for this plugin.
@@ -513,17 +848,22 @@ func (info PluginInfo) FileSuffix() string {
 	return ""
 }
 
-// DirPath returns the directory where this plugin should be installed.
+// DirPath returns the directory where this plugin should be installed: in its PluginDir if set, and otherwise in the
+// directory plugins of its kind are installed into.
 func (info PluginInfo) DirPath() (string, error) {
 	var err error
 	dir := info.PluginDir
 	if dir == "" {
-		dir, err = GetPluginDir()
+		dir, err = GetPluginKindDir(info.Kind)
 		if err != nil {
 			return "", err
 		}
 	}
 
+	if info.Variant != "" {
+		// Variants are installed alongside the release build, where lookups of the release build won't find them.
+		dir = filepath.Join(dir, pluginVariantsDir, info.Variant)
+	}
 	return filepath.Join(dir, info.Dir()), nil
 }
 
@@ -547,29 +887,67 @@ func (info PluginInfo) PartialFilePath() (string, error) {
 	return fmt.Sprintf("%s.partial", dir), nil
 }
 
-// FilePath returns the full path where this plugin's primary executable should be installed.
+// FilePath returns the full path where this plugin's primary executable should be installed. On Windows, plugins may
+// also be script entry points (`.cmd`, `.bat` or `.ps1`), so if the plugin is installed the first of the candidate
+// files that exists is returned; otherwise the `.exe` path is returned.
 func (info PluginInfo) FilePath() (string, error) {
 	dir, err := info.DirPath()
 	if err != nil {
 		return "", err
 	}
+	if path, ok := findPluginExecutable(dir, info.FilePrefix(), getCandidateExtensions()); ok {
+		return path, nil
+	}
 	return filepath.Join(dir, info.File()), nil
 }
 
+// findPluginExecutable probes dir for a file named prefix plus one of the given extensions, returning the first that
+// exists.
+func findPluginExecutable(dir, prefix string, exts []string) (string, bool) {
+	for _, ext := range exts {
+		candidate := filepath.Join(dir, prefix+ext)
+		if stat, err := os.Stat(candidate); err == nil && !stat.IsDir() {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
 // Delete removes the plugin from the cache.  It also deletes any supporting files in the cache, which includes
-// any files that contain the same prefix as the plugin itself.
+// any files that contain the same prefix as the plugin itself. Symbolic links are removed without touching what they
+// point to, so deleting a plugin that's linked to a development tree, or links to a shared virtual environment,
+// leaves that in place. If the plugin's install receipt lists the files its install created, only those are removed,
+// and any that were changed or added since are kept in the plugin cache's `.kept` directory.
 func (info PluginInfo) Delete() error {
 	dir, err := info.DirPath()
 	if err != nil {
 		return err
 	}
-	if err := os.RemoveAll(dir); err != nil {
-		return err
+	forgetPluginPaths(info.Kind, info.Name)
+	removeUnsealedPlugin(info)
+	// os.RemoveAll doesn't follow the links inside the directory. If the directory is a link itself, only it goes.
+	// Plugins whose receipts list their files have exactly those removed.
+	if stat, err := os.Lstat(dir); err == nil && stat.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(dir); err != nil {
+			return err
+		}
+	} else if removed, err := info.removeInstalledFiles(dir); err != nil {
+		return fmt.Errorf("removing the installed files of plugin %s: %w", info, err)
+	} else if !removed {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
 	}
 	// Attempt to delete any leftover .partial or .lock files.
 	// Don't fail the operation if we can't delete these.
 	contract.IgnoreError(os.Remove(fmt.Sprintf("%s.partial", dir)))
 	contract.IgnoreError(os.Remove(fmt.Sprintf("%s.lock", dir)))
+	// Nor if the shared virtual environment the plugin used, if any, can't be removed now nothing else uses it.
+	if root, err := info.pluginCacheDir(); err == nil {
+		if _, err := pruneSharedVirtualEnvs(root); err != nil {
+			info.logf(5, "could not remove unused shared virtual environments: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -581,79 +959,293 @@ func (info *PluginInfo) SetFileMetadata(path string) error {
 		return err
 	}
 
-	// Next, get the size from the directory (or, if there is none, just the file).
-	size, err := getPluginSize(path)
+	// Next, get the size from the directory (or, if there is none, just the file), leaving out caches and the like.
+	excludes, err := info.context().getPluginSizeExcludes()
+	if err != nil {
+		info.warnf("using the default plugin size excludes: %v", err)
+		excludes = DefaultPluginSizeExcludes
+	}
+	size, err := getPluginSize(path, excludes)
 	if err != nil {
-		return errors.Wrapf(err, "getting plugin dir %s size", path)
+		return fmt.Errorf("getting plugin dir %s size: %w", path, err)
 	}
 	info.Size = size
 
-	// Next get the access times from the plugin binary itself.
+	// Installs stamp the directory's modification time with the time they completed, and loads stamp its access time.
 	tinfo := times.Get(file)
-
-	if tinfo.HasBirthTime() {
-		info.InstallTime = tinfo.BirthTime()
-	}
-
+	info.InstallTime = tinfo.ModTime()
 	info.LastUsedTime = tinfo.AccessTime()
 	return nil
 }
 
-func interpolateURL(serverURL string, version semver.Version, os, arch string) string {
+// interpolateURL replaces the `${NAME}`, `${KIND}`, `${VERSION}`, `${OS}`, `${ARCH}` and `${FILENAME}` placeholders
+// in a PluginDownloadURL with those of the plugin's tarball for the given platform. `${FILENAME}` is the tarball's
+// name, as pluginTarballName returns it.
+func interpolateURL(serverURL string, kind PluginKind, name string, version semver.Version, os, arch string) string {
 	replacer := strings.NewReplacer(
+		"${NAME}", url.QueryEscape(name),
+		"${KIND}", url.QueryEscape(string(kind)),
 		"${VERSION}", url.QueryEscape(version.String()),
 		"${OS}", url.QueryEscape(os),
-		"${ARCH}", url.QueryEscape(arch))
+		"${ARCH}", url.QueryEscape(arch),
+		"${FILENAME}", url.QueryEscape(pluginTarballName(kind, name, version, os, arch)))
 	return replacer.Replace(serverURL)
 }
 
+// cutURLPath splits a URL into the part before the last segment of its path, the last segment, and the URL's query.
+func cutURLPath(rawURL string) (string, string, string) {
+	query := ""
+	if i := strings.IndexAny(rawURL, "?#"); i >= 0 {
+		rawURL, query = rawURL[:i], rawURL[i:]
+	}
+	rawURL = strings.TrimSuffix(rawURL, "/")
+	if scheme := strings.Index(rawURL, "://"); scheme < 0 || strings.LastIndex(rawURL, "/") < scheme+3 {
+		return rawURL, "", query
+	}
+	slash := strings.LastIndex(rawURL, "/")
+	return rawURL[:slash], rawURL[slash+1:], query
+}
+
+// namesPluginFile returns true if a PluginDownloadURL is the URL of the plugin's tarball, rather than of the
+// directory holding it. That's the case if its last segment contains `${FILENAME}`, or ends in `.tar.gz` or `.tgz`,
+// as in `https://plugins.corp/${NAME}/${VERSION}/${NAME}-${OS}-${ARCH}.tgz`, which lets stores name tarballs their
+// own way.
+func namesPluginFile(downloadURL string) bool {
+	_, file, _ := cutURLPath(downloadURL)
+	return strings.Contains(file, "${FILENAME}") || strings.HasSuffix(file, ".tar.gz") || strings.HasSuffix(file, ".tgz")
+}
+
+// splitPluginURL interpolates a PluginDownloadURL for the plugin's tarball for the given platform, returning the URL
+// of the directory it's in, along with the tarball's name.
+func splitPluginURL(downloadURL string, kind PluginKind, name string, version semver.Version,
+	os, arch string) (string, string) {
+	interpolated := interpolateURL(downloadURL, kind, name, version, os, arch)
+	if !namesPluginFile(downloadURL) {
+		return strings.TrimSuffix(interpolated, "/"), pluginTarballName(kind, name, version, os, arch)
+	}
+	dir, file, query := cutURLPath(interpolated)
+	if unescaped, err := url.QueryUnescape(file); err == nil {
+		file = unescaped
+	}
+	return dir + query, file
+}
+
+// Mirrors returns the plugin indexes, from PluginIndexURLsEnvVar or PluginConfigFile, that the plugin is looked for in
+// before its default source, in the order they're tried. Plugins that have a PluginDownloadURL or a download URL
+// override, or that are downloaded through the backend proxy, aren't looked for in any.
+func (info PluginInfo) Mirrors() []string {
+	if pluginDownloadProxyEnabled() || info.PluginDownloadURL != "" {
+		return nil
+	}
+	// Plugins with an invalid override aren't looked for in any either, since their source fails.
+	if _, ok, err := info.context().pluginDownloadURLOverride(info); ok || err != nil {
+		return nil
+	}
+	mirrors, err := getPluginMirrors()
+	if err != nil {
+		info.warnf("ignoring plugin mirrors: %v", err)
+	}
+	return mirrors
+}
+
 func (info PluginInfo) GetSource() PluginSource {
+	return info.getSource(info.Mirrors())
+}
+
+// getSource returns the plugin's source, looking for it in the given mirrors first.
+func (info PluginInfo) getSource(mirrors []string) PluginSource {
+	// In proxy mode, the backend downloads every plugin on our behalf, from wherever it would otherwise come from.
+	if pluginDownloadProxyEnabled() {
+		return newBackendProxySource(info.Name, info.Kind, info.PluginDownloadURL)
+	}
+
 	// The plugin has a set URL use that.
 	if info.PluginDownloadURL != "" {
+		if strings.HasPrefix(info.PluginDownloadURL, TerraformRegistryScheme) {
+			if fips, err := info.context().fipsMode(); err != nil {
+				return &errorSource{err: err}
+			} else if fips {
+				return &errorSource{err: errFIPSMode("%s plugin %s is downloaded from a Terraform registry, whose "+
+					"checksums are signed with OpenPGP", info.Kind, info.Name)}
+			}
+			source, err := newTerraformRegistrySource(info.Name, info.Kind, info.PluginDownloadURL)
+			if err != nil {
+				return &errorSource{err: err}
+			}
+			return source
+		}
+		switch {
+		case strings.HasPrefix(info.PluginDownloadURL, S3Scheme):
+			return newS3Source(info.Name, info.Kind, info.PluginDownloadURL, info.context())
+		case strings.HasPrefix(info.PluginDownloadURL, GCSScheme):
+			return newGCSSource(info.Name, info.Kind, info.PluginDownloadURL, info.context())
+		case strings.HasPrefix(info.PluginDownloadURL, AzureBlobScheme):
+			return newAzureBlobSource(info.Name, info.Kind, info.PluginDownloadURL, info.context())
+		case strings.HasPrefix(info.PluginDownloadURL, GitLabScheme):
+			source, err := newGitLabSource(info.Name, info.Kind, info.PluginDownloadURL)
+			if err != nil {
+				return &errorSource{err: err}
+			}
+			return source
+		case strings.HasPrefix(info.PluginDownloadURL, ArtifactoryScheme):
+			return newGenericRepoSource(info.Name, info.Kind, ArtifactoryScheme, info.PluginDownloadURL, info.context())
+		case strings.HasPrefix(info.PluginDownloadURL, NexusScheme):
+			return newGenericRepoSource(info.Name, info.Kind, NexusScheme, info.PluginDownloadURL, info.context())
+		case strings.HasPrefix(info.PluginDownloadURL, FileScheme):
+			return newFileSource(info.Name, info.Kind, info.PluginDownloadURL)
+		case strings.HasPrefix(info.PluginDownloadURL, GitScheme):
+			return newGitSource(info.Name, info.Kind, info.PluginDownloadURL)
+		}
 		return newPluginURLSource(info.Name, info.Kind, info.PluginDownloadURL)
 	}
 
 	// If the plugin name matches an override, download the plugin from the override URL.
-	if url, ok := pluginDownloadURLOverridesParsed.get(info.Name); ok {
+	if url, ok, err := info.context().pluginDownloadURLOverride(info); err != nil {
+		return &errorSource{err: err}
+	} else if ok {
 		return newPluginURLSource(info.Name, info.Kind, url)
 	}
 
-	// Use our default fallback behaviour of github then get.pulumi.com
-	return newFallbackSource(info.Name, info.Kind)
+	// Use our default fallback behaviour of github then get.pulumi.com, unless other default sources are configured.
+	defaultSources, err := info.context().getPluginDefaultSources()
+	if err != nil {
+		return &errorSource{err: err}
+	}
+	owners, err := info.context().getPluginGitHubOwners()
+	if err != nil {
+		return &errorSource{err: err}
+	}
+	var source PluginSource = newFallbackSource(info.Name, info.Kind, defaultSources, owners)
+
+	// If any plugin indexes are configured, look for the plugin in them first.
+	if len(mirrors) > 0 {
+		source = newPluginIndexSource(mirrors, info.Name, info.Kind, source)
+	}
+	return source
+}
+
+// pluginSource returns the plugin's source, looking for it in the given mirrors first, unless the context overrides
+// the plugin's source.
+func (ctx *Context) pluginSource(info PluginInfo, mirrors []string) PluginSource {
+	if ctx.PluginSource != nil {
+		return ctx.PluginSource(info)
+	}
+	return info.getSource(mirrors)
 }
 
 // GetLatestVersion tries to find the latest version for this plugin. This is currently only supported for
 // plugins we can get from github releases.
 func (info PluginInfo) GetLatestVersion() (*semver.Version, error) {
-	source := info.GetSource()
-	return source.GetLatestVersion(getHTTPResponse)
+	return (&Context{}).GetLatestVersion(info)
 }
 
-// Download fetches an io.ReadCloser for this plugin and also returns the size of the response (if known).
+// GetLatestVersion finds the latest version of the plugin like PluginInfo.GetLatestVersion, sending requests with the
+// context's HTTP client.
+func (ctx *Context) GetLatestVersion(info PluginInfo) (*semver.Version, error) {
+	source := ctx.pluginSource(info, info.Mirrors())
+	if ctx.prereleases() {
+		return ctx.getLatestPrerelease(info, source)
+	}
+	return source.GetLatestVersion(ctx.getHTTPResponse)
+}
+
+// Download fetches an io.ReadCloser for this plugin and also returns the size of the response (if known). Plugins with
+// a VersionRange instead of a Version are downloaded at the version ResolveVersionRange resolves it to.
 func (info PluginInfo) Download() (io.ReadCloser, int64, error) {
-	// Figure out the OS/ARCH pair for the download URL.
-	var opSy string
-	switch runtime.GOOS {
-	case "darwin", "linux", "windows":
-		opSy = runtime.GOOS
-	default:
-		return nil, -1, errors.Errorf("unsupported plugin OS: %s", runtime.GOOS)
+	return info.DownloadFromMirror(0)
+}
+
+// DownloadFromMirror downloads the plugin like Download, but skips the given number of its Mirrors. Skipping all of
+// them downloads the plugin from its default source.
+func (info PluginInfo) DownloadFromMirror(skip int) (io.ReadCloser, int64, error) {
+	return (&Context{}).DownloadFromMirror(info, skip)
+}
+
+// Download downloads the plugin like PluginInfo.Download, sending requests with the context's HTTP client.
+func (ctx *Context) Download(info PluginInfo) (io.ReadCloser, int64, error) {
+	return ctx.DownloadFromMirror(info, 0)
+}
+
+// DownloadFromMirror downloads the plugin like PluginInfo.DownloadFromMirror, sending requests with the context's
+// HTTP client.
+func (ctx *Context) DownloadFromMirror(info PluginInfo, skip int) (io.ReadCloser, int64, error) {
+	if info.ctx == nil {
+		info.ctx = ctx
 	}
-	var arch string
-	switch runtime.GOARCH {
-	case "amd64", "arm64":
-		arch = runtime.GOARCH
-	default:
-		return nil, -1, errors.Errorf("unsupported plugin architecture: %s", runtime.GOARCH)
+	resolved, err := ctx.ResolveVersionRange(info)
+	if err != nil {
+		return nil, -1, err
+	}
+	info = resolved
+	tgz, length, err := ctx.downloadFromMirror(info, skip)
+	if err != nil {
+		return nil, -1, err
+	}
+	watched, err := ctx.watchDownload(info, tgz, length, skip)
+	if err != nil {
+		return nil, -1, err
+	} else if watched != tgz {
+		watched = withExtractedSizeOf(watched, tgz)
+	}
+	return watched, length, nil
+}
+
+// downloadFromMirror downloads the plugin like DownloadFromMirror, without watching for the download to stall.
+func (ctx *Context) downloadFromMirror(info PluginInfo, skip int) (io.ReadCloser, int64, error) {
+	mirrors := info.Mirrors()
+	contract.Requiref(skip >= 0 && skip <= len(mirrors), "skip", "must be between 0 and %d", len(mirrors))
+
+	// Figure out the OS/ARCH pairs to try for the download URL. The host platform is usually tried first, followed by
+	// any platforms configured as fallbacks for it.
+	platforms, err := getDownloadPlatforms(HostPlatform())
+	if err != nil {
+		return nil, -1, err
 	}
 
 	// The plugin version is necessary for the endpoint. If it's not present, return an error.
 	if info.Version == nil {
-		return nil, -1, errors.Errorf("unknown version for plugin %s", info.Name)
+		return nil, -1, fmt.Errorf("unknown version for plugin %s", info.Name)
+	}
+
+	if err := runBeforePluginHooks(PluginHookEvent{Phase: BeforePluginDownload, Plugin: info}); err != nil {
+		return nil, -1, err
+	}
+	source := ctx.pluginSource(info, mirrors[skip:])
+	resp, length, err := downloadForPlatforms(info, source, *info.Version, platforms, ctx.getHTTPResponse)
+	runAfterPluginHooks(PluginHookEvent{Phase: AfterPluginDownload, Plugin: info, Size: length, Err: err})
+	if err != nil && limitedPluginArches[platforms[0].Arch] {
+		return nil, -1, &UnsupportedAssetError{Info: info, Platform: platforms[0], Err: err}
+	} else if err != nil {
+		return nil, -1, err
 	}
+	return withEstimatedExtractedSize(resp, length), length, nil
+}
+
+// downloadForPlatforms tries to download the plugin for each of the given platforms in order, returning the first
+// successful download. The next platform is only tried if there's no build of the plugin for the last one, so failures
+// such as server or authentication errors don't install another platform's build in its place. If every platform
+// fails, the error for the first (preferred) platform is returned.
+func downloadForPlatforms(info PluginInfo, source PluginSource, version semver.Version, platforms []Platform,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	contract.Require(len(platforms) > 0, "platforms")
 
-	source := info.GetSource()
-	return source.Download(*info.Version, opSy, arch, getHTTPResponse)
+	var firstErr error
+	for i, platform := range platforms {
+		if i > 0 {
+			info.logf(1, "retrying download using fallback platform %s", platform)
+		}
+		resp, length, err := downloadPlatform(info, source, version, platform, getHTTPResponse)
+		if err == nil {
+			return resp, length, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrUnsupportedPlatform) {
+			return nil, -1, err
+		}
+	}
+	return nil, -1, firstErr
 }
 
 func buildHTTPRequest(pluginEndpoint string, token string) (*http.Request, error) {
@@ -665,40 +1257,25 @@ func buildHTTPRequest(pluginEndpoint string, token string) (*http.Request, error
 	userAgent := fmt.Sprintf("pulumi-cli/1 (%s; %s)", version.Version, runtime.GOOS)
 	req.Header.Set("User-Agent", userAgent)
 
+	// Tokens configured for GitHub hosts are sent to them before the credentials of PluginConfigFile.
+	githubToken, err := gitHubTokenForURL(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		token = githubToken
+	}
 	if token != "" {
 		req.Header.Set("Authorization", fmt.Sprintf("token %s", token))
+	} else if err := applyPluginAuth(req); err != nil {
+		return nil, err
+	} else if err := applyPluginCredentials(req); err != nil {
+		return nil, err
 	}
 
 	return req, nil
 }
 
-func getHTTPResponse(req *http.Request) (io.ReadCloser, int64, error) {
-	logging.V(9).Infof("full plugin download url: %s", req.URL)
-	logging.V(9).Infof("plugin install request headers: %v", req.Header)
-
-	resp, err := httputil.DoWithRetry(req, http.DefaultClient)
-	if err != nil {
-		return nil, -1, err
-	}
-
-	logging.V(9).Infof("plugin install response headers: %v", resp.Header)
-
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-
-		errmsg := "%d HTTP error fetching plugin from %s"
-
-		if req.URL.Host == "api.github.com" && resp.StatusCode == 404 {
-			errmsg += ". If this is a private GitHub repository, try " +
-				"providing a token via the GITHUB_TOKEN environment variable. " +
-				"See: https://github.com/settings/tokens"
-		}
-
-		return nil, -1, errors.Errorf(errmsg, resp.StatusCode, req.URL)
-	}
-
-	return resp.Body, resp.ContentLength, nil
-}
-
 // installLock acquires a file lock used to prevent concurrent installs.
 func (info PluginInfo) installLock() (unlock func(), err error) {
 	finalDir, err := info.DirPath()
@@ -708,12 +1285,12 @@ func (info PluginInfo) installLock() (unlock func(), err error) {
 	lockFilePath := fmt.Sprintf("%s.lock", finalDir)
 
 	if err := os.MkdirAll(filepath.Dir(lockFilePath), 0700); err != nil {
-		return nil, errors.Wrap(err, "creating plugin root")
+		return nil, fmt.Errorf("creating plugin root: %w", notWritableError(filepath.Dir(lockFilePath), err))
 	}
 
 	mutex := fsutil.NewFileMutex(lockFilePath)
 	if err := mutex.Lock(); err != nil {
-		return nil, err
+		return nil, notWritableError(filepath.Dir(lockFilePath), err)
 	}
 	return func() {
 		contract.IgnoreError(mutex.Unlock())
@@ -732,8 +1309,30 @@ func (info PluginInfo) installLock() (unlock func(), err error) {
 // installed. The next time the plugin is installed, the old installation directory will be removed and replaced with
 // a fresh install.
 func (info PluginInfo) Install(tgz io.ReadCloser, reinstall bool) error {
+	return info.InstallWithProgress(tgz, reinstall, DefaultPluginInstallProgress())
+}
+
+// InstallWithProgress installs a plugin's tarball into the cache like Install, reporting the output of the
+// plugin's dependency install to progress as it runs. If progress is nil, that output is only shown if the dependency
+// install fails.
+func (info PluginInfo) InstallWithProgress(tgz io.ReadCloser, reinstall bool, progress PluginInstallProgress) error {
 	defer contract.IgnoreClose(tgz)
 
+	// The tarball is installed into the directory of its version, so a range must be resolved to the version that was
+	// downloaded first.
+	if info.Version == nil && info.VersionRange != "" {
+		return fmt.Errorf("the version range %q of plugin %s must be resolved with ResolveVersionRange before the "+
+			"plugin is installed", info.VersionRange, info.Name)
+	}
+
+	info.context().maybeMaintainPlugins()
+
+	// Install into the fallback plugin directory instead if the plugin directory isn't writable.
+	info, err := info.withWritablePluginDir()
+	if err != nil {
+		return err
+	}
+
 	// Fetch the directory into which we will expand this tarball.
 	finalDir, err := info.DirPath()
 	if err != nil {
@@ -751,7 +1350,7 @@ func (info PluginInfo) Install(tgz io.ReadCloser, reinstall bool) error {
 	if err := cleanupTempDirs(finalDir); err != nil {
 		// We don't want to fail the installation if there was an error cleaning up these old temp dirs.
 		// Instead, log the error and continue on.
-		logging.V(5).Infof("Install: Error cleaning up temp dirs: %s", err.Error())
+		info.logf(5, "Install: Error cleaning up temp dirs: %s", err.Error())
 	}
 
 	// Get the partial file path (e.g. <pluginsdir>/<kind>-<name>-<version>.partial).
@@ -765,7 +1364,7 @@ func (info PluginInfo) Install(tgz io.ReadCloser, reinstall bool) error {
 	if finalDirStatErr == nil {
 		_, partialFileStatErr := os.Stat(partialFilePath)
 		if partialFileStatErr != nil {
-			if !os.IsNotExist(partialFileStatErr) {
+			if !errors.Is(partialFileStatErr, os.ErrNotExist) {
 				return partialFileStatErr
 			}
 			if !reinstall {
@@ -781,22 +1380,69 @@ func (info PluginInfo) Install(tgz io.ReadCloser, reinstall bool) error {
 		if err := os.RemoveAll(finalDir); err != nil {
 			return err
 		}
-	} else if !os.IsNotExist(finalDirStatErr) {
+	} else if !errors.Is(finalDirStatErr, os.ErrNotExist) {
 		return finalDirStatErr
 	}
 
+	if err := runBeforePluginHooks(PluginHookEvent{Phase: BeforePluginInstall, Plugin: info, Dir: finalDir}); err != nil {
+		return err
+	}
+	err = info.installTarball(tgz, finalDir, partialFilePath, progress)
+	forgetPluginPaths(info.Kind, info.Name)
+	runAfterPluginHooks(PluginHookEvent{Phase: AfterPluginInstall, Plugin: info, Dir: finalDir, Err: err})
+	if err != nil {
+		return err
+	}
+
+	reportReleaseNotes(info, progress)
+	return nil
+}
+
+// PluginExtractOptions limits what is extracted from plugin tarballs. Whatever the options, a plugin's files are never
+// written outside of its install directory, and archive.UnsafePathError, archive.SymlinkError and archive.LimitError
+// are returned for tarballs that break the rules. By default, downloading, decompressing and writing a plugin's files
+// are pipelined, with a few MB of buffering between them.
+var PluginExtractOptions = archive.ExtractOptions{Readahead: 4 << 20}
+
+// installTarball extracts the plugin's tarball into finalDir and installs its dependencies. The partial file is
+// removed once the install is complete.
+func (info PluginInfo) installTarball(tgz io.ReadCloser, finalDir, partialFilePath string,
+	progress PluginInstallProgress) error {
+	// Make sure there's room to extract the plugin before starting, rather than running out part way through.
+	if err := checkPluginDiskSpace(info, tgz, filepath.Dir(finalDir)); err != nil {
+		return err
+	}
+
 	// Create an empty partial file to indicate installation is in-progress.
 	if err := ioutil.WriteFile(partialFilePath, nil, 0600); err != nil {
-		return err
+		return notWritableError(filepath.Dir(partialFilePath), err)
 	}
 
 	// Create the final directory.
 	if err := os.MkdirAll(finalDir, 0700); err != nil {
-		return err
+		return notWritableError(filepath.Dir(finalDir), err)
 	}
 
-	// Uncompress the plugin.
-	if err := archive.ExtractTGZ(tgz, finalDir); err != nil {
+	// Uncompress the plugin, and note which files came from its tarball, so the receipt can tell them apart from those
+	// its dependency install creates.
+	if err := archive.ExtractTGZWithOptions(tgz, finalDir, PluginExtractOptions); err != nil {
+		return classifyArchiveError(err)
+	}
+	extracted, err := listPluginFiles(finalDir, nil, false /* digest */)
+	if err != nil {
+		return fmt.Errorf("listing the extracted files of plugin %s: %w", info, err)
+	}
+
+	// Make sure the plugin's entry point made it into the install directory. Analyzer plugins are often launched via
+	// a policy pack's runtime rather than an executable of their own, so only warn rather than fail.
+	if _, ok := findPluginExecutable(finalDir, info.FilePrefix(), getCandidateExtensions()); !ok {
+		info.warnf("plugin %s was installed to %s but no %s executable was found; expected one of %s",
+			info, finalDir, info.FilePrefix(), strings.Join(candidatePluginFiles(info.FilePrefix()), ", "))
+	}
+
+	// Extracting the tarball can stop short of the end of the download, e.g. before the gzip trailer. Read whatever
+	// remains, so that the download's checksum is verified before the plugin is considered installed.
+	if _, err := io.Copy(ioutil.Discard, tgz); err != nil {
 		return err
 	}
 
@@ -807,31 +1453,70 @@ func (info PluginInfo) Install(tgz io.ReadCloser, reinstall bool) error {
 
 	// Install dependencies, if needed.
 	proj, err := LoadPluginProject(filepath.Join(finalDir, "PulumiPlugin.yaml"))
-	if err != nil && !os.IsNotExist(err) {
-		return errors.Wrap(err, "loading PulumiPlugin.yaml")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("loading PulumiPlugin.yaml: %w", err)
 	}
 	if proj != nil {
-		runtime := strings.ToLower(proj.Runtime.Name())
-		// For now, we only do this for Node.js and Python. For Go, the expectation is the binary is
-		// already built. For .NET, similarly, a single self-contained binary could be used, but
-		// otherwise `dotnet run` will implicitly run `dotnet restore`.
-		// TODO[pulumi/pulumi#1334]: move to the language plugins so we don't have to hard code here.
-		switch runtime {
-		case "nodejs":
-			var b bytes.Buffer
-			if _, err := npm.Install(finalDir, true /* production */, &b, &b); err != nil {
-				os.Stderr.Write(b.Bytes())
-				return errors.Wrap(err, "installing plugin dependencies")
-			}
-		case "python":
-			if err := python.InstallDependencies(finalDir, "venv", false /*showOutput*/); err != nil {
-				return errors.Wrap(err, "installing plugin dependencies")
-			}
+		// Check the license before installing dependencies, so a refused plugin never runs any of its code. The
+		// partial file is left in place, so the plugin isn't considered installed.
+		if err := checkPluginLicense(info, proj); err != nil {
+			return err
+		}
+		if err := installPluginDependencies(info, proj, finalDir, progress); err != nil {
+			return fmt.Errorf("installing plugin dependencies: %w", err)
+		}
+		if err := checkPluginDependencyLicenses(info, finalDir); err != nil {
+			return err
+		}
+		if missing := missingPluginBinaries(proj, finalDir, getCandidateExtensions()); len(missing) > 0 {
+			info.warnf("plugin %s was installed to %s but is missing the binaries %s declared by its PulumiPlugin.yaml",
+				info, finalDir, strings.Join(missing, ", "))
 		}
 	}
 
+	// Make sure the plugin passes its smoke test, if it declares one, and can start, if asked to, and record how it
+	// was installed. The partial file is left in place if it can't, so the plugin isn't considered installed.
+	var receipt PluginInstallReceipt
+	if proj != nil {
+		receipt.Env = proj.Env
+		tested, err := runPluginSmokeTest(info, proj, finalDir)
+		if err != nil {
+			return err
+		}
+		if tested {
+			testedAt := info.now()
+			receipt.SmokeTestedAt = &testedAt
+		}
+	}
+	if pluginHealthChecksEnabled() {
+		if receipt.HealthCheck, err = info.healthCheck(finalDir, receipt.Env); err != nil {
+			return err
+		}
+	}
+	if receipt.Files, err = installedPluginFiles(info, finalDir, extracted); err != nil {
+		return err
+	}
+	now := info.now()
+	receipt.InstalledAt = now
+	if err := writeInstallReceipt(finalDir, receipt); err != nil {
+		return err
+	}
+	// Once nothing else needs the plugin's files, encrypt them if the plugin cache is encrypted.
+	if err := sealPlugin(info, finalDir); err != nil {
+		return err
+	}
+
 	// Installation is complete. Remove the partial file.
-	return os.Remove(partialFilePath)
+	if err := os.Remove(partialFilePath); err != nil {
+		return err
+	}
+	if err := os.Chtimes(finalDir, now, now); err != nil {
+		info.logf(5, "could not record install time of plugin %s: %v", info, err)
+	}
+	if err := recordPluginChecksums(info); err != nil {
+		info.warnf("could not record the checksum of plugin %s: %v", info, err)
+	}
+	return nil
 }
 
 // cleanupTempDirs cleans up leftover temp dirs from failed installs with previous versions of Pulumi.
@@ -849,7 +1534,7 @@ func cleanupTempDirs(finalDir string) error {
 		if info.IsDir() && installingPluginRegexp.MatchString(info.Name()) {
 			path := filepath.Join(dir, info.Name())
 			if err := os.RemoveAll(path); err != nil {
-				return errors.Wrapf(err, "cleaning up temp dir %s", path)
+				return fmt.Errorf("cleaning up temp dir %s: %w", path, err)
 			}
 		}
 	}
@@ -862,6 +1547,9 @@ func (info PluginInfo) String() string {
 	if v := info.Version; v != nil {
 		version = fmt.Sprintf("-%s", v)
 	}
+	if info.Variant != "" {
+		version = fmt.Sprintf("%s (%s)", version, info.Variant)
+	}
 	return info.Name + version
 }
 
@@ -877,25 +1565,29 @@ const (
 	ResourcePlugin PluginKind = "resource"
 )
 
-// IsPluginKind returns true if k is a valid plugin kind, and false otherwise.
+// IsPluginKind returns true if k is a built-in plugin kind, or one registered with RegisterPluginKind, and false
+// otherwise.
 func IsPluginKind(k string) bool {
-	switch PluginKind(k) {
-	case AnalyzerPlugin, LanguagePlugin, ResourcePlugin:
-		return true
-	default:
-		return false
-	}
+	_, ok := getPluginKindOptions(PluginKind(k))
+	return ok
 }
 
 // HasPlugin returns true if the given plugin exists.
 func HasPlugin(plug PluginInfo) bool {
+	// Linked plugins are never installed over, so there's no need to install them.
+	if linkPath, err := plug.linkFilePath(); err == nil && plug.Variant == "" {
+		if _, err := os.Stat(linkPath); err == nil {
+			return true
+		}
+	}
+
 	dir, err := plug.DirPath()
 	if err == nil {
 		_, err := os.Stat(dir)
 		if err == nil {
 			partialFilePath, err := plug.PartialFilePath()
 			if err == nil {
-				if _, err := os.Stat(partialFilePath); os.IsNotExist(err) {
+				if _, err := os.Stat(partialFilePath); errors.Is(err, os.ErrNotExist) {
 					return true
 				}
 			}
@@ -920,7 +1612,7 @@ func HasPluginGTE(plug PluginInfo) (bool, error) {
 	// If we're not doing the legacy plugin behavior and we've been asked for a specific version, do the same plugin
 	// search that we'd do at runtime. This ensures that `pulumi plugin install` works the same way that the runtime
 	// loader does, to minimize confusion when a user has to install new plugins.
-	if !enableLegacyPluginBehavior && plug.Version != nil {
+	if !plug.context().legacyPluginSearch() && plug.Version != nil {
 		requestedVersion := semver.MustParseRange(plug.Version.String())
 		_, err := SelectCompatiblePlugin(plugs, plug.Kind, plug.Name, requestedVersion)
 		return err == nil, err
@@ -955,7 +1647,7 @@ func GetPolicyPath(orgName, name, version string) (string, bool, error) {
 	if err == nil && file.IsDir() {
 		// PolicyPack exists. Return.
 		return policyPackPath, true, nil
-	} else if err != nil && !os.IsNotExist(err) {
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
 		// Error trying to inspect PolicyPack FS entry. Return error.
 		return "", false, err
 	}
@@ -974,12 +1666,7 @@ func GetPluginDir() (string, error) {
 // expensive with the introduction of nodejs multilang components that have
 // deeply nested node_modules folders.
 func GetPlugins() ([]PluginInfo, error) {
-	// To get the list of plugins, simply scan the directory in the usual place.
-	dir, err := GetPluginDir()
-	if err != nil {
-		return nil, err
-	}
-	return getPlugins(dir, true /* skipMetadata */)
+	return (&Context{}).GetPlugins()
 }
 
 // GetPluginsWithMetadata returns a list of installed plugins with metadata about size,
@@ -987,18 +1674,13 @@ func GetPlugins() ([]PluginInfo, error) {
 // plugin directory, which can be extremely expensive with the introduction of
 // nodejs multilang components that have deeply nested node_modules folders.
 func GetPluginsWithMetadata() ([]PluginInfo, error) {
-	// To get the list of plugins, simply scan the directory in the usual place.
-	dir, err := GetPluginDir()
-	if err != nil {
-		return nil, err
-	}
-	return getPlugins(dir, false /* skipMetadata */)
+	return (&Context{}).GetPluginsWithMetadata()
 }
 
 func getPlugins(dir string, skipMetadata bool) ([]PluginInfo, error) {
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, os.ErrNotExist) {
 			return nil, nil
 		}
 		return nil, err
@@ -1018,7 +1700,7 @@ func getPlugins(dir string, skipMetadata bool) ([]PluginInfo, error) {
 			if _, err := os.Stat(fmt.Sprintf("%s.partial", path)); err == nil {
 				// Skip it if the partial file exists, meaning the plugin is not fully installed.
 				continue
-			} else if !os.IsNotExist(err) {
+			} else if !errors.Is(err, os.ErrNotExist) {
 				return nil, err
 			}
 			// computing plugin sizes can be very expensive (nested node_modules)
@@ -1035,74 +1717,180 @@ func getPlugins(dir string, skipMetadata bool) ([]PluginInfo, error) {
 
 // GetPluginPath finds a plugin's path by its kind, name, and optional version.  It will match the latest version that
 // is >= the version specified.  If no version is supplied, the latest plugin for that given kind/name pair is loaded,
-// using standard semver sorting rules.  A plugin may be overridden entirely by placing it on your $PATH, though it is
-// possible to opt out of this behavior by setting PULUMI_IGNORE_AMBIENT_PLUGINS to any non-empty value.
+// using standard semver sorting rules.  Plugins linked to a local build with PluginInfo.Link are found before all
+// others.  A plugin may be overridden entirely by placing it on your $PATH, though it is
+// possible to opt out of this behavior by setting PULUMI_IGNORE_AMBIENT_PLUGINS to any non-empty value, or for some
+// plugins with PULUMI_PLUGIN_AMBIENT_POLICY.
 func GetPluginPath(kind PluginKind, name string, version *semver.Version) (string, string, error) {
-	var filename string
-
-	// We currently bundle some plugins with "pulumi" and thus expect them to be next to the pulumi binary. We
-	// also always allow these plugins to be picked up from PATH even if PULUMI_IGNORE_AMBIENT_PLUGINS is set.
-	// Eventually we want to fix this so new plugins are true plugins in the plugin cache.
-	isBundled := kind == LanguagePlugin ||
-		(kind == ResourcePlugin && name == "pulumi-nodejs") ||
-		(kind == ResourcePlugin && name == "pulumi-python")
-
-	// If we have a version of the plugin on its $PATH, use it, unless we have opted out of this behavior explicitly.
-	// This supports development scenarios.
-	optOut, isFound := os.LookupEnv("PULUMI_IGNORE_AMBIENT_PLUGINS")
-	includeAmbient := !(isFound && cmdutil.IsTruthy(optOut)) || isBundled
-	if includeAmbient {
-		filename = (&PluginInfo{Kind: kind, Name: name, Version: version}).FilePrefix()
-		if path, err := exec.LookPath(filename); err == nil {
-			logging.V(6).Infof("GetPluginPath(%s, %s, %v): found on $PATH %s", kind, name, version, path)
-			return "", path, nil
-		}
-	}
-
-	// At some point in the future, bundled plugins will be located in the plugin cache, just like regular
-	// plugins (see pulumi/pulumi#956 for some of the reasons why this isn't the case today). For now, they
-	// ship next to the `pulumi` binary. While we encourage this folder to be on the $PATH (and so the check
-	// above would have found the plugin) it's possible someone is running `pulumi` with an explicit path on
-	// the command line or has done symlink magic such that `pulumi` is on the path, but the bundled plugins
-	// are not. So, if possible, look next to the instance of `pulumi` that is running to find this bundled
-	// plugin.
-	if isBundled {
-		exePath, exeErr := os.Executable()
-		if exeErr == nil {
-			fullPath, fullErr := filepath.EvalSymlinks(exePath)
-			if fullErr == nil {
-				for _, ext := range getCandidateExtensions() {
-					candidate := filepath.Join(filepath.Dir(fullPath), filename+ext)
-					// Let's see if the file is executable. On Windows, os.Stat() returns a mode of "-rw-rw-rw" so on
-					// on windows we just trust the fact that the .exe can actually be launched.
-					if stat, err := os.Stat(candidate); err == nil &&
-						(stat.Mode()&0100 != 0 || runtime.GOOS == windowsGOOS) {
-						logging.V(6).Infof("GetPluginPath(%s, %s, %v): found next to current executable %s",
-							kind, name, version, candidate)
-
-						return "", candidate, nil
-					}
+	return (&Context{}).GetPluginPath(kind, name, version)
+}
+
+// GetPluginPath finds a plugin's path like the package-level GetPluginPath, looking in the context's plugin
+// directory.
+func (ctx *Context) GetPluginPath(kind PluginKind, name string, version *semver.Version) (string, string, error) {
+	resolution, err := ctx.ResolvePlugin(kind, name, version)
+	if err != nil {
+		return "", "", err
+	}
+	return resolution.Dir, resolution.Path, nil
+}
+
+// ResolvePlugin finds a plugin like GetPluginPath, but returns where it found the plugin, and what it required of it,
+// as well as its path.
+func ResolvePlugin(kind PluginKind, name string, version *semver.Version) (*PluginResolution, error) {
+	return (&Context{}).ResolvePlugin(kind, name, version)
+}
+
+// ResolvePlugin finds a plugin like the package-level ResolvePlugin, looking in the context's plugin directory.
+func (ctx *Context) ResolvePlugin(kind PluginKind, name string, version *semver.Version) (*PluginResolution, error) {
+	// If we have a version of the plugin on its $PATH, use it, unless the plugin cache is preferred or we have opted
+	// out of this behavior explicitly. This supports development scenarios.
+	preference, err := ctx.getPluginAmbientPreference(kind, name)
+	if err != nil {
+		return nil, err
+	}
+	includeAmbient := preference != PluginAmbientIgnored
+	constraints := PluginConstraints{Version: version, Ambient: includeAmbient, AmbientPreference: preference}
+	resolved := func(origin PluginOrigin, entry pluginPathEntry) *PluginResolution {
+		entry.info.Path = entry.path
+		return &PluginResolution{
+			Path:        entry.path,
+			Dir:         entry.dir,
+			Origin:      origin,
+			Info:        entry.info,
+			Constraints: constraints,
+		}
+	}
+
+	// Plugins that are linked to a local build are always used, since they were explicitly linked for development.
+	if entry, ok, err := ctx.getPluginLinkPath(kind, name, version); err != nil {
+		return nil, err
+	} else if ok {
+		return resolved(PluginOriginLinked, entry), nil
+	}
+
+	filename := (&PluginInfo{Kind: kind, Name: name, Version: version}).FilePrefix()
+	findAmbient := func() *PluginResolution {
+		path, err := lookPathPlugin(filename)
+		if err != nil {
+			return nil
+		}
+		ctx.logf(6, "GetPluginPath(%s, %s, %v): found on $PATH %s", kind, name, version, path)
+		return resolved(PluginOriginAmbient, pluginPathEntry{info: PluginInfo{Kind: kind, Name: name}, path: path})
+	}
+	if preference == PluginAmbientPreferred {
+		if resolution := findAmbient(); resolution != nil {
+			return resolution, nil
+		}
+	}
+
+	// Bundled plugins are migrated into the plugin cache by MigrateBundledPlugins, as shims that forward to the plugins
+	// next to the `pulumi` binary. Prefer the shim for the instance of `pulumi` that is running, so bundled plugins are
+	// tracked like any other plugin. Until they are migrated, look next to the running `pulumi` directly: while we
+	// encourage this folder to be on the $PATH (and so the check above would have found the plugin) it's possible
+	// someone is running `pulumi` with an explicit path on the command line or has done symlink magic such that
+	// `pulumi` is on the path, but the bundled plugins are not.
+	if isBundledPlugin(kind, name) {
+		if exeDir, err := executableDir(); err == nil {
+			if entry, ok := ctx.findBundledPluginShim(kind, name, exeDir); ok {
+				ctx.logf(6, "GetPluginPath(%s, %s, %v): found shim of bundled plugin %s", kind, name, version, entry.path)
+				return resolved(PluginOriginBundled, entry), nil
+			}
+			for _, ext := range getCandidateExtensions() {
+				candidate := filepath.Join(exeDir, filename+ext)
+				if stat, err := os.Stat(candidate); err == nil && isPluginExecutable(stat) {
+					ctx.logf(6, "GetPluginPath(%s, %s, %v): found next to current executable %s",
+						kind, name, version, candidate)
+
+					entry := pluginPathEntry{info: PluginInfo{Kind: kind, Name: name}, path: candidate}
+					return resolved(PluginOriginBundled, entry), nil
 				}
 			}
 		}
 	}
 
-	// Otherwise, check the plugin cache.
-	plugins, err := GetPlugins()
+	// Plugins in the plugin cache are matched against the requested version.
+	legacy := ctx.legacyPluginSearch()
+	constraints.Range = pluginVersionRange(version, legacy)
+
+	// Provider engineers can select an alternate build of the plugin, such as a debug build, which is used instead of
+	// the release build.
+	variant, err := ctx.getPluginVariant(name)
+	if err != nil {
+		return nil, err
+	}
+	if variant != "" {
+		constraints.Variant = variant
+		entry, err := ctx.getPluginVariantPath(kind, name, version, variant)
+		if err != nil {
+			return nil, err
+		}
+		return resolved(PluginOriginCache, entry), nil
+	}
+
+	// Otherwise, check the plugin cache, unless this lookup has already found a plugin there. Maintenance is skipped, so
+	// it can't remove the plugin before it's used.
+	key, err := ctx.pluginPathCacheKey(kind, name, version)
 	if err != nil {
-		return "", "", errors.Wrapf(err, "loading plugin list")
+		return nil, err
+	}
+	if entry, ok := cachedPluginPath(key); ok {
+		ctx.logf(6, "GetPluginPath(%s, %s, %v): found in cache at %s (cached)", kind, name, version, entry.path)
+		ctx.markPluginUsed(entry.info, entry.dir)
+		return resolved(PluginOriginCache, entry), nil
+	}
+	plugins, err := ctx.getPlugins(true /* skipMetadata */)
+	if err != nil {
+		return nil, fmt.Errorf("loading plugin list: %w", err)
+	}
+
+	match := ctx.matchPlugin(plugins, kind, name, version, legacy)
+	if match != nil {
+		matchDir, err := match.DirPath()
+		if err != nil {
+			return nil, err
+		}
+		matchPath, err := match.FilePath()
+		if err != nil {
+			return nil, err
+		}
+
+		ctx.logf(6, "GetPluginPath(%s, %s, %v): found in cache at %s", kind, name, version, matchPath)
+		ctx.markPluginUsed(*match, matchDir)
+		unsealedDir, unsealedPath, sealed, err := ctx.unsealPlugin(*match, matchDir)
+		if err != nil {
+			return nil, err
+		} else if sealed {
+			matchDir, matchPath = unsealedDir, unsealedPath
+		}
+		entry := pluginPathEntry{info: *match, dir: matchDir, path: matchPath}
+		cachePluginPath(key, entry)
+		return resolved(PluginOriginCache, entry), nil
+	}
+
+	if preference == PluginCachePreferred {
+		if resolution := findAmbient(); resolution != nil {
+			return resolution, nil
+		}
 	}
 
+	return nil, newMissingErrorWithInstalled(PluginInfo{
+		Name:    name,
+		Kind:    kind,
+		Version: version,
+	}, includeAmbient, plugins)
+}
+
+// matchPlugin returns the plugin GetPluginPath selects from plugins for the given kind, name and optional version, or
+// nil if none of them match.
+func (ctx *Context) matchPlugin(plugins []PluginInfo, kind PluginKind, name string,
+	version *semver.Version, legacy bool) *PluginInfo {
 	var match *PluginInfo
-	if !enableLegacyPluginBehavior && version != nil {
-		logging.V(6).Infof("GetPluginPath(%s, %s, %s): enabling new plugin behavior", kind, name, version)
+	if !legacy && version != nil {
+		ctx.logf(6, "GetPluginPath(%s, %s, %s): enabling new plugin behavior", kind, name, version)
 		candidate, err := SelectCompatiblePlugin(plugins, kind, name, semver.MustParseRange(version.String()))
 		if err != nil {
-			return "", "", NewMissingError(PluginInfo{
-				Name:    name,
-				Kind:    kind,
-				Version: version,
-			}, includeAmbient)
+			return nil
 		}
 		match = &candidate
 	} else {
@@ -1125,32 +1913,27 @@ func GetPluginPath(kind PluginKind, name string, version *semver.Version) (strin
 
 				if m != nil {
 					match = m
-					logging.V(6).Infof("GetPluginPath(%s, %s, %s): found candidate (#%s)",
+					ctx.logf(6, "GetPluginPath(%s, %s, %s): found candidate (#%s)",
 						kind, name, version, match.Version)
 				}
 			}
 		}
 	}
 
-	if match != nil {
-		matchDir, err := match.DirPath()
-		if err != nil {
-			return "", "", err
-		}
-		matchPath, err := match.FilePath()
-		if err != nil {
-			return "", "", err
-		}
+	return match
+}
 
-		logging.V(6).Infof("GetPluginPath(%s, %s, %v): found in cache at %s", kind, name, version, matchPath)
-		return matchDir, matchPath, nil
+// markPluginUsed stamps the access time of the plugin's directory with the current time, which is reported as when
+// the plugin was last used. Access times aren't updated by reads on every file system, so they're set explicitly.
+func (ctx *Context) markPluginUsed(info PluginInfo, dir string) {
+	stat, err := os.Stat(dir)
+	if err == nil {
+		// Keep the modification time, which records when the plugin was installed.
+		err = os.Chtimes(dir, ctx.now(), stat.ModTime())
+	}
+	if err != nil {
+		ctx.logf(5, "could not record use of plugin %s: %v", info, err)
 	}
-
-	return "", "", NewMissingError(PluginInfo{
-		Name:    name,
-		Kind:    kind,
-		Version: version,
-	}, includeAmbient)
 }
 
 // SortedPluginInfo is a wrapper around PluginInfo that allows for sorting by version.
@@ -1183,7 +1966,7 @@ func (sp SortedPluginInfo) Swap(i, j int) { sp[i], sp[j] = sp[j], sp[i] }
 // are no other compatible plugins available.
 func SelectCompatiblePlugin(
 	plugins []PluginInfo, kind PluginKind, name string, requested semver.Range) (PluginInfo, error) {
-	logging.V(7).Infof("SelectCompatiblePlugin(..., %s): beginning", name)
+	logf(7, nil, "SelectCompatiblePlugin(..., %s): beginning", name)
 	var bestMatch PluginInfo
 	var hasMatch bool
 
@@ -1201,7 +1984,7 @@ func SelectCompatiblePlugin(
 		case !hasMatch && plugin.Version == nil:
 			// This is the plugin we're looking for, but it doesn't have a version. We haven't seen anything better yet,
 			// so take it.
-			logging.V(7).Infof(
+			logf(7, nil,
 				"SelectCompatiblePlugin(..., %s): best plugin %s: no version and no other candidates",
 				name, plugin.String())
 			hasMatch = true
@@ -1209,23 +1992,23 @@ func SelectCompatiblePlugin(
 		case plugin.Version == nil:
 			// This is a rare case - we've already seen a version-less plugin and we're seeing another here. Ignore this
 			// one and defer to the one we previously selected.
-			logging.V(7).Infof("SelectCompatiblePlugin(..., %s): skipping plugin %s: no version", name, plugin.String())
+			logf(7, nil, "SelectCompatiblePlugin(..., %s): skipping plugin %s: no version", name, plugin.String())
 		case requested(*plugin.Version):
 			// This plugin is compatible with the requested semver range. Save it as the best match and continue.
-			logging.V(7).Infof("SelectCompatiblePlugin(..., %s): best plugin %s: semver match", name, plugin.String())
+			logf(7, nil, "SelectCompatiblePlugin(..., %s): best plugin %s: semver match", name, plugin.String())
 			hasMatch = true
 			bestMatch = plugin
 		default:
-			logging.V(7).Infof(
+			logf(7, nil,
 				"SelectCompatiblePlugin(..., %s): skipping plugin %s: semver mismatch", name, plugin.String())
 		}
 	}
 
 	if !hasMatch {
-		logging.V(7).Infof("SelectCompatiblePlugin(..., %s): failed to find match", name)
+		logf(7, nil, "SelectCompatiblePlugin(..., %s): failed to find match", name)
 		return PluginInfo{}, errors.New("failed to locate compatible plugin")
 	}
-	logging.V(7).Infof("SelectCompatiblePlugin(..., %s): selecting plugin '%s': best match ", name, bestMatch.String())
+	logf(7, nil, "SelectCompatiblePlugin(..., %s): selecting plugin '%s': best match ", name, bestMatch.String())
 	return bestMatch, nil
 }
 
@@ -1249,22 +2032,53 @@ func ReadCloserProgressBar(
 	bar.SetUnits(pb.U_BYTES)
 	bar.Start()
 
-	return &barCloser{
+	return withExtractedSizeOf(&barCloser{
 		bar:        bar,
 		readCloser: bar.NewProxyReader(closer),
-	}
+	}, closer)
 }
 
 // getCandidateExtensions returns a set of file extensions (including the dot seprator) which should be used when
 // probing for an executable file.
 func getCandidateExtensions() []string {
-	if runtime.GOOS == windowsGOOS {
-		return []string{".exe", ".cmd"}
+	return candidateExtensionsFor(runtime.GOOS)
+}
+
+// candidatePluginFiles returns the file names that a plugin executable with the given prefix may have.
+func candidatePluginFiles(prefix string) []string {
+	return candidatePluginFilesFor(prefix, runtime.GOOS)
+}
+
+// lookPathPlugin searches the $PATH for a plugin executable. exec.LookPath consults PATHEXT on Windows, which includes
+// `.exe`, `.cmd` and `.bat` but not `.ps1`, so PowerShell scripts are probed for explicitly.
+func lookPathPlugin(filename string) (string, error) {
+	path, err := exec.LookPath(filename)
+	if err != nil && runtime.GOOS == windowsGOOS {
+		if ps1, ps1Err := exec.LookPath(filename + ".ps1"); ps1Err == nil {
+			return ps1, nil
+		}
+	}
+	return path, err
+}
+
+// candidateExtensionsFor returns the executable file extensions to probe for on the given OS, in order of preference.
+func candidateExtensionsFor(goos string) []string {
+	if goos == windowsGOOS {
+		return []string{".exe", ".cmd", ".bat", ".ps1"}
 	}
 
 	return []string{""}
 }
 
+// candidatePluginFilesFor returns the file names that a plugin executable with the given prefix may have on goos.
+func candidatePluginFilesFor(prefix, goos string) []string {
+	var files []string
+	for _, ext := range candidateExtensionsFor(goos) {
+		files = append(files, prefix+ext)
+	}
+	return files
+}
+
 // pluginRegexp matches plugin directory names: pulumi-KIND-NAME-VERSION.
 var pluginRegexp = regexp.MustCompile(
 	"^(?P<Kind>[a-z]+)-" + // KIND
@@ -1280,20 +2094,20 @@ var installingPluginRegexp = regexp.MustCompile(`\.tmp[0-9]+$`)
 func tryPlugin(file os.FileInfo) (PluginKind, string, semver.Version, bool) {
 	// Only directories contain plugins.
 	if !file.IsDir() {
-		logging.V(11).Infof("skipping file in plugin directory: %s", file.Name())
+		logf(11, nil, "skipping file in plugin directory: %s", file.Name())
 		return "", "", semver.Version{}, false
 	}
 
 	// Ignore plugins which are being installed
 	if installingPluginRegexp.MatchString(file.Name()) {
-		logging.V(11).Infof("skipping plugin %s which is being installed", file.Name())
+		logf(11, nil, "skipping plugin %s which is being installed", file.Name())
 		return "", "", semver.Version{}, false
 	}
 
 	// Filenames must match the plugin regexp.
 	match := pluginRegexp.FindStringSubmatch(file.Name())
 	if len(match) != len(pluginRegexp.SubexpNames()) {
-		logging.V(11).Infof("skipping plugin %s with missing capture groups: expect=%d, actual=%d",
+		logf(11, nil, "skipping plugin %s with missing capture groups: expect=%d, actual=%d",
 			file.Name(), len(pluginRegexp.SubexpNames()), len(match))
 		return "", "", semver.Version{}, false
 	}
@@ -1308,7 +2122,7 @@ func tryPlugin(file os.FileInfo) (PluginKind, string, semver.Version, bool) {
 			if IsPluginKind(v) {
 				kind = PluginKind(v)
 			} else {
-				logging.V(11).Infof("skipping invalid plugin kind: %s", v)
+				logf(11, nil, "skipping invalid plugin kind: %s", v)
 			}
 		case "Name":
 			name = v
@@ -1318,14 +2132,14 @@ func tryPlugin(file os.FileInfo) (PluginKind, string, semver.Version, bool) {
 			if err == nil {
 				version = &ver
 			} else {
-				logging.V(11).Infof("skipping invalid plugin version: %s", v)
+				logf(11, nil, "skipping invalid plugin version: %s", v)
 			}
 		}
 	}
 
 	// If anything was missing or invalid, skip this plugin.
 	if kind == "" || name == "" || version == nil {
-		logging.V(11).Infof("skipping plugin with missing information: kind=%s, name=%s, version=%v",
+		logf(11, nil, "skipping plugin with missing information: kind=%s, name=%s, version=%v",
 			kind, name, version)
 		return "", "", semver.Version{}, false
 	}
@@ -1333,32 +2147,6 @@ func tryPlugin(file os.FileInfo) (PluginKind, string, semver.Version, bool) {
 	return kind, name, *version, true
 }
 
-// getPluginSize recursively computes how much space is devoted to a given plugin.
-func getPluginSize(path string) (int64, error) {
-	file, err := os.Stat(path)
-	if err != nil {
-		return 0, nil
-	}
-
-	size := int64(0)
-	if file.IsDir() {
-		subs, err := ioutil.ReadDir(path)
-		if err != nil {
-			return 0, err
-		}
-		for _, child := range subs {
-			add, err := getPluginSize(filepath.Join(path, child.Name()))
-			if err != nil {
-				return 0, err
-			}
-			size += add
-		}
-	} else {
-		size += file.Size()
-	}
-	return size, nil
-}
-
 type barCloser struct {
 	bar        *pb.ProgressBar
 	readCloser io.ReadCloser
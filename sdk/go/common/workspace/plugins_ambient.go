@@ -0,0 +1,259 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blang/semver"
+)
+
+// AmbientPlugin is a plugin executable found on $PATH, which GetPluginPath uses instead of the plugin cache.
+type AmbientPlugin struct {
+	// Kind is the kind of the plugin.
+	Kind PluginKind
+	// Name is the name of the plugin.
+	Name string
+	// Path is the plugin's executable.
+	Path string
+}
+
+// AmbientPluginConflictReason is why an ambient plugin conflicts with the plugin cache.
+type AmbientPluginConflictReason string
+
+const (
+	// AmbientPluginOlder is reported for ambient plugins older than the newest version in the plugin cache.
+	AmbientPluginOlder AmbientPluginConflictReason = "older"
+	// AmbientPluginUnknownProvenance is reported for ambient plugins whose version couldn't be determined, so it's
+	// unknown whether they should be used instead of the plugin cache.
+	AmbientPluginUnknownProvenance AmbientPluginConflictReason = "unknown-provenance"
+)
+
+// AmbientPluginConflict is an ambient plugin that shadows a plugin in the plugin cache.
+type AmbientPluginConflict struct {
+	// Plugin is the ambient plugin.
+	Plugin AmbientPlugin
+	// Version is the version the ambient plugin reported, or nil if it's unknown.
+	Version *semver.Version
+	// Cached is the newest version of the plugin in the plugin cache, which the ambient plugin shadows.
+	Cached PluginInfo
+	// Reason is why the ambient plugin conflicts with the cached one.
+	Reason AmbientPluginConflictReason
+}
+
+// Message describes the conflict for display to users.
+func (c AmbientPluginConflict) Message() string {
+	var msg string
+	switch c.Reason {
+	case AmbientPluginOlder:
+		msg = fmt.Sprintf("%s plugin %s v%s on $PATH at %s shadows newer version v%s in the plugin cache",
+			c.Plugin.Kind, c.Plugin.Name, c.Version, c.Plugin.Path, c.Cached.Version)
+	default:
+		msg = fmt.Sprintf("%s plugin %s on $PATH at %s shadows version v%s in the plugin cache, "+
+			"but where it came from is unknown because it didn't report its version",
+			c.Plugin.Kind, c.Plugin.Name, c.Plugin.Path, c.Cached.Version)
+	}
+	if !isBundledPlugin(c.Plugin.Kind, c.Plugin.Name) {
+		msg += "; set PULUMI_IGNORE_AMBIENT_PLUGINS=true to use the plugin cache instead"
+	} else {
+		msg += fmt.Sprintf("; set %s=%s/%s=%s to use the plugin cache instead",
+			PluginAmbientPolicyEnvVar, c.Plugin.Kind, c.Plugin.Name, PluginCachePreferred)
+	}
+	return msg
+}
+
+// logFields returns the fields that identify the conflict in log messages.
+func (c AmbientPluginConflict) logFields() LogFields {
+	fields := LogFields{
+		"kind":   c.Plugin.Kind,
+		"name":   c.Plugin.Name,
+		"path":   c.Plugin.Path,
+		"cached": c.Cached.Version.String(),
+		"reason": c.Reason,
+	}
+	if c.Version != nil {
+		fields["version"] = c.Version.String()
+	}
+	return fields
+}
+
+// GetAmbientPlugins returns the plugins found on $PATH. See Context.GetAmbientPlugins.
+func GetAmbientPlugins() ([]AmbientPlugin, error) {
+	return (&Context{}).GetAmbientPlugins()
+}
+
+// GetAmbientPlugins returns the plugins on $PATH that GetPluginPath would use instead of the plugin cache: only the
+// first of each kind and name on $PATH is returned, and only those that PULUMI_PLUGIN_AMBIENT_POLICY, or
+// PULUMI_IGNORE_AMBIENT_PLUGINS, lets be preferred over the plugin cache. The bundled plugins next to the running
+// pulumi binary belong to it, and aren't returned.
+func (ctx *Context) GetAmbientPlugins() ([]AmbientPlugin, error) {
+	exeDir, err := executableDir()
+	if err != nil {
+		ctx.logf(5, "could not find the directory of the running executable: %v", err)
+	}
+
+	var plugins []AmbientPlugin
+	seen := map[string]bool{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			continue
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			// $PATH often names directories that don't exist.
+			continue
+		}
+		for _, ext := range getCandidateExtensions() {
+			for _, file := range files {
+				kind, name, ok := parsePluginFileName(file.Name(), ext)
+				if !ok || !isPluginExecutable(file) || seen[string(kind)+"/"+name] {
+					continue
+				}
+				seen[string(kind)+"/"+name] = true
+				if isBundledPlugin(kind, name) && sameDir(dir, exeDir) {
+					continue
+				}
+				preference, err := ctx.getPluginAmbientPreference(kind, name)
+				if err != nil {
+					return nil, err
+				} else if preference != PluginAmbientPreferred {
+					continue
+				}
+				plugins = append(plugins, AmbientPlugin{Kind: kind, Name: name, Path: filepath.Join(dir, file.Name())})
+			}
+		}
+	}
+	return plugins, nil
+}
+
+// parsePluginFileName returns the kind and name of the plugin executable with the given file name and extension.
+// The `-exec` helpers that some language plugins ship with aren't plugins of their own.
+func parsePluginFileName(file, ext string) (PluginKind, string, bool) {
+	if !strings.HasSuffix(file, ext) || !strings.HasPrefix(file, "pulumi-") {
+		return "", "", false
+	}
+	kindAndName := strings.SplitN(strings.TrimPrefix(strings.TrimSuffix(file, ext), "pulumi-"), "-", 2)
+	if len(kindAndName) != 2 || !IsPluginKind(kindAndName[0]) || kindAndName[1] == "" {
+		return "", "", false
+	}
+	kind, name := PluginKind(kindAndName[0]), kindAndName[1]
+	if kind == LanguagePlugin && strings.HasSuffix(name, "-exec") {
+		return "", "", false
+	}
+	return kind, name, true
+}
+
+// sameDir returns true if the two paths name the same directory once symlinks are resolved.
+func sameDir(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	if resolved, err := filepath.EvalSymlinks(a); err == nil {
+		a = resolved
+	}
+	return filepath.Clean(a) == filepath.Clean(b)
+}
+
+// GetAmbientPluginConflicts returns the ambient plugins that shadow plugins in the plugin cache. See
+// Context.GetAmbientPluginConflicts.
+func GetAmbientPluginConflicts() ([]AmbientPluginConflict, error) {
+	return (&Context{}).GetAmbientPluginConflicts()
+}
+
+// GetAmbientPluginConflicts compares the ambient plugins against the plugin cache, returning those that shadow a newer
+// version in the cache, or whose version can't be determined. Ambient plugins that link into the plugin cache have the
+// version of the plugin they link to; other ambient plugins that shadow a cached plugin are launched, as a health
+// check does, and asked for their version. Each conflict is also logged as a warning with the context's logger, with
+// fields describing it, so the CLI can show them before an update.
+func (ctx *Context) GetAmbientPluginConflicts() ([]AmbientPluginConflict, error) {
+	ambient, err := ctx.GetAmbientPlugins()
+	if err != nil || len(ambient) == 0 {
+		return nil, err
+	}
+	cached, err := ctx.getPlugins(true /* skipMetadata */)
+	if err != nil {
+		return nil, fmt.Errorf("loading plugin list: %w", err)
+	}
+
+	var conflicts []AmbientPluginConflict
+	for _, plugin := range ambient {
+		newest := newestCachedPlugin(cached, plugin.Kind, plugin.Name)
+		if newest == nil {
+			continue
+		}
+		conflict := AmbientPluginConflict{Plugin: plugin, Cached: *newest, Version: ctx.ambientPluginVersion(plugin, cached)}
+		if conflict.Version == nil {
+			conflict.Reason = AmbientPluginUnknownProvenance
+		} else if conflict.Version.LT(*newest.Version) {
+			conflict.Reason = AmbientPluginOlder
+		} else {
+			continue
+		}
+		ctx.logger().Warningf(conflict.logFields(), "%s", conflict.Message())
+		conflicts = append(conflicts, conflict)
+	}
+	return conflicts, nil
+}
+
+// newestCachedPlugin returns the newest version of the plugin in the given cached plugins, if any.
+func newestCachedPlugin(cached []PluginInfo, kind PluginKind, name string) *PluginInfo {
+	var newest *PluginInfo
+	for i, plugin := range cached {
+		if plugin.Kind == kind && plugin.Name == name && plugin.Version != nil &&
+			(newest == nil || plugin.Version.GT(*newest.Version)) {
+			newest = &cached[i]
+		}
+	}
+	return newest
+}
+
+// ambientPluginVersion returns the version of the ambient plugin, or nil if it can't be determined.
+func (ctx *Context) ambientPluginVersion(plugin AmbientPlugin, cached []PluginInfo) *semver.Version {
+	if resolved, err := filepath.EvalSymlinks(plugin.Path); err == nil {
+		for _, info := range cached {
+			dir, err := info.DirPath()
+			if err != nil || info.Kind != plugin.Kind || info.Name != plugin.Name {
+				continue
+			}
+			if resolvedDir, err := filepath.EvalSymlinks(dir); err == nil && filepath.Dir(resolved) == resolvedDir {
+				return info.Version
+			}
+		}
+	}
+
+	env, err := GetPluginEnvironment(plugin.Kind, plugin.Name, "")
+	if err != nil {
+		ctx.logf(5, "could not get the environment of plugin %s: %v", plugin.Path, err)
+		return nil
+	}
+	launchCtx, cancel := context.WithTimeout(context.Background(), PluginHealthCheckTimeout)
+	defer cancel()
+	check, _, err := launchPlugin(launchCtx, plugin.Kind, plugin.Path, env)
+	if err != nil || check.Version == "" {
+		ctx.logf(5, "could not get the version of plugin %s: %v", plugin.Path, err)
+		return nil
+	}
+	version, err := semver.ParseTolerant(check.Version)
+	if err != nil {
+		ctx.logf(5, "plugin %s reported an invalid version %q: %v", plugin.Path, check.Version, err)
+		return nil
+	}
+	return &version
+}
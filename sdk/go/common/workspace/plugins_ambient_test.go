@@ -0,0 +1,122 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePluginFileName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		file string
+		kind PluginKind
+		name string
+		ok   bool
+	}{
+		{file: "pulumi-resource-aws", kind: ResourcePlugin, name: "aws", ok: true},
+		{file: "pulumi-resource-azure-native", kind: ResourcePlugin, name: "azure-native", ok: true},
+		{file: "pulumi-language-nodejs", kind: LanguagePlugin, name: "nodejs", ok: true},
+		{file: "pulumi-analyzer-policy", kind: AnalyzerPlugin, name: "policy", ok: true},
+		{file: "pulumi-language-python-exec"},
+		{file: "pulumi-watch-files"},
+		{file: "pulumi-resource-"},
+		{file: "pulumi"},
+		{file: "aws"},
+	}
+	for _, tt := range tests {
+		kind, name, ok := parsePluginFileName(tt.file, "")
+		assert.Equal(t, tt.ok, ok, tt.file)
+		assert.Equal(t, tt.kind, kind, tt.file)
+		assert.Equal(t, tt.name, name, tt.file)
+	}
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestGetAmbientPluginConflicts(t *testing.T) {
+	if runtime.GOOS == windowsGOOS {
+		t.Skip("uses shell scripts as fake plugins")
+	}
+
+	pluginDir, pathDir, shadowedDir := t.TempDir(), t.TempDir(), t.TempDir()
+	ctx := &Context{Home: t.TempDir(), PluginDir: pluginDir}
+	reportsVersion := fmt.Sprintf("echo %d\nexec sleep 30", serveVersionProvider(t))
+	for dir, plugins := range map[string]map[string]string{
+		// The version provider reports version 1.2.3.
+		pathDir: {
+			"pulumi-resource-older":    reportsVersion,
+			"pulumi-resource-newer":    reportsVersion,
+			"pulumi-resource-unknown":  "exit 1",
+			"pulumi-resource-uncached": "exit 1",
+		},
+		// Only the first plugin of each name on $PATH is used.
+		shadowedDir: {"pulumi-resource-newer": "exit 1"},
+	} {
+		for name, script := range plugins {
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0700))
+		}
+	}
+	for _, dir := range []string{
+		"resource-older-v1.0.0", "resource-older-v2.0.0",
+		"resource-newer-v1.0.0",
+		"resource-unknown-v1.0.0",
+		"resource-linked-v3.0.0",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(pluginDir, dir), 0700))
+	}
+	// Plugins that link into the plugin cache have the version they link to.
+	linked := filepath.Join(pluginDir, "resource-linked-v3.0.0", "pulumi-resource-linked")
+	require.NoError(t, ioutil.WriteFile(linked, []byte("#!/bin/sh\nexit 1\n"), 0700))
+	require.NoError(t, os.Symlink(linked, filepath.Join(pathDir, "pulumi-resource-linked")))
+
+	t.Setenv("PATH", pathDir+string(os.PathListSeparator)+shadowedDir)
+	ambient, err := ctx.GetAmbientPlugins()
+	require.NoError(t, err)
+	assert.Len(t, ambient, 5)
+
+	conflicts, err := ctx.GetAmbientPluginConflicts()
+	require.NoError(t, err)
+	reported := map[string]AmbientPluginConflictReason{}
+	for _, conflict := range conflicts {
+		reported[conflict.Plugin.Name] = conflict.Reason
+		assert.Equal(t, filepath.Join(pathDir, "pulumi-resource-"+conflict.Plugin.Name), conflict.Plugin.Path)
+	}
+	assert.Equal(t, map[string]AmbientPluginConflictReason{
+		"older":   AmbientPluginOlder,
+		"unknown": AmbientPluginUnknownProvenance,
+	}, reported)
+	for _, conflict := range conflicts {
+		if conflict.Reason == AmbientPluginOlder {
+			assert.Equal(t, semver.MustParse("1.2.3"), *conflict.Version)
+			assert.Equal(t, semver.MustParse("2.0.0"), *conflict.Cached.Version)
+		}
+	}
+
+	// Ambient plugins aren't used at all if they're ignored.
+	t.Setenv("PULUMI_IGNORE_AMBIENT_PLUGINS", "true")
+	conflicts, err = ctx.GetAmbientPluginConflicts()
+	require.NoError(t, err)
+	assert.Empty(t, conflicts)
+}
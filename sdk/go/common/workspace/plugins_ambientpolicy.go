@@ -0,0 +1,153 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+)
+
+// PluginAmbientPolicyEnvVar chooses, by plugin kind and name, between ambient plugins on $PATH and those in the plugin
+// cache. It's a comma-separated list of [KIND/]NAME=PREFERENCE rules, such as `resource/aws*=cache,language/*=ambient`,
+// whose names are glob patterns and whose preferences are "ambient", "cache" or "ignore". It takes precedence over the
+// `ambientPolicy` section of PluginConfigFile.
+//
+// The first rule that matches a plugin decides, so rules take precedence over PULUMI_IGNORE_AMBIENT_PLUGINS. Plugins
+// that no rule matches prefer ambient plugins, unless PULUMI_IGNORE_AMBIENT_PLUGINS is set; bundled plugins, which
+// ship next to the `pulumi` binary, prefer ambient plugins even then, unless a rule says otherwise.
+const PluginAmbientPolicyEnvVar = "PULUMI_PLUGIN_AMBIENT_POLICY"
+
+// PluginAmbientPreference is how GetPluginPath chooses between an ambient plugin and the plugin cache.
+type PluginAmbientPreference string
+
+const (
+	// PluginAmbientPreferred uses the plugin on $PATH if there is one, and the plugin cache otherwise.
+	PluginAmbientPreferred PluginAmbientPreference = "ambient"
+	// PluginCachePreferred uses the plugin cache if it has a matching plugin, and the plugin on $PATH otherwise.
+	PluginCachePreferred PluginAmbientPreference = "cache"
+	// PluginAmbientIgnored only uses the plugin cache.
+	PluginAmbientIgnored PluginAmbientPreference = "ignore"
+)
+
+// PluginAmbientRule sets the ambient preference of the plugins it matches.
+type PluginAmbientRule struct {
+	// Kind is the kind of plugin the rule matches, or empty to match every kind.
+	Kind PluginKind
+	// Name is the glob pattern the names of the plugins the rule matches must match, such as "aws*".
+	Name string
+	// Prefer is the preference of the plugins the rule matches.
+	Prefer PluginAmbientPreference
+}
+
+// matches returns true if the rule applies to the plugin of the given kind and name.
+func (rule PluginAmbientRule) matches(kind PluginKind, name string) bool {
+	if rule.Kind != "" && rule.Kind != kind {
+		return false
+	}
+	ok, _ := path.Match(rule.Name, name)
+	return ok
+}
+
+func parsePluginAmbientPreference(s string) (PluginAmbientPreference, error) {
+	switch preference := PluginAmbientPreference(s); preference {
+	case PluginAmbientPreferred, PluginCachePreferred, PluginAmbientIgnored:
+		return preference, nil
+	default:
+		return "", fmt.Errorf("expected %q, %q or %q; got %q",
+			PluginAmbientPreferred, PluginCachePreferred, PluginAmbientIgnored, s)
+	}
+}
+
+// validatePluginAmbientRule checks the kind and name pattern of a rule.
+func validatePluginAmbientRule(rule PluginAmbientRule) error {
+	if rule.Kind != "" && !IsPluginKind(string(rule.Kind)) {
+		return fmt.Errorf("unrecognized plugin kind %q", rule.Kind)
+	} else if rule.Name == "" {
+		return errors.New("the name pattern is empty")
+	} else if _, err := path.Match(rule.Name, ""); err != nil {
+		return fmt.Errorf("%q is not a valid glob pattern", rule.Name)
+	}
+	return nil
+}
+
+// parsePluginAmbientPolicy parses the value of PluginAmbientPolicyEnvVar into its rules.
+func parsePluginAmbientPolicy(s string) ([]PluginAmbientRule, error) {
+	var rules []PluginAmbientRule
+	for _, entry := range splitEnvList(s) {
+		eq := strings.LastIndex(entry, "=")
+		if eq <= 0 {
+			return nil, fmt.Errorf("%q is not of the form [KIND/]NAME=PREFERENCE", entry)
+		}
+		var rule PluginAmbientRule
+		rule.Name = strings.TrimSpace(entry[:eq])
+		if slash := strings.Index(rule.Name, "/"); slash >= 0 {
+			rule.Kind, rule.Name = PluginKind(rule.Name[:slash]), rule.Name[slash+1:]
+		}
+		if err := validatePluginAmbientRule(rule); err != nil {
+			return nil, fmt.Errorf("%q: %w", entry, err)
+		}
+		preference, err := parsePluginAmbientPreference(strings.TrimSpace(entry[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", entry, err)
+		}
+		rule.Prefer = preference
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// getPluginAmbientPreference returns the ambient preference of the plugin of the given kind and name: that of the
+// first rule of PluginAmbientPolicyEnvVar, or PluginConfigFile if it isn't set, that matches the plugin, or its
+// default if none does.
+func (ctx *Context) getPluginAmbientPreference(kind PluginKind, name string) (PluginAmbientPreference, error) {
+	var rules []PluginAmbientRule
+	if env := os.Getenv(PluginAmbientPolicyEnvVar); env != "" {
+		parsed, err := parsePluginAmbientPolicy(env)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", PluginAmbientPolicyEnvVar, err)
+		}
+		rules = parsed
+	} else {
+		config, err := ctx.GetPluginConfig()
+		if err != nil {
+			return "", err
+		}
+		rules = config.AmbientPolicy
+	}
+	for _, rule := range rules {
+		if rule.matches(kind, name) {
+			return rule.Prefer, nil
+		}
+	}
+	return defaultPluginAmbientPreference(kind, name), nil
+}
+
+// defaultPluginAmbientPreference returns the ambient preference of plugins no rule matches. We currently bundle some
+// plugins with "pulumi" and thus expect them to be next to the pulumi binary, so they're always allowed to be picked
+// up from $PATH, even if PULUMI_IGNORE_AMBIENT_PLUGINS is set.
+func defaultPluginAmbientPreference(kind PluginKind, name string) PluginAmbientPreference {
+	if isBundledPlugin(kind, name) {
+		return PluginAmbientPreferred
+	}
+	if optOut, isFound := os.LookupEnv("PULUMI_IGNORE_AMBIENT_PLUGINS"); isFound && cmdutil.IsTruthy(optOut) {
+		return PluginAmbientIgnored
+	}
+	return PluginAmbientPreferred
+}
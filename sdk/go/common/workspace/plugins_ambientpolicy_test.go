@@ -0,0 +1,131 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePluginAmbientPolicy(t *testing.T) {
+	t.Parallel()
+
+	rules, err := parsePluginAmbientPolicy("resource/aws*=cache, language/*=ambient,random=ignore")
+	require.NoError(t, err)
+	assert.Equal(t, []PluginAmbientRule{
+		{Kind: ResourcePlugin, Name: "aws*", Prefer: PluginCachePreferred},
+		{Kind: LanguagePlugin, Name: "*", Prefer: PluginAmbientPreferred},
+		{Name: "random", Prefer: PluginAmbientIgnored},
+	}, rules)
+
+	for policy, expected := range map[string]string{
+		"aws":                 `"aws" is not of the form [KIND/]NAME=PREFERENCE`,
+		"provider/aws=cache":  `"provider/aws=cache": unrecognized plugin kind "provider"`,
+		"resource/=cache":     `"resource/=cache": the name pattern is empty`,
+		"resource/[aws=cache": `"resource/[aws=cache": "[aws" is not a valid glob pattern`,
+		"aws=path":            `"aws=path": expected "ambient", "cache" or "ignore"; got "path"`,
+	} {
+		_, err := parsePluginAmbientPolicy(policy)
+		assert.EqualError(t, err, expected, policy)
+	}
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestGetPluginAmbientPreference(t *testing.T) {
+	t.Setenv(PluginAmbientPolicyEnvVar, "")
+	t.Setenv("PULUMI_IGNORE_AMBIENT_PLUGINS", "true")
+
+	ctx := &Context{Home: t.TempDir()}
+	writePluginConfig(t, ctx.Home, `ambientPolicy:
+  - kind: resource
+    name: "aws*"
+    prefer: cache
+  - kind: language
+    name: nodejs
+    prefer: ignore
+`)
+	for _, tt := range []struct {
+		kind     PluginKind
+		name     string
+		expected PluginAmbientPreference
+	}{
+		{ResourcePlugin, "aws-native", PluginCachePreferred},
+		// Rules override the exception for bundled plugins...
+		{LanguagePlugin, "nodejs", PluginAmbientIgnored},
+		// ...which otherwise prefer ambient plugins even when PULUMI_IGNORE_AMBIENT_PLUGINS is set,
+		{LanguagePlugin, "python", PluginAmbientPreferred},
+		// ...unlike the plugins no rule matches.
+		{ResourcePlugin, "random", PluginAmbientIgnored},
+	} {
+		preference, err := ctx.getPluginAmbientPreference(tt.kind, tt.name)
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, preference, tt.name)
+	}
+
+	// The environment variable takes precedence over the file.
+	t.Setenv(PluginAmbientPolicyEnvVar, "*=ambient")
+	preference, err := ctx.getPluginAmbientPreference(ResourcePlugin, "aws")
+	require.NoError(t, err)
+	assert.Equal(t, PluginAmbientPreferred, preference)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestResolvePluginPrefersCache(t *testing.T) {
+	if runtime.GOOS == windowsGOOS {
+		t.Skip("uses a shell script as a fake plugin")
+	}
+	t.Setenv("PULUMI_IGNORE_AMBIENT_PLUGINS", "")
+	t.Setenv(PluginAmbientPolicyEnvVar, "resource/mock=cache")
+
+	pathDir := t.TempDir()
+	ambient := filepath.Join(pathDir, "pulumi-resource-mock")
+	require.NoError(t, ioutil.WriteFile(ambient, []byte("#!/bin/sh\nexit 1\n"), 0700))
+	t.Setenv("PATH", pathDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// Until the plugin is installed, the ambient plugin is used.
+	ctx := &Context{Home: t.TempDir()}
+	resolution, err := ctx.ResolvePlugin(ResourcePlugin, "mock", nil)
+	require.NoError(t, err)
+	assert.Equal(t, PluginOriginAmbient, resolution.Origin)
+	assert.Equal(t, ambient, resolution.Path)
+	assert.Equal(t, PluginCachePreferred, resolution.Constraints.AmbientPreference)
+	ambientPlugins, err := ctx.GetAmbientPlugins()
+	require.NoError(t, err)
+	for _, plugin := range ambientPlugins {
+		assert.NotEqual(t, ambient, plugin.Path)
+	}
+
+	// Once it is, the plugin cache takes precedence.
+	_, plugin := newMockPlugin(t)
+	plugin.PluginDir = ""
+	plugin, err = ctx.Plugin(plugin)
+	require.NoError(t, err)
+	require.NoError(t, plugin.Install(ioutil.NopCloser(bytes.NewReader(mockPluginTGZ(t))), false))
+	resolution, err = ctx.ResolvePlugin(ResourcePlugin, "mock", nil)
+	require.NoError(t, err)
+	assert.Equal(t, PluginOriginCache, resolution.Origin)
+
+	// Ignoring ambient plugins only uses the plugin cache.
+	t.Setenv(PluginAmbientPolicyEnvVar, "resource/*=ignore")
+	_, err = ctx.ResolvePlugin(ResourcePlugin, "other", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,316 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/fsutil"
+)
+
+// DefaultPluginAutoUpdateInterval is how often plugins are checked for updates if the policy doesn't say.
+const DefaultPluginAutoUpdateInterval = 24 * time.Hour
+
+// ErrDeploymentInProgress is returned by PluginAutoUpdater.CheckNow while a deployment holds the updater.
+var ErrDeploymentInProgress = errors.New("a deployment is in progress")
+
+var errUpdaterStopped = errors.New("the plugin auto-updater is stopped")
+
+// PluginAutoUpdatePolicy says which plugins a PluginAutoUpdater keeps up to date, and how often it checks them.
+type PluginAutoUpdatePolicy struct {
+	// Plugins are the plugins to keep up to date. No other plugins are updated.
+	Plugins []AutoUpdatePlugin
+	// Interval is how long to wait between checks. If zero, DefaultPluginAutoUpdateInterval is used.
+	Interval time.Duration
+	// OnUpdate, if set, is called with every update the updater installs or fails to install.
+	OnUpdate func(update PluginUpdate)
+}
+
+// AutoUpdatePlugin is a plugin a PluginAutoUpdater keeps up to date.
+type AutoUpdatePlugin struct {
+	// Kind is the plugin's kind.
+	Kind PluginKind
+	// Name is the plugin's name.
+	Name string
+	// PluginDownloadURL is the server the plugin is downloaded from, if it isn't the default one.
+	PluginDownloadURL string
+	// Versions is the range of versions the plugin may be updated to. If nil, newer versions with the same major
+	// version as the newest installed one are compatible.
+	Versions semver.Range
+}
+
+// PluginUpdate records a plugin a PluginAutoUpdater updated.
+type PluginUpdate struct {
+	// Plugin is the new version of the plugin.
+	Plugin PluginInfo
+	// Previous is the newest version that was installed before the update.
+	Previous semver.Version
+	// Time is when the update finished.
+	Time time.Time
+	// Err is why the update failed, if it did. The previous version is still installed.
+	Err error
+}
+
+// PluginAutoUpdater installs newer compatible versions of a policy's plugins in the background. Versions that are
+// already installed are kept, so programs that need them keep working. Updates never run while a deployment holds the
+// updater, and a file lock in the plugin directory keeps updaters in separate processes from checking at the same
+// time.
+type PluginAutoUpdater struct {
+	ctx    *Context
+	policy PluginAutoUpdatePolicy
+
+	m        sync.Mutex
+	cond     *sync.Cond
+	holds    int  // the number of deployments holding the updater
+	updating bool // true while the updater is checking for updates
+	stopped  bool
+	updates  []PluginUpdate
+
+	stop chan struct{}
+}
+
+// StartPluginAutoUpdate starts checking for and installing updates to the policy's plugins, right away and then
+// every interval, until the updater is stopped.
+func StartPluginAutoUpdate(policy PluginAutoUpdatePolicy) (*PluginAutoUpdater, error) {
+	return (&Context{}).StartPluginAutoUpdate(policy)
+}
+
+// StartPluginAutoUpdate starts updating the policy's plugins in the context's plugin directory, like the package-level
+// StartPluginAutoUpdate.
+func (ctx *Context) StartPluginAutoUpdate(policy PluginAutoUpdatePolicy) (*PluginAutoUpdater, error) {
+	updater, err := ctx.NewPluginAutoUpdater(policy)
+	if err != nil {
+		return nil, err
+	}
+	go updater.run()
+	return updater, nil
+}
+
+// NewPluginAutoUpdater returns an updater for the policy's plugins in the context's plugin directory that only checks
+// for updates when CheckNow is called.
+func (ctx *Context) NewPluginAutoUpdater(policy PluginAutoUpdatePolicy) (*PluginAutoUpdater, error) {
+	for i, plugin := range policy.Plugins {
+		if !IsPluginKind(string(plugin.Kind)) {
+			return nil, fmt.Errorf("plugins[%d]: unrecognized plugin kind %q", i, plugin.Kind)
+		} else if plugin.Name == "" {
+			return nil, fmt.Errorf("plugins[%d]: name must be set", i)
+		}
+	}
+	if policy.Interval < 0 {
+		return nil, fmt.Errorf("plugin update interval must be positive; got %v", policy.Interval)
+	} else if policy.Interval == 0 {
+		policy.Interval = DefaultPluginAutoUpdateInterval
+	}
+
+	updater := &PluginAutoUpdater{
+		ctx:    ctx,
+		policy: policy,
+		stop:   make(chan struct{}),
+	}
+	updater.cond = sync.NewCond(&updater.m)
+	return updater, nil
+}
+
+// Hold keeps the updater from installing updates until release is called. Deployments hold the updater while they run
+// so the plugins they use don't change under them. If the updater is installing updates, Hold waits for it to finish.
+func (updater *PluginAutoUpdater) Hold() (release func()) {
+	updater.m.Lock()
+	defer updater.m.Unlock()
+	for updater.updating {
+		updater.cond.Wait()
+	}
+	updater.holds++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			updater.m.Lock()
+			defer updater.m.Unlock()
+			updater.holds--
+		})
+	}
+}
+
+// Updates returns the updates the updater has installed or failed to install, oldest first.
+func (updater *PluginAutoUpdater) Updates() []PluginUpdate {
+	updater.m.Lock()
+	defer updater.m.Unlock()
+	return append([]PluginUpdate(nil), updater.updates...)
+}
+
+// Stop stops the updater, waiting for a check that is in progress to finish. A stopped updater doesn't check for
+// updates again.
+func (updater *PluginAutoUpdater) Stop() {
+	updater.m.Lock()
+	defer updater.m.Unlock()
+	if !updater.stopped {
+		updater.stopped = true
+		close(updater.stop)
+	}
+	for updater.updating {
+		updater.cond.Wait()
+	}
+}
+
+// run checks for updates every interval until the updater is stopped.
+func (updater *PluginAutoUpdater) run() {
+	ticker := time.NewTicker(updater.policy.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := updater.CheckNow(); err != nil && !errors.Is(err, errUpdaterStopped) {
+			updater.ctx.logf(3, "checking for plugin updates failed: %v", err)
+		}
+		select {
+		case <-updater.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckNow checks for and installs updates to the policy's plugins, returning the updates it installed or failed to
+// install. It returns ErrDeploymentInProgress, without checking, if a deployment holds the updater, and an error if
+// the updater is stopped.
+func (updater *PluginAutoUpdater) CheckNow() ([]PluginUpdate, error) {
+	updater.m.Lock()
+	for updater.updating {
+		updater.cond.Wait()
+	}
+	if updater.stopped {
+		updater.m.Unlock()
+		return nil, errUpdaterStopped
+	} else if updater.holds > 0 {
+		updater.m.Unlock()
+		return nil, ErrDeploymentInProgress
+	}
+	updater.updating = true
+	updater.m.Unlock()
+	defer func() {
+		updater.m.Lock()
+		defer updater.m.Unlock()
+		updater.updating = false
+		updater.cond.Broadcast()
+	}()
+
+	dir, err := updater.ctx.GetPluginDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, notWritableError(dir, err)
+	}
+	mutex := fsutil.NewFileMutex(filepath.Join(dir, ".autoupdate.lock"))
+	if err := mutex.Lock(); err != nil {
+		return nil, notWritableError(dir, err)
+	}
+	defer func() { contract.IgnoreError(mutex.Unlock()) }()
+
+	installed, err := updater.ctx.GetPlugins()
+	if err != nil {
+		return nil, err
+	}
+
+	// The latest versions of the installed plugins are looked up together, since they can be batched.
+	var plugins []AutoUpdatePlugin
+	var infos []PluginInfo
+	var currents []semver.Version
+	var updates []PluginUpdate
+	for _, plugin := range updater.policy.Plugins {
+		var current *semver.Version
+		for _, p := range installed {
+			if p.Kind == plugin.Kind && p.Name == plugin.Name && p.Version != nil &&
+				(current == nil || p.Version.GT(*current)) {
+				current = p.Version
+			}
+		}
+		if current == nil {
+			continue
+		}
+		info, err := updater.ctx.Plugin(PluginInfo{
+			Kind:              plugin.Kind,
+			Name:              plugin.Name,
+			PluginDownloadURL: plugin.PluginDownloadURL,
+		})
+		if err != nil {
+			updates = append(updates, updater.record(updater.failed(info, *current, err)))
+			continue
+		}
+		plugins, infos, currents = append(plugins, plugin), append(infos, info), append(currents, *current)
+	}
+
+	for i, latest := range updater.ctx.GetLatestVersions(infos) {
+		if latest.Err != nil {
+			latest.Plugin.logf(5, "could not get the latest version of plugin %s: %v", latest.Plugin, latest.Err)
+			continue
+		}
+		if update, ok := updater.update(plugins[i], latest.Plugin, currents[i], *latest.Version); ok {
+			updates = append(updates, updater.record(update))
+		}
+	}
+	return updates, nil
+}
+
+// record records the update, and reports it to the policy's OnUpdate.
+func (updater *PluginAutoUpdater) record(update PluginUpdate) PluginUpdate {
+	updater.m.Lock()
+	updater.updates = append(updater.updates, update)
+	updater.m.Unlock()
+	if updater.policy.OnUpdate != nil {
+		updater.policy.OnUpdate(update)
+	}
+	return update
+}
+
+// update installs the latest version of the plugin if it's newer than current, the newest installed version, and
+// compatible with it, returning false if there was nothing to update.
+func (updater *PluginAutoUpdater) update(plugin AutoUpdatePlugin, info PluginInfo, current,
+	latest semver.Version) (PluginUpdate, bool) {
+	compatible := plugin.Versions
+	if compatible == nil {
+		compatible = semver.MustParseRange(fmt.Sprintf(">%s <%d.0.0", current, current.Major+1))
+	}
+	if !latest.GT(current) || !compatible(latest) {
+		return PluginUpdate{}, false
+	}
+
+	info.Version = &latest
+	info.logf(1, "updating plugin %s from v%s", info, current)
+	tarball, _, err := updater.ctx.Download(info)
+	if err != nil {
+		return updater.failed(info, current, fmt.Errorf("downloading %s plugin %s: %w", info.Kind, info, err)), true
+	}
+	redownload := func() (io.ReadCloser, error) {
+		tarball, _, err := updater.ctx.Download(info)
+		return tarball, err
+	}
+	if err := info.InstallWithRedownload(tarball, redownload, false, nil); err != nil {
+		return updater.failed(info, current, fmt.Errorf("installing %s plugin %s: %w", info.Kind, info, err)), true
+	}
+	return PluginUpdate{Plugin: info, Previous: current, Time: updater.ctx.now()}, true
+}
+
+func (updater *PluginAutoUpdater) failed(info PluginInfo, previous semver.Version, err error) PluginUpdate {
+	info.warnf("could not update plugin %s: %v", info, err)
+	return PluginUpdate{Plugin: info, Previous: previous, Time: updater.ctx.now(), Err: err}
+}
@@ -0,0 +1,175 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// latestSource serves a tarball for every version of a plugin, and reports a fixed latest version.
+type latestSource struct {
+	latest  semver.Version
+	tarball []byte
+}
+
+func (s *latestSource) GetLatestVersion(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
+	return &s.latest, nil
+}
+
+func (s *latestSource) Download(version semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	return ioutil.NopCloser(bytes.NewReader(s.tarball)), int64(len(s.tarball)), nil
+}
+
+// newAutoUpdateTestContext returns a context with version 1.0.0 of the mock resource plugin installed, whose source
+// reports the given latest version.
+func newAutoUpdateTestContext(t *testing.T, latest string) *Context {
+	source := &latestSource{latest: semver.MustParse(latest), tarball: mockPluginTGZ(t)}
+	ctx := &Context{
+		PluginDir:    t.TempDir(),
+		PluginSource: func(PluginInfo) PluginSource { return source },
+	}
+	v := semver.MustParse("1.0.0")
+	info, err := ctx.Plugin(PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &v})
+	require.NoError(t, err)
+	require.NoError(t, info.Install(ioutil.NopCloser(bytes.NewReader(source.tarball)), false))
+	return ctx
+}
+
+func installedVersions(t *testing.T, ctx *Context) []string {
+	plugins, err := ctx.GetPlugins()
+	require.NoError(t, err)
+	var versions []string
+	for _, plugin := range plugins {
+		versions = append(versions, plugin.Version.String())
+	}
+	return versions
+}
+
+func TestPluginAutoUpdate(t *testing.T) {
+	t.Parallel()
+
+	mock := AutoUpdatePlugin{Kind: ResourcePlugin, Name: "mock"}
+	tests := []struct {
+		name     string
+		latest   string
+		plugins  []AutoUpdatePlugin
+		expected []string
+	}{
+		{"compatible", "1.2.0", []AutoUpdatePlugin{mock}, []string{"1.0.0", "1.2.0"}},
+		{"new major version", "2.0.0", []AutoUpdatePlugin{mock}, []string{"1.0.0"}},
+		{"allowed major version", "2.0.0", []AutoUpdatePlugin{
+			{Kind: ResourcePlugin, Name: "mock", Versions: semver.MustParseRange(">=1.0.0 <3.0.0")},
+		}, []string{"1.0.0", "2.0.0"}},
+		{"up to date", "1.0.0", []AutoUpdatePlugin{mock}, []string{"1.0.0"}},
+		{"not installed", "1.2.0", []AutoUpdatePlugin{{Kind: ResourcePlugin, Name: "other"}}, []string{"1.0.0"}},
+		{"not allowed", "1.2.0", nil, []string{"1.0.0"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := newAutoUpdateTestContext(t, tt.latest)
+			updater, err := ctx.NewPluginAutoUpdater(PluginAutoUpdatePolicy{Plugins: tt.plugins})
+			require.NoError(t, err)
+			updates, err := updater.CheckNow()
+			require.NoError(t, err)
+			assert.Equal(t, updates, updater.Updates())
+			assert.ElementsMatch(t, tt.expected, installedVersions(t, ctx))
+			if len(tt.expected) > 1 {
+				require.Len(t, updates, 1)
+				assert.NoError(t, updates[0].Err)
+				assert.Equal(t, tt.latest, updates[0].Plugin.Version.String())
+				assert.Equal(t, "1.0.0", updates[0].Previous.String())
+			} else {
+				assert.Empty(t, updates)
+			}
+		})
+	}
+}
+
+func TestPluginAutoUpdateHold(t *testing.T) {
+	t.Parallel()
+
+	ctx := newAutoUpdateTestContext(t, "1.2.0")
+	updater, err := ctx.NewPluginAutoUpdater(PluginAutoUpdatePolicy{
+		Plugins: []AutoUpdatePlugin{{Kind: ResourcePlugin, Name: "mock"}},
+	})
+	require.NoError(t, err)
+
+	release := updater.Hold()
+	_, err = updater.CheckNow()
+	assert.True(t, errors.Is(err, ErrDeploymentInProgress), "unexpected error %v", err)
+	assert.Equal(t, []string{"1.0.0"}, installedVersions(t, ctx))
+
+	release()
+	release()
+	updates, err := updater.CheckNow()
+	require.NoError(t, err)
+	assert.Len(t, updates, 1)
+
+	updater.Stop()
+	_, err = updater.CheckNow()
+	assert.Error(t, err)
+}
+
+func TestStartPluginAutoUpdate(t *testing.T) {
+	t.Parallel()
+
+	ctx := newAutoUpdateTestContext(t, "1.2.0")
+	updated := make(chan PluginUpdate, 1)
+	updater, err := ctx.StartPluginAutoUpdate(PluginAutoUpdatePolicy{
+		Plugins:  []AutoUpdatePlugin{{Kind: ResourcePlugin, Name: "mock"}},
+		Interval: time.Hour,
+		OnUpdate: func(update PluginUpdate) { updated <- update },
+	})
+	require.NoError(t, err)
+	defer updater.Stop()
+
+	select {
+	case update := <-updated:
+		assert.NoError(t, update.Err)
+		assert.Equal(t, "1.2.0", update.Plugin.Version.String())
+	case <-time.After(30 * time.Second):
+		t.Fatal("timed out waiting for the plugin to be updated")
+	}
+}
+
+func TestNewPluginAutoUpdaterErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := (&Context{}).NewPluginAutoUpdater(PluginAutoUpdatePolicy{
+		Plugins: []AutoUpdatePlugin{{Kind: "provider", Name: "aws"}},
+	})
+	assert.EqualError(t, err, `plugins[0]: unrecognized plugin kind "provider"`)
+	_, err = (&Context{}).NewPluginAutoUpdater(PluginAutoUpdatePolicy{
+		Plugins: []AutoUpdatePlugin{{Kind: ResourcePlugin}},
+	})
+	assert.EqualError(t, err, "plugins[0]: name must be set")
+	_, err = (&Context{}).NewPluginAutoUpdater(PluginAutoUpdatePolicy{Interval: -time.Second})
+	assert.EqualError(t, err, "plugin update interval must be positive; got -1s")
+}
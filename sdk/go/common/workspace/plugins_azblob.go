@@ -0,0 +1,323 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/blang/semver"
+)
+
+// AzureBlobScheme prefixes the PluginDownloadURL of plugins that are downloaded from an Azure Blob Storage container,
+// e.g. `azblob://my-container/plugins`. The storage account is set by a `storage_account` query parameter, or
+// otherwise by `AZURE_STORAGE_ACCOUNT`. Plugins are downloaded from under the prefix by the name of their tarball,
+// like they are from other PluginDownloadURLs, with the SAS token `AZURE_STORAGE_SAS_TOKEN` sets or the credentials
+// Azure's DefaultAzureCredential would find. Their latest version is the latest version with a tarball for the
+// current platform under the prefix.
+const AzureBlobScheme = "azblob://"
+
+const (
+	// azureStorageResource is the resource access tokens for Azure Storage are requested for.
+	azureStorageResource = "https://storage.azure.com/"
+	// azureStorageVersion is the version of the Blob service API requests are sent with, the first to support OAuth.
+	azureStorageVersion = "2017-11-09"
+	// azureDefaultAuthority is the default Azure Active Directory authority.
+	azureDefaultAuthority = "https://login.microsoftonline.com/"
+	// azureIMDSTokenURL is the endpoint of the instance metadata service that managed identities get tokens from.
+	azureIMDSTokenURL = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
+
+// azureBlobSource downloads plugins from an Azure Blob Storage container.
+type azureBlobSource struct {
+	name        string
+	kind        PluginKind
+	downloadURL string
+	ctx         *Context
+}
+
+func newAzureBlobSource(name string, kind PluginKind, downloadURL string, ctx *Context) *azureBlobSource {
+	return &azureBlobSource{
+		name:        name,
+		kind:        kind,
+		downloadURL: downloadURL,
+		ctx:         ctx,
+	}
+}
+
+func (source *azureBlobSource) GetLatestVersion(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
+	versions, listingURL, err := source.listVersions(getHTTPResponse)
+	if err != nil {
+		return nil, err
+	}
+	return latestBucketVersion(versions, listingURL, source.kind, source.name)
+}
+
+// ListVersions implements versionListingSource, using the versions with a tarball for the current platform.
+func (source *azureBlobSource) ListVersions(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, error) {
+	versions, _, err := source.listVersions(getHTTPResponse)
+	return versions, err
+}
+
+// listVersions returns the versions with a tarball for the current platform, along with the URL it listed.
+func (source *azureBlobSource) listVersions(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, string, error) {
+	listingURL, err := bucketListingURL(source.downloadURL, source.kind, source.name)
+	if err != nil {
+		return nil, "", err
+	}
+	location, err := parseAzureBlobURL(listingURL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var keys []string
+	query := url.Values{
+		"restype": {"container"},
+		"comp":    {"list"},
+		"prefix":  {bucketObjectKey(location.prefix, fmt.Sprintf("pulumi-%s-%s-v", source.kind, source.name))},
+	}
+	for {
+		req, err := source.request(location, "", query, "application/xml")
+		if err != nil {
+			return nil, "", err
+		}
+		var page struct {
+			Blobs []struct {
+				Name string `xml:"Name"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string `xml:"NextMarker"`
+		}
+		err = getBucketListing(req, getHTTPResponse, func(b []byte) error { return xml.Unmarshal(b, &page) })
+		if err != nil {
+			return nil, "", fmt.Errorf("listing %s: %w", listingURL, err)
+		}
+		for _, blob := range page.Blobs {
+			keys = append(keys, blob.Name)
+		}
+		if page.NextMarker == "" {
+			break
+		}
+		query.Set("marker", page.NextMarker)
+	}
+
+	return bucketPluginVersions(keys, location.prefix, source.kind, source.name), listingURL, nil
+}
+
+func (source *azureBlobSource) Download(
+	version semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	downloadURL, err := expandURLEnv(source.downloadURL)
+	if err != nil {
+		return nil, -1, err
+	}
+	dir, file := splitPluginURL(downloadURL, source.kind, source.name, version, opSy, arch)
+	location, err := parseAzureBlobURL(dir)
+	if err != nil {
+		return nil, -1, err
+	}
+	blob := bucketObjectKey(location.prefix, file)
+	logf(1, sourceLogFields(source.kind, source.name), "%s downloading from %s%s/%s in storage account %s",
+		source.name, AzureBlobScheme, location.container, blob, location.account)
+
+	req, err := source.request(location, blob, nil, "application/octet-stream")
+	if err != nil {
+		return nil, -1, err
+	}
+	return getHTTPResponse(req)
+}
+
+// request returns a request for the blob in the location's container, or for the container itself if blob is "",
+// authorized by the SAS token or access token its storage account is read with.
+func (source *azureBlobSource) request(location azureBlobLocation, blob string, query url.Values,
+	accept string) (*http.Request, error) {
+	u := url.URL{
+		Scheme: "https",
+		Host:   location.account + "." + location.domain,
+		Path:   "/" + location.container,
+	}
+	if blob != "" {
+		u.Path += "/" + blob
+	}
+	if query == nil {
+		query = url.Values{}
+	}
+
+	header := http.Header{}
+	header.Set("Accept", accept)
+	header.Set("X-Ms-Version", azureStorageVersion)
+	if sas := strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"); sas != "" {
+		sasQuery, err := url.ParseQuery(sas)
+		if err != nil {
+			return nil, fmt.Errorf("parsing AZURE_STORAGE_SAS_TOKEN: %w", err)
+		}
+		for name, values := range sasQuery {
+			query[name] = values
+		}
+	} else {
+		token, err := source.ctx.getAzureAccessToken()
+		if err != nil {
+			return nil, fmt.Errorf("getting Azure credentials to download from storage account %s: %w",
+				location.account, err)
+		}
+		if token != "" {
+			header.Set("Authorization", "Bearer "+token)
+		} else {
+			logf(5, sourceLogFields(source.kind, source.name),
+				"no Azure credentials found; reading storage account %s anonymously", location.account)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	return req, nil
+}
+
+// azureBlobLocation is where plugins are in Azure Blob Storage, as set by an `azblob://` PluginDownloadURL.
+type azureBlobLocation struct {
+	account   string
+	domain    string
+	container string
+	prefix    string
+}
+
+// parseAzureBlobURL parses an `azblob://container/prefix?storage_account=account&domain=domain` PluginDownloadURL.
+func parseAzureBlobURL(rawURL string) (azureBlobLocation, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme+"://" != AzureBlobScheme || u.Host == "" {
+		return azureBlobLocation{}, fmt.Errorf("expected Azure Blob Storage URL to be %scontainer/prefix; got %q",
+			AzureBlobScheme, rawURL)
+	}
+	location := azureBlobLocation{
+		account:   os.Getenv("AZURE_STORAGE_ACCOUNT"),
+		domain:    "blob.core.windows.net",
+		container: u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+	}
+	query := u.Query()
+	for name := range query {
+		if name != "storage_account" && name != "domain" {
+			return azureBlobLocation{}, fmt.Errorf("Azure Blob Storage URL %q: unrecognized query parameter %q",
+				rawURL, name)
+		}
+	}
+	if account := query.Get("storage_account"); account != "" {
+		location.account = account
+	}
+	if domain := query.Get("domain"); domain != "" {
+		location.domain = domain
+	}
+	if location.account == "" {
+		return azureBlobLocation{}, fmt.Errorf("Azure Blob Storage URL %q: the storage account must be set by its "+
+			"storage_account query parameter or AZURE_STORAGE_ACCOUNT", rawURL)
+	}
+	return location, nil
+}
+
+// getAzureAccessToken returns an access token for Azure Storage from the credentials DefaultAzureCredential would
+// find: a client secret or federated token in the environment, the managed identity of the machine or app, and the
+// Azure CLI. It returns "" if there are none.
+func (ctx *Context) getAzureAccessToken() (string, error) {
+	tenant, client := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID")
+	authority := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authority == "" {
+		authority = azureDefaultAuthority
+	}
+	tokenURL := strings.TrimSuffix(authority, "/") + "/" + url.PathEscape(tenant) + "/oauth2/v2.0/token"
+	scope := azureStorageResource + ".default"
+	if secret := os.Getenv("AZURE_CLIENT_SECRET"); tenant != "" && client != "" && secret != "" {
+		return ctx.getOAuthToken(http.MethodPost, tokenURL, nil, url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {client},
+			"client_secret": {secret},
+			"scope":         {scope},
+		})
+	}
+	if tokenFile := os.Getenv("AZURE_FEDERATED_TOKEN_FILE"); tenant != "" && client != "" && tokenFile != "" {
+		assertion, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("reading federated token: %w", err)
+		}
+		return ctx.getOAuthToken(http.MethodPost, tokenURL, nil, url.Values{
+			"grant_type":            {"client_credentials"},
+			"client_id":             {client},
+			"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+			"client_assertion":      {strings.TrimSpace(string(assertion))},
+			"scope":                 {scope},
+		})
+	}
+
+	token, err := ctx.getAzureManagedIdentityToken(client)
+	if err == nil {
+		return token, nil
+	}
+	ctx.logf(5, "no Azure credentials from a managed identity: %v", err)
+	token, err = getAzureCLIToken()
+	if err == nil {
+		return token, nil
+	}
+	ctx.logf(5, "no Azure credentials from the Azure CLI: %v", err)
+	return "", nil
+}
+
+// getAzureManagedIdentityToken returns an access token for the managed identity of the App Service app, or of the
+// virtual machine, the client ID of a user-assigned identity selects.
+func (ctx *Context) getAzureManagedIdentityToken(client string) (string, error) {
+	query := url.Values{"resource": {azureStorageResource}}
+	if client != "" {
+		query.Set("client_id", client)
+	}
+	if endpoint, secret := os.Getenv("IDENTITY_ENDPOINT"), os.Getenv("IDENTITY_HEADER"); endpoint != "" && secret != "" {
+		query.Set("api-version", "2019-08-01")
+		return ctx.getOAuthToken(http.MethodGet, endpoint+"?"+query.Encode(),
+			http.Header{"X-Identity-Header": {secret}}, nil)
+	}
+	query.Set("api-version", "2018-02-01")
+	return ctx.getOAuthToken(http.MethodGet, azureIMDSTokenURL+"?"+query.Encode(),
+		http.Header{"Metadata": {"true"}}, nil)
+}
+
+// getAzureCLIToken returns an access token for Azure Storage from the account the Azure CLI is logged in to.
+func getAzureCLIToken() (string, error) {
+	out, err := exec.Command("az", "account", "get-access-token", "--resource", azureStorageResource,
+		"--output", "json").Output()
+	if err != nil {
+		return "", err
+	}
+	var token struct {
+		AccessToken string `json:"accessToken"`
+	}
+	if err := json.Unmarshal(out, &token); err != nil {
+		return "", fmt.Errorf("parsing the output of `az account get-access-token`: %w", err)
+	} else if token.AccessToken == "" {
+		return "", errors.New("`az account get-access-token` returned no access token")
+	}
+	return token.AccessToken, nil
+}
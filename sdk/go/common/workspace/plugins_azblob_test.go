@@ -0,0 +1,136 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestAzureBlobSource(t *testing.T) {
+	t.Setenv("AZURE_STORAGE_ACCOUNT", "")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_TENANT_ID", "my-tenant")
+	t.Setenv("AZURE_CLIENT_ID", "my-client")
+	t.Setenv("AZURE_CLIENT_SECRET", "secret")
+	t.Setenv("AZURE_AUTHORITY_HOST", "")
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", "")
+	t.Setenv("IDENTITY_ENDPOINT", "")
+	t.Setenv("IDENTITY_HEADER", "")
+
+	platform := runtime.GOOS + "-" + runtime.GOARCH
+	var requests []*http.Request
+	respond := func(req *http.Request, body string) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	}
+	ctx := &Context{Home: t.TempDir(), HTTPClient: &http.Client{Transport: roundTripperFunc(
+		func(req *http.Request) (*http.Response, error) {
+			switch {
+			case req.URL.String() == "https://login.microsoftonline.com/my-tenant/oauth2/v2.0/token":
+				require.NoError(t, req.ParseForm())
+				assert.Equal(t, "client_credentials", req.PostForm.Get("grant_type"))
+				assert.Equal(t, "secret", req.PostForm.Get("client_secret"))
+				assert.Equal(t, "https://storage.azure.com/.default", req.PostForm.Get("scope"))
+				return respond(req, `{"access_token": "secret-token"}`)
+			case strings.HasPrefix(req.URL.String(), azureIMDSTokenURL):
+				assert.Equal(t, "true", req.Header.Get("Metadata"))
+				assert.Equal(t, "my-client", req.URL.Query().Get("client_id"))
+				return respond(req, `{"access_token": "identity-token"}`)
+			}
+			requests = append(requests, req)
+			assert.Equal(t, azureStorageVersion, req.Header.Get("X-Ms-Version"))
+			switch {
+			case req.URL.Query().Get("comp") != "list":
+				return respond(req, "tarball")
+			case req.URL.Query().Get("marker") == "":
+				return respond(req, fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+					<EnumerationResults><Blobs>
+						<Blob><Name>plugins/pulumi-resource-test-v1.0.0-%[1]s.tar.gz</Name></Blob>
+						<Blob><Name>plugins/pulumi-resource-test-v3.0.0-plan9-mips.tar.gz</Name></Blob>
+					</Blobs><NextMarker>two</NextMarker></EnumerationResults>`, platform))
+			default:
+				return respond(req, fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+					<EnumerationResults><Blobs>
+						<Blob><Name>plugins/pulumi-resource-test-v1.1.0-%s.tar.gz</Name></Blob>
+					</Blobs><NextMarker /></EnumerationResults>`, platform))
+			}
+		})}}
+	info, err := ctx.Plugin(PluginInfo{
+		Name:              "test",
+		Kind:              ResourcePlugin,
+		PluginDownloadURL: "azblob://my-container/plugins?storage_account=myaccount",
+	})
+	require.NoError(t, err)
+
+	// The latest version is found by listing the tarballs under the prefix, page by page.
+	latest, err := ctx.GetLatestVersion(info)
+	require.NoError(t, err)
+	assert.Equal(t, "1.1.0", latest.String())
+	require.Len(t, requests, 2)
+	assert.Equal(t, "myaccount.blob.core.windows.net", requests[0].URL.Host)
+	assert.Equal(t, "/my-container", requests[0].URL.Path)
+	assert.Equal(t, "plugins/pulumi-resource-test-v", requests[0].URL.Query().Get("prefix"))
+	assert.Equal(t, "two", requests[1].URL.Query().Get("marker"))
+	assert.Equal(t, "Bearer secret-token", requests[1].Header.Get("Authorization"))
+
+	// Tarballs are downloaded with the client secret's token.
+	info.Version = latest
+	body, _, err := ctx.Download(info)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.Equal(t, "tarball", string(b))
+	req := requests[len(requests)-1]
+	assert.Equal(t, "/my-container/plugins/pulumi-resource-test-v1.1.0-"+platform+".tar.gz", req.URL.Path)
+	assert.Equal(t, "Bearer secret-token", req.Header.Get("Authorization"))
+
+	// Without a client secret, the token of the managed identity is used.
+	t.Setenv("AZURE_CLIENT_SECRET", "")
+	_, _, err = ctx.Download(info)
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer identity-token", requests[len(requests)-1].Header.Get("Authorization"))
+
+	// A SAS token is used instead of credentials.
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "?sv=2020-08-04&sig=signature")
+	_, _, err = ctx.Download(info)
+	require.NoError(t, err)
+	req = requests[len(requests)-1]
+	assert.Empty(t, req.Header.Get("Authorization"))
+	assert.Equal(t, "signature", req.URL.Query().Get("sig"))
+
+	// The storage account must be set.
+	info.PluginDownloadURL = "azblob://my-container"
+	_, _, err = ctx.Download(info)
+	assert.EqualError(t, err, `Azure Blob Storage URL "azblob://my-container": the storage account must be set by `+
+		`its storage_account query parameter or AZURE_STORAGE_ACCOUNT`)
+	t.Setenv("AZURE_STORAGE_ACCOUNT", "envaccount")
+	_, _, err = ctx.Download(info)
+	require.NoError(t, err)
+	assert.Equal(t, "envaccount.blob.core.windows.net", requests[len(requests)-1].URL.Host)
+}
@@ -0,0 +1,107 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"net/url"
+	"os"
+	"strings"
+)
+
+// PulumiAccessTokenEnvVar is the environment variable holding the access token for the current Pulumi backend, which
+// takes precedence over the token stored by `pulumi login`.
+const PulumiAccessTokenEnvVar = "PULUMI_ACCESS_TOKEN"
+
+// defaultCloudURL is the backend the CLI logs in to when none is configured, matching httpstate.PulumiCloudURL.
+const defaultCloudURL = "https://api.pulumi.com"
+
+// currentCloudURL returns the URL of the current Pulumi backend, defaulting to the Pulumi Service.
+func currentCloudURL() (string, error) {
+	current, err := GetCurrentCloudURL()
+	if err != nil {
+		return "", err
+	}
+	if current == "" {
+		current = defaultCloudURL
+	}
+	return current, nil
+}
+
+// backendAccessToken returns the access token for the Pulumi backend at cloudURL, or "" if the user isn't logged in to
+// it. PULUMI_ACCESS_TOKEN is used for the current backend, and otherwise the token stored by `pulumi login`.
+func backendAccessToken(cloudURL string) (string, error) {
+	current, err := currentCloudURL()
+	if err != nil {
+		return "", err
+	}
+	if token := os.Getenv(PulumiAccessTokenEnvVar); token != "" && sameOrigin(cloudURL, current) {
+		filterCredentials(token)
+		return token, nil
+	}
+
+	creds, err := GetStoredCredentials()
+	if err != nil {
+		return "", err
+	}
+	for backend, token := range creds.AccessTokens {
+		if sameOrigin(cloudURL, backend) && token != "" {
+			filterCredentials(token)
+			return token, nil
+		}
+	}
+	return "", nil
+}
+
+// pluginBackendAccessToken returns the access token to authenticate a plugin download from downloadURL with, if it
+// points at a Pulumi backend the user is logged in to. This lets organizations host private plugins on their backend
+// without a second credential. The token is only sent to the exact origin (scheme, host and port) of the backend it
+// was issued by.
+func pluginBackendAccessToken(downloadURL string) (string, error) {
+	backends := map[string]bool{}
+	if current, err := currentCloudURL(); err == nil {
+		backends[current] = true
+	}
+	creds, err := GetStoredCredentials()
+	if err != nil {
+		return "", err
+	}
+	for backend := range creds.AccessTokens {
+		backends[backend] = true
+	}
+
+	for backend := range backends {
+		if sameOrigin(downloadURL, backend) {
+			return backendAccessToken(backend)
+		}
+	}
+	return "", nil
+}
+
+// sameOrigin returns true if a and b are HTTP(S) URLs with the same scheme, host and port.
+func sameOrigin(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	scheme := strings.ToLower(ua.Scheme)
+	if scheme != "https" && scheme != "http" {
+		return false
+	}
+	return scheme == strings.ToLower(ub.Scheme) && strings.EqualFold(ua.Host, ub.Host)
+}
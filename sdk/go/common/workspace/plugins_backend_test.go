@@ -0,0 +1,117 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+func TestSameOrigin(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"https://api.pulumi.com/api/orgs/acme/plugins", "https://api.pulumi.com", true},
+		{"https://API.pulumi.com/plugins", "https://api.pulumi.com/", true},
+		{"http://api.pulumi.com/plugins", "https://api.pulumi.com", false},
+		{"https://api.pulumi.com:8443/plugins", "https://api.pulumi.com", false},
+		{"https://api.pulumi.com.evil.example/plugins", "https://api.pulumi.com", false},
+		{"file:///tmp/plugins", "file:///tmp/plugins", false},
+		{"s3://bucket/plugins", "s3://bucket", false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.a, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.expected, sameOrigin(tt.a, tt.b))
+		})
+	}
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestPluginURLSourceBackendAccessToken(t *testing.T) {
+	t.Setenv(PulumiHomeEnvVar, t.TempDir())
+	t.Setenv(PulumiBackendURLEnvVar, "https://pulumi.corp.example")
+	t.Setenv(PulumiAccessTokenEnvVar, "")
+	require.NoError(t, StoreAccount("https://pulumi.corp.example", Account{AccessToken: "stored"}, true))
+	require.NoError(t, StoreAccount("https://other.example", Account{AccessToken: "other"}, false))
+
+	version := semver.MustParse("1.0.0")
+	download := func(downloadURL string) string {
+		var authorization string
+		getHTTPResponse := func(req *http.Request) (io.ReadCloser, int64, error) {
+			authorization = req.Header.Get("Authorization")
+			return newMockReadCloserString("data")
+		}
+		source := newPluginURLSource("acme", ResourcePlugin, downloadURL)
+		_, _, err := source.Download(version, "linux", "amd64", getHTTPResponse)
+		require.NoError(t, err)
+		return authorization
+	}
+
+	// Plugins hosted by a backend the user is logged in to are downloaded with that backend's token.
+	assert.Equal(t, "token stored", download("https://pulumi.corp.example/api/orgs/acme/plugins"))
+	assert.Equal(t, "token other", download("https://other.example/plugins"))
+	// Other hosts never see a token.
+	assert.Empty(t, download("https://cdn.example.com/plugins"))
+
+	// PULUMI_ACCESS_TOKEN overrides the stored token of the current backend only.
+	t.Setenv(PulumiAccessTokenEnvVar, "from-env")
+	assert.Equal(t, "token from-env", download("https://pulumi.corp.example/api/orgs/acme/plugins"))
+	assert.Equal(t, "token other", download("https://other.example/plugins"))
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestBackendAccessTokenFiltered(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte("ok"))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+	t.Setenv(PulumiHomeEnvVar, t.TempDir())
+	t.Setenv(PulumiBackendURLEnvVar, server.URL)
+	t.Setenv(PulumiAccessTokenEnvVar, "")
+
+	// The token stored by `pulumi login` is kept out of the logs of the requests it's sent with.
+	require.NoError(t, StoreAccount(server.URL, Account{AccessToken: "pul-3f9c2a7e"}, true))
+	req, err := buildHTTPRequest(server.URL+"/api/orgs/acme/plugins/test.tar.gz", "")
+	require.NoError(t, err)
+	token, err := pluginBackendAccessToken(req.URL.String())
+	require.NoError(t, err)
+	require.Equal(t, "pul-3f9c2a7e", token)
+	req.Header.Set("Authorization", "token "+token)
+
+	logger := &recordingLogger{}
+	ctx := &Context{PluginDir: t.TempDir(), Logger: logger}
+	r, _, err := ctx.sendHTTPRequest(req)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.NotEmpty(t, logger.entries)
+	for _, entry := range logger.entries {
+		assert.NotContains(t, entry.message, "pul-3f9c2a7e")
+	}
+	assert.Equal(t, "token [credential]", logging.FilterString("token pul-3f9c2a7e"))
+}
@@ -0,0 +1,180 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PluginInstallConcurrencyEnvVar sets how many plugins batch installs download and install at once.
+const PluginInstallConcurrencyEnvVar = "PULUMI_PLUGIN_INSTALL_CONCURRENCY"
+
+// ErrInstallSkipped is returned by the install function passed to InstallPluginBatch for plugins that were
+// deliberately not installed. They are reported neither as installed nor as failed.
+var ErrInstallSkipped = errors.New("plugin install skipped")
+
+// PluginInstallFailure is a plugin that failed to install as part of a batch.
+type PluginInstallFailure struct {
+	// Info is the plugin that failed to install.
+	Info PluginInfo
+	// Err is the reason it failed.
+	Err error
+}
+
+// BatchInstallError is returned when some of a batch of plugins failed to install. The rest of the batch was still
+// installed.
+type BatchInstallError struct {
+	// Installed are the plugins in the batch that were installed.
+	Installed []PluginInfo
+	// Failed are the plugins in the batch that failed to install.
+	Failed []PluginInstallFailure
+}
+
+// NewBatchInstallError returns the result of installing a batch of plugins: nil if none of them failed, and a
+// *BatchInstallError describing the whole batch otherwise.
+func NewBatchInstallError(installed []PluginInfo, failed []PluginInstallFailure) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return &BatchInstallError{Installed: installed, Failed: failed}
+}
+
+func (err *BatchInstallError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "failed to install %d of %d plugins:", len(err.Failed), len(err.Failed)+len(err.Installed))
+	for _, failure := range err.Failed {
+		message := failure.Err.Error()
+		fmt.Fprintf(&b, "\n  - %s plugin %s: %s", failure.Info.Kind, failure.Info,
+			strings.ReplaceAll(message, "\n", "\n    "))
+		if command := failure.RetryCommand(); !strings.Contains(message, command) {
+			fmt.Fprintf(&b, "\n    retry: %s", command)
+		}
+	}
+	if len(err.Installed) > 0 {
+		installed := make([]string, len(err.Installed))
+		for i, info := range err.Installed {
+			installed[i] = fmt.Sprintf("%s plugin %s", info.Kind, info)
+		}
+		fmt.Fprintf(&b, "\ninstalled: %s", strings.Join(installed, ", "))
+	}
+	return b.String()
+}
+
+// WrappedErrors returns the errors of the failed plugins, like a go-multierror.Error.
+func (err *BatchInstallError) WrappedErrors() []error {
+	errs := make([]error, len(err.Failed))
+	for i, failure := range err.Failed {
+		errs[i] = failure.Err
+	}
+	return errs
+}
+
+// Is returns true if the error of any failed plugin matches target.
+func (err *BatchInstallError) Is(target error) bool {
+	for _, failure := range err.Failed {
+		if errors.Is(failure.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryCommand returns the command that retries installing the plugin.
+func (failure PluginInstallFailure) RetryCommand() string {
+	return pluginInstallCommand(failure.Info)
+}
+
+// BatchInstallOptions configures how a batch of plugins is installed.
+type BatchInstallOptions struct {
+	// Concurrency is the most plugins downloaded and installed at once. If it's zero,
+	// `PULUMI_PLUGIN_INSTALL_CONCURRENCY` is used if it's set, and DefaultPluginInstallConcurrency otherwise.
+	Concurrency int
+}
+
+// DefaultPluginInstallConcurrency returns how many plugins are downloaded and installed at once by default. Downloads
+// are mostly spent waiting on the network, so a couple run at once even with a single CPU, while extracting plugins
+// and installing their dependencies keeps a CPU busy. The default is capped so large batches don't saturate the
+// network.
+func DefaultPluginInstallConcurrency() int {
+	n := runtime.NumCPU()
+	if n < 2 {
+		return 2
+	} else if n > 8 {
+		return 8
+	}
+	return n
+}
+
+// GetConcurrency returns the most plugins to download and install at once.
+func (opts BatchInstallOptions) GetConcurrency() (int, error) {
+	if opts.Concurrency < 0 {
+		return 0, fmt.Errorf("plugin install concurrency must be positive; got %d", opts.Concurrency)
+	} else if opts.Concurrency > 0 {
+		return opts.Concurrency, nil
+	}
+	if v := os.Getenv(PluginInstallConcurrencyEnvVar); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("%s must be a positive integer; got %q", PluginInstallConcurrencyEnvVar, v)
+		}
+		return n, nil
+	}
+	return DefaultPluginInstallConcurrency(), nil
+}
+
+// InstallPluginBatch calls install for each of the plugins, running as many at once as opts allows. A failed install
+// doesn't stop the others; the result is nil if none of them failed, and a *BatchInstallError listing the installed
+// and failed plugins, in the order they were given, otherwise.
+func InstallPluginBatch(plugins []PluginInfo, opts BatchInstallOptions, install func(PluginInfo) error) error {
+	concurrency, err := opts.GetConcurrency()
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, len(plugins))
+	slots := make(chan struct{}, concurrency)
+	var tasks sync.WaitGroup
+	for i, info := range plugins {
+		i, info := i, info // don't close over the loop induction variables
+		slots <- struct{}{}
+		tasks.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				tasks.Done()
+			}()
+			errs[i] = install(info)
+		}()
+	}
+	tasks.Wait()
+
+	var installed []PluginInfo
+	var failed []PluginInstallFailure
+	for i, info := range plugins {
+		switch {
+		case errs[i] == nil:
+			installed = append(installed, info)
+		case !errors.Is(errs[i], ErrInstallSkipped):
+			failed = append(failed, PluginInstallFailure{Info: info, Err: errs[i]})
+		}
+	}
+	return NewBatchInstallError(installed, failed)
+}
@@ -0,0 +1,130 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchInstallError(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, NewBatchInstallError([]PluginInfo{{Name: "random", Kind: ResourcePlugin}}, nil))
+
+	v1, v2 := semver.MustParse("1.0.0"), semver.MustParse("2.0.0")
+	installed := []PluginInfo{{Name: "random", Kind: ResourcePlugin, Version: &v1}}
+	failed := []PluginInstallFailure{
+		{
+			Info: PluginInfo{Name: "acme", Kind: ResourcePlugin, Version: &v2},
+			Err:  &HTTPError{StatusCode: 404, message: "404 HTTP error fetching plugin from https://example.com/acme"},
+		},
+		{
+			Info: PluginInfo{Name: "private", Kind: ResourcePlugin, Version: &v1, PluginDownloadURL: "https://example.com"},
+			Err:  errors.New("disk full\nfree up some space"),
+		},
+	}
+	err := NewBatchInstallError(installed, failed)
+
+	assert.EqualError(t, err, "failed to install 2 of 3 plugins:\n"+
+		"  - resource plugin acme-2.0.0: 404 HTTP error fetching plugin from https://example.com/acme\n"+
+		"    retry: pulumi plugin install resource acme v2.0.0\n"+
+		"  - resource plugin private-1.0.0: disk full\n"+
+		"    free up some space\n"+
+		"    retry: pulumi plugin install resource private v1.0.0 --server https://example.com\n"+
+		"installed: resource plugin random-1.0.0")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.False(t, errors.Is(err, ErrOffline))
+
+	var batchErr *BatchInstallError
+	assert.True(t, errors.As(err, &batchErr))
+	assert.Equal(t, []error{failed[0].Err, failed[1].Err}, batchErr.WrappedErrors())
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestBatchInstallConcurrency(t *testing.T) {
+	t.Setenv(PluginInstallConcurrencyEnvVar, "")
+	n, err := BatchInstallOptions{}.GetConcurrency()
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPluginInstallConcurrency(), n)
+	assert.True(t, n >= 2 && n <= 8)
+
+	t.Setenv(PluginInstallConcurrencyEnvVar, "3")
+	n, err = BatchInstallOptions{}.GetConcurrency()
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = BatchInstallOptions{Concurrency: 5}.GetConcurrency()
+	require.NoError(t, err)
+	assert.Equal(t, 5, n, "options take precedence over the environment")
+
+	t.Setenv(PluginInstallConcurrencyEnvVar, "0")
+	_, err = BatchInstallOptions{}.GetConcurrency()
+	assert.EqualError(t, err, `PULUMI_PLUGIN_INSTALL_CONCURRENCY must be a positive integer; got "0"`)
+	_, err = BatchInstallOptions{Concurrency: -1}.GetConcurrency()
+	assert.Error(t, err)
+}
+
+func TestInstallPluginBatch(t *testing.T) {
+	t.Parallel()
+
+	var plugins []PluginInfo
+	for i := 0; i < 10; i++ {
+		plugins = append(plugins, PluginInfo{Name: "plugin" + strconv.Itoa(i), Kind: ResourcePlugin})
+	}
+
+	var lock sync.Mutex
+	var running, maxRunning int
+	err := InstallPluginBatch(plugins, BatchInstallOptions{Concurrency: 3}, func(info PluginInfo) error {
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		lock.Unlock()
+		defer func() {
+			lock.Lock()
+			running--
+			lock.Unlock()
+		}()
+
+		switch info.Name {
+		case "plugin3", "plugin7":
+			return errors.New("failed")
+		case "plugin5":
+			return ErrInstallSkipped
+		default:
+			return nil
+		}
+	})
+	assert.LessOrEqual(t, maxRunning, 3)
+
+	var batchErr *BatchInstallError
+	require.True(t, errors.As(err, &batchErr))
+	require.Len(t, batchErr.Failed, 2)
+	assert.Equal(t, "plugin3", batchErr.Failed[0].Info.Name)
+	assert.Equal(t, "plugin7", batchErr.Failed[1].Info.Name)
+	require.Len(t, batchErr.Installed, 7)
+	assert.Equal(t, "plugin0", batchErr.Installed[0].Name)
+
+	assert.NoError(t, InstallPluginBatch(plugins, BatchInstallOptions{Concurrency: 1}, func(PluginInfo) error {
+		return nil
+	}))
+}
@@ -0,0 +1,119 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrPluginBinaryNotDeclared is returned when looking up a binary the plugin doesn't declare in its PulumiPlugin.yaml.
+var ErrPluginBinaryNotDeclared = errors.New("binary not declared")
+
+// validatePluginBinaries checks that each binary has a name and a path inside the plugin's directory.
+func validatePluginBinaries(binaries map[string]string) error {
+	for name, path := range binaries {
+		if name == "" || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("binary name %q is invalid", name)
+		}
+		clean := filepath.Clean(filepath.FromSlash(path))
+		if path == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("binary %q has path %q, which is not inside the plugin's directory", name, path)
+		}
+	}
+	return nil
+}
+
+// resolvePluginBinary returns the path of the binary declared with the given path relative to dir. As with FilePath,
+// the first file that exists of the path as declared and the path with each of exts is returned, and otherwise the path
+// with suffix.
+func resolvePluginBinary(dir, path string, exts []string, suffix string) (string, bool) {
+	full := filepath.Join(dir, filepath.FromSlash(path))
+	if stat, err := os.Stat(full); err == nil && !stat.IsDir() {
+		return full, true
+	}
+	if found, ok := findPluginExecutable(filepath.Dir(full), filepath.Base(full), exts); ok {
+		return found, true
+	}
+	return full + suffix, false
+}
+
+// pluginBinaries returns the binaries declared by the PulumiPlugin.yaml in dir, if it has one.
+func pluginBinaries(dir string) (map[string]string, error) {
+	proj, err := LoadPluginProject(filepath.Join(dir, "PulumiPlugin.yaml"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("loading PulumiPlugin.yaml: %w", err)
+	}
+	return proj.Binaries, nil
+}
+
+// BinaryPath returns the full path of the named binary the plugin declares in the `binaries` section of its
+// PulumiPlugin.yaml. It's resolved like FilePath: if the plugin is installed, the first of the candidate files that
+// exists is returned; otherwise the declared path with the platform's executable suffix is returned. An error wrapping
+// ErrPluginBinaryNotDeclared is returned if the plugin doesn't declare the binary.
+func (info PluginInfo) BinaryPath(name string) (string, error) {
+	dir, err := info.DirPath()
+	if err != nil {
+		return "", err
+	}
+	binaries, err := pluginBinaries(dir)
+	if err != nil {
+		return "", err
+	}
+	path, ok := binaries[name]
+	if !ok {
+		return "", fmt.Errorf("plugin %s does not declare a binary named %q: %w", info, name, ErrPluginBinaryNotDeclared)
+	}
+	resolved, _ := resolvePluginBinary(dir, path, getCandidateExtensions(), info.FileSuffix())
+	return resolved, nil
+}
+
+// BinaryPaths returns the full paths of all of the binaries the installed plugin declares, by name, resolved as
+// BinaryPath does.
+func (info PluginInfo) BinaryPaths() (map[string]string, error) {
+	dir, err := info.DirPath()
+	if err != nil {
+		return nil, err
+	}
+	binaries, err := pluginBinaries(dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]string, len(binaries))
+	for name, path := range binaries {
+		paths[name], _ = resolvePluginBinary(dir, path, getCandidateExtensions(), info.FileSuffix())
+	}
+	return paths, nil
+}
+
+// missingPluginBinaries returns the names of the binaries the project declares that aren't in dir for a platform with
+// the given executable extensions, sorted.
+func missingPluginBinaries(proj *PluginProject, dir string, exts []string) []string {
+	var missing []string
+	for name, path := range proj.Binaries {
+		if _, ok := resolvePluginBinary(dir, path, exts, ""); !ok {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
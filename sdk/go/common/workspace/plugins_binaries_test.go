@@ -0,0 +1,117 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePluginBinaries(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		binaries map[string]string
+		err      string
+	}{
+		{name: "none"},
+		{name: "relative paths", binaries: map[string]string{"tool": "bin/pulumi-tool", "helper": "./helper"}},
+		{
+			name:     "empty name",
+			binaries: map[string]string{"": "tool"},
+			err:      `binary name "" is invalid`,
+		},
+		{
+			name:     "name with a separator",
+			binaries: map[string]string{"bin/tool": "tool"},
+			err:      `binary name "bin/tool" is invalid`,
+		},
+		{
+			name:     "empty path",
+			binaries: map[string]string{"tool": ""},
+			err:      `binary "tool" has path "", which is not inside the plugin's directory`,
+		},
+		{
+			name:     "absolute path",
+			binaries: map[string]string{"tool": "/usr/bin/tool"},
+			err:      `binary "tool" has path "/usr/bin/tool", which is not inside the plugin's directory`,
+		},
+		{
+			name:     "path outside the plugin",
+			binaries: map[string]string{"tool": "bin/../../tool"},
+			err:      `binary "tool" has path "bin/../../tool", which is not inside the plugin's directory`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validatePluginBinaries(tt.binaries)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestPluginBinaryPath(t *testing.T) {
+	t.Parallel()
+
+	tgz, err := createTGZWithMode(map[string][]byte{
+		"PulumiPlugin.yaml":        []byte("binaries:\n  tool: bin/pulumi-mock-tool\n  missing: bin/missing\n"),
+		"pulumi-resource-mock":     nil,
+		"pulumi-resource-mock.exe": nil,
+		"bin/pulumi-mock-tool":     nil,
+		"bin/pulumi-mock-tool.exe": nil,
+	}, 0700)
+	require.NoError(t, err)
+
+	logger := &recordingLogger{}
+	dir, plugin := newMockPlugin(t)
+	plugin, err = (&Context{Logger: logger}).Plugin(plugin)
+	require.NoError(t, err)
+	require.NoError(t, plugin.Install(ioutil.NopCloser(bytes.NewReader(tgz)), false))
+
+	// Missing binaries are reported when the plugin is installed.
+	entry, ok := logger.find("is missing the binaries missing declared by its PulumiPlugin.yaml")
+	assert.True(t, ok)
+	assert.Equal(t, -1, entry.level)
+
+	pluginDir := filepath.Join(dir, plugin.Dir())
+	tool, err := plugin.BinaryPath("tool")
+	require.NoError(t, err)
+	assert.FileExists(t, tool)
+	assert.Equal(t, filepath.Join(pluginDir, "bin"), filepath.Dir(tool))
+
+	_, err = plugin.BinaryPath("other")
+	assert.True(t, errors.Is(err, ErrPluginBinaryNotDeclared), "unexpected error %v", err)
+
+	paths, err := plugin.BinaryPaths()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"tool":    tool,
+		"missing": filepath.Join(pluginDir, "bin", "missing") + plugin.FileSuffix(),
+	}, paths)
+}
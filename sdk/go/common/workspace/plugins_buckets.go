@@ -0,0 +1,177 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// credentialsTimeout is how long each service cloud credentials come from, such as an instance metadata service, has
+// to respond.
+const credentialsTimeout = 2 * time.Second
+
+// pluginTarballName returns the name of the tarball of a plugin for the given platform, as it's found under a
+// PluginDownloadURL.
+func pluginTarballName(kind PluginKind, name string, version semver.Version, opSy, arch string) string {
+	return fmt.Sprintf("pulumi-%s-%s-v%s-%s-%s.tar.gz", kind, name, version.String(), opSy, arch)
+}
+
+// bucketObjectKey returns the key of the object with the given name under prefix in a bucket.
+func bucketObjectKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+// bucketPluginVersions returns the versions of the plugin with a tarball for the current platform among the keys of
+// the objects under prefix in a bucket.
+func bucketPluginVersions(keys []string, prefix string, kind PluginKind, name string) []semver.Version {
+	start := bucketObjectKey(prefix, fmt.Sprintf("pulumi-%s-%s-v", kind, name))
+	end := fmt.Sprintf("-%s-%s.tar.gz", runtime.GOOS, runtime.GOARCH)
+	var versions []semver.Version
+	for _, key := range keys {
+		if !strings.HasPrefix(key, start) || !strings.HasSuffix(key, end) {
+			continue
+		}
+		version, err := semver.ParseTolerant(strings.TrimSuffix(strings.TrimPrefix(key, start), end))
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+// latestBucketVersion returns the latest of the versions of the plugin found by listing listingURL that isn't a
+// prerelease.
+func latestBucketVersion(versions []semver.Version, listingURL string, kind PluginKind,
+	name string) (*semver.Version, error) {
+	latest := latestReleasedVersion(versions)
+	if latest == nil {
+		return nil, fmt.Errorf("found no %s-%s tarballs of %s plugin %s in %s", runtime.GOOS, runtime.GOARCH,
+			kind, name, listingURL)
+	}
+	return latest, nil
+}
+
+// getBucketListing sends a request for a page of a bucket listing, and parses the response with parse.
+func getBucketListing(req *http.Request, getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error),
+	parse func([]byte) error) error {
+	resp, _, err := getHTTPResponse(req)
+	if err != nil {
+		return err
+	}
+	defer contract.IgnoreClose(resp)
+	b, err := ioutil.ReadAll(resp)
+	if err != nil {
+		return err
+	}
+	return parse(b)
+}
+
+// getCredentialsResponse sends a request to a service cloud credentials come from, with the context's HTTP client, and
+// returns the body of a successful response. The form, if any, is sent as the request's body.
+func (ctx *Context) getCredentialsResponse(method, endpoint string, header http.Header, form url.Values) (
+	[]byte, error) {
+	reqCtx, cancel := context.WithTimeout(context.Background(), credentialsTimeout)
+	defer cancel()
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(reqCtx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	client := ctx.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer contract.IgnoreClose(resp.Body)
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s", method, req.URL.Redacted(), resp.Status)
+	}
+	return b, nil
+}
+
+// getOAuthToken sends a request for an OAuth access token to a service cloud credentials come from, and returns the
+// token it responds with.
+func (ctx *Context) getOAuthToken(method, endpoint string, header http.Header, form url.Values) (string, error) {
+	b, err := ctx.getCredentialsResponse(method, endpoint, header, form)
+	if err != nil {
+		return "", err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(b, &token); err != nil {
+		return "", fmt.Errorf("parsing access token: %w", err)
+	} else if token.AccessToken == "" {
+		return "", fmt.Errorf("%s %s returned no access token", method, endpoint)
+	}
+	return token.AccessToken, nil
+}
+
+// bucketListingURL returns the PluginDownloadURL of a plugin in a bucket with its environment variables expanded and
+// the plugin and current platform interpolated, so the objects under it can be listed to find the plugin's latest
+// version. URLs that name the plugin's tarball are listed from the directory it's in, as long as it's named by
+// `${FILENAME}`.
+func bucketListingURL(downloadURL string, kind PluginKind, name string) (string, error) {
+	expanded, err := expandURLEnv(downloadURL)
+	if err != nil {
+		return "", err
+	}
+	if namesPluginFile(expanded) {
+		dir, file, query := cutURLPath(expanded)
+		if file != "${FILENAME}" {
+			return "", fmt.Errorf("the latest version of plugins can't be found in %s, since it names their tarballs "+
+				"its own way", downloadURL)
+		}
+		expanded = dir + query
+	}
+	if strings.Contains(expanded, "${VERSION}") {
+		return "", fmt.Errorf("the latest version of plugins can't be found in %s, since it depends on the version",
+			downloadURL)
+	}
+	return interpolateURL(expanded, kind, name, semver.Version{}, runtime.GOOS, runtime.GOARCH), nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/blang/semver"
+	"gopkg.in/yaml.v2"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// PluginBundle is a versioned set of plugins that are installed together, such as the providers a platform team has
+// approved. Its manifest is a YAML or JSON document, e.g.:
+//
+//	name: acme-approved-providers
+//	version: 2022.6.0
+//	plugins:
+//	  - name: aws
+//	    version: 5.1.0
+//	  - name: acme
+//	    version: 1.2.3
+//	    server: https://plugins.acme.com
+//
+// Every member's version must be set, and a member's kind defaults to a resource plugin.
+type PluginBundle struct {
+	// Name is the name of the bundle.
+	Name string
+	// Version is the version of the bundle.
+	Version semver.Version
+	// Plugins are the plugins in the bundle.
+	Plugins []PluginInfo
+}
+
+// pluginBundleFile is the layout of a bundle's manifest.
+type pluginBundleFile struct {
+	Name    string                  `yaml:"name"`
+	Version string                  `yaml:"version"`
+	Plugins []ProjectTemplatePlugin `yaml:"plugins"`
+}
+
+// ParsePluginBundle parses and validates a bundle's manifest.
+func ParsePluginBundle(b []byte) (*PluginBundle, error) {
+	var file pluginBundleFile
+	if err := yaml.UnmarshalStrict(b, &file); err != nil {
+		return nil, err
+	}
+	if file.Name == "" {
+		return nil, errors.New("name: must be set")
+	}
+	version, err := semver.ParseTolerant(file.Version)
+	if err != nil {
+		return nil, fmt.Errorf("version: invalid version %q: %w", file.Version, err)
+	}
+
+	bundle := &PluginBundle{Name: file.Name, Version: version}
+	members := map[string]bool{}
+	for i, plugin := range file.Plugins {
+		info, err := plugin.pluginInfo()
+		if err != nil {
+			return nil, fmt.Errorf("plugins[%d]: %w", i, err)
+		}
+		if info.Version == nil {
+			return nil, fmt.Errorf("plugins[%d]: version must be set", i)
+		}
+		key := string(info.Kind) + "/" + info.Name
+		if members[key] {
+			return nil, fmt.Errorf("plugins[%d]: %s plugin %s is already in the bundle", i, info.Kind, info.Name)
+		}
+		members[key] = true
+		bundle.Plugins = append(bundle.Plugins, info)
+	}
+	return bundle, nil
+}
+
+// LoadPluginBundle reads and validates the bundle manifest at source, which is either a path or an HTTP(S) URL.
+func LoadPluginBundle(source string) (*PluginBundle, error) {
+	return (&Context{}).LoadPluginBundle(source)
+}
+
+// LoadPluginBundle reads the bundle manifest like the package-level LoadPluginBundle, downloading it with the
+// context's HTTP client.
+func (ctx *Context) LoadPluginBundle(source string) (*PluginBundle, error) {
+	var b []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		b, err = ctx.downloadPluginBundle(source)
+	} else {
+		b, err = ioutil.ReadFile(source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading plugin bundle %s: %w", source, err)
+	}
+	bundle, err := ParsePluginBundle(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugin bundle %s: %w", source, err)
+	}
+	return bundle, nil
+}
+
+// downloadPluginBundle downloads the bundle manifest at url, sending the credentials configured for its host.
+func (ctx *Context) downloadPluginBundle(url string) ([]byte, error) {
+	req, err := buildHTTPRequest(url, "")
+	if err != nil {
+		return nil, err
+	}
+	resp, _, err := ctx.getHTTPResponse(req)
+	if err != nil {
+		return nil, err
+	}
+	defer contract.IgnoreClose(resp)
+	return ioutil.ReadAll(resp)
+}
+
+// Install downloads and installs the bundle's plugins with the batch installer, skipping those that are already
+// installed. It returns nil if every plugin was installed, and a *BatchInstallError otherwise.
+func (bundle *PluginBundle) Install(opts BatchInstallOptions) error {
+	return InstallPluginBatch(bundle.Plugins, opts, installMissingPlugin)
+}
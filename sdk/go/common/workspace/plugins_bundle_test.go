@@ -0,0 +1,122 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePluginBundle(t *testing.T) {
+	t.Parallel()
+
+	bundle, err := ParsePluginBundle([]byte(`name: acme-approved-providers
+version: 2022.6.0
+plugins:
+  - name: aws
+    version: v5.1.0
+  - name: acme
+    version: 1.2.3
+    server: https://plugins.acme.com
+  - name: policy
+    kind: analyzer
+    version: 1.0.0
+`))
+	require.NoError(t, err)
+	v510, v123, v100 := semver.MustParse("5.1.0"), semver.MustParse("1.2.3"), semver.MustParse("1.0.0")
+	assert.Equal(t, &PluginBundle{
+		Name:    "acme-approved-providers",
+		Version: semver.MustParse("2022.6.0"),
+		Plugins: []PluginInfo{
+			{Name: "aws", Kind: ResourcePlugin, Version: &v510},
+			{Name: "acme", Kind: ResourcePlugin, Version: &v123, PluginDownloadURL: "https://plugins.acme.com"},
+			{Name: "policy", Kind: AnalyzerPlugin, Version: &v100},
+		},
+	}, bundle)
+}
+
+func TestParsePluginBundleErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		manifest string
+		expected string
+	}{
+		{"version: 1.0.0", "name: must be set"},
+		{"name: b\nversion: latest", `version: invalid version "latest"`},
+		{"name: b\nversion: 1.0.0\nmembers: []", "field members not found"},
+		{"name: b\nversion: 1.0.0\nplugins: [{version: 1.0.0}]", "plugins[0]: name must be set"},
+		{"name: b\nversion: 1.0.0\nplugins: [{name: aws}]", "plugins[0]: version must be set"},
+		{"name: b\nversion: 1.0.0\nplugins: [{name: aws, kind: provider, version: 1.0.0}]",
+			`plugins[0]: unrecognized plugin kind "provider"`},
+		{"name: b\nversion: 1.0.0\nplugins: [{name: aws, version: 1.0.0}, {name: aws, version: 2.0.0}]",
+			"plugins[1]: resource plugin aws is already in the bundle"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.expected, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParsePluginBundle([]byte(tt.manifest))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestInstallPluginBundle(t *testing.T) {
+	t.Setenv(PulumiHomeEnvVar, t.TempDir())
+
+	tgz := mockPluginTGZ(t)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/bundle.yaml":
+			_, err := w.Write([]byte("name: b\nversion: 1.0.0\nplugins:\n" +
+				"  - {name: mock, version: 1.0.0, server: " + server.URL + "}\n"))
+			assert.NoError(t, err)
+		case strings.HasPrefix(r.URL.Path, "/pulumi-resource-mock-v1.0.0-"):
+			_, err := w.Write(tgz)
+			assert.NoError(t, err)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	bundle, err := LoadPluginBundle(server.URL + "/bundle.yaml")
+	require.NoError(t, err)
+	require.NoError(t, bundle.Install(BatchInstallOptions{}))
+	v := semver.MustParse("1.0.0")
+	assert.True(t, HasPlugin(PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &v}))
+
+	_, err = LoadPluginBundle(server.URL + "/missing.yaml")
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "bundle.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("name: b\nversion: 1.0.0\nplugins: [{name: aws}]"), 0600))
+	_, err = LoadPluginBundle(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid plugin bundle "+path)
+}
@@ -0,0 +1,311 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/fsutil"
+)
+
+// isBundledPlugin returns true for the plugins that ship next to the pulumi binary.
+func isBundledPlugin(kind PluginKind, name string) bool {
+	if opts, ok := getPluginKindOptions(kind); ok && opts.Bundled {
+		return true
+	}
+	return kind == ResourcePlugin && (name == "pulumi-nodejs" || name == "pulumi-python")
+}
+
+// bundledPlugin is a plugin executable found next to the pulumi binary.
+type bundledPlugin struct {
+	kind PluginKind
+	name string
+	path string
+}
+
+// executableDir returns the directory of the running executable, with symlinks resolved.
+func executableDir() (string, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	fullPath, err := filepath.EvalSymlinks(exePath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(fullPath), nil
+}
+
+// isPluginExecutable returns true if the file can be launched as a plugin. On Windows, os.Stat() returns a mode of
+// "-rw-rw-rw" so on windows we just trust the fact that the .exe can actually be launched.
+func isPluginExecutable(stat os.FileInfo) bool {
+	return !stat.IsDir() && (stat.Mode()&0100 != 0 || runtime.GOOS == windowsGOOS)
+}
+
+// findBundledPlugins returns the bundled plugins in dir. The `-exec` helpers that some language plugins ship with
+// aren't plugins of their own, and are left where they are.
+func findBundledPlugins(dir string) ([]bundledPlugin, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var plugins []bundledPlugin
+	seen := map[string]bool{}
+	// Extensions are probed in order of preference, so that an `.exe` wins over a `.cmd` of the same name.
+	for _, ext := range getCandidateExtensions() {
+		for _, file := range files {
+			kind, name, ok := parsePluginFileName(file.Name(), ext)
+			base := strings.TrimSuffix(file.Name(), ext)
+			if !ok || !isBundledPlugin(kind, name) || !isPluginExecutable(file) || seen[base] {
+				continue
+			}
+			seen[base] = true
+			plugins = append(plugins, bundledPlugin{kind: kind, name: name, path: filepath.Join(dir, file.Name())})
+		}
+	}
+	return plugins, nil
+}
+
+// MigrateBundledPlugins moves the plugins bundled next to the running pulumi binary into the plugin cache, as the
+// given version of the CLI that bundles them. It only does so once for each version, as recorded in the maintenance
+// state of the plugin cache, so that running the CLI doesn't touch the cache every time. See
+// Context.MigrateBundledPlugins.
+func MigrateBundledPlugins(version semver.Version) ([]PluginInfo, error) {
+	dir, err := executableDir()
+	if err != nil {
+		return nil, err
+	}
+	return (&Context{}).migrateBundledPluginsOnce(dir, version)
+}
+
+// migrateBundledPluginsOnce migrates the plugins bundled in dir, unless the plugins bundled with the given version
+// were the last to be migrated into the context's plugin directory.
+func (ctx *Context) migrateBundledPluginsOnce(dir string, version semver.Version) ([]PluginInfo, error) {
+	root, err := ctx.GetPluginDir()
+	if err != nil {
+		return nil, err
+	}
+	state, err := readPluginMaintenanceState(root)
+	if err != nil {
+		ctx.logf(5, "ignoring %v", err)
+	} else if state.BundledPluginsVersion == version.String() {
+		return nil, nil
+	}
+
+	migrated, err := ctx.MigrateBundledPlugins(dir, version)
+	if err != nil {
+		return migrated, err
+	}
+
+	// Keep this from racing the maintenance passes of other processes, which write the same state.
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return migrated, notWritableError(root, err)
+	}
+	mutex := fsutil.NewFileMutex(filepath.Join(root, ".maintenance.lock"))
+	if err := mutex.Lock(); err != nil {
+		return migrated, notWritableError(root, err)
+	}
+	defer func() { contract.IgnoreError(mutex.Unlock()) }()
+	return migrated, ctx.updatePluginMaintenanceState(root, func(state *pluginMaintenanceState) {
+		state.BundledPluginsVersion = version.String()
+	})
+}
+
+// MigrateBundledPlugins moves the plugins bundled in dir into the plugin cache as the given version, returning the
+// plugins it added. The plugins themselves stay where they are: each gets a cache entry holding a shim that forwards
+// to it, so bundled plugins are versioned, listed and garbage collected like any other plugin. Shims left behind by
+// other versions of the CLI are removed once the plugins they forward to are gone or have been replaced.
+func (ctx *Context) MigrateBundledPlugins(dir string, version semver.Version) ([]PluginInfo, error) {
+	bundled, err := findBundledPlugins(dir)
+	if err != nil {
+		return nil, fmt.Errorf("finding bundled plugins: %w", err)
+	}
+
+	var migrated []PluginInfo
+	for _, plugin := range bundled {
+		v := version
+		info, err := ctx.Plugin(PluginInfo{Kind: plugin.kind, Name: plugin.name, Version: &v})
+		if err != nil {
+			return migrated, err
+		}
+		if info, err = info.withWritablePluginDir(); err != nil {
+			return migrated, err
+		}
+		installed, err := info.installShim(plugin.path)
+		if err != nil {
+			return migrated, fmt.Errorf("migrating bundled plugin %s: %w", info, err)
+		}
+		if installed {
+			ctx.logf(5, "migrated bundled %s plugin %s from %s", info.Kind, info, plugin.path)
+			migrated = append(migrated, info)
+		}
+	}
+	if err := ctx.removeStaleShims(dir, version); err != nil {
+		ctx.logf(5, "could not remove stale shims of bundled plugins: %v", err)
+	}
+	return migrated, nil
+}
+
+// installShim installs the plugin as a shim that forwards to target, returning false if it's already installed. A
+// plugin of the same version installed from a tarball is left alone, as is a shim that forwards to another plugin that
+// still exists.
+func (info PluginInfo) installShim(target string) (bool, error) {
+	finalDir, err := info.DirPath()
+	if err != nil {
+		return false, err
+	}
+	unlock, err := info.installLock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	partialFilePath, err := info.PartialFilePath()
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(finalDir); err == nil {
+		if _, err := os.Stat(partialFilePath); errors.Is(err, os.ErrNotExist) {
+			receipt, err := info.GetInstallReceipt()
+			if err != nil {
+				return false, err
+			}
+			if receipt == nil || receipt.Shim == "" || receipt.Shim == target {
+				return false, nil
+			}
+			if _, err := os.Stat(receipt.Shim); err == nil {
+				return false, nil
+			}
+		}
+		if err := os.RemoveAll(finalDir); err != nil {
+			return false, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	defer forgetPluginPaths(info.Kind, info.Name)
+	if err := ioutil.WriteFile(partialFilePath, nil, 0600); err != nil {
+		return false, notWritableError(filepath.Dir(partialFilePath), err)
+	}
+	if err := os.MkdirAll(finalDir, 0700); err != nil {
+		return false, notWritableError(filepath.Dir(finalDir), err)
+	}
+	if err := writePluginShim(filepath.Join(finalDir, info.FilePrefix()), target); err != nil {
+		return false, err
+	}
+	now := info.now()
+	if err := writeInstallReceipt(finalDir, PluginInstallReceipt{InstalledAt: now, Shim: target}); err != nil {
+		return false, err
+	}
+	if err := os.Remove(partialFilePath); err != nil {
+		return false, err
+	}
+	if err := os.Chtimes(finalDir, now, now); err != nil {
+		info.logf(5, "could not record install time of plugin %s: %v", info, err)
+	}
+	return true, nil
+}
+
+// writePluginShim creates an executable at path that runs target with the same arguments. It's a symlink everywhere
+// but Windows, where creating symlinks needs elevated privileges, and a `.cmd` script is used instead.
+func writePluginShim(path, target string) error {
+	if runtime.GOOS == windowsGOOS {
+		script := fmt.Sprintf("@\"%s\" %%*\r\n", target)
+		return ioutil.WriteFile(path+".cmd", []byte(script), 0600)
+	}
+	return os.Symlink(target, path)
+}
+
+// removeStaleShims deletes the shims of other versions of the CLI that forward to plugins in dir, which were
+// replaced by this version, and the shims that forward to plugins that no longer exist.
+func (ctx *Context) removeStaleShims(dir string, version semver.Version) error {
+	plugins, err := ctx.getPlugins(true /* skipMetadata */)
+	if err != nil {
+		return err
+	}
+	for _, plugin := range plugins {
+		if !isBundledPlugin(plugin.Kind, plugin.Name) || plugin.Version == nil {
+			continue
+		}
+		receipt, err := plugin.GetInstallReceipt()
+		if err != nil || receipt == nil || receipt.Shim == "" {
+			continue
+		}
+		replaced := filepath.Dir(receipt.Shim) == dir && !plugin.Version.EQ(version)
+		if _, err := os.Stat(receipt.Shim); err == nil && !replaced {
+			continue
+		}
+		ctx.logf(5, "removing stale shim of bundled plugin %s forwarding to %s", plugin, receipt.Shim)
+		if err := plugin.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findBundledPluginShim looks for a shim in the plugin cache that forwards to the bundled plugin in exeDir, preferring
+// the newest if there are several.
+func (ctx *Context) findBundledPluginShim(kind PluginKind, name, exeDir string) (pluginPathEntry, bool) {
+	key, err := ctx.pluginPathCacheKey(kind, name, nil)
+	if err != nil {
+		return pluginPathEntry{}, false
+	}
+	key.version = "bundled:" + exeDir
+	if entry, ok := cachedPluginPath(key); ok {
+		return entry, true
+	}
+
+	plugins, err := ctx.getPlugins(true /* skipMetadata */)
+	if err != nil {
+		return pluginPathEntry{}, false
+	}
+	var shims []PluginInfo
+	for _, plugin := range plugins {
+		if plugin.Kind == kind && plugin.Name == name && plugin.Version != nil {
+			shims = append(shims, plugin)
+		}
+	}
+	sort.Slice(shims, func(i, j int) bool { return shims[i].Version.GT(*shims[j].Version) })
+	for _, plugin := range shims {
+		receipt, err := plugin.GetInstallReceipt()
+		if err != nil || receipt == nil || receipt.Shim == "" || filepath.Dir(receipt.Shim) != exeDir {
+			continue
+		}
+		dir, err := plugin.DirPath()
+		if err != nil {
+			continue
+		}
+		// Probing for the executable follows the shim, so this also checks the bundled plugin is still there.
+		path, ok := findPluginExecutable(dir, plugin.FilePrefix(), getCandidateExtensions())
+		if !ok {
+			continue
+		}
+		entry := pluginPathEntry{info: plugin, dir: dir, path: path}
+		cachePluginPath(key, entry)
+		return entry, true
+	}
+	return pluginPathEntry{}, false
+}
@@ -0,0 +1,152 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindBundledPlugins(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == windowsGOOS {
+		t.Skip("bundled plugins have .exe extensions on Windows")
+	}
+
+	dir := t.TempDir()
+	for name, mode := range map[string]os.FileMode{
+		"pulumi":                        0700,
+		"pulumi-language-nodejs":        0700,
+		"pulumi-language-python":        0700,
+		"pulumi-language-python-exec":   0700,
+		"pulumi-language-go":            0600,
+		"pulumi-resource-pulumi-nodejs": 0700,
+		"pulumi-resource-aws":           0700,
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), nil, mode))
+	}
+
+	plugins, err := findBundledPlugins(dir)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []bundledPlugin{
+		{LanguagePlugin, "nodejs", filepath.Join(dir, "pulumi-language-nodejs")},
+		{LanguagePlugin, "python", filepath.Join(dir, "pulumi-language-python")},
+		{ResourcePlugin, "pulumi-nodejs", filepath.Join(dir, "pulumi-resource-pulumi-nodejs")},
+	}, plugins)
+}
+
+func TestMigrateBundledPlugins(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == windowsGOOS {
+		t.Skip("bundled plugins have .exe extensions on Windows")
+	}
+
+	exeDir, pluginDir := t.TempDir(), t.TempDir()
+	target := filepath.Join(exeDir, "pulumi-language-nodejs")
+	require.NoError(t, ioutil.WriteFile(target, []byte("#!/bin/sh\n"), 0700))
+	ctx := &Context{Home: t.TempDir(), PluginDir: pluginDir}
+	migrate := func(version string) []string {
+		migrated, err := ctx.MigrateBundledPlugins(exeDir, semver.MustParse(version))
+		require.NoError(t, err)
+		var names []string
+		for _, plugin := range migrated {
+			names = append(names, plugin.Dir())
+		}
+		return names
+	}
+	installed := func() []string {
+		plugins, err := ctx.getPlugins(true /* skipMetadata */)
+		require.NoError(t, err)
+		var names []string
+		for _, plugin := range plugins {
+			names = append(names, plugin.Dir())
+		}
+		return names
+	}
+
+	// Bundled plugins get a shim in the plugin cache, as the version of the CLI.
+	assert.Equal(t, []string{"language-nodejs-v3.40.0"}, migrate("3.40.0"))
+	assert.Empty(t, migrate("3.40.0"))
+	entry, ok := ctx.findBundledPluginShim(LanguagePlugin, "nodejs", exeDir)
+	require.True(t, ok)
+	assert.Equal(t, filepath.Join(pluginDir, "language-nodejs-v3.40.0"), entry.dir)
+	resolved, err := filepath.EvalSymlinks(entry.path)
+	require.NoError(t, err)
+	assert.Equal(t, target, resolved)
+
+	v := semver.MustParse("3.40.0")
+	receipt, err := PluginInfo{Kind: LanguagePlugin, Name: "nodejs", Version: &v, PluginDir: pluginDir}.GetInstallReceipt()
+	require.NoError(t, err)
+	require.NotNil(t, receipt)
+	assert.Equal(t, target, receipt.Shim)
+
+	// Shims of plugins bundled with other installs aren't used.
+	_, ok = ctx.findBundledPluginShim(LanguagePlugin, "nodejs", t.TempDir())
+	assert.False(t, ok)
+
+	// Upgrading the CLI in place replaces the shim of the old version.
+	assert.Equal(t, []string{"language-nodejs-v3.41.0"}, migrate("3.41.0"))
+	assert.Equal(t, []string{"language-nodejs-v3.41.0"}, installed())
+
+	// Shims are no longer found once their plugin is gone, and are removed by the next migration.
+	require.NoError(t, os.Remove(target))
+	_, ok = ctx.findBundledPluginShim(LanguagePlugin, "nodejs", exeDir)
+	assert.False(t, ok)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(exeDir, "pulumi-language-python"), nil, 0700))
+	assert.Equal(t, []string{"language-python-v3.42.0"}, migrate("3.42.0"))
+	assert.Equal(t, []string{"language-python-v3.42.0"}, installed())
+}
+
+func TestMigrateBundledPluginsOnce(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == windowsGOOS {
+		t.Skip("bundled plugins have .exe extensions on Windows")
+	}
+
+	exeDir, pluginDir := t.TempDir(), t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(exeDir, "pulumi-language-nodejs"), []byte("#!/bin/sh\n"), 0700))
+	ctx := &Context{Home: t.TempDir(), PluginDir: pluginDir}
+	migrated, err := ctx.migrateBundledPluginsOnce(exeDir, semver.MustParse("3.40.0"))
+	require.NoError(t, err)
+	assert.Len(t, migrated, 1)
+
+	// Once a version's plugins are migrated, the cache isn't looked at again until another version runs.
+	shim := filepath.Join(pluginDir, "language-nodejs-v3.40.0")
+	assert.DirExists(t, shim)
+	require.NoError(t, os.RemoveAll(shim))
+	migrated, err = ctx.migrateBundledPluginsOnce(exeDir, semver.MustParse("3.40.0"))
+	require.NoError(t, err)
+	assert.Empty(t, migrated)
+	assert.NoDirExists(t, shim)
+
+	migrated, err = ctx.migrateBundledPluginsOnce(exeDir, semver.MustParse("3.41.0"))
+	require.NoError(t, err)
+	assert.Len(t, migrated, 1)
+
+	// Maintenance passes keep the migrated version.
+	_, err = ctx.MaintainPlugins()
+	require.NoError(t, err)
+	state, err := readPluginMaintenanceState(pluginDir)
+	require.NoError(t, err)
+	assert.Equal(t, "3.41.0", state.BundledPluginsVersion)
+	assert.False(t, state.LastRun.IsZero())
+}
@@ -0,0 +1,200 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/fsutil"
+)
+
+// pluginChecksumsFile is the file in the plugin cache that records the SHA-256 digest of each plugin tarball the first
+// time it's installed. Later downloads of the same tarball must match it, which catches a compromised mirror or a
+// corrupted CDN even for plugins whose publishers don't publish checksums.
+const pluginChecksumsFile = ".checksums.json"
+
+// pluginChecksums is the contents of pluginChecksumsFile.
+type pluginChecksums struct {
+	// Checksums maps the asset name of each tarball, as returned by PluginAssetName, to its hex-encoded SHA-256 digest.
+	Checksums map[string]string `json:"checksums"`
+}
+
+// pendingPluginChecksums holds the digests of downloaded tarballs that have no recorded digest yet, by plugin and then
+// asset name. They're only recorded once the plugin is installed, so a download that turns out to be corrupt is never
+// trusted.
+var pendingPluginChecksums = struct {
+	sync.Mutex
+	digests map[string]map[string]string
+}{digests: map[string]map[string]string{}}
+
+// pendingPluginKey returns the key of the plugin's version in pendingPluginChecksums.
+func pendingPluginKey(kind PluginKind, name string, version semver.Version) string {
+	return fmt.Sprintf("%s/%s/v%s", kind, name, version)
+}
+
+// pluginChecksumsPath returns the path of the plugin cache's pluginChecksumsFile.
+func pluginChecksumsPath(info PluginInfo) (string, error) {
+	dir, err := info.pluginCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, pluginChecksumsFile), nil
+}
+
+// loadPluginChecksums returns the digests recorded in the checksums file at path, which is empty if it doesn't exist.
+func loadPluginChecksums(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+	var checksums pluginChecksums
+	if err := json.Unmarshal(b, &checksums); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if checksums.Checksums == nil {
+		checksums.Checksums = map[string]string{}
+	}
+	return checksums.Checksums, nil
+}
+
+// checkPluginChecksum checks the digest of a downloaded tarball against the one recorded when it was first installed,
+// returning an ErrChecksumMismatch error if they differ. If no digest is recorded yet, the digest is held until the
+// plugin is installed.
+func checkPluginChecksum(info PluginInfo, version semver.Version, platform Platform, actual string) error {
+	path, err := pluginChecksumsPath(info)
+	if err != nil {
+		return err
+	}
+	recorded, err := loadPluginChecksums(path)
+	if err != nil {
+		return err
+	}
+
+	asset := PluginAssetName(info.Kind, info.Name, version, platform)
+	if expected, ok := recorded[asset]; ok {
+		if expected != actual {
+			return fmt.Errorf("%w; the digest was recorded when the plugin was first installed, so the download may "+
+				"have been tampered with or corrupted. If the publisher deliberately replaced the tarball, remove its "+
+				"entry from %s", checksumMismatchError(asset, expected, actual), path)
+		}
+		return nil
+	}
+
+	pendingPluginChecksums.Lock()
+	defer pendingPluginChecksums.Unlock()
+	key := pendingPluginKey(info.Kind, info.Name, version)
+	if pendingPluginChecksums.digests[key] == nil {
+		pendingPluginChecksums.digests[key] = map[string]string{}
+	}
+	pendingPluginChecksums.digests[key][asset] = actual
+	return nil
+}
+
+// recordPluginChecksums records the digests of the plugin's downloaded tarballs, now that it's been installed. Digests
+// that are already recorded aren't replaced: the first one recorded is trusted.
+func recordPluginChecksums(info PluginInfo) error {
+	if info.Version == nil {
+		return nil
+	}
+	pendingPluginChecksums.Lock()
+	key := pendingPluginKey(info.Kind, info.Name, *info.Version)
+	pending := pendingPluginChecksums.digests[key]
+	delete(pendingPluginChecksums.digests, key)
+	pendingPluginChecksums.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	path, err := pluginChecksumsPath(info)
+	if err != nil {
+		return err
+	}
+	mutex := fsutil.NewFileMutex(path + ".lock")
+	if err := mutex.Lock(); err != nil {
+		return err
+	}
+	defer func() { contract.IgnoreError(mutex.Unlock()) }()
+
+	recorded, err := loadPluginChecksums(path)
+	if err != nil {
+		return err
+	}
+	for asset, digest := range pending {
+		if _, ok := recorded[asset]; !ok {
+			recorded[asset] = digest
+		}
+	}
+	b, err := json.MarshalIndent(pluginChecksums{Checksums: recorded}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write the new file alongside the old one and move it into place, so readers never see half of it.
+	tmp, err := ioutil.TempFile(filepath.Dir(path), pluginChecksumsFile+"-")
+	if err != nil {
+		return err
+	}
+	defer func() { contract.IgnoreError(os.Remove(tmp.Name())) }()
+	if _, err := tmp.Write(b); err != nil {
+		contract.IgnoreClose(tmp)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// checksumRecordingReader wraps a download, checking its digest against the one recorded when the tarball was first
+// installed once it has been read to the end.
+type checksumRecordingReader struct {
+	io.ReadCloser
+	hash     hash.Hash
+	info     PluginInfo
+	version  semver.Version
+	platform Platform
+}
+
+func newChecksumRecordingReader(r io.ReadCloser, info PluginInfo, version semver.Version,
+	platform Platform) io.ReadCloser {
+	return &checksumRecordingReader{ReadCloser: r, hash: sha256.New(), info: info, version: version, platform: platform}
+}
+
+func (r *checksumRecordingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		actual := hex.EncodeToString(r.hash.Sum(nil))
+		if checkErr := checkPluginChecksum(r.info, r.version, r.platform, actual); checkErr != nil {
+			return n, checkErr
+		}
+	}
+	return n, err
+}
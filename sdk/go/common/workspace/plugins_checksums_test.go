@@ -0,0 +1,73 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginChecksumsTrustOnFirstUse(t *testing.T) {
+	t.Parallel()
+
+	dir, plugin := newMockPlugin(t)
+	platform := Platform{OS: "linux", Arch: "amd64"}
+	asset := PluginAssetName(plugin.Kind, plugin.Name, *plugin.Version, platform)
+	download := func(tgz []byte) *checksumRecordingReader {
+		r := newChecksumRecordingReader(ioutil.NopCloser(bytes.NewReader(tgz)), plugin, *plugin.Version, platform)
+		return r.(*checksumRecordingReader)
+	}
+	recorded := func() map[string]string {
+		checksums, err := loadPluginChecksums(filepath.Join(dir, pluginChecksumsFile))
+		require.NoError(t, err)
+		return checksums
+	}
+
+	// Downloads aren't trusted until the plugin they're for has been installed.
+	tgz := mockPluginTGZ(t)
+	_, err := ioutil.ReadAll(download(tgz))
+	require.NoError(t, err)
+	assert.Empty(t, recorded())
+
+	require.NoError(t, plugin.Install(download(tgz), false))
+	sum := sha256.Sum256(tgz)
+	assert.Equal(t, map[string]string{asset: hex.EncodeToString(sum[:])}, recorded())
+
+	// Later downloads must match the recorded digest.
+	_, err = ioutil.ReadAll(download(tgz))
+	assert.NoError(t, err)
+
+	tampered := append(append([]byte{}, tgz...), 0)
+	_, err = ioutil.ReadAll(download(tampered))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch), "unexpected error %v", err)
+	assert.Contains(t, err.Error(), "recorded when the plugin was first installed")
+
+	// Mismatches are caught even if extracting the tarball doesn't read all of it, and the plugin isn't installed.
+	err = plugin.Install(download(tampered), true)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch), "unexpected error %v", err)
+	partial, err := plugin.PartialFilePath()
+	require.NoError(t, err)
+	assert.FileExists(t, partial)
+	assert.Equal(t, map[string]string{asset: hex.EncodeToString(sum[:])}, recorded())
+}
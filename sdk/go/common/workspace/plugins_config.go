@@ -0,0 +1,481 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/blang/semver"
+	"gopkg.in/yaml.v2"
+)
+
+// PluginConfigFile is the name of the file in PULUMI_HOME holding settings for downloading and managing plugins, e.g.:
+//
+//	mirrors:
+//	  - https://plugins.corp/index.json
+//	auth:
+//	  - host: plugins.corp
+//	    tokenEnv: CORP_PLUGINS_TOKEN
+//	gc:
+//	  maxAge: 720h
+//	  keepVersions: 2
+//	verification: strict
+//	dirs:
+//	  resource: /mnt/large/pulumi-plugins
+//	variants:
+//	  aws: debug
+//	downloadCommand: aria2c --quiet -o {output} {url}
+//	sizeExcludes:
+//	  - node_modules/.cache
+//	  - "*.log"
+//	licensePolicy:
+//	  allow: [MIT, Apache-2.0, "BSD-*", ISC]
+//	  deny: ["AGPL-*"]
+//	  onViolation: warn
+//	cacheKeyCommand: security find-generic-password -s pulumi-plugin-cache -w
+//	fips: true
+//	remoteCache: https://plugin-cache.corp
+//	ambientPolicy:
+//	  - kind: resource
+//	    name: "aws*"
+//	    prefer: cache
+//	stallTimeout: 90s
+//	defaultSources:
+//	  - https://plugins.corp/${KIND}/${NAME}
+//	  - github
+//	downloadURLOverrides:
+//	  - resource/aws@>=5.0.0=https://plugins.corp/aws
+//	githubOwners: [acme, acme-labs]
+//	credentialHelper: git credential-store --file /etc/pulumi/credentials
+//
+// Environment variables that configure the same settings take precedence over the file.
+const PluginConfigFile = "plugin-config.yaml"
+
+// PluginVerificationEnvVar sets the plugin verification mode, taking precedence over PluginConfigFile.
+const PluginVerificationEnvVar = "PULUMI_PLUGIN_VERIFICATION"
+
+// PluginVerificationMode is how plugin downloads are verified.
+type PluginVerificationMode string
+
+const (
+	// PluginVerificationChecksum verifies downloads against the checksums their source publishes, if it publishes any.
+	// This is the default.
+	PluginVerificationChecksum PluginVerificationMode = "checksum"
+	// PluginVerificationStrict fails downloads from sources that don't publish a checksum for them.
+	PluginVerificationStrict PluginVerificationMode = "strict"
+)
+
+// PluginConfig is the contents of PluginConfigFile.
+type PluginConfig struct {
+	// Mirrors are the URLs of plugin indexes that plugins are looked for in before their default source, like
+	// `PULUMI_PLUGIN_INDEX_URLS`, which takes precedence.
+	Mirrors []string
+	// Auth are the credentials sent to the hosts plugins are downloaded from.
+	Auth []PluginAuthMapping
+	// GC is the policy for removing unused plugins from the plugin cache.
+	GC PluginGCPolicy
+	// Verification is how plugin downloads are verified. `PULUMI_PLUGIN_VERIFICATION` takes precedence.
+	Verification PluginVerificationMode
+	// Dirs are the directories plugins of each kind are installed into, instead of the plugin directory. Their
+	// environment variables, such as `PULUMI_RESOURCE_PLUGIN_DIR`, take precedence.
+	Dirs map[PluginKind]string
+	// Variants select alternate builds of plugins, such as debug builds, by plugin name. `PULUMI_PLUGIN_VARIANTS`
+	// takes precedence.
+	Variants map[string]string
+	// DownloadCommand is the command, split into its arguments, that plugin tarballs are downloaded with instead of
+	// the CLI's HTTP client. `PULUMI_PLUGIN_DOWNLOAD_COMMAND` takes precedence.
+	DownloadCommand []string
+	// SizeExcludes are the glob patterns matching files and directories that aren't counted in the sizes of plugins,
+	// or nil to use DefaultPluginSizeExcludes. `PULUMI_PLUGIN_SIZE_EXCLUDES` takes precedence.
+	SizeExcludes []string
+	// LicensePolicy is the policy the licenses of plugin dependencies are checked against once they're installed.
+	// `PULUMI_PLUGIN_LICENSE_POLICY` takes precedence over its OnViolation.
+	LicensePolicy PluginLicensePolicy
+	// CacheKeyCommand is the command, split into its arguments, that prints the key plugins are encrypted with in the
+	// plugin cache, or nil if they aren't encrypted. `PULUMI_PLUGIN_CACHE_KEY_COMMAND` takes precedence.
+	CacheKeyCommand []string
+	// FIPS restricts plugin downloads to the TLS and the sources FIPS mode allows. `PULUMI_PLUGIN_FIPS` takes
+	// precedence.
+	FIPS bool
+	// RemoteCache is the URL of the organization's plugin cache, which plugins are downloaded from before their
+	// source. `PULUMI_PLUGIN_REMOTE_CACHE` takes precedence.
+	RemoteCache string
+	// AmbientPolicy are the rules that choose between ambient plugins and the plugin cache, by plugin kind and name.
+	// `PULUMI_PLUGIN_AMBIENT_POLICY` takes precedence.
+	AmbientPolicy []PluginAmbientRule
+	// StallTimeout is how long plugin downloads may go without receiving any bytes before they're retried, zero to use
+	// DefaultPluginStallTimeout, or negative to never retry them. `PULUMI_PLUGIN_STALL_TIMEOUT` takes precedence.
+	StallTimeout time.Duration
+	// DefaultSources are the sources plugins without a PluginDownloadURL are downloaded from, in the order they're
+	// tried, or nil to use the built-in ones. `PULUMI_PLUGIN_DEFAULT_SOURCES` takes precedence.
+	DefaultSources []string
+	// DownloadURLOverrides override the download URLs of plugins, each in the format of
+	// `PULUMI_PLUGIN_DOWNLOAD_URL_OVERRIDES`, whose overrides are matched first.
+	DownloadURLOverrides []string
+	// GitHubOwners are the GitHub organizations or users whose releases plugins without a PluginDownloadURL are
+	// downloaded from after Pulumi's, in the order they're tried. `PULUMI_PLUGIN_GITHUB_OWNERS` takes precedence.
+	GitHubOwners []string
+	// CredentialHelper is the git-credential style helper, split into its arguments, that's asked for the username and
+	// password sent to the hosts plugins are downloaded from, or nil if there's none.
+	// `PULUMI_PLUGIN_CREDENTIAL_HELPER` takes precedence.
+	CredentialHelper []string
+}
+
+// PluginAuthMapping sends a token to a host plugins are downloaded from.
+type PluginAuthMapping struct {
+	// Host is the host name, and optional port, the token is sent to.
+	Host string
+	// TokenEnv is the environment variable holding the token, so it isn't written to the file.
+	TokenEnv string
+	// Scheme is the scheme of the Authorization header the token is sent in. It defaults to "Bearer".
+	Scheme string
+}
+
+// PluginGCPolicy is the policy for removing unused plugins from the plugin cache.
+type PluginGCPolicy struct {
+	// MaxAge is how long a plugin can go unused before it's removed, or zero to never remove plugins for their age.
+	MaxAge time.Duration
+	// KeepVersions is the number of the newest versions of each plugin that are always kept, or zero to keep them all.
+	KeepVersions int
+}
+
+// pluginConfigFile is the layout of PluginConfigFile.
+type pluginConfigFile struct {
+	Mirrors []string `yaml:"mirrors"`
+	Auth    []struct {
+		Host     string `yaml:"host"`
+		TokenEnv string `yaml:"tokenEnv"`
+		Scheme   string `yaml:"scheme"`
+	} `yaml:"auth"`
+	GC struct {
+		MaxAge       string `yaml:"maxAge"`
+		KeepVersions int    `yaml:"keepVersions"`
+	} `yaml:"gc"`
+	Verification    string            `yaml:"verification"`
+	Dirs            map[string]string `yaml:"dirs"`
+	Variants        map[string]string `yaml:"variants"`
+	DownloadCommand string            `yaml:"downloadCommand"`
+	SizeExcludes    []string          `yaml:"sizeExcludes"`
+	LicensePolicy   struct {
+		Allow       []string `yaml:"allow"`
+		Deny        []string `yaml:"deny"`
+		OnViolation string   `yaml:"onViolation"`
+	} `yaml:"licensePolicy"`
+	CacheKeyCommand string `yaml:"cacheKeyCommand"`
+	FIPS            bool   `yaml:"fips"`
+	RemoteCache     string `yaml:"remoteCache"`
+	AmbientPolicy   []struct {
+		Kind   string `yaml:"kind"`
+		Name   string `yaml:"name"`
+		Prefer string `yaml:"prefer"`
+	} `yaml:"ambientPolicy"`
+	StallTimeout         string   `yaml:"stallTimeout"`
+	DefaultSources       []string `yaml:"defaultSources"`
+	DownloadURLOverrides []string `yaml:"downloadURLOverrides"`
+	GitHubOwners         []string `yaml:"githubOwners"`
+	CredentialHelper     string   `yaml:"credentialHelper"`
+}
+
+// LoadPluginConfig reads and validates the plugin configuration file at path. A file that doesn't exist is empty.
+func LoadPluginConfig(path string) (*PluginConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &PluginConfig{}, nil
+		}
+		return nil, err
+	}
+	var file pluginConfigFile
+	if err := yaml.UnmarshalStrict(b, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	config, err := file.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// validate checks the settings in the file, naming the key of the first invalid one.
+func (file pluginConfigFile) validate() (*PluginConfig, error) {
+	config := &PluginConfig{}
+	for i, mirror := range file.Mirrors {
+		if u, err := url.Parse(mirror); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("mirrors[%d]: %q is not an absolute URL", i, mirror)
+		}
+		config.Mirrors = append(config.Mirrors, mirror)
+	}
+	for i, auth := range file.Auth {
+		if auth.Host == "" {
+			return nil, fmt.Errorf("auth[%d].host: must be set", i)
+		} else if auth.TokenEnv == "" {
+			return nil, fmt.Errorf("auth[%d].tokenEnv: must be set", i)
+		}
+		scheme := auth.Scheme
+		if scheme == "" {
+			scheme = "Bearer"
+		}
+		config.Auth = append(config.Auth, PluginAuthMapping{Host: auth.Host, TokenEnv: auth.TokenEnv, Scheme: scheme})
+	}
+	if file.GC.MaxAge != "" {
+		maxAge, err := time.ParseDuration(file.GC.MaxAge)
+		if err != nil || maxAge < 0 {
+			return nil, fmt.Errorf("gc.maxAge: %q is not a positive duration, such as 720h", file.GC.MaxAge)
+		}
+		config.GC.MaxAge = maxAge
+	}
+	if file.GC.KeepVersions < 0 {
+		return nil, fmt.Errorf("gc.keepVersions: must not be negative; got %d", file.GC.KeepVersions)
+	}
+	config.GC.KeepVersions = file.GC.KeepVersions
+	if file.Verification != "" {
+		mode, err := parsePluginVerificationMode(file.Verification)
+		if err != nil {
+			return nil, fmt.Errorf("verification: %w", err)
+		}
+		config.Verification = mode
+	}
+	for kind, dir := range file.Dirs {
+		if !IsPluginKind(kind) {
+			return nil, fmt.Errorf("dirs.%s: unrecognized plugin kind", kind)
+		} else if !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("dirs.%s: %q is not an absolute path", kind, dir)
+		}
+		if config.Dirs == nil {
+			config.Dirs = map[PluginKind]string{}
+		}
+		config.Dirs[PluginKind(kind)] = dir
+	}
+	for name, variant := range file.Variants {
+		if err := ValidatePluginVariant(variant); err != nil {
+			return nil, fmt.Errorf("variants.%s: %w", name, err)
+		}
+		if config.Variants == nil {
+			config.Variants = map[string]string{}
+		}
+		config.Variants[name] = variant
+	}
+	if file.DownloadCommand != "" {
+		command, err := parsePluginDownloadCommand(file.DownloadCommand)
+		if err != nil {
+			return nil, fmt.Errorf("downloadCommand: %w", err)
+		}
+		config.DownloadCommand = command
+	}
+	for i, pattern := range file.SizeExcludes {
+		if err := validatePluginSizeExclude(pattern); err != nil {
+			return nil, fmt.Errorf("sizeExcludes[%d]: %w", i, err)
+		}
+	}
+	config.SizeExcludes = file.SizeExcludes
+	for i, pattern := range file.LicensePolicy.Allow {
+		if err := validatePluginLicensePattern(pattern); err != nil {
+			return nil, fmt.Errorf("licensePolicy.allow[%d]: %w", i, err)
+		}
+	}
+	for i, pattern := range file.LicensePolicy.Deny {
+		if err := validatePluginLicensePattern(pattern); err != nil {
+			return nil, fmt.Errorf("licensePolicy.deny[%d]: %w", i, err)
+		}
+	}
+	config.LicensePolicy.Allow, config.LicensePolicy.Deny = file.LicensePolicy.Allow, file.LicensePolicy.Deny
+	if file.LicensePolicy.OnViolation != "" {
+		action, err := parsePluginLicensePolicyAction(file.LicensePolicy.OnViolation)
+		if err != nil {
+			return nil, fmt.Errorf("licensePolicy.onViolation: %w", err)
+		}
+		config.LicensePolicy.OnViolation = action
+	}
+	if file.CacheKeyCommand != "" {
+		command, err := parsePluginCacheKeyCommand(file.CacheKeyCommand)
+		if err != nil {
+			return nil, fmt.Errorf("cacheKeyCommand: %w", err)
+		}
+		config.CacheKeyCommand = command
+	}
+	config.FIPS = file.FIPS
+	if file.RemoteCache != "" {
+		if err := validatePluginRemoteCache(file.RemoteCache); err != nil {
+			return nil, fmt.Errorf("remoteCache: %w", err)
+		}
+		config.RemoteCache = file.RemoteCache
+	}
+	for i, entry := range file.AmbientPolicy {
+		rule := PluginAmbientRule{Kind: PluginKind(entry.Kind), Name: entry.Name}
+		if err := validatePluginAmbientRule(rule); err != nil {
+			return nil, fmt.Errorf("ambientPolicy[%d]: %w", i, err)
+		}
+		preference, err := parsePluginAmbientPreference(entry.Prefer)
+		if err != nil {
+			return nil, fmt.Errorf("ambientPolicy[%d].prefer: %w", i, err)
+		}
+		rule.Prefer = preference
+		config.AmbientPolicy = append(config.AmbientPolicy, rule)
+	}
+	if file.StallTimeout != "" {
+		timeout, err := parsePluginStallTimeout(file.StallTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("stallTimeout: %w", err)
+		}
+		config.StallTimeout = timeout
+	}
+	for i, source := range file.DefaultSources {
+		if err := validatePluginDefaultSource(source); err != nil {
+			return nil, fmt.Errorf("defaultSources[%d]: %w", i, err)
+		}
+		config.DefaultSources = append(config.DefaultSources, source)
+	}
+	for i, override := range file.DownloadURLOverrides {
+		if _, err := parsePluginDownloadURLOverrides(override); err != nil {
+			return nil, fmt.Errorf("downloadURLOverrides[%d]: %w", i, err)
+		}
+		config.DownloadURLOverrides = append(config.DownloadURLOverrides, override)
+	}
+	for i, owner := range file.GitHubOwners {
+		if err := validateGitHubOwner(owner); err != nil {
+			return nil, fmt.Errorf("githubOwners[%d]: %w", i, err)
+		}
+		config.GitHubOwners = append(config.GitHubOwners, owner)
+	}
+	if file.CredentialHelper != "" {
+		helper, err := parsePluginCredentialHelper(file.CredentialHelper)
+		if err != nil {
+			return nil, fmt.Errorf("credentialHelper: %w", err)
+		}
+		config.CredentialHelper = helper
+	}
+	return config, nil
+}
+
+func parsePluginVerificationMode(s string) (PluginVerificationMode, error) {
+	switch mode := PluginVerificationMode(s); mode {
+	case PluginVerificationChecksum, PluginVerificationStrict:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("expected %q or %q; got %q", PluginVerificationChecksum, PluginVerificationStrict, s)
+	}
+}
+
+// pluginConfigs caches the plugin configuration files that have been loaded, by path, so each is only read once.
+var pluginConfigs = struct {
+	sync.Mutex
+	loaded map[string]*PluginConfig
+}{loaded: map[string]*PluginConfig{}}
+
+// GetPluginConfig returns the settings in the context's PluginConfigFile. The file is only read the first time.
+func (ctx *Context) GetPluginConfig() (*PluginConfig, error) {
+	path, err := ctx.GetPulumiPath(PluginConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pluginConfigs.Lock()
+	defer pluginConfigs.Unlock()
+	if config, ok := pluginConfigs.loaded[path]; ok {
+		return config, nil
+	}
+	config, err := LoadPluginConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	pluginConfigs.loaded[path] = config
+	return config, nil
+}
+
+// GetPluginConfig returns the settings in PluginConfigFile. The file is only read the first time.
+func GetPluginConfig() (*PluginConfig, error) {
+	return (&Context{}).GetPluginConfig()
+}
+
+// getPluginMirrors returns the plugin indexes from `PULUMI_PLUGIN_INDEX_URLS`, or PluginConfigFile if it isn't set.
+func getPluginMirrors() ([]string, error) {
+	if mirrors := splitEnvList(os.Getenv(PluginIndexURLsEnvVar)); len(mirrors) > 0 {
+		return mirrors, nil
+	}
+	config, err := GetPluginConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.Mirrors, nil
+}
+
+// getPluginVerificationMode returns the verification mode from `PULUMI_PLUGIN_VERIFICATION`, or PluginConfigFile if
+// it isn't set.
+func getPluginVerificationMode() (PluginVerificationMode, error) {
+	if v := os.Getenv(PluginVerificationEnvVar); v != "" {
+		mode, err := parsePluginVerificationMode(v)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", PluginVerificationEnvVar, err)
+		}
+		return mode, nil
+	}
+	config, err := GetPluginConfig()
+	if err != nil {
+		return "", err
+	}
+	if config.Verification == "" {
+		return PluginVerificationChecksum, nil
+	}
+	return config.Verification, nil
+}
+
+// applyPluginAuth adds the token configured for the request's host in PluginConfigFile, if there is one, to req.
+func applyPluginAuth(req *http.Request) error {
+	config, err := GetPluginConfig()
+	if err != nil {
+		return err
+	}
+	for _, auth := range config.Auth {
+		if auth.Host != req.URL.Host {
+			continue
+		}
+		if token := os.Getenv(auth.TokenEnv); token != "" {
+			filterCredentials(token)
+			req.Header.Set("Authorization", auth.Scheme+" "+token)
+		}
+		return nil
+	}
+	return nil
+}
+
+// requireVerifiedDownload fails in strict verification mode if source doesn't publish a checksum to verify the
+// download of the given version of the plugin for platform against.
+func requireVerifiedDownload(info PluginInfo, source PluginSource, version semver.Version, platform Platform,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) error {
+	mode, err := getPluginVerificationMode()
+	if err != nil || mode != PluginVerificationStrict {
+		return err
+	}
+	switch source := source.(type) {
+	case *terraformRegistrySource:
+		// Terraform providers are always verified against their registry's signed checksums.
+		return nil
+	case checksumSource:
+		expected, _, err := source.Checksum(version, platform.assetOS(), platform.Arch, getHTTPResponse)
+		if err != nil || expected != "" {
+			return err
+		}
+	}
+	return fmt.Errorf("plugin verification is %s, but no checksum is published for %s plugin %s v%s on %s",
+		mode, info.Kind, info.Name, version, platform)
+}
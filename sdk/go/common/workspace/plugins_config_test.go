@@ -0,0 +1,207 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+func writePluginConfig(t *testing.T, dir, contents string) string {
+	path := filepath.Join(dir, PluginConfigFile)
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func TestLoadPluginConfig(t *testing.T) {
+	t.Parallel()
+
+	config, err := LoadPluginConfig(filepath.Join(t.TempDir(), PluginConfigFile))
+	require.NoError(t, err)
+	assert.Equal(t, &PluginConfig{}, config)
+
+	path := writePluginConfig(t, t.TempDir(), `
+mirrors:
+  - https://plugins.corp/index.json
+auth:
+  - host: plugins.corp
+    tokenEnv: CORP_PLUGINS_TOKEN
+  - host: github.corp
+    tokenEnv: CORP_GITHUB_TOKEN
+    scheme: token
+gc:
+  maxAge: 720h
+  keepVersions: 2
+verification: strict
+variants:
+  aws: debug
+downloadCommand: fetch --out {output} {url}
+sizeExcludes: ["*.log"]
+licensePolicy:
+  allow: [MIT, "BSD-*"]
+  deny: ["AGPL-*"]
+  onViolation: warn
+cacheKeyCommand: age --decrypt -i key.txt cache-key.age
+fips: true
+remoteCache: https://plugin-cache.corp
+ambientPolicy:
+  - kind: resource
+    name: "aws*"
+    prefer: cache
+  - name: "*"
+    prefer: ambient
+stallTimeout: 90s
+defaultSources: ["https://plugins.corp/${KIND}/${NAME}", github]
+downloadURLOverrides: ["resource/aws@>=5.0.0=https://plugins.corp/aws"]
+githubOwners: [acme, acme-labs]
+credentialHelper: git credential-store --file /etc/pulumi/credentials
+`)
+	config, err = LoadPluginConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, &PluginConfig{
+		Mirrors: []string{"https://plugins.corp/index.json"},
+		Auth: []PluginAuthMapping{
+			{Host: "plugins.corp", TokenEnv: "CORP_PLUGINS_TOKEN", Scheme: "Bearer"},
+			{Host: "github.corp", TokenEnv: "CORP_GITHUB_TOKEN", Scheme: "token"},
+		},
+		GC:              PluginGCPolicy{MaxAge: 720 * time.Hour, KeepVersions: 2},
+		Verification:    PluginVerificationStrict,
+		Variants:        map[string]string{"aws": "debug"},
+		DownloadCommand: []string{"fetch", "--out", "{output}", "{url}"},
+		SizeExcludes:    []string{"*.log"},
+		LicensePolicy: PluginLicensePolicy{
+			Allow:       []string{"MIT", "BSD-*"},
+			Deny:        []string{"AGPL-*"},
+			OnViolation: PluginLicensePolicyWarn,
+		},
+		CacheKeyCommand: []string{"age", "--decrypt", "-i", "key.txt", "cache-key.age"},
+		FIPS:            true,
+		RemoteCache:     "https://plugin-cache.corp",
+		AmbientPolicy: []PluginAmbientRule{
+			{Kind: ResourcePlugin, Name: "aws*", Prefer: PluginCachePreferred},
+			{Name: "*", Prefer: PluginAmbientPreferred},
+		},
+		StallTimeout:         90 * time.Second,
+		DefaultSources:       []string{"https://plugins.corp/${KIND}/${NAME}", "github"},
+		DownloadURLOverrides: []string{"resource/aws@>=5.0.0=https://plugins.corp/aws"},
+		GitHubOwners:         []string{"acme", "acme-labs"},
+		CredentialHelper:     []string{"git", "credential-store", "--file", "/etc/pulumi/credentials"},
+	}, config)
+}
+
+func TestLoadPluginConfigErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		contents string
+		expected string
+	}{
+		{"mirror: https://plugins.corp", "field mirror not found"},
+		{"mirrors: [plugins.corp]", `mirrors[0]: "plugins.corp" is not an absolute URL`},
+		{"auth: [{tokenEnv: TOKEN}]", "auth[0].host: must be set"},
+		{"auth: [{host: plugins.corp}]", "auth[0].tokenEnv: must be set"},
+		{"gc: {maxAge: 30 days}", `gc.maxAge: "30 days" is not a positive duration, such as 720h`},
+		{"gc: {keepVersions: -1}", "gc.keepVersions: must not be negative; got -1"},
+		{"verification: none", `verification: expected "checksum" or "strict"; got "none"`},
+		{"dirs: {provider: /plugins}", "dirs.provider: unrecognized plugin kind"},
+		{"dirs: {resource: plugins}", `dirs.resource: "plugins" is not an absolute path`},
+		{"variants: {aws: Debug}", `variants.aws: "Debug" is not a valid plugin variant`},
+		{"downloadCommand: fetch {url}", `downloadCommand: the download command "fetch {url}" doesn't contain {output}`},
+		{"sizeExcludes: ['[logs']", `sizeExcludes[0]: "[logs" is not a valid glob pattern`},
+		{"licensePolicy: {deny: ['[GPL']}", `licensePolicy.deny[0]: "[GPL" is not a valid glob pattern`},
+		{"licensePolicy: {onViolation: ignore}", `licensePolicy.onViolation: expected "fail", "warn" or "off"; got "ignore"`},
+		{"cacheKeyCommand: ' '", "cacheKeyCommand: the key command is empty"},
+		{"remoteCache: plugin-cache.corp", `remoteCache: "plugin-cache.corp" is not an absolute URL`},
+		{"ambientPolicy: [{kind: provider, name: aws}]", `ambientPolicy[0]: unrecognized plugin kind "provider"`},
+		{"ambientPolicy: [{prefer: cache}]", "ambientPolicy[0]: the name pattern is empty"},
+		{"ambientPolicy: [{name: aws, prefer: path}]",
+			`ambientPolicy[0].prefer: expected "ambient", "cache" or "ignore"; got "path"`},
+		{"stallTimeout: soon", `stallTimeout: "soon" is not a positive duration, such as 90s, or off`},
+		{"defaultSources: [gitlab]",
+			`defaultSources[0]: "gitlab" is not github, github-private, get.pulumi.com, or the URL of a plugin mirror`},
+		{"downloadURLOverrides: ['aws@five=https://plugins.corp/aws']",
+			"downloadURLOverrides[0]: invalid version range in plugin download URL override"},
+		{"githubOwners: [acme/labs]",
+			`githubOwners[0]: "acme/labs" is not the name of a GitHub organization or user`},
+		{"credentialHelper: ' '", "credentialHelper: the credential helper command is empty"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.contents, func(t *testing.T) {
+			t.Parallel()
+
+			path := writePluginConfig(t, t.TempDir(), tt.contents)
+			_, err := LoadPluginConfig(path)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), path)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestPluginConfigPrecedence(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv(PulumiHomeEnvVar, home)
+	t.Setenv(PluginIndexURLsEnvVar, "")
+	t.Setenv(PluginVerificationEnvVar, "")
+	t.Setenv("CORP_PLUGINS_TOKEN", "corp-plugins-token")
+	writePluginConfig(t, home, `
+mirrors: [https://plugins.corp/index.json]
+auth: [{host: plugins.corp, tokenEnv: CORP_PLUGINS_TOKEN}]
+verification: strict
+`)
+
+	mirrors, err := getPluginMirrors()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://plugins.corp/index.json"}, mirrors)
+	mode, err := getPluginVerificationMode()
+	require.NoError(t, err)
+	assert.Equal(t, PluginVerificationStrict, mode)
+
+	req, err := buildHTTPRequest("https://plugins.corp/aws.tar.gz", "")
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer corp-plugins-token", req.Header.Get("Authorization"))
+	assert.Equal(t, "Bearer [credential]", logging.FilterString("Bearer corp-plugins-token"))
+	req, err = buildHTTPRequest("https://other.corp/aws.tar.gz", "")
+	require.NoError(t, err)
+	assert.Empty(t, req.Header.Get("Authorization"))
+
+	v := semver.MustParse("1.0.0")
+	err = requireVerifiedDownload(PluginInfo{Name: "aws", Kind: ResourcePlugin}, newGetPulumiSource("aws", ResourcePlugin),
+		v, Platform{OS: "linux", Arch: "amd64"}, (&Context{}).getHTTPResponse)
+	assert.EqualError(t, err,
+		"plugin verification is strict, but no checksum is published for resource plugin aws v1.0.0 on linux/amd64")
+
+	// Environment variables take precedence over the file.
+	t.Setenv(PluginIndexURLsEnvVar, "https://mirror.corp/index.json")
+	t.Setenv(PluginVerificationEnvVar, "checksum")
+	mirrors, err = getPluginMirrors()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://mirror.corp/index.json"}, mirrors)
+	mode, err = getPluginVerificationMode()
+	require.NoError(t, err)
+	assert.Equal(t, PluginVerificationChecksum, mode)
+	assert.NoError(t, requireVerifiedDownload(PluginInfo{Name: "aws", Kind: ResourcePlugin},
+		newGetPulumiSource("aws", ResourcePlugin), v, Platform{OS: "linux", Arch: "amd64"}, (&Context{}).getHTTPResponse))
+}
@@ -0,0 +1,245 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// PluginCredentialHelperEnvVar is a git-credential style helper that's asked for the username and password sent to
+// the hosts plugins are downloaded from, e.g. `git credential-store --file /etc/pulumi/credentials`. It's run with
+// the argument `get`, is sent the request's `protocol` and `host` on stdin as git sends them, and prints the
+// `username` and `password` to send, or nothing if it has none. It takes precedence over the `credentialHelper`
+// setting of PluginConfigFile.
+//
+// HTTPS requests that aren't sent a token are sent the credentials of the helper, if it has any for their host, or
+// else of the `machine` entry of the netrc file for their host, which is `~/.netrc` (`%USERPROFILE%\_netrc` on
+// Windows) unless `NETRC` names another. Its `default` entry is ignored, so credentials are never sent to hosts that
+// weren't listed.
+const PluginCredentialHelperEnvVar = "PULUMI_PLUGIN_CREDENTIAL_HELPER"
+
+// pluginCredentials is a username and password sent to a host plugins are downloaded from.
+type pluginCredentials struct {
+	username string
+	password string
+}
+
+// parsePluginCredentialHelper splits a credential helper command into its arguments.
+func parsePluginCredentialHelper(s string) ([]string, error) {
+	args := strings.Fields(s)
+	if len(args) == 0 {
+		return nil, errors.New("the credential helper command is empty")
+	}
+	return args, nil
+}
+
+// getPluginCredentialHelper returns the credential helper command from PluginCredentialHelperEnvVar, or
+// PluginConfigFile if it isn't set, or nil if there's no helper.
+func getPluginCredentialHelper() ([]string, error) {
+	if env := os.Getenv(PluginCredentialHelperEnvVar); env != "" {
+		args, err := parsePluginCredentialHelper(env)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", PluginCredentialHelperEnvVar, err)
+		}
+		return args, nil
+	}
+	config, err := GetPluginConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.CredentialHelper, nil
+}
+
+// pluginHelperCredentials caches the credentials credential helpers print, by command, protocol and host, so each
+// helper is only run once for each host.
+var pluginHelperCredentials = struct {
+	sync.Mutex
+	creds map[string]*pluginCredentials
+}{creds: map[string]*pluginCredentials{}}
+
+// getHelperCredentials returns the credentials the helper prints for the protocol and host, or nil if it has none.
+func getHelperCredentials(helper []string, protocol, host string) (*pluginCredentials, error) {
+	id := strings.Join(append(append([]string{}, helper...), protocol, host), "\x00")
+	pluginHelperCredentials.Lock()
+	defer pluginHelperCredentials.Unlock()
+	if creds, ok := pluginHelperCredentials.creds[id]; ok {
+		return creds, nil
+	}
+
+	logf(9, nil, "running %s for the credentials of %s", helper[0], host)
+	var stderr bytes.Buffer
+	cmd := exec.Command(helper[0], append(helper[1:], "get")...) //nolint:gosec // the user configured the command
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=%s\nhost=%s\n\n", protocol, host))
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running the plugin credential helper `%s`: %w: %s",
+			strings.Join(helper, " "), err, strings.TrimSpace(stderr.String()))
+	}
+
+	var creds pluginCredentials
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value := scanner.Text(), ""
+		if eq := strings.Index(key, "="); eq >= 0 {
+			key, value = key[:eq], key[eq+1:]
+		}
+		switch key {
+		case "username":
+			creds.username = value
+		case "password":
+			creds.password = value
+		}
+	}
+	var result *pluginCredentials
+	if creds.password != "" {
+		result = &creds
+	}
+	pluginHelperCredentials.creds[id] = result
+	return result, nil
+}
+
+// netrcPath returns the path of the netrc file, or "" if there's no home directory to find it in.
+func netrcPath() string {
+	if env := os.Getenv("NETRC"); env != "" {
+		return env
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if runtime.GOOS == windowsGOOS {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// parseNetrc returns the credentials of each `machine` of a netrc file, by machine. The credentials of the first entry
+// of each machine are returned, and `default` entries and macros are skipped.
+func parseNetrc(data string) map[string]pluginCredentials {
+	machines := map[string]pluginCredentials{}
+	var machine string
+	var creds pluginCredentials
+	end := func() {
+		if _, ok := machines[machine]; machine != "" && !ok {
+			machines[machine] = creds
+		}
+		machine, creds = "", pluginCredentials{}
+	}
+
+	inMacro := false
+	for _, line := range strings.Split(data, "\n") {
+		if inMacro {
+			// Macros end at an empty line.
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		fields := strings.Fields(line)
+	tokens:
+		for i := 0; i < len(fields); i++ {
+			switch fields[i] {
+			case "machine", "default":
+				end()
+				if fields[i] == "machine" && i+1 < len(fields) {
+					i++
+					machine = fields[i]
+				}
+			case "login":
+				if i+1 < len(fields) {
+					i++
+					creds.username = fields[i]
+				}
+			case "password":
+				if i+1 < len(fields) {
+					i++
+					creds.password = fields[i]
+				}
+			case "account":
+				i++
+			case "macdef":
+				inMacro = true
+				break tokens
+			}
+		}
+	}
+	end()
+	return machines
+}
+
+// getNetrcCredentials returns the credentials the netrc file has for host, or nil if it has none.
+func getNetrcCredentials(host, hostname string) (*pluginCredentials, error) {
+	path := netrcPath()
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	machines := parseNetrc(string(data))
+	for _, machine := range []string{host, hostname} {
+		if creds, ok := machines[machine]; ok {
+			return &creds, nil
+		}
+	}
+	return nil, nil
+}
+
+// applyPluginCredentials sends the credentials of the credential helper or netrc file for the request's host, if
+// either has any, to req. Requests that already carry credentials are left as they are, and credentials are never
+// sent over plain HTTP.
+func applyPluginCredentials(req *http.Request) error {
+	if req.Header.Get("Authorization") != "" || req.URL.User != nil || req.URL.Host == "" ||
+		req.URL.Scheme != "https" {
+		return nil
+	}
+
+	helper, err := getPluginCredentialHelper()
+	if err != nil {
+		return err
+	}
+	var creds *pluginCredentials
+	if helper != nil {
+		if creds, err = getHelperCredentials(helper, req.URL.Scheme, req.URL.Host); err != nil {
+			return err
+		}
+	}
+	if creds == nil {
+		if creds, err = getNetrcCredentials(req.URL.Host, req.URL.Hostname()); err != nil {
+			return err
+		}
+	}
+	if creds != nil {
+		req.SetBasicAuth(creds.username, creds.password)
+		// The password is sent base64-encoded with the username, so that's kept out of the logs too.
+		encoded := strings.TrimPrefix(req.Header.Get("Authorization"), "Basic ")
+		filterCredentials(creds.password, encoded)
+	}
+	return nil
+}
@@ -0,0 +1,450 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// PluginDeltaUpdatesEnvVar enables delta updates of plugins when set to a truthy value. The tarball of each downloaded
+// plugin is kept, and when a newer version is installed from a source that publishes binary diffs, only the patch
+// from the kept tarball is downloaded.
+const PluginDeltaUpdatesEnvVar = "PULUMI_PLUGIN_DELTA_UPDATES"
+
+// pluginArchivesDir is the directory in the plugin cache that holds the tarballs kept for delta updates and the peer
+// cache.
+const pluginArchivesDir = ".archives"
+
+// maxPatchGrowth bounds the size of the tarballs patches produce, as a multiple of the size of the tarball they're
+// applied to, when the source doesn't publish the size of the tarball.
+const maxPatchGrowth = 8
+
+// patchSource is implemented by plugin sources that publish binary diffs between plugin versions.
+type patchSource interface {
+	// DownloadPatch fetches a bsdiff patch that turns the tarball of version from into that of version to, and
+	// returns it along with the hex-encoded SHA-256 digest of the tarball it produces and its size, or -1 if the size
+	// isn't known. If the source publishes no such patch, the returned reader is nil.
+	DownloadPatch(from, to semver.Version, opSy string, arch string,
+		getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, string, int64, error)
+}
+
+// pluginDeltaUpdatesEnabled returns true if PluginDeltaUpdatesEnvVar is set.
+func pluginDeltaUpdatesEnabled() bool {
+	return cmdutil.IsTruthy(os.Getenv(PluginDeltaUpdatesEnvVar))
+}
+
+// downloadPlatform downloads the given version of a plugin for the given platform from source. With a remote cache
+// configured, the tarball is looked for in it first, and uploaded to it if it's downloaded from source instead. With
+// the peer cache enabled, peers on the local network are asked for the tarball next. With delta updates enabled, a
+// patch from the newest kept tarball of an earlier version is tried next. In either case, the tarball that is
+// downloaded is kept. In strict verification mode, sources that don't publish a checksum for the tarball aren't
+// downloaded from at all. Whatever it's downloaded from, the tarball must match the digest recorded when it was first
+// installed, if any. If a download command is configured, it transfers what the source downloads.
+func downloadPlatform(info PluginInfo, source PluginSource, version semver.Version, platform Platform,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	getHTTPResponse, err := info.context().delegatePluginDownloads(getHTTPResponse)
+	if err != nil {
+		return nil, -1, err
+	}
+	if err := requireVerifiedDownload(info, source, version, platform, getHTTPResponse); err != nil {
+		return nil, -1, err
+	}
+
+	peers, delta := pluginPeerCacheEnabled(), pluginDeltaUpdatesEnabled()
+	if peers {
+		// Peers serve tarballs over plain HTTP, which FIPS mode doesn't allow.
+		fips, err := info.context().fipsMode()
+		if err != nil {
+			return nil, -1, err
+		}
+		peers = !fips
+	}
+	cache, err := info.context().getPluginRemoteCache()
+	if err != nil {
+		return nil, -1, err
+	}
+	if cache != "" {
+		r, length, err := downloadFromRemoteCacheFor(info, source, cache, version, platform, getHTTPResponse)
+		if err != nil {
+			info.logf(5, "could not download %s from the remote plugin cache: %v", info.Name, err)
+		} else if r != nil {
+			return newChecksumRecordingReader(r, info, version, platform), length, nil
+		}
+	}
+	// uploaded uploads what's downloaded from the source to the remote cache, once it's verified.
+	uploaded := func(checked io.ReadCloser) io.ReadCloser {
+		if cache == "" {
+			return checked
+		}
+		return newRemoteCacheUploadingReader(checked, info, cache, version, platform, getHTTPResponse)
+	}
+
+	if !peers && !delta {
+		resp, length, err := source.Download(version, platform.assetOS(), platform.Arch, getHTTPResponse)
+		if err != nil {
+			return nil, -1, err
+		}
+		checked := newChecksumRecordingReader(resp, info, version, platform)
+		return withExtractedSizeOf(uploaded(checked), resp), length, nil
+	}
+
+	if checksums, ok := source.(checksumSource); ok && peers {
+		expected, size, err := checksums.Checksum(version, platform.assetOS(), platform.Arch, getHTTPResponse)
+		if err == nil && expected != "" {
+			var r io.ReadCloser
+			var length int64
+			if r, length, err = downloadFromPeers(info, version, platform, expected, size); err == nil && r != nil {
+				return newChecksumRecordingReader(r, info, version, platform), length, nil
+			}
+		}
+		if err != nil {
+			info.logf(5, "could not download %s from peers: %v", info.Name, err)
+		}
+	}
+
+	if patches, ok := source.(patchSource); ok && delta {
+		tarball, err := downloadPatched(info, patches, version, platform, getHTTPResponse)
+		if err != nil {
+			info.logf(1, "delta update of %s failed, downloading the full plugin: %v", info.Name, err)
+		} else if tarball != nil {
+			sum := sha256.Sum256(tarball)
+			if err := checkPluginChecksum(info, version, platform, hex.EncodeToString(sum[:])); err != nil {
+				return nil, -1, err
+			}
+			if err := keepPluginArchive(info, version, platform, bytes.NewReader(tarball)); err != nil {
+				info.logf(5, "could not keep tarball of %s for delta updates: %v", info.Name, err)
+			}
+			return ioutil.NopCloser(bytes.NewReader(tarball)), int64(len(tarball)), nil
+		}
+	}
+
+	resp, length, err := source.Download(version, platform.assetOS(), platform.Arch, getHTTPResponse)
+	if err != nil {
+		return nil, -1, err
+	}
+	checked := newChecksumRecordingReader(resp, info, version, platform)
+	return withExtractedSizeOf(newArchiveKeepingReader(uploaded(checked), info, version, platform), resp), length, nil
+}
+
+// downloadPatched returns the tarball of the given plugin version produced by patching the newest kept tarball of an
+// earlier version, or nil if there is no kept tarball or the source has no patch from it.
+func downloadPatched(info PluginInfo, source patchSource, version semver.Version, platform Platform,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]byte, error) {
+	from, base, ok, err := keptPluginArchive(info, version, platform)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	patch, expected, size, err := source.DownloadPatch(from, version, platform.assetOS(), platform.Arch, getHTTPResponse)
+	if err != nil || patch == nil {
+		return nil, err
+	}
+	defer contract.IgnoreClose(patch)
+
+	info.logf(1, "%s updating from v%s to v%s with a binary patch", info.Name, from, version)
+	old, err := ioutil.ReadFile(base)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := ioutil.ReadAll(patch)
+	if err != nil {
+		return nil, err
+	}
+	// Patches can't produce tarballs larger than the source says they are, or much larger than the kept tarball.
+	maxLen := size
+	if maxLen < 0 {
+		maxLen = int64(len(old))*maxPatchGrowth + 1<<20
+	}
+	tarball, err := bspatch(old, diff, maxLen)
+	if err != nil {
+		return nil, fmt.Errorf("applying patch from v%s: %w", from, err)
+	}
+
+	sum := sha256.Sum256(tarball)
+	if actual := hex.EncodeToString(sum[:]); actual != strings.ToLower(expected) {
+		name := "patched " + PluginAssetName(info.Kind, info.Name, version, platform)
+		return nil, checksumMismatchError(name, expected, actual)
+	}
+	return tarball, nil
+}
+
+// pluginArchivesPath returns the directory in which tarballs are kept for delta updates.
+func pluginArchivesPath(info PluginInfo) (string, error) {
+	dir, err := info.pluginCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, pluginArchivesDir), nil
+}
+
+// keptPluginArchives returns the paths of the tarballs kept for the given plugin and platform, by version.
+func keptPluginArchives(info PluginInfo, platform Platform) (map[string]semver.Version, error) {
+	dir, err := pluginArchivesPath(info)
+	if err != nil {
+		return nil, err
+	}
+	prefix := "pulumi-" + string(info.Kind) + "-" + info.Name + "-v"
+	suffix := "-" + platform.assetOS() + "-" + platform.Arch + ".tar.gz"
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	archives := map[string]semver.Version{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		version, err := semver.Parse(strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix))
+		if err != nil {
+			continue
+		}
+		archives[filepath.Join(dir, name)] = version
+	}
+	return archives, nil
+}
+
+// keptPluginArchive returns the newest kept tarball of a version of the plugin earlier than version.
+func keptPluginArchive(info PluginInfo, version semver.Version,
+	platform Platform) (semver.Version, string, bool, error) {
+	archives, err := keptPluginArchives(info, platform)
+	if err != nil {
+		return semver.Version{}, "", false, err
+	}
+	var newest semver.Version
+	var path string
+	for p, v := range archives {
+		if v.LT(version) && (path == "" || v.GT(newest)) {
+			newest, path = v, p
+		}
+	}
+	return newest, path, path != "", nil
+}
+
+// keepPluginArchive stores the tarball of the given plugin version for future delta updates, replacing the tarballs
+// kept for other versions of the plugin.
+func keepPluginArchive(info PluginInfo, version semver.Version, platform Platform, tarball io.Reader) error {
+	dir, err := pluginArchivesPath(info)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, "download-")
+	if err != nil {
+		return err
+	}
+	defer func() { contract.IgnoreError(os.Remove(tmp.Name())) }()
+
+	if _, err := io.Copy(tmp, tarball); err != nil {
+		contract.IgnoreClose(tmp)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return finishKeepingPluginArchive(info, version, platform, tmp.Name())
+}
+
+// finishKeepingPluginArchive moves the downloaded tarball at tmp into place, and removes the tarballs kept for other
+// versions of the plugin.
+func finishKeepingPluginArchive(info PluginInfo, version semver.Version, platform Platform, tmp string) error {
+	old, err := keptPluginArchives(info, platform)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(filepath.Dir(tmp), PluginAssetName(info.Kind, info.Name, version, platform))
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	for p := range old {
+		if p != path {
+			contract.IgnoreError(os.Remove(p))
+		}
+	}
+	return nil
+}
+
+// archiveKeepingReader copies a downloaded tarball into the archives directory as it is read, keeping it once the
+// download has been read to the end without error.
+type archiveKeepingReader struct {
+	io.ReadCloser
+	info     PluginInfo
+	version  semver.Version
+	platform Platform
+	tmp      *os.File
+}
+
+func newArchiveKeepingReader(r io.ReadCloser, info PluginInfo, version semver.Version,
+	platform Platform) io.ReadCloser {
+	dir, err := pluginArchivesPath(info)
+	if err == nil {
+		err = os.MkdirAll(dir, 0700)
+	}
+	var tmp *os.File
+	if err == nil {
+		tmp, err = ioutil.TempFile(dir, "download-")
+	}
+	if err != nil {
+		info.logf(5, "could not keep tarball of %s for delta updates: %v", info.Name, err)
+		return r
+	}
+	return &archiveKeepingReader{ReadCloser: r, info: info, version: version, platform: platform, tmp: tmp}
+}
+
+func (r *archiveKeepingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if r.tmp == nil {
+		return n, err
+	}
+	if _, werr := r.tmp.Write(p[:n]); werr != nil {
+		r.discard()
+		return n, err
+	}
+	if err == io.EOF {
+		tmp := r.tmp.Name()
+		closeErr := r.tmp.Close()
+		r.tmp = nil
+		if closeErr == nil {
+			closeErr = finishKeepingPluginArchive(r.info, r.version, r.platform, tmp)
+		}
+		if closeErr != nil {
+			r.info.logf(5, "could not keep tarball of %s for delta updates: %v", r.info.Name, closeErr)
+			contract.IgnoreError(os.Remove(tmp))
+		}
+	} else if err != nil {
+		r.discard()
+	}
+	return n, err
+}
+
+// Close keeps the tarball if the download was read to the end. Extracting a tarball can stop short of the end of the
+// download, e.g. before the gzip trailer, so whatever remains is read first.
+func (r *archiveKeepingReader) Close() error {
+	if r.tmp != nil {
+		_, err := io.Copy(ioutil.Discard, r)
+		if err != nil {
+			r.info.logf(5, "could not keep tarball of %s for delta updates: %v", r.info.Name, err)
+		}
+	}
+	r.discard()
+	return r.ReadCloser.Close()
+}
+
+// discard removes the partially kept tarball.
+func (r *archiveKeepingReader) discard() {
+	if r.tmp != nil {
+		contract.IgnoreClose(r.tmp)
+		contract.IgnoreError(os.Remove(r.tmp.Name()))
+		r.tmp = nil
+	}
+}
+
+// bspatch applies a patch in the BSDIFF40 format produced by bsdiff to old, returning the new file, which must be no
+// larger than maxLen bytes.
+func bspatch(old, patch []byte, maxLen int64) ([]byte, error) {
+	const headerLen = 32
+	if len(patch) < headerLen || string(patch[:8]) != "BSDIFF40" {
+		return nil, errors.New("not a bsdiff patch")
+	}
+	ctrlLen, diffLen, newLen := offtin(patch[8:16]), offtin(patch[16:24]), offtin(patch[24:32])
+	if ctrlLen < 0 || diffLen < 0 || newLen < 0 || ctrlLen > int64(len(patch))-headerLen ||
+		diffLen > int64(len(patch))-headerLen-ctrlLen {
+		return nil, errors.New("corrupt bsdiff patch")
+	}
+	if newLen > maxLen {
+		return nil, fmt.Errorf("bsdiff patch produces %d bytes, more than the %d expected", newLen, maxLen)
+	}
+	// Seeks may move outside of the old file, whose bytes are then taken to be 0, but not arbitrarily far.
+	seekLimit := int64(len(old)) + newLen
+
+	ctrl := bzip2.NewReader(bytes.NewReader(patch[headerLen : headerLen+ctrlLen]))
+	diff := bzip2.NewReader(bytes.NewReader(patch[headerLen+ctrlLen : headerLen+ctrlLen+diffLen]))
+	extra := bzip2.NewReader(bytes.NewReader(patch[headerLen+ctrlLen+diffLen:]))
+
+	result := make([]byte, newLen)
+	var oldPos, newPos int64
+	var buf [24]byte
+	for newPos < newLen {
+		if _, err := io.ReadFull(ctrl, buf[:]); err != nil {
+			return nil, fmt.Errorf("corrupt bsdiff patch: %w", err)
+		}
+		add, copyLen, seek := offtin(buf[0:8]), offtin(buf[8:16]), offtin(buf[16:24])
+
+		// Add the diff block to the old bytes.
+		if add < 0 || add > newLen-newPos {
+			return nil, errors.New("corrupt bsdiff patch")
+		}
+		if _, err := io.ReadFull(diff, result[newPos:newPos+add]); err != nil {
+			return nil, fmt.Errorf("corrupt bsdiff patch: %w", err)
+		}
+		for i := int64(0); i < add; i++ {
+			if oldPos+i >= 0 && oldPos+i < int64(len(old)) {
+				result[newPos+i] += old[oldPos+i]
+			}
+		}
+		newPos, oldPos = newPos+add, oldPos+add
+
+		// Copy the extra block verbatim.
+		if copyLen < 0 || copyLen > newLen-newPos || seek > seekLimit || seek < -seekLimit {
+			return nil, errors.New("corrupt bsdiff patch")
+		}
+		if _, err := io.ReadFull(extra, result[newPos:newPos+copyLen]); err != nil {
+			return nil, fmt.Errorf("corrupt bsdiff patch: %w", err)
+		}
+		newPos, oldPos = newPos+copyLen, oldPos+seek
+		if oldPos > seekLimit || oldPos < -seekLimit {
+			return nil, errors.New("corrupt bsdiff patch")
+		}
+	}
+	return result, nil
+}
+
+// offtin decodes the sign-magnitude little-endian integers used by bsdiff.
+func offtin(b []byte) int64 {
+	var y int64
+	for i := 7; i >= 0; i-- {
+		v := b[i]
+		if i == 7 {
+			v &= 0x7f
+		}
+		y = y<<8 | int64(v)
+	}
+	if b[7]&0x80 != 0 {
+		y = -y
+	}
+	return y
+}
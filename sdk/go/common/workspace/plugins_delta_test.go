@@ -0,0 +1,166 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	deltaOldTarball = "pulumi plugin tarball v1.0.0 with some shared bytes"
+	deltaNewTarball = "pulumi plugin tarball v1.1.0 with some shared bytes and more"
+	// deltaPatch is a bsdiff patch from deltaOldTarball to deltaNewTarball.
+	deltaPatch = "QlNESUZGNDArAAAAAAAAACoAAAAAAAAAPAAAAAAAAABCWmg5MUFZJlNZLi2ukwAABcgASCgIACAAIYaBmgxWybi7kinChIFxbX" +
+		"SYQlpoOTFBWSZTWam5614AAADgAGAAAgAgADDMCTI0ZTi7kinChIVNz1rwQlpoOTFBWSZTWe5oxroAAAARgEAAJgOQACAAMQwIIYJokZT" +
+		"Ovi7kinChIdzRjXQ="
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestBSPatch(t *testing.T) {
+	t.Parallel()
+
+	patch, err := base64.StdEncoding.DecodeString(deltaPatch)
+	require.NoError(t, err)
+
+	result, err := bspatch([]byte(deltaOldTarball), patch, int64(len(deltaNewTarball)))
+	require.NoError(t, err)
+	assert.Equal(t, deltaNewTarball, string(result))
+
+	_, err = bspatch([]byte(deltaOldTarball), []byte("not a patch"), 1<<20)
+	assert.EqualError(t, err, "not a bsdiff patch")
+
+	_, err = bspatch([]byte(deltaOldTarball), patch[:40], 1<<20)
+	assert.Error(t, err)
+
+	// Patches can't make more than they're expected to.
+	_, err = bspatch([]byte(deltaOldTarball), patch, int64(len(deltaNewTarball))-1)
+	assert.EqualError(t, err, fmt.Sprintf("bsdiff patch produces %d bytes, more than the %d expected",
+		len(deltaNewTarball), len(deltaNewTarball)-1))
+
+	// Lengths that would overflow are rejected rather than panicking.
+	header := func(ctrlLen, diffLen, newLen uint64) []byte {
+		b := make([]byte, 32)
+		copy(b, "BSDIFF40")
+		binary.LittleEndian.PutUint64(b[8:], ctrlLen)
+		binary.LittleEndian.PutUint64(b[16:], diffLen)
+		binary.LittleEndian.PutUint64(b[24:], newLen)
+		return b
+	}
+	_, err = bspatch(nil, header(math.MaxInt64, math.MaxInt64, 0), 1<<20)
+	assert.EqualError(t, err, "corrupt bsdiff patch")
+	_, err = bspatch(nil, header(0, 0, math.MaxInt64), 1<<20)
+	assert.Error(t, err)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestDownloadPlatformDeltaUpdate(t *testing.T) {
+	t.Setenv(PluginDeltaUpdatesEnvVar, "true")
+
+	index := fmt.Sprintf(`{"versions": [
+		{"version": "1.0.0", "assets": {"linux-amd64": {"url": "/v1.0.0.tgz", "sha256": "%s"}}},
+		{"version": "1.1.0", "assets": {"linux-amd64": {"url": "/v1.1.0.tgz", "sha256": "%s",
+			"patches": [{"from": "1.0.0", "url": "/v1.0.0-v1.1.0.bsdiff"}]}}}
+	]}`, sha256Hex(deltaOldTarball), sha256Hex(deltaNewTarball))
+	patch, err := base64.StdEncoding.DecodeString(deltaPatch)
+	require.NoError(t, err)
+
+	var requested []string
+	serve := serveURLs(map[string]string{
+		"https://index.example.com/resource/mock.json":   index,
+		"https://index.example.com/v1.0.0.tgz":           deltaOldTarball,
+		"https://index.example.com/v1.1.0.tgz":           deltaNewTarball,
+		"https://index.example.com/v1.0.0-v1.1.0.bsdiff": string(patch),
+	})
+	getHTTPResponse := func(req *http.Request) (io.ReadCloser, int64, error) {
+		requested = append(requested, req.URL.Path)
+		return serve(req)
+	}
+
+	info := PluginInfo{Name: "mock", Kind: ResourcePlugin, PluginDir: t.TempDir()}
+	source := newPluginIndexSource([]string{"https://index.example.com"}, "mock", ResourcePlugin, &nextSource{})
+	platform := Platform{OS: "linux", Arch: "amd64"}
+	download := func(version string) string {
+		requested = nil
+		r, _, err := downloadPlatform(info, source, semver.MustParse(version), platform, getHTTPResponse)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		return string(b)
+	}
+	kept := func() []string {
+		matches, err := filepath.Glob(filepath.Join(info.PluginDir, pluginArchivesDir, "*"))
+		require.NoError(t, err)
+		for i := range matches {
+			matches[i] = filepath.Base(matches[i])
+		}
+		return matches
+	}
+
+	// Without a kept tarball, the full plugin is downloaded, and kept.
+	assert.Equal(t, deltaOldTarball, download("1.0.0"))
+	assert.Equal(t, []string{"/resource/mock.json", "/v1.0.0.tgz"}, requested)
+	assert.Equal(t, []string{"pulumi-resource-mock-v1.0.0-linux-amd64.tar.gz"}, kept())
+
+	// Upgrading only downloads the patch, and the new tarball replaces the old one.
+	assert.Equal(t, deltaNewTarball, download("1.1.0"))
+	assert.Equal(t, []string{"/resource/mock.json", "/v1.0.0-v1.1.0.bsdiff"}, requested)
+	assert.Equal(t, []string{"pulumi-resource-mock-v1.1.0-linux-amd64.tar.gz"}, kept())
+
+	// A patch that doesn't produce the published tarball is discarded in favor of the full download.
+	info.PluginDir = t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(info.PluginDir, pluginArchivesDir), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(info.PluginDir, pluginArchivesDir,
+		"pulumi-resource-mock-v1.0.0-linux-amd64.tar.gz"), []byte("tampered"), 0600))
+	assert.Equal(t, deltaNewTarball, download("1.1.0"))
+	assert.Equal(t, []string{"/resource/mock.json", "/v1.0.0-v1.1.0.bsdiff", "/resource/mock.json", "/v1.1.0.tgz"},
+		requested)
+	assert.Equal(t, []string{"pulumi-resource-mock-v1.1.0-linux-amd64.tar.gz"}, kept())
+}
+
+func TestPluginArchivesPath(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	info := PluginInfo{Name: "mock", Kind: ResourcePlugin, ctx: &Context{PluginDir: dir}}
+	path, err := pluginArchivesPath(info)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, pluginArchivesDir), path)
+
+	// The plugin's own directory takes precedence over its context's.
+	info.PluginDir = t.TempDir()
+	path, err = pluginArchivesPath(info)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(info.PluginDir, pluginArchivesDir), path)
+}
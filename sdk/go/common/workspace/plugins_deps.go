@@ -0,0 +1,470 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/nodejs/npm"
+	"github.com/pulumi/pulumi/sdk/v3/python"
+)
+
+const (
+	// PluginPipIndexURLEnvVar sets the package index pip uses when installing plugin dependencies.
+	PluginPipIndexURLEnvVar = "PULUMI_PLUGIN_PIP_INDEX_URL"
+	// PluginPipExtraIndexURLsEnvVar is a comma-separated list of additional package indexes pip uses when installing
+	// plugin dependencies.
+	PluginPipExtraIndexURLsEnvVar = "PULUMI_PLUGIN_PIP_EXTRA_INDEX_URLS"
+	// PluginPipTrustedHostsEnvVar is a comma-separated list of hosts pip trusts even without valid HTTPS when
+	// installing plugin dependencies.
+	PluginPipTrustedHostsEnvVar = "PULUMI_PLUGIN_PIP_TRUSTED_HOSTS"
+	// PluginPipCertEnvVar is the path to a CA bundle pip uses when installing plugin dependencies.
+	PluginPipCertEnvVar = "PULUMI_PLUGIN_PIP_CERT"
+	// PluginNodePathEnvVar is the path to the `node` executable used to install Node.js plugin dependencies, which is
+	// checked against the Node.js version a plugin requires, if any. If unset, `node` is looked up on the $PATH.
+	PluginNodePathEnvVar = "PULUMI_PLUGIN_NODE_PATH"
+)
+
+// vendoredWheelsDir is the directory in a Python plugin's tarball that holds the wheels of all of its dependencies.
+const vendoredWheelsDir = "wheels"
+
+// NodeResolver returns the path to a `node` executable whose version satisfies required, the range given by a Node.js
+// plugin's `nodeversion` runtime option in the syntax of semver.ParseRange, or an error explaining why there is none.
+// It is used before installing the plugin's dependencies, and may be replaced to select Node.js installations from a
+// version manager.
+var NodeResolver = resolveNode
+
+// installPluginDependencies installs the dependencies of a plugin that has been extracted into dir, as described by
+// its PulumiPlugin.yaml.
+//
+// For now, we only do this for Node.js and Python. For Go, the expectation is the binary is already built. For .NET,
+// similarly, a single self-contained binary could be used, but otherwise `dotnet run` will implicitly run
+// `dotnet restore`.
+// TODO[pulumi/pulumi#1334]: move to the language plugins so we don't have to hard code here.
+//
+// If progress is non-nil, the package manager's output is reported to it as the install runs. Otherwise, the output
+// is written to stderr only if the install fails. Installs that fail for transient reasons, such as network errors,
+// are retried.
+func installPluginDependencies(info PluginInfo, proj *PluginProject, dir string, progress PluginInstallProgress) error {
+	var out io.Writer
+	if progress != nil {
+		lines := newProgressLineWriter(info, progress)
+		defer lines.Flush()
+		out = lines
+	}
+
+	env, err := pluginEnvironment(info.Kind, info.Name, proj.Env)
+	if err != nil {
+		return err
+	}
+
+	var install func(w io.Writer) error
+	switch strings.ToLower(proj.Runtime.Name()) {
+	case "nodejs":
+		install = func(w io.Writer) error {
+			return installNodeJSPluginDependencies(proj, dir, env, w)
+		}
+	case "python":
+		install = func(w io.Writer) error {
+			return installPythonPluginDependencies(info, dir, env, w, out != nil)
+		}
+	default:
+		return nil
+	}
+	return retryDependencyInstall(install, out)
+}
+
+// installPythonPluginDependencies installs the dependencies of a Python plugin into a `venv` virtual environment.
+// Plugins that ship a `wheels` directory are installed from it alone, without contacting a package index. Otherwise,
+// plugins managed by poetry are installed with poetry. If the plugin's requirements.txt pins its requirements with
+// hashes, pip is made to verify all of them. The install runs with the additional environment variables in env. Output
+// is written to w: all of it if showOutput is true, and otherwise only that of failed commands.
+func installPythonPluginDependencies(info PluginInfo, dir string, env []string, w io.Writer, showOutput bool) error {
+	opts := python.InstallDependenciesOptions{
+		ShowOutput:  showOutput,
+		InfoWriter:  w,
+		ErrorWriter: w,
+		Pip:         pluginPipOptions(),
+		Env:         env,
+	}
+
+	wheels := filepath.Join(dir, vendoredWheelsDir)
+	vendored := isDir(wheels)
+	if vendored {
+		logf(5, nil, "installing Python plugin dependencies in %s from vendored wheels", dir)
+		opts.Pip.NoIndex = true
+		opts.Pip.FindLinks = []string{wheels}
+	} else if python.IsPoetryProject(dir) {
+		opts.Poetry = true
+		return installPythonVirtualEnv(info, dir, opts, true)
+	}
+
+	requireHashes, err := requirementsHaveHashes(filepath.Join(dir, "requirements.txt"))
+	if err != nil {
+		return err
+	}
+	opts.RequireHashes = requireHashes
+	return installPythonVirtualEnv(info, dir, opts, !vendored)
+}
+
+// pluginPipOptions returns the pip index and TLS settings configured for plugin dependency installs.
+func pluginPipOptions() python.PipOptions {
+	return python.PipOptions{
+		IndexURL:       os.Getenv(PluginPipIndexURLEnvVar),
+		ExtraIndexURLs: splitEnvList(os.Getenv(PluginPipExtraIndexURLsEnvVar)),
+		TrustedHosts:   splitEnvList(os.Getenv(PluginPipTrustedHostsEnvVar)),
+		Cert:           os.Getenv(PluginPipCertEnvVar),
+	}
+}
+
+// splitEnvList splits a comma-separated environment variable value, ignoring empty entries.
+func splitEnvList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// isDir returns true if path exists and is a directory.
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// requirementsHaveHashes returns true if the given requirements file pins any requirement with `--hash`. pip's hash
+// checking mode is all-or-nothing, so a single hash is enough to make the author's intent clear.
+func requirementsHaveHashes(path string) (bool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.Contains(line, "--hash=") || strings.Contains(line, "--hash ") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// installNodeJSPluginDependencies installs the dependencies of a Node.js plugin, using the package manager set by the
+// `packagemanager` runtime option or, if that isn't set, the one matching the lockfile shipped with the plugin. The
+// install runs with the additional environment variables in env, and its output is written to w.
+func installNodeJSPluginDependencies(proj *PluginProject, dir string, env []string, w io.Writer) error {
+	pm, err := nodeJSPackageManager(proj, dir)
+	if err != nil {
+		return err
+	}
+
+	// Pick the Node.js the plugin asks for up front; failing halfway through an install is much harder to diagnose.
+	var nodePath string
+	required, err := nodeJSVersionRange(proj)
+	if err != nil {
+		return err
+	}
+	if required != "" {
+		if nodePath, err = NodeResolver(required); err != nil {
+			return err
+		}
+	} else {
+		nodePath = os.Getenv(PluginNodePathEnvVar)
+	}
+
+	// Plugins that ship their node_modules are ready to run as they are, even without network access.
+	if isDir(filepath.Join(dir, "node_modules")) {
+		logf(5, nil, "skipping Node.js plugin dependency install in %s: node_modules is vendored", dir)
+		return nil
+	}
+
+	// When the plugin ships a lockfile, install exactly what it specifies, so the code executed on deploy matches
+	// what the author tested.
+	opts := npm.InstallOptions{
+		PackageManager: pm,
+		Production:     true,
+		Frozen:         npm.HasLockfile(dir, pm),
+		NodePath:       nodePath,
+		Env:            env,
+	}
+
+	_, err = npm.InstallWithOptions(dir, opts, w, w)
+	return err
+}
+
+// nodeJSPackageManager returns the package manager to use for the Node.js plugin extracted into dir.
+func nodeJSPackageManager(proj *PluginProject, dir string) (npm.PackageManager, error) {
+	var preferred npm.PackageManager
+	if option, ok := proj.Runtime.Options()["packagemanager"]; ok {
+		name, ok := option.(string)
+		if !ok {
+			return "", fmt.Errorf("runtime option 'packagemanager' must be a string; got %v", option)
+		}
+		pm, err := npm.ParsePackageManager(name)
+		if err != nil {
+			return "", err
+		}
+		preferred = pm
+	}
+	return npm.ResolvePackageManager(dir, preferred), nil
+}
+
+// nodeJSVersionRange returns the Node.js version range set by the `nodeversion` runtime option, or "" if there is none.
+// The option is an npm-style range, such as `>=18` or `^18.2`, and is returned in the syntax of semver.ParseRange.
+func nodeJSVersionRange(proj *PluginProject) (string, error) {
+	option, ok := proj.Runtime.Options()["nodeversion"]
+	if !ok {
+		return "", nil
+	}
+	required, ok := option.(string)
+	if !ok {
+		return "", fmt.Errorf("runtime option 'nodeversion' must be a string; got %v", option)
+	}
+	normalized, err := normalizeNodeVersionRange(required)
+	if err != nil {
+		return "", fmt.Errorf("runtime option 'nodeversion' is not a valid version range: %w", err)
+	}
+	if _, err := semver.ParseRange(normalized); err != nil {
+		return "", fmt.Errorf("runtime option 'nodeversion' is not a valid version range: %w", err)
+	}
+	return normalized, nil
+}
+
+// normalizeNodeVersionRange rewrites an npm-style version range into the syntax of semver.ParseRange, which needs
+// every version in full and doesn't know the `^`, `~`, `x` and hyphen forms. Ranges already in that syntax are
+// returned as they are, apart from whitespace.
+func normalizeNodeVersionRange(r string) (string, error) {
+	var sets []string
+	for _, set := range strings.Split(r, "||") {
+		fields := strings.Fields(set)
+		var comparators []string
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			// Hyphen ranges, such as `16 - 18`, include both ends.
+			if i+2 < len(fields) && fields[i+1] == "-" {
+				lower, err := parsePartialVersion(field)
+				if err != nil {
+					return "", err
+				}
+				upper, err := parsePartialVersion(fields[i+2])
+				if err != nil {
+					return "", err
+				}
+				comparators = append(comparators, ">="+lower.String())
+				if upper.parts == 3 {
+					comparators = append(comparators, "<="+upper.String())
+				} else if upper.parts > 0 {
+					comparators = append(comparators, "<"+upper.next().String())
+				}
+				i += 2
+				continue
+			}
+
+			// npm allows space between an operator and its version, as in `>= 18`.
+			if nodeVersionOperator(field) == field && i+1 < len(fields) {
+				i++
+				field += fields[i]
+			}
+			expanded, err := expandNodeVersionComparator(field)
+			if err != nil {
+				return "", err
+			}
+			comparators = append(comparators, expanded...)
+		}
+		if len(comparators) == 0 {
+			comparators = []string{">=0.0.0"}
+		}
+		sets = append(sets, strings.Join(comparators, " "))
+	}
+	return strings.Join(sets, " || "), nil
+}
+
+// expandNodeVersionComparator rewrites a single npm-style comparator, such as `^18` or `<=18.2`, into comparators
+// over full versions.
+func expandNodeVersionComparator(comparator string) ([]string, error) {
+	op := nodeVersionOperator(comparator)
+	v, err := parsePartialVersion(strings.TrimPrefix(comparator, op))
+	if err != nil {
+		return nil, err
+	}
+
+	// `*`, `x` and the like match any version, whatever the operator.
+	if v.parts == 0 {
+		if op == "<" || op == ">" {
+			return []string{"<0.0.0"}, nil
+		}
+		return []string{">=0.0.0"}, nil
+	}
+
+	switch op {
+	case ">=":
+		return []string{">=" + v.String()}, nil
+	case "<":
+		return []string{"<" + v.String()}, nil
+	case ">":
+		if v.parts == 3 {
+			return []string{">" + v.String()}, nil
+		}
+		return []string{">=" + v.next().String()}, nil
+	case "<=":
+		if v.parts == 3 {
+			return []string{"<=" + v.String()}, nil
+		}
+		return []string{"<" + v.next().String()}, nil
+	case "~":
+		upper := v
+		if upper.parts == 3 {
+			upper.parts = 2
+		}
+		return []string{">=" + v.String(), "<" + upper.next().String()}, nil
+	case "^":
+		// The upper bound is the next version that changes the leftmost part that isn't zero.
+		upper := v
+		switch {
+		case v.major > 0 || v.parts == 1:
+			upper.parts = 1
+		case v.minor > 0 || v.parts == 2:
+			upper.parts = 2
+		}
+		return []string{">=" + v.String(), "<" + upper.next().String()}, nil
+	default:
+		if v.parts == 3 {
+			return []string{"=" + v.String()}, nil
+		}
+		return []string{">=" + v.String(), "<" + v.next().String()}, nil
+	}
+}
+
+// nodeVersionOperator returns the operator comparator starts with, or "" if it has none.
+func nodeVersionOperator(comparator string) string {
+	for _, op := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(comparator, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// partialVersion is a version that may leave out its trailing parts, such as `18` or `18.2.x`.
+type partialVersion struct {
+	major, minor, patch uint64
+	// parts is how many of major, minor and patch are given.
+	parts int
+	// suffix is the prerelease and build metadata of a full version, including its leading `-` or `+`.
+	suffix string
+}
+
+// parsePartialVersion parses a version whose trailing parts may be left out or be wildcards.
+func parsePartialVersion(s string) (partialVersion, error) {
+	var v partialVersion
+	text := strings.TrimPrefix(strings.TrimPrefix(s, "="), "v")
+	if i := strings.IndexAny(text, "-+"); i >= 0 {
+		text, v.suffix = text[:i], text[i:]
+	}
+	parts := strings.Split(text, ".")
+	if len(parts) > 3 {
+		return partialVersion{}, fmt.Errorf("invalid version %q", s)
+	}
+	numbers := []*uint64{&v.major, &v.minor, &v.patch}
+	for i, part := range parts {
+		if part == "x" || part == "X" || part == "*" {
+			break
+		}
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return partialVersion{}, fmt.Errorf("invalid version %q", s)
+		}
+		*numbers[i] = n
+		v.parts++
+	}
+	if v.suffix != "" && v.parts != 3 {
+		return partialVersion{}, fmt.Errorf("invalid version %q: only full versions may have a prerelease", s)
+	}
+	return v, nil
+}
+
+// String returns the version in full, with the parts that were left out as zero.
+func (v partialVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d%s", v.major, v.minor, v.patch, v.suffix)
+}
+
+// next returns the smallest full version that is greater than every version v matches.
+func (v partialVersion) next() partialVersion {
+	switch v.parts {
+	case 1:
+		return partialVersion{major: v.major + 1, parts: 3}
+	case 2:
+		return partialVersion{major: v.major, minor: v.minor + 1, parts: 3}
+	default:
+		return partialVersion{major: v.major, minor: v.minor, patch: v.patch + 1, parts: 3}
+	}
+}
+
+// resolveNode is the default NodeResolver. It checks the `node` executable named by `PULUMI_PLUGIN_NODE_PATH`, or the
+// one on the $PATH.
+func resolveNode(required string) (string, error) {
+	satisfies, err := semver.ParseRange(required)
+	if err != nil {
+		return "", err
+	}
+
+	nodePath := os.Getenv(PluginNodePathEnvVar)
+	if nodePath == "" {
+		if nodePath, err = exec.LookPath("node"); err != nil {
+			return "", fmt.Errorf("plugin requires Node.js %s, but node could not be found on $PATH; "+
+				"install it or set %s", required, PluginNodePathEnvVar)
+		}
+	}
+
+	version, err := nodeVersion(nodePath)
+	if err != nil {
+		return "", err
+	}
+	if !satisfies(version) {
+		return "", fmt.Errorf("plugin requires Node.js %s, but %s is version %s; "+
+			"install a matching version or set %s", required, nodePath, version, PluginNodePathEnvVar)
+	}
+	return nodePath, nil
+}
+
+// nodeVersion returns the version reported by `node --version`.
+func nodeVersion(nodePath string) (semver.Version, error) {
+	out, err := exec.Command(nodePath, "--version").Output()
+	if err != nil {
+		return semver.Version{}, fmt.Errorf("running %s --version: %w", nodePath, err)
+	}
+	version, err := semver.ParseTolerant(strings.TrimSpace(string(out)))
+	if err != nil {
+		return semver.Version{}, fmt.Errorf("parsing version of %s: %w", nodePath, err)
+	}
+	return version, nil
+}
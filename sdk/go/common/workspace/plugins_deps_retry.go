@@ -0,0 +1,166 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DependencyInstallCategory classifies why a plugin's package manager failed to install its dependencies.
+type DependencyInstallCategory string
+
+const (
+	// DependencyInstallTransient failures, such as package registry server errors and network resets, may succeed if
+	// the install is retried.
+	DependencyInstallTransient DependencyInstallCategory = "transient"
+	// DependencyInstallDeterministic failures, such as a missing compiler or an incompatible engine, will fail again
+	// until something about the plugin or the machine changes.
+	DependencyInstallDeterministic DependencyInstallCategory = "deterministic"
+	// DependencyInstallUnknown failures could not be classified.
+	DependencyInstallUnknown DependencyInstallCategory = "unknown"
+)
+
+// DependencyInstallError is returned when a plugin's package manager fails to install its dependencies.
+type DependencyInstallError struct {
+	// Category classifies the failure.
+	Category DependencyInstallCategory
+	// Reason describes the cause of the failure, if it could be determined.
+	Reason string
+	// Attempts is the number of times the install was attempted.
+	Attempts int
+	// Err is the error returned by the package manager.
+	Err error
+}
+
+func (err *DependencyInstallError) Error() string {
+	if err.Reason == "" {
+		return err.Err.Error()
+	}
+	if err.Attempts > 1 {
+		return fmt.Sprintf("%s failure after %d attempts: %s: %v", err.Category, err.Attempts, err.Reason, err.Err)
+	}
+	return fmt.Sprintf("%s failure: %s: %v", err.Category, err.Reason, err.Err)
+}
+
+func (err *DependencyInstallError) Unwrap() error {
+	return err.Err
+}
+
+// dependencyInstallFailure maps output written by a package manager when it failed to the category and reason of
+// the failure.
+type dependencyInstallFailure struct {
+	patterns []string
+	category DependencyInstallCategory
+	reason   string
+}
+
+// dependencyInstallFailures are checked in order, so deterministic failures come first: a native module that fails
+// to build may also print network-looking noise, but retrying won't help it.
+var dependencyInstallFailures = []dependencyInstallFailure{
+	{
+		patterns: []string{"gyp ERR!", "error: command 'gcc' failed", "error: Microsoft Visual C++",
+			"Failed building wheel", "unable to execute 'gcc'"},
+		category: DependencyInstallDeterministic,
+		reason:   "a native dependency failed to compile; make sure a compiler toolchain is installed",
+	},
+	{
+		patterns: []string{"EBADENGINE", "Unsupported engine", "requires a different Python", "Requires-Python"},
+		category: DependencyInstallDeterministic,
+		reason:   "a dependency is incompatible with the installed Node.js or Python version",
+	},
+	{
+		patterns: []string{"No matching distribution found", "ETARGET", "E404", "ERESOLVE", "ResolutionImpossible"},
+		category: DependencyInstallDeterministic,
+		reason:   "a dependency could not be resolved",
+	},
+	{
+		patterns: []string{"E500", "E502", "E503", "E504", "500 Internal Server Error", "502 Bad Gateway",
+			"503 Service Unavailable", "504 Gateway Time-out", "504 Gateway Timeout"},
+		category: DependencyInstallTransient,
+		reason:   "the package registry returned a server error",
+	},
+	{
+		patterns: []string{"ECONNRESET", "ETIMEDOUT", "EAI_AGAIN", "socket hang up", "Connection reset by peer",
+			"Read timed out", "Temporary failure in name resolution", "Connection aborted", "IncompleteRead"},
+		category: DependencyInstallTransient,
+		reason:   "a network error occurred",
+	},
+}
+
+// classifyDependencyInstallFailure returns the category and reason of a failed install, given its output.
+func classifyDependencyInstallFailure(output string) (DependencyInstallCategory, string) {
+	for _, failure := range dependencyInstallFailures {
+		for _, pattern := range failure.patterns {
+			if strings.Contains(output, pattern) {
+				return failure.category, failure.reason
+			}
+		}
+	}
+	return DependencyInstallUnknown, ""
+}
+
+// maxDependencyInstallAttempts is the number of times an install that fails transiently is attempted.
+const maxDependencyInstallAttempts = 3
+
+// dependencyInstallRetryDelay is the delay before the first retry of a transiently failed install. It doubles with
+// each retry.
+var dependencyInstallRetryDelay = 2 * time.Second
+
+// retryDependencyInstall runs install, retrying it with backoff if the package manager fails transiently. Failures of
+// the package manager are returned as a DependencyInstallError. Output is written to out if it is non-nil, and
+// otherwise the output of the final attempt is written to stderr if it fails.
+func retryDependencyInstall(install func(w io.Writer) error, out io.Writer) error {
+	delay := dependencyInstallRetryDelay
+	for attempt := 1; ; attempt++ {
+		var b bytes.Buffer
+		var w io.Writer = &b
+		if out != nil {
+			w = io.MultiWriter(&b, out)
+		}
+
+		err := install(w)
+		if err == nil {
+			return nil
+		}
+
+		// Only failures of the package manager itself can be classified; anything else is returned as it is.
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			if out == nil {
+				os.Stderr.Write(b.Bytes())
+			}
+			return err
+		}
+
+		category, reason := classifyDependencyInstallFailure(b.String())
+		if category != DependencyInstallTransient || attempt == maxDependencyInstallAttempts {
+			if out == nil {
+				os.Stderr.Write(b.Bytes())
+			}
+			return &DependencyInstallError{Category: category, Reason: reason, Attempts: attempt, Err: err}
+		}
+
+		logf(3, nil, "installing plugin dependencies failed (%s), retrying in %v", reason, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyDependencyInstallFailure(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		output   string
+		expected DependencyInstallCategory
+	}{
+		{"npm ERR! code ECONNRESET\nnpm ERR! network aborted", DependencyInstallTransient},
+		{"npm ERR! code E503\nnpm ERR! 503 Service Unavailable", DependencyInstallTransient},
+		{"ReadTimeoutError: Read timed out. (read timeout=15)", DependencyInstallTransient},
+		{"gyp ERR! stack Error: not found: make", DependencyInstallDeterministic},
+		{"npm WARN EBADENGINE Unsupported engine {", DependencyInstallDeterministic},
+		{"ERROR: No matching distribution found for pulumi==99.0.0", DependencyInstallDeterministic},
+		// Build failures often print network-looking noise too, but are never retried.
+		{"gyp ERR! ...\nnpm ERR! code ECONNRESET", DependencyInstallDeterministic},
+		{"something else went wrong", DependencyInstallUnknown},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.output, func(t *testing.T) {
+			t.Parallel()
+
+			category, _ := classifyDependencyInstallFailure(tt.output)
+			assert.Equal(t, tt.expected, category)
+		})
+	}
+}
+
+// exitError returns an *exec.ExitError from a command that exits with a non-zero status.
+func exitError(t *testing.T) error {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh to produce an exit error")
+	}
+	err := exec.Command("sh", "-c", "exit 1").Run()
+	require.Error(t, err)
+	return err
+}
+
+//nolint:paralleltest // mutates dependencyInstallRetryDelay
+func TestRetryDependencyInstall(t *testing.T) {
+	delay := dependencyInstallRetryDelay
+	dependencyInstallRetryDelay = 0
+	t.Cleanup(func() { dependencyInstallRetryDelay = delay })
+
+	exitErr := exitError(t)
+
+	t.Run("transient then success", func(t *testing.T) {
+		attempts := 0
+		var out bytes.Buffer
+		err := retryDependencyInstall(func(w io.Writer) error {
+			attempts++
+			if attempts == 1 {
+				fmt.Fprintln(w, "npm ERR! code ECONNRESET")
+				return exitErr
+			}
+			return nil
+		}, &out)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, attempts)
+		assert.Equal(t, "npm ERR! code ECONNRESET\n", out.String())
+	})
+
+	t.Run("transient gives up", func(t *testing.T) {
+		attempts := 0
+		err := retryDependencyInstall(func(w io.Writer) error {
+			attempts++
+			fmt.Fprintln(w, "npm ERR! code ETIMEDOUT")
+			return exitErr
+		}, ioutil.Discard)
+		assert.Equal(t, maxDependencyInstallAttempts, attempts)
+
+		var installErr *DependencyInstallError
+		require.True(t, errors.As(err, &installErr))
+		assert.Equal(t, DependencyInstallTransient, installErr.Category)
+		assert.Equal(t, maxDependencyInstallAttempts, installErr.Attempts)
+		assert.True(t, errors.Is(err, exitErr))
+	})
+
+	t.Run("deterministic", func(t *testing.T) {
+		attempts := 0
+		err := retryDependencyInstall(func(w io.Writer) error {
+			attempts++
+			fmt.Fprintln(w, "gyp ERR! stack Error: not found: make")
+			return exitErr
+		}, ioutil.Discard)
+		assert.Equal(t, 1, attempts)
+
+		var installErr *DependencyInstallError
+		require.True(t, errors.As(err, &installErr))
+		assert.Equal(t, DependencyInstallDeterministic, installErr.Category)
+	})
+
+	t.Run("not a package manager failure", func(t *testing.T) {
+		cause := errors.New("plugin requires Node.js >=18.0.0")
+		err := retryDependencyInstall(func(w io.Writer) error {
+			return cause
+		}, ioutil.Discard)
+		assert.Equal(t, cause, err)
+	})
+}
@@ -0,0 +1,218 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/sdk/v3/nodejs/npm"
+	"github.com/pulumi/pulumi/sdk/v3/python"
+)
+
+func TestNodeJSPackageManager(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "pnpm-lock.yaml"), nil, 0600))
+
+	proj := &PluginProject{Runtime: NewProjectRuntimeInfo("nodejs", nil)}
+	pm, err := nodeJSPackageManager(proj, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, npm.PnpmPackageManager, pm)
+
+	proj = &PluginProject{Runtime: NewProjectRuntimeInfo("nodejs", map[string]interface{}{
+		"packagemanager": "yarn",
+	})}
+	pm, err = nodeJSPackageManager(proj, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, npm.YarnPackageManager, pm)
+
+	proj = &PluginProject{Runtime: NewProjectRuntimeInfo("nodejs", map[string]interface{}{
+		"packagemanager": true,
+	})}
+	_, err = nodeJSPackageManager(proj, dir)
+	assert.EqualError(t, err, "runtime option 'packagemanager' must be a string; got true")
+}
+
+func TestRequirementsHaveHashes(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "requirements.txt")
+
+	has, err := requirementsHaveHashes(path)
+	assert.NoError(t, err)
+	assert.False(t, has)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("# pip install --hash=sha256:abc\npulumi==3.0.0\n"), 0600))
+	has, err = requirementsHaveHashes(path)
+	assert.NoError(t, err)
+	assert.False(t, has)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("pulumi==3.0.0 \\\n    --hash=sha256:abc\n"), 0600))
+	has, err = requirementsHaveHashes(path)
+	assert.NoError(t, err)
+	assert.True(t, has)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestPluginPipOptions(t *testing.T) {
+	t.Setenv(PluginPipIndexURLEnvVar, "https://pypi.corp/simple")
+	t.Setenv(PluginPipExtraIndexURLsEnvVar, "https://a.corp/simple, https://b.corp/simple,")
+	t.Setenv(PluginPipTrustedHostsEnvVar, "a.corp")
+	t.Setenv(PluginPipCertEnvVar, "")
+
+	assert.Equal(t, python.PipOptions{
+		IndexURL:       "https://pypi.corp/simple",
+		ExtraIndexURLs: []string{"https://a.corp/simple", "https://b.corp/simple"},
+		TrustedHosts:   []string{"a.corp"},
+	}, pluginPipOptions())
+}
+
+func TestNodeJSVersionRange(t *testing.T) {
+	t.Parallel()
+
+	required, err := nodeJSVersionRange(&PluginProject{Runtime: NewProjectRuntimeInfo("nodejs", nil)})
+	assert.NoError(t, err)
+	assert.Equal(t, "", required)
+
+	required, err = nodeJSVersionRange(&PluginProject{Runtime: NewProjectRuntimeInfo("nodejs", map[string]interface{}{
+		"nodeversion": ">=14.0.0 <19.0.0",
+	})})
+	assert.NoError(t, err)
+	assert.Equal(t, ">=14.0.0 <19.0.0", required)
+
+	// npm-style ranges are rewritten into full versions.
+	required, err = nodeJSVersionRange(&PluginProject{Runtime: NewProjectRuntimeInfo("nodejs", map[string]interface{}{
+		"nodeversion": ">=18",
+	})})
+	assert.NoError(t, err)
+	assert.Equal(t, ">=18.0.0", required)
+
+	required, err = nodeJSVersionRange(&PluginProject{Runtime: NewProjectRuntimeInfo("nodejs", map[string]interface{}{
+		"nodeversion": "^18",
+	})})
+	assert.NoError(t, err)
+	assert.Equal(t, ">=18.0.0 <19.0.0", required)
+
+	_, err = nodeJSVersionRange(&PluginProject{Runtime: NewProjectRuntimeInfo("nodejs", map[string]interface{}{
+		"nodeversion": "fourteen",
+	})})
+	assert.Error(t, err)
+
+	_, err = nodeJSVersionRange(&PluginProject{Runtime: NewProjectRuntimeInfo("nodejs", map[string]interface{}{
+		"nodeversion": 14,
+	})})
+	assert.Error(t, err)
+}
+
+func TestNormalizeNodeVersionRange(t *testing.T) {
+	t.Parallel()
+
+	for r, expected := range map[string]string{
+		">=14.0.0 <19.0.0":   ">=14.0.0 <19.0.0",
+		">=18":               ">=18.0.0",
+		">= 18.2":            ">=18.2.0",
+		">18":                ">=19.0.0",
+		"<=18.2":             "<18.3.0",
+		"<18":                "<18.0.0",
+		"18":                 ">=18.0.0 <19.0.0",
+		"18.x":               ">=18.0.0 <19.0.0",
+		"v18.2.1":            "=18.2.1",
+		"*":                  ">=0.0.0",
+		"":                   ">=0.0.0",
+		"^18":                ">=18.0.0 <19.0.0",
+		"^18.2.1":            ">=18.2.1 <19.0.0",
+		"^0.2.1":             ">=0.2.1 <0.3.0",
+		"^0.0.3":             ">=0.0.3 <0.0.4",
+		"^0.0":               ">=0.0.0 <0.1.0",
+		"~18":                ">=18.0.0 <19.0.0",
+		"~18.2.1":            ">=18.2.1 <18.3.0",
+		"16 - 18":            ">=16.0.0 <19.0.0",
+		"16.1.0 - 18.2.3":    ">=16.1.0 <=18.2.3",
+		"^16.14 || >=18.0.0": ">=16.14.0 <17.0.0 || >=18.0.0",
+	} {
+		actual, err := normalizeNodeVersionRange(r)
+		if assert.NoError(t, err, r) {
+			assert.Equal(t, expected, actual, r)
+		}
+	}
+
+	for _, r := range []string{"fourteen", "^18.2.3.4", "~18-beta"} {
+		_, err := normalizeNodeVersionRange(r)
+		assert.Error(t, err, r)
+	}
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestResolveNode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as a fake node executable")
+	}
+
+	nodePath := filepath.Join(t.TempDir(), "node")
+	require.NoError(t, ioutil.WriteFile(nodePath, []byte("#!/bin/sh\necho v16.3.0\n"), 0700)) //nolint:gosec
+	t.Setenv(PluginNodePathEnvVar, nodePath)
+
+	resolved, err := resolveNode(">=14.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, nodePath, resolved)
+
+	_, err = resolveNode(">=18.0.0")
+	assert.EqualError(t, err, "plugin requires Node.js >=18.0.0, but "+nodePath+" is version 16.3.0; "+
+		"install a matching version or set PULUMI_PLUGIN_NODE_PATH")
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestInstallNodeJSPluginDependenciesNodePath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as fake node and npm executables")
+	}
+
+	// The fake npm records the output of the `node` it runs with.
+	bin, nodeDir := t.TempDir(), t.TempDir()
+	npm := "#!/bin/sh\n[ \"$1\" = --version ] && echo 8.19.2 && exit\nnode > node-used && /bin/mkdir node_modules\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bin, "npm"), []byte(npm), 0700)) //nolint:gosec
+	node := "#!/bin/sh\necho configured\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(nodeDir, "node"), []byte(node), 0700)) //nolint:gosec
+	t.Setenv("PATH", bin)
+	t.Setenv(PluginNodePathEnvVar, filepath.Join(nodeDir, "node"))
+
+	// Plugins that don't require a Node.js version are installed with it too.
+	dir := t.TempDir()
+	proj := &PluginProject{Runtime: NewProjectRuntimeInfo("nodejs", nil)}
+	require.NoError(t, installNodeJSPluginDependencies(proj, dir, nil, ioutil.Discard))
+	b, err := ioutil.ReadFile(filepath.Join(dir, "node-used"))
+	require.NoError(t, err)
+	assert.Equal(t, "configured\n", string(b))
+}
+
+func TestInstallNodeJSPluginDependenciesVendored(t *testing.T) {
+	t.Parallel()
+
+	// With node_modules vendored, nothing is run, so no package.json or package manager is needed.
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "node_modules"), 0700))
+
+	proj := &PluginProject{Runtime: NewProjectRuntimeInfo("nodejs", nil)}
+	assert.NoError(t, installNodeJSPluginDependencies(proj, dir, nil, ioutil.Discard))
+}
@@ -0,0 +1,118 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// pluginCompressionRatio estimates how much larger a plugin is once its tarball is extracted. Plugin tarballs are
+// mostly executables, which gzip compresses to roughly a third or a quarter of their size.
+const pluginCompressionRatio = 4
+
+// InsufficientDiskSpaceError is returned when there isn't enough free disk space to extract a plugin.
+type InsufficientDiskSpaceError struct {
+	// Info is the plugin that was being installed.
+	Info PluginInfo
+	// Dir is the directory the plugin was being installed into.
+	Dir string
+	// Required is the estimated number of bytes the extracted plugin needs.
+	Required int64
+	// Available is the number of bytes free in Dir.
+	Available int64
+}
+
+func (err *InsufficientDiskSpaceError) Error() string {
+	return fmt.Sprintf("not enough disk space to install %s plugin %s: need ~%d MB free in %s, but only %d MB is free",
+		err.Info.Kind, err.Info, megabytes(err.Required), err.Dir, megabytes(err.Available))
+}
+
+// megabytes returns n bytes in megabytes, rounded up.
+func megabytes(n int64) int64 {
+	return (n + 1<<20 - 1) >> 20
+}
+
+// extractedSizer is implemented by plugin tarballs that know how many bytes they need once extracted.
+type extractedSizer interface {
+	ExtractedSize() int64
+}
+
+// sizedTarball is a plugin tarball that knows how many bytes it needs once extracted.
+type sizedTarball struct {
+	io.ReadCloser
+	extracted int64
+}
+
+func (t *sizedTarball) ExtractedSize() int64 {
+	return t.extracted
+}
+
+// withExtractedSize records how many bytes the tarball needs once extracted. Sizes that aren't positive are unknown.
+func withExtractedSize(tgz io.ReadCloser, extracted int64) io.ReadCloser {
+	if extracted <= 0 {
+		return tgz
+	}
+	return &sizedTarball{ReadCloser: tgz, extracted: extracted}
+}
+
+// withEstimatedExtractedSize records an estimate of how many bytes the tarball needs once extracted, based on its
+// compressed size, unless the tarball already knows.
+func withEstimatedExtractedSize(tgz io.ReadCloser, compressed int64) io.ReadCloser {
+	if _, ok := tgz.(extractedSizer); ok || compressed <= 0 {
+		return tgz
+	}
+	return withExtractedSize(tgz, compressed*pluginCompressionRatio)
+}
+
+// withExtractedSizeOf records how many bytes wrapped, which reads tgz, needs once extracted, if tgz knows.
+func withExtractedSizeOf(wrapped, tgz io.ReadCloser) io.ReadCloser {
+	if sizer, ok := tgz.(extractedSizer); ok {
+		return withExtractedSize(wrapped, sizer.ExtractedSize())
+	}
+	return wrapped
+}
+
+// extractedSizeOf returns how many bytes the tarball needs once extracted, if that's known.
+func extractedSizeOf(tgz io.ReadCloser) (int64, bool) {
+	switch tgz := tgz.(type) {
+	case extractedSizer:
+		return tgz.ExtractedSize(), true
+	case *os.File:
+		if stat, err := tgz.Stat(); err == nil && stat.Mode().IsRegular() {
+			return stat.Size() * pluginCompressionRatio, true
+		}
+	}
+	return 0, false
+}
+
+// checkPluginDiskSpace fails if there's clearly not enough free space in dir to extract the plugin's tarball. The
+// check is skipped if the tarball's size or the free space can't be determined.
+func checkPluginDiskSpace(info PluginInfo, tgz io.ReadCloser, dir string) error {
+	required, ok := extractedSizeOf(tgz)
+	if !ok {
+		return nil
+	}
+	available, err := freeDiskSpace(dir)
+	if err != nil {
+		info.logf(5, "could not determine free disk space in %s: %v", dir, err)
+		return nil
+	}
+	if available >= 0 && available < required {
+		return &InsufficientDiskSpaceError{Info: info, Dir: dir, Required: required, Available: available}
+	}
+	return nil
+}
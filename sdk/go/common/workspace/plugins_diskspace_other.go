@@ -0,0 +1,23 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package workspace
+
+// freeDiskSpace returns -1, as the free disk space can't be determined on this platform.
+func freeDiskSpace(dir string) (int64, error) {
+	return -1, nil
+}
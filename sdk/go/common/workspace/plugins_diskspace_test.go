@@ -0,0 +1,98 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInsufficientDiskSpaceError(t *testing.T) {
+	t.Parallel()
+
+	_, info := newMockPlugin(t)
+	err := &InsufficientDiskSpaceError{Info: info, Dir: "/plugins", Required: 150<<20 + 1, Available: 20 << 20}
+	assert.Equal(t, "not enough disk space to install resource plugin mock-1.0.0: "+
+		"need ~151 MB free in /plugins, but only 20 MB is free", err.Error())
+
+	help, ok := GetPluginErrorHelp(info, err)
+	require.True(t, ok)
+	assert.Equal(t, []string{PulumiHomeEnvVar}, help.EnvVars)
+}
+
+func TestExtractedSizeOf(t *testing.T) {
+	t.Parallel()
+
+	tgz := ioutil.NopCloser(bytes.NewReader(nil))
+
+	_, ok := extractedSizeOf(tgz)
+	assert.False(t, ok)
+	_, ok = extractedSizeOf(withEstimatedExtractedSize(tgz, -1))
+	assert.False(t, ok, "unknown download sizes aren't estimated")
+
+	size, ok := extractedSizeOf(withEstimatedExtractedSize(tgz, 100))
+	assert.True(t, ok)
+	assert.Equal(t, int64(100*pluginCompressionRatio), size)
+
+	size, ok = extractedSizeOf(withEstimatedExtractedSize(withExtractedSize(tgz, 150), 100))
+	assert.True(t, ok)
+	assert.Equal(t, int64(150), size, "known sizes aren't replaced by estimates")
+
+	size, ok = extractedSizeOf(withExtractedSizeOf(tgz, withExtractedSize(tgz, 150)))
+	assert.True(t, ok)
+	assert.Equal(t, int64(150), size)
+
+	path := filepath.Join(t.TempDir(), "plugin.tar.gz")
+	require.NoError(t, ioutil.WriteFile(path, make([]byte, 100), 0600))
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	size, ok = extractedSizeOf(f)
+	assert.True(t, ok)
+	assert.Equal(t, int64(100*pluginCompressionRatio), size)
+}
+
+func TestInstallChecksDiskSpace(t *testing.T) {
+	t.Parallel()
+
+	dir, info := newMockPlugin(t)
+	if available, err := freeDiskSpace(dir); err != nil || available < 0 {
+		t.Skip("free disk space can't be determined on this platform")
+	}
+
+	tgz := withExtractedSize(ioutil.NopCloser(bytes.NewReader(mockPluginTGZ(t))), 1<<62)
+	err := info.InstallWithProgress(tgz, false, nil)
+	var diskSpace *InsufficientDiskSpaceError
+	require.True(t, errors.As(err, &diskSpace), "unexpected error: %v", err)
+	assert.Equal(t, dir, diskSpace.Dir)
+
+	// Nothing is left behind to clean up.
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.NotEqual(t, info.Dir(), entry.Name())
+		assert.NotEqual(t, info.Dir()+".partial", entry.Name())
+	}
+
+	tgz = withExtractedSize(ioutil.NopCloser(bytes.NewReader(mockPluginTGZ(t))), 4096)
+	require.NoError(t, info.InstallWithProgress(tgz, false, nil))
+}
@@ -0,0 +1,151 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// PluginDownloadCommandEnvVar is a command that plugin tarballs are downloaded with instead of the CLI's own HTTP
+// client, such as `aria2c --quiet -o {output} {url}` or a fetch tool that authenticates with Kerberos. `{url}` is
+// replaced with the URL to download, and `{output}` with the path the command must write it to. It takes precedence
+// over the `downloadCommand` setting in PluginConfigFile.
+const PluginDownloadCommandEnvVar = "PULUMI_PLUGIN_DOWNLOAD_COMMAND"
+
+const (
+	downloadCommandURL    = "{url}"
+	downloadCommandOutput = "{output}"
+)
+
+// parsePluginDownloadCommand splits a download command template into its arguments, which are separated by
+// whitespace. The command isn't run with a shell, so the URL can't change what's run.
+func parsePluginDownloadCommand(template string) ([]string, error) {
+	args := strings.Fields(template)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("the download command is empty")
+	}
+	for _, placeholder := range []string{downloadCommandURL, downloadCommandOutput} {
+		if !strings.Contains(template, placeholder) {
+			return nil, fmt.Errorf("the download command %q doesn't contain %s", template, placeholder)
+		}
+	}
+	return args, nil
+}
+
+// getPluginDownloadCommand returns the arguments of the download command set by PluginDownloadCommandEnvVar, or
+// PluginConfigFile if it isn't set, or nil if plugins are downloaded with the CLI's own HTTP client.
+func (ctx *Context) getPluginDownloadCommand() ([]string, error) {
+	if env := os.Getenv(PluginDownloadCommandEnvVar); env != "" {
+		args, err := parsePluginDownloadCommand(env)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", PluginDownloadCommandEnvVar, err)
+		}
+		return args, nil
+	}
+	config, err := ctx.GetPluginConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.DownloadCommand, nil
+}
+
+// delegatePluginDownloads returns a getter that runs the context's download command, if it has one, for the requests
+// a plugin source makes to download a tarball, and sends its other requests, such as GitHub API lookups, with next.
+// Delegated requests don't go through the context's download middleware, and their headers aren't sent, so the
+// command is responsible for its own authentication. Verifying and installing the download is unchanged.
+func (ctx *Context) delegatePluginDownloads(next PluginHTTPGetter) (PluginHTTPGetter, error) {
+	command, err := ctx.getPluginDownloadCommand()
+	if err != nil || command == nil {
+		return next, err
+	}
+	if fips, err := ctx.fipsMode(); err != nil {
+		return nil, err
+	} else if fips {
+		return nil, errFIPSMode("plugins can't be downloaded with the download command `%s`, whose TLS isn't the CLI's",
+			strings.Join(command, " "))
+	}
+	return func(req *http.Request) (io.ReadCloser, int64, error) {
+		if req.Method != http.MethodGet || strings.Contains(req.Header.Get("Accept"), "json") {
+			return next(req)
+		}
+		return ctx.runPluginDownloadCommand(command, req.URL.String())
+	}, nil
+}
+
+// runPluginDownloadCommand downloads url with the given download command into a temporary directory, returning the
+// downloaded file. The directory is removed when the file is closed.
+func (ctx *Context) runPluginDownloadCommand(command []string, url string) (io.ReadCloser, int64, error) {
+	dir, err := ioutil.TempDir("", "pulumi-plugin-download-")
+	if err != nil {
+		return nil, -1, err
+	}
+	name := path.Base(strings.SplitN(url, "?", 2)[0])
+	if name == "" || name == "/" || name == "." {
+		name = "download"
+	}
+	output := filepath.Join(dir, name)
+
+	args := make([]string, len(command))
+	for i, arg := range command {
+		arg = strings.ReplaceAll(arg, downloadCommandURL, url)
+		args[i] = strings.ReplaceAll(arg, downloadCommandOutput, output)
+	}
+	ctx.logf(9, "downloading %s with %s", url, command[0])
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...) //nolint:gosec // the command is configured by the user
+	cmd.Stdout, cmd.Stderr = &stderr, &stderr
+	if err := cmd.Run(); err != nil {
+		contract.IgnoreError(os.RemoveAll(dir))
+		return nil, -1, fmt.Errorf("download command %s failed to download %s: %w\n%s",
+			command[0], url, err, strings.TrimSpace(stderr.String()))
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		contract.IgnoreError(os.RemoveAll(dir))
+		return nil, -1, fmt.Errorf("download command %s didn't write %s: %w", command[0], url, err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		contract.IgnoreClose(f)
+		contract.IgnoreError(os.RemoveAll(dir))
+		return nil, -1, err
+	}
+	return &downloadedFile{File: f, dir: dir}, stat.Size(), nil
+}
+
+// downloadedFile is a file written by the download command, whose temporary directory is removed once it's closed.
+type downloadedFile struct {
+	*os.File
+	dir string
+}
+
+func (f *downloadedFile) Close() error {
+	err := f.File.Close()
+	contract.IgnoreError(os.RemoveAll(f.dir))
+	return err
+}
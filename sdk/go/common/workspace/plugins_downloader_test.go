@@ -0,0 +1,121 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePluginDownloadCommand(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		template string
+		args     []string
+		err      string
+	}{
+		{template: "aria2c -o {output} {url}", args: []string{"aria2c", "-o", "{output}", "{url}"}},
+		{template: "  fetch --out={output}\t{url} ", args: []string{"fetch", "--out={output}", "{url}"}},
+		{template: "", err: "the download command is empty"},
+		{template: "fetch {output}", err: `the download command "fetch {output}" doesn't contain {url}`},
+	}
+	for _, tt := range tests {
+		args, err := parsePluginDownloadCommand(tt.template)
+		if tt.err == "" {
+			assert.NoError(t, err)
+			assert.Equal(t, tt.args, args)
+		} else {
+			assert.EqualError(t, err, tt.err)
+		}
+	}
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestDelegatePluginDownloads(t *testing.T) {
+	if runtime.GOOS == windowsGOOS {
+		t.Skip("uses a shell script as the download command")
+	}
+
+	dir := t.TempDir()
+	tgz := mockPluginTGZ(t)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "plugin.tar.gz"), tgz, 0600))
+	script := filepath.Join(dir, "fetch")
+	require.NoError(t, ioutil.WriteFile(script, []byte(`#!/bin/sh
+case "$1" in
+*/missing.tar.gz) echo "not found: $1" >&2; exit 1 ;;
+esac
+echo "$1" >> "$(dirname "$0")/fetched"
+cp "$(dirname "$0")/plugin.tar.gz" "$2"
+`), 0700))
+
+	var sent []string
+	next := func(req *http.Request) (io.ReadCloser, int64, error) {
+		sent = append(sent, req.URL.String())
+		return ioutil.NopCloser(strings.NewReader("{}")), 2, nil
+	}
+	ctx := &Context{Home: t.TempDir()}
+
+	// Without a download command, requests are sent as usual.
+	t.Setenv(PluginDownloadCommandEnvVar, "")
+	getter, err := ctx.delegatePluginDownloads(next)
+	require.NoError(t, err)
+	req, err := http.NewRequest("GET", "https://plugins.corp/pulumi-resource-mock-v1.0.0.tar.gz", nil)
+	require.NoError(t, err)
+	_, _, err = getter(req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{req.URL.String()}, sent)
+
+	t.Setenv(PluginDownloadCommandEnvVar, script+" {url} {output}")
+	getter, err = ctx.delegatePluginDownloads(next)
+	require.NoError(t, err)
+
+	// Tarballs are downloaded with the command.
+	r, length, err := getter(req)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(tgz)), length)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.True(t, bytes.Equal(tgz, b))
+	fetched, err := ioutil.ReadFile(filepath.Join(dir, "fetched"))
+	require.NoError(t, err)
+	assert.Equal(t, req.URL.String()+"\n", string(fetched))
+
+	// API requests aren't.
+	api, err := http.NewRequest("GET", "https://api.github.com/repos/pulumi/pulumi-mock/releases/latest", nil)
+	require.NoError(t, err)
+	api.Header.Set("Accept", "application/json")
+	_, _, err = getter(api)
+	require.NoError(t, err)
+	assert.Equal(t, []string{req.URL.String(), api.URL.String()}, sent)
+
+	// The command's output is reported if it fails.
+	missing, err := http.NewRequest("GET", "https://plugins.corp/missing.tar.gz", nil)
+	require.NoError(t, err)
+	_, _, err = getter(missing)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to download https://plugins.corp/missing.tar.gz")
+	assert.Contains(t, err.Error(), "not found: https://plugins.corp/missing.tar.gz")
+}
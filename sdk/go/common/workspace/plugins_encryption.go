@@ -0,0 +1,520 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/archive"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// PluginCacheKeyCommandEnvVar is a command, split into its arguments at spaces, that prints the key plugins are
+// encrypted with in the plugin cache. It takes precedence over the `cacheKeyCommand` setting of PluginConfigFile.
+//
+// When a key command is set, plugins are encrypted once they're installed, so the plugin cache doesn't hold them in
+// cleartext, and GetPluginPath decrypts them into a directory private to the process, in $XDG_RUNTIME_DIR if it's
+// set, when they're used. RemoveDecryptedPlugins removes the decrypted copies. The key is 32 random bytes,
+// base64-encoded, such as those printed by `openssl rand -base64 32`. It's kept wrapped, for example encrypted with
+// age and printed by `age --decrypt -i key.txt plugin-cache-key.age`, or in the OS keychain and printed by
+// `security find-generic-password -s pulumi-plugin-cache -w`.
+const PluginCacheKeyCommandEnvVar = "PULUMI_PLUGIN_CACHE_KEY_COMMAND"
+
+const (
+	// pluginSealedFile is the file in an encrypted plugin's directory holding its encrypted files.
+	pluginSealedFile = ".sealed"
+	// pluginSealedMagic starts each sealed file, identifying its format.
+	pluginSealedMagic = "pulumi-sealed-v1\n"
+	// sealChunkSize is how much plaintext each authenticated chunk of a sealed file holds.
+	sealChunkSize = 64 << 10
+	// sealSaltSize is the size of the random salt each sealed file's key is derived with.
+	sealSaltSize = 32
+)
+
+// parsePluginCacheKeyCommand splits a key command into its arguments.
+func parsePluginCacheKeyCommand(s string) ([]string, error) {
+	args := strings.Fields(s)
+	if len(args) == 0 {
+		return nil, errors.New("the key command is empty")
+	}
+	return args, nil
+}
+
+// getPluginCacheKeyCommand returns the key command from PluginCacheKeyCommandEnvVar, or PluginConfigFile if it isn't
+// set, or nil if the plugin cache isn't encrypted.
+func (ctx *Context) getPluginCacheKeyCommand() ([]string, error) {
+	if env := os.Getenv(PluginCacheKeyCommandEnvVar); env != "" {
+		args, err := parsePluginCacheKeyCommand(env)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", PluginCacheKeyCommandEnvVar, err)
+		}
+		return args, nil
+	}
+	config, err := ctx.GetPluginConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.CacheKeyCommand, nil
+}
+
+// pluginCacheKeys caches the keys printed by key commands, by command, so each is only run once.
+var pluginCacheKeys = struct {
+	sync.Mutex
+	keys map[string][]byte
+}{keys: map[string][]byte{}}
+
+// getPluginCacheKey returns the key plugins are encrypted with, or nil if the plugin cache isn't encrypted.
+func (ctx *Context) getPluginCacheKey() ([]byte, error) {
+	command, err := ctx.getPluginCacheKeyCommand()
+	if err != nil || command == nil {
+		return nil, err
+	}
+	id := strings.Join(command, "\x00")
+
+	pluginCacheKeys.Lock()
+	defer pluginCacheKeys.Unlock()
+	if key, ok := pluginCacheKeys.keys[id]; ok {
+		return key, nil
+	}
+	ctx.logf(9, "running %s for the plugin cache key", command[0])
+	var stderr bytes.Buffer
+	cmd := exec.Command(command[0], command[1:]...) //nolint:gosec // the user configured the command
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running the plugin cache key command `%s`: %w: %s",
+			strings.Join(command, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("the plugin cache key command `%s` didn't print 32 base64-encoded bytes",
+			strings.Join(command, " "))
+	}
+	pluginCacheKeys.keys[id] = key
+	return key, nil
+}
+
+// sealAEAD returns the cipher a sealed file's chunks are encrypted with, for the given key and salt.
+func sealAEAD(key, salt []byte) (cipher.AEAD, error) {
+	derived := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte("pulumi plugin cache")), derived); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealNonce returns the nonce of a sealed file's chunk: its index, and whether it's the last one, so chunks can't be
+// reordered or the file truncated without it being noticed.
+func sealNonce(aead cipher.AEAD, index uint64, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[aead.NonceSize()-9:], index)
+	if last {
+		nonce[aead.NonceSize()-1] = 1
+	}
+	return nonce
+}
+
+// sealingWriter encrypts what's written to it in authenticated chunks. Close writes the last chunk, and must be called.
+type sealingWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	buf   []byte
+	index uint64
+}
+
+func newSealingWriter(w io.Writer, key []byte) (*sealingWriter, error) {
+	salt := make([]byte, sealSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := sealAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, pluginSealedMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	return &sealingWriter{w: w, aead: aead, buf: make([]byte, 0, sealChunkSize)}, nil
+}
+
+func (s *sealingWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		// A full chunk is only written once more follows, since the last chunk is sealed differently.
+		if len(s.buf) == sealChunkSize {
+			if err := s.flush(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(s.buf[len(s.buf):sealChunkSize], p)
+		s.buf = s.buf[:len(s.buf)+c]
+		p, n = p[c:], n+c
+	}
+	return n, nil
+}
+
+func (s *sealingWriter) flush(last bool) error {
+	sealed := s.aead.Seal(nil, sealNonce(s.aead, s.index, last), s.buf, nil)
+	s.buf, s.index = s.buf[:0], s.index+1
+	_, err := s.w.Write(sealed)
+	return err
+}
+
+func (s *sealingWriter) Close() error {
+	return s.flush(true)
+}
+
+// unsealingReader decrypts and authenticates the chunks written by a sealingWriter.
+type unsealingReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	buf   []byte
+	rest  []byte
+	index uint64
+	done  bool
+}
+
+// newUnsealingReader reads the header of a sealed file, returning a reader of its plaintext and its salt.
+func newUnsealingReader(r io.Reader, key []byte) (*unsealingReader, []byte, error) {
+	header := make([]byte, len(pluginSealedMagic)+sealSaltSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(pluginSealedMagic)]) != pluginSealedMagic {
+		return nil, nil, errors.New("not an encrypted plugin")
+	}
+	salt := header[len(pluginSealedMagic):]
+	aead, err := sealAEAD(key, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	// One byte more than a chunk is read at a time, to tell whether the chunk is the last one.
+	buf := make([]byte, 0, sealChunkSize+aead.Overhead()+1)
+	return &unsealingReader{r: r, aead: aead, buf: buf}, salt, nil
+}
+
+func (u *unsealingReader) Read(p []byte) (int, error) {
+	for len(u.rest) == 0 {
+		if u.done {
+			return 0, io.EOF
+		}
+		if err := u.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, u.rest)
+	u.rest = u.rest[n:]
+	return n, nil
+}
+
+func (u *unsealingReader) next() error {
+	n, err := io.ReadFull(u.r, u.buf[len(u.buf):cap(u.buf)])
+	u.buf = u.buf[:len(u.buf)+n]
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	last := len(u.buf) < cap(u.buf)
+	chunk := u.buf
+	if !last {
+		chunk = u.buf[:cap(u.buf)-1]
+	}
+	plaintext, err := u.aead.Open(nil, sealNonce(u.aead, u.index, last), chunk, nil)
+	if err != nil {
+		return errors.New("the plugin can't be decrypted with the plugin cache key; it was encrypted with " +
+			"another key, or is corrupt")
+	}
+	if last {
+		u.buf, u.done = u.buf[:0], true
+	} else {
+		// Keep the byte read past the chunk, which starts the next one.
+		u.buf = append(u.buf[:0], u.buf[cap(u.buf)-1])
+	}
+	u.rest, u.index = plaintext, u.index+1
+	return nil
+}
+
+// sealPlugin encrypts the plugin installed in dir, if the plugin cache is encrypted, replacing its files with
+// pluginSealedFile. Its install receipt is left in cleartext.
+func sealPlugin(info PluginInfo, dir string) error {
+	key, err := info.context().getPluginCacheKey()
+	if err != nil || key == nil {
+		return err
+	}
+	info.logf(5, "encrypting plugin %s in %s", info, dir)
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, pluginSealedFile+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = writeSealedPlugin(f, dir, key)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(dir, pluginSealedFile))
+	}
+	if err != nil {
+		contract.IgnoreError(os.Remove(tmp))
+		return fmt.Errorf("encrypting plugin %s: %w", info, err)
+	}
+
+	for _, entry := range entries {
+		if name := entry.Name(); name != PluginInstallReceiptFile {
+			if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeSealedPlugin writes the files of the plugin in dir, other than its install receipt, to w as an encrypted
+// tarball.
+func writeSealedPlugin(w io.Writer, dir string, key []byte) error {
+	sealer, err := newSealingWriter(w, key)
+	if err != nil {
+		return err
+	}
+	gw := gzip.NewWriter(sealer)
+	tw := tar.NewWriter(gw)
+	err = filepath.Walk(dir, func(path string, stat os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." || rel == PluginInstallReceiptFile || rel == pluginSealedFile+".tmp" {
+			return nil
+		}
+
+		link := ""
+		if stat.Mode()&os.ModeSymlink != 0 {
+			if link, err = containedPluginLink(dir, path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(stat, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if stat.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !stat.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer contract.IgnoreClose(f)
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	return sealer.Close()
+}
+
+// containedPluginLink returns the target of the link at path relative to the link, so it still points to the same
+// file once the plugin's decrypted elsewhere, failing for links to files outside of dir.
+func containedPluginLink(dir, path string) (string, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+	resolved := target
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(filepath.Dir(path), resolved)
+	}
+	rel, err := filepath.Rel(dir, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s links to %s, outside of the plugin's directory, so it can't be encrypted", path, target)
+	}
+	return filepath.Rel(filepath.Dir(path), resolved)
+}
+
+// pluginUnsealed records the directories this process decrypts plugins into, and the copies it's decrypted there.
+var pluginUnsealed = struct {
+	sync.Mutex
+	// roots are the process's directories, by the directory they're in.
+	roots map[string]string
+	// copies are the directories of the plugins decrypted into roots.
+	copies map[string]bool
+}{roots: map[string]string{}, copies: map[string]bool{}}
+
+// pluginUnsealedRoot returns the directory this process decrypts encrypted plugins into, creating it if need be. It's
+// created in $XDG_RUNTIME_DIR, which is private to the user and usually kept in memory, or else in the temporary
+// directory, and is only readable by the user. It's removed by RemoveDecryptedPlugins. pluginUnsealed must be locked.
+func pluginUnsealedRoot() (string, error) {
+	parent := os.TempDir()
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		parent = filepath.Join(dir, "pulumi")
+		if err := os.MkdirAll(parent, 0700); err != nil {
+			return "", err
+		}
+		if err := checkPrivateDir(parent); err != nil {
+			return "", err
+		}
+	}
+	if root, ok := pluginUnsealed.roots[parent]; ok && checkPrivateDir(root) == nil {
+		return root, nil
+	}
+	root, err := ioutil.TempDir(parent, "pulumi-plugins-")
+	if err != nil {
+		return "", err
+	}
+	if err := checkPrivateDir(root); err != nil {
+		return "", err
+	}
+	pluginUnsealed.roots[parent] = root
+	return root, nil
+}
+
+// RemoveDecryptedPlugins removes the copies of the plugins this process decrypted from an encrypted plugin cache, so
+// they aren't kept in cleartext once it's done with them. Programs that use encrypted plugin caches should call it
+// before they exit; plugins that are still running are unaffected, but can't be started again afterwards.
+func RemoveDecryptedPlugins() error {
+	pluginUnsealed.Lock()
+	defer pluginUnsealed.Unlock()
+	var result error
+	for parent, root := range pluginUnsealed.roots {
+		if err := os.RemoveAll(root); err != nil && result == nil {
+			result = err
+		}
+		delete(pluginUnsealed.roots, parent)
+	}
+	pluginUnsealed.copies = map[string]bool{}
+	return result
+}
+
+// unsealPlugin decrypts the plugin installed in dir, if it's encrypted, returning the directory it's decrypted into and
+// the path of its executable there. Plugins are only decrypted once by each process for each time they're encrypted.
+func (ctx *Context) unsealPlugin(info PluginInfo, dir string) (string, string, bool, error) {
+	f, err := os.Open(filepath.Join(dir, pluginSealedFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", false, nil
+		}
+		return "", "", false, err
+	}
+	defer contract.IgnoreClose(f)
+
+	key, err := ctx.getPluginCacheKey()
+	if err != nil {
+		return "", "", false, err
+	} else if key == nil {
+		return "", "", false, fmt.Errorf("%s plugin %s is encrypted in the plugin cache, but no key is configured "+
+			"to decrypt it; set %s", info.Kind, info, PluginCacheKeyCommandEnvVar)
+	}
+	unsealer, salt, err := newUnsealingReader(f, key)
+	if err != nil {
+		return "", "", false, fmt.Errorf("decrypting %s plugin %s: %w", info.Kind, info, err)
+	}
+
+	pluginUnsealed.Lock()
+	defer pluginUnsealed.Unlock()
+	root, err := pluginUnsealedRoot()
+	if err != nil {
+		return "", "", false, fmt.Errorf("decrypting %s plugin %s: %w", info.Kind, info, err)
+	}
+	target := filepath.Join(root, fmt.Sprintf("%s-%s", info.Dir(), hex.EncodeToString(salt[:8])))
+	if !pluginUnsealed.copies[target] {
+		// Only copies this process decrypted are used, so nothing else can have put files there.
+		if err := os.RemoveAll(target); err != nil {
+			return "", "", false, err
+		}
+		tmp, err := ioutil.TempDir(root, info.Dir()+".tmp-")
+		if err != nil {
+			return "", "", false, err
+		}
+		defer func() { contract.IgnoreError(os.RemoveAll(tmp)) }()
+
+		ctx.logf(5, "decrypting plugin %s into %s", info, target)
+		opts := PluginExtractOptions
+		opts.Symlinks = archive.AllowContainedSymlinks
+		if err := archive.ExtractTGZWithOptions(unsealer, tmp, opts); err != nil {
+			return "", "", false, fmt.Errorf("decrypting %s plugin %s: %w", info.Kind, info, err)
+		}
+		if err := os.Rename(tmp, target); err != nil {
+			return "", "", false, err
+		}
+		pluginUnsealed.copies[target] = true
+	}
+
+	if path, ok := findPluginExecutable(target, info.FilePrefix(), getCandidateExtensions()); ok {
+		return target, path, true, nil
+	}
+	return target, filepath.Join(target, info.File()), true, nil
+}
+
+// removeUnsealedPlugin removes the copies of the plugin this process decrypted from the plugin cache.
+func removeUnsealedPlugin(info PluginInfo) {
+	pluginUnsealed.Lock()
+	defer pluginUnsealed.Unlock()
+	for _, root := range pluginUnsealed.roots {
+		// Copies are named by the plugin's directory and the first 8 bytes of the salt it was encrypted with.
+		copies, err := filepath.Glob(filepath.Join(root, info.Dir()+"-"+strings.Repeat("?", 16)))
+		if err != nil {
+			continue
+		}
+		for _, dir := range copies {
+			contract.IgnoreError(os.RemoveAll(dir))
+			delete(pluginUnsealed.copies, dir)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	key := bytes.Repeat([]byte{7}, 32)
+	for _, size := range []int{0, 1, sealChunkSize - 1, sealChunkSize, sealChunkSize + 1, 3 * sealChunkSize} {
+		size := size
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			t.Parallel()
+
+			plaintext := make([]byte, size)
+			_, err := rand.Read(plaintext)
+			require.NoError(t, err)
+			var sealed bytes.Buffer
+			w, err := newSealingWriter(&sealed, key)
+			require.NoError(t, err)
+			_, err = w.Write(plaintext)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			r, _, err := newUnsealingReader(bytes.NewReader(sealed.Bytes()), key)
+			require.NoError(t, err)
+			unsealed, err := ioutil.ReadAll(r)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(plaintext, unsealed))
+
+			// Flipping a bit, dropping the last chunk, or using another key must all be noticed.
+			tampered := append([]byte{}, sealed.Bytes()...)
+			tampered[len(tampered)-1] ^= 1
+			truncated := sealed.Bytes()[:len(pluginSealedMagic)+sealSaltSize]
+			if size > sealChunkSize {
+				truncated = sealed.Bytes()[:sealed.Len()-(size%sealChunkSize)-16]
+			}
+			for name, c := range map[string]struct {
+				sealed []byte
+				key    []byte
+			}{
+				"tampered":  {tampered, key},
+				"truncated": {truncated, key},
+				"wrong key": {sealed.Bytes(), bytes.Repeat([]byte{8}, 32)},
+			} {
+				r, _, err := newUnsealingReader(bytes.NewReader(c.sealed), c.key)
+				require.NoError(t, err, name)
+				_, err = ioutil.ReadAll(r)
+				assert.EqualError(t, err, "the plugin can't be decrypted with the plugin cache key; it was "+
+					"encrypted with another key, or is corrupt", name)
+			}
+		})
+	}
+
+	_, _, err := newUnsealingReader(strings.NewReader("PK\x03\x04"), key)
+	assert.EqualError(t, err, "not an encrypted plugin")
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestEncryptedPluginCache(t *testing.T) {
+	if runtime.GOOS == windowsGOOS {
+		t.Skip("prints the key with echo")
+	}
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	t.Setenv(PluginCacheKeyCommandEnvVar, "echo "+base64.StdEncoding.EncodeToString(key))
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+
+	ctx := &Context{Home: t.TempDir()}
+	_, plugin := newMockPlugin(t)
+	plugin.PluginDir = ""
+	plugin, err = ctx.Plugin(plugin)
+	require.NoError(t, err)
+	// The plugin has a file in a subdirectory as well as its executable.
+	tgz, err := createTGZWithMode(map[string][]byte{
+		"lib/schema.json":      []byte("binary"),
+		"pulumi-resource-mock": []byte("binary"),
+	}, 0700)
+	require.NoError(t, err)
+	require.NoError(t, plugin.Install(ioutil.NopCloser(bytes.NewReader(tgz)), false))
+
+	// Only the encrypted files and the install receipt are left in the plugin cache.
+	pluginDir, err := plugin.DirPath()
+	require.NoError(t, err)
+	entries, err := ioutil.ReadDir(pluginDir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{PluginInstallReceiptFile, pluginSealedFile}, names)
+
+	dir, path, err := ctx.GetPluginPath(ResourcePlugin, "mock", plugin.Version)
+	require.NoError(t, err)
+	// The plugin is decrypted into a directory of the process's own.
+	root := filepath.Dir(dir)
+	assert.Equal(t, filepath.Join(runtimeDir, "pulumi"), filepath.Dir(root))
+	assert.NoError(t, checkPrivateDir(root))
+	assert.Equal(t, filepath.Join(dir, "pulumi-resource-mock"), path)
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "binary", string(contents))
+
+	// Without the key, the plugin can't be used.
+	forgetPluginPaths(ResourcePlugin, "mock")
+	t.Setenv(PluginCacheKeyCommandEnvVar, "")
+	_, _, err = ctx.GetPluginPath(ResourcePlugin, "mock", plugin.Version)
+	assert.EqualError(t, err, "resource plugin mock-1.0.0 is encrypted in the plugin cache, but no key is "+
+		"configured to decrypt it; set PULUMI_PLUGIN_CACHE_KEY_COMMAND")
+
+	require.NoError(t, plugin.Delete())
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+
+	require.NoError(t, RemoveDecryptedPlugins())
+	_, err = os.Stat(root)
+	assert.True(t, os.IsNotExist(err))
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestPluginUnsealedRootMustBePrivate(t *testing.T) {
+	if runtime.GOOS == windowsGOOS {
+		t.Skip("directory permissions aren't checked on Windows")
+	}
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	require.NoError(t, os.Mkdir(filepath.Join(runtimeDir, "pulumi"), 0777))
+	require.NoError(t, os.Chmod(filepath.Join(runtimeDir, "pulumi"), 0777))
+
+	pluginUnsealed.Lock()
+	defer pluginUnsealed.Unlock()
+	_, err := pluginUnsealedRoot()
+	assert.EqualError(t, err, fmt.Sprintf("%s is accessible to other users: its mode is -rwxrwxrwx, not 0700",
+		filepath.Join(runtimeDir, "pulumi")))
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestSealPluginLinks(t *testing.T) {
+	if runtime.GOOS == windowsGOOS {
+		t.Skip("creates symlinks and prints the key with echo")
+	}
+	t.Setenv(PluginCacheKeyCommandEnvVar, "echo "+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	// Links within the plugin, however they're written, still point to the same files once it's decrypted.
+	dir, plugin := newMockPlugin(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "node_modules", ".bin"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "node_modules", "tsc.js"), []byte("tsc"), 0600))
+	require.NoError(t, os.Symlink("../tsc.js", filepath.Join(dir, "node_modules", ".bin", "tsc")))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "node_modules", "tsc.js"), filepath.Join(dir, "tsc")))
+	require.NoError(t, sealPlugin(plugin, dir))
+	unsealed, _, sealed, err := plugin.context().unsealPlugin(plugin, dir)
+	require.NoError(t, err)
+	require.True(t, sealed)
+	for link, target := range map[string]string{"node_modules/.bin/tsc": "../tsc.js", "tsc": "node_modules/tsc.js"} {
+		actual, err := os.Readlink(filepath.Join(unsealed, filepath.FromSlash(link)))
+		require.NoError(t, err)
+		assert.Equal(t, filepath.FromSlash(target), actual)
+		contents, err := ioutil.ReadFile(filepath.Join(unsealed, filepath.FromSlash(link)))
+		require.NoError(t, err)
+		assert.Equal(t, "tsc", string(contents))
+	}
+
+	// Links to files outside of the plugin can't be encrypted.
+	dir = t.TempDir()
+	require.NoError(t, os.Symlink("/etc/passwd", filepath.Join(dir, "passwd")))
+	err = writeSealedPlugin(ioutil.Discard, dir, bytes.Repeat([]byte{7}, 32))
+	assert.EqualError(t, err, filepath.Join(dir, "passwd")+" links to /etc/passwd, outside of the plugin's "+
+		"directory, so it can't be encrypted")
+}
@@ -0,0 +1,131 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/blang/semver"
+)
+
+// PulumiEntitlementProvider is the name of the built-in EntitlementProvider that issues the Pulumi access token of the
+// current backend as the entitlement token.
+const PulumiEntitlementProvider = "pulumi"
+
+// EntitlementRequirement describes an entitlement a source requires before it serves a plugin, such as a license key
+// for a paid provider.
+type EntitlementRequirement struct {
+	// Provider is the name of the EntitlementProvider that issues the token, e.g. "pulumi".
+	Provider string `json:"provider"`
+	// Audience identifies what the token must grant access to, such as the vendor's product ID. Its meaning is up to
+	// the provider.
+	Audience string `json:"audience,omitempty"`
+	// Header is the request header the token is sent in. If empty, the token is sent as a bearer token in the
+	// Authorization header.
+	Header string `json:"header,omitempty"`
+}
+
+// EntitlementProvider issues the tokens that entitle the user to download plugins gated by an
+// EntitlementRequirement.
+type EntitlementProvider interface {
+	// EntitlementToken returns a token entitling the user to download the given plugin version from sources requiring
+	// the given audience.
+	EntitlementToken(info PluginInfo, audience string) (string, error)
+}
+
+// EntitlementProviderFunc adapts a function to an EntitlementProvider.
+type EntitlementProviderFunc func(info PluginInfo, audience string) (string, error)
+
+// EntitlementToken calls f(info, audience).
+func (f EntitlementProviderFunc) EntitlementToken(info PluginInfo, audience string) (string, error) {
+	return f(info, audience)
+}
+
+var (
+	entitlementProvidersLock sync.RWMutex
+	entitlementProviders     = map[string]EntitlementProvider{
+		PulumiEntitlementProvider: EntitlementProviderFunc(pulumiEntitlementToken),
+	}
+)
+
+// RegisterEntitlementProvider makes provider available to sources requiring entitlements from the given provider
+// name, replacing any provider already registered with that name. Hosts use it to plug in vendor license APIs.
+func RegisterEntitlementProvider(name string, provider EntitlementProvider) {
+	entitlementProvidersLock.Lock()
+	defer entitlementProvidersLock.Unlock()
+	entitlementProviders[name] = provider
+}
+
+// getEntitlementProvider returns the provider registered with the given name.
+func getEntitlementProvider(name string) (EntitlementProvider, bool) {
+	entitlementProvidersLock.RLock()
+	defer entitlementProvidersLock.RUnlock()
+	provider, ok := entitlementProviders[name]
+	return provider, ok
+}
+
+// applyEntitlement resolves the token for requirement, which may be nil, and adds it to req, a request for the given
+// version of a plugin.
+func applyEntitlement(req *http.Request, name string, kind PluginKind, version semver.Version,
+	requirement *EntitlementRequirement) error {
+	if requirement == nil {
+		return nil
+	}
+
+	info := PluginInfo{Name: name, Kind: kind, Version: &version}
+	provider, ok := getEntitlementProvider(requirement.Provider)
+	if !ok {
+		return classifyPluginError(ErrUnauthorized, fmt.Errorf(
+			"%s plugin %s requires an entitlement from %q, but no such entitlement provider is registered",
+			kind, info, requirement.Provider))
+	}
+	token, err := provider.EntitlementToken(info, requirement.Audience)
+	if err != nil {
+		return fmt.Errorf("getting %q entitlement for %s plugin %s: %w", requirement.Provider, kind, info, err)
+	}
+	if token == "" {
+		return classifyPluginError(ErrUnauthorized, fmt.Errorf(
+			"%s plugin %s requires an entitlement from %q, but none was issued", kind, info, requirement.Provider))
+	}
+	filterCredentials(token)
+
+	if requirement.Header != "" {
+		req.Header.Set(requirement.Header, token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// pulumiEntitlementToken is the built-in "pulumi" EntitlementProvider. It issues the access token of the current
+// Pulumi backend, which the source can use to check the user's organization memberships.
+func pulumiEntitlementToken(info PluginInfo, audience string) (string, error) {
+	cloudURL, err := currentCloudURL()
+	if err != nil {
+		return "", err
+	}
+	token, err := backendAccessToken(cloudURL)
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", classifyPluginError(ErrUnauthorized,
+			errors.New("not logged in to a Pulumi backend; run `pulumi login` or set "+PulumiAccessTokenEnvVar))
+	}
+	return token, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyEntitlement(t *testing.T) {
+	t.Parallel()
+
+	RegisterEntitlementProvider("test-vendor", EntitlementProviderFunc(func(info PluginInfo,
+		audience string) (string, error) {
+		switch audience {
+		case "acme-pro":
+			return "token-for-" + info.String(), nil
+		case "revoked":
+			return "", errors.New("subscription expired")
+		default:
+			return "", nil
+		}
+	}))
+
+	version := semver.MustParse("1.0.0")
+	newRequest := func() *http.Request {
+		req, err := buildHTTPRequest("https://example.com/plugin.tgz", "")
+		require.NoError(t, err)
+		return req
+	}
+
+	// No requirement leaves the request alone.
+	req := newRequest()
+	assert.NoError(t, applyEntitlement(req, "acme", ResourcePlugin, version, nil))
+	assert.Empty(t, req.Header.Get("Authorization"))
+
+	req = newRequest()
+	assert.NoError(t, applyEntitlement(req, "acme", ResourcePlugin, version,
+		&EntitlementRequirement{Provider: "test-vendor", Audience: "acme-pro"}))
+	assert.Equal(t, "Bearer token-for-acme-1.0.0", req.Header.Get("Authorization"))
+
+	req = newRequest()
+	assert.NoError(t, applyEntitlement(req, "acme", ResourcePlugin, version,
+		&EntitlementRequirement{Provider: "test-vendor", Audience: "acme-pro", Header: "X-License-Key"}))
+	assert.Equal(t, "token-for-acme-1.0.0", req.Header.Get("X-License-Key"))
+	assert.Empty(t, req.Header.Get("Authorization"))
+
+	err := applyEntitlement(newRequest(), "acme", ResourcePlugin, version,
+		&EntitlementRequirement{Provider: "test-vendor", Audience: "revoked"})
+	assert.EqualError(t, err, `getting "test-vendor" entitlement for resource plugin acme-1.0.0: subscription expired`)
+
+	err = applyEntitlement(newRequest(), "acme", ResourcePlugin, version,
+		&EntitlementRequirement{Provider: "test-vendor", Audience: "other"})
+	assert.EqualError(t, err, `resource plugin acme-1.0.0 requires an entitlement from "test-vendor", but none was issued`)
+
+	err = applyEntitlement(newRequest(), "acme", ResourcePlugin, version, &EntitlementRequirement{Provider: "missing"})
+	assert.EqualError(t, err, `resource plugin acme-1.0.0 requires an entitlement from "missing", `+
+		`but no such entitlement provider is registered`)
+}
+
+func TestPluginIndexSourceEntitlement(t *testing.T) {
+	t.Parallel()
+
+	RegisterEntitlementProvider("test-index", EntitlementProviderFunc(func(info PluginInfo,
+		audience string) (string, error) {
+		return "secret-" + audience, nil
+	}))
+
+	bodies := map[string]string{
+		"https://index.example.com/resource/paid.json": `{"versions": [
+			{"version": "1.0.0", "entitlement": {"provider": "test-index", "audience": "paid"},
+			 "assets": {"linux-amd64": {"url": "https://cdn.example.com/paid-1.0.0.tgz"}}}
+		]}`,
+		"https://cdn.example.com/paid-1.0.0.tgz": "tarball",
+	}
+	serve := serveURLs(bodies)
+	var authorization string
+	getHTTPResponse := func(req *http.Request) (io.ReadCloser, int64, error) {
+		if req.URL.Path == "/paid-1.0.0.tgz" {
+			authorization = req.Header.Get("Authorization")
+		}
+		return serve(req)
+	}
+
+	source := newPluginIndexSource([]string{"https://index.example.com"}, "paid", ResourcePlugin, &nextSource{})
+	r, _, err := source.Download(semver.MustParse("1.0.0"), "linux", "amd64", getHTTPResponse)
+	require.NoError(t, err)
+	r.Close()
+	assert.Equal(t, "Bearer secret-paid", authorization)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestPulumiEntitlementToken(t *testing.T) {
+	t.Setenv(PulumiHomeEnvVar, t.TempDir())
+	t.Setenv(PulumiBackendURLEnvVar, "https://api.example.com")
+	t.Setenv("PULUMI_ACCESS_TOKEN", "")
+
+	_, err := pulumiEntitlementToken(PluginInfo{Name: "acme", Kind: ResourcePlugin}, "")
+	assert.Error(t, err)
+
+	require.NoError(t, StoreAccount("https://api.example.com", Account{AccessToken: "stored"}, true))
+	token, err := pulumiEntitlementToken(PluginInfo{Name: "acme", Kind: ResourcePlugin}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "stored", token)
+
+	t.Setenv("PULUMI_ACCESS_TOKEN", "from-env")
+	token, err = pulumiEntitlementToken(PluginInfo{Name: "acme", Kind: ResourcePlugin}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "from-env", token)
+}
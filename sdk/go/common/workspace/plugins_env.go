@@ -0,0 +1,110 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// PluginEnvFile is the name of the file in PULUMI_HOME holding local overrides of the environment variables set for
+// plugins. It maps `<kind>-<name>` to the variables to set for that plugin, e.g.:
+//
+//	resource-aws:
+//	  HTTPS_PROXY: http://proxy.corp:3128
+//
+// These take precedence over the variables declared by the plugin's PulumiPlugin.yaml.
+const PluginEnvFile = "plugin-env.yaml"
+
+// GetPluginEnvironment returns the environment variables to set when running the plugin of the given kind and name
+// installed in dir, as `KEY=value` pairs sorted by key. These are the variables declared in the `env` section of the
+// plugin's PulumiPlugin.yaml, as recorded in its install receipt when it was installed, overridden by those set for
+// the plugin in PluginEnvFile. Plugins that have no receipt, or no dir, only get their overrides.
+func GetPluginEnvironment(kind PluginKind, name, dir string) ([]string, error) {
+	var declared map[string]string
+	if dir != "" {
+		receipt, err := readInstallReceipt(dir)
+		if err != nil {
+			return nil, fmt.Errorf("reading the install receipt of %s plugin %s: %w", kind, name, err)
+		}
+		if receipt != nil {
+			declared = receipt.Env
+		}
+	}
+	return pluginEnvironment(kind, name, declared)
+}
+
+// Environment returns the environment variables to set when running the resolved plugin, like GetPluginEnvironment.
+// Only plugins installed in the plugin cache have install receipts; for encrypted plugins, that's in their
+// directory in the plugin cache rather than in Dir, where they're decrypted to.
+func (resolution *PluginResolution) Environment() ([]string, error) {
+	dir := ""
+	if resolution.Origin == PluginOriginCache {
+		installDir, err := resolution.Info.DirPath()
+		if err != nil {
+			return nil, err
+		}
+		dir = installDir
+	}
+	return GetPluginEnvironment(resolution.Info.Kind, resolution.Info.Name, dir)
+}
+
+// pluginEnvironment returns the environment variables declared for the plugin, which may be nil, overridden by those
+// set for the plugin in PluginEnvFile.
+func pluginEnvironment(kind PluginKind, name string, declared map[string]string) ([]string, error) {
+	vars := map[string]string{}
+	for k, v := range declared {
+		vars[k] = v
+	}
+
+	overrides, err := loadPluginEnvOverrides()
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range overrides[string(kind)+"-"+name] {
+		vars[k] = v
+	}
+
+	env := make([]string, 0, len(vars))
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+// loadPluginEnvOverrides reads PluginEnvFile, returning nil if it doesn't exist.
+func loadPluginEnvOverrides() (map[string]map[string]string, error) {
+	path, err := GetPulumiPath(PluginEnvFile)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var overrides map[string]map[string]string
+	if err := yaml.Unmarshal(b, &overrides); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return overrides, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestGetPluginEnvironment(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv(PulumiHomeEnvVar, home)
+
+	// The environment is read from the install receipt, not from the PulumiPlugin.yaml it was recorded from.
+	dir := t.TempDir()
+	require.NoError(t, writeInstallReceipt(dir, PluginInstallReceipt{Env: map[string]string{
+		"NODE_OPTIONS": "--max-old-space-size=4096",
+		"HTTPS_PROXY":  "http://default:3128",
+	}}))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "PulumiPlugin.yaml"), []byte(`runtime: nodejs
+env:
+  NODE_OPTIONS: --changed-since-install
+`), 0600))
+
+	// Without overrides, the plugin's own environment is used.
+	env, err := GetPluginEnvironment(ResourcePlugin, "mock", dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"HTTPS_PROXY=http://default:3128", "NODE_OPTIONS=--max-old-space-size=4096"}, env)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(home, PluginEnvFile), []byte(`resource-mock:
+  HTTPS_PROXY: http://corp:3128
+resource-other:
+  FOO: bar
+`), 0600))
+
+	env, err = GetPluginEnvironment(ResourcePlugin, "mock", dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"HTTPS_PROXY=http://corp:3128", "NODE_OPTIONS=--max-old-space-size=4096"}, env)
+
+	// Plugins without an install receipt, such as those found on the $PATH, still get their overrides.
+	env, err = GetPluginEnvironment(ResourcePlugin, "other", t.TempDir())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"FOO=bar"}, env)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(home, PluginEnvFile), []byte("resource-mock: [oops]"), 0600))
+	_, err = GetPluginEnvironment(ResourcePlugin, "mock", dir)
+	assert.Error(t, err)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestPluginResolutionEnvironment(t *testing.T) {
+	t.Setenv(PulumiHomeEnvVar, t.TempDir())
+
+	dir, plugin := newMockPlugin(t)
+	installDir, err := plugin.DirPath()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(installDir, 0700))
+	require.NoError(t, writeInstallReceipt(installDir, PluginInstallReceipt{Env: map[string]string{"FOO": "bar"}}))
+
+	// Encrypted plugins run from where they're decrypted to, but their receipt stays in the plugin cache.
+	resolution := &PluginResolution{Dir: filepath.Join(dir, "unsealed"), Origin: PluginOriginCache, Info: plugin}
+	env, err := resolution.Environment()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"FOO=bar"}, env)
+
+	// Plugins found on the $PATH have no receipt.
+	resolution.Origin = PluginOriginAmbient
+	env, err = resolution.Environment()
+	require.NoError(t, err)
+	assert.Empty(t, env)
+}
@@ -0,0 +1,229 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"archive/tar"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+)
+
+// The errors returned by plugin sources, downloads and installs match one of these with errors.Is when their cause is
+// known, so callers can handle each case without inspecting error messages. The original cause remains available to
+// errors.As.
+var (
+	// ErrNotFound means the plugin, the requested version of it, or its asset doesn't exist.
+	ErrNotFound = errors.New("plugin not found")
+	// ErrUnauthorized means the source refused the request for lack of valid credentials or entitlements.
+	ErrUnauthorized = errors.New("not authorized to download plugin")
+	// ErrChecksumMismatch means a download didn't match the digest published for it.
+	ErrChecksumMismatch = errors.New("plugin checksum mismatch")
+	// ErrUnsupportedPlatform means the plugin isn't available for the host platform.
+	ErrUnsupportedPlatform = errors.New("plugin not supported on this platform")
+	// ErrRateLimited means the source is throttling requests, and the download may succeed later.
+	ErrRateLimited = errors.New("plugin download rate limited")
+	// ErrOffline means the source couldn't be reached over the network.
+	ErrOffline = errors.New("plugin source unreachable")
+	// ErrCorruptArchive means a plugin tarball couldn't be extracted because it was truncated or corrupted.
+	ErrCorruptArchive = errors.New("plugin archive corrupt")
+	// ErrStalled means a plugin download received nothing for the stall timeout, and was abandoned.
+	ErrStalled = errors.New("plugin download stalled")
+)
+
+// pluginError attaches one of the plugin error sentinels to an error, without changing its message.
+type pluginError struct {
+	kind error
+	err  error
+}
+
+// classifyPluginError returns err, marked as matching kind.
+func classifyPluginError(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &pluginError{kind: kind, err: err}
+}
+
+func (err *pluginError) Error() string {
+	return err.err.Error()
+}
+
+func (err *pluginError) Unwrap() error {
+	return err.err
+}
+
+func (err *pluginError) Is(target error) bool {
+	return target == err.kind
+}
+
+// HTTPError is returned when a plugin source responds to a request with an unsuccessful status code.
+type HTTPError struct {
+	// StatusCode is the response's HTTP status code.
+	StatusCode int
+	// URL is the URL that was requested.
+	URL string
+	// RateLimited is true if the response reported that the client ran out of requests, as GitHub does with a 403.
+	RateLimited bool
+	// RateLimitReset is when the source's rate limit resets, if the response said.
+	RateLimitReset time.Time
+	// TokenSent is true if the request carried credentials.
+	TokenSent bool
+	// SSOURL is set if GitHub refused the request because the token sent hasn't been authorized for the
+	// organization's SAML single sign-on. It's where the token can be authorized.
+	SSOURL string
+
+	message string
+}
+
+func (err *HTTPError) Error() string {
+	return err.message
+}
+
+// Is matches the plugin error sentinel corresponding to the status code.
+func (err *HTTPError) Is(target error) bool {
+	switch {
+	case err.RateLimited || err.StatusCode == http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	case err.StatusCode == http.StatusNotFound || err.StatusCode == http.StatusGone:
+		return target == ErrNotFound
+	case err.StatusCode == http.StatusUnauthorized || err.StatusCode == http.StatusForbidden:
+		return target == ErrUnauthorized
+	default:
+		return false
+	}
+}
+
+// DownloadAttempt is an unsuccessful attempt to download a plugin from one of its sources.
+type DownloadAttempt struct {
+	// Source describes the source, such as "Pulumi github", "get.pulumi.com" or the URL of a mirror.
+	Source string
+	// URL is the last URL that was requested from the source, or empty if it sent no requests.
+	URL string
+	// StatusCode is the HTTP status code the source responded with, or zero if it didn't respond with an HTTPError.
+	StatusCode int
+	// Err is the error the source returned.
+	Err error
+}
+
+// DownloadError is returned when a plugin couldn't be downloaded from any of the sources it was tried in, and records
+// every attempt, so the reason it failed on each source isn't hidden by the errors of the sources tried after it. It
+// matches any error that one of its attempts' errors matches with errors.Is and errors.As. Those are tried in the
+// order the sources were, so errors.As finds the error of the first source that returned an error of its type.
+type DownloadError struct {
+	// Kind is the kind of the plugin.
+	Kind PluginKind
+	// Name is the name of the plugin.
+	Name string
+	// Version is the version of the plugin that was downloaded.
+	Version semver.Version
+	// Attempts are the attempts to download the plugin from each of its sources, in the order they were made.
+	Attempts []DownloadAttempt
+}
+
+func (err *DownloadError) Error() string {
+	var msg strings.Builder
+	for i, attempt := range err.Attempts {
+		if i == 0 {
+			fmt.Fprintf(&msg, "error downloading %s plugin %s-%s from %s", err.Kind, err.Name, err.Version,
+				attempt.Source)
+		} else {
+			fmt.Fprintf(&msg, "\nand from %s", attempt.Source)
+		}
+		// HTTP errors already say what was requested.
+		if attempt.URL != "" && attempt.URL != attempt.Source && !strings.Contains(attempt.Err.Error(), attempt.URL) {
+			fmt.Fprintf(&msg, " (%s)", attempt.URL)
+		}
+		fmt.Fprintf(&msg, ": %s", attempt.Err.Error())
+	}
+	return msg.String()
+}
+
+// Is returns true if the error of any of the attempts matches target.
+func (err *DownloadError) Is(target error) bool {
+	for _, attempt := range err.Attempts {
+		if errors.Is(attempt.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error of the attempts that matches target.
+func (err *DownloadError) As(target interface{}) bool {
+	for _, attempt := range err.Attempts {
+		if errors.As(attempt.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// newHTTPError returns the error for an unsuccessful response to req.
+func newHTTPError(req *http.Request, resp *http.Response, message string) *HTTPError {
+	err := &HTTPError{
+		StatusCode:  resp.StatusCode,
+		URL:         req.URL.String(),
+		RateLimited: resp.Header.Get("X-RateLimit-Remaining") == "0",
+		TokenSent:   req.Header.Get("Authorization") != "",
+		message:     message,
+	}
+	if reset, parseErr := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); parseErr == nil {
+		err.RateLimitReset = time.Unix(reset, 0)
+	} else if retry, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); parseErr == nil {
+		err.RateLimitReset = time.Now().Add(time.Duration(retry) * time.Second)
+	}
+	return err
+}
+
+// classifyNetworkError marks err as ErrOffline if it shows the source couldn't be reached at all: its host name
+// couldn't be resolved, or no connection could be made to it.
+func classifyNetworkError(err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return classifyPluginError(ErrOffline, err)
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return classifyPluginError(ErrOffline, err)
+	}
+	return err
+}
+
+// checksumMismatchError returns the error reported when the download named name doesn't match its expected digest.
+func checksumMismatchError(name, expected, actual string) error {
+	return classifyPluginError(ErrChecksumMismatch,
+		fmt.Errorf("checksum mismatch for %s: expected sha256 %s, got %s", name, expected, actual))
+}
+
+// classifyArchiveError marks err, returned while extracting a plugin tarball, as ErrCorruptArchive if it shows the
+// tarball itself is truncated or corrupted.
+func classifyArchiveError(err error) error {
+	var corruptErr flate.CorruptInputError
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, gzip.ErrHeader) || errors.Is(err, gzip.ErrChecksum) ||
+		errors.Is(err, tar.ErrHeader) || errors.As(err, &corruptErr) {
+		return classifyPluginError(ErrCorruptArchive, err)
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetHTTPResponseErrorKinds(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		status   int
+		header   map[string]string
+		expected error
+	}{
+		{name: "not found", status: http.StatusNotFound, expected: ErrNotFound},
+		{name: "gone", status: http.StatusGone, expected: ErrNotFound},
+		{name: "unauthorized", status: http.StatusUnauthorized, expected: ErrUnauthorized},
+		{name: "forbidden", status: http.StatusForbidden, expected: ErrUnauthorized},
+		{name: "too many requests", status: http.StatusTooManyRequests, expected: ErrRateLimited},
+		{
+			name:     "rate limit exhausted",
+			status:   http.StatusForbidden,
+			header:   map[string]string{"X-RateLimit-Remaining": "0"},
+			expected: ErrRateLimited,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tt.header {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			req, err := buildHTTPRequest(server.URL+"/plugin.tar.gz", "")
+			require.NoError(t, err)
+			_, _, err = (&Context{}).getHTTPResponse(req)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, tt.expected), "expected %v to match %v", err, tt.expected)
+
+			var httpErr *HTTPError
+			require.True(t, errors.As(err, &httpErr))
+			assert.Equal(t, tt.status, httpErr.StatusCode)
+			assert.Equal(t, server.URL+"/plugin.tar.gz", httpErr.URL)
+		})
+	}
+}
+
+func TestClassifyNetworkError(t *testing.T) {
+	t.Parallel()
+
+	dnsErr := &net.DNSError{Err: "no such host", Name: "get.pulumi.com", IsNotFound: true}
+	err := classifyNetworkError(dnsErr)
+	assert.True(t, errors.Is(err, ErrOffline))
+	assert.Equal(t, dnsErr.Error(), err.Error())
+	var unwrapped *net.DNSError
+	assert.True(t, errors.As(err, &unwrapped))
+
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	assert.True(t, errors.Is(classifyNetworkError(dialErr), ErrOffline))
+
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	assert.False(t, errors.Is(classifyNetworkError(readErr), ErrOffline))
+}
+
+func TestPluginErrorKinds(t *testing.T) {
+	t.Parallel()
+
+	missing := NewMissingError(PluginInfo{Name: "aws", Kind: ResourcePlugin}, false)
+	assert.True(t, errors.Is(missing, ErrNotFound))
+
+	cause := errors.New("404 HTTP error fetching plugin")
+	unsupported := &UnsupportedAssetError{Platform: Platform{OS: "linux", Arch: "s390x"}, Err: cause}
+	assert.True(t, errors.Is(unsupported, ErrUnsupportedPlatform))
+	assert.True(t, errors.Is(unsupported, cause))
+
+	_, err := PlatformFallbackMatrix{}.Candidates(Platform{OS: "plan9", Arch: "amd64"})
+	assert.True(t, errors.Is(err, ErrUnsupportedPlatform))
+}
+
+func TestChecksumVerifyingReaderMismatch(t *testing.T) {
+	t.Parallel()
+
+	r := newChecksumVerifyingReader(ioutil.NopCloser(strings.NewReader("tarball")), sha256Hex("other"),
+		"plugin.tar.gz")
+	_, err := ioutil.ReadAll(r)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+	assert.Contains(t, err.Error(), "checksum mismatch for plugin.tar.gz")
+}
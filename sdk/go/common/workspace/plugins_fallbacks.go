@@ -0,0 +1,112 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// PluginDefaultSourcesEnvVar is a comma-separated list of the sources plugins without a PluginDownloadURL are
+// downloaded from, in the order they're tried, e.g. `https://plugins.corp/${KIND}/${NAME},github`. It takes precedence
+// over the `defaultSources` setting of PluginConfigFile.
+//
+// Each source is PluginSourceGitHub, PluginSourcePrivateGitHub, PluginSourceGetPulumi, or the URL of a mirror, which
+// is served like a PluginDownloadURL, so it can tell plugins apart with the `${KIND}` and `${NAME}` placeholders.
+// Public sources that aren't listed are never downloaded from, so listing only internal mirrors keeps plugins from
+// being downloaded from the internet. Unless either configures other sources, plugins are downloaded from
+// PluginSourceGitHub and then PluginSourceGetPulumi, trying PluginSourcePrivateGitHub in between when
+// PluginGitHubOwnersEnvVar or PluginConfigFile configures its owners, or `PULUMI_EXPERIMENTAL` is set.
+const PluginDefaultSourcesEnvVar = "PULUMI_PLUGIN_DEFAULT_SOURCES"
+
+const (
+	// PluginSourceGitHub is the default source of the releases of the Pulumi organization on GitHub.
+	PluginSourceGitHub = "github"
+	// PluginSourcePrivateGitHub is the default source of the releases of the GitHub organizations named by
+	// PluginGitHubOwnersEnvVar, which are tried in order. Without any configured, it's the releases of the
+	// organization named by `GITHUB_REPOSITORY_OWNER`, which are only downloaded with a `GITHUB_TOKEN`.
+	PluginSourcePrivateGitHub = "github-private"
+	// PluginSourceGetPulumi is the default source of the plugins hosted at get.pulumi.com. It can't look up the
+	// versions of plugins, so it's skipped when their latest version is looked up.
+	PluginSourceGetPulumi = "get.pulumi.com"
+)
+
+// PluginGitHubOwnersEnvVar is a comma-separated list of the GitHub organizations or users whose releases
+// PluginSourcePrivateGitHub downloads plugins from, in the order they're tried, e.g. `acme,acme-labs`. The plugin
+// `NAME` is released by the repository `pulumi-NAME` of each. It takes precedence over the `githubOwners` setting of
+// PluginConfigFile, and configuring either tries PluginSourcePrivateGitHub after PluginSourceGitHub by default, so
+// organizations that publish many providers don't need to give each a PluginDownloadURL.
+const PluginGitHubOwnersEnvVar = "PULUMI_PLUGIN_GITHUB_OWNERS"
+
+// gitHubOwnerRegexp matches the names GitHub allows organizations and users to have.
+var gitHubOwnerRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+
+// getPluginGitHubOwners returns the GitHub owners from PluginGitHubOwnersEnvVar, or PluginConfigFile if it isn't set,
+// or nil if neither configures any.
+func (ctx *Context) getPluginGitHubOwners() ([]string, error) {
+	if env := splitEnvList(os.Getenv(PluginGitHubOwnersEnvVar)); len(env) > 0 {
+		for _, owner := range env {
+			if err := validateGitHubOwner(owner); err != nil {
+				return nil, fmt.Errorf("%s: %w", PluginGitHubOwnersEnvVar, err)
+			}
+		}
+		return env, nil
+	}
+	config, err := ctx.GetPluginConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.GitHubOwners, nil
+}
+
+func validateGitHubOwner(owner string) error {
+	if !gitHubOwnerRegexp.MatchString(owner) {
+		return fmt.Errorf("%q is not the name of a GitHub organization or user", owner)
+	}
+	return nil
+}
+
+// getPluginDefaultSources returns the default plugin sources from PluginDefaultSourcesEnvVar, or PluginConfigFile if
+// it isn't set, or nil if neither configures any.
+func (ctx *Context) getPluginDefaultSources() ([]string, error) {
+	if env := splitEnvList(os.Getenv(PluginDefaultSourcesEnvVar)); len(env) > 0 {
+		for _, source := range env {
+			if err := validatePluginDefaultSource(source); err != nil {
+				return nil, fmt.Errorf("%s: %w", PluginDefaultSourcesEnvVar, err)
+			}
+		}
+		return env, nil
+	}
+	config, err := ctx.GetPluginConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.DefaultSources, nil
+}
+
+func validatePluginDefaultSource(s string) error {
+	switch s {
+	case PluginSourceGitHub, PluginSourcePrivateGitHub, PluginSourceGetPulumi:
+		return nil
+	}
+	if u, err := url.Parse(s); err == nil && u.Scheme != "" && strings.HasPrefix(s, u.Scheme+"://") {
+		return nil
+	}
+	return fmt.Errorf("%q is not %s, %s, %s, or the URL of a plugin mirror",
+		s, PluginSourceGitHub, PluginSourcePrivateGitHub, PluginSourceGetPulumi)
+}
@@ -0,0 +1,208 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestPluginDefaultSources(t *testing.T) {
+	t.Setenv("PULUMI_EXPERIMENTAL", "")
+	require.NoError(t, os.Unsetenv("PULUMI_EXPERIMENTAL"))
+	t.Setenv(PluginDefaultSourcesEnvVar, "https://mirror.corp/${KIND}/${NAME},get.pulumi.com")
+
+	var hosts []string
+	ctx := &Context{Home: t.TempDir(), HTTPClient: &http.Client{Transport: roundTripperFunc(
+		func(req *http.Request) (*http.Response, error) {
+			hosts = append(hosts, req.URL.Host)
+			resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req}
+			body := "tarball"
+			switch req.URL.String() {
+			case "https://mirror.corp/resource/test/index.json":
+				body = `{"versions": [{"version": "1.0.0"}, {"version": "1.1.0"}]}`
+			case "https://mirror.corp/resource/test/" + pluginTarballName(ResourcePlugin, "test",
+				semver.MustParse("1.1.0"), "linux", "amd64"):
+			default:
+				if req.URL.Host != "get.pulumi.com" {
+					resp.StatusCode, body = http.StatusNotFound, "not found"
+				}
+			}
+			resp.Body = ioutil.NopCloser(strings.NewReader(body))
+			return resp, nil
+		})}}
+	info, err := ctx.Plugin(PluginInfo{Name: "test", Kind: ResourcePlugin})
+	require.NoError(t, err)
+	source, ok := ctx.pluginSource(info, nil).(*fallbackSource)
+	require.True(t, ok)
+
+	// The latest version is looked up in the mirror, and never on GitHub.
+	latest, err := source.GetLatestVersion(ctx.getHTTPResponse)
+	require.NoError(t, err)
+	assert.Equal(t, "1.1.0", latest.String())
+	assert.Equal(t, []string{"mirror.corp"}, hosts)
+	_, ok = ctx.githubRepoOf(info)
+	assert.False(t, ok)
+
+	// Plugins are downloaded from the mirror first, then from the sources after it.
+	hosts = nil
+	_, _, err = source.Download(*latest, "linux", "amd64", ctx.getHTTPResponse)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mirror.corp"}, hosts)
+	hosts = nil
+	_, _, err = source.Download(semver.MustParse("1.0.0"), "linux", "amd64", ctx.getHTTPResponse)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mirror.corp", "get.pulumi.com"}, hosts)
+
+	// Public sources that aren't listed are never tried.
+	t.Setenv(PluginDefaultSourcesEnvVar, "https://mirror.corp/${KIND}/${NAME}")
+	source, ok = ctx.pluginSource(info, nil).(*fallbackSource)
+	require.True(t, ok)
+	hosts = nil
+	_, _, err = source.Download(semver.MustParse("1.0.0"), "linux", "amd64", ctx.getHTTPResponse)
+	assert.True(t, errors.Is(err, ErrNotFound), "%v", err)
+	assert.Equal(t, []string{"mirror.corp"}, hosts)
+
+	// Sources that can't look up versions are skipped when looking them up.
+	t.Setenv(PluginDefaultSourcesEnvVar, "get.pulumi.com")
+	_, err = ctx.GetLatestVersion(info)
+	assert.EqualError(t, err, "none of the default sources of resource plugin test can look up its versions")
+
+	t.Setenv(PluginDefaultSourcesEnvVar, "github,gitlab")
+	_, err = ctx.GetLatestVersion(info)
+	assert.EqualError(t, err, PluginDefaultSourcesEnvVar+
+		`: "gitlab" is not github, github-private, get.pulumi.com, or the URL of a plugin mirror`)
+
+	// Without any configured, plugins are downloaded from GitHub, then get.pulumi.com.
+	t.Setenv(PluginDefaultSourcesEnvVar, "")
+	source, ok = ctx.pluginSource(info, nil).(*fallbackSource)
+	require.True(t, ok)
+	var labels []string
+	for _, entry := range source.entries() {
+		labels = append(labels, entry.label)
+	}
+	assert.Equal(t, []string{"Pulumi github", "get.pulumi.com"}, labels)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestFallbackSourceDownloadError(t *testing.T) {
+	t.Setenv(PluginDefaultSourcesEnvVar, "github,get.pulumi.com")
+	t.Setenv("GITHUB_TOKEN", "")
+
+	ctx := &Context{Home: t.TempDir(), HTTPClient: &http.Client{Transport: roundTripperFunc(
+		func(req *http.Request) (*http.Response, error) {
+			resp := &http.Response{StatusCode: http.StatusNotFound, Header: http.Header{}, Request: req,
+				Body: ioutil.NopCloser(strings.NewReader("not found"))}
+			if req.URL.Host == "github.com" {
+				resp.StatusCode = http.StatusForbidden
+				resp.Header.Set("X-RateLimit-Remaining", "0")
+			}
+			return resp, nil
+		})}}
+	v := semver.MustParse("1.0.0")
+	info, err := ctx.Plugin(PluginInfo{Name: "test", Kind: ResourcePlugin, Version: &v})
+	require.NoError(t, err)
+	source, ok := ctx.pluginSource(info, nil).(*fallbackSource)
+	require.True(t, ok)
+
+	// GitHub's error isn't hidden by get.pulumi.com's.
+	_, _, err = source.Download(v, "linux", "amd64", ctx.getHTTPResponse)
+	var downloadErr *DownloadError
+	require.True(t, errors.As(err, &downloadErr), "%v", err)
+	require.Len(t, downloadErr.Attempts, 2)
+	assert.Equal(t, "Pulumi github", downloadErr.Attempts[0].Source)
+	assert.Equal(t, http.StatusForbidden, downloadErr.Attempts[0].StatusCode)
+	assert.Equal(t, "https://github.com/pulumi/pulumi-test/releases/download/v1.0.0/"+
+		pluginTarballName(ResourcePlugin, "test", v, "linux", "amd64"), downloadErr.Attempts[0].URL)
+	assert.Equal(t, "get.pulumi.com", downloadErr.Attempts[1].Source)
+	assert.Equal(t, http.StatusNotFound, downloadErr.Attempts[1].StatusCode)
+	assert.Equal(t, "https://get.pulumi.com/releases/plugins/"+pluginTarballName(ResourcePlugin, "test", v,
+		"linux", "amd64"), downloadErr.Attempts[1].URL)
+
+	assert.True(t, errors.Is(err, ErrRateLimited), "%v", err)
+	assert.True(t, errors.Is(err, ErrNotFound), "%v", err)
+	assert.False(t, errors.Is(err, ErrOffline), "%v", err)
+	var httpErr *HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	assert.True(t, httpErr.RateLimited, "the first source's error is found first")
+	assert.True(t, strings.HasPrefix(err.Error(), "error downloading resource plugin test-1.0.0 from Pulumi github: "+
+		"403 HTTP error fetching plugin from https://github.com/"), err.Error())
+	assert.Contains(t, err.Error(),
+		"\nand from get.pulumi.com: 404 HTTP error fetching plugin from https://get.pulumi.com/")
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestPluginGitHubOwners(t *testing.T) {
+	t.Setenv("PULUMI_EXPERIMENTAL", "")
+	require.NoError(t, os.Unsetenv("PULUMI_EXPERIMENTAL"))
+	t.Setenv(PluginDefaultSourcesEnvVar, "")
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv(PluginGitHubOwnersEnvVar, "acme,acme-labs")
+
+	v := semver.MustParse("1.0.0")
+	tarball := pluginTarballName(ResourcePlugin, "test", v, "linux", "amd64")
+	var requested []string
+	ctx := &Context{Home: t.TempDir(), HTTPClient: &http.Client{Transport: roundTripperFunc(
+		func(req *http.Request) (*http.Response, error) {
+			requested = append(requested, req.URL.String())
+			resp := &http.Response{StatusCode: http.StatusNotFound, Header: http.Header{}, Request: req,
+				Body: ioutil.NopCloser(strings.NewReader("not found"))}
+			if req.URL.Path == "/acme-labs/pulumi-test/releases/download/v1.0.0/"+tarball {
+				resp.StatusCode, resp.Body = http.StatusOK, ioutil.NopCloser(strings.NewReader("tarball"))
+			}
+			return resp, nil
+		})}}
+	info, err := ctx.Plugin(PluginInfo{Name: "test", Kind: ResourcePlugin, Version: &v})
+	require.NoError(t, err)
+
+	// The owners are tried in order, after Pulumi's releases, without needing PULUMI_EXPERIMENTAL.
+	source, ok := ctx.pluginSource(info, nil).(*fallbackSource)
+	require.True(t, ok)
+	var labels []string
+	for _, entry := range source.entries() {
+		labels = append(labels, entry.label)
+	}
+	assert.Equal(t, []string{"Pulumi github", "acme github", "acme-labs github", "get.pulumi.com"}, labels)
+	_, _, err = source.Download(v, "linux", "amd64", ctx.getHTTPResponse)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"https://github.com/pulumi/pulumi-test/releases/download/v1.0.0/" + tarball,
+		"https://github.com/acme/pulumi-test/releases/download/v1.0.0/" + tarball,
+		"https://github.com/acme-labs/pulumi-test/releases/download/v1.0.0/" + tarball,
+	}, requested)
+
+	// They can be tried before Pulumi's releases too.
+	t.Setenv(PluginDefaultSourcesEnvVar, "github-private,github")
+	source, ok = ctx.pluginSource(info, nil).(*fallbackSource)
+	require.True(t, ok)
+	labels = nil
+	for _, entry := range source.entries() {
+		labels = append(labels, entry.label)
+	}
+	assert.Equal(t, []string{"acme github", "acme-labs github", "Pulumi github"}, labels)
+
+	t.Setenv(PluginGitHubOwnersEnvVar, "acme,acme/labs")
+	_, _, err = ctx.pluginSource(info, nil).Download(v, "linux", "amd64", ctx.getHTTPResponse)
+	assert.EqualError(t, err, PluginGitHubOwnersEnvVar+`: "acme/labs" is not the name of a GitHub organization or user`)
+}
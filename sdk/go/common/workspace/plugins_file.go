@@ -0,0 +1,143 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// FileScheme prefixes the PluginDownloadURL of plugins that are read from a local or network-mounted directory, e.g.
+// `file:///mnt/plugins` or `file:///C:/plugins`. Plugins are read from the directory by the name of their tarball,
+// like they are downloaded from other PluginDownloadURLs. Their latest version is the latest version with a tarball
+// for the current platform in the directory.
+const FileScheme = "file://"
+
+// fileSource reads plugins from a directory.
+type fileSource struct {
+	name        string
+	kind        PluginKind
+	downloadURL string
+}
+
+func newFileSource(name string, kind PluginKind, downloadURL string) *fileSource {
+	return &fileSource{
+		name:        name,
+		kind:        kind,
+		downloadURL: downloadURL,
+	}
+}
+
+// parseFileURL returns the path of the directory a `file://` URL names. On Windows, the URL may name a drive, as in
+// `file:///C:/plugins`, or a share, as in `file://server/share/plugins`.
+func parseFileURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme+"://" != FileScheme || u.Path == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("expected file URL to be %s/path/to/dir; got %q", FileScheme, rawURL)
+	}
+	path := u.Path
+	switch {
+	case runtime.GOOS == "windows" && u.Host != "" && u.Host != "localhost":
+		path = `\\` + u.Host + filepath.FromSlash(path)
+	case u.Host != "" && u.Host != "localhost":
+		return "", fmt.Errorf("file URL %q names host %q; only local paths are supported", rawURL, u.Host)
+	case runtime.GOOS == "windows" && len(path) >= 3 && path[0] == '/' && path[2] == ':':
+		path = filepath.FromSlash(path[1:])
+	default:
+		path = filepath.FromSlash(path)
+	}
+	return path, nil
+}
+
+func (source *fileSource) GetLatestVersion(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
+	versions, listingURL, err := source.listVersions(getHTTPResponse)
+	if err != nil {
+		return nil, err
+	}
+	return latestBucketVersion(versions, listingURL, source.kind, source.name)
+}
+
+// ListVersions implements versionListingSource, using the versions with a tarball for the current platform.
+func (source *fileSource) ListVersions(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, error) {
+	versions, _, err := source.listVersions(getHTTPResponse)
+	return versions, err
+}
+
+// listVersions returns the versions with a tarball for the current platform, along with the URL it listed.
+func (source *fileSource) listVersions(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, string, error) {
+	listingURL, err := bucketListingURL(source.downloadURL, source.kind, source.name)
+	if err != nil {
+		return nil, "", err
+	}
+	dir, err := parseFileURL(listingURL)
+	if err != nil {
+		return nil, "", err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, "", fmt.Errorf("listing %s: %w", dir, err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	return bucketPluginVersions(names, "", source.kind, source.name), dir, nil
+}
+
+func (source *fileSource) Download(
+	version semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	downloadURL, err := expandURLEnv(source.downloadURL)
+	if err != nil {
+		return nil, -1, err
+	}
+	dirURL, file := splitPluginURL(downloadURL, source.kind, source.name, version, opSy, arch)
+	dir, err := parseFileURL(dirURL)
+	if err != nil {
+		return nil, -1, err
+	}
+	path := filepath.Join(dir, file)
+
+	logf(1, sourceLogFields(source.kind, source.name), "%s reading from %s", source.name, path)
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, -1, classifyPluginError(ErrNotFound, err)
+		}
+		return nil, -1, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		contract.IgnoreClose(f)
+		return nil, -1, err
+	}
+	return f, stat.Size(), nil
+}
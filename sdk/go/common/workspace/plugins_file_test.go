@@ -0,0 +1,79 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestFileSource(t *testing.T) {
+	dir := t.TempDir()
+	platform := runtime.GOOS + "-" + runtime.GOARCH
+	for _, name := range []string{
+		"pulumi-resource-test-v1.0.0-" + platform + ".tar.gz",
+		"pulumi-resource-test-v1.1.0-" + platform + ".tar.gz",
+		"pulumi-resource-test-v1.2.0-beta.1-" + platform + ".tar.gz",
+		"pulumi-resource-test-v2.0.0-plan9-mips.tar.gz",
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("tarball "+name), 0600))
+	}
+	t.Setenv("PULUMI_PLUGIN_URL_DIR", "/"+strings.TrimPrefix(filepath.ToSlash(dir), "/"))
+
+	ctx := &Context{Home: t.TempDir()}
+	info, err := ctx.Plugin(PluginInfo{
+		Name:              "test",
+		Kind:              ResourcePlugin,
+		PluginDownloadURL: "file://${env:PULUMI_PLUGIN_URL_DIR}",
+	})
+	require.NoError(t, err)
+
+	// The latest version is the latest one with a tarball for this platform that isn't a prerelease.
+	latest, err := ctx.GetLatestVersion(info)
+	require.NoError(t, err)
+	assert.Equal(t, "1.1.0", latest.String())
+
+	// Tarballs are read from the directory.
+	info.Version = latest
+	body, size, err := ctx.Download(info)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.Equal(t, "tarball pulumi-resource-test-v1.1.0-"+platform+".tar.gz", string(b))
+	assert.Equal(t, int64(len(b)), size)
+
+	// Versions without tarballs aren't found.
+	v := semver.MustParse("0.9.0")
+	info.Version = &v
+	_, _, err = ctx.Download(info)
+	assert.True(t, errors.Is(err, ErrNotFound), "%v", err)
+
+	_, err = parseFileURL("file://server/share")
+	if runtime.GOOS != "windows" {
+		assert.EqualError(t, err, `file URL "file://server/share" names host "server"; only local paths are supported`)
+	}
+	_, err = parseFileURL("file:///plugins?version=1")
+	assert.EqualError(t, err, `expected file URL to be file:///path/to/dir; got "file:///plugins?version=1"`)
+}
@@ -0,0 +1,127 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+)
+
+// PluginFIPSModeEnvVar turns FIPS mode on or off, taking precedence over the `fips` setting of PluginConfigFile.
+//
+// In FIPS mode, plugins are only downloaded over TLS 1.2, with the cipher suites and curves FIPS 140 approves, and
+// from sources whose downloads are verified with approved algorithms alone. Plain HTTP requests, the peer cache, and
+// download commands, whose TLS isn't the CLI's own, are refused, as are Terraform registry providers, whose checksums
+// are signed with OpenPGP. The digests the plugin cache computes and verifies are SHA-256 in either mode.
+const PluginFIPSModeEnvVar = "PULUMI_PLUGIN_FIPS"
+
+// fipsCipherSuites are the TLS 1.2 cipher suites FIPS mode allows. TLS 1.3 isn't used, since its cipher suites can't
+// be restricted, and it would negotiate ChaCha20-Poly1305 with servers that prefer it.
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// fipsCurves are the key exchange curves FIPS mode allows.
+var fipsCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+
+// fipsMode returns true if the context is in FIPS mode.
+func (ctx *Context) fipsMode() (bool, error) {
+	if env, ok := os.LookupEnv(PluginFIPSModeEnvVar); ok && env != "" {
+		return cmdutil.IsTruthy(env), nil
+	}
+	config, err := ctx.GetPluginConfig()
+	if err != nil {
+		return false, err
+	}
+	return config.FIPS, nil
+}
+
+// errFIPSMode returns the error for something FIPS mode doesn't allow.
+func errFIPSMode(format string, args ...interface{}) error {
+	return fmt.Errorf("%s; FIPS mode is enabled by %s or the `fips` setting of %s", fmt.Sprintf(format, args...),
+		PluginFIPSModeEnvVar, PluginConfigFile)
+}
+
+// fipsTLSConfig returns a copy of config, which may be nil, that only negotiates what FIPS mode allows.
+func fipsTLSConfig(config *tls.Config) *tls.Config {
+	if config == nil {
+		config = &tls.Config{} //nolint:gosec // the versions are set below
+	} else {
+		config = config.Clone()
+	}
+	config.MinVersion, config.MaxVersion = tls.VersionTLS12, tls.VersionTLS12
+	config.CipherSuites, config.CurvePreferences = fipsCipherSuites, fipsCurves
+	return config
+}
+
+// fipsClients caches the FIPS mode clients made from each client, so their connections are reused.
+var fipsClients sync.Map // map[*http.Client]*http.Client
+
+// fipsHTTPClient returns a copy of client whose transport only negotiates what FIPS mode allows, and that refuses
+// redirects to plain HTTP. The client's transport must be an *http.Transport, or nil, so its TLS configuration can be
+// changed.
+func fipsHTTPClient(client *http.Client) (*http.Client, error) {
+	if cached, ok := fipsClients.Load(client); ok {
+		return cached.(*http.Client), nil
+	}
+
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	transport, ok := base.(*http.Transport)
+	if !ok {
+		return nil, errFIPSMode("the cipher suites of the %T transport plugins are downloaded with can't be restricted",
+			base)
+	}
+	transport = transport.Clone()
+	transport.TLSClientConfig = fipsTLSConfig(transport.TLSClientConfig)
+	fips := *client
+	fips.Transport = transport
+	fips.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if err := checkFIPSURL(req.URL); err != nil {
+			return err
+		}
+		if client.CheckRedirect != nil {
+			return client.CheckRedirect(req, via)
+		}
+		// The default policy of http.Client.
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+
+	cached, _ := fipsClients.LoadOrStore(client, &fips)
+	return cached.(*http.Client), nil
+}
+
+// checkFIPSURL returns an error if the resource at u isn't fetched over TLS.
+func checkFIPSURL(u *url.URL) error {
+	if u.Scheme != "https" {
+		return errFIPSMode("refusing to fetch %s without TLS", u.Redacted())
+	}
+	return nil
+}
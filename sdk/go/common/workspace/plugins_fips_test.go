@@ -0,0 +1,148 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// fipsTestContext returns a context in FIPS mode that trusts server.
+func fipsTestContext(t *testing.T, server *httptest.Server) *Context {
+	ctx := &Context{Home: t.TempDir(), PluginDir: t.TempDir(), HTTPClient: server.Client()}
+	writePluginConfig(t, ctx.Home, "fips: true\n")
+	return ctx
+}
+
+// fipsGet sends a GET request for url with the context's HTTP client, and returns the body of the response.
+func fipsGet(ctx *Context, url string) (string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	r, _, err := ctx.sendHTTPRequest(req)
+	if err != nil {
+		return "", err
+	}
+	defer contract.IgnoreClose(r)
+	b, err := ioutil.ReadAll(r)
+	return string(b), err
+}
+
+// roundTripperFunc is an http.RoundTripper other than *http.Transport.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestFIPSModeTLS(t *testing.T) {
+	t.Setenv(PluginFIPSModeEnvVar, "")
+
+	var negotiated *tls.ConnectionState
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, plain.URL, http.StatusFound)
+			return
+		}
+		negotiated = r.TLS
+		_, err := w.Write([]byte("ok"))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	ctx := fipsTestContext(t, server)
+	body, err := fipsGet(ctx, server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", body)
+	assert.Equal(t, uint16(tls.VersionTLS12), negotiated.Version)
+	assert.Contains(t, fipsCipherSuites, negotiated.CipherSuite)
+
+	// Plain HTTP isn't allowed, even when it's redirected to.
+	_, err = fipsGet(ctx, plain.URL)
+	assert.EqualError(t, err, "refusing to fetch "+plain.URL+" without TLS; FIPS mode is enabled by "+
+		"PULUMI_PLUGIN_FIPS or the `fips` setting of plugin-config.yaml")
+	_, err = fipsGet(ctx, server.URL+"/redirect")
+	assert.Contains(t, err.Error(), "refusing to fetch "+plain.URL+" without TLS")
+
+	// Outside of FIPS mode, both are fine.
+	t.Setenv(PluginFIPSModeEnvVar, "false")
+	_, err = fipsGet(ctx, plain.URL)
+	assert.NoError(t, err)
+	_, err = fipsGet(ctx, server.URL+"/redirect")
+	assert.NoError(t, err)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestFIPSModeRefusesNoncompliantTLS(t *testing.T) {
+	t.Setenv(PluginFIPSModeEnvVar, "")
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	ctx := fipsTestContext(t, server)
+	_, err := fipsGet(ctx, server.URL)
+	assert.Error(t, err)
+
+	// The cipher suites of transports other than *http.Transport can't be restricted.
+	ctx.HTTPClient = &http.Client{Transport: roundTripperFunc(http.DefaultTransport.RoundTrip)}
+	_, err = fipsGet(ctx, server.URL)
+	assert.EqualError(t, err, "the cipher suites of the workspace.roundTripperFunc transport plugins are downloaded "+
+		"with can't be restricted; FIPS mode is enabled by PULUMI_PLUGIN_FIPS or the `fips` setting of "+
+		"plugin-config.yaml")
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestFIPSModeRefusesNoncompliantSources(t *testing.T) {
+	t.Setenv(PluginFIPSModeEnvVar, "true")
+	t.Setenv(PluginDownloadCommandEnvVar, "")
+
+	ctx := &Context{Home: t.TempDir(), PluginDir: t.TempDir()}
+	v := semver.MustParse("1.0.0")
+	info, err := ctx.Plugin(PluginInfo{
+		Name:              "random",
+		Kind:              ResourcePlugin,
+		Version:           &v,
+		PluginDownloadURL: TerraformRegistryScheme + "registry.terraform.io/hashicorp/random",
+	})
+	require.NoError(t, err)
+	_, _, err = ctx.Download(info)
+	assert.EqualError(t, err, "resource plugin random is downloaded from a Terraform registry, whose checksums are "+
+		"signed with OpenPGP; FIPS mode is enabled by PULUMI_PLUGIN_FIPS or the `fips` setting of plugin-config.yaml")
+
+	t.Setenv(PluginDownloadCommandEnvVar, "curl -o {output} {url}")
+	_, err = ctx.delegatePluginDownloads(ctx.sendHTTPRequest)
+	assert.EqualError(t, err, "plugins can't be downloaded with the download command `curl -o {output} {url}`, "+
+		"whose TLS isn't the CLI's; FIPS mode is enabled by PULUMI_PLUGIN_FIPS or the `fips` setting of "+
+		"plugin-config.yaml")
+}
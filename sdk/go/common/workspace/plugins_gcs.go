@@ -0,0 +1,338 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// GCSScheme prefixes the PluginDownloadURL of plugins that are downloaded from a Google Cloud Storage bucket, e.g.
+// `gs://my-bucket/plugins`. Plugins are downloaded from under the prefix by the name of their tarball, like they are
+// from other PluginDownloadURLs, with Google's Application Default Credentials. Their latest version is the latest
+// version with a tarball for the current platform under the prefix.
+const GCSScheme = "gs://"
+
+const (
+	// gcsReadScope is the OAuth scope of the access tokens service accounts request.
+	gcsReadScope = "https://www.googleapis.com/auth/devstorage.read_only"
+	// googleTokenURL is the endpoint access tokens are requested from with user credentials.
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+	// googleMetadataHost is the default host of the GCE metadata server.
+	googleMetadataHost = "metadata.google.internal"
+)
+
+// gcsSource downloads plugins from a Google Cloud Storage bucket.
+type gcsSource struct {
+	name        string
+	kind        PluginKind
+	downloadURL string
+	ctx         *Context
+}
+
+func newGCSSource(name string, kind PluginKind, downloadURL string, ctx *Context) *gcsSource {
+	return &gcsSource{
+		name:        name,
+		kind:        kind,
+		downloadURL: downloadURL,
+		ctx:         ctx,
+	}
+}
+
+func (source *gcsSource) GetLatestVersion(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
+	versions, listingURL, err := source.listVersions(getHTTPResponse)
+	if err != nil {
+		return nil, err
+	}
+	return latestBucketVersion(versions, listingURL, source.kind, source.name)
+}
+
+// ListVersions implements versionListingSource, using the versions with a tarball for the current platform.
+func (source *gcsSource) ListVersions(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, error) {
+	versions, _, err := source.listVersions(getHTTPResponse)
+	return versions, err
+}
+
+// listVersions returns the versions with a tarball for the current platform, along with the URL it listed.
+func (source *gcsSource) listVersions(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, string, error) {
+	listingURL, err := bucketListingURL(source.downloadURL, source.kind, source.name)
+	if err != nil {
+		return nil, "", err
+	}
+	bucket, prefix, err := parseGCSURL(listingURL)
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := source.accessToken(bucket)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var keys []string
+	query := url.Values{
+		"prefix": {bucketObjectKey(prefix, fmt.Sprintf("pulumi-%s-%s-v", source.kind, source.name))},
+		"fields": {"items(name),nextPageToken"},
+	}
+	for {
+		req, err := http.NewRequest(http.MethodGet,
+			fmt.Sprintf("%s/storage/v1/b/%s/o?%s", gcsEndpoint(), url.PathEscape(bucket), query.Encode()), nil)
+		if err != nil {
+			return nil, "", err
+		}
+		req.Header.Set("Accept", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = getBucketListing(req, getHTTPResponse, func(b []byte) error { return json.Unmarshal(b, &page) })
+		if err != nil {
+			return nil, "", fmt.Errorf("listing %s: %w", listingURL, err)
+		}
+		for _, item := range page.Items {
+			keys = append(keys, item.Name)
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+
+	return bucketPluginVersions(keys, prefix, source.kind, source.name), listingURL, nil
+}
+
+func (source *gcsSource) Download(
+	version semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	downloadURL, err := expandURLEnv(source.downloadURL)
+	if err != nil {
+		return nil, -1, err
+	}
+	dir, file := splitPluginURL(downloadURL, source.kind, source.name, version, opSy, arch)
+	bucket, prefix, err := parseGCSURL(dir)
+	if err != nil {
+		return nil, -1, err
+	}
+	object := bucketObjectKey(prefix, file)
+	logf(1, sourceLogFields(source.kind, source.name), "%s downloading from %s%s/%s", source.name, GCSScheme, bucket,
+		object)
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", gcsEndpoint(),
+		url.PathEscape(bucket), url.PathEscape(object)), nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	req.Header.Set("Accept", "application/octet-stream")
+	token, err := source.accessToken(bucket)
+	if err != nil {
+		return nil, -1, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return getHTTPResponse(req)
+}
+
+// accessToken returns the access token requests for objects in the bucket are sent with, or "" if there are no
+// credentials, so only public buckets can be read.
+func (source *gcsSource) accessToken(bucket string) (string, error) {
+	token, err := source.ctx.getGoogleAccessToken()
+	if err != nil {
+		return "", fmt.Errorf("getting Google credentials to download from %s%s: %w", GCSScheme, bucket, err)
+	}
+	if token == "" {
+		logf(5, sourceLogFields(source.kind, source.name), "no Google credentials found; reading %s%s anonymously",
+			GCSScheme, bucket)
+	}
+	return token, nil
+}
+
+// parseGCSURL parses a `gs://bucket/prefix` PluginDownloadURL.
+func parseGCSURL(rawURL string) (string, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme+"://" != GCSScheme || u.Host == "" || u.RawQuery != "" {
+		return "", "", fmt.Errorf("expected Google Cloud Storage URL to be %sbucket/prefix; got %q", GCSScheme, rawURL)
+	}
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}
+
+// gcsEndpoint returns the endpoint of the Cloud Storage JSON API, or of the emulator `STORAGE_EMULATOR_HOST` names.
+func gcsEndpoint() string {
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if strings.Contains(host, "://") {
+			return strings.TrimSuffix(host, "/")
+		}
+		return "http://" + host
+	}
+	return "https://storage.googleapis.com"
+}
+
+// googleCredentialsFile is the file Application Default Credentials are read from.
+type googleCredentialsFile struct {
+	Type string `json:"type"`
+
+	// The credentials of users, such as those `gcloud auth application-default login` writes.
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+
+	// The keys of service accounts.
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// getGoogleAccessToken returns an access token from Application Default Credentials, found the way Google's client
+// libraries find them: the file `GOOGLE_APPLICATION_CREDENTIALS` names, the file `gcloud` writes, and the GCE metadata
+// server. It returns "" if there are none.
+func (ctx *Context) getGoogleAccessToken() (string, error) {
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		path = wellKnownGoogleCredentialsFile()
+		if _, err := os.Stat(path); err != nil {
+			path = ""
+		}
+	}
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		var creds googleCredentialsFile
+		if err := json.Unmarshal(b, &creds); err != nil {
+			return "", fmt.Errorf("parsing %s: %w", path, err)
+		}
+		return ctx.getGoogleFileAccessToken(creds)
+	}
+
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = googleMetadataHost
+	}
+	token, err := ctx.getOAuthToken(http.MethodGet,
+		"http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token",
+		http.Header{"Metadata-Flavor": {"Google"}}, nil)
+	if err != nil {
+		ctx.logf(5, "no Google credentials from the GCE metadata server: %v", err)
+		return "", nil
+	}
+	return token, nil
+}
+
+// wellKnownGoogleCredentialsFile returns the path of the credentials file `gcloud auth application-default login`
+// writes.
+func wellKnownGoogleCredentialsFile() string {
+	dir := os.Getenv("CLOUDSDK_CONFIG")
+	if dir == "" {
+		if runtime.GOOS == windowsGOOS {
+			dir = filepath.Join(os.Getenv("APPDATA"), "gcloud")
+		} else if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, ".config", "gcloud")
+		}
+	}
+	return filepath.Join(dir, "application_default_credentials.json")
+}
+
+// getGoogleFileAccessToken exchanges the credentials of a user or service account for an access token.
+func (ctx *Context) getGoogleFileAccessToken(creds googleCredentialsFile) (string, error) {
+	switch creds.Type {
+	case "authorized_user":
+		return ctx.getOAuthToken(http.MethodPost, googleTokenURL, nil, url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {creds.ClientID},
+			"client_secret": {creds.ClientSecret},
+			"refresh_token": {creds.RefreshToken},
+		})
+	case "service_account":
+		tokenURI := creds.TokenURI
+		if tokenURI == "" {
+			tokenURI = googleTokenURL
+		}
+		assertion, err := signGoogleJWT(creds, tokenURI, ctx.now().Unix())
+		if err != nil {
+			return "", err
+		}
+		return ctx.getOAuthToken(http.MethodPost, tokenURI, nil, url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		})
+	default:
+		return "", fmt.Errorf("unsupported type of Google credentials %q", creds.Type)
+	}
+}
+
+// signGoogleJWT returns the JWT a service account requests an access token with, issued at the given Unix time.
+func signGoogleJWT(creds googleCredentialsFile, audience string, issuedAt int64) (string, error) {
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return "", errors.New("the service account's private key is not PEM-encoded")
+	}
+	var key *rsa.PrivateKey
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		rsaKey, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return "", errors.New("the service account's private key is not an RSA key")
+		}
+		key = rsaKey
+	} else if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return "", fmt.Errorf("parsing the service account's private key: %w", err)
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": creds.PrivateKeyID})
+	contract.AssertNoError(err)
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   creds.ClientEmail,
+		"scope": gcsReadScope,
+		"aud":   audience,
+		"iat":   issuedAt,
+		"exp":   issuedAt + 3600,
+	})
+	contract.AssertNoError(err)
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucketPluginVersions(t *testing.T) {
+	t.Parallel()
+
+	platform := runtime.GOOS + "-" + runtime.GOARCH
+	versions := bucketPluginVersions([]string{
+		"plugins/pulumi-resource-test-v1.0.0-" + platform + ".tar.gz",
+		"plugins/pulumi-resource-test-v1.10.0-" + platform + ".tar.gz",
+		"plugins/pulumi-resource-test-v2.0.0-beta.1-" + platform + ".tar.gz",
+		"plugins/pulumi-resource-test-v3.0.0-plan9-mips.tar.gz",
+		"plugins/pulumi-resource-test-extra-v4.0.0-" + platform + ".tar.gz",
+		"plugins/pulumi-resource-test-v1.9.0-" + platform + ".tar.gz",
+	}, "plugins", ResourcePlugin, "test")
+	require.Len(t, versions, 4)
+	assert.Equal(t, "2.0.0-beta.1", versions[2].String())
+
+	// The latest version isn't a prerelease.
+	latest := latestReleasedVersion(versions)
+	require.NotNil(t, latest)
+	assert.Equal(t, "1.10.0", latest.String())
+
+	assert.Empty(t, bucketPluginVersions([]string{"pulumi-resource-test-v1.0.0-" + platform + ".tar.gz"},
+		"plugins", ResourcePlugin, "test"))
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestGCSSource(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	creds, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "plugins@project.iam.gserviceaccount.com",
+		"private_key_id": "key-1",
+		"private_key": string(pem.EncodeToMemory(&pem.Block{
+			Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key),
+		})),
+	})
+	require.NoError(t, err)
+	credsFile := filepath.Join(t.TempDir(), "creds.json")
+	require.NoError(t, ioutil.WriteFile(credsFile, creds, 0600))
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", credsFile)
+	t.Setenv("CLOUDSDK_CONFIG", t.TempDir())
+	t.Setenv("GCE_METADATA_HOST", "")
+	t.Setenv("STORAGE_EMULATOR_HOST", "")
+
+	platform := runtime.GOOS + "-" + runtime.GOARCH
+	var requests []*http.Request
+	respond := func(req *http.Request, body string) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	}
+	ctx := &Context{Home: t.TempDir(), HTTPClient: &http.Client{Transport: roundTripperFunc(
+		func(req *http.Request) (*http.Response, error) {
+			switch {
+			case req.URL.String() == googleTokenURL:
+				// The service account's JWT is signed with its key.
+				require.NoError(t, req.ParseForm())
+				assert.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", req.PostForm.Get("grant_type"))
+				parts := strings.Split(req.PostForm.Get("assertion"), ".")
+				require.Len(t, parts, 3)
+				signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+				require.NoError(t, err)
+				digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+				assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature))
+				return respond(req, `{"access_token": "sa-token", "expires_in": 3600}`)
+			case req.URL.Host == googleMetadataHost:
+				assert.Equal(t, "Google", req.Header.Get("Metadata-Flavor"))
+				return respond(req, `{"access_token": "gce-token"}`)
+			case req.URL.Query().Get("alt") == "media":
+				requests = append(requests, req)
+				return respond(req, "tarball")
+			}
+			requests = append(requests, req)
+			if req.URL.Query().Get("pageToken") == "" {
+				return respond(req, fmt.Sprintf(`{"items": [
+					{"name": "plugins/pulumi-resource-test-v1.0.0-%[1]s.tar.gz"},
+					{"name": "plugins/pulumi-resource-test-v1.5.0-alpha-%[1]s.tar.gz"}
+				], "nextPageToken": "two"}`, platform))
+			}
+			return respond(req, fmt.Sprintf(`{"items": [{"name": "plugins/pulumi-resource-test-v1.2.0-%s.tar.gz"}]}`,
+				platform))
+		})}}
+	info, err := ctx.Plugin(PluginInfo{
+		Name:              "test",
+		Kind:              ResourcePlugin,
+		PluginDownloadURL: "gs://my-bucket/plugins/",
+	})
+	require.NoError(t, err)
+
+	// The latest version is found by listing the tarballs under the prefix, page by page.
+	latest, err := ctx.GetLatestVersion(info)
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.0", latest.String())
+	require.Len(t, requests, 2)
+	assert.Equal(t, "/storage/v1/b/my-bucket/o", requests[0].URL.Path)
+	assert.Equal(t, "plugins/pulumi-resource-test-v", requests[0].URL.Query().Get("prefix"))
+	assert.Equal(t, "two", requests[1].URL.Query().Get("pageToken"))
+	assert.Equal(t, "Bearer sa-token", requests[1].Header.Get("Authorization"))
+
+	// Tarballs are downloaded with the service account's token.
+	info.Version = latest
+	body, _, err := ctx.Download(info)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.Equal(t, "tarball", string(b))
+	req := requests[len(requests)-1]
+	assert.Equal(t, "/storage/v1/b/my-bucket/o/"+url.PathEscape("plugins/pulumi-resource-test-v1.2.0-"+platform+
+		".tar.gz"), req.URL.EscapedPath())
+	assert.Equal(t, "Bearer sa-token", req.Header.Get("Authorization"))
+
+	// Without a credentials file, the token of the GCE metadata server is used.
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	_, _, err = ctx.Download(info)
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer gce-token", requests[len(requests)-1].Header.Get("Authorization"))
+
+	// URLs with query parameters aren't Cloud Storage URLs.
+	info.PluginDownloadURL = "gs://my-bucket?versioned=true"
+	_, _, err = ctx.Download(info)
+	assert.EqualError(t, err,
+		`expected Google Cloud Storage URL to be gs://bucket/prefix; got "gs://my-bucket?versioned=true"`)
+}
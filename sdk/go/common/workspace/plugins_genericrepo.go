@@ -0,0 +1,357 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+const (
+	// ArtifactoryScheme prefixes the PluginDownloadURL of plugins that are downloaded from a JFrog Artifactory generic
+	// repository, e.g. `artifactory://example.jfrog.io/artifactory/pulumi-plugins/providers`, which names the
+	// `providers` folder of the `pulumi-plugins` repository. Requests are sent with the access token
+	// `ARTIFACTORY_ACCESS_TOKEN` sets or, failing that, the API key `ARTIFACTORY_API_KEY` sets.
+	ArtifactoryScheme = "artifactory://"
+	// NexusScheme prefixes the PluginDownloadURL of plugins that are downloaded from a Sonatype Nexus raw repository,
+	// e.g. `nexus://nexus.example.com/repository/pulumi-plugins/providers`. Requests are sent with the user token
+	// `NEXUS_USER_TOKEN` sets, as `<name code>:<pass code>`.
+	NexusScheme = "nexus://"
+)
+
+// genericRepoSource downloads plugins from a generic repository of an Artifactory or Nexus server. Plugins are
+// downloaded over HTTPS from under the URL by the name of their tarball, like they are from other PluginDownloadURLs,
+// and verified against the SHA-256 digest the server returns in the X-Checksum-Sha256 header, if it returns one. Their
+// latest version is the latest version with a tarball for the current platform in the repository's index.
+type genericRepoSource struct {
+	name        string
+	kind        PluginKind
+	scheme      string
+	downloadURL string
+	ctx         *Context
+}
+
+func newGenericRepoSource(name string, kind PluginKind, scheme, downloadURL string, ctx *Context) *genericRepoSource {
+	return &genericRepoSource{
+		name:        name,
+		kind:        kind,
+		scheme:      scheme,
+		downloadURL: downloadURL,
+		ctx:         ctx,
+	}
+}
+
+// genericRepoURL is a folder of a generic repository, as named by a PluginDownloadURL.
+type genericRepoURL struct {
+	// origin is the server's `https://host` origin.
+	origin string
+	// context is the path the server is served under, such as `/artifactory`, if any.
+	context string
+	// repo is the name of the repository.
+	repo string
+	// path is the folder's path in the repository, with no leading or trailing slashes.
+	path string
+}
+
+// folderURL returns the HTTPS URL of the folder.
+func (u genericRepoURL) folderURL(scheme string) string {
+	repoPath := "/repository"
+	if scheme == ArtifactoryScheme {
+		repoPath = ""
+	}
+	return u.origin + u.context + repoPath + "/" + url.PathEscape(u.repo) + "/" + u.path
+}
+
+// parseGenericRepoURL parses a PluginDownloadURL that names a folder of a generic repository. Artifactory serves
+// repositories at `/<repo>` under its context path, which ends with `/artifactory` if the URL has such a segment and is
+// empty otherwise, and Nexus serves them at `/repository/<repo>` under its own.
+func parseGenericRepoURL(scheme, rawURL string) (genericRepoURL, error) {
+	u, err := url.Parse(rawURL)
+	segments := []string{}
+	if err == nil {
+		if trimmed := strings.Trim(u.Path, "/"); trimmed != "" {
+			segments = strings.Split(trimmed, "/")
+		}
+	}
+
+	// Find the segment the repository's name follows.
+	marker := "repository"
+	if scheme == ArtifactoryScheme {
+		marker = "artifactory"
+	}
+	start := 0
+	for i, segment := range segments {
+		if segment == marker {
+			start = i + 1
+			break
+		}
+	}
+	if scheme == NexusScheme && start == 0 {
+		start = len(segments)
+	}
+
+	if err != nil || u.Scheme+"://" != scheme || u.Host == "" || start >= len(segments) || u.RawQuery != "" {
+		format := "%shost/artifactory/repo/path"
+		if scheme == NexusScheme {
+			format = "%shost/repository/repo/path"
+		}
+		return genericRepoURL{}, fmt.Errorf("expected %s URL to be "+format+"; got %q",
+			genericRepoServerName(scheme), scheme, rawURL)
+	}
+	// Artifactory's context path ends with the marker, but Nexus's ends before it.
+	context := segments[:start]
+	if scheme == NexusScheme {
+		context = segments[:start-1]
+	}
+	contextPath := ""
+	if len(context) > 0 {
+		contextPath = "/" + strings.Join(context, "/")
+	}
+	return genericRepoURL{
+		origin:  "https://" + u.Host,
+		context: contextPath,
+		repo:    segments[start],
+		path:    strings.Join(segments[start+1:], "/"),
+	}, nil
+}
+
+// genericRepoServerName returns the name of the server URLs with the given scheme are on, for use in messages.
+func genericRepoServerName(scheme string) string {
+	if scheme == ArtifactoryScheme {
+		return "Artifactory"
+	}
+	return "Nexus"
+}
+
+// newRequest returns a request for the given URL, with the source's credentials if the URL is on its server.
+func (source *genericRepoSource) newRequest(method, requestURL, accept string, origin string) (*http.Request, error) {
+	req, err := buildHTTPRequest(requestURL, "")
+	if err != nil {
+		return nil, err
+	}
+	req.Method = method
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if !sameOrigin(requestURL, origin) {
+		return req, nil
+	}
+	switch source.scheme {
+	case ArtifactoryScheme:
+		if token := os.Getenv("ARTIFACTORY_ACCESS_TOKEN"); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else if key := os.Getenv("ARTIFACTORY_API_KEY"); key != "" {
+			req.Header.Set("X-JFrog-Art-Api", key)
+		}
+	case NexusScheme:
+		if token := os.Getenv("NEXUS_USER_TOKEN"); token != "" {
+			req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(token)))
+		}
+	}
+	return req, nil
+}
+
+// tarballURL returns the parsed URL of the folder the tarball of the given version and platform is in, along with the
+// tarball's URL.
+func (source *genericRepoSource) tarballURL(version semver.Version, opSy, arch string) (genericRepoURL, string, error) {
+	downloadURL, err := expandURLEnv(source.downloadURL)
+	if err != nil {
+		return genericRepoURL{}, "", err
+	}
+	dir, file := splitPluginURL(downloadURL, source.kind, source.name, version, opSy, arch)
+	folder, err := parseGenericRepoURL(source.scheme, dir)
+	if err != nil {
+		return genericRepoURL{}, "", err
+	}
+	tarball := strings.TrimSuffix(folder.folderURL(source.scheme), "/") + "/" + file
+	return folder, tarball, nil
+}
+
+func (source *genericRepoSource) GetLatestVersion(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
+	versions, listingURL, err := source.listVersions(getHTTPResponse)
+	if err != nil {
+		return nil, err
+	}
+	return latestBucketVersion(versions, listingURL, source.kind, source.name)
+}
+
+// ListVersions implements versionListingSource, using the versions with a tarball for the current platform.
+func (source *genericRepoSource) ListVersions(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, error) {
+	versions, _, err := source.listVersions(getHTTPResponse)
+	return versions, err
+}
+
+// listVersions returns the versions with a tarball for the current platform, along with the URL it listed.
+func (source *genericRepoSource) listVersions(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, string, error) {
+	listingURL, err := bucketListingURL(source.downloadURL, source.kind, source.name)
+	if err != nil {
+		return nil, "", err
+	}
+	folder, err := parseGenericRepoURL(source.scheme, listingURL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var keys []string
+	prefix := folder.path
+	if source.scheme == ArtifactoryScheme {
+		// Artifactory's storage API lists the folder's children by their names.
+		keys, err = source.listArtifactoryFolder(folder, getHTTPResponse)
+		prefix = ""
+	} else {
+		keys, err = source.listNexusAssets(folder, getHTTPResponse)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("listing %s: %w", listingURL, err)
+	}
+
+	return bucketPluginVersions(keys, prefix, source.kind, source.name), listingURL, nil
+}
+
+// listArtifactoryFolder returns the names of the files in a folder of an Artifactory repository.
+func (source *genericRepoSource) listArtifactoryFolder(folder genericRepoURL,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]string, error) {
+	req, err := source.newRequest(http.MethodGet,
+		fmt.Sprintf("%s%s/api/storage/%s/%s", folder.origin, folder.context, url.PathEscape(folder.repo), folder.path),
+		"application/json", folder.origin)
+	if err != nil {
+		return nil, err
+	}
+	var info struct {
+		Children []struct {
+			URI    string `json:"uri"`
+			Folder bool   `json:"folder"`
+		} `json:"children"`
+	}
+	err = getBucketListing(req, getHTTPResponse, func(b []byte) error { return json.Unmarshal(b, &info) })
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(info.Children))
+	for _, child := range info.Children {
+		if !child.Folder {
+			names = append(names, strings.TrimPrefix(child.URI, "/"))
+		}
+	}
+	return names, nil
+}
+
+// listNexusAssets returns the paths of the assets in a Nexus repository, page by page.
+func (source *genericRepoSource) listNexusAssets(folder genericRepoURL,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]string, error) {
+	var paths []string
+	query := url.Values{"repository": {folder.repo}}
+	for {
+		req, err := source.newRequest(http.MethodGet,
+			fmt.Sprintf("%s%s/service/rest/v1/assets?%s", folder.origin, folder.context, query.Encode()),
+			"application/json", folder.origin)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				Path string `json:"path"`
+			} `json:"items"`
+			ContinuationToken string `json:"continuationToken"`
+		}
+		err = getBucketListing(req, getHTTPResponse, func(b []byte) error { return json.Unmarshal(b, &page) })
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			paths = append(paths, strings.TrimPrefix(item.Path, "/"))
+		}
+		if page.ContinuationToken == "" {
+			return paths, nil
+		}
+		query.Set("continuationToken", page.ContinuationToken)
+	}
+}
+
+// Checksum implements checksumSource, using the digest the server returns in the X-Checksum-Sha256 header of a HEAD
+// request for the tarball, and the length it returns.
+func (source *genericRepoSource) Checksum(version semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (string, int64, error) {
+	folder, tarballURL, err := source.tarballURL(version, opSy, arch)
+	if err != nil {
+		return "", -1, err
+	}
+	req, err := source.newRequest(http.MethodHead, tarballURL, "", folder.origin)
+	if err != nil {
+		return "", -1, err
+	}
+	client, err := source.ctx.httpClient(req)
+	if err != nil {
+		return "", -1, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", -1, classifyNetworkError(err)
+	}
+	contract.IgnoreClose(resp.Body)
+
+	// Leave errors to the download, which reports them like other sources do.
+	digest := strings.ToLower(resp.Header.Get("X-Checksum-Sha256"))
+	if resp.StatusCode != http.StatusOK || len(digest) != 64 {
+		return "", -1, nil
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return "", -1, nil
+	}
+	return digest, resp.ContentLength, nil
+}
+
+func (source *genericRepoSource) Download(
+	version semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	folder, tarballURL, err := source.tarballURL(version, opSy, arch)
+	if err != nil {
+		return nil, -1, err
+	}
+	expected, _, err := source.Checksum(version, opSy, arch, getHTTPResponse)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	logf(1, sourceLogFields(source.kind, source.name), "%s downloading from %s", source.name, tarballURL)
+	req, err := source.newRequest(http.MethodGet, tarballURL, "application/octet-stream", folder.origin)
+	if err != nil {
+		return nil, -1, err
+	}
+	resp, length, err := getHTTPResponse(req)
+	if err != nil {
+		return nil, -1, err
+	}
+	if expected == "" {
+		logf(9, sourceLogFields(source.kind, source.name), "%s returned no checksum for %s",
+			genericRepoServerName(source.scheme), tarballURL)
+		return resp, length, nil
+	}
+	return withExtractedSizeOf(newChecksumVerifyingReader(resp, expected, tarballURL), resp), length, nil
+}
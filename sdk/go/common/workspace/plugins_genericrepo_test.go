@@ -0,0 +1,181 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestArtifactorySource(t *testing.T) {
+	t.Setenv("ARTIFACTORY_ACCESS_TOKEN", "")
+	t.Setenv("ARTIFACTORY_API_KEY", "secret-key")
+
+	platform := runtime.GOOS + "-" + runtime.GOARCH
+	digest := sha256.Sum256([]byte("tarball"))
+	checksum := hex.EncodeToString(digest[:])
+	var requests []*http.Request
+	ctx := &Context{Home: t.TempDir(), HTTPClient: &http.Client{Transport: roundTripperFunc(
+		func(req *http.Request) (*http.Response, error) {
+			requests = append(requests, req)
+			resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req}
+			body := ""
+			switch req.URL.Path {
+			case "/artifactory/api/storage/pulumi-plugins/providers":
+				body = fmt.Sprintf(`{"children": [
+					{"uri": "/pulumi-resource-test-v1.0.0-%[1]s.tar.gz", "folder": false},
+					{"uri": "/pulumi-resource-test-v1.1.0-%[1]s.tar.gz", "folder": false},
+					{"uri": "/pulumi-resource-test-v1.2.0-rc.1-%[1]s.tar.gz", "folder": false},
+					{"uri": "/pulumi-resource-test-v9.0.0-%[1]s.tar.gz", "folder": true}
+				]}`, platform)
+			default:
+				resp.Header.Set("X-Checksum-Sha256", checksum)
+				if strings.Contains(req.URL.Path, "v1.0.0") {
+					body = "corrupted"
+				} else {
+					body = "tarball"
+				}
+			}
+			resp.Body = ioutil.NopCloser(strings.NewReader(body))
+			return resp, nil
+		})}}
+	info, err := ctx.Plugin(PluginInfo{
+		Name:              "test",
+		Kind:              ResourcePlugin,
+		PluginDownloadURL: "artifactory://example.jfrog.io/artifactory/pulumi-plugins/providers/",
+	})
+	require.NoError(t, err)
+
+	// The latest version is found by listing the folder with the storage API.
+	latest, err := ctx.GetLatestVersion(info)
+	require.NoError(t, err)
+	assert.Equal(t, "1.1.0", latest.String())
+	assert.Equal(t, "secret-key", requests[0].Header.Get("X-JFrog-Art-Api"))
+
+	// Tarballs are verified against the checksum the server returns for them.
+	info.Version = latest
+	body, _, err := ctx.Download(info)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.Equal(t, "tarball", string(b))
+	require.Len(t, requests, 3)
+	assert.Equal(t, http.MethodHead, requests[1].Method)
+	assert.Equal(t, "/artifactory/pulumi-plugins/providers/pulumi-resource-test-v1.1.0-"+platform+".tar.gz",
+		requests[2].URL.Path)
+	assert.Equal(t, "secret-key", requests[2].Header.Get("X-JFrog-Art-Api"))
+
+	v := semver.MustParse("1.0.0")
+	info.Version = &v
+	body, _, err = ctx.Download(info)
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(body)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch), "%v", err)
+	require.NoError(t, body.Close())
+
+	// Access tokens take precedence over API keys.
+	t.Setenv("ARTIFACTORY_ACCESS_TOKEN", "secret-token")
+	info.Version = latest
+	_, _, err = ctx.Download(info)
+	require.NoError(t, err)
+	req := requests[len(requests)-1]
+	assert.Equal(t, "Bearer secret-token", req.Header.Get("Authorization"))
+	assert.Empty(t, req.Header.Get("X-JFrog-Art-Api"))
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestNexusSource(t *testing.T) {
+	t.Setenv("NEXUS_USER_TOKEN", "name:pass")
+
+	platform := runtime.GOOS + "-" + runtime.GOARCH
+	var requests []*http.Request
+	ctx := &Context{Home: t.TempDir(), HTTPClient: &http.Client{Transport: roundTripperFunc(
+		func(req *http.Request) (*http.Response, error) {
+			requests = append(requests, req)
+			resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req}
+			body := "tarball"
+			if req.URL.Path == "/nexus/service/rest/v1/assets" {
+				if req.URL.Query().Get("continuationToken") == "" {
+					body = fmt.Sprintf(`{"items": [
+						{"path": "providers/pulumi-resource-test-v1.0.0-%[1]s.tar.gz"},
+						{"path": "other/pulumi-resource-test-v3.0.0-%[1]s.tar.gz"}
+					], "continuationToken": "two"}`, platform)
+				} else {
+					body = fmt.Sprintf(`{"items": [{"path": "/providers/pulumi-resource-test-v2.0.0-%s.tar.gz"}]}`,
+						platform)
+				}
+			}
+			resp.Body = ioutil.NopCloser(strings.NewReader(body))
+			return resp, nil
+		})}}
+	info, err := ctx.Plugin(PluginInfo{
+		Name:              "test",
+		Kind:              ResourcePlugin,
+		PluginDownloadURL: "nexus://nexus.example.com/nexus/repository/pulumi-plugins/providers",
+	})
+	require.NoError(t, err)
+
+	// The latest version is found by listing the repository's assets under the folder, page by page.
+	latest, err := ctx.GetLatestVersion(info)
+	require.NoError(t, err)
+	assert.Equal(t, "2.0.0", latest.String())
+	require.Len(t, requests, 2)
+	assert.Equal(t, "pulumi-plugins", requests[0].URL.Query().Get("repository"))
+	assert.Equal(t, "Basic bmFtZTpwYXNz", requests[1].Header.Get("Authorization"))
+
+	// Tarballs without checksums are downloaded unverified.
+	info.Version = latest
+	body, _, err := ctx.Download(info)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.Equal(t, "tarball", string(b))
+	assert.Equal(t, "/nexus/repository/pulumi-plugins/providers/pulumi-resource-test-v2.0.0-"+platform+".tar.gz",
+		requests[len(requests)-1].URL.Path)
+}
+
+func TestParseGenericRepoURL(t *testing.T) {
+	t.Parallel()
+
+	u, err := parseGenericRepoURL(ArtifactoryScheme, "artifactory://artifacts.example.com/plugins")
+	require.NoError(t, err)
+	assert.Equal(t, genericRepoURL{origin: "https://artifacts.example.com", repo: "plugins"}, u)
+	assert.Equal(t, "https://artifacts.example.com/plugins/", u.folderURL(ArtifactoryScheme))
+
+	u, err = parseGenericRepoURL(NexusScheme, "nexus://nexus.example.com/repository/plugins/a/b")
+	require.NoError(t, err)
+	assert.Equal(t, genericRepoURL{origin: "https://nexus.example.com", repo: "plugins", path: "a/b"}, u)
+
+	_, err = parseGenericRepoURL(NexusScheme, "nexus://nexus.example.com/plugins")
+	assert.EqualError(t, err,
+		`expected Nexus URL to be nexus://host/repository/repo/path; got "nexus://nexus.example.com/plugins"`)
+	_, err = parseGenericRepoURL(ArtifactoryScheme, "artifactory://example.jfrog.io/artifactory")
+	assert.EqualError(t, err, `expected Artifactory URL to be artifactory://host/artifactory/repo/path; `+
+		`got "artifactory://example.jfrog.io/artifactory"`)
+}
@@ -0,0 +1,258 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/archive"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// GitScheme prefixes the PluginDownloadURL of plugins that are built from a git repository instead of downloaded,
+// e.g. `git+https://github.com/pulumi/pulumi-aws@master`. The repository is cloned at the ref after the `@`, which
+// may contain the same placeholders as other PluginDownloadURLs, or at the tag `v${VERSION}` if there's no ref. It's
+// built as its PulumiPlugin.yaml's build section declares, and the result is installed as the plugin's version.
+// The plugin's versions are those of the repository's tags.
+const GitScheme = "git+"
+
+// PluginBuild is how a plugin is built from its source when it's installed from a git repository, as declared by the
+// PulumiPlugin.yaml at the root of the repository.
+type PluginBuild struct {
+	// Command is the command to run, and its arguments, at the root of the repository. `GOOS` and `GOARCH` are set to
+	// the platform the plugin is built for. It defaults to building the Go package
+	// `./provider/cmd/pulumi-<kind>-<name>` into Output, the layout of Pulumi's own providers.
+	Command []string `json:"command,omitempty" yaml:"command,omitempty"`
+	// Output is the directory the command builds the plugin into, relative to the root of the repository. Its
+	// contents are installed as the plugin. It defaults to `bin`.
+	Output string `json:"output,omitempty" yaml:"output,omitempty"`
+}
+
+// validate checks the build section.
+func (build *PluginBuild) validate() error {
+	if len(build.Command) > 0 && build.Command[0] == "" {
+		return errors.New("build.command must not be empty")
+	}
+	output := filepath.Clean(filepath.FromSlash(build.Output))
+	if filepath.IsAbs(output) || output == ".." || strings.HasPrefix(output, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("build.output: %q is not a directory inside the repository", build.Output)
+	}
+	return nil
+}
+
+// gitSource builds a plugin from a git repository.
+type gitSource struct {
+	name        string
+	kind        PluginKind
+	downloadURL string
+}
+
+func newGitSource(name string, kind PluginKind, downloadURL string) *gitSource {
+	return &gitSource{
+		name:        name,
+		kind:        kind,
+		downloadURL: downloadURL,
+	}
+}
+
+// parseGitURL returns the URL of the repository a `git+` URL names, and the ref after its `@`, if it has one. Refs
+// that start with `-` are rejected, since git would read them as options.
+func parseGitURL(rawURL string) (string, string, error) {
+	u, err := url.Parse(strings.TrimPrefix(rawURL, GitScheme))
+	if err != nil || !strings.HasPrefix(rawURL, GitScheme) || u.Scheme == "" || u.Path == "" {
+		return "", "", fmt.Errorf("expected git URL to be %shttps://host/repo@ref; got %q", GitScheme, rawURL)
+	}
+	ref := ""
+	if at := strings.Index(u.Path, "@"); at >= 0 {
+		u.Path, ref = u.Path[:at], u.Path[at+1:]
+		u.RawPath = ""
+		if ref == "" {
+			return "", "", fmt.Errorf("git URL %q has an empty ref", rawURL)
+		} else if strings.HasPrefix(ref, "-") {
+			return "", "", fmt.Errorf("git URL %q has a ref that starts with -", rawURL)
+		}
+	}
+	return u.String(), ref, nil
+}
+
+// runGit runs git with the given arguments in dir, returning what it wrote to stdout.
+func runGit(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	// Never prompt for credentials: builds run unattended.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func (source *gitSource) GetLatestVersion(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
+	versions, err := source.ListVersions(getHTTPResponse)
+	if err != nil {
+		return nil, err
+	}
+	latest := latestReleasedVersion(versions)
+	if latest == nil {
+		return nil, fmt.Errorf("the repository of %s plugin %s has no tags of released versions", source.kind,
+			source.name)
+	}
+	return latest, nil
+}
+
+// ListVersions implements versionListingSource, using the versions of the repository's tags.
+func (source *gitSource) ListVersions(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, error) {
+	downloadURL, err := expandURLEnv(source.downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	repo, _, err := parseGitURL(downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	out, err := runGit("", "ls-remote", "--tags", "--refs", "--", repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []semver.Version
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "refs/tags/v") {
+			continue
+		}
+		if version, err := semver.ParseTolerant(strings.TrimPrefix(fields[1], "refs/tags/")); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
+
+func (source *gitSource) Download(
+	version semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	downloadURL, err := expandURLEnv(source.downloadURL)
+	if err != nil {
+		return nil, -1, err
+	}
+	repo, ref, err := parseGitURL(interpolateURL(downloadURL, source.kind, source.name, version, opSy, arch))
+	if err != nil {
+		return nil, -1, err
+	}
+	if ref == "" {
+		ref = "v" + version.String()
+	}
+
+	dir, err := ioutil.TempDir("", "pulumi-plugin-git-")
+	if err != nil {
+		return nil, -1, err
+	}
+	defer func() { contract.IgnoreError(os.RemoveAll(dir)) }()
+
+	logf(1, sourceLogFields(source.kind, source.name), "%s cloning %s at %s", source.name, repo, ref)
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"fetch", "--quiet", "--depth", "1", "--", repo, ref},
+		{"checkout", "--quiet", "FETCH_HEAD"},
+	} {
+		if _, err := runGit(dir, args...); err != nil {
+			err = fmt.Errorf("cloning %s at %s: %w", repo, ref, err)
+			if strings.Contains(err.Error(), "couldn't find remote ref") {
+				err = classifyPluginError(ErrNotFound, err)
+			}
+			return nil, -1, err
+		}
+	}
+
+	tarball, err := source.build(dir, version, opSy, arch)
+	if err != nil {
+		return nil, -1, fmt.Errorf("building %s plugin %s from %s at %s: %w", source.kind, source.name, repo, ref, err)
+	}
+	return ioutil.NopCloser(bytes.NewReader(tarball)), int64(len(tarball)), nil
+}
+
+// build builds the plugin in the repository cloned into dir, as its PulumiPlugin.yaml declares, returning a tarball of
+// the result.
+func (source *gitSource) build(dir string, version semver.Version, opSy, arch string) ([]byte, error) {
+	var build PluginBuild
+	proj, err := LoadPluginProject(filepath.Join(dir, "PulumiPlugin.yaml"))
+	switch {
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("loading PulumiPlugin.yaml: %w", err)
+	case err == nil && proj.Build != nil:
+		build = *proj.Build
+	}
+
+	// Go doesn't know the libc of an asset's OS, such as `linux-musl`, so it's left out of the GOOS the build gets.
+	goos := assetPlatform(opSy, arch).OS
+	binary := fmt.Sprintf("pulumi-%s-%s", source.kind, source.name)
+	if build.Output == "" {
+		build.Output = "bin"
+	}
+	if len(build.Command) == 0 {
+		output := filepath.ToSlash(filepath.Join(build.Output, binary))
+		if goos == windowsGOOS {
+			output += ".exe"
+		}
+		build.Command = []string{"go", "build", "-o", output, "./provider/cmd/" + binary}
+	}
+	if err := build.validate(); err != nil {
+		return nil, err
+	}
+	var declared map[string]string
+	if proj != nil {
+		declared = proj.Env
+	}
+	env, err := pluginEnvironment(source.kind, source.name, declared)
+	if err != nil {
+		return nil, err
+	}
+
+	logf(1, sourceLogFields(source.kind, source.name), "%s building %s for %s/%s with `%s`", source.name, version,
+		opSy, arch, strings.Join(build.Command, " "))
+	cmd := exec.Command(build.Command[0], build.Command[1:]...) //nolint:gosec // the build the repository declares
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), env...), "GOOS="+goos, "GOARCH="+arch)
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("`%s` failed: %w\n%s", strings.Join(build.Command, " "), err,
+			strings.TrimSpace(output.String()))
+	}
+
+	outputDir := filepath.Join(dir, filepath.FromSlash(build.Output))
+	if entries, err := ioutil.ReadDir(outputDir); err != nil || len(entries) == 0 {
+		return nil, fmt.Errorf("the build didn't produce anything in %s", build.Output)
+	}
+	return archive.TGZ(outputDir, "", false /*useDefaultExcludes*/)
+}
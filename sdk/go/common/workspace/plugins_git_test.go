@@ -0,0 +1,137 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/archive"
+)
+
+func TestParseGitURL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		url  string
+		repo string
+		ref  string
+	}{
+		{"git+https://github.com/pulumi/pulumi-aws", "https://github.com/pulumi/pulumi-aws", ""},
+		{"git+https://github.com/pulumi/pulumi-aws@feature/x", "https://github.com/pulumi/pulumi-aws", "feature/x"},
+		{"git+ssh://git@github.com/pulumi/pulumi-aws.git@v${VERSION}", "ssh://git@github.com/pulumi/pulumi-aws.git",
+			"v${VERSION}"},
+		{"git+file:///src/pulumi-aws@0a1b2c3", "file:///src/pulumi-aws", "0a1b2c3"},
+	}
+	for _, tt := range tests {
+		repo, ref, err := parseGitURL(tt.url)
+		require.NoError(t, err, tt.url)
+		assert.Equal(t, tt.repo, repo)
+		assert.Equal(t, tt.ref, ref)
+	}
+
+	_, _, err := parseGitURL("git+github.com/pulumi/pulumi-aws")
+	assert.EqualError(t, err,
+		`expected git URL to be git+https://host/repo@ref; got "git+github.com/pulumi/pulumi-aws"`)
+	_, _, err = parseGitURL("git+https://github.com/pulumi/pulumi-aws@")
+	assert.EqualError(t, err, `git URL "git+https://github.com/pulumi/pulumi-aws@" has an empty ref`)
+	_, _, err = parseGitURL("git+https://github.com/pulumi/pulumi-aws@--upload-pack=touch")
+	assert.EqualError(t, err,
+		`git URL "git+https://github.com/pulumi/pulumi-aws@--upload-pack=touch" has a ref that starts with -`)
+}
+
+func TestGitSource(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == windowsGOOS {
+		t.Skip("the test repository builds with a shell script")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+
+	// A repository whose build writes the platform it's built for into the plugin's "binary".
+	repo := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(repo, "PulumiPlugin.yaml"),
+		[]byte("build:\n  command: [sh, build.sh]\n  output: out\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(repo, "build.sh"),
+		[]byte("mkdir -p out && echo \"$GOOS/$GOARCH\" > out/pulumi-resource-test\n"), 0600))
+	git := func(args ...string) {
+		_, err := runGit(repo, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"},
+			args...)...)
+		require.NoError(t, err)
+	}
+	git("init", "--quiet")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "v1")
+	git("tag", "v1.0.0")
+	git("tag", "v1.1.0-beta.1")
+	git("tag", "not-a-version")
+
+	source, ok := (PluginInfo{
+		Name: "test", Kind: ResourcePlugin, PluginDownloadURL: GitScheme + "file://" + repo,
+	}).GetSource().(*gitSource)
+	require.True(t, ok)
+
+	// The plugin's versions are those of the repository's tags.
+	versions, err := source.ListVersions(nil)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []semver.Version{semver.MustParse("1.0.0"), semver.MustParse("1.1.0-beta.1")}, versions)
+	latest, err := source.GetLatestVersion(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", latest.String())
+
+	// Versions are built from their tags, for the platform they're downloaded for.
+	r, _, err := source.Download(*latest, "linux", "arm64", nil)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, archive.ExtractTGZ(r, dir))
+	b, err := ioutil.ReadFile(filepath.Join(dir, "pulumi-resource-test"))
+	require.NoError(t, err)
+	assert.Equal(t, "linux/arm64\n", string(b))
+
+	// Builds for musl get the GOOS Go knows.
+	r, _, err = source.Download(*latest, "linux-musl", "arm64", nil)
+	require.NoError(t, err)
+	dir = t.TempDir()
+	require.NoError(t, archive.ExtractTGZ(r, dir))
+	b, err = ioutil.ReadFile(filepath.Join(dir, "pulumi-resource-test"))
+	require.NoError(t, err)
+	assert.Equal(t, "linux/arm64\n", string(b))
+
+	// Versions without a tag aren't found, unless the URL names the ref to build.
+	_, _, err = source.Download(semver.MustParse("2.0.0"), "linux", "arm64", nil)
+	assert.True(t, errors.Is(err, ErrNotFound), "%v", err)
+	source.downloadURL += "@v1.0.0"
+	_, _, err = source.Download(semver.MustParse("2.0.0"), "linux", "arm64", nil)
+	assert.NoError(t, err)
+
+	// Builds that fail report their output.
+	require.NoError(t, os.Remove(filepath.Join(repo, "build.sh")))
+	git("commit", "--quiet", "-am", "no build")
+	git("tag", "v1.2.0")
+	source.downloadURL = GitScheme + "file://" + repo
+	_, _, err = source.Download(semver.MustParse("1.2.0"), "linux", "arm64", nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "`sh build.sh` failed")
+}
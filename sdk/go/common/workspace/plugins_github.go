@@ -0,0 +1,78 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxGitHubErrorBody bounds how much of an unsuccessful GitHub response is read looking for the reason it failed.
+const maxGitHubErrorBody = 64 << 10
+
+// diagnoseGitHubError works out why GitHub refused a plugin download from the headers and body of its response, and
+// adds the reason to err's message. GitHub answers requests for private repositories it won't show the requester
+// with a 404, so a 404 may mean the release asset doesn't exist or that a token with access is needed.
+func diagnoseGitHubError(err *HTTPError, resp *http.Response) {
+	var body struct {
+		Message string `json:"message"`
+	}
+	if b, readErr := ioutil.ReadAll(io.LimitReader(resp.Body, maxGitHubErrorBody)); readErr == nil {
+		_ = json.Unmarshal(b, &body) // the body is only a hint, so it doesn't matter if it can't be read.
+	}
+
+	// Secondary rate limits are reported with a 403 that leaves requests remaining, so the message is all there is.
+	if strings.Contains(strings.ToLower(body.Message), "rate limit") {
+		err.RateLimited = true
+	}
+	if sso := resp.Header.Get("X-GitHub-SSO"); strings.HasPrefix(sso, "required") {
+		err.SSOURL = "https://github.com"
+		if i := strings.Index(sso, "url="); i >= 0 {
+			err.SSOURL = strings.TrimSpace(sso[i+len("url="):])
+		}
+	}
+
+	var reason string
+	switch {
+	case err.RateLimited || err.StatusCode == http.StatusTooManyRequests:
+		reason = "GitHub rate limit exceeded"
+		if !err.RateLimitReset.IsZero() {
+			reason += ", resets at " + err.RateLimitReset.Format(time.RFC3339)
+		}
+		if !err.TokenSent {
+			reason += "; no GITHUB_TOKEN was sent, so the lower unauthenticated limit applies"
+		}
+	case err.SSOURL != "":
+		reason = "the GITHUB_TOKEN sent must be authorized for the organization's SAML single sign-on at " + err.SSOURL
+	case err.StatusCode == http.StatusNotFound && err.TokenSent:
+		reason = "the release or asset doesn't exist, or the GITHUB_TOKEN sent doesn't have access to the repository"
+	case err.StatusCode == http.StatusNotFound:
+		reason = "the release or asset doesn't exist, or the repository is private and no GITHUB_TOKEN was sent"
+	case (err.StatusCode == http.StatusUnauthorized || err.StatusCode == http.StatusForbidden) && err.TokenSent:
+		reason = "GitHub refused the GITHUB_TOKEN sent"
+	case err.StatusCode == http.StatusUnauthorized || err.StatusCode == http.StatusForbidden:
+		reason = "no GITHUB_TOKEN was sent"
+	}
+	if body.Message != "" && reason != "" {
+		reason += " (GitHub said: " + body.Message + ")"
+	}
+	if reason != "" {
+		err.message += ": " + reason
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnoseGitHubError(t *testing.T) {
+	t.Parallel()
+
+	reset := time.Date(2022, 3, 14, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		token    string
+		status   int
+		header   http.Header
+		body     string
+		expected string
+		kind     error
+	}{
+		{
+			name:   "rate limited without token",
+			status: 403,
+			header: http.Header{
+				"X-Ratelimit-Remaining": {"0"},
+				"X-Ratelimit-Reset":     {"1647259200"},
+			},
+			body: `{"message": "API rate limit exceeded for 192.0.2.1."}`,
+			expected: ": GitHub rate limit exceeded, resets at " + reset.Local().Format(time.RFC3339) +
+				"; no GITHUB_TOKEN was sent, so the lower unauthenticated limit applies " +
+				"(GitHub said: API rate limit exceeded for 192.0.2.1.)",
+			kind: ErrRateLimited,
+		},
+		{
+			name:     "secondary rate limit with token",
+			token:    "ghp_secret",
+			status:   403,
+			header:   http.Header{"X-Ratelimit-Remaining": {"4000"}},
+			body:     `{"message": "You have exceeded a secondary rate limit."}`,
+			expected: ": GitHub rate limit exceeded (GitHub said: You have exceeded a secondary rate limit.)",
+			kind:     ErrRateLimited,
+		},
+		{
+			name:   "sso",
+			token:  "ghp_secret",
+			status: 403,
+			header: http.Header{
+				"X-Github-Sso": {"required; url=https://github.com/orgs/acme/sso?authorization_request=abc"},
+			},
+			body: `{"message": "Resource protected by organization SAML enforcement."}`,
+			expected: ": the GITHUB_TOKEN sent must be authorized for the organization's SAML single sign-on at " +
+				"https://github.com/orgs/acme/sso?authorization_request=abc " +
+				"(GitHub said: Resource protected by organization SAML enforcement.)",
+			kind: ErrUnauthorized,
+		},
+		{
+			name:     "not found without token",
+			status:   404,
+			expected: ": the release or asset doesn't exist, or the repository is private and no GITHUB_TOKEN was sent",
+			kind:     ErrNotFound,
+		},
+		{
+			name:   "not found with token",
+			token:  "ghp_secret",
+			status: 404,
+			body:   `{"message": "Not Found"}`,
+			expected: ": the release or asset doesn't exist, or the GITHUB_TOKEN sent doesn't have access to the " +
+				"repository (GitHub said: Not Found)",
+			kind: ErrNotFound,
+		},
+		{
+			name:     "bad credentials",
+			token:    "ghp_expired",
+			status:   401,
+			body:     `{"message": "Bad credentials"}`,
+			expected: ": GitHub refused the GITHUB_TOKEN sent (GitHub said: Bad credentials)",
+			kind:     ErrUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req, err := buildHTTPRequest("https://api.github.com/repos/acme/pulumi-acme/releases/tags/v1.0.0", tt.token)
+			require.NoError(t, err)
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+			resp := &http.Response{
+				StatusCode: tt.status,
+				Header:     header,
+				Body:       ioutil.NopCloser(strings.NewReader(tt.body)),
+			}
+
+			httpErr := newHTTPError(req, resp, "HTTP error")
+			diagnoseGitHubError(httpErr, resp)
+			assert.Equal(t, "HTTP error"+tt.expected, httpErr.Error())
+			assert.Equal(t, tt.token != "", httpErr.TokenSent)
+			assert.True(t, errors.Is(httpErr, tt.kind))
+			assert.NotContains(t, httpErr.Error(), "ghp_")
+		})
+	}
+}
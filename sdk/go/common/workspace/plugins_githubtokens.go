@@ -0,0 +1,151 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+// GitHubTokensEnvVar is a comma-separated list of HOST[/OWNER]=TOKEN entries, the tokens sent to GitHub hosts for the
+// repositories of any owner, or of a single organization or user, e.g.
+// `github.com/pulumi=TOKEN1,github.com=TOKEN2,ghe.corp=TOKEN3`. Requests for the repositories of an owner are sent its
+// token if it has one, and otherwise their host's. The releases of plugins on github.com are downloaded with
+// `GITHUB_TOKEN` if neither is configured, and other requests are sent the credentials of PluginConfigFile.
+const GitHubTokensEnvVar = "PULUMI_GITHUB_TOKENS"
+
+// gitHubToken is an entry of GitHubTokensEnvVar.
+type gitHubToken struct {
+	host  string
+	owner string // empty if the token is sent for every owner on the host.
+	token string
+}
+
+// parseGitHubTokens parses the value of GitHubTokensEnvVar. Its errors never include the tokens.
+func parseGitHubTokens(s string) ([]gitHubToken, error) {
+	var tokens []gitHubToken
+	for i, entry := range splitEnvList(s) {
+		eq := strings.Index(entry, "=")
+		if eq <= 0 || eq == len(entry)-1 {
+			return nil, fmt.Errorf("entry %d is not of the form HOST[/OWNER]=TOKEN", i+1)
+		}
+		token := gitHubToken{host: entry[:eq], token: entry[eq+1:]}
+		if strings.Contains(token.host, "://") {
+			return nil, fmt.Errorf("entry %d: %q must be a host name, without a scheme", i+1, token.host)
+		}
+		if slash := strings.Index(token.host, "/"); slash >= 0 {
+			token.host, token.owner = token.host[:slash], token.host[slash+1:]
+			if err := validateGitHubOwner(token.owner); err != nil {
+				return nil, fmt.Errorf("entry %d: %w", i+1, err)
+			}
+		}
+		if u, err := url.Parse("https://" + token.host); err != nil || u.Host != token.host || u.Hostname() == "" {
+			return nil, fmt.Errorf("entry %d: %q is not a host name", i+1, token.host)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// getGitHubTokens returns the tokens GitHubTokensEnvVar configures.
+func getGitHubTokens() ([]gitHubToken, error) {
+	tokens, err := parseGitHubTokens(os.Getenv(GitHubTokensEnvVar))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", GitHubTokensEnvVar, err)
+	}
+	return tokens, nil
+}
+
+// findGitHubToken returns the token configured for the repositories of owner on host, or "" if there isn't one.
+func findGitHubToken(tokens []gitHubToken, host, owner string) string {
+	hostToken := ""
+	for _, token := range tokens {
+		if !strings.EqualFold(token.host, host) {
+			continue
+		}
+		if token.owner == "" && hostToken == "" {
+			hostToken = token.token
+		} else if token.owner != "" && strings.EqualFold(token.owner, owner) {
+			return token.token
+		}
+	}
+	return hostToken
+}
+
+// githubReleaseToken returns the token the releases of owner's repositories on github.com are downloaded with.
+// Malformed GitHubTokensEnvVar entries are reported by buildHTTPRequest, so they're ignored here.
+func githubReleaseToken(owner string) string {
+	tokens, _ := getGitHubTokens()
+	token := findGitHubToken(tokens, "github.com", owner)
+	if token == "" {
+		token = os.Getenv("GITHUB_TOKEN")
+	}
+	if token != "" {
+		filterCredentials(token)
+	}
+	return token
+}
+
+// gitHubTokenForURL returns the token GitHubTokensEnvVar configures for a request to u, by its host and the owner of
+// the repository its path names, if any.
+func gitHubTokenForURL(u *url.URL) (string, error) {
+	tokens, err := getGitHubTokens()
+	if err != nil || len(tokens) == 0 {
+		return "", err
+	}
+
+	// The owner is the first segment of the paths of repositories, after the prefix of their API.
+	host, path := u.Host, u.Path
+	if strings.EqualFold(host, "api.github.com") {
+		host = "github.com"
+	} else {
+		path = strings.TrimPrefix(path, "/api/v3")
+	}
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "/"), "repos/")
+	owner := strings.SplitN(path, "/", 2)[0]
+	token := findGitHubToken(tokens, host, owner)
+	if token != "" {
+		filterCredentials(token)
+	}
+	return token, nil
+}
+
+var (
+	credentialFiltersLock sync.Mutex
+	credentialFilters     = map[string]bool{}
+)
+
+// filterCredentials keeps the given secrets out of the logs. Each distinct secret is only added to the global log
+// filters once, so that looking up the same credential for every plugin request doesn't grow them without bound.
+func filterCredentials(secrets ...string) {
+	credentialFiltersLock.Lock()
+	defer credentialFiltersLock.Unlock()
+
+	var added []string
+	for _, secret := range secrets {
+		if secret != "" && !credentialFilters[secret] {
+			credentialFilters[secret] = true
+			added = append(added, secret)
+		}
+	}
+	if len(added) > 0 {
+		logging.AddGlobalFilter(logging.CreateFilter(added, "[credential]"))
+	}
+}
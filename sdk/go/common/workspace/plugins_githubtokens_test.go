@@ -0,0 +1,94 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+func TestParseGitHubTokens(t *testing.T) {
+	t.Parallel()
+
+	tokens, err := parseGitHubTokens("github.com/pulumi=tokA, ghe.corp:8443=tokB")
+	require.NoError(t, err)
+	assert.Equal(t, []gitHubToken{
+		{host: "github.com", owner: "pulumi", token: "tokA"},
+		{host: "ghe.corp:8443", token: "tokB"},
+	}, tokens)
+
+	// Errors never include the tokens.
+	for value, expected := range map[string]string{
+		"secret":                    "entry 1 is not of the form HOST[/OWNER]=TOKEN",
+		"github.com=tokA,ghe.corp=": "entry 2 is not of the form HOST[/OWNER]=TOKEN",
+		"github.com/a/b=secret":     `entry 1: "a/b" is not the name of a GitHub organization or user`,
+		"https://ghe.corp=secret":   `entry 1: "https://ghe.corp" must be a host name, without a scheme`,
+		"ghe corp=secret":           `entry 1: "ghe corp" is not a host name`,
+	} {
+		_, err := parseGitHubTokens(value)
+		assert.EqualError(t, err, expected, value)
+	}
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestGitHubTokens(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "fallback")
+	t.Setenv(GitHubTokensEnvVar, "github.com/acme=acme,ghe.corp=ghe,ghe.corp/labs=labs")
+
+	// Releases on github.com are downloaded with their owner's token, or GITHUB_TOKEN.
+	assert.Equal(t, "acme", newGithubSource("acme", "test", ResourcePlugin).token)
+	assert.Equal(t, "fallback", newGithubSource("pulumi", "test", ResourcePlugin).token)
+	t.Setenv(GitHubTokensEnvVar, "github.com/acme=acme,github.com=all,ghe.corp=ghe,ghe.corp/labs=labs")
+	assert.Equal(t, "all", newGithubSource("pulumi", "test", ResourcePlugin).token)
+
+	// Other requests to GitHub hosts are sent their tokens too.
+	for url, expected := range map[string]string{
+		"https://ghe.corp/acme/pulumi-test/releases/download/v1.0.0/test.tar.gz": "token ghe",
+		"https://ghe.corp/labs/pulumi-test/releases/download/v1.0.0/test.tar.gz": "token labs",
+		"https://ghe.corp/api/v3/repos/labs/pulumi-test/releases/latest":         "token labs",
+		"https://api.github.com/repos/acme/pulumi-test/releases/latest":          "token acme",
+		"https://plugins.corp/test.tar.gz":                                       "",
+	} {
+		req, err := buildHTTPRequest(url, "")
+		require.NoError(t, err)
+		assert.Equal(t, expected, req.Header.Get("Authorization"), url)
+	}
+	// The tokens that are sent are kept out of the logs.
+	assert.Equal(t, "token [credential]", logging.FilterString("token labs"))
+
+	t.Setenv(GitHubTokensEnvVar, "ghe.corp")
+	_, err := buildHTTPRequest("https://plugins.corp/test.tar.gz", "")
+	assert.EqualError(t, err, GitHubTokensEnvVar+": entry 1 is not of the form HOST[/OWNER]=TOKEN")
+}
+
+//nolint:paralleltest // counts the secrets every test registers
+func TestFilterCredentials(t *testing.T) {
+	filterCredentials("filtered-token", "")
+	credentialFiltersLock.Lock()
+	count := len(credentialFilters)
+	credentialFiltersLock.Unlock()
+
+	// Secrets that are already filtered aren't registered again.
+	filterCredentials("filtered-token")
+	credentialFiltersLock.Lock()
+	assert.Equal(t, count, len(credentialFilters))
+	assert.False(t, credentialFilters[""])
+	credentialFiltersLock.Unlock()
+	assert.Equal(t, "token [credential]", logging.FilterString("token filtered-token"))
+}
@@ -0,0 +1,218 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// GitLabScheme prefixes the PluginDownloadURL of plugins that are downloaded from the releases of a GitLab project,
+// on gitlab.com or a self-managed instance, e.g. `gitlab://gitlab.com/my-group/pulumi-foo` or
+// `gitlab://gitlab.example.com/1234`. The project is named by its path or ID. Each release is tagged with the version
+// it releases, such as `v1.2.0`, and links to the plugin's tarballs by their names. Requests are sent with the token
+// `GITLAB_TOKEN` sets, if any.
+const GitLabScheme = "gitlab://"
+
+// gitlabSource can download a plugin from the releases of a GitLab project.
+type gitlabSource struct {
+	name string
+	kind PluginKind
+
+	host    string
+	project string
+
+	token string
+}
+
+// newGitLabSource returns a source for the project named by a `gitlab://host/project` PluginDownloadURL, adding
+// authentication data in the environment, if it exists.
+func newGitLabSource(name string, kind PluginKind, downloadURL string) (*gitlabSource, error) {
+	u, err := url.Parse(downloadURL)
+	project := ""
+	if err == nil {
+		project = strings.Trim(u.Path, "/")
+	}
+	if err != nil || u.Scheme+"://" != GitLabScheme || u.Host == "" || project == "" || u.RawQuery != "" {
+		return nil, fmt.Errorf("expected GitLab URL to be %shost/project; got %q", GitLabScheme, downloadURL)
+	}
+	return &gitlabSource{
+		name:    name,
+		kind:    kind,
+		host:    u.Host,
+		project: project,
+		token:   os.Getenv("GITLAB_TOKEN"),
+	}, nil
+}
+
+// gitlabRelease is a release of a GitLab project, as returned by the releases API.
+type gitlabRelease struct {
+	TagName         string `json:"tag_name"`
+	Description     string `json:"description"`
+	UpcomingRelease bool   `json:"upcoming_release"`
+	Assets          struct {
+		Links []struct {
+			Name           string `json:"name"`
+			URL            string `json:"url"`
+			DirectAssetURL string `json:"direct_asset_url"`
+		} `json:"links"`
+	} `json:"assets"`
+}
+
+// releasesURL returns the URL of the project's releases in the GitLab API, followed by the given path.
+func (source *gitlabSource) releasesURL(path string) string {
+	return fmt.Sprintf("https://%s/api/v4/projects/%s/releases%s", source.host, url.PathEscape(source.project), path)
+}
+
+// newRequest returns a request for the given URL, with the source's token if the URL is on its GitLab instance.
+func (source *gitlabSource) newRequest(requestURL, accept string) (*http.Request, error) {
+	req, err := buildHTTPRequest(requestURL, "")
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if source.token != "" && sameOrigin(requestURL, "https://"+source.host) {
+		req.Header.Set("Authorization", "Bearer "+source.token)
+	}
+	return req, nil
+}
+
+// getJSON gets the JSON at the given URL of the GitLab API into v.
+func (source *gitlabSource) getJSON(requestURL string, v interface{},
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) error {
+	logf(9, sourceLogFields(source.kind, source.name), "plugin GitLab releases url: %s", requestURL)
+	req, err := source.newRequest(requestURL, "application/json")
+	if err != nil {
+		return err
+	}
+	resp, _, err := getHTTPResponse(req)
+	if err != nil {
+		return err
+	}
+	defer contract.IgnoreClose(resp)
+	jsonBody, err := ioutil.ReadAll(resp)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(jsonBody, v); err != nil {
+		return fmt.Errorf("cannot unmarshal GitLab response: %w", err)
+	}
+	return nil
+}
+
+// getRelease returns the project's release of the given version.
+func (source *gitlabSource) getRelease(version semver.Version,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*gitlabRelease, error) {
+	var release gitlabRelease
+	err := source.getJSON(source.releasesURL("/"+url.PathEscape("v"+version.String())), &release, getHTTPResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
+
+func (source *gitlabSource) GetLatestVersion(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
+	// Releases are listed latest first. Like the latest release on GitHub, the latest version isn't a prerelease.
+	var releases []gitlabRelease
+	query := url.Values{"order_by": {"released_at"}, "sort": {"desc"}, "per_page": {"100"}}
+	if err := source.getJSON(source.releasesURL("?"+query.Encode()), &releases, getHTTPResponse); err != nil {
+		return nil, err
+	}
+	for _, release := range releases {
+		version, err := semver.ParseTolerant(release.TagName)
+		if err != nil || len(version.Pre) > 0 || release.UpcomingRelease {
+			continue
+		}
+		return &version, nil
+	}
+	return nil, classifyPluginError(ErrNotFound, fmt.Errorf("no releases of %s plugin %s found in %s/%s",
+		source.kind, source.name, source.host, source.project))
+}
+
+// ListVersions implements versionListingSource, using the tags of the project's releases, page by page. Upcoming
+// releases aren't listed.
+func (source *gitlabSource) ListVersions(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, error) {
+	const perPage = 100
+	var versions []semver.Version
+	for page := 1; ; page++ {
+		var releases []gitlabRelease
+		query := url.Values{"per_page": {strconv.Itoa(perPage)}, "page": {strconv.Itoa(page)}}
+		if err := source.getJSON(source.releasesURL("?"+query.Encode()), &releases, getHTTPResponse); err != nil {
+			return nil, err
+		}
+		for _, release := range releases {
+			if version, err := semver.ParseTolerant(release.TagName); err == nil && !release.UpcomingRelease {
+				versions = append(versions, version)
+			}
+		}
+		if len(releases) < perPage {
+			return versions, nil
+		}
+	}
+}
+
+func (source *gitlabSource) Download(
+	version semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	assetName := pluginTarballName(source.kind, source.name, version, opSy, arch)
+	release, err := source.getRelease(version, getHTTPResponse)
+	if err != nil {
+		return nil, -1, err
+	}
+	assetURL := ""
+	for _, link := range release.Assets.Links {
+		if link.Name == assetName {
+			assetURL = link.DirectAssetURL
+			if assetURL == "" {
+				assetURL = link.URL
+			}
+		}
+	}
+	if assetURL == "" {
+		logf(9, sourceLogFields(source.kind, source.name), "plugin asset '%s' not found", assetName)
+		return nil, -1, classifyPluginError(ErrNotFound, fmt.Errorf(
+			"plugin asset '%s' not found: release v%s of %s/%s exists but has no asset for this platform",
+			assetName, version, source.host, source.project))
+	}
+
+	logf(1, sourceLogFields(source.kind, source.name), "%s downloading from %s", source.name, assetURL)
+	req, err := source.newRequest(assetURL, "application/octet-stream")
+	if err != nil {
+		return nil, -1, err
+	}
+	return getHTTPResponse(req)
+}
+
+func (source *gitlabSource) GetReleaseNotes(version semver.Version,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (string, error) {
+	release, err := source.getRelease(version, getHTTPResponse)
+	if err != nil {
+		return "", err
+	}
+	return release.Description, nil
+}
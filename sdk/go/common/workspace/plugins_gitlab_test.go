@@ -0,0 +1,116 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestGitLabSource(t *testing.T) {
+	t.Setenv("GITLAB_TOKEN", "secret")
+
+	assetName := fmt.Sprintf("pulumi-resource-test-v1.1.0-%s-%s.tar.gz", runtime.GOOS, runtime.GOARCH)
+	var requests []*http.Request
+	ctx := &Context{Home: t.TempDir(), HTTPClient: &http.Client{Transport: roundTripperFunc(
+		func(req *http.Request) (*http.Response, error) {
+			requests = append(requests, req)
+			resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req}
+			body := ""
+			switch req.URL.EscapedPath() {
+			case "/api/v4/projects/my-group%2Fpulumi-test/releases":
+				body = `[
+					{"tag_name": "v2.0.0", "upcoming_release": true},
+					{"tag_name": "v1.2.0-beta.1"},
+					{"tag_name": "v1.1.0"},
+					{"tag_name": "v1.0.0"}
+				]`
+			case "/api/v4/projects/my-group%2Fpulumi-test/releases/v1.1.0":
+				body = fmt.Sprintf(`{"tag_name": "v1.1.0", "description": "Fixes things.", "assets": {"links": [
+					{"name": "checksums.txt", "url": "https://gitlab.example.com/checksums.txt"},
+					{"name": %q, "url": "https://gitlab.example.com/my-group/pulumi-test/-/releases/v1.1.0/asset",
+					 "direct_asset_url": "https://gitlab.example.com/my-group/pulumi-test/-/releases/v1.1.0/downloads/t"}
+				]}}`, assetName)
+			case "/api/v4/projects/my-group%2Fpulumi-test/releases/v1.0.0":
+				body = `{"tag_name": "v1.0.0", "assets": {"links": [
+					{"name": "` + strings.Replace(assetName, "1.1.0", "1.0.0", 1) + `",
+					 "url": "https://files.example.com/test.tar.gz"}
+				]}}`
+			case "/my-group/pulumi-test/-/releases/v1.1.0/downloads/t", "/test.tar.gz":
+				body = "tarball"
+			default:
+				resp.StatusCode = http.StatusNotFound
+				body = `{"message": "404 Not Found"}`
+			}
+			resp.Body = ioutil.NopCloser(strings.NewReader(body))
+			return resp, nil
+		})}}
+	info, err := ctx.Plugin(PluginInfo{
+		Name:              "test",
+		Kind:              ResourcePlugin,
+		PluginDownloadURL: "gitlab://gitlab.example.com/my-group/pulumi-test/",
+	})
+	require.NoError(t, err)
+
+	// The latest version is that of the latest release that isn't a prerelease or upcoming.
+	latest, err := ctx.GetLatestVersion(info)
+	require.NoError(t, err)
+	assert.Equal(t, "1.1.0", latest.String())
+	assert.Equal(t, "Bearer secret", requests[0].Header.Get("Authorization"))
+
+	// Tarballs are downloaded from the links of the release, with the token only sent to the GitLab instance.
+	info.Version = latest
+	body, _, err := ctx.Download(info)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.Equal(t, "tarball", string(b))
+	req := requests[len(requests)-1]
+	assert.Equal(t, "/my-group/pulumi-test/-/releases/v1.1.0/downloads/t", req.URL.Path)
+	assert.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+	v := semver.MustParse("1.0.0")
+	info.Version = &v
+	_, _, err = ctx.Download(info)
+	require.NoError(t, err)
+	req = requests[len(requests)-1]
+	assert.Equal(t, "files.example.com", req.URL.Host)
+	assert.Empty(t, req.Header.Get("Authorization"))
+
+	// Release notes are the release's description.
+	info.Version = latest
+	notes, err := ctx.GetReleaseNotes(info)
+	require.NoError(t, err)
+	assert.Equal(t, "Fixes things.", notes)
+
+	// Versions without releases aren't found.
+	v = semver.MustParse("0.9.0")
+	info.Version = &v
+	_, _, err = ctx.Download(info)
+	assert.True(t, errors.Is(err, ErrNotFound), "%v", err)
+
+	_, err = newGitLabSource("test", ResourcePlugin, "gitlab://gitlab.com")
+	assert.EqualError(t, err, `expected GitLab URL to be gitlab://host/project; got "gitlab://gitlab.com"`)
+}
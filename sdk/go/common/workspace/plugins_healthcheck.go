@@ -0,0 +1,268 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// PluginHealthCheckEnvVar makes installs launch each plugin they install when set to a truthy value, failing the
+// install if the plugin can't start, such as when it was built for the wrong architecture or needs a missing
+// library.
+const PluginHealthCheckEnvVar = "PULUMI_PLUGIN_HEALTH_CHECK"
+
+// PluginHealthCheckTimeout is how long a plugin has to start and report its port during a health check.
+var PluginHealthCheckTimeout = 10 * time.Second
+
+// PluginInstallReceiptFile is the file in each plugin's directory that records how the plugin was installed.
+const PluginInstallReceiptFile = ".pulumi-install.json"
+
+// PluginInstallReceipt records how a plugin was installed.
+type PluginInstallReceipt struct {
+	// InstalledAt is when the install finished.
+	InstalledAt time.Time `json:"installedAt"`
+	// HealthCheck is the result of the plugin's health check, if it was run.
+	HealthCheck *PluginHealthCheck `json:"healthCheck,omitempty"`
+	// Shim is the bundled plugin that the plugin's executable forwards to, for plugins migrated into the plugin cache
+	// from next to the pulumi binary.
+	Shim string `json:"shim,omitempty"`
+	// SmokeTestedAt is when the plugin passed the smoke test declared by its PulumiPlugin.yaml, if it was run.
+	SmokeTestedAt *time.Time `json:"smokeTestedAt,omitempty"`
+	// Files are the files the install created in the plugin's directory, which Delete removes, other than caches.
+	Files []PluginInstalledFile `json:"files,omitempty"`
+	// Env are the environment variables declared by the plugin's PulumiPlugin.yaml, which are set when it runs.
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// PluginHealthCheck is what a plugin reported when it was launched by a health check.
+type PluginHealthCheck struct {
+	// Protocol is how the plugin was talked to. Plugins that serve the plugin gRPC protocol report "grpc".
+	Protocol string `json:"protocol"`
+	// Version is the version the plugin reported, if it reported one.
+	Version string `json:"version,omitempty"`
+	// CheckedAt is when the health check ran.
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// PluginHealthCheckError is returned when a plugin fails its health check.
+type PluginHealthCheckError struct {
+	// Info is the plugin that failed its health check.
+	Info PluginInfo
+	// Path is the plugin's executable.
+	Path string
+	// Output is what the plugin wrote to stderr before it failed.
+	Output string
+	// Err is why the health check failed.
+	Err error
+}
+
+func (err *PluginHealthCheckError) Error() string {
+	msg := fmt.Sprintf("%s plugin %s failed its health check: %v", err.Info.Kind, err.Info, err.Err)
+	if output := strings.TrimSpace(err.Output); output != "" {
+		msg += "\n" + output
+	}
+	return msg
+}
+
+func (err *PluginHealthCheckError) Unwrap() error {
+	return err.Err
+}
+
+// pluginHealthChecksEnabled returns true if PluginHealthCheckEnvVar is set.
+func pluginHealthChecksEnabled() bool {
+	return cmdutil.IsTruthy(os.Getenv(PluginHealthCheckEnvVar))
+}
+
+// GetInstallReceipt returns the receipt recorded when the plugin was installed, or nil if it has none, such as when it
+// was installed by an older version of Pulumi.
+func (info PluginInfo) GetInstallReceipt() (*PluginInstallReceipt, error) {
+	dir, err := info.DirPath()
+	if err != nil {
+		return nil, err
+	}
+	receipt, err := readInstallReceipt(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading install receipt of plugin %s: %w", info, err)
+	}
+	return receipt, nil
+}
+
+// readInstallReceipt reads the receipt in the plugin's install directory, returning nil if it has none.
+func readInstallReceipt(dir string) (*PluginInstallReceipt, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, PluginInstallReceiptFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var receipt PluginInstallReceipt
+	if err := json.Unmarshal(b, &receipt); err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// writeInstallReceipt records the receipt in the plugin's install directory.
+func writeInstallReceipt(dir string, receipt PluginInstallReceipt) error {
+	b, err := json.MarshalIndent(receipt, "", "  ")
+	contract.AssertNoError(err)
+	return ioutil.WriteFile(filepath.Join(dir, PluginInstallReceiptFile), b, 0600)
+}
+
+// HealthCheck launches the installed plugin and waits for it to report the port it serves the plugin protocol on,
+// then asks it for its version. A *PluginHealthCheckError is returned if it can't start. Plugins without an
+// executable, such as analyzers run by their policy pack's runtime, aren't launched, and nil is returned.
+func (info PluginInfo) HealthCheck() (*PluginHealthCheck, error) {
+	dir, err := info.DirPath()
+	if err != nil {
+		return nil, err
+	}
+	receipt, err := info.GetInstallReceipt()
+	if err != nil {
+		return nil, err
+	}
+	var declared map[string]string
+	if receipt != nil {
+		declared = receipt.Env
+	}
+	return info.healthCheck(dir, declared)
+}
+
+// healthCheck runs the health check of the plugin installed in dir, with the environment variables it declares. The
+// install runs it before the plugin's receipt records them.
+func (info PluginInfo) healthCheck(dir string, declared map[string]string) (*PluginHealthCheck, error) {
+	path, ok := findPluginExecutable(dir, info.FilePrefix(), getCandidateExtensions())
+	if !ok {
+		return nil, nil
+	}
+	env, err := pluginEnvironment(info.Kind, info.Name, declared)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), PluginHealthCheckTimeout)
+	defer cancel()
+	check, output, err := launchPlugin(ctx, info.Kind, path, env)
+	if err != nil {
+		return nil, &PluginHealthCheckError{Info: info, Path: path, Output: output, Err: err}
+	}
+	check.CheckedAt = info.now()
+	return check, nil
+}
+
+// launchPlugin runs the plugin and reads the port it reports, returning what the plugin told it and what it wrote to
+// stderr. The plugin is killed before launchPlugin returns.
+func launchPlugin(ctx context.Context, kind PluginKind, path string, env []string) (*PluginHealthCheck, string, error) {
+	// Plugins are given the address of an engine to connect to. Nothing answers on it, but plugins only contact the
+	// engine once they're in use.
+	engine, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	defer contract.IgnoreClose(engine)
+
+	cmd := exec.CommandContext(ctx, path, engine.Addr().String()) //nolint:gosec // the plugin was just installed
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, "", err
+	}
+	// Once the plugin has been killed and waited for, all of its stderr has been read.
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			contract.IgnoreError(cmd.Process.Kill())
+			contract.IgnoreError(cmd.Wait())
+		})
+	}
+	defer stop()
+	fail := func(err error) (*PluginHealthCheck, string, error) {
+		stop()
+		return nil, stderr.String(), err
+	}
+
+	ports := make(chan string, 1)
+	go func() {
+		line, err := bufio.NewReader(stdout).ReadString('\n')
+		if err != nil {
+			line = ""
+		}
+		ports <- strings.TrimSpace(line)
+	}()
+	var port string
+	select {
+	case port = <-ports:
+	case <-ctx.Done():
+	}
+	if ctx.Err() != nil {
+		return fail(fmt.Errorf("the plugin didn't report a port within %v", PluginHealthCheckTimeout))
+	} else if port == "" {
+		return fail(errors.New("the plugin exited without reporting a port"))
+	} else if _, err := strconv.Atoi(port); err != nil {
+		return fail(fmt.Errorf("the plugin reported a non-numeric port %q", port))
+	}
+
+	check := &PluginHealthCheck{Protocol: "grpc"}
+	if version, err := getPluginVersion(ctx, kind, "127.0.0.1:"+port); err == nil {
+		check.Version = version
+	} else {
+		logf(5, nil, "could not get the version of plugin %s: %v", path, err)
+	}
+	return check, "", nil
+}
+
+// getPluginVersion asks the plugin serving the gRPC protocol of its kind at addr for its version.
+func getPluginVersion(ctx context.Context, kind PluginKind, addr string) (string, error) {
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return "", err
+	}
+	defer contract.IgnoreClose(conn)
+
+	opts, ok := getPluginKindOptions(kind)
+	if !ok || opts.GetPluginInfo == nil {
+		return "", fmt.Errorf("plugins of kind %q aren't asked for their version", kind)
+	}
+	pluginInfo, err := opts.GetPluginInfo(ctx, conn)
+	if err != nil {
+		return "", err
+	}
+	return pluginInfo.GetVersion(), nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+type versionProvider struct {
+	pulumirpc.UnimplementedResourceProviderServer
+}
+
+func (*versionProvider) GetPluginInfo(context.Context, *emptypb.Empty) (*pulumirpc.PluginInfo, error) {
+	return &pulumirpc.PluginInfo{Version: "1.2.3"}, nil
+}
+
+// serveVersionProvider serves a resource provider that reports version 1.2.3, returning its port.
+func serveVersionProvider(t *testing.T) int {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	pulumirpc.RegisterResourceProviderServer(srv, &versionProvider{})
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+func TestPluginHealthCheck(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == windowsGOOS {
+		t.Skip("uses shell scripts as fake plugins")
+	}
+
+	port := serveVersionProvider(t)
+	tests := []struct {
+		name     string
+		script   string
+		expected *PluginHealthCheck
+		err      string
+		output   string
+	}{
+		{
+			name:     "serves the plugin protocol",
+			script:   fmt.Sprintf("echo %d\nexec sleep 30", port),
+			expected: &PluginHealthCheck{Protocol: "grpc", Version: "1.2.3"},
+		},
+		{
+			name:   "can't start",
+			script: "echo 'error while loading shared libraries: libc.so.6' >&2\nexit 127",
+			err:    "the plugin exited without reporting a port",
+			output: "error while loading shared libraries: libc.so.6",
+		},
+		{
+			name:   "writes garbage",
+			script: "echo hello\nexec sleep 30",
+			err:    `the plugin reported a non-numeric port "hello"`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, info := newMockPlugin(t)
+			tgz, err := createTGZWithMode(map[string][]byte{"pulumi-resource-mock": []byte("#!/bin/sh\n" + tt.script + "\n")},
+				0700)
+			require.NoError(t, err)
+			require.NoError(t, info.Install(ioutil.NopCloser(bytes.NewReader(tgz)), false))
+
+			check, err := info.HealthCheck()
+			if tt.err != "" {
+				var checkErr *PluginHealthCheckError
+				require.True(t, errors.As(err, &checkErr), "unexpected error %v", err)
+				assert.EqualError(t, checkErr.Err, tt.err)
+				assert.Contains(t, checkErr.Output, tt.output)
+				return
+			}
+			require.NoError(t, err)
+			check.CheckedAt = tt.expected.CheckedAt
+			assert.Equal(t, tt.expected, check)
+		})
+	}
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestInstallWithHealthCheck(t *testing.T) {
+	if runtime.GOOS == windowsGOOS {
+		t.Skip("uses shell scripts as fake plugins")
+	}
+
+	failing, err := createTGZWithMode(map[string][]byte{"pulumi-resource-mock": []byte("#!/bin/sh\nexit 1\n")}, 0700)
+	require.NoError(t, err)
+
+	// Without health checks, the receipt only records when the plugin was installed.
+	_, info := newMockPlugin(t)
+	require.NoError(t, info.Install(ioutil.NopCloser(bytes.NewReader(failing)), false))
+	receipt, err := info.GetInstallReceipt()
+	require.NoError(t, err)
+	require.NotNil(t, receipt)
+	assert.False(t, receipt.InstalledAt.IsZero())
+	assert.Nil(t, receipt.HealthCheck)
+
+	t.Setenv(PluginHealthCheckEnvVar, "true")
+
+	// Plugins that fail their health check aren't installed.
+	dir, info := newMockPlugin(t)
+	err = info.Install(ioutil.NopCloser(bytes.NewReader(failing)), false)
+	var checkErr *PluginHealthCheckError
+	assert.True(t, errors.As(err, &checkErr), "unexpected error %v", err)
+	plugins, err := getPlugins(dir, true /* skipMetadata */)
+	require.NoError(t, err)
+	assert.Empty(t, plugins)
+
+	// Those that pass have the result recorded in their receipt.
+	v := semver.MustParse("1.0.0")
+	info = PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &v, PluginDir: dir}
+	script := fmt.Sprintf("#!/bin/sh\necho %d\nexec sleep 30\n", serveVersionProvider(t))
+	passing, err := createTGZWithMode(map[string][]byte{"pulumi-resource-mock": []byte(script)}, 0700)
+	require.NoError(t, err)
+	require.NoError(t, info.Install(ioutil.NopCloser(bytes.NewReader(passing)), false))
+	receipt, err = info.GetInstallReceipt()
+	require.NoError(t, err)
+	require.NotNil(t, receipt)
+	require.NotNil(t, receipt.HealthCheck)
+	assert.Equal(t, "grpc", receipt.HealthCheck.Protocol)
+	assert.Equal(t, "1.2.3", receipt.HealthCheck.Version)
+}
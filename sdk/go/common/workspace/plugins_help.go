@@ -0,0 +1,231 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// The documentation linked to from plugin error help.
+const (
+	pluginInstallDocsURL         = "https://www.pulumi.com/docs/reference/cli/pulumi_plugin_install/"
+	pluginProvidersDocsURL       = "https://www.pulumi.com/docs/intro/concepts/resources/providers/"
+	pluginLoginDocsURL           = "https://www.pulumi.com/docs/reference/cli/pulumi_login/"
+	pluginTroubleshootingDocsURL = "https://www.pulumi.com/docs/troubleshooting/"
+	gitHubTokensURL              = "https://github.com/settings/tokens"
+	gitHubRateLimitsURL          = "https://docs.github.com/en/rest/overview/resources-in-the-rest-api#rate-limiting"
+)
+
+// PluginErrorHelp tells the user how to resolve an error acquiring a plugin.
+type PluginErrorHelp struct {
+	// Hint explains what to do about the error.
+	Hint string
+	// Command is the command that resolves the error, if there is one.
+	Command string
+	// EnvVars are the environment variables that affect the error.
+	EnvVars []string
+	// DocsURL links to the documentation for resolving the error.
+	DocsURL string
+}
+
+func (help PluginErrorHelp) String() string {
+	var b strings.Builder
+	b.WriteString(help.Hint)
+	if help.Command != "" {
+		fmt.Fprintf(&b, "\n    run: %s", help.Command)
+	}
+	if len(help.EnvVars) > 0 {
+		fmt.Fprintf(&b, "\n    environment variables: %s", strings.Join(help.EnvVars, ", "))
+	}
+	if help.DocsURL != "" {
+		fmt.Fprintf(&b, "\n    docs: %s", help.DocsURL)
+	}
+	return b.String()
+}
+
+// GetPluginErrorHelp returns help for resolving err, which was returned while downloading or installing the given
+// plugin. It returns false if err isn't one of the plugin errors help is available for.
+func GetPluginErrorHelp(info PluginInfo, err error) (PluginErrorHelp, bool) {
+	var license *LicenseNotAcceptedError
+	var missing *MissingError
+	var httpErr *HTTPError
+	var diskSpace *InsufficientDiskSpaceError
+	var notWritable *PluginDirNotWritableError
+	fromGitHub := errors.As(err, &httpErr) && isGitHubURL(httpErr.URL)
+
+	switch {
+	case errors.As(err, &license):
+		return PluginErrorHelp{
+			Hint:    "Review and accept the plugin's license interactively, or accept it in advance.",
+			Command: pluginInstallCommand(license.Info),
+			EnvVars: []string{PluginAcceptLicensesEnvVar},
+			DocsURL: pluginInstallDocsURL,
+		}, true
+	case errors.As(err, &missing):
+		return PluginErrorHelp{
+			Hint:    "Install the plugin.",
+			Command: pluginInstallCommand(missing.Info),
+			DocsURL: pluginInstallDocsURL,
+		}, true
+	case errors.Is(err, ErrUnsupportedPlatform):
+		return PluginErrorHelp{
+			Hint:    "Install a build of the plugin for this platform from a file, or configure a platform to fall back to.",
+			Command: pluginInstallCommand(info, "--file", "<path>"),
+			EnvVars: []string{PluginPlatformFallbacksEnvVar},
+			DocsURL: pluginInstallDocsURL,
+		}, true
+	case errors.Is(err, ErrChecksumMismatch):
+		return PluginErrorHelp{
+			Hint: "The download was corrupted or tampered with. Retry the install, and report the problem to the " +
+				"plugin's maintainers if it persists.",
+			Command: pluginInstallCommand(info, "--reinstall"),
+			DocsURL: pluginTroubleshootingDocsURL,
+		}, true
+	case errors.Is(err, ErrCorruptArchive):
+		return PluginErrorHelp{
+			Hint:    "The plugin's tarball was truncated or corrupted, possibly by a proxy. Retry the install.",
+			Command: pluginInstallCommand(info, "--reinstall"),
+			DocsURL: pluginTroubleshootingDocsURL,
+		}, true
+	case errors.As(err, &diskSpace):
+		return PluginErrorHelp{
+			Hint:    fmt.Sprintf("Free up disk space in %s, or move the plugin cache to a larger disk.", diskSpace.Dir),
+			EnvVars: []string{PulumiHomeEnvVar},
+			DocsURL: pluginTroubleshootingDocsURL,
+		}, true
+	case errors.As(err, &notWritable):
+		return PluginErrorHelp{
+			Hint: fmt.Sprintf("Make %s writable by the current user, or install plugins into a writable directory "+
+				"instead.", notWritable.Dir),
+			EnvVars: []string{PulumiHomeEnvVar, PluginFallbackDirEnvVar},
+			DocsURL: pluginTroubleshootingDocsURL,
+		}, true
+	case errors.Is(err, ErrRateLimited) && fromGitHub && httpErr.TokenSent:
+		return PluginErrorHelp{
+			Hint:    "GitHub is rate limiting downloads. Wait for the limit to reset before retrying.",
+			DocsURL: gitHubRateLimitsURL,
+		}, true
+	case errors.Is(err, ErrRateLimited) && fromGitHub:
+		return PluginErrorHelp{
+			Hint:    "GitHub is rate limiting downloads. Wait before retrying, or authenticate to raise the limit.",
+			EnvVars: []string{"GITHUB_TOKEN"},
+			DocsURL: gitHubTokensURL,
+		}, true
+	case fromGitHub && httpErr.SSOURL != "":
+		return PluginErrorHelp{
+			Hint:    "Authorize GITHUB_TOKEN for the organization's SAML single sign-on, then retry.",
+			EnvVars: []string{"GITHUB_TOKEN"},
+			DocsURL: httpErr.SSOURL,
+		}, true
+	case errors.Is(err, ErrNotFound) && fromGitHub && httpErr.TokenSent:
+		return PluginErrorHelp{
+			Hint: "Check the plugin's version was released with an asset for this platform, and that GITHUB_TOKEN has " +
+				"access to the repository.",
+			EnvVars: []string{"GITHUB_TOKEN"},
+			DocsURL: pluginProvidersDocsURL,
+		}, true
+	case errors.Is(err, ErrUnauthorized) && fromGitHub && httpErr.TokenSent:
+		return PluginErrorHelp{
+			Hint:    "Check that GITHUB_TOKEN hasn't expired and has access to the repository.",
+			EnvVars: []string{"GITHUB_TOKEN"},
+			DocsURL: gitHubTokensURL,
+		}, true
+	case errors.Is(err, ErrRateLimited):
+		return PluginErrorHelp{
+			Hint:    "The plugin source is rate limiting downloads. Wait before retrying.",
+			DocsURL: pluginTroubleshootingDocsURL,
+		}, true
+	case (errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrNotFound)) && fromGitHub:
+		return PluginErrorHelp{
+			Hint:    "If this is a private GitHub repository, provide a token with access to it.",
+			EnvVars: []string{"GITHUB_TOKEN"},
+			DocsURL: gitHubTokensURL,
+		}, true
+	case errors.Is(err, ErrUnauthorized):
+		return PluginErrorHelp{
+			Hint:    "Log in to the Pulumi backend, or provide an access token.",
+			Command: "pulumi login",
+			EnvVars: []string{PulumiAccessTokenEnvVar},
+			DocsURL: pluginLoginDocsURL,
+		}, true
+	case errors.Is(err, ErrNotFound):
+		return PluginErrorHelp{
+			Hint:    "Check the plugin's name and version, and the URL it is downloaded from.",
+			EnvVars: []string{PluginIndexURLsEnvVar},
+			DocsURL: pluginProvidersDocsURL,
+		}, true
+	case errors.Is(err, ErrOffline):
+		return PluginErrorHelp{
+			Hint:    "Check your network connection and proxy settings, or install the plugin from a file.",
+			Command: pluginInstallCommand(info, "--file", "<path>"),
+			EnvVars: []string{"HTTPS_PROXY", PluginDownloadProxyEnvVar},
+			DocsURL: pluginTroubleshootingDocsURL,
+		}, true
+	default:
+		return PluginErrorHelp{}, false
+	}
+}
+
+// WithPluginErrorHelp returns err with the help for resolving it, if any, appended to its message. info is the plugin
+// that was being downloaded or installed when err was returned. The result still matches err with errors.Is and
+// errors.As.
+func WithPluginErrorHelp(info PluginInfo, err error) error {
+	if err == nil {
+		return nil
+	}
+	help, ok := GetPluginErrorHelp(info, err)
+	if !ok {
+		return err
+	}
+	// Some errors already name the command that resolves them.
+	if strings.Contains(err.Error(), help.Command) {
+		help.Command = ""
+	}
+	return &pluginErrorWithHelp{err: err, help: help}
+}
+
+type pluginErrorWithHelp struct {
+	err  error
+	help PluginErrorHelp
+}
+
+func (err *pluginErrorWithHelp) Error() string {
+	return err.err.Error() + "\n  " + err.help.String()
+}
+
+func (err *pluginErrorWithHelp) Unwrap() error {
+	return err.err
+}
+
+// pluginInstallCommand returns the `pulumi plugin install` command for the given plugin, with any extra arguments.
+func pluginInstallCommand(info PluginInfo, args ...string) string {
+	command := []string{"pulumi", "plugin", "install", string(info.Kind), info.Name}
+	if info.Version != nil {
+		command = append(command, "v"+info.Version.String())
+	}
+	if info.PluginDownloadURL != "" {
+		command = append(command, "--server", info.PluginDownloadURL)
+	}
+	return strings.Join(append(command, args...), " ")
+}
+
+// isGitHubURL returns true if rawURL is served by GitHub.
+func isGitHubURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Host == "api.github.com" || u.Host == "github.com")
+}
@@ -0,0 +1,164 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPluginErrorHelp(t *testing.T) {
+	t.Parallel()
+
+	v := semver.MustParse("1.2.3")
+	info := PluginInfo{Name: "acme", Kind: ResourcePlugin, Version: &v}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected PluginErrorHelp
+	}{
+		{
+			name: "missing",
+			err:  NewMissingError(info, false),
+			expected: PluginErrorHelp{
+				Hint:    "Install the plugin.",
+				Command: "pulumi plugin install resource acme v1.2.3",
+				DocsURL: pluginInstallDocsURL,
+			},
+		},
+		{
+			name: "license",
+			err:  fmt.Errorf("installing: %w", &LicenseNotAcceptedError{Info: info}),
+			expected: PluginErrorHelp{
+				Hint:    "Review and accept the plugin's license interactively, or accept it in advance.",
+				Command: "pulumi plugin install resource acme v1.2.3",
+				EnvVars: []string{PluginAcceptLicensesEnvVar},
+				DocsURL: pluginInstallDocsURL,
+			},
+		},
+		{
+			name: "checksum",
+			err:  checksumMismatchError("acme.tar.gz", "aaaa", "bbbb"),
+			expected: PluginErrorHelp{
+				Hint: "The download was corrupted or tampered with. Retry the install, and report the problem to the " +
+					"plugin's maintainers if it persists.",
+				Command: "pulumi plugin install resource acme v1.2.3 --reinstall",
+				DocsURL: pluginTroubleshootingDocsURL,
+			},
+		},
+		{
+			name: "private github",
+			err:  &HTTPError{StatusCode: 404, URL: "https://api.github.com/repos/acme/pulumi-acme/releases"},
+			expected: PluginErrorHelp{
+				Hint:    "If this is a private GitHub repository, provide a token with access to it.",
+				EnvVars: []string{"GITHUB_TOKEN"},
+				DocsURL: gitHubTokensURL,
+			},
+		},
+		{
+			name: "github rate limit",
+			err:  &HTTPError{StatusCode: 403, URL: "https://api.github.com/repos/acme/pulumi-acme", RateLimited: true},
+			expected: PluginErrorHelp{
+				Hint:    "GitHub is rate limiting downloads. Wait before retrying, or authenticate to raise the limit.",
+				EnvVars: []string{"GITHUB_TOKEN"},
+				DocsURL: gitHubTokensURL,
+			},
+		},
+		{
+			name: "github sso",
+			err: &HTTPError{StatusCode: 403, URL: "https://api.github.com/repos/acme/pulumi-acme", TokenSent: true,
+				SSOURL: "https://github.com/orgs/acme/sso?authorization_request=abc"},
+			expected: PluginErrorHelp{
+				Hint:    "Authorize GITHUB_TOKEN for the organization's SAML single sign-on, then retry.",
+				EnvVars: []string{"GITHUB_TOKEN"},
+				DocsURL: "https://github.com/orgs/acme/sso?authorization_request=abc",
+			},
+		},
+		{
+			name: "github missing asset with token",
+			err:  &HTTPError{StatusCode: 404, URL: "https://api.github.com/repos/acme/pulumi-acme", TokenSent: true},
+			expected: PluginErrorHelp{
+				Hint: "Check the plugin's version was released with an asset for this platform, and that GITHUB_TOKEN " +
+					"has access to the repository.",
+				EnvVars: []string{"GITHUB_TOKEN"},
+				DocsURL: pluginProvidersDocsURL,
+			},
+		},
+		{
+			name: "backend unauthorized",
+			err:  &HTTPError{StatusCode: 401, URL: "https://api.pulumi.com/api/plugins/resource/acme/latest"},
+			expected: PluginErrorHelp{
+				Hint:    "Log in to the Pulumi backend, or provide an access token.",
+				Command: "pulumi login",
+				EnvVars: []string{PulumiAccessTokenEnvVar},
+				DocsURL: pluginLoginDocsURL,
+			},
+		},
+		{
+			name: "offline",
+			err:  classifyNetworkError(&net.DNSError{Err: "no such host", Name: "get.pulumi.com"}),
+			expected: PluginErrorHelp{
+				Hint:    "Check your network connection and proxy settings, or install the plugin from a file.",
+				Command: "pulumi plugin install resource acme v1.2.3 --file <path>",
+				EnvVars: []string{"HTTPS_PROXY", PluginDownloadProxyEnvVar},
+				DocsURL: pluginTroubleshootingDocsURL,
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			help, ok := GetPluginErrorHelp(info, tt.err)
+			assert.True(t, ok)
+			assert.Equal(t, tt.expected, help)
+		})
+	}
+
+	_, ok := GetPluginErrorHelp(info, errors.New("something else"))
+	assert.False(t, ok)
+}
+
+func TestWithPluginErrorHelp(t *testing.T) {
+	t.Parallel()
+
+	v := semver.MustParse("1.2.3")
+	info := PluginInfo{Name: "acme", Kind: ResourcePlugin, Version: &v}
+
+	cause := checksumMismatchError("acme.tar.gz", "aaaa", "bbbb")
+	err := WithPluginErrorHelp(info, cause)
+	assert.Equal(t, "checksum mismatch for acme.tar.gz: expected sha256 aaaa, got bbbb\n"+
+		"  The download was corrupted or tampered with. Retry the install, and report the problem to the "+
+		"plugin's maintainers if it persists.\n"+
+		"    run: pulumi plugin install resource acme v1.2.3 --reinstall\n"+
+		"    docs: "+pluginTroubleshootingDocsURL, err.Error())
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	// The command isn't repeated when the error already names it.
+	err = WithPluginErrorHelp(info, NewMissingError(info, false))
+	assert.Equal(t, NewMissingError(info, false).Error()+"\n  Install the plugin.\n    docs: "+pluginInstallDocsURL,
+		err.Error())
+
+	other := errors.New("something else")
+	assert.Equal(t, other, WithPluginErrorHelp(info, other))
+	assert.Nil(t, WithPluginErrorHelp(info, nil))
+}
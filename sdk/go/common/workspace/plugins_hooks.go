@@ -0,0 +1,115 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"sync"
+	"time"
+)
+
+// PluginHookPhase is the point in a plugin's download or install at which a PluginHook is called.
+type PluginHookPhase string
+
+const (
+	// BeforePluginDownload hooks are called before a plugin is downloaded.
+	BeforePluginDownload PluginHookPhase = "before-download"
+	// AfterPluginDownload hooks are called once a plugin's download has started, or failed to.
+	AfterPluginDownload PluginHookPhase = "after-download"
+	// BeforePluginInstall hooks are called before a plugin's tarball is extracted into its install directory. They
+	// aren't called if the plugin is already installed.
+	BeforePluginInstall PluginHookPhase = "before-install"
+	// AfterPluginInstall hooks are called once a plugin has been installed, or failed to be.
+	AfterPluginInstall PluginHookPhase = "after-install"
+	// PluginDownloadHeartbeat hooks are called every few seconds while a plugin's tarball is read, so a slow download
+	// can be told apart from one that has stalled. Like those of after phases, their errors are only logged. They're
+	// only called for downloads that start after they're registered.
+	PluginDownloadHeartbeat PluginHookPhase = "download-heartbeat"
+)
+
+// PluginHookEvent describes the download or install a PluginHook is called for.
+type PluginHookEvent struct {
+	// Phase is the point in the download or install the hook is called at.
+	Phase PluginHookPhase
+	// Plugin is the plugin being downloaded or installed.
+	Plugin PluginInfo
+	// Dir is the directory the plugin is installed into. It's only set for install phases.
+	Dir string
+	// Size is the size of the download, or -1 if it isn't known. It's only set after downloads and for heartbeats.
+	Size int64
+	// Received is how much of the download has been read. It's only set for heartbeats.
+	Received int64
+	// Idle is how long it's been since any of the download was read. It's only set for heartbeats.
+	Idle time.Duration
+	// Err is the error the download or install failed with, if any. It's only set for after phases.
+	Err error
+}
+
+// PluginHook is called at a phase of plugin downloads and installs, e.g. to enforce policy, report telemetry or warm
+// caches. An error returned by a hook for a before phase stops the download or install, and is returned from it.
+// Errors returned for after phases are only logged.
+type PluginHook func(event PluginHookEvent) error
+
+var (
+	pluginHooksLock sync.Mutex
+	pluginHooks     = map[PluginHookPhase][]*PluginHook{}
+)
+
+// RegisterPluginHook calls hook at the given phase of every plugin download or install. Hooks for a phase are called in
+// the order they were registered. The returned function unregisters the hook.
+func RegisterPluginHook(phase PluginHookPhase, hook PluginHook) (unregister func()) {
+	pluginHooksLock.Lock()
+	defer pluginHooksLock.Unlock()
+
+	registered := &hook
+	pluginHooks[phase] = append(pluginHooks[phase], registered)
+	return func() {
+		pluginHooksLock.Lock()
+		defer pluginHooksLock.Unlock()
+		hooks := pluginHooks[phase]
+		for i, h := range hooks {
+			if h == registered {
+				pluginHooks[phase] = append(hooks[:i:i], hooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// pluginHooksFor returns the hooks registered for the phase.
+func pluginHooksFor(phase PluginHookPhase) []*PluginHook {
+	pluginHooksLock.Lock()
+	defer pluginHooksLock.Unlock()
+	return append([]*PluginHook(nil), pluginHooks[phase]...)
+}
+
+// runBeforePluginHooks calls the hooks registered for the event's phase, stopping at and returning the first error a
+// hook returns.
+func runBeforePluginHooks(event PluginHookEvent) error {
+	for _, hook := range pluginHooksFor(event.Phase) {
+		if err := (*hook)(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runAfterPluginHooks calls every hook registered for the event's phase, logging the errors they return.
+func runAfterPluginHooks(event PluginHookEvent) {
+	for _, hook := range pluginHooksFor(event.Phase) {
+		if err := (*hook)(event); err != nil {
+			event.Plugin.logf(5, "%s hook for plugin %s failed: %v", event.Phase, event.Plugin, err)
+		}
+	}
+}
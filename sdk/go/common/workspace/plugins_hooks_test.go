@@ -0,0 +1,91 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables and registers global hooks
+func TestPluginHooks(t *testing.T) {
+	t.Setenv(PulumiHomeEnvVar, t.TempDir())
+
+	tgz := mockPluginTGZ(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write(tgz)
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	dir, info := newMockPlugin(t)
+	info.PluginDownloadURL = server.URL
+
+	var m sync.Mutex
+	var events []PluginHookEvent
+	record := func(event PluginHookEvent) error {
+		m.Lock()
+		defer m.Unlock()
+		if event.Plugin.PluginDir == dir {
+			event.Plugin = PluginInfo{}
+			events = append(events, event)
+		}
+		return nil
+	}
+	for _, phase := range []PluginHookPhase{
+		BeforePluginDownload, AfterPluginDownload, BeforePluginInstall, AfterPluginInstall,
+	} {
+		defer RegisterPluginHook(phase, record)()
+	}
+
+	r, size, err := info.Download()
+	require.NoError(t, err)
+	require.NoError(t, info.Install(r, false))
+	installDir, err := info.DirPath()
+	require.NoError(t, err)
+	assert.Equal(t, []PluginHookEvent{
+		{Phase: BeforePluginDownload},
+		{Phase: AfterPluginDownload, Size: size},
+		{Phase: BeforePluginInstall, Dir: installDir},
+		{Phase: AfterPluginInstall, Dir: installDir},
+	}, events)
+
+	// Before hooks can stop an install, and installs of plugins that are already installed don't call hooks.
+	events = nil
+	refused := errors.New("refused by policy")
+	defer RegisterPluginHook(BeforePluginInstall, func(event PluginHookEvent) error {
+		return refused
+	})()
+	r, _, err = info.Download()
+	require.NoError(t, err)
+	assert.NoError(t, info.Install(r, false))
+	r, _, err = info.Download()
+	require.NoError(t, err)
+	err = info.Install(r, true)
+	assert.True(t, errors.Is(err, refused), "expected %v to be the hook's error", err)
+	assert.Equal(t, []PluginHookEvent{
+		{Phase: BeforePluginDownload},
+		{Phase: AfterPluginDownload, Size: size},
+		{Phase: BeforePluginDownload},
+		{Phase: AfterPluginDownload, Size: size},
+		{Phase: BeforePluginInstall, Dir: installDir},
+	}, events)
+}
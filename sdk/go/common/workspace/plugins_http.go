@@ -0,0 +1,162 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/httputil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+// httpClient returns the client plugin sources send req with. In FIPS mode, it's restricted to what FIPS mode allows,
+// and req is refused if it isn't sent over TLS.
+func (ctx *Context) httpClient(req *http.Request) (*http.Client, error) {
+	client := ctx.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	fips, err := ctx.fipsMode()
+	if err != nil || !fips {
+		return client, err
+	}
+	if err := checkFIPSURL(req.URL); err != nil {
+		return nil, err
+	}
+	return fipsHTTPClient(client)
+}
+
+// loggedHeaders returns a copy of headers with their credentials redacted, since the context's Logger may not apply
+// the credential filters of the logging package. The values of other headers are filtered the way logging filters
+// them, which redacts tokens sent in other headers, such as those of entitlements.
+func loggedHeaders(headers http.Header) http.Header {
+	logged := make(http.Header, len(headers))
+	for name, values := range headers {
+		for _, value := range values {
+			switch http.CanonicalHeaderKey(name) {
+			case "Authorization", "Proxy-Authorization":
+				// Keep the scheme, which helps tell which credentials were sent.
+				if scheme := strings.Index(value, " "); scheme > 0 {
+					value = value[:scheme] + " [credential]"
+				} else {
+					value = "[credential]"
+				}
+			case "Cookie", "Set-Cookie":
+				value = "[credential]"
+			default:
+				value = logging.FilterString(value)
+			}
+			logged[name] = append(logged[name], value)
+		}
+	}
+	return logged
+}
+
+// getHTTPResponse sends req through the context's download middleware, and returns the body and length of a successful
+// response.
+func (ctx *Context) getHTTPResponse(req *http.Request) (io.ReadCloser, int64, error) {
+	return ctx.httpGetter()(req)
+}
+
+// sendHTTPRequest sends req with the context's HTTP client, retrying transient failures, and returns the body and
+// length of a successful response. Other responses are returned as an *HTTPError. Downloads are spooled to the plugin
+// cache as they're read, and resumed from the spool if an earlier invocation was interrupted part way through. Requests
+// that receive nothing for the stall timeout fail with ErrStalled. The context's embedder is identified in the
+// User-Agent and attribution header of requests if it's configured to be. The responses to API requests are cached,
+// revalidated with their ETags, and used in place of errors that are likely to be brief.
+func (ctx *Context) sendHTTPRequest(req *http.Request) (io.ReadCloser, int64, error) {
+	spool := ctx.claimDownloadSpool(req)
+	sent := req
+	if spool != nil {
+		sent = spool.request(req)
+	}
+	cache := ctx.metadataCacheFor(req)
+	sent = cache.request(sent)
+	sent, err := ctx.attributeRequest(sent)
+	var client *http.Client
+	var timeout time.Duration
+	if err == nil {
+		client, err = ctx.httpClient(sent)
+	}
+	if err == nil {
+		timeout, err = ctx.pluginStallTimeout()
+	}
+	if err != nil {
+		if spool != nil {
+			spool.release()
+		}
+		return nil, -1, err
+	}
+	ctx.logf(9, "full plugin download url: %s", sent.URL)
+	ctx.logf(9, "plugin install request headers: %v", loggedHeaders(sent.Header))
+
+	sent, watch := watchForStalls(sent, timeout)
+	resp, err := httputil.DoWithRetry(sent, client)
+	if err != nil {
+		watch.stop()
+		if spool != nil {
+			spool.release()
+		}
+		err = classifyNetworkError(watch.err(err))
+		if body, length, ok := cache.fallback(err); ok {
+			return body, length, nil
+		}
+		return nil, -1, err
+	}
+	resp.Body = watch.wrap(resp.Body)
+
+	ctx.logf(9, "plugin install response headers: %v", loggedHeaders(resp.Header))
+	if body, length, ok := cache.notModified(resp); ok {
+		return body, length, nil
+	}
+
+	// If the server can't send the rest of the spooled download, start over.
+	if spool != nil && spool.offset > 0 &&
+		(resp.StatusCode == http.StatusRequestedRangeNotSatisfiable ||
+			(resp.StatusCode == http.StatusPartialContent && !spool.resumes(resp))) {
+		contract.IgnoreClose(resp.Body)
+		spool.discard()
+		return ctx.sendHTTPRequest(req)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if spool != nil {
+			spool.release()
+		}
+		defer contract.IgnoreClose(resp.Body)
+
+		// Advice on resolving the error, such as providing a token for private GitHub repositories, is added by
+		// WithPluginErrorHelp.
+		errmsg := fmt.Sprintf("%d HTTP error fetching plugin from %s", resp.StatusCode, req.URL)
+		httpErr := newHTTPError(req, resp, errmsg)
+		if isGitHubURL(httpErr.URL) {
+			diagnoseGitHubError(httpErr, resp)
+		}
+		if body, length, ok := cache.fallback(httpErr); ok {
+			return body, length, nil
+		}
+		return nil, -1, httpErr
+	}
+
+	if spool != nil {
+		return spool.wrap(resp)
+	}
+	return cache.store(resp)
+}
@@ -0,0 +1,124 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+// headerTransport adds a header to every request, as authentication middleware would, and counts the requests.
+type headerTransport struct {
+	requests int32
+}
+
+func (transport *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&transport.requests, 1)
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Plugin-Token", "secret")
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestContextHTTPClient(t *testing.T) {
+	t.Setenv(PulumiHomeEnvVar, t.TempDir())
+
+	tgz := mockPluginTGZ(t)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Plugin-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.URL.Path == "/bundle.yaml":
+			_, err := w.Write([]byte("name: b\nversion: 1.0.0\nplugins:\n" +
+				"  - {name: mock, version: 1.0.0, server: " + server.URL + "}\n"))
+			assert.NoError(t, err)
+		case strings.HasPrefix(r.URL.Path, "/pulumi-resource-mock-v1.0.0-"):
+			_, err := w.Write(tgz)
+			assert.NoError(t, err)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	transport := &headerTransport{}
+	ctx := &Context{HTTPClient: &http.Client{Transport: transport}}
+
+	bundle, err := ctx.LoadPluginBundle(server.URL + "/bundle.yaml")
+	require.NoError(t, err)
+	require.Len(t, bundle.Plugins, 1)
+
+	r, _, err := ctx.Download(bundle.Plugins[0])
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, tgz, b)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&transport.requests))
+
+	// Without the context's client, requests are sent without the header.
+	v := semver.MustParse("1.0.0")
+	_, _, err = PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &v, PluginDownloadURL: server.URL}.Download()
+	var httpErr *HTTPError
+	require.True(t, errors.As(err, &httpErr), "expected %v to be an *HTTPError", err)
+	assert.Equal(t, http.StatusForbidden, httpErr.StatusCode)
+}
+
+func TestLoggedHeaders(t *testing.T) {
+	t.Parallel()
+
+	logging.AddGlobalFilter(logging.CreateFilter([]string{"entitlement-token"}, "[credential]"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=abc")
+		_, err := w.Write([]byte("ok"))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	logger := &recordingLogger{}
+	ctx := &Context{PluginDir: t.TempDir(), Logger: logger}
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/index.json", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer backend-token")
+	req.Header.Set("X-Entitlement", "entitlement-token")
+	r, _, err := ctx.sendHTTPRequest(req)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	// Loggers are sent the headers without the credentials in them.
+	for _, entry := range logger.entries {
+		assert.NotContains(t, entry.message, "backend-token")
+		assert.NotContains(t, entry.message, "entitlement-token")
+		assert.NotContains(t, entry.message, "session=abc")
+	}
+	_, ok := logger.find("Authorization:[Bearer [credential]]")
+	assert.True(t, ok)
+	_, ok = logger.find("X-Entitlement:[[credential]]")
+	assert.True(t, ok)
+}
@@ -0,0 +1,368 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// PluginIndexURLsEnvVar is a comma-separated list of plugin index URLs that are searched, in order, for plugins that
+// don't have a PluginDownloadURL, before the default sources.
+const PluginIndexURLsEnvVar = "PULUMI_PLUGIN_INDEX_URLS"
+
+// PluginIndex lists the published versions of a plugin. A plugin index is a static tree of these files, one per
+// plugin at `<index URL>/<kind>/<name>.json`, so it can be hosted on any web server or CDN.
+type PluginIndex struct {
+	// Versions are the published versions of the plugin.
+	Versions []PluginIndexVersion `json:"versions"`
+}
+
+// PluginIndexVersion describes a published version of a plugin.
+type PluginIndexVersion struct {
+	// Version is the plugin's semantic version.
+	Version string `json:"version"`
+	// Assets maps each platform the version is published for, as `<os>-<arch>`, to its tarball.
+	Assets map[string]PluginIndexAsset `json:"assets"`
+	// ReleaseNotes are the release notes of the version, if any.
+	ReleaseNotes string `json:"releaseNotes,omitempty"`
+	// Entitlement, if set, is the entitlement required to download the version's assets. The token issued for it is
+	// sent with each asset download.
+	Entitlement *EntitlementRequirement `json:"entitlement,omitempty"`
+}
+
+// PluginIndexAsset is a plugin tarball listed in a plugin index.
+type PluginIndexAsset struct {
+	// URL is where the tarball is downloaded from. Relative URLs are resolved against the URL of the index file.
+	URL string `json:"url"`
+	// SHA256 is the hex-encoded SHA-256 digest of the tarball, which is verified as it's downloaded.
+	SHA256 string `json:"sha256"`
+	// Size is the size of the tarball in bytes. If it's set, no more than that is read from LAN peers that offer it,
+	// or produced by its patches.
+	Size int64 `json:"size,omitempty"`
+	// Patches are binary diffs that produce this tarball from the tarballs of earlier versions. They are only used if
+	// SHA256 is set, so the patched tarball can be verified.
+	Patches []PluginIndexPatch `json:"patches,omitempty"`
+	// ExtractedSize is the number of bytes the tarball's contents take up once extracted. If it's set, installs check
+	// there's that much free disk space instead of estimating how much is needed from the size of the tarball.
+	ExtractedSize int64 `json:"extractedSize,omitempty"`
+}
+
+// size returns the size of the tarball, or -1 if the index doesn't list it.
+func (asset PluginIndexAsset) size() int64 {
+	if asset.Size <= 0 {
+		return -1
+	}
+	return asset.Size
+}
+
+// PluginIndexPatch is a bsdiff patch listed in a plugin index, which turns the tarball of an earlier version of a
+// plugin into that of the asset listing it.
+type PluginIndexPatch struct {
+	// From is the version whose tarball the patch applies to.
+	From string `json:"from"`
+	// URL is where the patch is downloaded from. Relative URLs are resolved against the URL of the index file.
+	URL string `json:"url"`
+	// SHA256 is the hex-encoded SHA-256 digest of the patch itself.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// pluginURLIndexFile is the file at the root of a PluginDownloadURL that lists the plugin's versions, as a PluginIndex,
+// so its latest version can be found. Its assets aren't used: tarballs are downloaded from the PluginDownloadURL.
+const pluginURLIndexFile = "index.json"
+
+// pluginURLIndexURL returns the URL of the pluginURLIndexFile of a PluginDownloadURL. The root of a URL that depends on
+// the version is the folder above the first segment that does, that of a URL that names the plugin's tarball is at
+// most the folder the tarball is in, and the plugin and current platform are interpolated into it.
+func pluginURLIndexURL(downloadURL string, kind PluginKind, name string) (string, error) {
+	root, err := expandURLEnv(downloadURL)
+	if err != nil {
+		return "", err
+	}
+	if namesPluginFile(root) {
+		dir, _, query := cutURLPath(root)
+		root = dir + query
+	}
+	if i := strings.Index(root, "${VERSION}"); i >= 0 {
+		slash := strings.LastIndex(root[:i], "/")
+		if scheme := strings.Index(root, "://"); scheme < 0 || slash < scheme+3 {
+			return "", fmt.Errorf("the latest version of plugins can't be found in %s, since its host depends on "+
+				"the version", downloadURL)
+		}
+		root = root[:slash]
+	}
+	root = interpolateURL(root, kind, name, semver.Version{}, runtime.GOOS, runtime.GOARCH)
+	return strings.TrimSuffix(root, "/") + "/" + pluginURLIndexFile, nil
+}
+
+// pluginIndexSource looks for plugins in a list of plugin indexes, and defers to the next source for plugins none of
+// the indexes list.
+type pluginIndexSource struct {
+	indexURLs []string
+	name      string
+	kind      PluginKind
+	next      PluginSource
+}
+
+func newPluginIndexSource(indexURLs []string, name string, kind PluginKind, next PluginSource) *pluginIndexSource {
+	return &pluginIndexSource{
+		indexURLs: indexURLs,
+		name:      name,
+		kind:      kind,
+		next:      next,
+	}
+}
+
+// indexes fetches the plugin's index file from each of the configured indexes that list it, returning the URL each
+// was fetched from alongside it. Indexes that can't be fetched are treated as not listing the plugin.
+func (source *pluginIndexSource) indexes(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]string, []PluginIndex) {
+	var urls []string
+	var indexes []PluginIndex
+	for _, indexURL := range source.indexURLs {
+		fileURL := fmt.Sprintf("%s/%s/%s.json", strings.TrimSuffix(indexURL, "/"), source.kind, source.name)
+		index, err := fetchPluginIndex(fileURL, "", getHTTPResponse)
+		if err != nil {
+			logf(5, sourceLogFields(source.kind, source.name), "plugin %s not found in index %s: %v", source.name, indexURL, err)
+			continue
+		}
+		urls, indexes = append(urls, fileURL), append(indexes, index)
+	}
+	return urls, indexes
+}
+
+// fetchPluginIndex fetches the plugin index file at fileURL, authenticating with token if it's set.
+func fetchPluginIndex(fileURL, token string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (PluginIndex, error) {
+	req, err := buildHTTPRequest(fileURL, token)
+	if err != nil {
+		return PluginIndex{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, _, err := getHTTPResponse(req)
+	if err != nil {
+		return PluginIndex{}, err
+	}
+	defer contract.IgnoreClose(resp)
+
+	body, err := ioutil.ReadAll(resp)
+	if err != nil {
+		return PluginIndex{}, err
+	}
+	var index PluginIndex
+	if err := json.Unmarshal(body, &index); err != nil {
+		return PluginIndex{}, fmt.Errorf("parsing plugin index %s: %w", fileURL, err)
+	}
+	return index, nil
+}
+
+func (source *pluginIndexSource) GetLatestVersion(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
+	_, indexes := source.indexes(getHTTPResponse)
+
+	var latest *semver.Version
+	for _, index := range indexes {
+		if version := index.latestVersion(source.kind, source.name); version != nil {
+			if latest == nil || version.GT(*latest) {
+				latest = version
+			}
+		}
+	}
+	if latest == nil {
+		return source.next.GetLatestVersion(getHTTPResponse)
+	}
+	return latest, nil
+}
+
+// ListVersions implements versionListingSource, using the versions the indexes list, or the next source's versions if
+// none of them list the plugin.
+func (source *pluginIndexSource) ListVersions(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, error) {
+	_, indexes := source.indexes(getHTTPResponse)
+	var versions []semver.Version
+	for _, index := range indexes {
+		versions = append(versions, index.versions(source.kind, source.name)...)
+	}
+	if len(versions) == 0 {
+		return listSourceVersions(source.next, getHTTPResponse)
+	}
+	return versions, nil
+}
+
+// versions returns the valid versions the index lists.
+func (index PluginIndex) versions(kind PluginKind, name string) []semver.Version {
+	versions := make([]semver.Version, 0, len(index.Versions))
+	for _, v := range index.Versions {
+		version, err := semver.ParseTolerant(v.Version)
+		if err != nil {
+			logf(5, sourceLogFields(kind, name),
+				"skipping invalid version %q of plugin %s in index: %v", v.Version, name, err)
+			continue
+		}
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+// latestVersion returns the latest version the index lists that isn't a prerelease, or nil if it lists none.
+func (index PluginIndex) latestVersion(kind PluginKind, name string) *semver.Version {
+	return latestReleasedVersion(index.versions(kind, name))
+}
+
+func (source *pluginIndexSource) Download(
+	version semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	urls, indexes := source.indexes(getHTTPResponse)
+	for i, index := range indexes {
+		asset, entitlement, ok := index.asset(version, opSy+"-"+arch)
+		if !ok {
+			continue
+		}
+		resp, length, err := source.fetch(urls[i], asset.URL, asset.SHA256, version, entitlement, getHTTPResponse)
+		if err != nil {
+			return nil, -1, err
+		}
+		return withExtractedSize(resp, asset.ExtractedSize), length, nil
+	}
+	return source.next.Download(version, opSy, arch, getHTTPResponse)
+}
+
+// Checksum implements checksumSource, using the digest and size listed for the version's asset.
+func (source *pluginIndexSource) Checksum(version semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (string, int64, error) {
+	_, indexes := source.indexes(getHTTPResponse)
+	for _, index := range indexes {
+		if asset, _, ok := index.asset(version, opSy+"-"+arch); ok {
+			return asset.SHA256, asset.size(), nil
+		}
+	}
+	return "", -1, nil
+}
+
+// DownloadPatch implements patchSource, using the patches listed for the version's asset.
+func (source *pluginIndexSource) DownloadPatch(from, to semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, string, int64, error) {
+	urls, indexes := source.indexes(getHTTPResponse)
+	for i, index := range indexes {
+		asset, entitlement, ok := index.asset(to, opSy+"-"+arch)
+		if !ok {
+			continue
+		}
+		if asset.SHA256 == "" {
+			return nil, "", -1, nil
+		}
+		for _, patch := range asset.Patches {
+			if parsed, err := semver.ParseTolerant(patch.From); err != nil || !parsed.Equals(from) {
+				continue
+			}
+			r, _, err := source.fetch(urls[i], patch.URL, patch.SHA256, to, entitlement, getHTTPResponse)
+			if err != nil {
+				return nil, "", -1, err
+			}
+			return r, asset.SHA256, asset.size(), nil
+		}
+		return nil, "", -1, nil
+	}
+	return nil, "", -1, nil
+}
+
+// fetch downloads ref, a URL listed in the index file at indexURL, verifying it against the expected SHA-256 digest if
+// one is given.
+func (source *pluginIndexSource) fetch(indexURL, ref, expected string, version semver.Version,
+	entitlement *EntitlementRequirement,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	base, err := url.Parse(indexURL)
+	if err != nil {
+		return nil, -1, err
+	}
+	fileURL, err := base.Parse(ref)
+	if err != nil {
+		return nil, -1, fmt.Errorf("invalid URL for plugin %s in index %s: %w", source.name, indexURL, err)
+	}
+
+	logf(1, sourceLogFields(source.kind, source.name), "%s downloading from %s", source.name, fileURL)
+	req, err := buildHTTPRequest(fileURL.String(), "")
+	if err != nil {
+		return nil, -1, err
+	}
+	if err := applyEntitlement(req, source.name, source.kind, version, entitlement); err != nil {
+		return nil, -1, err
+	}
+	resp, length, err := getHTTPResponse(req)
+	if err != nil {
+		return nil, -1, err
+	}
+	if expected == "" {
+		return resp, length, nil
+	}
+	return newChecksumVerifyingReader(resp, expected, fileURL.String()), length, nil
+}
+
+// asset returns the tarball the index lists for the given version and `<os>-<arch>` platform, along with the
+// entitlement required to download it, if any.
+func (index PluginIndex) asset(version semver.Version,
+	platform string) (PluginIndexAsset, *EntitlementRequirement, bool) {
+	for _, v := range index.Versions {
+		parsed, err := semver.ParseTolerant(v.Version)
+		if err != nil || !parsed.Equals(version) {
+			continue
+		}
+		asset, ok := v.Assets[platform]
+		return asset, v.Entitlement, ok
+	}
+	return PluginIndexAsset{}, nil, false
+}
+
+// checksumVerifyingReader wraps a download, failing the read that reaches the end of it if its SHA-256 digest doesn't
+// match the expected one.
+type checksumVerifyingReader struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected string
+	name     string
+}
+
+func newChecksumVerifyingReader(r io.ReadCloser, expected, name string) io.ReadCloser {
+	return &checksumVerifyingReader{
+		ReadCloser: r,
+		hash:       sha256.New(),
+		expected:   strings.ToLower(expected),
+		name:       name,
+	}
+}
+
+func (r *checksumVerifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		if actual := hex.EncodeToString(r.hash.Sum(nil)); actual != r.expected {
+			return n, checksumMismatchError(r.name, r.expected, actual)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,143 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nextSource records that the index source deferred to it.
+type nextSource struct {
+	called bool
+}
+
+func (s *nextSource) GetLatestVersion(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
+	s.called = true
+	return nil, errors.New("next source")
+}
+
+func (s *nextSource) Download(version semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	s.called = true
+	return nil, -1, errors.New("next source")
+}
+
+// serveURLs returns a getHTTPResponse that serves the given bodies by URL, and 404s everything else.
+func serveURLs(bodies map[string]string) func(*http.Request) (io.ReadCloser, int64, error) {
+	return func(req *http.Request) (io.ReadCloser, int64, error) {
+		body, ok := bodies[req.URL.String()]
+		if !ok {
+			return nil, -1, newHTTPError(req, &http.Response{StatusCode: http.StatusNotFound},
+				fmt.Sprintf("404 HTTP error fetching plugin from %s", req.URL))
+		}
+		return newMockReadCloserString(body)
+	}
+}
+
+func TestPluginIndexSource(t *testing.T) {
+	t.Parallel()
+
+	tarball := "not really a tarball"
+	sum := sha256.Sum256([]byte(tarball))
+	checksum := hex.EncodeToString(sum[:])
+
+	getHTTPResponse := serveURLs(map[string]string{
+		"https://a.example.com/resource/mock.json": `{"versions": [
+			{"version": "1.0.0", "assets": {"linux-amd64": {"url": "https://cdn.example.com/mock-1.0.0.tgz"}}},
+			{"version": "2.0.0-alpha.1", "assets": {}}
+		]}`,
+		"https://b.example.com/index/resource/mock.json": fmt.Sprintf(`{"versions": [
+			{"version": "1.1.0", "assets": {
+				"linux-amd64": {"url": "../../assets/mock-1.1.0.tgz", "sha256": "%s"},
+				"darwin-arm64": {"url": "../../assets/mock-1.1.0.tgz", "sha256": "0000"}
+			}}
+		]}`, checksum),
+		"https://b.example.com/assets/mock-1.1.0.tgz": tarball,
+	})
+	indexes := []string{"https://a.example.com", "https://b.example.com/index/", "https://c.example.com"}
+
+	t.Run("latest version", func(t *testing.T) {
+		t.Parallel()
+
+		source := newPluginIndexSource(indexes, "mock", ResourcePlugin, &nextSource{})
+		version, err := source.GetLatestVersion(getHTTPResponse)
+		require.NoError(t, err)
+		assert.Equal(t, semver.MustParse("1.1.0"), *version)
+	})
+
+	t.Run("download", func(t *testing.T) {
+		t.Parallel()
+
+		source := newPluginIndexSource(indexes, "mock", ResourcePlugin, &nextSource{})
+		r, _, err := source.Download(semver.MustParse("1.1.0"), "linux", "amd64", getHTTPResponse)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, tarball, string(b))
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		t.Parallel()
+
+		source := newPluginIndexSource(indexes, "mock", ResourcePlugin, &nextSource{})
+		r, _, err := source.Download(semver.MustParse("1.1.0"), "darwin", "arm64", getHTTPResponse)
+		require.NoError(t, err)
+		_, err = ioutil.ReadAll(r)
+		assert.EqualError(t, err, "checksum mismatch for https://b.example.com/assets/mock-1.1.0.tgz: "+
+			"expected sha256 0000, got "+checksum)
+	})
+
+	t.Run("unlisted", func(t *testing.T) {
+		t.Parallel()
+
+		next := &nextSource{}
+		source := newPluginIndexSource(indexes, "mock", ResourcePlugin, next)
+		_, _, err := source.Download(semver.MustParse("3.0.0"), "linux", "amd64", getHTTPResponse)
+		assert.EqualError(t, err, "next source")
+		assert.True(t, next.called)
+
+		next = &nextSource{}
+		source = newPluginIndexSource(indexes, "other", ResourcePlugin, next)
+		_, err = source.GetLatestVersion(getHTTPResponse)
+		assert.EqualError(t, err, "next source")
+		assert.True(t, next.called)
+	})
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestGetSourceWithPluginIndex(t *testing.T) {
+	t.Setenv(PluginIndexURLsEnvVar, "https://a.example.com,https://b.example.com")
+	source := PluginInfo{Name: "mock", Kind: ResourcePlugin}.GetSource()
+	index, ok := source.(*pluginIndexSource)
+	require.True(t, ok)
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, index.indexURLs)
+	assert.IsType(t, &fallbackSource{}, index.next)
+
+	// A PluginDownloadURL takes precedence over the indexes.
+	source = PluginInfo{Name: "mock", Kind: ResourcePlugin, PluginDownloadURL: "https://example.com"}.GetSource()
+	assert.IsType(t, &pluginURLSource{}, source)
+}
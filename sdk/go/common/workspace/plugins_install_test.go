@@ -18,9 +18,7 @@
 package workspace
 
 import (
-	"archive/tar"
 	"bytes"
-	"compress/gzip"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -35,36 +33,6 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-// createTGZ creates an in-memory tarball.
-func createTGZ(files map[string][]byte) ([]byte, error) {
-	buffer := &bytes.Buffer{}
-	gw := gzip.NewWriter(buffer)
-	writer := tar.NewWriter(gw)
-
-	for name, content := range files {
-		if err := writer.WriteHeader(&tar.Header{
-			Name: name,
-			Size: int64(len(content)),
-			Mode: 0600,
-		}); err != nil {
-			return nil, err
-		}
-		if _, err := writer.Write(content); err != nil {
-			return nil, err
-		}
-	}
-
-	// Close the tar and gzip writers to flush and write footers.
-	if err := writer.Close(); err != nil {
-		return nil, err
-	}
-	if err := gw.Close(); err != nil {
-		return nil, err
-	}
-
-	return buffer.Bytes(), nil
-}
-
 func prepareTestPluginTGZ(t *testing.T, files map[string][]byte) io.ReadCloser {
 	if files == nil {
 		files = map[string][]byte{}
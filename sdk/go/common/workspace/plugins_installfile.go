@@ -0,0 +1,52 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// InstallFromFile installs the plugin from the tarball at path, instead of one that was downloaded. It's installed like
+// InstallWithProgress installs downloaded tarballs, so it gets the same checks, dependency install and handling of
+// partial installs as they do, rather than being extracted into the plugin directory by hand. The plugin's kind, name
+// and version must be given, since they aren't read from the tarball.
+func (info PluginInfo) InstallFromFile(path string, reinstall bool, progress PluginInstallProgress) error {
+	if !IsPluginKind(string(info.Kind)) {
+		return fmt.Errorf("unrecognized plugin kind: %s", info.Kind)
+	} else if info.Name == "" {
+		return fmt.Errorf("a name is required to install a %s plugin from %s", info.Kind, path)
+	} else if info.Version == nil {
+		return fmt.Errorf("a version is required to install %s plugin %s from %s", info.Kind, info.Name, path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening file %s: %w", path, err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		contract.IgnoreClose(f)
+		return err
+	} else if stat.IsDir() {
+		contract.IgnoreClose(f)
+		return fmt.Errorf("%s is a directory; expected the tarball of a plugin", path)
+	}
+
+	info.logf(1, "installing %s plugin %s from %s", info.Kind, info, path)
+	return info.InstallWithProgress(f, reinstall, progress)
+}
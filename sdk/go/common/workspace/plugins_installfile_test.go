@@ -0,0 +1,54 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstallFromFile(t *testing.T) {
+	t.Parallel()
+
+	dir, info := newMockPlugin(t)
+	path := filepath.Join(t.TempDir(), "plugin.tar.gz")
+	require.NoError(t, ioutil.WriteFile(path, mockPluginTGZ(t), 0600))
+
+	require.NoError(t, info.InstallFromFile(path, false, nil))
+	assert.True(t, HasPlugin(info))
+	_, err := os.Stat(filepath.Join(dir, info.Dir()+".partial"))
+	assert.True(t, os.IsNotExist(err), "%v", err)
+	b, err := ioutil.ReadFile(filepath.Join(dir, info.Dir(), "data"))
+	require.NoError(t, err)
+	assert.Len(t, b, 4096)
+
+	// The tarball doesn't say which plugin it is, so that must be given.
+	noVersion := info
+	noVersion.Version = nil
+	err = noVersion.InstallFromFile(path, false, nil)
+	assert.EqualError(t, err, "a version is required to install resource plugin mock from "+path)
+
+	err = info.InstallFromFile(filepath.Dir(path), true, nil)
+	assert.EqualError(t, err, filepath.Dir(path)+" is a directory; expected the tarball of a plugin")
+
+	err = info.InstallFromFile(filepath.Join(filepath.Dir(path), "missing.tar.gz"), true, nil)
+	assert.True(t, errors.Is(err, os.ErrNotExist), "%v", err)
+}
@@ -0,0 +1,267 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// pluginKeptFilesDir is the directory in the plugin cache that holds the files of removed plugins that were changed or
+// added since they were installed.
+const pluginKeptFilesDir = ".kept"
+
+// PluginInstalledFile is a file that was created by a plugin's install, as recorded in its install receipt.
+type PluginInstalledFile struct {
+	// Path is the file's slash-separated path, relative to the plugin's directory.
+	Path string `json:"path"`
+	// Size is the file's size.
+	Size int64 `json:"size"`
+	// SHA256 is the hex-encoded digest of the file's contents. It's empty for symlinks.
+	SHA256 string `json:"sha256,omitempty"`
+	// Link is the target of the file, if it's a symlink.
+	Link string `json:"link,omitempty"`
+	// Dependency is true if the file was created by the install of the plugin's dependencies, rather than extracted
+	// from its tarball.
+	Dependency bool `json:"dependency,omitempty"`
+}
+
+// PluginFileChanges are the changes to a plugin's files since it was installed.
+type PluginFileChanges struct {
+	// Modified are the installed files whose contents have changed.
+	Modified []string
+	// Missing are the installed files that no longer exist.
+	Missing []string
+	// Added are the files that weren't installed, other than caches, which match the plugin size excludes.
+	Added []string
+}
+
+// Empty returns true if none of the plugin's files have changed.
+func (changes *PluginFileChanges) Empty() bool {
+	return len(changes.Modified) == 0 && len(changes.Missing) == 0 && len(changes.Added) == 0
+}
+
+// listPluginFiles returns the files in the plugin directory dir, other than its install receipt and those that match
+// the excludes, keyed by their slash-separated paths. Their digests are only computed if digest is true.
+func listPluginFiles(dir string, excludes []string, digest bool) (map[string]PluginInstalledFile, error) {
+	files := map[string]PluginInstalledFile{}
+	err := filepath.Walk(dir, func(path string, stat os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case rel == ".":
+			return nil
+		case pluginSizeExcluded(rel, excludes):
+			if stat.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		case stat.IsDir() || rel == PluginInstallReceiptFile:
+			return nil
+		}
+
+		file := PluginInstalledFile{Path: rel, Size: stat.Size()}
+		if stat.Mode()&os.ModeSymlink != 0 {
+			if file.Link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		} else if digest {
+			if file.SHA256, err = fileSHA256(path); err != nil {
+				return err
+			}
+		}
+		files[rel] = file
+		return nil
+	})
+	return files, err
+}
+
+// fileSHA256 returns the hex-encoded digest of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer contract.IgnoreClose(f)
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// installedPluginFiles returns the files the install of the plugin in dir created, sorted by path. extracted are the
+// files that were extracted from its tarball; the rest were created by the install of its dependencies.
+func installedPluginFiles(info PluginInfo, dir string, extracted map[string]PluginInstalledFile) (
+	[]PluginInstalledFile, error) {
+	excludes, err := info.context().getPluginSizeExcludes()
+	if err != nil {
+		excludes = DefaultPluginSizeExcludes
+	}
+	listed, err := listPluginFiles(dir, excludes, true /* digest */)
+	if err != nil {
+		return nil, fmt.Errorf("listing the installed files of plugin %s: %w", info, err)
+	}
+	files := make([]PluginInstalledFile, 0, len(listed))
+	for rel, file := range listed {
+		_, fromTarball := extracted[rel]
+		file.Dependency = !fromTarball
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// GetInstalledFileChanges returns the changes to the plugin's files since it was installed, or nil if its install
+// receipt doesn't list its files, such as when it was installed by an older version of Pulumi.
+func (info PluginInfo) GetInstalledFileChanges() (*PluginFileChanges, error) {
+	dir, err := info.DirPath()
+	if err != nil {
+		return nil, err
+	}
+	receipt, err := info.GetInstallReceipt()
+	if err != nil || receipt == nil || len(receipt.Files) == 0 {
+		return nil, err
+	}
+	return info.installedFileChanges(dir, receipt.Files)
+}
+
+func (info PluginInfo) installedFileChanges(dir string, installed []PluginInstalledFile) (*PluginFileChanges, error) {
+	excludes, err := info.context().getPluginSizeExcludes()
+	if err != nil {
+		excludes = DefaultPluginSizeExcludes
+	}
+	current, err := listPluginFiles(dir, excludes, true /* digest */)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := &PluginFileChanges{}
+	for _, file := range installed {
+		now, ok := current[file.Path]
+		delete(current, file.Path)
+		switch {
+		case !ok:
+			changes.Missing = append(changes.Missing, file.Path)
+		case now.SHA256 != file.SHA256 || now.Link != file.Link:
+			changes.Modified = append(changes.Modified, file.Path)
+		}
+	}
+	for rel := range current {
+		changes.Added = append(changes.Added, rel)
+	}
+	sort.Strings(changes.Added)
+	return changes, nil
+}
+
+// removeInstalledFiles removes the files the plugin's install receipt lists from dir, the plugin's directory, along
+// with its receipt and the directories that are left empty, returning false if the receipt doesn't list the plugin's
+// files. Files that were modified or added since the install are kept, and the directory holding them moved out of
+// the way, so the plugin is no longer installed.
+func (info PluginInfo) removeInstalledFiles(dir string) (bool, error) {
+	receipt, err := info.GetInstallReceipt()
+	if err != nil || receipt == nil || len(receipt.Files) == 0 {
+		return false, nil
+	}
+	// Encrypted plugins only have their sealed files until they're decrypted.
+	if _, err := os.Stat(filepath.Join(dir, pluginSealedFile)); err == nil {
+		return false, nil
+	}
+
+	changes, err := info.installedFileChanges(dir, receipt.Files)
+	if err != nil {
+		return false, err
+	}
+	modified := map[string]bool{}
+	for _, rel := range changes.Modified {
+		modified[rel] = true
+	}
+	for _, file := range receipt.Files {
+		if modified[file.Path] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, filepath.FromSlash(file.Path))); err != nil && !os.IsNotExist(err) {
+			return true, err
+		}
+	}
+	if err := os.Remove(filepath.Join(dir, PluginInstallReceiptFile)); err != nil && !os.IsNotExist(err) {
+		return true, err
+	}
+
+	// Caches aren't kept, so directories that only hold caches are removed too.
+	excludes, err := info.context().getPluginSizeExcludes()
+	if err != nil {
+		excludes = DefaultPluginSizeExcludes
+	}
+	var dirs []string
+	err = filepath.Walk(dir, func(path string, stat os.FileInfo, err error) error {
+		if err != nil || !stat.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && pluginSizeExcluded(filepath.ToSlash(rel), excludes) {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		}
+		dirs = append(dirs, path)
+		return nil
+	})
+	if err != nil {
+		return true, err
+	}
+	// Children are removed before their parents, which sort before them.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		contract.IgnoreError(os.Remove(dirs[i]))
+	}
+	if _, err := os.Lstat(dir); os.IsNotExist(err) {
+		return true, nil
+	}
+
+	kept := filepath.Join(filepath.Dir(dir), pluginKeptFilesDir,
+		filepath.Base(dir)+"-"+info.now().UTC().Format("20060102T150405Z"))
+	if err := os.MkdirAll(filepath.Dir(kept), 0700); err != nil {
+		return true, err
+	}
+	if err := os.Rename(dir, kept); err != nil {
+		return true, err
+	}
+	keptFiles := append(changes.Modified, changes.Added...)
+	sort.Strings(keptFiles)
+	if len(keptFiles) > 10 {
+		keptFiles = append(keptFiles[:10], fmt.Sprintf("and %d more", len(keptFiles)-10))
+	}
+	info.warnf("plugin %s was removed, but its files that were changed or added since it was installed were kept in "+
+		"%s: %s", info, kept, strings.Join(keptFiles, ", "))
+	return true, nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstalledFiles(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	ctx := &Context{Home: t.TempDir(), Clock: FixedClock(now)}
+	v := semver.MustParse("1.0.0")
+	tgz, err := createTGZWithMode(map[string][]byte{
+		"pulumi-resource-test":     nil,
+		"pulumi-resource-test.exe": nil,
+		"README.md":                []byte("# test"),
+		"lib/data.txt":             []byte("data"),
+	}, 0700)
+	require.NoError(t, err)
+	install := func() (PluginInfo, string) {
+		info, err := ctx.Plugin(PluginInfo{Name: "test", Kind: ResourcePlugin, Version: &v})
+		require.NoError(t, err)
+		require.NoError(t, info.Install(ioutil.NopCloser(bytes.NewReader(tgz)), false))
+		dir, err := info.DirPath()
+		require.NoError(t, err)
+		return info, dir
+	}
+	write := func(dir, rel, contents string) {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+	}
+
+	// The receipt lists every file the install extracted.
+	info, dir := install()
+	receipt, err := info.GetInstallReceipt()
+	require.NoError(t, err)
+	require.NotNil(t, receipt)
+	var paths []string
+	for _, file := range receipt.Files {
+		paths = append(paths, file.Path)
+		assert.False(t, file.Dependency)
+	}
+	assert.Equal(t, []string{"README.md", "lib/data.txt", "pulumi-resource-test", "pulumi-resource-test.exe"}, paths)
+	assert.Equal(t, "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7", receipt.Files[1].SHA256)
+	changes, err := info.GetInstalledFileChanges()
+	require.NoError(t, err)
+	assert.True(t, changes.Empty())
+
+	// Changes are detected, other than to caches.
+	write(dir, "lib/data.txt", "changed")
+	write(dir, "notes.txt", "mine")
+	write(dir, "lib/__pycache__/data.pyc", "cache")
+	require.NoError(t, os.Remove(filepath.Join(dir, "README.md")))
+	changes, err = info.GetInstalledFileChanges()
+	require.NoError(t, err)
+	assert.Equal(t, &PluginFileChanges{
+		Modified: []string{"lib/data.txt"},
+		Missing:  []string{"README.md"},
+		Added:    []string{"notes.txt"},
+	}, changes)
+
+	// Deleting the plugin removes what it installed, and keeps the files that were changed or added.
+	require.NoError(t, info.Delete())
+	assert.False(t, HasPlugin(info))
+	kept := filepath.Join(filepath.Dir(dir), pluginKeptFilesDir, "resource-test-v1.0.0-20220601T120000Z")
+	keptFiles, err := listPluginFiles(kept, nil, false /* digest */)
+	require.NoError(t, err)
+	assert.Len(t, keptFiles, 2)
+	assert.Contains(t, keptFiles, "lib/data.txt")
+	assert.Contains(t, keptFiles, "notes.txt")
+
+	// Plugins that weren't changed are removed entirely.
+	info, dir = install()
+	write(dir, "__pycache__/main.pyc", "cache")
+	ctx.Clock = FixedClock(now.Add(time.Hour))
+	require.NoError(t, info.Delete())
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+	entries, err := ioutil.ReadDir(filepath.Join(filepath.Dir(dir), pluginKeptFilesDir))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestInstalledPluginFilesDependencies(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "package.json"), []byte("{}"), 0600))
+	extracted, err := listPluginFiles(dir, nil, false /* digest */)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "node_modules", "left-pad"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "node_modules", "left-pad", "index.js"), nil, 0600))
+
+	files, err := installedPluginFiles(PluginInfo{Name: "test"}, dir, extracted)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, "node_modules/left-pad/index.js", files[0].Path)
+	assert.True(t, files[0].Dependency)
+	assert.Equal(t, "package.json", files[1].Path)
+	assert.False(t, files[1].Dependency)
+}
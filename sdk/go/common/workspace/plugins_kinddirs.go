@@ -0,0 +1,85 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	// LanguagePluginDirEnvVar is the directory language plugins are installed into, instead of the plugin directory.
+	LanguagePluginDirEnvVar = "PULUMI_LANGUAGE_PLUGIN_DIR"
+	// ResourcePluginDirEnvVar is the directory resource plugins are installed into, instead of the plugin directory.
+	ResourcePluginDirEnvVar = "PULUMI_RESOURCE_PLUGIN_DIR"
+	// AnalyzerPluginDirEnvVar is the directory analyzer plugins are installed into, instead of the plugin directory.
+	AnalyzerPluginDirEnvVar = "PULUMI_ANALYZER_PLUGIN_DIR"
+)
+
+// GetPluginKindDir returns the directory plugins of the given kind are installed into: the directory its environment
+// variable, such as `PULUMI_RESOURCE_PLUGIN_DIR`, or the dirs in PluginConfigFile set, or the plugin directory.
+func GetPluginKindDir(kind PluginKind) (string, error) {
+	return (&Context{}).GetPluginKindDir(kind)
+}
+
+// GetPluginKindDir returns the directory plugins of the given kind are installed into, like the package-level
+// GetPluginKindDir. The context's PluginDir, if set, is used for every kind.
+func (ctx *Context) GetPluginKindDir(kind PluginKind) (string, error) {
+	if ctx.PluginDir != "" {
+		return ctx.PluginDir, nil
+	}
+	if opts, ok := getPluginKindOptions(kind); ok && opts.DirEnvVar != "" {
+		if dir := os.Getenv(opts.DirEnvVar); dir != "" {
+			return dir, nil
+		}
+	}
+	config, err := ctx.GetPluginConfig()
+	if err != nil {
+		return "", err
+	}
+	if dir := config.Dirs[kind]; dir != "" {
+		return dir, nil
+	}
+	return ctx.GetPluginDir()
+}
+
+// getPluginDirs returns the directories the context's plugins are installed into: the plugin directory, followed by
+// the other directories plugins of some kind are installed into.
+func (ctx *Context) getPluginDirs() ([]string, error) {
+	dir, err := ctx.GetPluginDir()
+	if err != nil {
+		return nil, err
+	}
+	dirs := []string{dir}
+	for _, kind := range PluginKinds() {
+		kindDir, err := ctx.GetPluginKindDir(kind)
+		if err != nil {
+			return nil, fmt.Errorf("getting the %s plugin directory: %w", kind, err)
+		}
+		if !containsString(dirs, kindDir) {
+			dirs = append(dirs, kindDir)
+		}
+	}
+	return dirs, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestGetPluginKindDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv(PulumiHomeEnvVar, home)
+	t.Setenv(ResourcePluginDirEnvVar, "")
+	t.Setenv(AnalyzerPluginDirEnvVar, "")
+	analyzers := filepath.Join(t.TempDir(), "analyzers")
+	writePluginConfig(t, home, "dirs:\n  analyzer: "+analyzers+"\n")
+
+	dir, err := GetPluginKindDir(LanguagePlugin)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, PluginDir), dir)
+
+	dir, err = GetPluginKindDir(AnalyzerPlugin)
+	require.NoError(t, err)
+	assert.Equal(t, analyzers, dir)
+
+	// The environment takes precedence over the config file.
+	resources := t.TempDir()
+	t.Setenv(ResourcePluginDirEnvVar, resources)
+	t.Setenv(AnalyzerPluginDirEnvVar, resources)
+	for _, kind := range []PluginKind{ResourcePlugin, AnalyzerPlugin} {
+		dir, err = GetPluginKindDir(kind)
+		require.NoError(t, err)
+		assert.Equal(t, resources, dir)
+	}
+
+	// A context's plugin directory takes precedence over both.
+	override := t.TempDir()
+	dir, err = (&Context{PluginDir: override}).GetPluginKindDir(ResourcePlugin)
+	require.NoError(t, err)
+	assert.Equal(t, override, dir)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestPluginKindDirsInstallAndList(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv(PulumiHomeEnvVar, home)
+	resources := t.TempDir()
+	t.Setenv(ResourcePluginDirEnvVar, resources)
+
+	v := semver.MustParse("1.0.0")
+	plugin := PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &v}
+	require.NoError(t, plugin.Install(ioutil.NopCloser(bytes.NewReader(mockPluginTGZ(t))), false))
+	dir, err := plugin.DirPath()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(resources, "resource-mock-v1.0.0"), dir)
+
+	// A plugin installed into the plugin directory before its kind had a directory of its own is still found.
+	old := PluginInfo{Name: "old", Kind: ResourcePlugin, Version: &v}
+	oldDir := filepath.Join(home, PluginDir, old.Dir())
+	require.NoError(t, os.MkdirAll(oldDir, 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(oldDir, old.File()), nil, 0700)) //nolint:gosec
+
+	plugins, err := GetPlugins()
+	require.NoError(t, err)
+	require.Len(t, plugins, 2)
+	byName := map[string]PluginInfo{}
+	for _, p := range plugins {
+		byName[p.Name] = p
+	}
+	assert.Equal(t, "", byName["mock"].PluginDir)
+	assert.Equal(t, filepath.Join(home, PluginDir), byName["old"].PluginDir)
+
+	_, path, err := GetPluginPath(ResourcePlugin, "old", &v)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(oldDir, old.File()), path)
+}
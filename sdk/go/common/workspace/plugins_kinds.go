@@ -0,0 +1,104 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+// PluginKindOptions describe a kind of plugin registered with RegisterPluginKind.
+type PluginKindOptions struct {
+	// DirEnvVar is the environment variable that sets the directory plugins of the kind are installed into, instead of
+	// the plugin directory, if any.
+	DirEnvVar string
+	// Bundled is true if plugins of the kind ship with the CLI, rather than being installed on demand.
+	Bundled bool
+	// GetPluginInfo asks a plugin of the kind, connected to over conn, for its info. Health checks use it to get the
+	// plugin's version; plugins of kinds without it are only checked to start.
+	GetPluginInfo func(ctx context.Context, conn *grpc.ClientConn) (*pulumirpc.PluginInfo, error)
+}
+
+// pluginKindRegexp matches the names of plugin kinds, which are part of plugins' directory and executable names, and
+// so can't contain dashes.
+var pluginKindRegexp = regexp.MustCompile(`^[a-z]+$`)
+
+var (
+	pluginKindsLock sync.RWMutex
+	// pluginKindOrder are the registered plugin kinds, in the order they were registered.
+	pluginKindOrder = []PluginKind{LanguagePlugin, ResourcePlugin, AnalyzerPlugin}
+	// pluginKinds are the options of each registered plugin kind.
+	pluginKinds = map[PluginKind]PluginKindOptions{
+		LanguagePlugin: {
+			DirEnvVar: LanguagePluginDirEnvVar,
+			Bundled:   true,
+			GetPluginInfo: func(ctx context.Context, conn *grpc.ClientConn) (*pulumirpc.PluginInfo, error) {
+				return pulumirpc.NewLanguageRuntimeClient(conn).GetPluginInfo(ctx, &emptypb.Empty{})
+			},
+		},
+		ResourcePlugin: {
+			DirEnvVar: ResourcePluginDirEnvVar,
+			GetPluginInfo: func(ctx context.Context, conn *grpc.ClientConn) (*pulumirpc.PluginInfo, error) {
+				return pulumirpc.NewResourceProviderClient(conn).GetPluginInfo(ctx, &emptypb.Empty{})
+			},
+		},
+		AnalyzerPlugin: {
+			DirEnvVar: AnalyzerPluginDirEnvVar,
+			GetPluginInfo: func(ctx context.Context, conn *grpc.ClientConn) (*pulumirpc.PluginInfo, error) {
+				return pulumirpc.NewAnalyzerClient(conn).GetPluginInfo(ctx, &emptypb.Empty{})
+			},
+		},
+	}
+)
+
+// RegisterPluginKind registers a kind of plugin, so plugins of the kind are recognized wherever a kind is parsed, such
+// as in plugin directory names, and can be installed and resolved like those of the built-in kinds. This lets other
+// packages, and experiments, add kinds of plugins. Kinds are lowercase letters, and can only be registered once.
+func RegisterPluginKind(kind PluginKind, opts PluginKindOptions) error {
+	if !pluginKindRegexp.MatchString(string(kind)) {
+		return fmt.Errorf("plugin kind %q must be lowercase letters", kind)
+	}
+
+	pluginKindsLock.Lock()
+	defer pluginKindsLock.Unlock()
+	if _, ok := pluginKinds[kind]; ok {
+		return fmt.Errorf("plugin kind %q is already registered", kind)
+	}
+	pluginKinds[kind] = opts
+	pluginKindOrder = append(pluginKindOrder, kind)
+	return nil
+}
+
+// PluginKinds returns the registered plugin kinds, in the order they were registered, starting with the built-in kinds.
+func PluginKinds() []PluginKind {
+	pluginKindsLock.RLock()
+	defer pluginKindsLock.RUnlock()
+	return append([]PluginKind(nil), pluginKindOrder...)
+}
+
+// getPluginKindOptions returns the options the kind was registered with, and false if it isn't registered.
+func getPluginKindOptions(kind PluginKind) (PluginKindOptions, bool) {
+	pluginKindsLock.RLock()
+	defer pluginKindsLock.RUnlock()
+	opts, ok := pluginKinds[kind]
+	return opts, ok
+}
@@ -0,0 +1,89 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testPluginKind          PluginKind = "tool"
+	testPluginKindDirEnvVar            = "PULUMI_TOOL_PLUGIN_DIR"
+)
+
+var registerTestPluginKindOnce sync.Once
+
+// registerTestPluginKind registers testPluginKind, once for every run of the tests.
+func registerTestPluginKind(t *testing.T) {
+	registerTestPluginKindOnce.Do(func() {
+		require.NoError(t, RegisterPluginKind(testPluginKind, PluginKindOptions{DirEnvVar: testPluginKindDirEnvVar}))
+	})
+}
+
+func TestRegisterPluginKind(t *testing.T) {
+	t.Parallel()
+
+	registerTestPluginKind(t)
+	assert.True(t, IsPluginKind("tool"))
+	assert.False(t, IsPluginKind("widget"))
+	kinds := PluginKinds()
+	assert.Equal(t, []PluginKind{LanguagePlugin, ResourcePlugin, AnalyzerPlugin}, kinds[:3])
+	assert.Contains(t, kinds, testPluginKind)
+
+	assert.EqualError(t, RegisterPluginKind(ResourcePlugin, PluginKindOptions{}),
+		`plugin kind "resource" is already registered`)
+	for _, kind := range []PluginKind{"", "my-tool", "Tool", "tool2"} {
+		assert.Error(t, RegisterPluginKind(kind, PluginKindOptions{}), kind)
+	}
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestRegisteredPluginKindInstallAndResolve(t *testing.T) {
+	registerTestPluginKind(t)
+	tools := t.TempDir()
+	t.Setenv(testPluginKindDirEnvVar, tools)
+
+	tgz, err := createTGZWithMode(map[string][]byte{"pulumi-tool-test": nil, "pulumi-tool-test.exe": nil}, 0700)
+	require.NoError(t, err)
+
+	// Plugins of registered kinds are installed into the directory of their kind.
+	ctx := &Context{Home: t.TempDir()}
+	v := semver.MustParse("1.2.0")
+	info, err := ctx.Plugin(PluginInfo{Name: "test", Kind: testPluginKind, Version: &v})
+	require.NoError(t, err)
+	require.NoError(t, info.Install(ioutil.NopCloser(bytes.NewReader(tgz)), false))
+	dir, err := info.DirPath()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(tools, "tool-test-v1.2.0"), dir)
+
+	// They're listed, and resolved like plugins of the built-in kinds.
+	plugins, err := ctx.GetPlugins()
+	require.NoError(t, err)
+	require.Len(t, plugins, 1)
+	assert.Equal(t, testPluginKind, plugins[0].Kind)
+	assert.Equal(t, "test", plugins[0].Name)
+	resolution, err := ctx.ResolvePlugin(testPluginKind, "test", nil)
+	require.NoError(t, err)
+	assert.Equal(t, PluginOriginCache, resolution.Origin)
+	assert.Equal(t, dir, resolution.Dir)
+}
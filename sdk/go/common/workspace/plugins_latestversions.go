@@ -0,0 +1,197 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"strings"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/version"
+)
+
+const (
+	// githubGraphQLURL is the endpoint of GitHub's GraphQL API.
+	githubGraphQLURL = "https://api.github.com/graphql"
+	// githubGraphQLBatchSize is how many repositories are looked up by each GraphQL query.
+	githubGraphQLBatchSize = 100
+)
+
+// PluginLatestVersion is the latest version of a plugin, or the reason it couldn't be found.
+type PluginLatestVersion struct {
+	// Plugin is the plugin whose latest version was looked up.
+	Plugin PluginInfo
+	// Version is its latest version, if it was found.
+	Version *semver.Version
+	// Err is the reason it wasn't.
+	Err error
+}
+
+// GetLatestVersions finds the latest versions of the given plugins. See Context.GetLatestVersions.
+func GetLatestVersions(plugins []PluginInfo) []PluginLatestVersion {
+	return (&Context{}).GetLatestVersions(plugins)
+}
+
+// GetLatestVersions finds the latest versions of the given plugins, in the same order, like GetLatestVersion does for
+// each of them. When GITHUB_TOKEN, or a token for all of github.com in GitHubTokensEnvVar, is set, the latest
+// releases of the plugins released on GitHub are looked up with a GraphQL query for each batch of them, rather than
+// a request for each plugin, since GitHub's GraphQL API requires authentication. Plugins the query finds no release
+// of, and plugins from other sources, are looked up one by one.
+func (ctx *Context) GetLatestVersions(plugins []PluginInfo) []PluginLatestVersion {
+	results := make([]PluginLatestVersion, len(plugins))
+
+	// Plugins are batched by the repository their releases are in.
+	var repos []githubRepo
+	batched := map[githubRepo][]int{}
+	for i, info := range plugins {
+		results[i].Plugin = info
+		repo, ok := ctx.githubRepoOf(info)
+		if !ok {
+			continue
+		}
+		if _, ok := batched[repo]; !ok {
+			repos = append(repos, repo)
+		}
+		batched[repo] = append(batched[repo], i)
+	}
+	for start := 0; start < len(repos); start += githubGraphQLBatchSize {
+		end := start + githubGraphQLBatchSize
+		if end > len(repos) {
+			end = len(repos)
+		}
+		releases, err := ctx.getLatestGitHubReleases(repos[start:end])
+		if err != nil {
+			ctx.logf(3, "looking up the latest releases of %d plugins on GitHub: %v", end-start, err)
+			continue
+		}
+		for repo, tag := range releases {
+			version, err := semver.ParseTolerant(tag)
+			if err != nil {
+				err = fmt.Errorf("invalid plugin semver: %w", err)
+			}
+			for _, i := range batched[repo] {
+				if err != nil {
+					results[i].Err = err
+				} else {
+					v := version
+					results[i].Version = &v
+				}
+			}
+		}
+	}
+
+	for i, info := range plugins {
+		if results[i].Version == nil && results[i].Err == nil {
+			results[i].Version, results[i].Err = ctx.GetLatestVersion(info)
+		}
+	}
+	return results
+}
+
+// githubRepo is a repository on GitHub.
+type githubRepo struct {
+	owner string
+	name  string
+}
+
+// githubRepoOf returns the GitHub repository the plugin's source looks its latest version up in first, if its latest
+// version can be looked up with a GraphQL query.
+func (ctx *Context) githubRepoOf(info PluginInfo) (githubRepo, bool) {
+	// The latest release of a repository is never a prerelease.
+	if githubReleaseToken("") == "" || ctx.prereleases() {
+		return githubRepo{}, false
+	}
+	switch source := ctx.pluginSource(info, info.Mirrors()).(type) {
+	case *githubSource:
+		return githubRepo{owner: source.organization, name: "pulumi-" + source.name}, true
+	case *fallbackSource:
+		if github, ok := source.entries()[0].source.(*githubSource); ok {
+			return githubRepo{owner: github.organization, name: "pulumi-" + github.name}, true
+		}
+		return githubRepo{}, false
+	default:
+		return githubRepo{}, false
+	}
+}
+
+// getLatestGitHubReleases returns the tags of the latest releases of the given repositories. Repositories that don't
+// exist, or have no releases, are left out.
+func (ctx *Context) getLatestGitHubReleases(repos []githubRepo) (map[githubRepo]string, error) {
+	var query strings.Builder
+	query.WriteString("query {")
+	for i, repo := range repos {
+		owner, err := json.Marshal(repo.owner)
+		contract.AssertNoError(err)
+		name, err := json.Marshal(repo.name)
+		contract.AssertNoError(err)
+		fmt.Fprintf(&query, " r%d: repository(owner: %s, name: %s) { latestRelease { tagName } }", i, owner, name)
+	}
+	query.WriteString(" }")
+	body, err := json.Marshal(map[string]string{"query": query.String()})
+	contract.AssertNoError(err)
+
+	req, err := http.NewRequest(http.MethodPost, githubGraphQLURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("pulumi-cli/1 (%s; %s)", version.Version, runtime.GOOS))
+	// A query spans the repositories of many owners, so it's sent the token for all of github.com. Repositories it
+	// can't see are left out, and looked up on their own, with their owners' tokens.
+	req.Header.Set("Authorization", fmt.Sprintf("token %s", githubReleaseToken("")))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, _, err := ctx.getHTTPResponse(req)
+	if err != nil {
+		return nil, err
+	}
+	defer contract.IgnoreClose(resp)
+	b, err := ioutil.ReadAll(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Repositories that aren't found are null, and reported in errors, which are otherwise ignored since they only
+	// concern the repositories they name.
+	var result struct {
+		Data map[string]*struct {
+			LatestRelease *struct {
+				TagName string `json:"tagName"`
+			} `json:"latestRelease"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return nil, fmt.Errorf("parsing the GraphQL response: %w", err)
+	}
+	if result.Data == nil && len(result.Errors) > 0 {
+		return nil, fmt.Errorf("GraphQL query failed: %s", result.Errors[0].Message)
+	}
+	releases := map[githubRepo]string{}
+	for i, repo := range repos {
+		if r := result.Data[fmt.Sprintf("r%d", i)]; r != nil && r.LatestRelease != nil {
+			releases[repo] = r.LatestRelease.TagName
+		}
+	}
+	return releases, nil
+}
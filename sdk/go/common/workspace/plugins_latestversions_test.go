@@ -0,0 +1,104 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestGetLatestVersions(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "secret")
+	t.Setenv("PULUMI_EXPERIMENTAL", "")
+	t.Setenv(PluginIndexURLsEnvVar, "")
+
+	var queries []string
+	var requested []string
+	respond := func(req *http.Request, status int, body string) *http.Response {
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}
+	}
+	ctx := &Context{Home: t.TempDir(), HTTPClient: &http.Client{Transport: roundTripperFunc(
+		func(req *http.Request) (*http.Response, error) {
+			requested = append(requested, req.Method+" "+req.URL.String())
+			switch {
+			case req.URL.String() == githubGraphQLURL:
+				assert.Equal(t, "token secret", req.Header.Get("Authorization"))
+				var body struct {
+					Query string `json:"query"`
+				}
+				require.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+				queries = append(queries, body.Query)
+				return respond(req, http.StatusOK, `{
+					"data": {"r0": {"latestRelease": {"tagName": "v5.1.0"}}, "r1": null},
+					"errors": [{"type": "NOT_FOUND", "path": ["r1"], "message": "Could not resolve to a Repository"}]
+				}`), nil
+			case strings.HasSuffix(req.URL.Path, "/pulumi-missing/releases/latest"):
+				return respond(req, http.StatusNotFound, `{"message": "Not Found"}`), nil
+			case req.URL.String() == "https://plugins.corp/index.json":
+				return respond(req, http.StatusNotFound, ""), nil
+			default:
+				t.Errorf("unexpected request %s %s", req.Method, req.URL)
+				return respond(req, http.StatusInternalServerError, ""), nil
+			}
+		})}}
+
+	results := ctx.GetLatestVersions([]PluginInfo{
+		{Kind: ResourcePlugin, Name: "aws"},
+		{Kind: ResourcePlugin, Name: "missing"},
+		{Kind: ResourcePlugin, Name: "aws"},
+		{Kind: ResourcePlugin, Name: "custom", PluginDownloadURL: "https://plugins.corp"},
+	})
+	require.Len(t, results, 4)
+
+	// The plugins on GitHub are looked up with a single query.
+	require.Len(t, queries, 1)
+	assert.Equal(t, `query { r0: repository(owner: "pulumi", name: "pulumi-aws") { latestRelease { tagName } } `+
+		`r1: repository(owner: "pulumi", name: "pulumi-missing") { latestRelease { tagName } } }`, queries[0])
+	for _, i := range []int{0, 2} {
+		assert.Equal(t, "aws", results[i].Plugin.Name)
+		require.NoError(t, results[i].Err)
+		assert.Equal(t, "5.1.0", results[i].Version.String())
+	}
+
+	// Plugins the query doesn't find, and plugins from elsewhere, are looked up one by one.
+	assert.Equal(t, "missing", results[1].Plugin.Name)
+	assert.Nil(t, results[1].Version)
+	var httpErr *HTTPError
+	require.True(t, errors.As(results[1].Err, &httpErr), "%v", results[1].Err)
+	assert.Equal(t, http.StatusNotFound, httpErr.StatusCode)
+	assert.EqualError(t, results[3].Err, "GetLatestVersion is not supported for plugins using PluginDownloadURL "+
+		"unless the server hosts an index of their versions at https://plugins.corp/index.json")
+	assert.Len(t, requested, 3)
+
+	// Without a token, every plugin is looked up one by one.
+	t.Setenv("GITHUB_TOKEN", "")
+	queries = nil
+	results = ctx.GetLatestVersions([]PluginInfo{{Kind: ResourcePlugin, Name: "missing"}})
+	assert.Empty(t, queries)
+	assert.Error(t, results[0].Err)
+}
@@ -0,0 +1,132 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// LegacyPluginSearchEnvVar enables legacy plugin search for every context, like Context.LegacyPluginSearch.
+//
+// Deprecated: set Context.LegacyPluginSearch instead. Legacy plugin search will be removed; `pulumi plugin
+// search-report` lists the plugins of a project it resolves differently.
+const LegacyPluginSearchEnvVar = "PULUMI_ENABLE_LEGACY_PLUGIN_SEARCH"
+
+// legacyPluginSearchWarning makes sure the deprecation of LegacyPluginSearchEnvVar is only warned about once.
+var legacyPluginSearchWarning sync.Once
+
+// legacyPluginSearch returns true if the context uses legacy plugin search.
+func (ctx *Context) legacyPluginSearch() bool {
+	if ctx.LegacyPluginSearch {
+		return true
+	}
+	if os.Getenv(LegacyPluginSearchEnvVar) == "" {
+		return false
+	}
+	legacyPluginSearchWarning.Do(func() {
+		ctx.logger().Warningf(nil, "%s is deprecated, and legacy plugin search will be removed; run `pulumi plugin "+
+			"search-report` to list the plugins of a project it resolves differently", LegacyPluginSearchEnvVar)
+	})
+	return true
+}
+
+// LegacyPluginSearchDifference is a plugin that legacy plugin search resolves to another plugin in the plugin cache.
+type LegacyPluginSearchDifference struct {
+	// Plugin is the plugin that is requested.
+	Plugin PluginInfo
+	// Legacy is the plugin legacy plugin search resolves it to.
+	Legacy PluginInfo
+	// Modern is the plugin it resolves to without legacy plugin search, or nil if none is installed.
+	Modern *PluginInfo
+}
+
+// Message describes the difference for display to users.
+func (d LegacyPluginSearchDifference) Message() string {
+	msg := fmt.Sprintf("%s plugin %s v%s resolves to v%s with legacy plugin search", d.Plugin.Kind, d.Plugin.Name,
+		d.Plugin.Version, d.Legacy.Version)
+	if d.Modern == nil {
+		return msg + fmt.Sprintf(", and isn't installed without it; install it with `pulumi plugin install %s %s %s`",
+			d.Plugin.Kind, d.Plugin.Name, d.Plugin.Version)
+	}
+	return msg + fmt.Sprintf(", and to v%s without it", d.Modern.Version)
+}
+
+// ReportLegacyPluginSearch lists the plugins that legacy plugin search resolves differently. See
+// Context.ReportLegacyPluginSearch.
+func ReportLegacyPluginSearch(plugins []PluginInfo) ([]LegacyPluginSearchDifference, error) {
+	return (&Context{}).ReportLegacyPluginSearch(plugins)
+}
+
+// ReportLegacyPluginSearch returns the given plugins, such as those a project requires, that legacy plugin search
+// resolves to other plugins in the plugin cache than the search GetPluginPath does without it, so users can stop
+// using it without surprises. Plugins requested without a version, and those found on $PATH instead of the plugin
+// cache, resolve the same way with either search, and are never reported.
+func (ctx *Context) ReportLegacyPluginSearch(plugins []PluginInfo) ([]LegacyPluginSearchDifference, error) {
+	cached, err := ctx.getPlugins(true /* skipMetadata */)
+	if err != nil {
+		return nil, fmt.Errorf("loading plugin list: %w", err)
+	}
+	var variants []PluginInfo
+
+	var differences []LegacyPluginSearchDifference
+	seen := map[string]bool{}
+	for _, plugin := range plugins {
+		key := fmt.Sprintf("%s/%s@%s", plugin.Kind, plugin.Name, plugin.Version)
+		if plugin.Version == nil || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		preference, err := ctx.getPluginAmbientPreference(plugin.Kind, plugin.Name)
+		if err != nil {
+			return nil, err
+		}
+		if preference == PluginAmbientPreferred {
+			if _, err := lookPathPlugin(plugin.FilePrefix()); err == nil {
+				continue
+			}
+		}
+
+		// Selected variants are matched against the installed builds of the variant instead.
+		candidates := cached
+		variant, err := ctx.getPluginVariant(plugin.Name)
+		if err != nil {
+			return nil, err
+		}
+		if variant != "" {
+			if variants == nil {
+				if variants, err = ctx.GetPluginVariants(); err != nil {
+					return nil, fmt.Errorf("loading plugin variant list: %w", err)
+				}
+			}
+			candidates = nil
+			for _, installed := range variants {
+				if installed.Variant == variant {
+					candidates = append(candidates, installed)
+				}
+			}
+		}
+
+		legacy := ctx.matchPlugin(candidates, plugin.Kind, plugin.Name, plugin.Version, true /* legacy */)
+		modern := ctx.matchPlugin(candidates, plugin.Kind, plugin.Name, plugin.Version, false /* legacy */)
+		if legacy == nil || (modern != nil && legacy.Version.Equals(*modern.Version)) {
+			continue
+		}
+		differences = append(differences, LegacyPluginSearchDifference{Plugin: plugin, Legacy: *legacy, Modern: modern})
+	}
+	return differences, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// legacySearchTestContext returns a context with versions 1.0.0 and 1.2.0 of the mock resource plugin installed.
+func legacySearchTestContext(t *testing.T) *Context {
+	ctx := &Context{Home: t.TempDir()}
+	for _, version := range []string{"1.0.0", "1.2.0"} {
+		v := semver.MustParse(version)
+		plugin, err := ctx.Plugin(PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &v})
+		require.NoError(t, err)
+		require.NoError(t, plugin.Install(ioutil.NopCloser(bytes.NewReader(mockPluginTGZ(t))), false))
+	}
+	return ctx
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestLegacyPluginSearch(t *testing.T) {
+	t.Setenv(LegacyPluginSearchEnvVar, "")
+	t.Setenv("PULUMI_IGNORE_AMBIENT_PLUGINS", "true")
+	t.Setenv(PluginAmbientPolicyEnvVar, "")
+
+	ctx := legacySearchTestContext(t)
+	v := semver.MustParse("1.0.0")
+	resolution, err := ctx.ResolvePlugin(ResourcePlugin, "mock", &v)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", resolution.Info.Version.String())
+	assert.Equal(t, "=1.0.0", resolution.Constraints.Range)
+
+	ctx.LegacyPluginSearch = true
+	resolution, err = ctx.ResolvePlugin(ResourcePlugin, "mock", &v)
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.0", resolution.Info.Version.String())
+	assert.Equal(t, ">=1.0.0", resolution.Constraints.Range)
+
+	// The environment variable still enables it, too.
+	ctx.LegacyPluginSearch = false
+	t.Setenv(LegacyPluginSearchEnvVar, "1")
+	assert.True(t, ctx.legacyPluginSearch())
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestReportLegacyPluginSearch(t *testing.T) {
+	t.Setenv("PULUMI_IGNORE_AMBIENT_PLUGINS", "true")
+	t.Setenv(PluginAmbientPolicyEnvVar, "")
+	t.Setenv(PluginVariantsEnvVar, "")
+
+	ctx := legacySearchTestContext(t)
+	var plugins []PluginInfo
+	for _, version := range []string{"1.0.0", "1.1.0", "1.2.0", "1.0.0"} {
+		v := semver.MustParse(version)
+		plugins = append(plugins, PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &v})
+	}
+	plugins = append(plugins, PluginInfo{Name: "mock", Kind: ResourcePlugin})
+
+	differences, err := ctx.ReportLegacyPluginSearch(plugins)
+	require.NoError(t, err)
+	require.Len(t, differences, 2)
+	assert.Equal(t, "resource plugin mock v1.0.0 resolves to v1.2.0 with legacy plugin search, and to v1.0.0 "+
+		"without it", differences[0].Message())
+	assert.Equal(t, "resource plugin mock v1.1.0 resolves to v1.2.0 with legacy plugin search, and isn't installed "+
+		"without it; install it with `pulumi plugin install resource mock 1.1.0`", differences[1].Message())
+	assert.Nil(t, differences[1].Modern)
+}
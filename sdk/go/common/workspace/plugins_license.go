@@ -0,0 +1,199 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+)
+
+const (
+	// PluginLicensesFile is the name of the file in PULUMI_HOME recording the plugin licenses the user has accepted.
+	PluginLicensesFile = "plugin-licenses.json"
+	// PluginAcceptLicensesEnvVar accepts the licenses of all plugins that require acceptance, as though the user had
+	// agreed to each of them. It is intended for CI, where installs can't prompt.
+	PluginAcceptLicensesEnvVar = "PULUMI_ACCEPT_PLUGIN_LICENSES"
+)
+
+// PluginLicense describes the license a plugin is distributed under, as declared by the `license` section of its
+// PulumiPlugin.yaml.
+type PluginLicense struct {
+	// Name is the name of the license, e.g. "Acme Commercial License".
+	Name string `json:"name" yaml:"name"`
+	// URL is where the full text of the license can be read.
+	URL string `json:"url,omitempty" yaml:"url,omitempty"`
+	// RequiresAcceptance is true if the plugin may only be installed once the user has accepted the license.
+	RequiresAcceptance bool `json:"requiresAcceptance,omitempty" yaml:"requiresAcceptance,omitempty"`
+}
+
+// PluginLicensePrompt asks the user whether they accept the license of the given plugin. It is nil by default, in
+// which case installing a plugin whose license hasn't been accepted fails with a LicenseNotAcceptedError; interactive
+// hosts such as the CLI set it to ask the user instead.
+var PluginLicensePrompt func(info PluginInfo, license PluginLicense) (bool, error)
+
+// LicenseNotAcceptedError is returned when installing a plugin whose license requires acceptance, but the user hasn't
+// accepted it.
+type LicenseNotAcceptedError struct {
+	Info    PluginInfo
+	License PluginLicense
+}
+
+func (err *LicenseNotAcceptedError) Error() string {
+	license := err.License.Name
+	if err.License.URL != "" {
+		license = fmt.Sprintf("%s (%s)", license, err.License.URL)
+	}
+	install := fmt.Sprintf("pulumi plugin install %s %s", err.Info.Kind, err.Info.Name)
+	if version := pluginLicenseVersion(err.Info); version != "" {
+		install += " " + version
+	}
+	return fmt.Sprintf("%s plugin %s is distributed under %s, which must be accepted before it is installed; "+
+		"run `%s` interactively to review and accept it, or set %s=true",
+		err.Info.Kind, err.Info, license, install, PluginAcceptLicensesEnvVar)
+}
+
+// pluginLicenseAcceptance records that the user accepted the license of a specific plugin version.
+type pluginLicenseAcceptance struct {
+	Kind       PluginKind `json:"kind"`
+	Name       string     `json:"name"`
+	Version    string     `json:"version"`
+	License    string     `json:"license"`
+	URL        string     `json:"url,omitempty"`
+	AcceptedAt time.Time  `json:"acceptedAt"`
+}
+
+// matches returns true if this acceptance covers the given plugin version and license. A changed license, even for a
+// version that was accepted before, must be accepted again.
+func (a pluginLicenseAcceptance) matches(info PluginInfo, license PluginLicense) bool {
+	return a.Kind == info.Kind && a.Name == info.Name && a.Version == pluginLicenseVersion(info) &&
+		a.License == license.Name && a.URL == license.URL
+}
+
+// IsPluginLicenseAccepted returns true if the user has accepted the given license for the given plugin version.
+func IsPluginLicenseAccepted(info PluginInfo, license PluginLicense) (bool, error) {
+	acceptances, err := loadPluginLicenseAcceptances()
+	if err != nil {
+		return false, err
+	}
+	for _, a := range acceptances {
+		if a.matches(info, license) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AcceptPluginLicense records in PluginLicensesFile that the user has accepted the given license for the given plugin
+// version.
+func AcceptPluginLicense(info PluginInfo, license PluginLicense) error {
+	acceptances, err := loadPluginLicenseAcceptances()
+	if err != nil {
+		return err
+	}
+	for _, a := range acceptances {
+		if a.matches(info, license) {
+			return nil
+		}
+	}
+	acceptances = append(acceptances, pluginLicenseAcceptance{
+		Kind:       info.Kind,
+		Name:       info.Name,
+		Version:    pluginLicenseVersion(info),
+		License:    license.Name,
+		URL:        license.URL,
+		AcceptedAt: info.now().UTC(),
+	})
+
+	path, err := GetPulumiPath(PluginLicensesFile)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(acceptances, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+// checkPluginLicense ensures the license of the plugin described by proj has been accepted, if it requires
+// acceptance. Licenses accepted through PluginAcceptLicensesEnvVar or PluginLicensePrompt are recorded, so the user
+// isn't asked again for the same plugin version.
+func checkPluginLicense(info PluginInfo, proj *PluginProject) error {
+	if proj.License == nil || !proj.License.RequiresAcceptance {
+		return nil
+	}
+	license := *proj.License
+
+	accepted, err := IsPluginLicenseAccepted(info, license)
+	if err != nil {
+		return err
+	}
+	if accepted {
+		return nil
+	}
+
+	switch {
+	case cmdutil.IsTruthy(os.Getenv(PluginAcceptLicensesEnvVar)):
+		info.logf(5, "accepting license %q of plugin %s because %s is set",
+			license.Name, info, PluginAcceptLicensesEnvVar)
+	case PluginLicensePrompt != nil:
+		if accepted, err = PluginLicensePrompt(info, license); err != nil {
+			return err
+		}
+		if !accepted {
+			return &LicenseNotAcceptedError{Info: info, License: license}
+		}
+	default:
+		return &LicenseNotAcceptedError{Info: info, License: license}
+	}
+	return AcceptPluginLicense(info, license)
+}
+
+// pluginLicenseVersion returns the version of the plugin that a license acceptance is recorded for.
+func pluginLicenseVersion(info PluginInfo) string {
+	if info.Version == nil {
+		return ""
+	}
+	return info.Version.String()
+}
+
+// loadPluginLicenseAcceptances reads PluginLicensesFile, returning nil if it doesn't exist.
+func loadPluginLicenseAcceptances() ([]pluginLicenseAcceptance, error) {
+	path, err := GetPulumiPath(PluginLicensesFile)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var acceptances []pluginLicenseAcceptance
+	if err := json.Unmarshal(b, &acceptances); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return acceptances, nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables and PluginLicensePrompt
+func TestCheckPluginLicense(t *testing.T) {
+	t.Setenv(PulumiHomeEnvVar, t.TempDir())
+	t.Setenv(PluginAcceptLicensesEnvVar, "")
+
+	version := semver.MustParse("1.0.0")
+	info := PluginInfo{Name: "acme", Kind: ResourcePlugin, Version: &version}
+	license := PluginLicense{Name: "Acme Commercial License", URL: "https://acme.example/license",
+		RequiresAcceptance: true}
+	proj := &PluginProject{License: &license}
+
+	// Licenses that don't require acceptance never block an install.
+	assert.NoError(t, checkPluginLicense(info, &PluginProject{}))
+	assert.NoError(t, checkPluginLicense(info, &PluginProject{License: &PluginLicense{Name: "Apache-2.0"}}))
+
+	// Without a prompt, an unaccepted license is refused.
+	err := checkPluginLicense(info, proj)
+	var notAccepted *LicenseNotAcceptedError
+	require.True(t, errors.As(err, &notAccepted))
+	assert.Equal(t, "resource plugin acme-1.0.0 is distributed under Acme Commercial License "+
+		"(https://acme.example/license), which must be accepted before it is installed; run "+
+		"`pulumi plugin install resource acme 1.0.0` interactively to review and accept it, "+
+		"or set PULUMI_ACCEPT_PLUGIN_LICENSES=true", err.Error())
+
+	// Declining the prompt refuses the install, and nothing is recorded.
+	prompts := 0
+	PluginLicensePrompt = func(PluginInfo, PluginLicense) (bool, error) {
+		prompts++
+		return false, nil
+	}
+	defer func() { PluginLicensePrompt = nil }()
+	assert.True(t, errors.As(checkPluginLicense(info, proj), &notAccepted))
+	accepted, err := IsPluginLicenseAccepted(info, license)
+	require.NoError(t, err)
+	assert.False(t, accepted)
+
+	// Accepting it records the acceptance, so the user isn't asked again.
+	PluginLicensePrompt = func(PluginInfo, PluginLicense) (bool, error) {
+		prompts++
+		return true, nil
+	}
+	assert.NoError(t, checkPluginLicense(info, proj))
+	assert.NoError(t, checkPluginLicense(info, proj))
+	assert.Equal(t, 2, prompts)
+
+	// A new version, or a changed license, needs to be accepted again.
+	PluginLicensePrompt = nil
+	newVersion := semver.MustParse("1.1.0")
+	newInfo := PluginInfo{Name: "acme", Kind: ResourcePlugin, Version: &newVersion}
+	assert.True(t, errors.As(checkPluginLicense(newInfo, proj), &notAccepted))
+	changed := license
+	changed.URL = "https://acme.example/license-v2"
+	assert.True(t, errors.As(checkPluginLicense(info, &PluginProject{License: &changed}), &notAccepted))
+
+	// The environment variable accepts licenses non-interactively, and records them.
+	t.Setenv(PluginAcceptLicensesEnvVar, "true")
+	assert.NoError(t, checkPluginLicense(newInfo, proj))
+	t.Setenv(PluginAcceptLicensesEnvVar, "")
+	accepted, err = IsPluginLicenseAccepted(newInfo, license)
+	require.NoError(t, err)
+	assert.True(t, accepted)
+}
@@ -0,0 +1,420 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// PluginLicensePolicyEnvVar sets what happens when the dependencies of a plugin have licenses that the `licensePolicy`
+// section of PluginConfigFile doesn't allow, taking precedence over its `onViolation` setting.
+const PluginLicensePolicyEnvVar = "PULUMI_PLUGIN_LICENSE_POLICY"
+
+// PluginLicensePolicyAction is what happens when the dependencies of a plugin have licenses a policy doesn't allow.
+type PluginLicensePolicyAction string
+
+const (
+	// PluginLicensePolicyFail fails the install. This is the default.
+	PluginLicensePolicyFail PluginLicensePolicyAction = "fail"
+	// PluginLicensePolicyWarn warns about the licenses, and installs the plugin anyway.
+	PluginLicensePolicyWarn PluginLicensePolicyAction = "warn"
+	// PluginLicensePolicyOff doesn't scan the licenses at all.
+	PluginLicensePolicyOff PluginLicensePolicyAction = "off"
+)
+
+func parsePluginLicensePolicyAction(s string) (PluginLicensePolicyAction, error) {
+	switch action := PluginLicensePolicyAction(s); action {
+	case PluginLicensePolicyFail, PluginLicensePolicyWarn, PluginLicensePolicyOff:
+		return action, nil
+	default:
+		return "", fmt.Errorf("expected %q, %q or %q; got %q",
+			PluginLicensePolicyFail, PluginLicensePolicyWarn, PluginLicensePolicyOff, s)
+	}
+}
+
+// PluginLicensePolicy is the policy the licenses of the npm and pip packages installed as plugin dependencies are
+// checked against once they're installed.
+type PluginLicensePolicy struct {
+	// Allow are glob patterns, such as `BSD-*`, matching the licenses that are allowed, case insensitively. If there
+	// are any, every package must have an allowed license; packages whose license can't be determined are named
+	// `UNKNOWN`, and Python packages that only give trove classifiers are named by them, such as `MIT License`. If
+	// there are none, every license Deny doesn't match is allowed.
+	Allow []string
+	// Deny are glob patterns matching the licenses that aren't allowed, even if Allow matches them.
+	Deny []string
+	// OnViolation is what happens when a package's license isn't allowed. It defaults to PluginLicensePolicyFail.
+	OnViolation PluginLicensePolicyAction
+}
+
+// enabled returns true if the policy restricts any licenses.
+func (policy PluginLicensePolicy) enabled() bool {
+	return (len(policy.Allow) > 0 || len(policy.Deny) > 0) && policy.OnViolation != PluginLicensePolicyOff
+}
+
+// validatePluginLicensePattern checks that pattern is a valid glob.
+func validatePluginLicensePattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("%q is not a valid glob pattern", pattern)
+	}
+	return nil
+}
+
+var (
+	// spdxOrRegexp and spdxAndRegexp split SPDX license expressions, such as `(MIT OR Apache-2.0)`.
+	spdxOrRegexp  = regexp.MustCompile(`(?i)\s+OR\s+`)
+	spdxAndRegexp = regexp.MustCompile(`(?i)\s+AND\s+`)
+	// spdxWithRegexp matches the license exception of a term of an SPDX license expression.
+	spdxWithRegexp = regexp.MustCompile(`(?i)\s+WITH\s+.*$`)
+)
+
+// Permits returns true if the policy allows license, which may be an SPDX license expression. Expressions are allowed
+// if any of their alternatives has only allowed licenses.
+func (policy PluginLicensePolicy) Permits(license string) bool {
+	license = strings.TrimSpace(strings.NewReplacer("(", " ", ")", " ").Replace(license))
+	if license == "" {
+		license = "UNKNOWN"
+	}
+	for _, alternative := range spdxOrRegexp.Split(license, -1) {
+		permitted := true
+		for _, term := range spdxAndRegexp.Split(strings.TrimSpace(alternative), -1) {
+			term = strings.TrimSpace(term)
+			names := []string{term, spdxWithRegexp.ReplaceAllString(term, "")}
+			if pluginLicenseMatches(policy.Deny, names) ||
+				len(policy.Allow) > 0 && !pluginLicenseMatches(policy.Allow, names) {
+				permitted = false
+				break
+			}
+		}
+		if permitted {
+			return true
+		}
+	}
+	return false
+}
+
+// pluginLicenseMatches returns true if any of the patterns matches any of the names, case insensitively.
+func pluginLicenseMatches(patterns, names []string) bool {
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getPluginLicensePolicy returns the license policy from PluginConfigFile, with its action overridden by
+// PluginLicensePolicyEnvVar if it's set.
+func (ctx *Context) getPluginLicensePolicy() (PluginLicensePolicy, error) {
+	config, err := ctx.GetPluginConfig()
+	if err != nil {
+		return PluginLicensePolicy{}, err
+	}
+	policy := config.LicensePolicy
+	if env := os.Getenv(PluginLicensePolicyEnvVar); env != "" {
+		action, err := parsePluginLicensePolicyAction(env)
+		if err != nil {
+			return PluginLicensePolicy{}, fmt.Errorf("%s: %w", PluginLicensePolicyEnvVar, err)
+		}
+		policy.OnViolation = action
+	}
+	if policy.OnViolation == "" {
+		policy.OnViolation = PluginLicensePolicyFail
+	}
+	return policy, nil
+}
+
+// PluginPackageLicense is the license of a package installed as a dependency of a plugin.
+type PluginPackageLicense struct {
+	// Package is the package.
+	Package PluginSBOMDependency
+	// License is the package's license, which may be an SPDX license expression, or "" if it can't be determined.
+	License string
+}
+
+func (pkg PluginPackageLicense) String() string {
+	license := pkg.License
+	if license == "" {
+		license = "UNKNOWN"
+	}
+	return fmt.Sprintf("%s %s@%s (%s)", pkg.Package.Ecosystem, pkg.Package.Name, pkg.Package.Version, license)
+}
+
+// PluginLicensePolicyError is returned when the dependencies of a plugin have licenses the license policy in
+// PluginConfigFile doesn't allow.
+type PluginLicensePolicyError struct {
+	// Info is the plugin.
+	Info PluginInfo
+	// Violations are the packages whose licenses aren't allowed.
+	Violations []PluginPackageLicense
+}
+
+func (err *PluginLicensePolicyError) Error() string {
+	violations := make([]string, len(err.Violations))
+	for i, v := range err.Violations {
+		violations[i] = v.String()
+	}
+	return fmt.Sprintf("%s plugin %s depends on packages whose licenses the license policy in %s doesn't allow: %s; "+
+		"set %s=%s to install it anyway", err.Info.Kind, err.Info, PluginConfigFile, strings.Join(violations, ", "),
+		PluginLicensePolicyEnvVar, PluginLicensePolicyWarn)
+}
+
+// checkPluginDependencyLicenses checks the licenses of the dependencies installed into dir against the license
+// policy, failing with a *PluginLicensePolicyError or warning about those it doesn't allow.
+func checkPluginDependencyLicenses(info PluginInfo, dir string) error {
+	policy, err := info.context().getPluginLicensePolicy()
+	if err != nil {
+		return err
+	}
+	if !policy.enabled() {
+		return nil
+	}
+	pkgs, err := scanPluginDependencyLicenses(dir)
+	if err != nil {
+		return fmt.Errorf("scanning the licenses of plugin dependencies: %w", err)
+	}
+	var violations []PluginPackageLicense
+	for _, pkg := range pkgs {
+		if !policy.Permits(pkg.License) {
+			violations = append(violations, pkg)
+		}
+	}
+	info.logf(5, "scanned the licenses of %d dependencies of plugin %s, %d of which aren't allowed",
+		len(pkgs), info, len(violations))
+	if len(violations) == 0 {
+		return nil
+	}
+	policyErr := &PluginLicensePolicyError{Info: info, Violations: violations}
+	if policy.OnViolation == PluginLicensePolicyWarn {
+		info.warnf("%v", policyErr)
+		return nil
+	}
+	return policyErr
+}
+
+// ScanDependencyLicenses returns the licenses of the npm and pip packages installed as the installed plugin's
+// dependencies.
+func (info PluginInfo) ScanDependencyLicenses() ([]PluginPackageLicense, error) {
+	dir, err := info.DirPath()
+	if err != nil {
+		return nil, err
+	}
+	return scanPluginDependencyLicenses(dir)
+}
+
+// scanPluginDependencyLicenses returns the licenses of the packages installed in the `node_modules` and `venv` of the
+// plugin in dir, sorted by ecosystem, name and version.
+func scanPluginDependencyLicenses(dir string) ([]PluginPackageLicense, error) {
+	pkgs, err := scanNodeModulesLicenses(filepath.Join(dir, "node_modules"))
+	if err != nil {
+		return nil, err
+	}
+	pythonPkgs, err := scanVirtualEnvLicenses(filepath.Join(dir, "venv"))
+	if err != nil {
+		return nil, err
+	}
+	pkgs = append(pkgs, pythonPkgs...)
+	sort.Slice(pkgs, func(i, j int) bool {
+		a, b := pkgs[i].Package, pkgs[j].Package
+		if a.Ecosystem != b.Ecosystem {
+			return a.Ecosystem < b.Ecosystem
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+	return pkgs, nil
+}
+
+// scanNodeModulesLicenses returns the licenses of the packages installed in a node_modules directory, and in theirs.
+func scanNodeModulesLicenses(nodeModules string) ([]PluginPackageLicense, error) {
+	entries, err := ioutil.ReadDir(nodeModules)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var dirs []string
+	for _, entry := range entries {
+		switch name := entry.Name(); {
+		case strings.HasPrefix(name, "."):
+			// Skip .bin, .cache, .package-lock.json and the like.
+		case strings.HasPrefix(name, "@"):
+			scoped, err := ioutil.ReadDir(filepath.Join(nodeModules, name))
+			if err != nil {
+				return nil, err
+			}
+			for _, pkg := range scoped {
+				dirs = append(dirs, filepath.Join(nodeModules, name, pkg.Name()))
+			}
+		default:
+			dirs = append(dirs, filepath.Join(nodeModules, name))
+		}
+	}
+
+	var pkgs []PluginPackageLicense
+	for _, dir := range dirs {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		var pkgJSON struct {
+			Name     string          `json:"name"`
+			Version  string          `json:"version"`
+			License  json.RawMessage `json:"license"`
+			Licenses []struct {
+				Type string `json:"type"`
+			} `json:"licenses"`
+		}
+		if err := json.Unmarshal(b, &pkgJSON); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", filepath.Join(dir, "package.json"), err)
+		}
+		pkg := PluginPackageLicense{
+			Package: PluginSBOMDependency{Ecosystem: "npm", Name: pkgJSON.Name, Version: pkgJSON.Version},
+		}
+		// Licenses are usually SPDX expressions, but older packages give objects, or lists of them, instead.
+		if err := json.Unmarshal(pkgJSON.License, &pkg.License); err != nil {
+			var license struct {
+				Type string `json:"type"`
+			}
+			if json.Unmarshal(pkgJSON.License, &license) == nil {
+				pkg.License = license.Type
+			}
+		}
+		if pkg.License == "" {
+			var types []string
+			for _, l := range pkgJSON.Licenses {
+				types = append(types, l.Type)
+			}
+			pkg.License = strings.Join(types, " OR ")
+		}
+		pkgs = append(pkgs, pkg)
+
+		// Linked packages are scanned, but not the packages they depend on.
+		if stat, err := os.Lstat(dir); err == nil && stat.Mode()&os.ModeSymlink == 0 {
+			nested, err := scanNodeModulesLicenses(filepath.Join(dir, "node_modules"))
+			if err != nil {
+				return nil, err
+			}
+			pkgs = append(pkgs, nested...)
+		}
+	}
+	return pkgs, nil
+}
+
+// scanVirtualEnvLicenses returns the licenses of the packages installed in a Python virtual environment, from the
+// METADATA of their .dist-info directories.
+func scanVirtualEnvLicenses(venv string) ([]PluginPackageLicense, error) {
+	var metadata []string
+	for _, pattern := range []string{
+		filepath.Join(venv, "lib", "python*", "site-packages", "*.dist-info", "METADATA"),
+		filepath.Join(venv, "Lib", "site-packages", "*.dist-info", "METADATA"),
+	} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		metadata = append(metadata, matches...)
+	}
+
+	var pkgs []PluginPackageLicense
+	for _, path := range metadata {
+		pkg, err := readPythonPackageLicense(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// readPythonPackageLicense reads the license of a Python package from its METADATA: its License-Expression, or the
+// licenses of its trove classifiers, or a one-line License.
+func readPythonPackageLicense(path string) (PluginPackageLicense, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return PluginPackageLicense{}, err
+	}
+	defer contract.IgnoreClose(f)
+
+	pkg := PluginPackageLicense{Package: PluginSBOMDependency{Ecosystem: "pypi"}}
+	var header, expression, license string
+	var classifiers []string
+	multiline := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			// The headers end at the first blank line, and the description follows.
+			break
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			multiline = multiline || header == "License"
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		header = line[:colon]
+		value := strings.TrimSpace(line[colon+1:])
+		switch header {
+		case "Name":
+			pkg.Package.Name = value
+		case "Version":
+			pkg.Package.Version = value
+		case "License-Expression":
+			expression = value
+		case "License":
+			license = value
+		case "Classifier":
+			if strings.HasPrefix(value, "License ::") {
+				parts := strings.Split(value, "::")
+				classifiers = append(classifiers, strings.TrimSpace(parts[len(parts)-1]))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return PluginPackageLicense{}, err
+	}
+
+	switch {
+	case expression != "":
+		pkg.License = expression
+	case len(classifiers) > 0:
+		pkg.License = strings.Join(classifiers, " OR ")
+	case !multiline && license != "" && !strings.EqualFold(license, "UNKNOWN"):
+		// A License that spans lines is the text of the license, rather than its name.
+		pkg.License = license
+	}
+	return pkg, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginLicensePolicyPermits(t *testing.T) {
+	t.Parallel()
+
+	policy := PluginLicensePolicy{Allow: []string{"MIT", "Apache-2.0", "BSD-*", "GPL-2.0-only"}, Deny: []string{"BSD-4-*"}}
+	tests := []struct {
+		license   string
+		permitted bool
+	}{
+		{"MIT", true},
+		{"mit", true},
+		{"BSD-3-Clause", true},
+		{"BSD-4-Clause", false},
+		{"GPL-3.0-only", false},
+		{"(MIT OR GPL-3.0-only)", true},
+		{"MIT AND GPL-3.0-only", false},
+		{"(MIT AND Apache-2.0) or GPL-3.0-only", true},
+		{"GPL-2.0-only WITH Classpath-exception-2.0", true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.permitted, policy.Permits(tt.license), tt.license)
+	}
+
+	// Without an allow list, only denied licenses aren't permitted.
+	policy = PluginLicensePolicy{Deny: []string{"AGPL-*"}}
+	assert.True(t, policy.Permits(""))
+	assert.False(t, policy.Permits("AGPL-3.0-or-later"))
+}
+
+// writeLicenseTestDependencies writes installed npm and pip packages, with the licenses their metadata gives them,
+// into dir.
+func writeLicenseTestDependencies(t *testing.T, dir string) {
+	site := "venv/lib/python3.9/site-packages/"
+	for path, contents := range map[string]string{
+		"node_modules/.bin/semver":         "",
+		"node_modules/semver/package.json": `{"name": "semver", "version": "7.3.7", "license": "ISC"}`,
+		"node_modules/@pulumi/pulumi/package.json": `{"name": "@pulumi/pulumi", "version": "3.40.0",
+			"license": {"type": "Apache-2.0"}}`,
+		"node_modules/@pulumi/pulumi/node_modules/old/package.json": `{"name": "old", "version": "0.1.0",
+			"licenses": [{"type": "MIT"}, {"type": "GPL-2.0"}]}`,
+		site + "requests-2.28.1.dist-info/METADATA": "Metadata-Version: 2.1\nName: requests\nVersion: 2.28.1\n" +
+			"License: Apache 2.0\n\nLicense: ignored",
+		site + "typing_extensions-4.3.0.dist-info/METADATA": "Name: typing_extensions\nVersion: 4.3.0\n" +
+			"Classifier: License :: OSI Approved :: Python Software Foundation License\n",
+		site + "attrs-22.1.0.dist-info/METADATA": "Name: attrs\nVersion: 22.1.0\nLicense-Expression: MIT\n" +
+			"License: MIT License\n",
+		site + "copyleft-1.0.dist-info/METADATA": "Name: copyleft\nVersion: 1.0\nLicense: Copyright (c) 2022\n" +
+			"        All rights reserved.\n",
+	} {
+		path = filepath.Join(dir, filepath.FromSlash(path))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+	}
+}
+
+func TestScanPluginDependencyLicenses(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeLicenseTestDependencies(t, dir)
+	pkgs, err := scanPluginDependencyLicenses(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []PluginPackageLicense{
+		{
+			Package: PluginSBOMDependency{Ecosystem: "npm", Name: "@pulumi/pulumi", Version: "3.40.0"},
+			License: "Apache-2.0",
+		},
+		{Package: PluginSBOMDependency{Ecosystem: "npm", Name: "old", Version: "0.1.0"}, License: "MIT OR GPL-2.0"},
+		{Package: PluginSBOMDependency{Ecosystem: "npm", Name: "semver", Version: "7.3.7"}, License: "ISC"},
+		{Package: PluginSBOMDependency{Ecosystem: "pypi", Name: "attrs", Version: "22.1.0"}, License: "MIT"},
+		{Package: PluginSBOMDependency{Ecosystem: "pypi", Name: "copyleft", Version: "1.0"}},
+		{Package: PluginSBOMDependency{Ecosystem: "pypi", Name: "requests", Version: "2.28.1"}, License: "Apache 2.0"},
+		{
+			Package: PluginSBOMDependency{Ecosystem: "pypi", Name: "typing_extensions", Version: "4.3.0"},
+			License: "Python Software Foundation License",
+		},
+	}, pkgs)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestInstallChecksDependencyLicenses(t *testing.T) {
+	t.Setenv(PluginLicensePolicyEnvVar, "")
+	ctx := &Context{Home: t.TempDir(), PluginDir: t.TempDir()}
+	writePluginConfig(t, ctx.Home, "licensePolicy:\n  allow: [MIT, ISC, Apache-2.0]\n")
+	_, plugin := newMockPlugin(t)
+	plugin, err := ctx.Plugin(plugin)
+	require.NoError(t, err)
+	// The plugin ships its dependencies in node_modules.
+	tgz, err := createTGZWithMode(map[string][]byte{
+		"PulumiPlugin.yaml":                []byte("binaries:\n  mock: pulumi-resource-mock\n"),
+		"pulumi-resource-mock":             nil,
+		"node_modules/semver/package.json": []byte(`{"name": "semver", "version": "7.3.7", "license": "ISC"}`),
+		"node_modules/gpl/package.json":    []byte(`{"name": "gpl", "version": "1.0.0", "license": "GPL-3.0-only"}`),
+	}, 0700)
+	require.NoError(t, err)
+
+	err = plugin.Install(ioutil.NopCloser(bytes.NewReader(tgz)), false)
+	var policyErr *PluginLicensePolicyError
+	require.True(t, errors.As(err, &policyErr), "unexpected error %v", err)
+	assert.EqualError(t, err, "resource plugin mock-1.0.0 depends on packages whose licenses the license policy in "+
+		"plugin-config.yaml doesn't allow: npm gpl@1.0.0 (GPL-3.0-only); "+
+		"set PULUMI_PLUGIN_LICENSE_POLICY=warn to install it anyway")
+	partial, err := plugin.PartialFilePath()
+	require.NoError(t, err)
+	assert.FileExists(t, partial)
+
+	t.Setenv(PluginLicensePolicyEnvVar, "warn")
+	require.NoError(t, plugin.Install(ioutil.NopCloser(bytes.NewReader(tgz)), true))
+	assert.NoFileExists(t, partial)
+}
@@ -0,0 +1,169 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blang/semver"
+)
+
+// pluginLinksDir is the directory in each plugin directory that records the plugins that are linked to locally built
+// executables, in a file per plugin.
+const pluginLinksDir = ".links"
+
+// pluginLink is the record of a linked plugin.
+type pluginLink struct {
+	// Path is the absolute path of the executable the plugin is linked to.
+	Path string `json:"path"`
+}
+
+// linkFilePath returns the path of the file that records the plugin's link.
+func (info PluginInfo) linkFilePath() (string, error) {
+	info.Variant = ""
+	dir, err := info.DirPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(dir), pluginLinksDir, filepath.Base(dir)+".json"), nil
+}
+
+// Link makes GetPluginPath resolve the plugin to the executable at path, which is typically a provider that's being
+// developed, instead of a plugin on the $PATH or in the plugin cache. The executable isn't copied, so rebuilding it
+// updates the plugin, and it's found by the lookups that would find the plugin if it were installed. Links are removed
+// by Unlink.
+func (info PluginInfo) Link(path string) error {
+	if !IsPluginKind(string(info.Kind)) {
+		return fmt.Errorf("unrecognized plugin kind: %s", info.Kind)
+	} else if info.Name == "" {
+		return fmt.Errorf("a name is required to link a %s plugin to %s", info.Kind, path)
+	} else if info.Version == nil {
+		return fmt.Errorf("a version is required to link %s plugin %s to %s", info.Kind, info.Name, path)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	stat, err := os.Stat(abs)
+	if err != nil {
+		return fmt.Errorf("linking %s plugin %s: %w", info.Kind, info, err)
+	} else if !isPluginExecutable(stat) {
+		return fmt.Errorf("linking %s plugin %s: %s is not an executable file", info.Kind, info, abs)
+	}
+
+	linkPath, err := info.linkFilePath()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(pluginLink{Path: abs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(linkPath), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(linkPath, b, 0600); err != nil {
+		return err
+	}
+	info.logf(1, "linked %s plugin %s to %s", info.Kind, info, abs)
+	return nil
+}
+
+// Unlink removes the plugin's link, if it has one, so GetPluginPath resolves it as usual again.
+func (info PluginInfo) Unlink() error {
+	linkPath, err := info.linkFilePath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(linkPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// GetPluginLinks returns the plugins linked in the context's plugin directories, with their Path set to the executable
+// they're linked to. Linked plugins aren't returned by GetPlugins, which only lists installed plugins.
+func (ctx *Context) GetPluginLinks() ([]PluginInfo, error) {
+	dirs, err := ctx.getPluginDirs()
+	if err != nil {
+		return nil, err
+	}
+	var plugins []PluginInfo
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(filepath.Join(dir, pluginLinksDir))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		for _, file := range files {
+			match := pluginRegexp.FindStringSubmatch(strings.TrimSuffix(file.Name(), ".json"))
+			if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") || match == nil ||
+				!IsPluginKind(match[pluginRegexp.SubexpIndex("Kind")]) {
+				continue
+			}
+			version, err := semver.ParseTolerant(match[pluginRegexp.SubexpIndex("Version")])
+			if err != nil {
+				continue
+			}
+			b, err := ioutil.ReadFile(filepath.Join(dir, pluginLinksDir, file.Name()))
+			if err != nil {
+				return nil, err
+			}
+			var link pluginLink
+			if err := json.Unmarshal(b, &link); err != nil {
+				return nil, fmt.Errorf("reading plugin link %s: %w", file.Name(), err)
+			}
+			plugins = append(plugins, PluginInfo{
+				Kind:      PluginKind(match[pluginRegexp.SubexpIndex("Kind")]),
+				Name:      match[pluginRegexp.SubexpIndex("Name")],
+				Version:   &version,
+				Path:      link.Path,
+				PluginDir: dir,
+				ctx:       ctx,
+			})
+		}
+	}
+	return plugins, nil
+}
+
+// getPluginLinkPath finds the linked plugin that GetPluginPath resolves, if any. A link to an executable that no longer
+// exists is an error rather than falling back to the plugin cache, so development sessions don't silently run the
+// wrong build.
+func (ctx *Context) getPluginLinkPath(kind PluginKind, name string,
+	version *semver.Version) (pluginPathEntry, bool, error) {
+	links, err := ctx.GetPluginLinks()
+	if err != nil {
+		return pluginPathEntry{}, false, fmt.Errorf("loading plugin link list: %w", err)
+	}
+	match := ctx.matchPlugin(links, kind, name, version, ctx.legacyPluginSearch())
+	if match == nil {
+		return pluginPathEntry{}, false, nil
+	}
+	if _, err := os.Stat(match.Path); err != nil {
+		return pluginPathEntry{}, false, fmt.Errorf("%s plugin %s is linked to %s, which can't be used: %w; rebuild "+
+			"it, or unlink the plugin", kind, match, match.Path, err)
+	}
+	ctx.logf(6, "GetPluginPath(%s, %s, %v): found link to %s", kind, name, version, match.Path)
+	return pluginPathEntry{info: *match, path: match.Path}, true, nil
+}
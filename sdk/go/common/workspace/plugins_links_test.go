@@ -0,0 +1,74 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginLinks(t *testing.T) {
+	t.Parallel()
+
+	pluginDir := t.TempDir()
+	ctx := &Context{Home: t.TempDir(), PluginDir: pluginDir}
+	v := semver.MustParse("1.0.0")
+	info, err := ctx.Plugin(PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &v, PluginDir: pluginDir})
+	require.NoError(t, err)
+	require.NoError(t, info.Install(ioutil.NopCloser(bytes.NewReader(mockPluginTGZ(t))), false))
+
+	binary := filepath.Join(t.TempDir(), "pulumi-resource-mock")
+	require.NoError(t, ioutil.WriteFile(binary, []byte("#!/bin/sh\n"), 0700))
+	err = info.Link(filepath.Dir(binary))
+	assert.EqualError(t, err, "linking resource plugin mock-1.0.0: "+filepath.Dir(binary)+" is not an executable file")
+	require.NoError(t, info.Link(binary))
+
+	// The link is used instead of the installed plugin, without being copied.
+	resolution, err := ctx.ResolvePlugin(ResourcePlugin, "mock", &v)
+	require.NoError(t, err)
+	assert.Equal(t, PluginOriginLinked, resolution.Origin)
+	assert.Equal(t, binary, resolution.Path)
+	links, err := ctx.GetPluginLinks()
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, "mock", links[0].Name)
+	assert.Equal(t, binary, links[0].Path)
+	plugins, err := ctx.GetPlugins()
+	require.NoError(t, err)
+	assert.Len(t, plugins, 1, "links aren't installed plugins")
+
+	// Other versions aren't linked.
+	other := semver.MustParse("2.0.0")
+	assert.True(t, HasPlugin(info))
+	assert.False(t, HasPlugin(PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &other, PluginDir: pluginDir}))
+
+	// Links to builds that are gone aren't silently replaced by the installed plugin.
+	require.NoError(t, os.Remove(binary))
+	_, err = ctx.ResolvePlugin(ResourcePlugin, "mock", &v)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "resource plugin mock-1.0.0 is linked to "+binary+", which can't be used")
+
+	require.NoError(t, info.Unlink())
+	resolution, err = ctx.ResolvePlugin(ResourcePlugin, "mock", &v)
+	require.NoError(t, err)
+	assert.Equal(t, PluginOriginCache, resolution.Origin)
+}
@@ -0,0 +1,319 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djherbis/times"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/fsutil"
+)
+
+// PluginMaintenanceIntervalEnvVar sets how often the plugin cache is maintained, as a duration such as 12h. 0
+// disables maintenance.
+const PluginMaintenanceIntervalEnvVar = "PULUMI_PLUGIN_MAINTENANCE_INTERVAL"
+
+// DefaultPluginMaintenanceInterval is how often the plugin cache is maintained by default.
+const DefaultPluginMaintenanceInterval = 24 * time.Hour
+
+// pluginMaintenanceStateFile records when the plugin directory it's in was last maintained.
+const pluginMaintenanceStateFile = ".maintenance.json"
+
+// stalePluginInstallAge is how old a lock or partial file left behind by an install has to be before maintenance
+// considers the install dead. Installs take minutes at most, so this leaves plenty of room for slow ones.
+const stalePluginInstallAge = time.Hour
+
+// PluginMaintenanceReport lists what a maintenance pass removed from the plugin cache.
+type PluginMaintenanceReport struct {
+	// Locks are the paths of lock files left behind by plugins that are no longer installed.
+	Locks []string
+	// Partials are the paths of the directories of plugins whose installs never finished.
+	Partials []string
+	// Collected are the plugins removed by the GC policy in PluginConfigFile.
+	Collected []PluginInfo
+	// Spooled are the paths of partial downloads that were abandoned long ago, or that took up too much space.
+	Spooled []string
+	// VirtualEnvs are the paths of the shared virtual environments that no installed plugin linked to anymore.
+	VirtualEnvs []string
+}
+
+type pluginMaintenanceState struct {
+	LastRun time.Time `json:"lastRun"`
+	// BundledPluginsVersion is the version of the CLI whose bundled plugins were last migrated into the cache.
+	BundledPluginsVersion string `json:"bundledPluginsVersion,omitempty"`
+}
+
+// readPluginMaintenanceState reads the maintenance state of the plugin directory root, which is empty if it was never
+// maintained.
+func readPluginMaintenanceState(root string) (pluginMaintenanceState, error) {
+	var state pluginMaintenanceState
+	statePath := filepath.Join(root, pluginMaintenanceStateFile)
+	b, err := ioutil.ReadFile(statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return pluginMaintenanceState{}, fmt.Errorf("invalid plugin maintenance state %s: %w", statePath, err)
+	}
+	return state, nil
+}
+
+// updatePluginMaintenanceState updates the maintenance state of the plugin directory root with update. The caller
+// must hold the directory's maintenance lock.
+func (ctx *Context) updatePluginMaintenanceState(root string, update func(state *pluginMaintenanceState)) error {
+	state, err := readPluginMaintenanceState(root)
+	if err != nil {
+		ctx.logf(5, "ignoring %v", err)
+	}
+	update(&state)
+	b, err := json.Marshal(state)
+	contract.AssertNoError(err)
+	return ioutil.WriteFile(filepath.Join(root, pluginMaintenanceStateFile), b, 0600)
+}
+
+// maintainedPluginDirs remembers when each plugin directory was last found to be maintained in this process, so
+// the state file isn't read by every workspace operation.
+var maintainedPluginDirs = struct {
+	sync.Mutex
+	checked map[string]time.Time
+}{checked: map[string]time.Time{}}
+
+// getPluginMaintenanceInterval returns how often the plugin cache is maintained, or zero if it isn't.
+func getPluginMaintenanceInterval() (time.Duration, error) {
+	v := os.Getenv(PluginMaintenanceIntervalEnvVar)
+	if v == "" {
+		return DefaultPluginMaintenanceInterval, nil
+	}
+	interval, err := time.ParseDuration(v)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("%s must be a positive duration, such as 12h; got %q", PluginMaintenanceIntervalEnvVar, v)
+	}
+	return interval, nil
+}
+
+// maybeMaintainPlugins maintains the context's plugin directories, unless they were maintained within the
+// maintenance interval. Maintenance is opportunistic: its failures are logged rather than returned.
+func (ctx *Context) maybeMaintainPlugins() {
+	interval, err := getPluginMaintenanceInterval()
+	if err != nil {
+		ctx.logf(5, "skipping plugin cache maintenance: %v", err)
+		return
+	} else if interval == 0 {
+		return
+	}
+	dir, err := ctx.GetPluginDir()
+	if err != nil {
+		ctx.logf(5, "skipping plugin cache maintenance: %v", err)
+		return
+	}
+
+	now := ctx.now()
+	maintainedPluginDirs.Lock()
+	checked, ok := maintainedPluginDirs.checked[dir]
+	if ok && now.Sub(checked) < interval {
+		maintainedPluginDirs.Unlock()
+		return
+	}
+	maintainedPluginDirs.checked[dir] = now
+	maintainedPluginDirs.Unlock()
+
+	statePath := filepath.Join(dir, pluginMaintenanceStateFile)
+	var state pluginMaintenanceState
+	if b, err := ioutil.ReadFile(statePath); err == nil {
+		if err := json.Unmarshal(b, &state); err != nil {
+			ctx.logf(5, "ignoring invalid plugin maintenance state %s: %v", statePath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		ctx.logf(5, "skipping plugin cache maintenance: %v", err)
+		return
+	}
+	if now.Sub(state.LastRun) < interval {
+		maintainedPluginDirs.Lock()
+		maintainedPluginDirs.checked[dir] = state.LastRun
+		maintainedPluginDirs.Unlock()
+		return
+	}
+
+	if _, err := ctx.MaintainPlugins(); err != nil {
+		ctx.logf(5, "plugin cache maintenance failed: %v", err)
+	}
+}
+
+// MaintainPlugins removes what failed and abandoned installs and downloads left behind in the context's plugin
+// directories, and the plugins the GC policy in PluginConfigFile says have expired, returning what it removed.
+// Workspace operations call it at most once per maintenance interval, so the cache heals itself without a manual
+// prune.
+func (ctx *Context) MaintainPlugins() (*PluginMaintenanceReport, error) {
+	root, err := ctx.GetPluginDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, notWritableError(root, err)
+	}
+
+	// Keep passes in separate processes from racing each other.
+	mutex := fsutil.NewFileMutex(filepath.Join(root, ".maintenance.lock"))
+	if err := mutex.Lock(); err != nil {
+		return nil, notWritableError(root, err)
+	}
+	defer func() { contract.IgnoreError(mutex.Unlock()) }()
+
+	config, err := ctx.GetPluginConfig()
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := ctx.getPluginDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PluginMaintenanceReport{}
+	for _, dir := range dirs {
+		if err := ctx.maintainPluginDir(dir, config.GC, report); err != nil {
+			return report, err
+		}
+	}
+	if err := ctx.maintainPluginSpool(root, report); err != nil {
+		return report, err
+	}
+
+	now := ctx.now()
+	if err := ctx.updatePluginMaintenanceState(root, func(state *pluginMaintenanceState) {
+		state.LastRun = now
+	}); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// maintainPluginDir maintains a single plugin directory, adding what it removed to the report.
+func (ctx *Context) maintainPluginDir(dir string, gc PluginGCPolicy, report *PluginMaintenanceReport) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	exists := map[string]bool{}
+	for _, file := range files {
+		exists[file.Name()] = true
+	}
+
+	now := ctx.now()
+	var installed []PluginInfo
+	for _, file := range files {
+		name := file.Name()
+		path := filepath.Join(dir, name)
+		stale := now.Sub(file.ModTime()) > stalePluginInstallAge
+		switch {
+		case strings.HasSuffix(name, ".partial") && !file.IsDir():
+			// An install that never finished, and isn't still running, leaves a broken plugin directory.
+			pluginDir := strings.TrimSuffix(path, ".partial")
+			if !stale {
+				continue
+			}
+			ctx.logf(5, "removing plugin %s, whose install never finished", pluginDir)
+			if err := os.RemoveAll(pluginDir); err != nil {
+				return err
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			report.Partials = append(report.Partials, pluginDir)
+		case strings.HasSuffix(name, ".lock") && !file.IsDir():
+			// The locks of plugins that are installed are left in place, since they might be in use.
+			plugin := strings.TrimSuffix(name, ".lock")
+			if !stale || exists[plugin] || !pluginRegexp.MatchString(plugin) {
+				continue
+			}
+			ctx.logf(5, "removing stale plugin lock file %s", path)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			report.Locks = append(report.Locks, path)
+		default:
+			if kind, name, version, ok := tryPlugin(file); ok && !exists[file.Name()+".partial"] {
+				installed = append(installed, PluginInfo{
+					Kind:         kind,
+					Name:         name,
+					Version:      &version,
+					PluginDir:    dir,
+					LastUsedTime: times.Get(file).AccessTime(),
+				})
+			}
+		}
+	}
+
+	for _, plugin := range expiredPlugins(installed, gc, now) {
+		plugin.logf(5, "removing plugin %s, unused since %v", plugin, plugin.LastUsedTime)
+		if err := plugin.Delete(); err != nil {
+			return err
+		}
+		report.Collected = append(report.Collected, plugin)
+	}
+
+	// Shared virtual environments are left behind by plugins that were removed without Delete.
+	venvs, err := pruneSharedVirtualEnvs(dir)
+	report.VirtualEnvs = append(report.VirtualEnvs, venvs...)
+	return err
+}
+
+// expiredPlugins returns the plugins that have gone unused for longer than the policy's MaxAge, apart from the
+// newest KeepVersions versions of each plugin.
+func expiredPlugins(plugins []PluginInfo, gc PluginGCPolicy, now time.Time) []PluginInfo {
+	if gc.MaxAge == 0 {
+		return nil
+	}
+
+	sort.Slice(plugins, func(i, j int) bool {
+		if plugins[i].Kind != plugins[j].Kind {
+			return plugins[i].Kind < plugins[j].Kind
+		} else if plugins[i].Name != plugins[j].Name {
+			return plugins[i].Name < plugins[j].Name
+		}
+		return plugins[i].Version.GT(*plugins[j].Version)
+	})
+
+	var expired []PluginInfo
+	kept := 0
+	for i, plugin := range plugins {
+		if i == 0 || plugin.Kind != plugins[i-1].Kind || plugin.Name != plugins[i-1].Name {
+			kept = 0
+		}
+		if gc.KeepVersions > 0 && kept < gc.KeepVersions {
+			kept++
+			continue
+		}
+		if now.Sub(plugin.LastUsedTime) > gc.MaxAge {
+			expired = append(expired, plugin)
+		}
+	}
+	return expired
+}
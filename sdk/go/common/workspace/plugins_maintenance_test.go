@@ -0,0 +1,124 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeMaintenanceTestFile creates a plugin directory, or a file if dir is false, last modified and used at the
+// given time.
+func writeMaintenanceTestFile(t *testing.T, path string, dir bool, at time.Time) {
+	if dir {
+		require.NoError(t, os.MkdirAll(path, 0700))
+	} else {
+		require.NoError(t, ioutil.WriteFile(path, nil, 0600))
+	}
+	require.NoError(t, os.Chtimes(path, at, at))
+}
+
+func TestMaintainPlugins(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	old, recent := now.Add(-90*24*time.Hour), now.Add(-time.Minute)
+	home, dir := t.TempDir(), t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(home, PluginConfigFile),
+		[]byte("gc:\n  maxAge: 720h\n  keepVersions: 1\n"), 0600))
+	ctx := &Context{Home: home, PluginDir: dir, Clock: FixedClock(now)}
+
+	for _, f := range []struct {
+		name string
+		dir  bool
+		at   time.Time
+	}{
+		// An abandoned install, and one that is still running.
+		{"resource-abandoned-v1.0.0", true, old},
+		{"resource-abandoned-v1.0.0.partial", false, old},
+		{"resource-installing-v1.0.0", true, recent},
+		{"resource-installing-v1.0.0.partial", false, recent},
+		// The lock of a plugin that was removed, and of one that is installed.
+		{"resource-removed-v1.0.0.lock", false, old},
+		{"resource-installed-v1.0.0", true, recent},
+		{"resource-installed-v1.0.0.lock", false, old},
+		// Only the newest version of a plugin is kept once they expire.
+		{"resource-expired-v1.0.0", true, old},
+		{"resource-expired-v2.0.0", true, old},
+		{"resource-expired-v3.0.0", true, old},
+		{"resource-used-v1.0.0", true, recent},
+	} {
+		writeMaintenanceTestFile(t, filepath.Join(dir, f.name), f.dir, f.at)
+	}
+
+	report, err := ctx.MaintainPlugins()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "resource-abandoned-v1.0.0")}, report.Partials)
+	assert.Equal(t, []string{filepath.Join(dir, "resource-removed-v1.0.0.lock")}, report.Locks)
+	var collected []string
+	for _, plugin := range report.Collected {
+		collected = append(collected, plugin.String())
+	}
+	assert.ElementsMatch(t, []string{"expired-1.0.0", "expired-2.0.0"}, collected)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	var remaining []string
+	for _, file := range files {
+		remaining = append(remaining, file.Name())
+	}
+	assert.ElementsMatch(t, []string{
+		".maintenance.json",
+		".maintenance.lock",
+		"resource-installing-v1.0.0",
+		"resource-installing-v1.0.0.partial",
+		"resource-installed-v1.0.0",
+		"resource-installed-v1.0.0.lock",
+		"resource-expired-v3.0.0",
+		"resource-used-v1.0.0",
+	}, remaining)
+}
+
+func TestMaybeMaintainPlugins(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	dir := t.TempDir()
+	lock := filepath.Join(dir, "resource-removed-v1.0.0.lock")
+
+	// Listing plugins maintains the cache the first time.
+	ctx := &Context{Home: t.TempDir(), PluginDir: dir, Clock: FixedClock(now)}
+	writeMaintenanceTestFile(t, lock, false, now.Add(-2*time.Hour))
+	_, err := ctx.GetPlugins()
+	require.NoError(t, err)
+	assert.NoFileExists(t, lock)
+
+	// But not again until the maintenance interval has passed.
+	writeMaintenanceTestFile(t, lock, false, now.Add(-2*time.Hour))
+	_, err = ctx.GetPlugins()
+	require.NoError(t, err)
+	assert.FileExists(t, lock)
+
+	ctx.Clock = FixedClock(now.Add(DefaultPluginMaintenanceInterval + time.Minute))
+	_, err = ctx.GetPlugins()
+	require.NoError(t, err)
+	assert.NoFileExists(t, lock)
+}
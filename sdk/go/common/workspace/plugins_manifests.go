@@ -0,0 +1,399 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver"
+	"golang.org/x/mod/modfile"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// PluginSpec is a plugin a program requires, as derived from one of its language's package manifests.
+type PluginSpec struct {
+	// Name is the name of the plugin.
+	Name string
+	// Kind is the kind of the plugin.
+	Kind PluginKind
+	// Version is the lowest version of the plugin the manifest allows, or nil if the manifest doesn't constrain it.
+	Version *semver.Version
+	// PluginDownloadURL is the URL the plugin is downloaded from, if the provider SDK embeds one.
+	PluginDownloadURL string
+	// Manifest is the path of the manifest the requirement was derived from.
+	Manifest string
+}
+
+// PluginInfo returns the plugin the spec requires.
+func (spec PluginSpec) PluginInfo() PluginInfo {
+	return PluginInfo{
+		Name:              spec.Name,
+		Kind:              spec.Kind,
+		Version:           spec.Version,
+		PluginDownloadURL: spec.PluginDownloadURL,
+	}
+}
+
+// PluginManifestAnalyzer derives plugin requirements from one kind of language package manifest.
+type PluginManifestAnalyzer struct {
+	// Pattern matches the names of the manifests the analyzer reads, as with filepath.Match.
+	Pattern string
+	// Analyze returns the plugins required by the provider SDKs the manifest at path depends on.
+	Analyze func(path string) ([]PluginSpec, error)
+}
+
+// PluginManifestAnalyzers are the analyzers GetManifestPluginRequirements runs. Analyzers for other package managers
+// may be added to it.
+var PluginManifestAnalyzers = []PluginManifestAnalyzer{
+	{Pattern: "package.json", Analyze: analyzePackageJSON},
+	{Pattern: "requirements.txt", Analyze: analyzeRequirementsTxt},
+	{Pattern: "go.mod", Analyze: analyzeGoMod},
+	{Pattern: "*.csproj", Analyze: analyzeCsproj},
+}
+
+// nonProviderPackages are the Pulumi packages in each ecosystem that don't have a plugin.
+var nonProviderPackages = map[string]bool{
+	"@pulumi/pulumi":                  true,
+	"@pulumi/policy":                  true,
+	"@pulumi/query":                   true,
+	"pulumi":                          true,
+	"pulumi-policy":                   true,
+	"github.com/pulumi/pulumi/sdk":    true,
+	"github.com/pulumi/pulumi/sdk/v2": true,
+	"github.com/pulumi/pulumi/sdk/v3": true,
+	"Pulumi":                          true,
+	"Pulumi.Automation":               true,
+	"Pulumi.FSharp":                   true,
+	"Pulumi.Policy":                   true,
+}
+
+// GetManifestPluginRequirements returns the plugins required by the provider SDKs that the package manifests in dir
+// depend on, sorted by kind and name. Dependencies aren't resolved, so the versions are the lowest each manifest
+// allows, unless the dependencies have been installed and their SDKs embed plugin metadata. A plugin required by more
+// than one manifest is returned once, at the highest of the versions they require.
+func GetManifestPluginRequirements(dir string) ([]PluginSpec, error) {
+	var all []PluginSpec
+	for _, analyzer := range PluginManifestAnalyzers {
+		paths, err := filepath.Glob(filepath.Join(dir, analyzer.Pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			specs, err := analyzer.Analyze(path)
+			if err != nil {
+				return nil, fmt.Errorf("reading plugin requirements from %s: %w", path, err)
+			}
+			all = append(all, specs...)
+		}
+	}
+	return mergePluginSpecs(all), nil
+}
+
+// mergePluginSpecs returns one spec for each plugin in specs, at the highest version required of it, sorted by kind
+// and name.
+func mergePluginSpecs(specs []PluginSpec) []PluginSpec {
+	var merged []PluginSpec
+	index := map[string]int{}
+	for _, spec := range specs {
+		key := string(spec.Kind) + "/" + spec.Name
+		i, has := index[key]
+		if !has {
+			index[key] = len(merged)
+			merged = append(merged, spec)
+			continue
+		}
+		existing := &merged[i]
+		if spec.Version != nil && (existing.Version == nil || spec.Version.GT(*existing.Version)) {
+			existing.Version = spec.Version
+		}
+		if existing.PluginDownloadURL == "" {
+			existing.PluginDownloadURL = spec.PluginDownloadURL
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Kind != merged[j].Kind {
+			return merged[i].Kind < merged[j].Kind
+		}
+		return merged[i].Name < merged[j].Name
+	})
+	return merged
+}
+
+// pulumiPluginMetadata is the plugin metadata provider SDKs embed: in the "pulumi" section of a Node.js package's
+// package.json, and in the pulumi-plugin.json of a Python package.
+type pulumiPluginMetadata struct {
+	Resource bool   `json:"resource"`
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Server   string `json:"server"`
+}
+
+// apply overrides spec with the metadata, if it describes a resource plugin.
+func (metadata pulumiPluginMetadata) apply(spec *PluginSpec) {
+	if !metadata.Resource {
+		return
+	}
+	if metadata.Name != "" {
+		spec.Name = metadata.Name
+	}
+	if metadata.Version != "" {
+		if version, err := semver.ParseTolerant(metadata.Version); err == nil {
+			spec.Version = &version
+		}
+	}
+	spec.PluginDownloadURL = metadata.Server
+}
+
+// analyzePackageJSON returns the plugins required by the @pulumi packages a package.json depends on. The metadata of
+// installed packages in the adjacent node_modules takes precedence over the package name and version range.
+func analyzePackageJSON(path string) ([]PluginSpec, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest struct {
+		Dependencies map[string]string `json:"dependencies"`
+	}
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, err
+	}
+
+	var specs []PluginSpec
+	for pkg, constraint := range manifest.Dependencies {
+		if !strings.HasPrefix(pkg, "@pulumi/") || nonProviderPackages[pkg] {
+			continue
+		}
+		spec := PluginSpec{
+			Name:     strings.TrimPrefix(pkg, "@pulumi/"),
+			Kind:     ResourcePlugin,
+			Version:  minimumVersion(strings.Split(constraint, "||")[0]),
+			Manifest: path,
+		}
+		installed := filepath.Join(filepath.Dir(path), "node_modules", filepath.FromSlash(pkg), "package.json")
+		if b, err := ioutil.ReadFile(installed); err == nil {
+			var pkgJSON struct {
+				Pulumi pulumiPluginMetadata `json:"pulumi"`
+			}
+			if err := json.Unmarshal(b, &pkgJSON); err != nil {
+				return nil, fmt.Errorf("reading %s: %w", installed, err)
+			}
+			pkgJSON.Pulumi.apply(&spec)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// pipRequirementRegexp matches the package name and version specifiers of a line of a requirements.txt.
+var pipRequirementRegexp = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)\s*(?:\[[^\]]*\])?\s*([^;#]*)`)
+
+// pipNameSeparatorsRegexp matches the runs of separators that pip treats the same in package names.
+var pipNameSeparatorsRegexp = regexp.MustCompile(`[-_.]+`)
+
+// analyzeRequirementsTxt returns the plugins required by the pulumi- packages a requirements.txt depends on. The
+// pulumi-plugin.json of packages installed in a virtual environment next to it takes precedence over the package name
+// and version specifiers.
+func analyzeRequirementsTxt(path string) ([]PluginSpec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer contract.IgnoreClose(f)
+
+	var specs []PluginSpec
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "-") {
+			continue
+		}
+		match := pipRequirementRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		// Package names are case insensitive, and treat runs of '-', '_' and '.' the same.
+		pkg := strings.ToLower(pipNameSeparatorsRegexp.ReplaceAllString(match[1], "-"))
+		if !strings.HasPrefix(pkg, "pulumi-") || nonProviderPackages[pkg] {
+			continue
+		}
+		spec := PluginSpec{
+			Name:     strings.TrimPrefix(pkg, "pulumi-"),
+			Kind:     ResourcePlugin,
+			Version:  minimumPipVersion(match[2]),
+			Manifest: path,
+		}
+		if err := applyPythonPluginMetadata(filepath.Dir(path), pkg, &spec); err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return specs, nil
+}
+
+// applyPythonPluginMetadata applies the pulumi-plugin.json of pkg to spec, if the package is installed in a virtual
+// environment in dir.
+func applyPythonPluginMetadata(dir, pkg string, spec *PluginSpec) error {
+	module := strings.ReplaceAll(pkg, "-", "_")
+	for _, pattern := range []string{
+		filepath.Join(dir, "*", "lib", "python*", "site-packages", module, "pulumi-plugin.json"),
+		filepath.Join(dir, "*", "Lib", "site-packages", module, "pulumi-plugin.json"),
+	} {
+		paths, err := filepath.Glob(pattern)
+		if err != nil || len(paths) == 0 {
+			continue
+		}
+		b, err := ioutil.ReadFile(paths[0])
+		if err != nil {
+			return err
+		}
+		var metadata pulumiPluginMetadata
+		if err := json.Unmarshal(b, &metadata); err != nil {
+			return fmt.Errorf("reading %s: %w", paths[0], err)
+		}
+		metadata.apply(spec)
+		return nil
+	}
+	return nil
+}
+
+// goProviderModuleRegexp matches the paths of the Go modules of Pulumi's provider SDKs.
+var goProviderModuleRegexp = regexp.MustCompile(`^github\.com/pulumi/pulumi-([a-z0-9-]+)/sdk(?:/v[0-9]+)?$`)
+
+// analyzeGoMod returns the plugins required by the provider SDK modules a go.mod requires.
+func analyzeGoMod(path string) ([]PluginSpec, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := modfile.ParseLax(path, b, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var specs []PluginSpec
+	for _, require := range file.Require {
+		match := goProviderModuleRegexp.FindStringSubmatch(require.Mod.Path)
+		if match == nil || nonProviderPackages[require.Mod.Path] {
+			continue
+		}
+		spec := PluginSpec{Name: match[1], Kind: ResourcePlugin, Manifest: path}
+		if version, err := semver.ParseTolerant(require.Mod.Version); err == nil {
+			spec.Version = &version
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// analyzeCsproj returns the plugins required by the Pulumi provider packages a .NET project references. The plugin's
+// name is derived from the package's: Pulumi.AzureNative requires the azure-native plugin.
+func analyzeCsproj(path string) ([]PluginSpec, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var project struct {
+		ItemGroups []struct {
+			PackageReferences []struct {
+				Include        string `xml:"Include,attr"`
+				Version        string `xml:"Version,attr"`
+				VersionElement string `xml:"Version"`
+			} `xml:"PackageReference"`
+		} `xml:"ItemGroup"`
+	}
+	if err := xml.Unmarshal(b, &project); err != nil {
+		return nil, err
+	}
+
+	var specs []PluginSpec
+	for _, group := range project.ItemGroups {
+		for _, ref := range group.PackageReferences {
+			if !strings.HasPrefix(ref.Include, "Pulumi.") || nonProviderPackages[ref.Include] {
+				continue
+			}
+			name := strings.TrimPrefix(ref.Include, "Pulumi.")
+			if strings.Contains(name, ".") {
+				// Packages like Pulumi.Automation.Extensions aren't providers.
+				continue
+			}
+			if strings.HasSuffix(name, "Native") && name != "Native" {
+				name = strings.TrimSuffix(name, "Native") + "-native"
+			}
+			version := ref.Version
+			if version == "" {
+				version = ref.VersionElement
+			}
+			// NuGet versions are minimums unless bracketed, and bracketed ranges start with the minimum.
+			specs = append(specs, PluginSpec{
+				Name:     strings.ToLower(name),
+				Kind:     ResourcePlugin,
+				Version:  minimumVersion(strings.Split(strings.TrimLeft(version, "[("), ",")[0]),
+				Manifest: path,
+			})
+		}
+	}
+	return specs, nil
+}
+
+// minimumVersion returns the lowest version an npm-style version constraint allows, or nil if it doesn't have one.
+func minimumVersion(constraint string) *semver.Version {
+	fields := strings.Fields(constraint)
+	if len(fields) == 0 {
+		return nil
+	}
+	first := fields[0]
+	if strings.HasPrefix(first, "<") {
+		return nil
+	}
+	first = strings.TrimLeft(first, "^~>=v")
+	for _, wildcard := range []string{"x", "X", "*"} {
+		first = strings.ReplaceAll(first, "."+wildcard, ".0")
+	}
+	version, err := semver.ParseTolerant(first)
+	if err != nil {
+		return nil
+	}
+	return &version
+}
+
+// minimumPipVersion returns the lowest version pip version specifiers allow, or nil if they don't have one.
+func minimumPipVersion(specifiers string) *semver.Version {
+	for _, specifier := range strings.Split(specifiers, ",") {
+		specifier = strings.TrimSpace(specifier)
+		for _, op := range []string{"===", "==", ">=", "~="} {
+			if strings.HasPrefix(specifier, op) {
+				version, err := semver.ParseTolerant(strings.TrimSpace(strings.TrimPrefix(specifier, op)))
+				if err != nil {
+					return nil
+				}
+				return &version
+			}
+		}
+	}
+	return nil
+}
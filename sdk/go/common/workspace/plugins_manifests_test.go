@@ -0,0 +1,169 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeManifestFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	}
+	return dir
+}
+
+func TestGetManifestPluginRequirements(t *testing.T) {
+	t.Parallel()
+
+	version := func(v string) *semver.Version {
+		parsed := semver.MustParse(v)
+		return &parsed
+	}
+	tests := []struct {
+		name     string
+		files    map[string]string
+		expected []PluginSpec
+	}{
+		{
+			name: "package.json",
+			files: map[string]string{
+				"package.json": `{"dependencies": {
+					"@pulumi/pulumi": "^3.0.0",
+					"@pulumi/aws": "^5.1.0",
+					"@pulumi/random": "4.x || 5.x",
+					"@pulumi/acme": "*",
+					"left-pad": "1.0.0"
+				}}`,
+				"node_modules/@pulumi/acme/package.json": `{"name": "@pulumi/acme", "pulumi": {
+					"resource": true, "name": "acme", "version": "1.2.3", "server": "https://plugins.acme.com"
+				}}`,
+			},
+			expected: []PluginSpec{
+				{
+					Name: "acme", Version: version("1.2.3"), PluginDownloadURL: "https://plugins.acme.com",
+					Manifest: "package.json",
+				},
+				{Name: "aws", Version: version("5.1.0"), Manifest: "package.json"},
+				{Name: "random", Version: version("4.0.0"), Manifest: "package.json"},
+			},
+		},
+		{
+			name: "requirements.txt",
+			files: map[string]string{
+				"requirements.txt": "# Pulumi\npulumi>=3.0.0,<4.0.0\n-r base.txt\nPulumi_AWS>=5.1.0,<6.0.0\n" +
+					"pulumi-random==4.2.0 ; python_version >= '3.7'\npulumi-acme\nrequests\n",
+				"venv/lib/python3.9/site-packages/pulumi_acme/pulumi-plugin.json": `{
+					"resource": true, "name": "acme", "version": "1.2.3", "server": "https://plugins.acme.com"
+				}`,
+			},
+			expected: []PluginSpec{
+				{
+					Name: "acme", Version: version("1.2.3"), PluginDownloadURL: "https://plugins.acme.com",
+					Manifest: "requirements.txt",
+				},
+				{Name: "aws", Version: version("5.1.0"), Manifest: "requirements.txt"},
+				{Name: "random", Version: version("4.2.0"), Manifest: "requirements.txt"},
+			},
+		},
+		{
+			name: "go.mod",
+			files: map[string]string{
+				"go.mod": `module example.com/infra
+
+go 1.17
+
+require (
+	github.com/pulumi/pulumi-aws/sdk/v5 v5.1.0
+	github.com/pulumi/pulumi-azure-native/sdk v1.60.0
+	github.com/pulumi/pulumi/sdk/v3 v3.30.0
+	github.com/stretchr/testify v1.6.1
+)
+`,
+			},
+			expected: []PluginSpec{
+				{Name: "aws", Version: version("5.1.0"), Manifest: "go.mod"},
+				{Name: "azure-native", Version: version("1.60.0"), Manifest: "go.mod"},
+			},
+		},
+		{
+			name: "csproj",
+			files: map[string]string{
+				"Infra.csproj": `<Project Sdk="Microsoft.NET.Sdk">
+  <ItemGroup>
+    <PackageReference Include="Pulumi" Version="3.*" />
+    <PackageReference Include="Pulumi.Aws" Version="5.1.0" />
+    <PackageReference Include="Pulumi.AzureNative">
+      <Version>[1.60.0,2.0.0)</Version>
+    </PackageReference>
+    <PackageReference Include="Pulumi.Automation.Extensions" Version="1.0.0" />
+  </ItemGroup>
+</Project>`,
+			},
+			expected: []PluginSpec{
+				{Name: "aws", Version: version("5.1.0"), Manifest: "Infra.csproj"},
+				{Name: "azure-native", Version: version("1.60.0"), Manifest: "Infra.csproj"},
+			},
+		},
+		{
+			name: "merged",
+			files: map[string]string{
+				"package.json":     `{"dependencies": {"@pulumi/aws": "^5.1.0"}}`,
+				"requirements.txt": "pulumi-aws>=5.3.0\n",
+			},
+			expected: []PluginSpec{
+				{Name: "aws", Version: version("5.3.0"), Manifest: "package.json"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := writeManifestFiles(t, tt.files)
+			specs, err := GetManifestPluginRequirements(dir)
+			require.NoError(t, err)
+			for i := range tt.expected {
+				tt.expected[i].Kind = ResourcePlugin
+				tt.expected[i].Manifest = filepath.Join(dir, tt.expected[i].Manifest)
+			}
+			assert.Equal(t, tt.expected, specs)
+		})
+	}
+}
+
+func TestGetManifestPluginRequirementsErrors(t *testing.T) {
+	t.Parallel()
+
+	dir := writeManifestFiles(t, map[string]string{"package.json": `{"dependencies": [`})
+	_, err := GetManifestPluginRequirements(dir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "package.json")
+
+	specs, err := GetManifestPluginRequirements(t.TempDir())
+	assert.NoError(t, err)
+	assert.Empty(t, specs)
+}
@@ -0,0 +1,176 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// pluginMetadataCacheDir is the directory in the plugin cache that holds the responses to the API requests plugin
+// sources look versions up with, such as GitHub's latest releases and plugin index files, so they can be revalidated
+// with their ETags rather than downloaded again.
+const pluginMetadataCacheDir = ".metadata"
+
+const (
+	// pluginMetadataMaxStaleness is how long after a cached response was last known to be current it may be used in
+	// place of an upstream error.
+	pluginMetadataMaxStaleness = 24 * time.Hour
+	// pluginMetadataMaxSize is the size of the largest response that is cached.
+	pluginMetadataMaxSize = 16 << 20
+)
+
+// metadataCacheEntry is a cached response to an API request.
+type metadataCacheEntry struct {
+	URL  string `json:"url"`
+	ETag string `json:"etag"`
+	// Validated is when the response was last known to be current.
+	Validated time.Time `json:"validated"`
+	Body      []byte    `json:"body"`
+}
+
+// metadataCache is the cache of the response to an API request, keyed by the request's URL and credentials, so the
+// responses sent to different credentials aren't mixed up.
+type metadataCache struct {
+	ctx  *Context
+	url  string
+	path string
+	// entry is the cached response, or nil if there is none.
+	entry *metadataCacheEntry
+}
+
+// metadataCacheFor returns the cache of the response to req, or nil if it isn't cached. Only the GET requests plugin
+// sources send to their APIs for JSON or XML are cached, not the files they download.
+func (ctx *Context) metadataCacheFor(req *http.Request) *metadataCache {
+	if req.Method != http.MethodGet || !isAPIRequest(req) {
+		return nil
+	}
+	root, err := ctx.GetPluginDir()
+	if err != nil {
+		return nil
+	}
+	url := req.URL.String()
+	key := spoolKey(url + "\n" + req.Header.Get("Authorization"))
+	cache := &metadataCache{ctx: ctx, url: url, path: filepath.Join(root, pluginMetadataCacheDir, key+".json")}
+
+	b, err := ioutil.ReadFile(cache.path)
+	if err != nil {
+		return cache
+	}
+	var entry metadataCacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		ctx.logf(5, "ignoring cached response for %s: %v", url, err)
+		return cache
+	}
+	if entry.URL != url {
+		return cache
+	}
+	cache.entry = &entry
+	return cache
+}
+
+// request returns req, made conditional on the cached response being out of date.
+func (cache *metadataCache) request(req *http.Request) *http.Request {
+	if cache == nil || cache.entry == nil {
+		return req
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("If-None-Match", cache.entry.ETag)
+	return req
+}
+
+// body returns the cached response's body and length.
+func (cache *metadataCache) body() (io.ReadCloser, int64, bool) {
+	return ioutil.NopCloser(bytes.NewReader(cache.entry.Body)), int64(len(cache.entry.Body)), true
+}
+
+// notModified returns the cached response if resp shows that it's current.
+func (cache *metadataCache) notModified(resp *http.Response) (io.ReadCloser, int64, bool) {
+	if cache == nil || cache.entry == nil || resp.StatusCode != http.StatusNotModified {
+		return nil, -1, false
+	}
+	contract.IgnoreClose(resp.Body)
+	cache.ctx.logf(9, "%s is unchanged; using the cached response", cache.url)
+	cache.entry.Validated = cache.ctx.now()
+	cache.save()
+	return cache.body()
+}
+
+// fallback returns the cached response in place of err, if the request failed for a reason that's likely to be
+// brief, such as a server error or rate limit, and the cached response was current recently enough.
+func (cache *metadataCache) fallback(err error) (io.ReadCloser, int64, bool) {
+	if cache == nil || cache.entry == nil {
+		return nil, -1, false
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode < 500 && httpErr.StatusCode != http.StatusTooManyRequests &&
+		!httpErr.RateLimited {
+		return nil, -1, false
+	}
+	age := cache.ctx.now().Sub(cache.entry.Validated)
+	if age > pluginMetadataMaxStaleness {
+		return nil, -1, false
+	}
+	cache.ctx.logger().Warningf(nil, "%v; using the response cached %s ago", err, age.Round(time.Second))
+	return cache.body()
+}
+
+// store caches the body of resp, a successful response, if it has an ETag to revalidate it with. It returns the body
+// to read in place of resp's.
+func (cache *metadataCache) store(resp *http.Response) (io.ReadCloser, int64, error) {
+	etag := resp.Header.Get("ETag")
+	if cache == nil || etag == "" || resp.ContentLength > pluginMetadataMaxSize {
+		return resp.Body, resp.ContentLength, nil
+	}
+	defer contract.IgnoreClose(resp.Body)
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, pluginMetadataMaxSize+1))
+	if err != nil {
+		if body, length, ok := cache.fallback(err); ok {
+			return body, length, nil
+		}
+		return nil, -1, err
+	}
+	if len(body) > pluginMetadataMaxSize {
+		return nil, -1, fmt.Errorf("the response from %s is larger than %d bytes", cache.url, pluginMetadataMaxSize)
+	}
+
+	cache.entry = &metadataCacheEntry{URL: cache.url, ETag: etag, Validated: cache.ctx.now(), Body: body}
+	cache.save()
+	return ioutil.NopCloser(bytes.NewReader(body)), int64(len(body)), nil
+}
+
+// save writes the cached response. Failing to is only logged, since the response can be downloaded again.
+func (cache *metadataCache) save() {
+	b, err := json.Marshal(cache.entry)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(cache.path), 0700)
+	}
+	if err == nil {
+		err = atomicWriteFile(cache.path, b)
+	}
+	if err != nil {
+		cache.ctx.logf(5, "caching the response for %s: %v", cache.url, err)
+	}
+}
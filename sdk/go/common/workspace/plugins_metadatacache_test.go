@@ -0,0 +1,99 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataCache(t *testing.T) {
+	t.Parallel()
+
+	var status int
+	var requested, revalidated int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested++
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			revalidated++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, err := w.Write([]byte(`{"tag_name": "v1.0.0"}`))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	ctx := &Context{Home: t.TempDir(), Clock: FixedClock(now)}
+	get := func(accept string) (string, error) {
+		req, err := buildHTTPRequest(server.URL, "")
+		require.NoError(t, err)
+		req.Header.Set("Accept", accept)
+		body, _, err := ctx.getHTTPResponse(req)
+		if err != nil {
+			return "", err
+		}
+		defer func() { assert.NoError(t, body.Close()) }()
+		b, err := ioutil.ReadAll(body)
+		require.NoError(t, err)
+		return string(b), nil
+	}
+
+	// The first response is cached, and revalidated by the next request.
+	status = http.StatusOK
+	for i := 0; i < 2; i++ {
+		body, err := get("application/json")
+		require.NoError(t, err)
+		assert.Equal(t, `{"tag_name": "v1.0.0"}`, body)
+	}
+	assert.Equal(t, 2, requested)
+	assert.Equal(t, 1, revalidated)
+
+	// Server errors are answered from the cache.
+	status = http.StatusBadGateway
+	body, err := get("application/json")
+	require.NoError(t, err)
+	assert.Equal(t, `{"tag_name": "v1.0.0"}`, body)
+
+	// Errors that aren't likely to be brief aren't.
+	status = http.StatusNotFound
+	_, err = get("application/json")
+	assert.Error(t, err)
+
+	// Nor are server errors once the cached response is too old.
+	status = http.StatusBadGateway
+	ctx.Clock = FixedClock(now.Add(pluginMetadataMaxStaleness + time.Minute))
+	_, err = get("application/json")
+	assert.Error(t, err)
+
+	// Downloads aren't cached.
+	status = http.StatusOK
+	revalidated = 0
+	_, err = get("application/octet-stream")
+	require.NoError(t, err)
+	assert.Equal(t, 0, revalidated)
+}
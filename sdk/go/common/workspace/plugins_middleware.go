@@ -0,0 +1,74 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// PluginHTTPGetter sends a request made while downloading a plugin, or looking up its versions, returning the body and
+// length, if known, of a successful response.
+type PluginHTTPGetter func(req *http.Request) (io.ReadCloser, int64, error)
+
+// PluginDownloadMiddleware wraps the getter plugin sources send their requests with, e.g. to add credentials, serve
+// responses from a cache, log requests, or compute the digests of downloads. It returns a getter that usually calls
+// next.
+type PluginDownloadMiddleware func(next PluginHTTPGetter) PluginHTTPGetter
+
+var (
+	pluginDownloadMiddlewareLock sync.Mutex
+	pluginDownloadMiddleware     []*PluginDownloadMiddleware
+)
+
+// RegisterPluginDownloadMiddleware adds middleware around the requests plugin sources send, for every Context. The
+// middleware registered first is outermost, and the middleware of each Context is inside all registered middleware.
+// The returned function unregisters the middleware.
+func RegisterPluginDownloadMiddleware(middleware PluginDownloadMiddleware) (unregister func()) {
+	pluginDownloadMiddlewareLock.Lock()
+	defer pluginDownloadMiddlewareLock.Unlock()
+
+	registered := &middleware
+	pluginDownloadMiddleware = append(pluginDownloadMiddleware, registered)
+	return func() {
+		pluginDownloadMiddlewareLock.Lock()
+		defer pluginDownloadMiddlewareLock.Unlock()
+		for i, m := range pluginDownloadMiddleware {
+			if m == registered {
+				pluginDownloadMiddleware = append(pluginDownloadMiddleware[:i:i], pluginDownloadMiddleware[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// httpGetter returns the getter the context's plugin sources send their requests with: the context's HTTP client,
+// wrapped in the registered middleware and then the context's own.
+func (ctx *Context) httpGetter() PluginHTTPGetter {
+	pluginDownloadMiddlewareLock.Lock()
+	middleware := make([]PluginDownloadMiddleware, 0, len(pluginDownloadMiddleware)+len(ctx.DownloadMiddleware))
+	for _, m := range pluginDownloadMiddleware {
+		middleware = append(middleware, *m)
+	}
+	pluginDownloadMiddlewareLock.Unlock()
+	middleware = append(middleware, ctx.DownloadMiddleware...)
+
+	getter := PluginHTTPGetter(ctx.sendHTTPRequest)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		getter = middleware[i](getter)
+	}
+	return getter
+}
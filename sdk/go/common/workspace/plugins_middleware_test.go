@@ -0,0 +1,85 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables and registers global middleware
+func TestPluginDownloadMiddleware(t *testing.T) {
+	t.Setenv(PulumiHomeEnvVar, t.TempDir())
+
+	tgz := mockPluginTGZ(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, err := w.Write(tgz)
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	var calls []string
+	record := func(name string) PluginDownloadMiddleware {
+		return func(next PluginHTTPGetter) PluginHTTPGetter {
+			return func(req *http.Request) (io.ReadCloser, int64, error) {
+				if strings.HasPrefix(req.URL.String(), server.URL) {
+					calls = append(calls, name)
+				}
+				return next(req)
+			}
+		}
+	}
+	auth := func(next PluginHTTPGetter) PluginHTTPGetter {
+		return func(req *http.Request) (io.ReadCloser, int64, error) {
+			req.Header.Set("Authorization", "Bearer secret")
+			return next(req)
+		}
+	}
+
+	unregisterFirst := RegisterPluginDownloadMiddleware(record("first"))
+	defer unregisterFirst()
+	unregisterSecond := RegisterPluginDownloadMiddleware(record("second"))
+	defer unregisterSecond()
+
+	v := semver.MustParse("1.0.0")
+	info := PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &v, PluginDownloadURL: server.URL}
+	ctx := &Context{DownloadMiddleware: []PluginDownloadMiddleware{record("context"), auth}}
+	r, _, err := ctx.Download(info)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, tgz, b)
+	assert.Equal(t, []string{"first", "second", "context"}, calls)
+
+	// Unregistered middleware is no longer called, and other contexts don't use the context's middleware.
+	calls = nil
+	unregisterFirst()
+	_, _, err = (&Context{}).Download(info)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"second"}, calls)
+}
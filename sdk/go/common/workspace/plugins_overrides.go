@@ -0,0 +1,58 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"os"
+)
+
+// PluginDownloadURLOverridesEnvVar overrides the download URLs of plugins at runtime, in the same format as the
+// overrides the CLI is built with, e.g. `resource/aws@>=5.0.0=https://mirror.corp/aws,^gcp$=https://mirror.corp/gcp`.
+// Its overrides are matched before those of the `downloadURLOverrides` setting of PluginConfigFile, which are matched
+// before those the CLI is built with.
+const PluginDownloadURLOverridesEnvVar = "PULUMI_PLUGIN_DOWNLOAD_URL_OVERRIDES"
+
+// pluginDownloadURLOverrides returns the download URL overrides from PluginDownloadURLOverridesEnvVar, then
+// PluginConfigFile, then the CLI's build, in the order they're matched.
+func (ctx *Context) pluginDownloadURLOverrides() (pluginDownloadOverrideArray, error) {
+	overrides, err := parsePluginDownloadURLOverrides(os.Getenv(PluginDownloadURLOverridesEnvVar))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", PluginDownloadURLOverridesEnvVar, err)
+	}
+	config, err := ctx.GetPluginConfig()
+	if err != nil {
+		return nil, err
+	}
+	for _, override := range config.DownloadURLOverrides {
+		parsed, err := parsePluginDownloadURLOverrides(override)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, parsed...)
+	}
+	return append(overrides, pluginDownloadURLOverridesParsed...), nil
+}
+
+// pluginDownloadURLOverride returns the URL the plugin's download URL is overridden with, and true, if it's
+// overridden.
+func (ctx *Context) pluginDownloadURLOverride(info PluginInfo) (string, bool, error) {
+	overrides, err := ctx.pluginDownloadURLOverrides()
+	if err != nil {
+		return "", false, err
+	}
+	url, ok := overrides.get(info.Kind, info.Name, info.Version)
+	return url, ok, nil
+}
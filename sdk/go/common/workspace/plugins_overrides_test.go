@@ -0,0 +1,69 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestRuntimePluginDownloadURLOverrides(t *testing.T) {
+	t.Setenv(PluginDownloadURLOverridesEnvVar, "^aws$=https://env.corp/aws")
+	ctx := &Context{Home: t.TempDir()}
+	writePluginConfig(t, ctx.Home, `
+downloadURLOverrides:
+  - ^aws$=https://config.corp/aws
+  - ^gcp$=https://config.corp/gcp
+`)
+
+	old := pluginDownloadURLOverridesParsed
+	t.Cleanup(func() { pluginDownloadURLOverridesParsed = old })
+	var err error
+	pluginDownloadURLOverridesParsed, err = parsePluginDownloadURLOverrides(
+		"^gcp$=https://built.corp/gcp,^azure$=https://built.corp/azure")
+	require.NoError(t, err)
+
+	// Overrides from the environment are matched first, then those from the file, then those the CLI is built with.
+	tests := map[string]string{
+		"aws":     "https://env.corp/aws",
+		"gcp":     "https://config.corp/gcp",
+		"azure":   "https://built.corp/azure",
+		"unknown": "",
+	}
+	v := semver.MustParse("1.0.0")
+	for name, expected := range tests {
+		info, err := ctx.Plugin(PluginInfo{Name: name, Kind: ResourcePlugin, Version: &v})
+		require.NoError(t, err)
+		source, ok := info.GetSource().(*pluginURLSource)
+		if expected == "" {
+			assert.False(t, ok, name)
+			continue
+		}
+		require.True(t, ok, name)
+		assert.Equal(t, expected, source.pluginDownloadURL)
+		assert.Empty(t, info.Mirrors())
+	}
+
+	t.Setenv(PluginDownloadURLOverridesEnvVar, "aws")
+	info, err := ctx.Plugin(PluginInfo{Name: "aws", Kind: ResourcePlugin, Version: &v})
+	require.NoError(t, err)
+	_, _, err = info.GetSource().Download(v, "linux", "amd64", ctx.getHTTPResponse)
+	assert.EqualError(t, err,
+		PluginDownloadURLOverridesEnvVar+`: expected format to be "regexp1=URL1,regexp2=URL2"; got "aws"`)
+}
@@ -0,0 +1,102 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/blang/semver"
+)
+
+// pluginPathKey identifies a lookup of a plugin in the plugin cache: the directories it looked in, and the kind, name
+// and version constraint it looked for.
+type pluginPathKey struct {
+	dirs    string
+	kind    PluginKind
+	name    string
+	version string
+}
+
+// pluginPathEntry is the result of a successful lookup.
+type pluginPathEntry struct {
+	info PluginInfo
+	dir  string
+	path string
+}
+
+// pluginPaths caches the plugins GetPluginPath found in the plugin cache for the lifetime of the process, since large
+// programs make the engine look up the same plugins hundreds of times per update. Installing or deleting a plugin
+// forgets the lookups of plugins with its kind and name.
+var pluginPaths = struct {
+	sync.Mutex
+	found map[pluginPathKey]pluginPathEntry
+}{found: map[pluginPathKey]pluginPathEntry{}}
+
+// pluginPathCacheKey returns the key of a lookup in the context's plugin directories.
+func (ctx *Context) pluginPathCacheKey(kind PluginKind, name string, version *semver.Version) (pluginPathKey, error) {
+	dirs, err := ctx.getPluginDirs()
+	if err != nil {
+		return pluginPathKey{}, err
+	}
+	key := pluginPathKey{
+		dirs: strings.Join(append(dirs, os.Getenv(PluginFallbackDirEnvVar)), string(os.PathListSeparator)),
+		kind: kind,
+		name: name,
+	}
+	if version != nil {
+		key.version = version.String()
+	}
+	if ctx.legacyPluginSearch() {
+		key.version = fmt.Sprintf("legacy:%s", key.version)
+	}
+	return key, nil
+}
+
+// cachedPluginPath returns the result of an earlier lookup, if its plugin is still there.
+func cachedPluginPath(key pluginPathKey) (pluginPathEntry, bool) {
+	pluginPaths.Lock()
+	entry, ok := pluginPaths.found[key]
+	pluginPaths.Unlock()
+	if !ok {
+		return pluginPathEntry{}, false
+	}
+	if _, err := os.Stat(entry.path); err != nil {
+		forgetPluginPaths(key.kind, key.name)
+		return pluginPathEntry{}, false
+	}
+	return entry, true
+}
+
+// cachePluginPath records the result of a successful lookup.
+func cachePluginPath(key pluginPathKey, entry pluginPathEntry) {
+	pluginPaths.Lock()
+	defer pluginPaths.Unlock()
+	pluginPaths.found[key] = entry
+}
+
+// forgetPluginPaths forgets the lookups of plugins with the given kind and name, since installing or deleting one
+// of their versions can change which version they find.
+func forgetPluginPaths(kind PluginKind, name string) {
+	pluginPaths.Lock()
+	defer pluginPaths.Unlock()
+	for key := range pluginPaths.found {
+		if key.kind == kind && key.name == name {
+			delete(pluginPaths.found, key)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPluginPathCache(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ctx := &Context{Home: t.TempDir(), PluginDir: dir}
+	install := func(version string) PluginInfo {
+		v := semver.MustParse(version)
+		info, err := ctx.Plugin(PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &v})
+		require.NoError(t, err)
+		require.NoError(t, info.Install(ioutil.NopCloser(bytes.NewReader(mockPluginTGZ(t))), false))
+		return info
+	}
+	cached := func() bool {
+		key, err := ctx.pluginPathCacheKey(ResourcePlugin, "mock", nil)
+		require.NoError(t, err)
+		_, ok := cachedPluginPath(key)
+		return ok
+	}
+	getPluginDir := func() string {
+		pluginDir, _, err := ctx.GetPluginPath(ResourcePlugin, "mock", nil)
+		require.NoError(t, err)
+		return filepath.Base(pluginDir)
+	}
+
+	install("1.0.0")
+	assert.False(t, cached())
+	assert.Equal(t, "resource-mock-v1.0.0", getPluginDir())
+	assert.True(t, cached())
+	assert.Equal(t, "resource-mock-v1.0.0", getPluginDir())
+
+	// Installing a newer version forgets the lookup.
+	newer := install("1.1.0")
+	assert.False(t, cached())
+	assert.Equal(t, "resource-mock-v1.1.0", getPluginDir())
+
+	// So does deleting it.
+	require.NoError(t, newer.Delete())
+	assert.False(t, cached())
+	assert.Equal(t, "resource-mock-v1.0.0", getPluginDir())
+
+	// Plugins that are removed behind the cache's back aren't found.
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "resource-mock-v1.0.0")))
+	_, _, err := ctx.GetPluginPath(ResourcePlugin, "mock", nil)
+	assert.Error(t, err)
+	assert.False(t, cached())
+}
@@ -0,0 +1,347 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// PluginPeerCacheEnvVar enables the LAN peer cache when set to a truthy value. Downloaded plugin tarballs are kept,
+// and before a plugin is downloaded from the internet, peers on the local network sharing their tarballs with
+// ServePluginPeerCache (`pulumi plugin share`) are asked for it. Only plugins whose source publishes the tarball's
+// digest are fetched from peers, and the digest is verified before the tarball is used. Peers only send tarballs to
+// requests for their digest, and no more than the size the source publishes is read from them.
+const PluginPeerCacheEnvVar = "PULUMI_PLUGIN_PEER_CACHE"
+
+var (
+	// pluginPeerDiscoveryAddr is the UDP address peer cache queries are sent to and served on.
+	pluginPeerDiscoveryAddr = "239.255.80.76:7946"
+	// pluginPeerDiscoveryTimeout is how long to wait for a peer to offer a plugin before downloading it directly.
+	pluginPeerDiscoveryTimeout = 500 * time.Millisecond
+)
+
+// maxPeerTarballSize is the most that's read from a peer for a tarball whose source doesn't publish its size.
+const maxPeerTarballSize = 1 << 30
+
+// pluginPeerQuery asks peers for a plugin tarball.
+type pluginPeerQuery struct {
+	Asset  string `json:"asset"`
+	SHA256 string `json:"sha256"`
+}
+
+// pluginPeerOffer is a peer's answer to a pluginPeerQuery. The tarball is served over HTTP on the given port of the
+// address the offer was sent from.
+type pluginPeerOffer struct {
+	Asset string `json:"asset"`
+	Port  int    `json:"port"`
+}
+
+// checksumSource is implemented by plugin sources that know the digest of a plugin tarball before downloading it.
+type checksumSource interface {
+	// Checksum returns the hex-encoded SHA-256 digest of the tarball of the given version and platform, or "" if it
+	// isn't known, and its size in bytes, or -1 if that isn't known.
+	Checksum(version semver.Version, opSy string, arch string,
+		getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (string, int64, error)
+}
+
+// pluginPeerCacheEnabled returns true if PluginPeerCacheEnvVar is set.
+func pluginPeerCacheEnabled() bool {
+	return cmdutil.IsTruthy(os.Getenv(PluginPeerCacheEnvVar))
+}
+
+// downloadFromPeers asks the peers on the local network for the tarball with the given name, digest and size, which is
+// -1 if it isn't known. The first offered tarball that matches the digest is kept, as though it had been downloaded,
+// and returned. If no peer has it, the returned reader is nil.
+func downloadFromPeers(info PluginInfo, version semver.Version, platform Platform,
+	expected string, size int64) (io.ReadCloser, int64, error) {
+	asset := PluginAssetName(info.Kind, info.Name, version, platform)
+	addr, err := net.ResolveUDPAddr("udp4", pluginPeerDiscoveryAddr)
+	if err != nil {
+		return nil, -1, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer contract.IgnoreClose(conn)
+
+	query, err := json.Marshal(pluginPeerQuery{Asset: asset, SHA256: expected})
+	if err != nil {
+		return nil, -1, err
+	}
+	if _, err := conn.WriteTo(query, addr); err != nil {
+		return nil, -1, err
+	}
+
+	deadline := time.Now().Add(pluginPeerDiscoveryTimeout)
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, -1, err
+	}
+	buf := make([]byte, 1024)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return nil, -1, nil
+			}
+			return nil, -1, err
+		}
+		var offer pluginPeerOffer
+		if err := json.Unmarshal(buf[:n], &offer); err != nil || offer.Asset != asset || offer.Port == 0 {
+			continue
+		}
+
+		peerURL := fmt.Sprintf("http://%s/%s?sha256=%s", net.JoinHostPort(from.IP.String(), fmt.Sprint(offer.Port)),
+			asset, url.QueryEscape(expected))
+		path, err := fetchFromPeer(info, version, platform, peerURL, expected, size, time.Until(deadline))
+		if err != nil {
+			info.logf(5, "could not fetch %s from peer %s: %v", asset, peerURL, err)
+			continue
+		}
+		info.logf(1, "%s downloaded from peer %s", info.Name, peerURL)
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, -1, err
+		}
+		stat, err := f.Stat()
+		if err != nil {
+			contract.IgnoreClose(f)
+			return nil, -1, err
+		}
+		return f, stat.Size(), nil
+	}
+}
+
+// fetchFromPeer downloads the tarball at peerURL, verifies it against the expected digest and size and keeps it,
+// returning the path of the kept tarball. No more than size bytes are read, or maxPeerTarballSize if it's -1, and the
+// download is abandoned once the peer stops sending for longer than the timeout.
+func fetchFromPeer(info PluginInfo, version semver.Version, platform Platform, peerURL, expected string, size int64,
+	timeout time.Duration) (string, error) {
+	// Peers are on the local network, so they get only a little longer to send each part of the tarball than to
+	// answer the query.
+	if timeout < 0 {
+		timeout = 0
+	}
+	req, err := http.NewRequest(http.MethodGet, peerURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req, watch := watchForStalls(req, timeout+time.Second)
+	resp, err := (&http.Client{Transport: &http.Transport{}}).Do(req)
+	if err != nil {
+		watch.stop()
+		return "", watch.err(err)
+	}
+	resp.Body = watch.wrap(resp.Body)
+	defer contract.IgnoreClose(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%d HTTP error fetching plugin from %s", resp.StatusCode, peerURL)
+	}
+	limit := size
+	if limit < 0 {
+		limit = maxPeerTarballSize
+	}
+	if resp.ContentLength > limit {
+		return "", fmt.Errorf("%s is %d bytes, more than the %d expected", peerURL, resp.ContentLength, limit)
+	}
+
+	tarball := newChecksumVerifyingReader(&peerLimitedReader{r: resp.Body, n: limit, name: peerURL}, expected, peerURL)
+	if err := keepPluginArchive(info, version, platform, tarball); err != nil {
+		return "", err
+	}
+	dir, err := pluginArchivesPath(info)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, PluginAssetName(info.Kind, info.Name, version, platform)), nil
+}
+
+// peerLimitedReader reads from a peer, failing once it sends more than n bytes.
+type peerLimitedReader struct {
+	r    io.ReadCloser
+	n    int64
+	name string
+}
+
+func (l *peerLimitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if l.n -= int64(n); l.n < 0 {
+		return 0, fmt.Errorf("%s sent more than the expected size", l.name)
+	}
+	return n, err
+}
+
+func (l *peerLimitedReader) Close() error {
+	return l.r.Close()
+}
+
+// ServePluginPeerCache shares the plugin tarballs kept in the plugin cache with the CLIs on the local network that
+// have PluginPeerCacheEnvVar set, until ctx is canceled. Tarballs are only offered to queries for their exact digest,
+// and only sent to requests for it.
+func ServePluginPeerCache(ctx context.Context) error {
+	dir, err := pluginArchivesPath(PluginInfo{})
+	if err != nil {
+		return err
+	}
+	return servePluginPeerCache(ctx, dir)
+}
+
+func servePluginPeerCache(ctx context.Context, dir string) error {
+	addr, err := net.ResolveUDPAddr("udp4", pluginPeerDiscoveryAddr)
+	if err != nil {
+		return err
+	}
+	var conn *net.UDPConn
+	if addr.IP.IsMulticast() {
+		conn, err = net.ListenMulticastUDP("udp4", nil, addr)
+	} else {
+		conn, err = net.ListenUDP("udp4", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("listening for plugin peer queries on %s: %w", addr, err)
+	}
+	defer contract.IgnoreClose(conn)
+
+	listener, err := net.Listen("tcp4", ":0")
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: peerCacheHandler(dir), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logf(5, nil, "plugin peer cache server stopped: %v", err)
+		}
+	}()
+	defer func() { contract.IgnoreError(server.Close()) }()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	go func() {
+		<-ctx.Done()
+		contract.IgnoreClose(conn)
+	}()
+
+	logf(1, nil, "sharing plugins in %s on port %d", dir, port)
+	buf := make([]byte, 1024)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		var query pluginPeerQuery
+		if err := json.Unmarshal(buf[:n], &query); err != nil || !isPeerCacheAsset(query.Asset) {
+			continue
+		}
+		if !peerCacheHas(dir, query.Asset, query.SHA256) {
+			continue
+		}
+		offer, err := json.Marshal(pluginPeerOffer{Asset: query.Asset, Port: port})
+		if err != nil {
+			return err
+		}
+		if _, err := conn.WriteToUDP(offer, from); err != nil {
+			logf(5, nil, "could not answer plugin peer query from %s: %v", from, err)
+		}
+	}
+}
+
+// peerCacheHandler serves the plugin tarballs in dir, and nothing else. Requests must give the tarball's digest in the
+// `sha256` query parameter, as peers that were offered it do.
+func peerCacheHandler(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		if r.Method != http.MethodGet || !isPeerCacheAsset(name) || !peerCacheHas(dir, name, r.URL.Query().Get("sha256")) {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join(dir, name))
+	})
+}
+
+// isPeerCacheAsset returns true if name is the name of a plugin tarball, not a path.
+func isPeerCacheAsset(name string) bool {
+	return name != "" && filepath.Base(name) == name && !strings.ContainsAny(name, `/\`) &&
+		strings.HasPrefix(name, "pulumi-") && strings.HasSuffix(name, ".tar.gz")
+}
+
+// peerCacheHas returns true if dir holds the named tarball with the given digest. Queries come from anyone on the
+// local network, so the digests of tarballs are only computed once for each version of the file.
+func peerCacheHas(dir, name, expected string) bool {
+	if expected == "" {
+		return false
+	}
+	path := filepath.Join(dir, name)
+	stat, err := os.Stat(path)
+	if err != nil || !stat.Mode().IsRegular() {
+		return false
+	}
+	key := peerDigestKey{path: path, size: stat.Size(), modTime: stat.ModTime().UnixNano()}
+
+	peerDigestsLock.Lock()
+	digest, ok := peerDigests[key]
+	peerDigestsLock.Unlock()
+	if !ok {
+		f, err := os.Open(path)
+		if err != nil {
+			return false
+		}
+		defer contract.IgnoreClose(f)
+		hash := sha256.New()
+		if _, err := io.Copy(hash, f); err != nil {
+			return false
+		}
+		digest = hex.EncodeToString(hash.Sum(nil))
+
+		peerDigestsLock.Lock()
+		peerDigests[key] = digest
+		peerDigestsLock.Unlock()
+	}
+	return digest == strings.ToLower(expected)
+}
+
+// peerDigestKey identifies a version of a tarball in the peer cache, by its path, size and modification time.
+type peerDigestKey struct {
+	path    string
+	size    int64
+	modTime int64
+}
+
+var (
+	peerDigestsLock sync.Mutex
+	peerDigests     = map[peerDigestKey]string{}
+)
@@ -0,0 +1,197 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPeerCacheAsset(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, isPeerCacheAsset("pulumi-resource-aws-v5.0.0-linux-amd64.tar.gz"))
+	assert.False(t, isPeerCacheAsset("../credentials.json"))
+	assert.False(t, isPeerCacheAsset("../pulumi-resource-aws-v5.0.0-linux-amd64.tar.gz"))
+	assert.False(t, isPeerCacheAsset("credentials.json"))
+	assert.False(t, isPeerCacheAsset(""))
+}
+
+func TestPeerCacheHandler(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "pulumi-resource-mock-v1.0.0-linux-amd64.tar.gz"),
+		[]byte("tarball"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "secret.json"), []byte("secret"), 0600))
+
+	server := httptest.NewServer(peerCacheHandler(dir))
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(b)
+	}
+
+	status, body := get("/pulumi-resource-mock-v1.0.0-linux-amd64.tar.gz?sha256=" + sha256Hex("tarball"))
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "tarball", body)
+
+	// Tarballs are only sent to requests for their digest.
+	status, _ = get("/pulumi-resource-mock-v1.0.0-linux-amd64.tar.gz")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = get("/pulumi-resource-mock-v1.0.0-linux-amd64.tar.gz?sha256=" + sha256Hex("something else"))
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, _ = get("/secret.json?sha256=" + sha256Hex("secret"))
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+//nolint:paralleltest // mutates the peer discovery address
+func TestDownloadFromPeers(t *testing.T) {
+	probe, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := probe.LocalAddr().String()
+	require.NoError(t, probe.Close())
+
+	oldAddr, oldTimeout := pluginPeerDiscoveryAddr, pluginPeerDiscoveryTimeout
+	pluginPeerDiscoveryAddr, pluginPeerDiscoveryTimeout = addr, 200*time.Millisecond
+	defer func() { pluginPeerDiscoveryAddr, pluginPeerDiscoveryTimeout = oldAddr, oldTimeout }()
+
+	version := semver.MustParse("1.0.0")
+	platform := Platform{OS: "linux", Arch: "amd64"}
+	shared := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(shared, "pulumi-resource-mock-v1.0.0-linux-amd64.tar.gz"),
+		[]byte(deltaOldTarball), 0600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- servePluginPeerCache(ctx, shared) }()
+
+	info := PluginInfo{Name: "mock", Kind: ResourcePlugin, PluginDir: t.TempDir()}
+
+	// The server may take a moment to start listening, so ask until it answers.
+	var r io.ReadCloser
+	for i := 0; i < 10 && r == nil; i++ {
+		r, _, err = downloadFromPeers(info, version, platform, sha256Hex(deltaOldTarball), -1)
+		require.NoError(t, err)
+	}
+	require.NotNil(t, r)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, deltaOldTarball, string(b))
+
+	// The tarball is kept, so this CLI can pass it on.
+	_, path, ok, err := keptPluginArchive(info, semver.MustParse("2.0.0"), platform)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "pulumi-resource-mock-v1.0.0-linux-amd64.tar.gz", filepath.Base(path))
+
+	// Tarballs with a different digest aren't offered.
+	r, _, err = downloadFromPeers(PluginInfo{Name: "mock", Kind: ResourcePlugin, PluginDir: t.TempDir()},
+		version, platform, sha256Hex("something else"), -1)
+	require.NoError(t, err)
+	assert.Nil(t, r)
+
+	cancel()
+	assert.NoError(t, <-served)
+}
+
+func TestFetchFromPeerLimitsSize(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Stream without a length, as a hostile peer might.
+		w.(http.Flusher).Flush()
+		_, err := w.Write([]byte(deltaOldTarball))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	info := PluginInfo{Name: "mock", Kind: ResourcePlugin, PluginDir: t.TempDir()}
+	platform := Platform{OS: "linux", Arch: "amd64"}
+	peerURL := server.URL + "/pulumi-resource-mock-v1.0.0-linux-amd64.tar.gz"
+	_, err := fetchFromPeer(info, semver.MustParse("1.0.0"), platform, peerURL, sha256Hex(deltaOldTarball),
+		int64(len(deltaOldTarball)-1), time.Second)
+	assert.EqualError(t, err, peerURL+" sent more than the expected size")
+
+	path, err := fetchFromPeer(info, semver.MustParse("1.0.0"), platform, peerURL, sha256Hex(deltaOldTarball),
+		int64(len(deltaOldTarball)), time.Second)
+	require.NoError(t, err)
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, deltaOldTarball, string(b))
+}
+
+func TestPeerCacheHas(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	name := "pulumi-resource-mock-v1.0.0-linux-amd64.tar.gz"
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(deltaOldTarball), 0600))
+
+	assert.False(t, peerCacheHas(dir, name, ""))
+	assert.True(t, peerCacheHas(dir, name, strings.ToUpper(sha256Hex(deltaOldTarball))))
+	assert.False(t, peerCacheHas(dir, name, sha256Hex("something else")))
+	assert.False(t, peerCacheHas(dir, "pulumi-resource-missing-v1.0.0-linux-amd64.tar.gz", sha256Hex(deltaOldTarball)))
+
+	// Tarballs that are replaced are hashed again.
+	require.NoError(t, ioutil.WriteFile(path, []byte("something else"), 0600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Hour)))
+	assert.True(t, peerCacheHas(dir, name, sha256Hex("something else")))
+	assert.False(t, peerCacheHas(dir, name, sha256Hex(deltaOldTarball)))
+}
+
+func TestFetchFromPeerStalled(t *testing.T) {
+	t.Parallel()
+
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Send the headers and part of the tarball, then stall.
+		_, err := w.Write([]byte(deltaOldTarball[:1]))
+		assert.NoError(t, err)
+		w.(http.Flusher).Flush()
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	info := PluginInfo{Name: "mock", Kind: ResourcePlugin, PluginDir: t.TempDir()}
+	platform := Platform{OS: "linux", Arch: "amd64"}
+	peerURL := server.URL + "/pulumi-resource-mock-v1.0.0-linux-amd64.tar.gz"
+	_, err := fetchFromPeer(info, semver.MustParse("1.0.0"), platform, peerURL, sha256Hex(deltaOldTarball),
+		int64(len(deltaOldTarball)), 0)
+	assert.True(t, errors.Is(err, ErrStalled), "%v", err)
+}
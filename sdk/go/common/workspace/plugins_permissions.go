@@ -0,0 +1,114 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// PluginFallbackDirEnvVar is a directory that plugins are installed into when the plugin directory isn't writable,
+// such as when the Pulumi home directory is read-only or owned by another user. Plugins installed into it are found
+// alongside those in the plugin directory.
+const PluginFallbackDirEnvVar = "PULUMI_PLUGIN_FALLBACK_DIR"
+
+// PluginDirNotWritableError is returned when a plugin can't be installed because the directory it's installed into
+// isn't writable.
+type PluginDirNotWritableError struct {
+	// Dir is the directory that isn't writable.
+	Dir string
+	// Err is the underlying file system error.
+	Err error
+}
+
+func (err *PluginDirNotWritableError) Error() string {
+	return fmt.Sprintf("plugin directory %s is not writable: %v; set %s to a writable directory, or %s to a writable "+
+		"directory to install plugins into instead", err.Dir, err.Err, PulumiHomeEnvVar, PluginFallbackDirEnvVar)
+}
+
+func (err *PluginDirNotWritableError) Unwrap() error {
+	return err.Err
+}
+
+// notWritableError returns a *PluginDirNotWritableError if err is a permission error writing to dir, and err
+// otherwise.
+func notWritableError(dir string, err error) error {
+	if err != nil && errors.Is(err, os.ErrPermission) {
+		return &PluginDirNotWritableError{Dir: dir, Err: err}
+	}
+	return err
+}
+
+// checkDirWritable creates dir if it doesn't exist yet, and makes sure files can be created in it.
+func checkDirWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return notWritableError(dir, err)
+	}
+	f, err := ioutil.TempFile(dir, ".write-check-")
+	if err != nil {
+		return notWritableError(dir, err)
+	}
+	contract.IgnoreClose(f)
+	contract.IgnoreError(os.Remove(f.Name()))
+	return nil
+}
+
+// withWritablePluginDir returns info set up to be installed into `PULUMI_PLUGIN_FALLBACK_DIR` if the directory it
+// would otherwise be installed into isn't writable. Plugins with an explicit PluginDir are returned
+// as is.
+func (info PluginInfo) withWritablePluginDir() (PluginInfo, error) {
+	fallback := os.Getenv(PluginFallbackDirEnvVar)
+	if info.PluginDir != "" || fallback == "" {
+		return info, nil
+	}
+	dir, err := GetPluginKindDir(info.Kind)
+	if err != nil {
+		return info, err
+	}
+	err = checkDirWritable(dir)
+	var notWritable *PluginDirNotWritableError
+	if !errors.As(err, &notWritable) || dir == fallback {
+		return info, err
+	}
+
+	info.logf(1, "plugin directory %s is not writable (%v), installing %s into %s instead",
+		dir, notWritable.Err, info, fallback)
+	if err := checkDirWritable(fallback); err != nil {
+		return info, err
+	}
+	info.PluginDir = fallback
+	return info, nil
+}
+
+// getFallbackPlugins returns the plugins installed into `PULUMI_PLUGIN_FALLBACK_DIR`, if it's set and isn't the
+// plugin directory dir.
+func getFallbackPlugins(dir string, skipMetadata bool) ([]PluginInfo, error) {
+	fallback := os.Getenv(PluginFallbackDirEnvVar)
+	if fallback == "" || fallback == dir {
+		return nil, nil
+	}
+	plugins, err := getPlugins(fallback, skipMetadata)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", PluginFallbackDirEnvVar, err)
+	}
+	for i := range plugins {
+		plugins[i].PluginDir = fallback
+	}
+	return plugins, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotWritableError(t *testing.T) {
+	t.Parallel()
+
+	permErr := &os.PathError{Op: "mkdir", Path: "/plugins/resource-mock-v1.0.0", Err: os.ErrPermission}
+	err := notWritableError("/plugins", permErr)
+	var notWritable *PluginDirNotWritableError
+	require.True(t, errors.As(err, &notWritable))
+	assert.Equal(t, "/plugins", notWritable.Dir)
+	assert.True(t, errors.Is(err, os.ErrPermission))
+	assert.Contains(t, err.Error(), "plugin directory /plugins is not writable")
+	assert.Contains(t, err.Error(), PulumiHomeEnvVar)
+	assert.Contains(t, err.Error(), PluginFallbackDirEnvVar)
+
+	help, ok := GetPluginErrorHelp(PluginInfo{}, err)
+	require.True(t, ok)
+	assert.Equal(t, []string{PulumiHomeEnvVar, PluginFallbackDirEnvVar}, help.EnvVars)
+
+	otherErr := &os.PathError{Op: "mkdir", Path: "/plugins", Err: os.ErrExist}
+	assert.Equal(t, otherErr, notWritableError("/plugins", otherErr))
+	assert.Nil(t, notWritableError("/plugins", nil))
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestGetPluginsIncludesFallbackDir(t *testing.T) {
+	home, fallback := t.TempDir(), t.TempDir()
+	t.Setenv(PulumiHomeEnvVar, home)
+	t.Setenv(PluginFallbackDirEnvVar, fallback)
+	require.NoError(t, os.MkdirAll(filepath.Join(home, PluginDir, "resource-aws-v5.0.0"), 0700))
+	require.NoError(t, os.MkdirAll(filepath.Join(fallback, "resource-mock-v1.0.0"), 0700))
+
+	plugins, err := GetPlugins()
+	require.NoError(t, err)
+	require.Len(t, plugins, 2)
+	assert.Equal(t, "aws", plugins[0].Name)
+	assert.Equal(t, "", plugins[0].PluginDir)
+	assert.Equal(t, "mock", plugins[1].Name)
+	assert.Equal(t, fallback, plugins[1].PluginDir)
+
+	dir, err := plugins[1].DirPath()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(fallback, "resource-mock-v1.0.0"), dir)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestInstallFallsBackToWritableDir(t *testing.T) {
+	if runtime.GOOS == windowsGOOS || os.Geteuid() == 0 {
+		t.Skip("directory permissions aren't enforced")
+	}
+
+	home, fallback := t.TempDir(), t.TempDir()
+	t.Setenv(PulumiHomeEnvVar, home)
+	require.NoError(t, os.Chmod(home, 0500))
+	t.Cleanup(func() { _ = os.Chmod(home, 0700) })
+
+	_, info := newMockPlugin(t)
+	info.PluginDir = ""
+
+	// Without a fallback directory, the error says which directory isn't writable.
+	err := info.Install(ioutil.NopCloser(bytes.NewReader(mockPluginTGZ(t))), false)
+	var notWritable *PluginDirNotWritableError
+	require.True(t, errors.As(err, &notWritable), "unexpected error: %v", err)
+	assert.Equal(t, filepath.Join(home, PluginDir), notWritable.Dir)
+
+	t.Setenv(PluginFallbackDirEnvVar, fallback)
+	require.NoError(t, info.Install(ioutil.NopCloser(bytes.NewReader(mockPluginTGZ(t))), false))
+	_, err = os.Stat(filepath.Join(fallback, info.Dir(), "pulumi-resource-mock"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,260 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// PluginPlatformFallbacksEnvVar configures additional platform fallbacks used when downloading plugins. The expected
+// format is `os/arch[/libc]=os/arch[/libc]`, and multiple pairs can be specified separated by commas, e.g.
+// `darwin/arm64=darwin/amd64,linux/arm64=linux/amd64,linux/amd64/musl=linux/amd64`. Configuring fallbacks for a musl
+// platform also has its musl builds tried before their fallbacks, rather than after them.
+const PluginPlatformFallbacksEnvVar = "PULUMI_PLUGIN_PLATFORM_FALLBACKS"
+
+// Platform is an OS/architecture pair, as it appears in plugin asset names, and the C library its binaries link
+// against if it isn't the OS's usual one.
+type Platform struct {
+	OS   string
+	Arch string
+	// Libc is `musl` for Linux distributions whose C library is musl, such as Alpine, and empty for glibc. The OS of
+	// musl builds is `linux-musl` in asset names, e.g. `pulumi-resource-aws-v5.0.0-linux-musl-amd64.tar.gz`.
+	Libc string
+}
+
+func (p Platform) String() string {
+	if p.Libc != "" {
+		return p.OS + "/" + p.Arch + "/" + p.Libc
+	}
+	return p.OS + "/" + p.Arch
+}
+
+// assetOS returns the OS of the platform as it appears in asset names and download URLs.
+func (p Platform) assetOS() string {
+	if p.Libc != "" {
+		return p.OS + "-" + p.Libc
+	}
+	return p.OS
+}
+
+// assetPlatform returns the platform of an asset from the OS and architecture in its name.
+func assetPlatform(assetOS, arch string) Platform {
+	if i := strings.LastIndex(assetOS, "-"); i >= 0 && supportedPluginLibcs[assetOS[i+1:]] {
+		return Platform{OS: assetOS[:i], Arch: arch, Libc: assetOS[i+1:]}
+	}
+	return Platform{OS: assetOS, Arch: arch}
+}
+
+// ParsePlatform parses a platform in the `os/arch[/libc]` format.
+func ParsePlatform(s string) (Platform, error) {
+	split := strings.Split(s, "/")
+	if len(split) < 2 || len(split) > 3 || split[0] == "" || split[1] == "" {
+		return Platform{}, fmt.Errorf("expected platform format to be \"os/arch[/libc]\"; got %q", s)
+	}
+	platform := Platform{OS: split[0], Arch: split[1]}
+	if len(split) == 3 {
+		if !supportedPluginLibcs[split[2]] {
+			return Platform{}, fmt.Errorf("unsupported libc %q in platform %q; expected musl", split[2], s)
+		}
+		platform.Libc = split[2]
+	}
+	return platform, nil
+}
+
+// HostPlatform returns the platform the CLI is running on, whose plugin builds are downloaded.
+func HostPlatform() Platform {
+	platform := Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	// musl-based distributions have musl's dynamic loader instead of glibc's.
+	if runtime.GOOS == "linux" {
+		if loaders, err := filepath.Glob("/lib/ld-musl-*.so.1"); err == nil && len(loaders) > 0 {
+			platform.Libc = "musl"
+		}
+	}
+	return platform
+}
+
+// PlatformFallbackMatrix maps a host platform to the ordered list of platforms whose plugin builds may be used in its
+// place when no build is published for the host platform itself.
+type PlatformFallbackMatrix map[Platform][]Platform
+
+// DefaultPlatformFallbacks is the fallback matrix used when downloading plugins. Additional entries can be supplied at
+// runtime with `PULUMI_PLUGIN_PLATFORM_FALLBACKS`, which take precedence over these defaults.
+var DefaultPlatformFallbacks = PlatformFallbackMatrix{
+	// Apple silicon can run amd64 binaries through Rosetta 2.
+	{OS: "darwin", Arch: "arm64"}: {{OS: "darwin", Arch: "amd64"}},
+	// Windows on ARM can emulate amd64 binaries.
+	{OS: "windows", Arch: "arm64"}: {{OS: "windows", Arch: "amd64"}},
+	// Most plugins are statically linked Go binaries, whose glibc builds also run on musl. These are tried before the
+	// musl builds, which few plugins publish.
+	{OS: "linux", Arch: "amd64", Libc: "musl"}: {{OS: "linux", Arch: "amd64"}},
+	{OS: "linux", Arch: "arm64", Libc: "musl"}: {{OS: "linux", Arch: "arm64"}},
+}
+
+// supportedPluginOSes is the set of operating systems plugins are published for.
+var supportedPluginOSes = map[string]bool{
+	"darwin":  true,
+	"linux":   true,
+	"windows": true,
+}
+
+// supportedPluginArches is the set of architectures plugins are published for.
+var supportedPluginArches = map[string]bool{
+	"amd64":   true,
+	"arm64":   true,
+	"ppc64le": true,
+	"s390x":   true,
+}
+
+// supportedPluginLibcs is the set of C libraries other than glibc that Linux plugins are published for.
+var supportedPluginLibcs = map[string]bool{
+	"musl": true,
+}
+
+// limitedPluginArches is the subset of supportedPluginArches for which many plugins do not publish builds. Download
+// failures on these architectures are reported with an UnsupportedAssetError to explain why the asset may be missing.
+var limitedPluginArches = map[string]bool{
+	"ppc64le": true,
+	"s390x":   true,
+}
+
+// UnsupportedAssetError is returned when a plugin could not be downloaded for a platform that plugins are only
+// sometimes published for, such as IBM Power (ppc64le) and IBM Z (s390x) Linux systems.
+type UnsupportedAssetError struct {
+	// Info contains information about the plugin that could not be downloaded.
+	Info PluginInfo
+	// Platform is the platform that the plugin was requested for.
+	Platform Platform
+	// Err is the underlying download error.
+	Err error
+}
+
+func (err *UnsupportedAssetError) Error() string {
+	return fmt.Sprintf("could not download %s plugin %s for %s: %v; the plugin may not publish builds for %s, "+
+		"contact its maintainers or install a build manually with `pulumi plugin install %s %s --file <path>`",
+		err.Info.Kind, err.Info.String(), err.Platform, err.Err, err.Platform.Arch, err.Info.Kind, err.Info.Name)
+}
+
+func (err *UnsupportedAssetError) Unwrap() error {
+	return err.Err
+}
+
+// Is matches ErrUnsupportedPlatform.
+func (err *UnsupportedAssetError) Is(target error) bool {
+	return target == ErrUnsupportedPlatform
+}
+
+// isSupportedPluginPlatform returns true if plugins may be published for the given platform.
+func isSupportedPluginPlatform(p Platform) bool {
+	return supportedPluginOSes[p.OS] && supportedPluginArches[p.Arch] &&
+		(p.Libc == "" || p.OS == "linux" && supportedPluginLibcs[p.Libc])
+}
+
+// parsePlatformFallbacks parses a fallbacks string with the expected format `os/arch=os/arch,os/arch=os/arch`, where
+// each platform may also name its libc, as in `linux/amd64/musl`.
+// Repeated sources are merged, preserving the order in which their targets appear.
+func parsePlatformFallbacks(fallbacks string) (PlatformFallbackMatrix, error) {
+	result := PlatformFallbackMatrix{}
+	if fallbacks == "" {
+		return result, nil
+	}
+	for _, pair := range strings.Split(fallbacks, ",") {
+		split := strings.Split(pair, "=")
+		if len(split) != 2 {
+			return nil, fmt.Errorf("expected format to be \"os/arch=os/arch,os/arch=os/arch\"; got %q", fallbacks)
+		}
+		from, err := ParsePlatform(split[0])
+		if err != nil {
+			return nil, err
+		}
+		to, err := ParsePlatform(split[1])
+		if err != nil {
+			return nil, err
+		}
+		result[from] = append(result[from], to)
+	}
+	return result, nil
+}
+
+// merge returns a new matrix containing the entries of other followed by the entries of the receiver, so that the
+// fallbacks in other are tried first.
+func (m PlatformFallbackMatrix) merge(other PlatformFallbackMatrix) PlatformFallbackMatrix {
+	result := PlatformFallbackMatrix{}
+	for from, to := range other {
+		result[from] = append(result[from], to...)
+	}
+	for from, to := range m {
+		result[from] = append(result[from], to...)
+	}
+	return result
+}
+
+// Candidates returns the ordered list of platforms to try when downloading a plugin for the given host platform: the
+// host itself (if plugins are published for it), followed by each of its supported fallbacks. Fallbacks are not
+// followed transitively. An error is returned if there are no candidates at all.
+func (m PlatformFallbackMatrix) Candidates(host Platform) ([]Platform, error) {
+	var candidates []Platform
+	seen := map[Platform]bool{}
+	add := func(p Platform) {
+		if !seen[p] && isSupportedPluginPlatform(p) {
+			seen[p] = true
+			candidates = append(candidates, p)
+		}
+	}
+
+	add(host)
+	for _, fallback := range m[host] {
+		add(fallback)
+	}
+
+	if len(candidates) == 0 {
+		if !supportedPluginOSes[host.OS] {
+			return nil, classifyPluginError(ErrUnsupportedPlatform, fmt.Errorf("unsupported plugin OS: %s", host.OS))
+		} else if supportedPluginArches[host.Arch] {
+			return nil, classifyPluginError(ErrUnsupportedPlatform, fmt.Errorf("unsupported plugin libc: %s", host.Libc))
+		}
+		return nil, classifyPluginError(ErrUnsupportedPlatform,
+			fmt.Errorf("unsupported plugin architecture: %s", host.Arch))
+	}
+	return candidates, nil
+}
+
+// getDownloadPlatforms returns the ordered list of platforms to try when downloading a plugin for the given host
+// platform, using the fallbacks in `PULUMI_PLUGIN_PLATFORM_FALLBACKS` and DefaultPlatformFallbacks.
+func getDownloadPlatforms(host Platform) ([]Platform, error) {
+	configured, err := parsePlatformFallbacks(os.Getenv(PluginPlatformFallbacksEnvVar))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", PluginPlatformFallbacksEnvVar, err)
+	}
+	return downloadPlatforms(DefaultPlatformFallbacks.merge(configured), configured, host)
+}
+
+// downloadPlatforms returns the candidates of the fallbacks matrix for host, in the order they should be downloaded.
+// Few plugins publish builds for musl, so on musl hosts trying them first would mean a failed request to every
+// source for nearly every install. Their glibc fallbacks are tried first instead, unless the configured matrix has
+// fallbacks for the host, which says its builds are expected.
+func downloadPlatforms(fallbacks, configured PlatformFallbackMatrix, host Platform) ([]Platform, error) {
+	candidates, err := fallbacks.Candidates(host)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := configured[host]; ok || host.Libc == "" || len(candidates) < 2 || candidates[0] != host {
+		return candidates, nil
+	}
+	return append(candidates[1:], host), nil
+}
@@ -0,0 +1,246 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlatformFallbacks(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input       string
+		expected    PlatformFallbackMatrix
+		expectError bool
+	}{
+		{
+			input:    "",
+			expected: PlatformFallbackMatrix{},
+		},
+		{
+			input: "linux/arm64=linux/amd64",
+			expected: PlatformFallbackMatrix{
+				{OS: "linux", Arch: "arm64"}: {{OS: "linux", Arch: "amd64"}},
+			},
+		},
+		{
+			input: "freebsd/amd64=linux/amd64,freebsd/amd64=darwin/amd64",
+			expected: PlatformFallbackMatrix{
+				{OS: "freebsd", Arch: "amd64"}: {{OS: "linux", Arch: "amd64"}, {OS: "darwin", Arch: "amd64"}},
+			},
+		},
+		{
+			input: "linux/amd64/musl=linux/amd64",
+			expected: PlatformFallbackMatrix{
+				{OS: "linux", Arch: "amd64", Libc: "musl"}: {{OS: "linux", Arch: "amd64"}},
+			},
+		},
+		{
+			input:       "linux/amd64/uclibc=linux/amd64",
+			expectError: true,
+		},
+		{
+			input:       "linux/arm64",
+			expectError: true,
+		},
+		{
+			input:       "linux=linux/amd64",
+			expectError: true,
+		},
+		{
+			input:       "linux/arm64=linux/amd64,",
+			expectError: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.input, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := parsePlatformFallbacks(tt.input)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestPlatformFallbackCandidates(t *testing.T) {
+	t.Parallel()
+
+	matrix := DefaultPlatformFallbacks.merge(PlatformFallbackMatrix{
+		{OS: "freebsd", Arch: "amd64"}: {{OS: "linux", Arch: "amd64"}},
+		{OS: "linux", Arch: "arm64"}:   {{OS: "linux", Arch: "amd64"}},
+	})
+
+	candidates, err := matrix.Candidates(Platform{OS: "darwin", Arch: "arm64"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Platform{{OS: "darwin", Arch: "arm64"}, {OS: "darwin", Arch: "amd64"}}, candidates)
+
+	candidates, err = matrix.Candidates(Platform{OS: "linux", Arch: "amd64"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Platform{{OS: "linux", Arch: "amd64"}}, candidates)
+
+	// An unsupported host can still download plugins if it has a supported fallback.
+	candidates, err = matrix.Candidates(Platform{OS: "freebsd", Arch: "amd64"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Platform{{OS: "linux", Arch: "amd64"}}, candidates)
+
+	_, err = matrix.Candidates(Platform{OS: "plan9", Arch: "amd64"})
+	assert.EqualError(t, err, "unsupported plugin OS: plan9")
+
+	_, err = matrix.Candidates(Platform{OS: "linux", Arch: "mips"})
+	assert.EqualError(t, err, "unsupported plugin architecture: mips")
+
+	// musl hosts fall back to glibc builds.
+	candidates, err = matrix.Candidates(Platform{OS: "linux", Arch: "amd64", Libc: "musl"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Platform{{OS: "linux", Arch: "amd64", Libc: "musl"}, {OS: "linux", Arch: "amd64"}}, candidates)
+	assert.Equal(t, "pulumi-resource-mock-v1.0.0-linux-musl-amd64.tar.gz",
+		PluginAssetName(ResourcePlugin, "mock", semver.MustParse("1.0.0"), candidates[0]))
+	assert.Equal(t, candidates[0], assetPlatform("linux-musl", "amd64"))
+	assert.Equal(t, "linux/amd64/musl", candidates[0].String())
+}
+
+func TestDownloadForPlatformsFallsBack(t *testing.T) {
+	t.Parallel()
+
+	version := semver.MustParse("1.0.0")
+	info := PluginInfo{
+		Name:              "mock",
+		Kind:              ResourcePlugin,
+		Version:           &version,
+		PluginDownloadURL: "https://example.com",
+	}
+
+	var requested []string
+	getHTTPResponse := func(req *http.Request) (io.ReadCloser, int64, error) {
+		requested = append(requested, req.URL.String())
+		switch req.URL.String() {
+		case "https://example.com/pulumi-resource-mock-v1.0.0-darwin-arm64.tar.gz":
+			return nil, -1, &HTTPError{StatusCode: http.StatusNotFound, URL: req.URL.String()}
+		case "https://example.com/pulumi-resource-mock-v1.0.0-windows-arm64.tar.gz":
+			return nil, -1, &HTTPError{StatusCode: http.StatusBadGateway, URL: req.URL.String()}
+		}
+		return newMockReadCloserString("data")
+	}
+
+	platforms := []Platform{{OS: "darwin", Arch: "arm64"}, {OS: "darwin", Arch: "amd64"}}
+	r, _, err := downloadForPlatforms(info, info.GetSource(), version, platforms, getHTTPResponse)
+	require.NoError(t, err)
+	assert.NotNil(t, r)
+	assert.Equal(t, []string{
+		"https://example.com/pulumi-resource-mock-v1.0.0-darwin-arm64.tar.gz",
+		"https://example.com/pulumi-resource-mock-v1.0.0-darwin-amd64.tar.gz",
+	}, requested)
+
+	// Only missing builds fall back, so other failures don't install another platform's build.
+	requested = nil
+	platforms = []Platform{{OS: "windows", Arch: "arm64"}, {OS: "windows", Arch: "amd64"}}
+	_, _, err = downloadForPlatforms(info, info.GetSource(), version, platforms, getHTTPResponse)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"https://example.com/pulumi-resource-mock-v1.0.0-windows-arm64.tar.gz"}, requested)
+}
+
+func TestDownloadPlatformsMusl(t *testing.T) {
+	t.Parallel()
+
+	version := semver.MustParse("1.0.0")
+	info := PluginInfo{
+		Name:              "mock",
+		Kind:              ResourcePlugin,
+		Version:           &version,
+		PluginDownloadURL: "https://example.com",
+	}
+	musl := Platform{OS: "linux", Arch: "amd64", Libc: "musl"}
+
+	var requested []string
+	published := map[string]bool{}
+	getHTTPResponse := func(req *http.Request) (io.ReadCloser, int64, error) {
+		requested = append(requested, req.URL.String())
+		if !published[req.URL.String()] {
+			return nil, -1, &HTTPError{StatusCode: http.StatusNotFound, URL: req.URL.String()}
+		}
+		return newMockReadCloserString("data")
+	}
+
+	// Plugins that only publish glibc builds are downloaded with a single request.
+	published["https://example.com/pulumi-resource-mock-v1.0.0-linux-amd64.tar.gz"] = true
+	platforms, err := downloadPlatforms(DefaultPlatformFallbacks, nil, musl)
+	require.NoError(t, err)
+	assert.Equal(t, []Platform{{OS: "linux", Arch: "amd64"}, musl}, platforms)
+	_, _, err = downloadForPlatforms(info, info.GetSource(), version, platforms, getHTTPResponse)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://example.com/pulumi-resource-mock-v1.0.0-linux-amd64.tar.gz"}, requested)
+
+	// Musl builds are still found when they're the only ones.
+	requested = nil
+	published = map[string]bool{"https://example.com/pulumi-resource-mock-v1.0.0-linux-musl-amd64.tar.gz": true}
+	_, _, err = downloadForPlatforms(info, info.GetSource(), version, platforms, getHTTPResponse)
+	require.NoError(t, err)
+	assert.Len(t, requested, 2)
+
+	// Configuring fallbacks for musl has its builds tried first.
+	configured := PlatformFallbackMatrix{musl: {{OS: "linux", Arch: "amd64"}}}
+	platforms, err = downloadPlatforms(DefaultPlatformFallbacks.merge(configured), configured, musl)
+	require.NoError(t, err)
+	assert.Equal(t, []Platform{musl, {OS: "linux", Arch: "amd64"}}, platforms)
+	requested = nil
+	_, _, err = downloadForPlatforms(info, info.GetSource(), version, platforms, getHTTPResponse)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://example.com/pulumi-resource-mock-v1.0.0-linux-musl-amd64.tar.gz"}, requested)
+
+	// Other hosts are unaffected.
+	platforms, err = downloadPlatforms(DefaultPlatformFallbacks, nil, Platform{OS: "darwin", Arch: "arm64"})
+	require.NoError(t, err)
+	assert.Equal(t, []Platform{{OS: "darwin", Arch: "arm64"}, {OS: "darwin", Arch: "amd64"}}, platforms)
+}
+
+func TestPlatformCandidatesIBMArchitectures(t *testing.T) {
+	t.Parallel()
+
+	for _, arch := range []string{"ppc64le", "s390x"} {
+		candidates, err := DefaultPlatformFallbacks.Candidates(Platform{OS: "linux", Arch: arch})
+		assert.NoError(t, err)
+		assert.Equal(t, []Platform{{OS: "linux", Arch: arch}}, candidates)
+	}
+}
+
+func TestUnsupportedAssetError(t *testing.T) {
+	t.Parallel()
+
+	version := semver.MustParse("1.2.3")
+	cause := errors.New("404 HTTP error fetching plugin")
+	err := &UnsupportedAssetError{
+		Info:     PluginInfo{Name: "aws", Kind: ResourcePlugin, Version: &version},
+		Platform: Platform{OS: "linux", Arch: "s390x"},
+		Err:      cause,
+	}
+	assert.True(t, errors.Is(err, cause))
+	assert.Equal(t, "could not download resource plugin aws-1.2.3 for linux/s390x: 404 HTTP error fetching plugin; "+
+		"the plugin may not publish builds for s390x, contact its maintainers or install a build manually with "+
+		"`pulumi plugin install resource aws --file <path>`", err.Error())
+}
@@ -0,0 +1,137 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/blang/semver"
+)
+
+// PluginPrefetch is a batch of plugins being downloaded and installed in the background.
+type PluginPrefetch struct {
+	done chan struct{}
+	err  error
+}
+
+// Wait waits for the prefetch to finish, returning nil if every plugin was installed, and a *BatchInstallError
+// otherwise.
+func (prefetch *PluginPrefetch) Wait() error {
+	<-prefetch.done
+	return prefetch.err
+}
+
+// PrefetchPlugins starts downloading and installing the given plugins in the background, skipping those that are
+// already installed, so they're ready by the time they're needed. Plugins without a version get the latest one.
+func PrefetchPlugins(plugins []PluginInfo, opts BatchInstallOptions) *PluginPrefetch {
+	prefetch := &PluginPrefetch{done: make(chan struct{})}
+	go func() {
+		defer close(prefetch.done)
+		prefetch.err = InstallPluginBatch(plugins, opts, installMissingPlugin)
+	}()
+	return prefetch
+}
+
+// PrefetchTemplatePlugins starts downloading and installing the plugins the template declares in the background, like
+// PrefetchPlugins. An error is returned if the template's plugins are invalid.
+func PrefetchTemplatePlugins(template Template, opts BatchInstallOptions) (*PluginPrefetch, error) {
+	plugins, err := template.PluginRequirements()
+	if err != nil {
+		return nil, err
+	}
+	return PrefetchPlugins(plugins, opts), nil
+}
+
+// PluginRequirements returns the plugins projects created from the template need.
+func (template Template) PluginRequirements() ([]PluginInfo, error) {
+	var plugins []PluginInfo
+	for i, plugin := range template.Plugins {
+		info, err := plugin.pluginInfo()
+		if err != nil {
+			return nil, fmt.Errorf("template %s: plugins[%d]: %w", template.Name, i, err)
+		}
+		plugins = append(plugins, info)
+	}
+	return plugins, nil
+}
+
+// pluginInfo returns the plugin the declaration requires. The kind defaults to a resource plugin.
+func (plugin ProjectTemplatePlugin) pluginInfo() (PluginInfo, error) {
+	if plugin.Name == "" {
+		return PluginInfo{}, errors.New("name must be set")
+	}
+	kind := ResourcePlugin
+	if plugin.Kind != "" {
+		if !IsPluginKind(plugin.Kind) {
+			return PluginInfo{}, fmt.Errorf("unrecognized plugin kind %q", plugin.Kind)
+		}
+		kind = PluginKind(plugin.Kind)
+	}
+	info := PluginInfo{Name: plugin.Name, Kind: kind, PluginDownloadURL: plugin.Server}
+	if plugin.Version != "" {
+		version, err := semver.ParseTolerant(plugin.Version)
+		if err != nil {
+			if _, rangeErr := semver.ParseRange(plugin.Version); rangeErr != nil {
+				return PluginInfo{}, fmt.Errorf("invalid version %q: %w", plugin.Version, err)
+			}
+			info.VersionRange = plugin.Version
+		} else {
+			info.Version = &version
+		}
+	}
+	return info, nil
+}
+
+// installMissingPlugin installs the plugin, unless a compatible version is already installed. Its download isn't
+// shown, but the output of its dependency install is if it fails.
+func installMissingPlugin(info PluginInfo) error {
+	if opts, ok := getPluginKindOptions(info.Kind); ok && opts.Bundled {
+		// Plugins of bundled kinds, such as language plugins, ship with the CLI.
+		return ErrInstallSkipped
+	}
+	if info.Version == nil && info.VersionRange != "" {
+		resolved, err := info.ResolveVersionRange()
+		if err != nil {
+			return fmt.Errorf("could not resolve version range for plugin %s: %w", info.Name, err)
+		}
+		info = resolved
+	} else if info.Version == nil {
+		version, err := info.GetLatestVersion()
+		if err != nil {
+			return fmt.Errorf("could not get latest version for plugin %s: %w", info.Name, err)
+		}
+		info.Version = version
+	}
+	if has, _ := HasPluginGTE(info); has {
+		info.logf(5, "prefetch: plugin %s is already installed", info)
+		return ErrInstallSkipped
+	}
+
+	info.logf(5, "prefetch: installing plugin %s", info)
+	tarball, _, err := info.Download()
+	if err != nil {
+		return WithPluginErrorHelp(info, fmt.Errorf("downloading %s plugin %s: %w", info.Kind, info, err))
+	}
+	redownload := func() (io.ReadCloser, error) {
+		tarball, _, err := info.Download()
+		return tarball, err
+	}
+	if err := info.InstallWithRedownload(tarball, redownload, false, nil); err != nil {
+		return WithPluginErrorHelp(info, fmt.Errorf("installing %s plugin %s: %w", info.Kind, info, err))
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplatePluginRequirements(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Pulumi.yaml"), []byte(`name: starter
+runtime: nodejs
+template:
+  description: A starter
+  plugins:
+    - name: aws
+      version: v5.1.0
+    - name: acme
+      kind: resource
+      server: https://plugins.acme.com
+    - name: policy
+      kind: analyzer
+      version: 1.0.0
+`), 0600))
+	template, err := LoadTemplate(dir)
+	require.NoError(t, err)
+
+	plugins, err := template.PluginRequirements()
+	require.NoError(t, err)
+	v510, v100 := semver.MustParse("5.1.0"), semver.MustParse("1.0.0")
+	assert.Equal(t, []PluginInfo{
+		{Name: "aws", Kind: ResourcePlugin, Version: &v510},
+		{Name: "acme", Kind: ResourcePlugin, PluginDownloadURL: "https://plugins.acme.com"},
+		{Name: "policy", Kind: AnalyzerPlugin, Version: &v100},
+	}, plugins)
+
+	for _, plugin := range []ProjectTemplatePlugin{
+		{Kind: "resource"},
+		{Name: "aws", Kind: "provider"},
+		{Name: "aws", Version: "latest"},
+	} {
+		_, err := Template{Name: "starter", Plugins: []ProjectTemplatePlugin{plugin}}.PluginRequirements()
+		assert.Error(t, err, "%+v", plugin)
+	}
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestPrefetchPlugins(t *testing.T) {
+	t.Setenv(PulumiHomeEnvVar, t.TempDir())
+
+	tgz := mockPluginTGZ(t)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if !strings.HasPrefix(r.URL.Path, "/pulumi-resource-mock-v1.0.0-") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, err := w.Write(tgz)
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	template := Template{Name: "starter", Plugins: []ProjectTemplatePlugin{
+		{Name: "mock", Version: "1.0.0", Server: server.URL},
+	}}
+	prefetch, err := PrefetchTemplatePlugins(template, BatchInstallOptions{})
+	require.NoError(t, err)
+	require.NoError(t, prefetch.Wait())
+	v := semver.MustParse("1.0.0")
+	assert.True(t, HasPlugin(PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &v}))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// Installed plugins aren't downloaded again.
+	prefetch, err = PrefetchTemplatePlugins(template, BatchInstallOptions{})
+	require.NoError(t, err)
+	require.NoError(t, prefetch.Wait())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	missing := semver.MustParse("2.0.0")
+	err = PrefetchPlugins([]PluginInfo{
+		{Name: "mock", Kind: ResourcePlugin, Version: &missing, PluginDownloadURL: server.URL},
+	}, BatchInstallOptions{}).Wait()
+	var batchErr *BatchInstallError
+	require.True(t, errors.As(err, &batchErr))
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
@@ -0,0 +1,63 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+)
+
+// PluginPrereleaseEnvVar makes every context consider prereleases when it looks up the latest versions of plugins,
+// like Context.Prereleases.
+const PluginPrereleaseEnvVar = "PULUMI_PLUGIN_PRERELEASE"
+
+// prereleases returns true if the context considers prereleases when it looks up the latest versions of plugins.
+func (ctx *Context) prereleases() bool {
+	return ctx.Prereleases || cmdutil.IsTruthy(os.Getenv(PluginPrereleaseEnvVar))
+}
+
+// latestVersion returns the latest of the versions, or nil if there are none. Prereleases are only considered if
+// prereleases is true.
+func latestVersion(versions []semver.Version, prereleases bool) *semver.Version {
+	if !prereleases {
+		return latestReleasedVersion(versions)
+	}
+	var latest *semver.Version
+	for i, version := range versions {
+		if latest == nil || version.GT(*latest) {
+			latest = &versions[i]
+		}
+	}
+	return latest
+}
+
+// getLatestPrerelease returns the latest version the source publishes, whether or not it's a prerelease. Sources that
+// can't list the versions they publish only return their latest release.
+func (ctx *Context) getLatestPrerelease(info PluginInfo, source PluginSource) (*semver.Version, error) {
+	versions, err := listSourceVersions(source, ctx.getHTTPResponse)
+	if err != nil {
+		return nil, err
+	}
+	latest := latestVersion(versions, true)
+	if latest == nil {
+		return nil, classifyPluginError(ErrNotFound, fmt.Errorf("no versions of %s plugin %s found", info.Kind,
+			info.Name))
+	}
+	return latest, nil
+}
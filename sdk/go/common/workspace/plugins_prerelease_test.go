@@ -0,0 +1,80 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestGetLatestPrerelease(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("PULUMI_EXPERIMENTAL", "")
+	t.Setenv(PluginIndexURLsEnvVar, "")
+	t.Setenv(PluginPrereleaseEnvVar, "")
+
+	var requested []string
+	ctx := &Context{Home: t.TempDir(), HTTPClient: &http.Client{Transport: roundTripperFunc(
+		func(req *http.Request) (*http.Response, error) {
+			requested = append(requested, req.URL.Path)
+			body := `{"tag_name": "v1.2.0"}`
+			if req.URL.Path == "/repos/pulumi/pulumi-test/releases" {
+				body = `[{"tag_name": "v1.2.0"}, {"tag_name": "v1.3.0-beta.2"}, {"tag_name": "v1.3.0-beta.10"},
+					{"tag_name": "v2.0.0", "draft": true}]`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       ioutil.NopCloser(strings.NewReader(body)),
+				Request:    req,
+			}, nil
+		})}}
+	info := PluginInfo{Name: "test", Kind: ResourcePlugin}
+
+	// By default, the latest release is used.
+	latest, err := ctx.GetLatestVersion(info)
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.0", latest.String())
+	assert.Equal(t, []string{"/repos/pulumi/pulumi-test/releases/latest"}, requested)
+
+	// With prereleases, the highest version is used, even if it's a prerelease.
+	ctx.Prereleases = true
+	latest, err = ctx.GetLatestVersion(info)
+	require.NoError(t, err)
+	assert.Equal(t, "1.3.0-beta.10", latest.String())
+	assert.Equal(t, "/repos/pulumi/pulumi-test/releases", requested[len(requested)-1])
+
+	// Prereleases are also used to resolve version ranges.
+	info.VersionRange = "<1.3.0"
+	resolved, err := ctx.ResolveVersionRange(info)
+	require.NoError(t, err)
+	assert.Equal(t, "1.3.0-beta.10", resolved.Version.String())
+
+	// The environment variable enables prereleases for every context.
+	info.VersionRange = ""
+	latest, err = (&Context{Home: ctx.Home, HTTPClient: ctx.HTTPClient}).GetLatestVersion(info)
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.0", latest.String())
+	t.Setenv(PluginPrereleaseEnvVar, "true")
+	latest, err = (&Context{Home: ctx.Home, HTTPClient: ctx.HTTPClient}).GetLatestVersion(info)
+	require.NoError(t, err)
+	assert.Equal(t, "1.3.0-beta.10", latest.String())
+}
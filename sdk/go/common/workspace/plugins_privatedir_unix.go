@@ -0,0 +1,43 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package workspace
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkPrivateDir fails unless dir is a directory, and not a link to one, that's owned by the user and only
+// accessible to them.
+func checkPrivateDir(dir string) error {
+	stat, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if sys, ok := stat.Sys().(*syscall.Stat_t); !ok || int(sys.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is not owned by the current user", dir)
+	}
+	if stat.Mode().Perm() != 0700 {
+		return fmt.Errorf("%s is accessible to other users: its mode is %v, not 0700", dir, stat.Mode().Perm())
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+)
+
+// PluginInstallVerboseEnvVar, when truthy, streams the output of plugin dependency installs to stderr as they run,
+// rather than only showing it if the install fails.
+const PluginInstallVerboseEnvVar = "PULUMI_PLUGIN_INSTALL_VERBOSE"
+
+// PluginInstallProgress receives progress from a plugin install.
+type PluginInstallProgress interface {
+	// DependencyOutput is called with each line of output from the package manager installing the plugin's
+	// dependencies.
+	DependencyOutput(info PluginInfo, line string)
+}
+
+// DefaultPluginInstallProgress returns the progress used by PluginInfo.Install: output is written to stderr if
+// `PULUMI_PLUGIN_INSTALL_VERBOSE` is set, and otherwise only shown on failure.
+func DefaultPluginInstallProgress() PluginInstallProgress {
+	if cmdutil.IsTruthy(os.Getenv(PluginInstallVerboseEnvVar)) {
+		return NewWriterPluginInstallProgress(os.Stderr)
+	}
+	return nil
+}
+
+// NewWriterPluginInstallProgress returns a PluginInstallProgress that writes each line of output to w, prefixed with
+// the plugin it came from.
+func NewWriterPluginInstallProgress(w io.Writer) PluginInstallProgress {
+	return &writerPluginInstallProgress{w: w}
+}
+
+type writerPluginInstallProgress struct {
+	w io.Writer
+}
+
+func (p *writerPluginInstallProgress) DependencyOutput(info PluginInfo, line string) {
+	fmt.Fprintf(p.w, "[%s plugin %s] %s\n", info.Kind, info, line)
+}
+
+// progressLineWriter is an io.Writer that splits what's written to it into lines and reports each of them as
+// dependency output. Package managers write to stdout and stderr concurrently, so writes are serialized.
+type progressLineWriter struct {
+	info     PluginInfo
+	progress PluginInstallProgress
+
+	m   sync.Mutex
+	buf []byte
+}
+
+func newProgressLineWriter(info PluginInfo, progress PluginInstallProgress) *progressLineWriter {
+	return &progressLineWriter{info: info, progress: progress}
+}
+
+func (w *progressLineWriter) Write(p []byte) (int, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.progress.DependencyOutput(w.info, strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush reports any trailing output that wasn't terminated by a newline.
+func (w *progressLineWriter) Flush() {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if len(w.buf) > 0 {
+		w.progress.DependencyOutput(w.info, strings.TrimRight(string(w.buf), "\r"))
+		w.buf = nil
+	}
+}
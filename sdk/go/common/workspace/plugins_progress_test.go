@@ -0,0 +1,68 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingInstallProgress struct {
+	lines []string
+}
+
+func (p *recordingInstallProgress) DependencyOutput(info PluginInfo, line string) {
+	p.lines = append(p.lines, line)
+}
+
+func TestProgressLineWriter(t *testing.T) {
+	t.Parallel()
+
+	progress := &recordingInstallProgress{}
+	w := newProgressLineWriter(PluginInfo{Name: "mock", Kind: ResourcePlugin}, progress)
+
+	fmt.Fprint(w, "added 12 ")
+	fmt.Fprint(w, "packages\r\nfound 0 vulnerabilities\nnpm notice")
+	assert.Equal(t, []string{"added 12 packages", "found 0 vulnerabilities"}, progress.lines)
+
+	w.Flush()
+	assert.Equal(t, []string{"added 12 packages", "found 0 vulnerabilities", "npm notice"}, progress.lines)
+
+	w.Flush()
+	assert.Len(t, progress.lines, 3)
+}
+
+func TestWriterPluginInstallProgress(t *testing.T) {
+	t.Parallel()
+
+	version := semver.MustParse("1.2.3")
+	var b bytes.Buffer
+	NewWriterPluginInstallProgress(&b).DependencyOutput(
+		PluginInfo{Name: "aws", Kind: ResourcePlugin, Version: &version}, "added 12 packages")
+	assert.Equal(t, "[resource plugin aws-1.2.3] added 12 packages\n", b.String())
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestDefaultPluginInstallProgress(t *testing.T) {
+	t.Setenv(PluginInstallVerboseEnvVar, "")
+	assert.Nil(t, DefaultPluginInstallProgress())
+
+	t.Setenv(PluginInstallVerboseEnvVar, "true")
+	assert.NotNil(t, DefaultPluginInstallProgress())
+}
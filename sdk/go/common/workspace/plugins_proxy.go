@@ -0,0 +1,211 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+const (
+	// PluginDownloadProxyEnvVar routes all plugin downloads through the current Pulumi backend when set to a truthy
+	// value. The backend fetches plugins on the CLI's behalf, so it can cache them and enforce which plugins may be
+	// installed, and the CLI only ever talks to the backend.
+	PluginDownloadProxyEnvVar = "PULUMI_PLUGIN_DOWNLOAD_PROXY"
+	// PluginDownloadProxyCapability is the capability a backend advertises when it can proxy plugin downloads.
+	PluginDownloadProxyCapability = "PluginDownloadProxy"
+)
+
+// backendCapabilities caches the capabilities advertised by each backend, so they are only fetched once per process.
+var backendCapabilities = struct {
+	sync.Mutex
+	byURL map[string]map[string]bool
+}{byURL: map[string]map[string]bool{}}
+
+// backendProxySource downloads plugins through the `/api/plugins` endpoints of the current Pulumi backend. The
+// plugin's PluginDownloadURL, if any, is passed along so the backend knows where to fetch it from.
+type backendProxySource struct {
+	name              string
+	kind              PluginKind
+	pluginDownloadURL string
+}
+
+func newBackendProxySource(name string, kind PluginKind, pluginDownloadURL string) *backendProxySource {
+	return &backendProxySource{
+		name:              name,
+		kind:              kind,
+		pluginDownloadURL: pluginDownloadURL,
+	}
+}
+
+// pluginDownloadProxyEnabled returns true if plugin downloads should be routed through the current backend.
+func pluginDownloadProxyEnabled() bool {
+	return cmdutil.IsTruthy(os.Getenv(PluginDownloadProxyEnvVar))
+}
+
+// endpoint returns the URL of the given path under the plugin's proxy endpoint, and the token to authenticate with.
+// It fails if the backend doesn't advertise PluginDownloadProxyCapability: downloading the plugin directly instead
+// would defeat the point of the proxy on networks that require it.
+func (source *backendProxySource) endpoint(path string, query url.Values,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (string, string, error) {
+	cloudURL, err := currentCloudURL()
+	if err != nil {
+		return "", "", err
+	}
+	token, err := backendAccessToken(cloudURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	supported, err := backendSupports(cloudURL, token, PluginDownloadProxyCapability, getHTTPResponse)
+	if err != nil {
+		return "", "", fmt.Errorf("checking whether %s can proxy plugin downloads: %w", cloudURL, err)
+	}
+	if !supported {
+		return "", "", fmt.Errorf("%s is set, but the backend %s does not support proxying plugin downloads",
+			PluginDownloadProxyEnvVar, cloudURL)
+	}
+
+	if source.pluginDownloadURL != "" {
+		query.Set("pluginDownloadURL", source.pluginDownloadURL)
+	}
+	endpoint := fmt.Sprintf("%s/api/plugins/%s/%s/%s", strings.TrimSuffix(cloudURL, "/"),
+		url.PathEscape(string(source.kind)), url.PathEscape(source.name), path)
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	return endpoint, token, nil
+}
+
+func (source *backendProxySource) GetLatestVersion(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
+	endpoint, token, err := source.endpoint("latest", url.Values{}, getHTTPResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := buildHTTPRequest(endpoint, token)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, _, err := getHTTPResponse(req)
+	if err != nil {
+		return nil, err
+	}
+	defer contract.IgnoreClose(resp)
+
+	body, err := ioutil.ReadAll(resp)
+	if err != nil {
+		return nil, err
+	}
+	var latest struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(body, &latest); err != nil {
+		return nil, fmt.Errorf("parsing response from %s: %w", endpoint, err)
+	}
+	version, err := semver.ParseTolerant(latest.Version)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugin semver: %w", err)
+	}
+	return &version, nil
+}
+
+func (source *backendProxySource) Download(
+	version semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	query := url.Values{"os": {opSy}, "arch": {arch}}
+	endpoint, token, err := source.endpoint("versions/"+url.PathEscape(version.String())+"/download", query,
+		getHTTPResponse)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	logf(1, sourceLogFields(source.kind, source.name), "%s downloading through backend proxy %s", source.name, endpoint)
+	req, err := buildHTTPRequest(endpoint, token)
+	if err != nil {
+		return nil, -1, err
+	}
+	req.Header.Set("Accept", "application/octet-stream")
+	return getHTTPResponse(req)
+}
+
+// backendSupports returns true if the backend at cloudURL advertises the given capability from its
+// `/api/capabilities` endpoint. Backends without that endpoint support no capabilities.
+func backendSupports(cloudURL, token, capability string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (bool, error) {
+	backendCapabilities.Lock()
+	defer backendCapabilities.Unlock()
+
+	capabilities, ok := backendCapabilities.byURL[cloudURL]
+	if !ok {
+		var err error
+		if capabilities, err = fetchBackendCapabilities(cloudURL, token, getHTTPResponse); err != nil {
+			return false, err
+		}
+		backendCapabilities.byURL[cloudURL] = capabilities
+	}
+	return capabilities[capability], nil
+}
+
+func fetchBackendCapabilities(cloudURL, token string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (map[string]bool, error) {
+	req, err := buildHTTPRequest(strings.TrimSuffix(cloudURL, "/")+"/api/capabilities", token)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, _, err := getHTTPResponse(req)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return map[string]bool{}, nil
+		}
+		return nil, err
+	}
+	defer contract.IgnoreClose(resp)
+
+	body, err := ioutil.ReadAll(resp)
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Capabilities []struct {
+			Capability string `json:"capability"`
+		} `json:"capabilities"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("parsing capabilities of %s: %w", cloudURL, err)
+	}
+
+	capabilities := map[string]bool{}
+	for _, c := range response.Capabilities {
+		capabilities[c.Capability] = true
+	}
+	return capabilities, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestBackendProxySource(t *testing.T) {
+	t.Setenv(PulumiHomeEnvVar, t.TempDir())
+	t.Setenv(PulumiBackendURLEnvVar, "https://proxy.example.com")
+	t.Setenv(PulumiAccessTokenEnvVar, "secret")
+	t.Setenv(PluginDownloadProxyEnvVar, "true")
+
+	info := PluginInfo{Name: "acme", Kind: ResourcePlugin, PluginDownloadURL: "https://cdn.example.com/plugins"}
+	source := info.GetSource()
+	require.IsType(t, &backendProxySource{}, source)
+
+	var authorizations []string
+	serve := serveURLs(map[string]string{
+		"https://proxy.example.com/api/capabilities": `{"capabilities": [{"capability": "PluginDownloadProxy"}]}`,
+		"https://proxy.example.com/api/plugins/resource/acme/latest?" +
+			"pluginDownloadURL=https%3A%2F%2Fcdn.example.com%2Fplugins": `{"version": "v1.2.3"}`,
+		"https://proxy.example.com/api/plugins/resource/acme/versions/1.2.3/download?" +
+			"arch=amd64&os=linux&pluginDownloadURL=https%3A%2F%2Fcdn.example.com%2Fplugins": "tarball",
+	})
+	getHTTPResponse := func(req *http.Request) (io.ReadCloser, int64, error) {
+		authorizations = append(authorizations, req.Header.Get("Authorization"))
+		return serve(req)
+	}
+
+	version, err := source.GetLatestVersion(getHTTPResponse)
+	require.NoError(t, err)
+	assert.Equal(t, semver.MustParse("1.2.3"), *version)
+
+	r, _, err := source.Download(*version, "linux", "amd64", getHTTPResponse)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "tarball", string(b))
+
+	// The capabilities are fetched once, and every request is authenticated with the backend's token.
+	assert.Equal(t, []string{"token secret", "token secret", "token secret"}, authorizations)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestBackendProxySourceUnsupported(t *testing.T) {
+	t.Setenv(PulumiHomeEnvVar, t.TempDir())
+	t.Setenv(PulumiBackendURLEnvVar, "https://old-backend.example.com")
+	t.Setenv(PulumiAccessTokenEnvVar, "secret")
+
+	// Backends that predate the capabilities endpoint don't support proxying, and we refuse to go around them.
+	source := newBackendProxySource("acme", ResourcePlugin, "")
+	_, _, err := source.Download(semver.MustParse("1.0.0"), "linux", "amd64", serveURLs(nil))
+	assert.EqualError(t, err, "PULUMI_PLUGIN_DOWNLOAD_PROXY is set, but the backend "+
+		"https://old-backend.example.com does not support proxying plugin downloads")
+}
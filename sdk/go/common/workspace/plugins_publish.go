@@ -0,0 +1,300 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/archive"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/version"
+)
+
+// PluginAssetName returns the name of the tarball a plugin is published as for the given platform, e.g.
+// `pulumi-resource-aws-v5.0.0-linux-amd64.tar.gz`. This is the name that plugin sources download.
+func PluginAssetName(kind PluginKind, name string, version semver.Version, platform Platform) string {
+	return fmt.Sprintf("pulumi-%s-%s-v%s-%s-%s.tar.gz", kind, name, version.String(), platform.assetOS(), platform.Arch)
+}
+
+// PluginSigner signs published plugin tarballs.
+type PluginSigner interface {
+	// Sign returns a detached signature of data.
+	Sign(data []byte) ([]byte, error)
+}
+
+// PackPluginOptions configures PackPlugin.
+type PackPluginOptions struct {
+	// Platform is the platform the plugin was built for. Defaults to the host platform.
+	Platform Platform
+	// Signer, if set, signs the tarball.
+	Signer PluginSigner
+}
+
+// PluginPackage is a plugin packaged for publishing.
+type PluginPackage struct {
+	// Info describes the packaged plugin.
+	Info PluginInfo
+	// Platform is the platform the plugin was built for.
+	Platform Platform
+	// AssetName is the name the tarball is published as.
+	AssetName string
+	// Tarball is the packaged plugin.
+	Tarball []byte
+	// Checksum is the hex-encoded SHA-256 digest of Tarball.
+	Checksum string
+	// Signature is a detached signature of Tarball, if the package was signed.
+	Signature []byte
+}
+
+// PluginPackageFile is a file that is published as part of a plugin package.
+type PluginPackageFile struct {
+	// Name is the name the file is published as.
+	Name string
+	// Contents are the contents of the file.
+	Contents []byte
+}
+
+// Files returns the files to publish for the package: the tarball, a `.sha256` checksum file in the format written
+// by `sha256sum`, and the `.sig` signature file if the package was signed.
+func (pkg *PluginPackage) Files() []PluginPackageFile {
+	files := []PluginPackageFile{
+		{Name: pkg.AssetName, Contents: pkg.Tarball},
+		{Name: pkg.AssetName + ".sha256", Contents: []byte(fmt.Sprintf("%s  %s\n", pkg.Checksum, pkg.AssetName))},
+	}
+	if pkg.Signature != nil {
+		files = append(files, PluginPackageFile{Name: pkg.AssetName + ".sig", Contents: pkg.Signature})
+	}
+	return files
+}
+
+// PackPlugin packages the built plugin in dir into the tarball layout that Install expects, with the plugin's files
+// at the root of the archive. It validates that the plugin is named correctly and that dir contains either the
+// plugin's executable or a valid PulumiPlugin.yaml.
+func PackPlugin(dir string, info PluginInfo, opts PackPluginOptions) (*PluginPackage, error) {
+	if !IsPluginKind(string(info.Kind)) {
+		return nil, fmt.Errorf("unrecognized plugin kind: %s", info.Kind)
+	}
+	if info.Version == nil {
+		return nil, fmt.Errorf("a version is required to pack %s plugin %s", info.Kind, info.Name)
+	}
+	if !pluginRegexp.MatchString(info.Dir()) {
+		return nil, fmt.Errorf("invalid plugin name %q: names may only contain letters, digits and dashes", info.Name)
+	}
+
+	platform := opts.Platform
+	if platform == (Platform{}) {
+		platform = HostPlatform()
+	}
+	if !isSupportedPluginPlatform(platform) {
+		return nil, fmt.Errorf("unsupported plugin platform: %s", platform)
+	}
+
+	proj, err := LoadPluginProject(filepath.Join(dir, "PulumiPlugin.yaml"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("loading PulumiPlugin.yaml: %w", err)
+	}
+	prefix := info.FilePrefix()
+	if _, ok := findPluginExecutable(dir, prefix, candidateExtensionsFor(platform.OS)); !ok && proj == nil {
+		return nil, fmt.Errorf("%s contains neither a PulumiPlugin.yaml nor a %s executable for %s; expected one of %s",
+			dir, prefix, platform, strings.Join(candidatePluginFilesFor(prefix, platform.OS), ", "))
+	}
+	if proj != nil {
+		if missing := missingPluginBinaries(proj, dir, candidateExtensionsFor(platform.OS)); len(missing) > 0 {
+			return nil, fmt.Errorf("%s is missing the binaries %s declared by its PulumiPlugin.yaml for %s",
+				dir, strings.Join(missing, ", "), platform)
+		}
+	}
+
+	tarball, err := archive.TGZ(dir, "", true /*useDefaultExcludes*/)
+	if err != nil {
+		return nil, fmt.Errorf("packing %s: %w", dir, err)
+	}
+	sum := sha256.Sum256(tarball)
+
+	pkg := &PluginPackage{
+		Info:      info,
+		Platform:  platform,
+		AssetName: PluginAssetName(info.Kind, info.Name, *info.Version, platform),
+		Tarball:   tarball,
+		Checksum:  hex.EncodeToString(sum[:]),
+	}
+	if opts.Signer != nil {
+		if pkg.Signature, err = opts.Signer.Sign(tarball); err != nil {
+			return nil, fmt.Errorf("signing plugin: %w", err)
+		}
+	}
+	return pkg, nil
+}
+
+// PluginPublisher uploads packaged plugins to where plugin sources can download them.
+type PluginPublisher interface {
+	// Publish uploads all of the files of the package.
+	Publish(pkg *PluginPackage) error
+}
+
+// githubReleasePublisher uploads plugin packages as assets of a GitHub release, where githubSource downloads them.
+type githubReleasePublisher struct {
+	apiURL     string
+	repository string
+	token      string
+	client     *http.Client
+}
+
+// NewGitHubReleasePublisher returns a PluginPublisher that uploads packages to the release tagged with the package's
+// version (`v<version>`) in the `owner/name` GitHub repository, which must already exist. The token must be allowed
+// to write to the repository's releases.
+func NewGitHubReleasePublisher(repository, token string) PluginPublisher {
+	return &githubReleasePublisher{
+		apiURL:     "https://api.github.com",
+		repository: repository,
+		token:      token,
+		client:     http.DefaultClient,
+	}
+}
+
+func (p *githubReleasePublisher) Publish(pkg *PluginPackage) error {
+	tag := "v" + pkg.Info.Version.String()
+	releaseURL := fmt.Sprintf("%s/repos/%s/releases/tags/%s", p.apiURL, p.repository, tag)
+	req, err := newPublishRequest("GET", releaseURL, p.token, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	body, err := doPublishRequest(p.client, req)
+	if err != nil {
+		return fmt.Errorf("looking up release %s of %s: %w", tag, p.repository, err)
+	}
+
+	release := struct {
+		UploadURL string `json:"upload_url"`
+	}{}
+	if err := json.Unmarshal(body, &release); err != nil {
+		return fmt.Errorf("parsing release %s of %s: %w", tag, p.repository, err)
+	}
+	// The upload URL is a URI template, e.g. `https://uploads.github.com/repos/o/r/releases/1/assets{?name,label}`.
+	uploadURL := release.UploadURL
+	if i := strings.Index(uploadURL, "{"); i >= 0 {
+		uploadURL = uploadURL[:i]
+	}
+	if uploadURL == "" {
+		return fmt.Errorf("release %s of %s has no upload URL", tag, p.repository)
+	}
+
+	for _, file := range pkg.Files() {
+		logf(5, nil, "uploading %s to release %s of %s", file.Name, tag, p.repository)
+		req, err := newPublishRequest("POST", uploadURL+"?name="+url.QueryEscape(file.Name), p.token, file.Contents)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		if _, err := doPublishRequest(p.client, req); err != nil {
+			return fmt.Errorf("uploading %s: %w", file.Name, err)
+		}
+	}
+	return nil
+}
+
+// pluginServerPublisher uploads plugin packages to a server that hosts them for a PluginDownloadURL.
+type pluginServerPublisher struct {
+	serverURL string
+	token     string
+	client    *http.Client
+}
+
+// NewPluginServerPublisher returns a PluginPublisher that uploads each file of a package with a PUT to
+// `<serverURL>/<file name>`, the layout plugins with a PluginDownloadURL are downloaded from. serverURL may contain
+// the same placeholders as a PluginDownloadURL, and name the tarball like one. If token is set, it is sent as a bearer
+// token.
+func NewPluginServerPublisher(serverURL, token string) PluginPublisher {
+	return &pluginServerPublisher{
+		serverURL: serverURL,
+		token:     token,
+		client:    http.DefaultClient,
+	}
+}
+
+func (p *pluginServerPublisher) Publish(pkg *PluginPackage) error {
+	serverURL, tarball := splitPluginURL(p.serverURL, pkg.Info.Kind, pkg.Info.Name, *pkg.Info.Version,
+		pkg.Platform.OS, pkg.Platform.Arch)
+
+	for _, file := range pkg.Files() {
+		// URLs that name the tarball name the files published alongside it after it too.
+		name := tarball + strings.TrimPrefix(file.Name, pkg.AssetName)
+		logf(5, nil, "uploading %s to %s", name, serverURL)
+		req, err := newPublishRequest("PUT", serverURL+"/"+url.PathEscape(name), "", file.Contents)
+		if err != nil {
+			return err
+		}
+		if p.token != "" {
+			req.Header.Set("Authorization", "Bearer "+p.token)
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		if _, err := doPublishRequest(p.client, req); err != nil {
+			return fmt.Errorf("uploading %s: %w", file.Name, err)
+		}
+	}
+	return nil
+}
+
+// newPublishRequest builds a request to a plugin publishing endpoint, authenticated with a GitHub-style token if one
+// is given.
+func newPublishRequest(method, endpoint, token string, body []byte) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, endpoint, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("pulumi-cli/1 (%s; %s)", version.Version, runtime.GOOS))
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("token %s", token))
+	}
+	return req, nil
+}
+
+// doPublishRequest sends req and returns the response body, or an error if the response status isn't a success.
+func doPublishRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer contract.IgnoreClose(resp.Body)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%d HTTP error from %s %s: %s", resp.StatusCode, req.Method, req.URL,
+			strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/archive"
+)
+
+type reverseSigner struct{}
+
+func (reverseSigner) Sign(data []byte) ([]byte, error) {
+	sig := make([]byte, len(data))
+	for i, b := range data {
+		sig[len(data)-1-i] = b
+	}
+	return sig, nil
+}
+
+func newTestPluginDir(t *testing.T) string {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "pulumi-resource-mock"), []byte("#!/bin/sh\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "LICENSE"), []byte("Apache-2.0"), 0600))
+	return dir
+}
+
+func TestPackPlugin(t *testing.T) {
+	t.Parallel()
+
+	version := semver.MustParse("1.2.3")
+	info := PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &version}
+	linux := Platform{OS: "linux", Arch: "amd64"}
+
+	pkg, err := PackPlugin(newTestPluginDir(t), info, PackPluginOptions{Platform: linux, Signer: reverseSigner{}})
+	require.NoError(t, err)
+	assert.Equal(t, "pulumi-resource-mock-v1.2.3-linux-amd64.tar.gz", pkg.AssetName)
+
+	sum := sha256.Sum256(pkg.Tarball)
+	assert.Equal(t, hex.EncodeToString(sum[:]), pkg.Checksum)
+
+	// The plugin's files are at the root of the tarball.
+	extracted := t.TempDir()
+	require.NoError(t, archive.ExtractTGZ(bytes.NewReader(pkg.Tarball), extracted))
+	assert.FileExists(t, filepath.Join(extracted, "pulumi-resource-mock"))
+	assert.FileExists(t, filepath.Join(extracted, "LICENSE"))
+
+	files := pkg.Files()
+	require.Len(t, files, 3)
+	assert.Equal(t, "pulumi-resource-mock-v1.2.3-linux-amd64.tar.gz.sha256", files[1].Name)
+	assert.Equal(t, pkg.Checksum+"  pulumi-resource-mock-v1.2.3-linux-amd64.tar.gz\n", string(files[1].Contents))
+	assert.Equal(t, "pulumi-resource-mock-v1.2.3-linux-amd64.tar.gz.sig", files[2].Name)
+}
+
+func TestPackPluginValidation(t *testing.T) {
+	t.Parallel()
+
+	version := semver.MustParse("1.2.3")
+	linux := Platform{OS: "linux", Arch: "amd64"}
+	dir := newTestPluginDir(t)
+
+	tests := []struct {
+		name     string
+		info     PluginInfo
+		platform Platform
+		expected string
+	}{
+		{
+			name:     "invalid kind",
+			info:     PluginInfo{Name: "mock", Kind: "widget", Version: &version},
+			platform: linux,
+			expected: "unrecognized plugin kind: widget",
+		},
+		{
+			name:     "missing version",
+			info:     PluginInfo{Name: "mock", Kind: ResourcePlugin},
+			platform: linux,
+			expected: "a version is required to pack resource plugin mock",
+		},
+		{
+			name:     "invalid name",
+			info:     PluginInfo{Name: "mock_provider", Kind: ResourcePlugin, Version: &version},
+			platform: linux,
+			expected: `invalid plugin name "mock_provider": names may only contain letters, digits and dashes`,
+		},
+		{
+			name:     "unsupported platform",
+			info:     PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &version},
+			platform: Platform{OS: "plan9", Arch: "amd64"},
+			expected: "unsupported plugin platform: plan9/amd64",
+		},
+		{
+			name:     "missing executable",
+			info:     PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &version},
+			platform: Platform{OS: "windows", Arch: "amd64"},
+			expected: fmt.Sprintf("%s contains neither a PulumiPlugin.yaml nor a pulumi-resource-mock executable for "+
+				"windows/amd64; expected one of pulumi-resource-mock.exe, pulumi-resource-mock.cmd, "+
+				"pulumi-resource-mock.bat, pulumi-resource-mock.ps1", dir),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := PackPlugin(dir, tt.info, PackPluginOptions{Platform: tt.platform})
+			assert.EqualError(t, err, tt.expected)
+		})
+	}
+}
+
+// recordingUploadServer records the files uploaded to it.
+type recordingUploadServer struct {
+	m       sync.Mutex
+	uploads map[string][]byte
+	auth    []string
+}
+
+func (s *recordingUploadServer) record(name string, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.uploads == nil {
+		s.uploads = map[string][]byte{}
+	}
+	s.uploads[name] = body
+	s.auth = append(s.auth, r.Header.Get("Authorization"))
+}
+
+func testPluginPackage(t *testing.T) *PluginPackage {
+	version := semver.MustParse("1.2.3")
+	pkg, err := PackPlugin(newTestPluginDir(t), PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &version},
+		PackPluginOptions{Platform: Platform{OS: "linux", Arch: "amd64"}})
+	require.NoError(t, err)
+	return pkg
+}
+
+func TestGitHubReleasePublisher(t *testing.T) {
+	t.Parallel()
+
+	uploads := &recordingUploadServer{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/repos/acme/pulumi-mock/releases/tags/v1.2.3":
+			fmt.Fprintf(w, `{"upload_url": "%s/uploads/42/assets{?name,label}"}`, server.URL)
+		case r.Method == "POST" && r.URL.Path == "/uploads/42/assets":
+			uploads.record(r.URL.Query().Get("name"), r)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	pkg := testPluginPackage(t)
+	publisher := &githubReleasePublisher{
+		apiURL:     server.URL,
+		repository: "acme/pulumi-mock",
+		token:      "secret",
+		client:     server.Client(),
+	}
+	require.NoError(t, publisher.Publish(pkg))
+	assert.Equal(t, pkg.Tarball, uploads.uploads[pkg.AssetName])
+	assert.Contains(t, uploads.uploads, pkg.AssetName+".sha256")
+	assert.Equal(t, []string{"token secret", "token secret"}, uploads.auth)
+
+	publisher.repository = "acme/pulumi-missing"
+	err := publisher.Publish(pkg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "looking up release v1.2.3 of acme/pulumi-missing: 404 HTTP error")
+}
+
+func TestPluginServerPublisher(t *testing.T) {
+	t.Parallel()
+
+	uploads := &recordingUploadServer{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uploads.record(r.URL.Path, r)
+	}))
+	defer server.Close()
+
+	pkg := testPluginPackage(t)
+	publisher := &pluginServerPublisher{
+		serverURL: server.URL + "/mock/${VERSION}/",
+		token:     "secret",
+		client:    server.Client(),
+	}
+	require.NoError(t, publisher.Publish(pkg))
+	assert.Equal(t, pkg.Tarball, uploads.uploads["/mock/1.2.3/"+pkg.AssetName])
+	assert.Contains(t, uploads.uploads, "/mock/1.2.3/"+pkg.AssetName+".sha256")
+	assert.Equal(t, []string{"Bearer secret", "Bearer secret"}, uploads.auth)
+}
@@ -0,0 +1,74 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// maxPluginRedownloads bounds how many times InstallWithRedownload downloads a plugin again after its tarball turns
+// out to be corrupt.
+const maxPluginRedownloads = 2
+
+// InstallWithRedownload installs the plugin's tarball like InstallWithProgress. If the tarball turns out to be corrupt,
+// most often because a CDN or proxy truncated the download, any kept copy of it is discarded and the plugin is
+// downloaded again with redownload and reinstalled, up to maxPluginRedownloads times. If redownload is nil, the
+// tarball is only installed once.
+func (info PluginInfo) InstallWithRedownload(tgz io.ReadCloser, redownload func() (io.ReadCloser, error),
+	reinstall bool, progress PluginInstallProgress) error {
+	for attempt := 0; ; attempt++ {
+		err := info.InstallWithProgress(tgz, reinstall, progress)
+		if err == nil || redownload == nil || attempt == maxPluginRedownloads || !errors.Is(err, ErrCorruptArchive) {
+			return err
+		}
+
+		info.logf(1, "tarball of plugin %s is corrupt, downloading it again: %v", info, err)
+		if info.Version != nil {
+			discardKeptPluginArchives(info, *info.Version)
+		}
+		if tgz, err = redownload(); err != nil {
+			return err
+		}
+	}
+}
+
+// discardKeptPluginArchives removes the tarballs kept for the given version of the plugin, on any platform, so a
+// corrupt copy isn't used again.
+func discardKeptPluginArchives(info PluginInfo, version semver.Version) {
+	dir, err := pluginArchivesPath(info)
+	if err != nil {
+		return
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	prefix := "pulumi-" + string(info.Kind) + "-" + info.Name + "-v" + version.String() + "-"
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ".tar.gz") {
+			info.logf(5, "discarding kept tarball %s", name)
+			contract.IgnoreError(os.Remove(filepath.Join(dir, name)))
+		}
+	}
+}
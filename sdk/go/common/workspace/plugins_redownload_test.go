@@ -0,0 +1,80 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// truncatedTGZ returns a plugin tarball that has been cut short, as by a CDN or proxy dropping the connection.
+func truncatedTGZ(t *testing.T) io.ReadCloser {
+	tgz := mockPluginTGZ(t)
+	return ioutil.NopCloser(bytes.NewReader(tgz[:len(tgz)/2]))
+}
+
+func TestInstallWithRedownload(t *testing.T) {
+	t.Parallel()
+
+	dir, plugin := newMockPlugin(t)
+
+	// Keep a copy of the corrupt tarball, as delta updates do, to check it's discarded.
+	archives := filepath.Join(dir, pluginArchivesDir)
+	require.NoError(t, os.MkdirAll(archives, 0700))
+	kept := filepath.Join(archives, PluginAssetName(plugin.Kind, plugin.Name, *plugin.Version,
+		Platform{OS: "linux", Arch: "amd64"}))
+	require.NoError(t, ioutil.WriteFile(kept, []byte("corrupt"), 0600))
+
+	downloads := 0
+	redownload := func() (io.ReadCloser, error) {
+		downloads++
+		return ioutil.NopCloser(bytes.NewReader(mockPluginTGZ(t))), nil
+	}
+	err := plugin.InstallWithRedownload(truncatedTGZ(t), redownload, false, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, downloads)
+	assert.True(t, HasPlugin(plugin))
+	_, err = os.Stat(kept)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestInstallWithRedownloadGivesUp(t *testing.T) {
+	t.Parallel()
+
+	_, plugin := newMockPlugin(t)
+
+	downloads := 0
+	redownload := func() (io.ReadCloser, error) {
+		downloads++
+		return truncatedTGZ(t), nil
+	}
+	err := plugin.InstallWithRedownload(truncatedTGZ(t), redownload, false, nil)
+	assert.True(t, errors.Is(err, ErrCorruptArchive), "expected %v to be ErrCorruptArchive", err)
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	assert.Equal(t, maxPluginRedownloads, downloads)
+	assert.False(t, HasPlugin(plugin))
+
+	// Without a way to download it again, a corrupt tarball is only installed once.
+	err = plugin.InstallWithRedownload(truncatedTGZ(t), nil, false, nil)
+	assert.True(t, errors.Is(err, ErrCorruptArchive))
+}
@@ -0,0 +1,138 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// ReleaseNotesProgress can be implemented by a PluginInstallProgress to receive the release notes of each plugin
+// version it installs. Fetching release notes takes an extra request, so it is only done for progress that asks.
+type ReleaseNotesProgress interface {
+	// ReleaseNotes is called with the release notes, usually markdown, of a plugin version that was installed.
+	ReleaseNotes(info PluginInfo, notes string)
+}
+
+// releaseNotesSource is implemented by plugin sources that publish release notes.
+type releaseNotesSource interface {
+	// GetReleaseNotes returns the release notes for the given version, or "" if there are none.
+	GetReleaseNotes(version semver.Version,
+		getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (string, error)
+}
+
+// GetReleaseNotes returns the release notes of this plugin's version from its source, or "" if the source doesn't
+// publish release notes.
+func (info PluginInfo) GetReleaseNotes() (string, error) {
+	return (&Context{}).GetReleaseNotes(info)
+}
+
+// GetReleaseNotes returns the plugin's release notes like PluginInfo.GetReleaseNotes, sending requests with the
+// context's HTTP client.
+func (ctx *Context) GetReleaseNotes(info PluginInfo) (string, error) {
+	if info.Version == nil {
+		return "", fmt.Errorf("unknown version for plugin %s", info.Name)
+	}
+	source, ok := ctx.pluginSource(info, info.Mirrors()).(releaseNotesSource)
+	if !ok {
+		return "", nil
+	}
+	return source.GetReleaseNotes(*info.Version, ctx.getHTTPResponse)
+}
+
+// reportReleaseNotes sends the release notes of an installed plugin to progress, if it wants them. Release notes are
+// informational, so failures to fetch them are only logged.
+func reportReleaseNotes(info PluginInfo, progress PluginInstallProgress) {
+	notesProgress, ok := progress.(ReleaseNotesProgress)
+	if !ok || info.Version == nil {
+		return
+	}
+	notes, err := info.GetReleaseNotes()
+	if err != nil {
+		info.logf(5, "could not get release notes for %s plugin %s: %v", info.Kind, info, err)
+		return
+	}
+	if notes != "" {
+		notesProgress.ReleaseNotes(info, notes)
+	}
+}
+
+func (source *githubSource) GetReleaseNotes(version semver.Version,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (string, error) {
+	releaseURL := fmt.Sprintf(
+		"https://api.github.com/repos/%s/pulumi-%s/releases/tags/v%s",
+		source.organization, source.name, version.String())
+	logf(9, sourceLogFields(source.kind, source.name), "plugin GitHub releases url: %s", releaseURL)
+	req, err := buildHTTPRequest(releaseURL, source.token)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, _, err := getHTTPResponse(req)
+	if err != nil {
+		return "", err
+	}
+	defer contract.IgnoreClose(resp)
+
+	jsonBody, err := ioutil.ReadAll(resp)
+	if err != nil {
+		return "", err
+	}
+	release := struct {
+		Body string `json:"body"`
+	}{}
+	if err := json.Unmarshal(jsonBody, &release); err != nil {
+		return "", err
+	}
+	return release.Body, nil
+}
+
+func (source *fallbackSource) GetReleaseNotes(version semver.Version,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (string, error) {
+	// Release notes come from the first of the default sources that publishes them.
+	var err error
+	for _, entry := range source.entries() {
+		if notes, ok := entry.source.(releaseNotesSource); ok {
+			var releaseNotes string
+			if releaseNotes, err = notes.GetReleaseNotes(version, getHTTPResponse); err == nil {
+				return releaseNotes, nil
+			}
+		}
+	}
+	return "", err
+}
+
+func (source *pluginIndexSource) GetReleaseNotes(version semver.Version,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (string, error) {
+	_, indexes := source.indexes(getHTTPResponse)
+	for _, index := range indexes {
+		for _, v := range index.Versions {
+			if parsed, err := semver.ParseTolerant(v.Version); err == nil && parsed.Equals(version) {
+				return v.ReleaseNotes, nil
+			}
+		}
+	}
+	if next, ok := source.next.(releaseNotesSource); ok {
+		return next.GetReleaseNotes(version, getHTTPResponse)
+	}
+	return "", nil
+}
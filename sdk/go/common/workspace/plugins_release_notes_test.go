@@ -0,0 +1,60 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitHubReleaseNotes(t *testing.T) {
+	t.Parallel()
+
+	getHTTPResponse := serveURLs(map[string]string{
+		"https://api.github.com/repos/pulumi/pulumi-mock/releases/tags/v1.2.3": `{"tag_name": "v1.2.3",
+			"body": "## Bug Fixes\n\n- Fixed everything"}`,
+	})
+
+	source := &githubSource{organization: "pulumi", name: "mock", kind: ResourcePlugin}
+	notes, err := source.GetReleaseNotes(semver.MustParse("1.2.3"), getHTTPResponse)
+	require.NoError(t, err)
+	assert.Equal(t, "## Bug Fixes\n\n- Fixed everything", notes)
+
+	_, err = source.GetReleaseNotes(semver.MustParse("9.9.9"), getHTTPResponse)
+	assert.Error(t, err)
+}
+
+func TestPluginIndexReleaseNotes(t *testing.T) {
+	t.Parallel()
+
+	getHTTPResponse := serveURLs(map[string]string{
+		"https://index.example.com/resource/mock.json": `{"versions": [
+			{"version": "1.0.0", "releaseNotes": "Initial release"}
+		]}`,
+	})
+
+	source := newPluginIndexSource([]string{"https://index.example.com"}, "mock", ResourcePlugin, &nextSource{})
+	notes, err := source.GetReleaseNotes(semver.MustParse("1.0.0"), getHTTPResponse)
+	require.NoError(t, err)
+	assert.Equal(t, "Initial release", notes)
+
+	// Versions that aren't listed are looked up in the next source, which here doesn't publish release notes.
+	notes, err = source.GetReleaseNotes(semver.MustParse("2.0.0"), getHTTPResponse)
+	require.NoError(t, err)
+	assert.Equal(t, "", notes)
+}
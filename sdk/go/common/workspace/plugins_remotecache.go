@@ -0,0 +1,315 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/version"
+)
+
+// PluginRemoteCacheEnvVar is the URL of an organization's plugin cache, taking precedence over the `remoteCache`
+// setting of PluginConfigFile. Plugins are looked for in it before they're downloaded from their source, and those
+// that aren't there yet are uploaded to it once they've been downloaded and verified, so each plugin is downloaded
+// from its source once for everyone sharing the cache.
+//
+// The cache is addressed by plugin, at `<url>/<kind>/<name>/v<version>/<os>-<arch>.tar.gz`:
+//
+//   - GET responds with the plugin's tarball, or 404 if the cache doesn't have it.
+//   - PUT uploads the tarball, with its hex-encoded SHA-256 digest in PluginRemoteCacheDigestHeader. The cache should
+//     verify the digest, and keep the first tarball uploaded for each plugin.
+//
+// Tarballs from the cache are verified like those from the plugin's source: against the digest the source publishes,
+// if it publishes one, and the digest recorded when the plugin was first installed. Requests carry the credentials
+// the `auth` setting of PluginConfigFile configures for the cache's host. NewPluginRemoteCacheHandler serves the
+// protocol from a directory.
+const PluginRemoteCacheEnvVar = "PULUMI_PLUGIN_REMOTE_CACHE"
+
+// PluginRemoteCacheDigestHeader is the header carrying the digest of the tarballs uploaded to the remote cache.
+const PluginRemoteCacheDigestHeader = "X-Pulumi-Plugin-SHA256"
+
+// getPluginRemoteCache returns the URL of the remote cache from PluginRemoteCacheEnvVar, or PluginConfigFile if it
+// isn't set, or "" if there is none.
+func (ctx *Context) getPluginRemoteCache() (string, error) {
+	if env := os.Getenv(PluginRemoteCacheEnvVar); env != "" {
+		if err := validatePluginRemoteCache(env); err != nil {
+			return "", fmt.Errorf("%s: %w", PluginRemoteCacheEnvVar, err)
+		}
+		return env, nil
+	}
+	config, err := ctx.GetPluginConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.RemoteCache, nil
+}
+
+func validatePluginRemoteCache(s string) error {
+	if u, err := url.Parse(s); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute URL", s)
+	}
+	return nil
+}
+
+// pluginRemoteCacheURL returns the URL of the given plugin's tarball in the remote cache at cache.
+func pluginRemoteCacheURL(cache string, info PluginInfo, version semver.Version, platform Platform) string {
+	return fmt.Sprintf("%s/%s/%s/v%s/%s-%s.tar.gz", strings.TrimSuffix(cache, "/"), info.Kind,
+		url.PathEscape(info.Name), version, platform.assetOS(), platform.Arch)
+}
+
+// newPluginRemoteCacheRequest returns a request to the remote cache, with the credentials configured for its host.
+func newPluginRemoteCacheRequest(method, endpoint string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("pulumi-cli/1 (%s; %s)", version.Version, runtime.GOOS))
+	if err := applyPluginAuth(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// downloadFromRemoteCache fetches the plugin's tarball from the remote cache. If the cache doesn't have it, the
+// returned reader is nil. The tarball is verified against expected, if it's set.
+func downloadFromRemoteCache(info PluginInfo, cache string, version semver.Version, platform Platform, expected string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	endpoint := pluginRemoteCacheURL(cache, info, version, platform)
+	req, err := newPluginRemoteCacheRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	resp, length, err := getHTTPResponse(req)
+	if err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+			return nil, -1, nil
+		}
+		return nil, -1, err
+	}
+	info.logf(1, "%s downloaded from the remote plugin cache", info.Name)
+	if expected != "" {
+		resp = withExtractedSizeOf(newChecksumVerifyingReader(resp, expected, endpoint), resp)
+	}
+	return resp, length, nil
+}
+
+// downloadFromRemoteCacheFor fetches the plugin's tarball from the remote cache, verifying it against the digest
+// source publishes, if it publishes one.
+func downloadFromRemoteCacheFor(info PluginInfo, source PluginSource, cache string, version semver.Version,
+	platform Platform, getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	var expected string
+	if checksums, ok := source.(checksumSource); ok {
+		var err error
+		if expected, _, err = checksums.Checksum(version, platform.assetOS(), platform.Arch, getHTTPResponse); err != nil {
+			return nil, -1, err
+		}
+	}
+	return downloadFromRemoteCache(info, cache, version, platform, expected, getHTTPResponse)
+}
+
+// remoteCacheUploadingReader copies a tarball downloaded from its source into a temporary file as it is read, and
+// uploads it to the remote cache once the download has been read to the end without error, by which point it has
+// been verified.
+type remoteCacheUploadingReader struct {
+	io.ReadCloser
+	info            PluginInfo
+	endpoint        string
+	hash            hash.Hash
+	tmp             *os.File
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)
+}
+
+func newRemoteCacheUploadingReader(r io.ReadCloser, info PluginInfo, cache string, version semver.Version,
+	platform Platform, getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) io.ReadCloser {
+	tmp, err := ioutil.TempFile("", "pulumi-plugin-upload-")
+	if err != nil {
+		info.logf(5, "could not upload %s to the remote plugin cache: %v", info.Name, err)
+		return r
+	}
+	return &remoteCacheUploadingReader{
+		ReadCloser:      r,
+		info:            info,
+		endpoint:        pluginRemoteCacheURL(cache, info, version, platform),
+		hash:            sha256.New(),
+		tmp:             tmp,
+		getHTTPResponse: getHTTPResponse,
+	}
+}
+
+func (r *remoteCacheUploadingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if r.tmp == nil {
+		return n, err
+	}
+	r.hash.Write(p[:n])
+	if _, werr := r.tmp.Write(p[:n]); werr != nil {
+		r.discard()
+		return n, err
+	}
+	if err == io.EOF {
+		if uploadErr := r.upload(); uploadErr != nil {
+			r.info.logf(5, "could not upload %s to the remote plugin cache: %v", r.info.Name, uploadErr)
+		}
+		r.discard()
+	} else if err != nil {
+		r.discard()
+	}
+	return n, err
+}
+
+// upload sends the downloaded tarball to the remote cache.
+func (r *remoteCacheUploadingReader) upload() error {
+	stat, err := r.tmp.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := r.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	req, err := newPluginRemoteCacheRequest(http.MethodPut, r.endpoint, r.tmp)
+	if err != nil {
+		return err
+	}
+	name := r.tmp.Name()
+	req.ContentLength = stat.Size()
+	req.GetBody = func() (io.ReadCloser, error) { return os.Open(name) }
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set(PluginRemoteCacheDigestHeader, hex.EncodeToString(r.hash.Sum(nil)))
+	resp, _, err := r.getHTTPResponse(req)
+	if err != nil {
+		return err
+	}
+	r.info.logf(5, "uploaded %s to the remote plugin cache at %s", r.info.Name, r.endpoint)
+	return resp.Close()
+}
+
+// Close uploads the tarball if the download was read to the end. Extracting a tarball can stop short of the end of
+// the download, e.g. before the gzip trailer, so whatever remains is read first.
+func (r *remoteCacheUploadingReader) Close() error {
+	if r.tmp != nil {
+		if _, err := io.Copy(ioutil.Discard, r); err != nil {
+			r.info.logf(5, "could not upload %s to the remote plugin cache: %v", r.info.Name, err)
+		}
+	}
+	r.discard()
+	return r.ReadCloser.Close()
+}
+
+// discard removes the temporary copy of the tarball.
+func (r *remoteCacheUploadingReader) discard() {
+	if r.tmp != nil {
+		contract.IgnoreClose(r.tmp)
+		contract.IgnoreError(os.Remove(r.tmp.Name()))
+		r.tmp = nil
+	}
+}
+
+// pluginRemoteCachePathRegexp matches the paths of tarballs in the remote cache protocol.
+var pluginRemoteCachePathRegexp = regexp.MustCompile(
+	`^/(language|resource|analyzer)/([A-Za-z0-9_.-]+)/v([^/]+)/([a-z0-9]+(?:-musl)?)-([a-z0-9]+)\.tar\.gz$`)
+
+// NewPluginRemoteCacheHandler returns a handler that serves the remote cache protocol described by
+// PluginRemoteCacheEnvVar from dir, for organizations that don't run a caching service of their own. Uploads are
+// verified against their digest, and the first upload of each plugin is kept. It doesn't authenticate requests.
+func NewPluginRemoteCacheHandler(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := pluginRemoteCachePathRegexp.FindStringSubmatch(r.URL.Path)
+		if m == nil {
+			http.NotFound(w, r)
+			return
+		}
+		v, err := semver.ParseTolerant(m[3])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		asset := PluginAssetName(PluginKind(m[1]), m[2], v, assetPlatform(m[4], m[5]))
+		path := filepath.Join(dir, asset)
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			if _, err := os.Stat(path); err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/gzip")
+			http.ServeFile(w, r, path)
+		case http.MethodPut:
+			if _, err := os.Stat(path); err == nil {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			status, err := receivePluginRemoteCacheUpload(dir, path, r)
+			if err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// receivePluginRemoteCacheUpload keeps the tarball uploaded in r at path, once it's verified against its digest,
+// returning the status to respond with if it isn't.
+func receivePluginRemoteCacheUpload(dir, path string, r *http.Request) (int, error) {
+	expected := strings.ToLower(r.Header.Get(PluginRemoteCacheDigestHeader))
+	if expected == "" {
+		return http.StatusBadRequest, fmt.Errorf("missing %s header", PluginRemoteCacheDigestHeader)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	tmp, err := ioutil.TempFile(dir, "upload-")
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer func() { contract.IgnoreError(os.Remove(tmp.Name())) }()
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), r.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return http.StatusBadRequest, checksumMismatchError(filepath.Base(path), expected, actual)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusCreated, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveRemoteCache returns a getter that serves requests to https://cache.example.com with handler, and others with
+// next, recording each request's method and path.
+func serveRemoteCache(handler http.Handler, next func(*http.Request) (io.ReadCloser, int64, error),
+	requested *[]string) func(*http.Request) (io.ReadCloser, int64, error) {
+	return func(req *http.Request) (io.ReadCloser, int64, error) {
+		*requested = append(*requested, req.Method+" "+req.URL.Path)
+		if req.URL.Host != "cache.example.com" {
+			return next(req)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code < 200 || rec.Code > 299 {
+			return nil, -1, newHTTPError(req, rec.Result(), fmt.Sprintf("%d HTTP error", rec.Code))
+		}
+		return ioutil.NopCloser(rec.Body), int64(rec.Body.Len()), nil
+	}
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestDownloadPlatformRemoteCache(t *testing.T) {
+	t.Setenv(PluginRemoteCacheEnvVar, "https://cache.example.com/plugins/")
+	t.Setenv(PluginPeerCacheEnvVar, "")
+	t.Setenv(PluginDeltaUpdatesEnvVar, "")
+
+	tarball := "pulumi plugin tarball v1.0.0"
+	cacheDir := t.TempDir()
+	handler := http.StripPrefix("/plugins", NewPluginRemoteCacheHandler(cacheDir))
+	var requested []string
+	getHTTPResponse := serveRemoteCache(handler, serveURLs(map[string]string{
+		"https://index.example.com/resource/mock.json": fmt.Sprintf(`{"versions": [
+			{"version": "1.0.0", "assets": {"linux-amd64": {"url": "/v1.0.0.tgz", "sha256": "%s"}}}
+		]}`, sha256Hex(tarball)),
+		"https://index.example.com/v1.0.0.tgz": tarball,
+	}), &requested)
+
+	ctx := &Context{Home: t.TempDir(), PluginDir: t.TempDir()}
+	info, err := ctx.Plugin(PluginInfo{Name: "mock", Kind: ResourcePlugin})
+	require.NoError(t, err)
+	source := newPluginIndexSource([]string{"https://index.example.com"}, "mock", ResourcePlugin, &nextSource{})
+	platform := Platform{OS: "linux", Arch: "amd64"}
+	download := func() (string, error) {
+		requested = nil
+		r, _, err := downloadPlatform(info, source, semver.MustParse("1.0.0"), platform, getHTTPResponse)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(r)
+		require.NoError(t, r.Close())
+		return string(b), err
+	}
+
+	// The first download misses the cache, and uploads the tarball from the source to it once it's verified.
+	body, err := download()
+	require.NoError(t, err)
+	assert.Equal(t, tarball, body)
+	assert.Equal(t, []string{
+		"GET /resource/mock.json",
+		"GET /plugins/resource/mock/v1.0.0/linux-amd64.tar.gz",
+		"GET /resource/mock.json",
+		"GET /v1.0.0.tgz",
+		"PUT /plugins/resource/mock/v1.0.0/linux-amd64.tar.gz",
+	}, requested)
+	cached := filepath.Join(cacheDir, "pulumi-resource-mock-v1.0.0-linux-amd64.tar.gz")
+	b, err := ioutil.ReadFile(cached)
+	require.NoError(t, err)
+	assert.Equal(t, tarball, string(b))
+
+	// Later downloads come from the cache, and are verified against the digest the source publishes.
+	body, err = download()
+	require.NoError(t, err)
+	assert.Equal(t, tarball, body)
+	assert.Equal(t, []string{
+		"GET /resource/mock.json",
+		"GET /plugins/resource/mock/v1.0.0/linux-amd64.tar.gz",
+	}, requested)
+
+	require.NoError(t, ioutil.WriteFile(cached, []byte("tampered"), 0600))
+	_, err = download()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch for https://cache.example.com/plugins/resource/mock/v1.0.0/"+
+		"linux-amd64.tar.gz")
+}
+
+func TestPluginRemoteCacheHandler(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	handler := NewPluginRemoteCacheHandler(dir)
+	do := func(method, path, body, digest string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if digest != "" {
+			req.Header.Set(PluginRemoteCacheDigestHeader, digest)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	const path = "/resource/mock/v1.0.0/linux-amd64.tar.gz"
+	assert.Equal(t, http.StatusNotFound, do("GET", path, "", ""))
+	assert.Equal(t, http.StatusNotFound, do("GET", "/resource/../../etc/passwd", "", ""))
+	assert.Equal(t, http.StatusBadRequest, do("PUT", path, "tarball", ""))
+	assert.Equal(t, http.StatusBadRequest, do("PUT", path, "tarball", sha256Hex("other")))
+	assert.Equal(t, http.StatusCreated, do("PUT", path, "tarball", sha256Hex("tarball")))
+	// The first upload is kept.
+	assert.Equal(t, http.StatusNoContent, do("PUT", path, "other", sha256Hex("other")))
+	assert.Equal(t, http.StatusOK, do("GET", path, "", ""))
+	assert.Equal(t, http.StatusMethodNotAllowed, do("DELETE", path, "", ""))
+
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "pulumi-resource-mock-v1.0.0-linux-amd64.tar.gz", entries[0].Name())
+}
@@ -0,0 +1,76 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"github.com/blang/semver"
+)
+
+// PluginOrigin is where ResolvePlugin found a plugin.
+type PluginOrigin string
+
+const (
+	// PluginOriginAmbient is a plugin found on the $PATH.
+	PluginOriginAmbient PluginOrigin = "ambient"
+	// PluginOriginBundled is a plugin bundled with the `pulumi` executable, found next to it or as its shim in the
+	// plugin cache.
+	PluginOriginBundled PluginOrigin = "bundled"
+	// PluginOriginCache is a plugin installed in the plugin cache.
+	PluginOriginCache PluginOrigin = "cache"
+	// PluginOriginLinked is a plugin linked to a locally built executable with PluginInfo.Link.
+	PluginOriginLinked PluginOrigin = "linked"
+)
+
+// PluginConstraints are what ResolvePlugin required of the plugin it resolved.
+type PluginConstraints struct {
+	// Version is the version that was requested, or nil if any version would do.
+	Version *semver.Version
+	// Range is the range of versions plugins in the plugin cache were matched against, such as "=1.2.3" or, with
+	// legacy plugin search, ">=1.2.3". It's empty if any version would do, or if the plugin cache wasn't searched.
+	Range string
+	// Ambient is true if plugins on the $PATH were considered.
+	Ambient bool
+	// AmbientPreference decided between plugins on the $PATH and the plugin cache.
+	AmbientPreference PluginAmbientPreference
+	// Variant is the variant of the plugin that was selected for the lookup, if any.
+	Variant string
+}
+
+// PluginResolution is a plugin ResolvePlugin resolved, and how it resolved it.
+type PluginResolution struct {
+	// Path is the path of the plugin's executable.
+	Path string
+	// Dir is the plugin's directory in the plugin cache, or empty if it isn't in the plugin cache.
+	Dir string
+	// Origin is where the plugin was found.
+	Origin PluginOrigin
+	// Info describes the plugin. Its version is nil for plugins that weren't found in the plugin cache, whose versions
+	// aren't known without running them.
+	Info PluginInfo
+	// Constraints are what was required of the plugin.
+	Constraints PluginConstraints
+}
+
+// pluginVersionRange returns the range of versions matchPlugin matches version against.
+func pluginVersionRange(version *semver.Version, legacy bool) string {
+	switch {
+	case version == nil:
+		return ""
+	case legacy:
+		return ">=" + version.String()
+	default:
+		return "=" + version.String()
+	}
+}
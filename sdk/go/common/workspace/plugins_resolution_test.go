@@ -0,0 +1,87 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestResolvePlugin(t *testing.T) {
+	if runtime.GOOS == windowsGOOS {
+		t.Skip("uses a shell script as a fake plugin")
+	}
+	t.Setenv("PULUMI_IGNORE_AMBIENT_PLUGINS", "")
+	t.Setenv(PluginAmbientPolicyEnvVar, "")
+
+	ctx := &Context{Home: t.TempDir()}
+	_, plugin := newMockPlugin(t)
+	plugin.PluginDir = ""
+	plugin, err := ctx.Plugin(plugin)
+	require.NoError(t, err)
+	require.NoError(t, plugin.Install(ioutil.NopCloser(bytes.NewReader(mockPluginTGZ(t))), false))
+
+	// Installed plugins are resolved from the plugin cache, matching the requested version exactly.
+	resolution, err := ctx.ResolvePlugin(ResourcePlugin, "mock", plugin.Version)
+	require.NoError(t, err)
+	pluginDir, err := plugin.DirPath()
+	require.NoError(t, err)
+	assert.Equal(t, PluginOriginCache, resolution.Origin)
+	assert.Equal(t, pluginDir, resolution.Dir)
+	assert.Equal(t, filepath.Join(pluginDir, "pulumi-resource-mock"), resolution.Path)
+	assert.Equal(t, resolution.Path, resolution.Info.Path)
+	assert.Equal(t, "mock", resolution.Info.Name)
+	assert.Equal(t, plugin.Version, resolution.Info.Version)
+	assert.Equal(t, PluginConstraints{
+		Version:           plugin.Version,
+		Range:             "=1.0.0",
+		Ambient:           true,
+		AmbientPreference: PluginAmbientPreferred,
+	}, resolution.Constraints)
+
+	dir, path, err := ctx.GetPluginPath(ResourcePlugin, "mock", plugin.Version)
+	require.NoError(t, err)
+	assert.Equal(t, resolution.Dir, dir)
+	assert.Equal(t, resolution.Path, path)
+
+	// Plugins on the $PATH take precedence, and aren't matched against a version.
+	pathDir := t.TempDir()
+	ambient := filepath.Join(pathDir, "pulumi-resource-mock")
+	require.NoError(t, ioutil.WriteFile(ambient, []byte("#!/bin/sh\nexit 1\n"), 0700))
+	t.Setenv("PATH", pathDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	resolution, err = ctx.ResolvePlugin(ResourcePlugin, "mock", plugin.Version)
+	require.NoError(t, err)
+	assert.Equal(t, &PluginResolution{
+		Path:        ambient,
+		Origin:      PluginOriginAmbient,
+		Info:        PluginInfo{Name: "mock", Kind: ResourcePlugin, Path: ambient},
+		Constraints: PluginConstraints{Version: plugin.Version, Ambient: true, AmbientPreference: PluginAmbientPreferred},
+	}, resolution)
+
+	// Unless they're ignored.
+	t.Setenv("PULUMI_IGNORE_AMBIENT_PLUGINS", "true")
+	resolution, err = ctx.ResolvePlugin(ResourcePlugin, "mock", plugin.Version)
+	require.NoError(t, err)
+	assert.Equal(t, PluginOriginCache, resolution.Origin)
+	assert.False(t, resolution.Constraints.Ambient)
+}
@@ -0,0 +1,532 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// S3Scheme prefixes the PluginDownloadURL of plugins that are downloaded from an S3 bucket, e.g.
+// `s3://my-bucket/plugins`. Plugins are downloaded from under the prefix by the name of their tarball, like they are
+// from other PluginDownloadURLs, with requests signed by the credentials AWS's CLI and SDKs would find. The bucket's
+// region is set by a `region` query parameter, such as `s3://my-bucket/plugins?region=eu-west-1`, or otherwise found
+// like AWS's CLI finds it. An `endpoint` query parameter sends requests to an S3-compatible service instead.
+const S3Scheme = "s3://"
+
+const (
+	// s3DefaultRegion is the region buckets are assumed to be in when no region is configured.
+	s3DefaultRegion = "us-east-1"
+	// s3EmptyPayloadHash is the digest of the empty body of the requests sent to S3.
+	s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// awsEC2MetadataEndpoint is the default endpoint of the EC2 instance metadata service.
+	awsEC2MetadataEndpoint = "http://169.254.169.254"
+	// awsECSCredentialsEndpoint is the endpoint the relative URIs of ECS task credentials are on.
+	awsECSCredentialsEndpoint = "http://169.254.170.2"
+)
+
+// s3Source downloads plugins from an S3 bucket.
+type s3Source struct {
+	name        string
+	kind        PluginKind
+	downloadURL string
+	ctx         *Context
+}
+
+func newS3Source(name string, kind PluginKind, downloadURL string, ctx *Context) *s3Source {
+	return &s3Source{
+		name:        name,
+		kind:        kind,
+		downloadURL: downloadURL,
+		ctx:         ctx,
+	}
+}
+
+func (source *s3Source) GetLatestVersion(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
+	return nil, errors.New("GetLatestVersion is not supported for plugins downloaded from S3")
+}
+
+func (source *s3Source) Download(
+	version semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	downloadURL, err := expandURLEnv(source.downloadURL)
+	if err != nil {
+		return nil, -1, err
+	}
+	dir, file := splitPluginURL(downloadURL, source.kind, source.name, version, opSy, arch)
+	location, err := parseS3URL(dir)
+	if err != nil {
+		return nil, -1, err
+	}
+	if location.region == "" {
+		location.region = getAWSRegion()
+	}
+	fips, err := source.ctx.fipsMode()
+	if err != nil {
+		return nil, -1, err
+	}
+	key := bucketObjectKey(location.prefix, file)
+	endpoint, err := location.objectURL(key, fips)
+	if err != nil {
+		return nil, -1, err
+	}
+	logf(1, sourceLogFields(source.kind, source.name), "%s downloading from s3://%s/%s in %s", source.name,
+		location.bucket, key, location.region)
+
+	req, err := http.NewRequest(http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	req.Header.Set("Accept", "application/octet-stream")
+
+	// Public buckets can be read without credentials, so requests are only signed if there are some.
+	creds, err := source.ctx.getAWSCredentials()
+	if err != nil {
+		return nil, -1, fmt.Errorf("getting AWS credentials to download from %s: %w", S3Scheme+location.bucket, err)
+	}
+	if creds != nil {
+		signS3Request(req, *creds, location.region, source.ctx.now())
+	} else {
+		logf(5, sourceLogFields(source.kind, source.name), "no AWS credentials found; downloading %s unsigned", endpoint)
+	}
+	return getHTTPResponse(req)
+}
+
+// s3Location is where plugins are in S3, as set by an `s3://` PluginDownloadURL.
+type s3Location struct {
+	bucket string
+	prefix string
+	// region is the bucket's region, if the URL set it.
+	region string
+	// endpoint is the S3-compatible service the bucket is in, if the URL set one.
+	endpoint *url.URL
+}
+
+// parseS3URL parses an `s3://bucket/prefix?region=region&endpoint=endpoint` PluginDownloadURL.
+func parseS3URL(rawURL string) (s3Location, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme+"://" != S3Scheme || u.Host == "" {
+		return s3Location{}, fmt.Errorf("expected S3 URL to be %sbucket/prefix; got %q", S3Scheme, rawURL)
+	}
+	location := s3Location{
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+	}
+	query := u.Query()
+	for name := range query {
+		if name != "region" && name != "endpoint" {
+			return s3Location{}, fmt.Errorf("S3 URL %q: unrecognized query parameter %q", rawURL, name)
+		}
+	}
+	location.region = query.Get("region")
+	if endpoint := query.Get("endpoint"); endpoint != "" {
+		if location.endpoint, err = url.Parse(endpoint); err != nil || location.endpoint.Host == "" {
+			return s3Location{}, fmt.Errorf("S3 URL %q: endpoint %q is not a URL", rawURL, endpoint)
+		}
+	}
+	return location, nil
+}
+
+// objectURL returns the URL of the object with the given key. Objects are addressed by virtual host on S3's regional
+// endpoints, or its FIPS endpoints in FIPS mode, unless the bucket's name can't be part of a host name covered by
+// S3's certificates, and by path on other endpoints.
+func (location s3Location) objectURL(key string, fips bool) (*url.URL, error) {
+	if location.endpoint != nil {
+		u := *location.endpoint
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + location.bucket + "/" + key
+		return &u, nil
+	}
+	service := "s3"
+	if fips {
+		service = "s3-fips"
+	}
+	host := fmt.Sprintf("%s.%s.amazonaws.com", service, location.region)
+	if strings.Contains(location.bucket, ".") {
+		return &url.URL{Scheme: "https", Host: host, Path: "/" + location.bucket + "/" + key}, nil
+	}
+	return &url.URL{Scheme: "https", Host: location.bucket + "." + host, Path: "/" + key}, nil
+}
+
+// getAWSRegion returns the region set by `AWS_REGION`, `AWS_DEFAULT_REGION` or the AWS config file, or the default
+// region.
+func getAWSRegion() string {
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(env); region != "" {
+			return region
+		}
+	}
+	if profile, err := readAWSConfigProfile(); err == nil && profile["region"] != "" {
+		return profile["region"]
+	}
+	return s3DefaultRegion
+}
+
+// awsCredentials are the credentials requests to AWS are signed with.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// getAWSCredentials finds AWS credentials the way AWS's SDKs do: in the environment, the shared credentials and config
+// files, a web identity token, the ECS task's credentials and the EC2 instance's. It returns nil if there are none.
+func (ctx *Context) getAWSCredentials() (*awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return &awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	if creds, err := getAWSProfileCredentials(); creds != nil || err != nil {
+		return creds, err
+	}
+	if tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); tokenFile != "" {
+		return ctx.getAWSWebIdentityCredentials(tokenFile)
+	}
+	if relative, full := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"),
+		os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); relative != "" || full != "" {
+		endpoint := full
+		if relative != "" {
+			endpoint = awsECSCredentialsEndpoint + relative
+		}
+		return ctx.getAWSContainerCredentials(endpoint)
+	}
+	if !cmdutil.IsTruthy(os.Getenv("AWS_EC2_METADATA_DISABLED")) {
+		creds, err := ctx.getAWSInstanceCredentials()
+		if err != nil {
+			ctx.logf(5, "no AWS credentials from the EC2 instance metadata service: %v", err)
+		}
+		return creds, nil
+	}
+	return nil, nil
+}
+
+// getAWSProfileCredentials returns the credentials of the AWS profile named by `AWS_PROFILE`, or the default profile,
+// from the shared credentials file or the config file. It returns nil if the profile has none, and an error if
+// `AWS_PROFILE` names a profile that doesn't exist.
+func getAWSProfileCredentials() (*awsCredentials, error) {
+	name := awsProfileName()
+	var found bool
+	for _, read := range []func() (map[string]string, error){readAWSCredentialsProfile, readAWSConfigProfile} {
+		profile, err := read()
+		if err != nil {
+			return nil, err
+		} else if profile == nil {
+			continue
+		}
+		found = true
+		if profile["aws_access_key_id"] != "" && profile["aws_secret_access_key"] != "" {
+			return &awsCredentials{
+				AccessKeyID:     profile["aws_access_key_id"],
+				SecretAccessKey: profile["aws_secret_access_key"],
+				SessionToken:    profile["aws_session_token"],
+			}, nil
+		}
+		if process := profile["credential_process"]; process != "" {
+			return getAWSProcessCredentials(process)
+		}
+	}
+	if !found && os.Getenv("AWS_PROFILE") != "" {
+		return nil, fmt.Errorf("AWS profile %q does not exist", name)
+	}
+	return nil, nil
+}
+
+// awsProfileName returns the name of the AWS profile in use.
+func awsProfileName() string {
+	if name := os.Getenv("AWS_PROFILE"); name != "" {
+		return name
+	}
+	return "default"
+}
+
+// readAWSCredentialsProfile returns the settings of the AWS profile in use in the shared credentials file, or nil if
+// the file doesn't have it.
+func readAWSCredentialsProfile() (map[string]string, error) {
+	return readAWSFileSection("AWS_SHARED_CREDENTIALS_FILE", "credentials", awsProfileName())
+}
+
+// readAWSConfigProfile returns the settings of the AWS profile in use in the config file, in which profiles other than
+// the default profile are prefixed with `profile`, or nil if the file doesn't have it.
+func readAWSConfigProfile() (map[string]string, error) {
+	section := awsProfileName()
+	if section != "default" {
+		section = "profile " + section
+	}
+	return readAWSFileSection("AWS_CONFIG_FILE", "config", section)
+}
+
+// readAWSFileSection returns the settings in the given section of the AWS file at the path envVar sets, or name in the
+// `.aws` directory of the user's home directory, or nil if the file or section doesn't exist.
+func readAWSFileSection(envVar, name, section string) (map[string]string, error) {
+	path := os.Getenv(envVar)
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".aws", name)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer contract.IgnoreClose(f)
+
+	var settings map[string]string
+	var current string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			current = strings.Join(strings.Fields(line[1:len(line)-1]), " ")
+			if current == section && settings == nil {
+				settings = map[string]string{}
+			}
+		case current == section:
+			if eq := strings.Index(line, "="); eq > 0 {
+				settings[strings.TrimSpace(line[:eq])] = strings.TrimSpace(line[eq+1:])
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return settings, nil
+}
+
+// getAWSProcessCredentials runs the `credential_process` of an AWS profile, and returns the credentials it prints.
+func getAWSProcessCredentials(process string) (*awsCredentials, error) {
+	args := strings.Fields(process)
+	out, err := exec.Command(args[0], args[1:]...).Output() //nolint:gosec // the user's AWS profile runs it
+	if err != nil {
+		return nil, fmt.Errorf("running credential_process %q: %w", process, err)
+	}
+	var creds struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		SessionToken    string `json:"SessionToken"`
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return nil, fmt.Errorf("parsing the output of credential_process %q: %w", process, err)
+	}
+	return &awsCredentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+	}, nil
+}
+
+// getAWSWebIdentityCredentials exchanges the web identity token in tokenFile for the credentials of the role
+// `AWS_ROLE_ARN` names, with STS.
+func (ctx *Context) getAWSWebIdentityCredentials(tokenFile string) (*awsCredentials, error) {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading web identity token: %w", err)
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("pulumi-%d", ctx.now().Unix())
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {os.Getenv("AWS_ROLE_ARN")},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	endpoint := fmt.Sprintf("https://sts.%s.amazonaws.com/", getAWSRegion())
+	body, err := ctx.getCredentialsResponse(http.MethodPost, endpoint, nil, form)
+	if err != nil {
+		return nil, fmt.Errorf("assuming role %s with web identity: %w", os.Getenv("AWS_ROLE_ARN"), err)
+	}
+	var resp struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string `xml:"SecretAccessKey"`
+			SessionToken    string `xml:"SessionToken"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("parsing the response of STS: %w", err)
+	}
+	return &awsCredentials{
+		AccessKeyID:     resp.Credentials.AccessKeyID,
+		SecretAccessKey: resp.Credentials.SecretAccessKey,
+		SessionToken:    resp.Credentials.SessionToken,
+	}, nil
+}
+
+// getAWSContainerCredentials returns the credentials of the ECS task, from the given endpoint.
+func (ctx *Context) getAWSContainerCredentials(endpoint string) (*awsCredentials, error) {
+	header := http.Header{}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		header.Set("Authorization", token)
+	}
+	body, err := ctx.getCredentialsResponse(http.MethodGet, endpoint, header, nil)
+	if err != nil {
+		return nil, fmt.Errorf("getting the ECS task's credentials: %w", err)
+	}
+	return parseAWSServiceCredentials(body)
+}
+
+// getAWSInstanceCredentials returns the credentials of the EC2 instance's role, from the instance metadata service.
+func (ctx *Context) getAWSInstanceCredentials() (*awsCredentials, error) {
+	endpoint := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	if endpoint == "" {
+		endpoint = awsEC2MetadataEndpoint
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	token, err := ctx.getCredentialsResponse(http.MethodPut, endpoint+"/latest/api/token",
+		http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"300"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{"X-Aws-Ec2-Metadata-Token": {string(token)}}
+	roles, err := ctx.getCredentialsResponse(http.MethodGet,
+		endpoint+"/latest/meta-data/iam/security-credentials/", header, nil)
+	if err != nil {
+		return nil, err
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return nil, errors.New("the instance has no role")
+	}
+	body, err := ctx.getCredentialsResponse(http.MethodGet,
+		endpoint+"/latest/meta-data/iam/security-credentials/"+role, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseAWSServiceCredentials(body)
+}
+
+// parseAWSServiceCredentials parses the credentials returned by the ECS and EC2 credentials services.
+func parseAWSServiceCredentials(body []byte) (*awsCredentials, error) {
+	var creds struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+	}
+	if err := json.Unmarshal(body, &creds); err != nil {
+		return nil, fmt.Errorf("parsing credentials: %w", err)
+	}
+	return &awsCredentials{AccessKeyID: creds.AccessKeyID, SecretAccessKey: creds.SecretAccessKey,
+		SessionToken: creds.Token}, nil
+}
+
+// signS3Request signs req with AWS Signature Version 4, for S3 in the given region. The request's host, the `Range`
+// header and the `X-Amz-*` headers are signed, so headers that are added later, such as the `Range` a resumed
+// download sends, don't invalidate the signature.
+func signS3Request(req *http.Request, creds awsCredentials, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3EmptyPayloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncode(req.URL.EscapedPath()),
+		strings.Replace(req.URL.Query().Encode(), "+", "%20", -1),
+		canonicalHeaders.String(),
+		signedHeaders,
+		s3EmptyPayloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// awsURIEncode returns the canonical form of an escaped URL path for signing, in which every byte other than
+// unreserved characters and slashes is percent-encoded.
+func awsURIEncode(escapedPath string) string {
+	path, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		path = escapedPath
+	}
+	if path == "" {
+		return "/"
+	}
+	var encoded strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			encoded.WriteByte(c)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return encoded.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, err := mac.Write([]byte(data))
+	contract.AssertNoError(err)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,544 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// PluginSBOMFormat is the format a software bill of materials for plugins is written in.
+type PluginSBOMFormat string
+
+const (
+	// PluginSBOMCycloneDX writes a CycloneDX 1.4 JSON document.
+	PluginSBOMCycloneDX PluginSBOMFormat = "cyclonedx"
+	// PluginSBOMSPDX writes an SPDX 2.3 JSON document.
+	PluginSBOMSPDX PluginSBOMFormat = "spdx"
+)
+
+// ParsePluginSBOMFormat parses the name of a PluginSBOMFormat.
+func ParsePluginSBOMFormat(s string) (PluginSBOMFormat, error) {
+	switch format := PluginSBOMFormat(strings.ToLower(s)); format {
+	case PluginSBOMCycloneDX, PluginSBOMSPDX:
+		return format, nil
+	default:
+		return "", fmt.Errorf("expected %q or %q; got %q", PluginSBOMCycloneDX, PluginSBOMSPDX, s)
+	}
+}
+
+// PluginSBOMDependency is a package a plugin depends on, as recorded by the lockfiles in its directory.
+type PluginSBOMDependency struct {
+	// Ecosystem is the package ecosystem, as the type of a package URL: "npm" or "pypi".
+	Ecosystem string
+	// Name is the name of the package.
+	Name string
+	// Version is the version of the package, or "" if its lockfile doesn't pin one.
+	Version string
+}
+
+// PURL returns the package URL identifying the dependency.
+func (dep PluginSBOMDependency) PURL() string {
+	name := dep.Name
+	if dep.Ecosystem == "pypi" {
+		name = strings.ToLower(pipNameSeparatorsRegexp.ReplaceAllString(name, "-"))
+	}
+	// npm scopes are the namespace of the package URL, and the @ that starts them is escaped.
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		parts[i] = purlEscape(parts[i])
+	}
+	purl := fmt.Sprintf("pkg:%s/%s", dep.Ecosystem, strings.Join(parts, "/"))
+	if dep.Version != "" {
+		purl += "@" + purlEscape(dep.Version)
+	}
+	return purl
+}
+
+// purlEscape escapes a segment of a package URL, including the @ that separates its version.
+func purlEscape(s string) string {
+	return strings.ReplaceAll(url.PathEscape(s), "@", "%40")
+}
+
+// PluginSBOMComponent describes an installed plugin in a software bill of materials.
+type PluginSBOMComponent struct {
+	// Plugin is the plugin.
+	Plugin PluginInfo
+	// Digest is the hex-encoded SHA-256 digest of the plugin's executable, or "" if it doesn't have one, as for plugins
+	// that are run by a language runtime.
+	Digest string
+	// License is the license the plugin's PulumiPlugin.yaml declares, if any.
+	License *PluginLicense
+	// Dependencies are the packages the lockfiles in the plugin's directory pin, sorted by ecosystem, name and version.
+	Dependencies []PluginSBOMDependency
+}
+
+// PURL returns the package URL identifying the plugin.
+func (c PluginSBOMComponent) PURL() string {
+	purl := "pkg:generic/" + purlEscape(c.Plugin.FilePrefix())
+	if version := c.version(); version != "" {
+		purl += "@" + purlEscape(version)
+	}
+	return purl
+}
+
+func (c PluginSBOMComponent) version() string {
+	if c.Plugin.Version == nil {
+		return ""
+	}
+	return c.Plugin.Version.String()
+}
+
+// pluginSBOMLockfiles are the lockfiles read for the dependencies of plugins, in the order they're read.
+var pluginSBOMLockfiles = []struct {
+	Name string
+	Read func(path string) ([]PluginSBOMDependency, error)
+}{
+	{Name: "package-lock.json", Read: readPackageLockDependencies},
+	{Name: "poetry.lock", Read: readPoetryLockDependencies},
+	{Name: "requirements.txt", Read: readRequirementsTxtDependencies},
+}
+
+// SBOMComponent describes the installed plugin for a software bill of materials: the digest of its executable, the
+// license its PulumiPlugin.yaml declares, and the dependencies its lockfiles pin.
+func (info PluginInfo) SBOMComponent() (*PluginSBOMComponent, error) {
+	dir, err := info.DirPath()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	component := &PluginSBOMComponent{Plugin: info}
+
+	path, err := info.FilePath()
+	if err != nil {
+		return nil, err
+	}
+	if component.Digest, err = pluginFileDigest(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("computing the digest of %s: %w", path, err)
+	}
+
+	proj, err := LoadPluginProject(filepath.Join(dir, "PulumiPlugin.yaml"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("loading PulumiPlugin.yaml: %w", err)
+	}
+	if proj != nil {
+		component.License = proj.License
+	}
+
+	seen := map[PluginSBOMDependency]bool{}
+	for _, lockfile := range pluginSBOMLockfiles {
+		path := filepath.Join(dir, lockfile.Name)
+		deps, err := lockfile.Read(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		for _, dep := range deps {
+			if !seen[dep] {
+				seen[dep] = true
+				component.Dependencies = append(component.Dependencies, dep)
+			}
+		}
+	}
+	sort.Slice(component.Dependencies, func(i, j int) bool {
+		a, b := component.Dependencies[i], component.Dependencies[j]
+		if a.Ecosystem != b.Ecosystem {
+			return a.Ecosystem < b.Ecosystem
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+	return component, nil
+}
+
+// pluginFileDigest returns the hex-encoded SHA-256 digest of the file at path.
+func pluginFileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer contract.IgnoreClose(f)
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// readPackageLockDependencies returns the packages a package-lock.json pins. Version 2 and 3 lockfiles list them all
+// in "packages", keyed by where they're installed; version 1 lockfiles nest them in "dependencies".
+func readPackageLockDependencies(path string) ([]PluginSBOMDependency, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	type lockedDependency struct {
+		Version      string                      `json:"version"`
+		Dependencies map[string]lockedDependency `json:"dependencies"`
+	}
+	var lock struct {
+		Packages map[string]struct {
+			Version string `json:"version"`
+			Link    bool   `json:"link"`
+		} `json:"packages"`
+		Dependencies map[string]lockedDependency `json:"dependencies"`
+	}
+	if err := json.Unmarshal(b, &lock); err != nil {
+		return nil, err
+	}
+
+	var deps []PluginSBOMDependency
+	if lock.Packages != nil {
+		for key, pkg := range lock.Packages {
+			i := strings.LastIndex(key, "node_modules/")
+			if i < 0 || pkg.Link {
+				continue
+			}
+			deps = append(deps, PluginSBOMDependency{
+				Ecosystem: "npm",
+				Name:      key[i+len("node_modules/"):],
+				Version:   pkg.Version,
+			})
+		}
+		return deps, nil
+	}
+	var walk func(map[string]lockedDependency)
+	walk = func(pkgs map[string]lockedDependency) {
+		for name, pkg := range pkgs {
+			deps = append(deps, PluginSBOMDependency{Ecosystem: "npm", Name: name, Version: pkg.Version})
+			walk(pkg.Dependencies)
+		}
+	}
+	walk(lock.Dependencies)
+	return deps, nil
+}
+
+// poetryLockFieldRegexp matches the name and version fields of the packages in a poetry.lock.
+var poetryLockFieldRegexp = regexp.MustCompile(`^(name|version)\s*=\s*"([^"]*)"`)
+
+// readPoetryLockDependencies returns the packages a poetry.lock pins, from the name and version of each [[package]]
+// table.
+func readPoetryLockDependencies(path string) ([]PluginSBOMDependency, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer contract.IgnoreClose(f)
+
+	var deps []PluginSBOMDependency
+	var pkg *PluginSBOMDependency
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			if pkg != nil && pkg.Name != "" {
+				deps = append(deps, *pkg)
+			}
+			pkg = nil
+			if line == "[[package]]" {
+				pkg = &PluginSBOMDependency{Ecosystem: "pypi"}
+			}
+			continue
+		}
+		if match := poetryLockFieldRegexp.FindStringSubmatch(line); pkg != nil && match != nil {
+			if match[1] == "name" {
+				pkg.Name = match[2]
+			} else {
+				pkg.Version = match[2]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if pkg != nil && pkg.Name != "" {
+		deps = append(deps, *pkg)
+	}
+	return deps, nil
+}
+
+// readRequirementsTxtDependencies returns the packages a requirements.txt lists, with the versions it pins them to.
+func readRequirementsTxtDependencies(path string) ([]PluginSBOMDependency, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer contract.IgnoreClose(f)
+
+	var deps []PluginSBOMDependency
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "-") {
+			continue
+		}
+		match := pipRequirementRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		dep := PluginSBOMDependency{Ecosystem: "pypi", Name: match[1]}
+		if specifier := strings.TrimSpace(match[2]); strings.HasPrefix(specifier, "==") {
+			dep.Version = strings.TrimSpace(strings.TrimPrefix(specifier, "=="))
+		}
+		deps = append(deps, dep)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+// PluginSBOM is a software bill of materials for installed plugins, for inventories of everything that runs during
+// deployments.
+type PluginSBOM struct {
+	// Created is when the bill of materials was created.
+	Created time.Time
+	// Components are the plugins.
+	Components []PluginSBOMComponent
+}
+
+// NewPluginSBOM creates a software bill of materials for the given installed plugins.
+func (ctx *Context) NewPluginSBOM(plugins []PluginInfo) (*PluginSBOM, error) {
+	sbom := &PluginSBOM{Created: ctx.now().UTC()}
+	for _, plugin := range plugins {
+		component, err := plugin.SBOMComponent()
+		if err != nil {
+			return nil, fmt.Errorf("describing %s plugin %s: %w", plugin.Kind, plugin, err)
+		}
+		sbom.Components = append(sbom.Components, *component)
+	}
+	return sbom, nil
+}
+
+// GetPluginSBOM creates a software bill of materials for every plugin installed in the context's plugin directory.
+func (ctx *Context) GetPluginSBOM() (*PluginSBOM, error) {
+	plugins, err := ctx.getPlugins(true /* skipMetadata */)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.NewPluginSBOM(plugins)
+}
+
+// Write writes the bill of materials to w in the given format.
+func (sbom *PluginSBOM) Write(w io.Writer, format PluginSBOMFormat) error {
+	var doc interface{}
+	switch format {
+	case PluginSBOMCycloneDX:
+		doc = sbom.cycloneDX()
+	case PluginSBOMSPDX:
+		doc = sbom.spdx()
+	default:
+		return fmt.Errorf("unsupported SBOM format %q", format)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+type cycloneDXDocument struct {
+	BOMFormat   string               `json:"bomFormat"`
+	SpecVersion string               `json:"specVersion"`
+	Version     int                  `json:"version"`
+	Metadata    cycloneDXMetadata    `json:"metadata"`
+	Components  []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string                   `json:"timestamp"`
+	Tools     []map[string]interface{} `json:"tools"`
+}
+
+type cycloneDXComponent struct {
+	Type       string                   `json:"type"`
+	BOMRef     string                   `json:"bom-ref"`
+	Name       string                   `json:"name"`
+	Version    string                   `json:"version,omitempty"`
+	PURL       string                   `json:"purl"`
+	Hashes     []map[string]string      `json:"hashes,omitempty"`
+	Licenses   []map[string]interface{} `json:"licenses,omitempty"`
+	Components []cycloneDXComponent     `json:"components,omitempty"`
+}
+
+func (sbom *PluginSBOM) cycloneDX() cycloneDXDocument {
+	doc := cycloneDXDocument{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.4",
+		Version:     1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: sbom.Created.Format(time.RFC3339),
+			Tools:     []map[string]interface{}{{"vendor": "Pulumi", "name": "pulumi"}},
+		},
+		Components: []cycloneDXComponent{},
+	}
+	for _, c := range sbom.Components {
+		component := cycloneDXComponent{
+			Type:    "application",
+			BOMRef:  c.PURL(),
+			Name:    c.Plugin.FilePrefix(),
+			Version: c.version(),
+			PURL:    c.PURL(),
+		}
+		if c.Digest != "" {
+			component.Hashes = []map[string]string{{"alg": "SHA-256", "content": c.Digest}}
+		}
+		if c.License != nil {
+			license := map[string]string{"name": c.License.Name}
+			if c.License.URL != "" {
+				license["url"] = c.License.URL
+			}
+			component.Licenses = []map[string]interface{}{{"license": license}}
+		}
+		for _, dep := range c.Dependencies {
+			component.Components = append(component.Components, cycloneDXComponent{
+				Type:    "library",
+				BOMRef:  c.PURL() + "|" + dep.PURL(),
+				Name:    dep.Name,
+				Version: dep.Version,
+				PURL:    dep.PURL(),
+			})
+		}
+		doc.Components = append(doc.Components, component)
+	}
+	return doc
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID           string              `json:"SPDXID"`
+	Name             string              `json:"name"`
+	VersionInfo      string              `json:"versionInfo,omitempty"`
+	DownloadLocation string              `json:"downloadLocation"`
+	FilesAnalyzed    bool                `json:"filesAnalyzed"`
+	Checksums        []map[string]string `json:"checksums,omitempty"`
+	LicenseDeclared  string              `json:"licenseDeclared"`
+	LicenseComments  string              `json:"licenseComments,omitempty"`
+	ExternalRefs     []map[string]string `json:"externalRefs"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// spdxLicenseIDRegexp matches license names that are SPDX license identifiers, such as Apache-2.0, rather than free
+// text.
+var spdxLicenseIDRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.+-]*$`)
+
+func spdxPURLRef(purl string) []map[string]string {
+	return []map[string]string{
+		{"referenceCategory": "PACKAGE-MANAGER", "referenceType": "purl", "referenceLocator": purl},
+	}
+}
+
+func (sbom *PluginSBOM) spdx() spdxDocument {
+	created := sbom.Created.Format(time.RFC3339)
+	doc := spdxDocument{
+		SPDXVersion: "SPDX-2.3",
+		DataLicense: "CC0-1.0",
+		SPDXID:      "SPDXRef-DOCUMENT",
+		Name:        "pulumi-plugins",
+		CreationInfo: spdxCreationInfo{
+			Created:  created,
+			Creators: []string{"Tool: pulumi"},
+		},
+		Packages:      []spdxPackage{},
+		Relationships: []spdxRelationship{},
+	}
+
+	// The namespace must be unique to this document, so it's derived from everything in it.
+	namespace := sha256.New()
+	fmt.Fprintf(namespace, "%s\x00", created)
+	for i, c := range sbom.Components {
+		id := fmt.Sprintf("SPDXRef-Plugin-%d", i+1)
+		pkg := spdxPackage{
+			SPDXID:           id,
+			Name:             c.Plugin.FilePrefix(),
+			VersionInfo:      c.version(),
+			DownloadLocation: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			ExternalRefs:     spdxPURLRef(c.PURL()),
+		}
+		if c.Digest != "" {
+			pkg.Checksums = []map[string]string{{"algorithm": "SHA256", "checksumValue": c.Digest}}
+		}
+		if c.License != nil {
+			if spdxLicenseIDRegexp.MatchString(c.License.Name) {
+				pkg.LicenseDeclared = c.License.Name
+			} else {
+				pkg.LicenseComments = strings.TrimSpace(c.License.Name + " " + c.License.URL)
+			}
+		}
+		doc.Packages = append(doc.Packages, pkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID: doc.SPDXID, RelationshipType: "DESCRIBES", RelatedSPDXElement: id,
+		})
+		fmt.Fprintf(namespace, "%s\x00%s\x00", c.PURL(), c.Digest)
+
+		for j, dep := range c.Dependencies {
+			depID := fmt.Sprintf("%s-Dependency-%d", id, j+1)
+			doc.Packages = append(doc.Packages, spdxPackage{
+				SPDXID:           depID,
+				Name:             dep.Name,
+				VersionInfo:      dep.Version,
+				DownloadLocation: "NOASSERTION",
+				LicenseDeclared:  "NOASSERTION",
+				ExternalRefs:     spdxPURLRef(dep.PURL()),
+			})
+			doc.Relationships = append(doc.Relationships, spdxRelationship{
+				SPDXElementID: id, RelationshipType: "DEPENDS_ON", RelatedSPDXElement: depID,
+			})
+			fmt.Fprintf(namespace, "%s\x00", dep.PURL())
+		}
+	}
+	doc.DocumentNamespace = "https://pulumi.com/spdxdocs/pulumi-plugins-" + hex.EncodeToString(namespace.Sum(nil))
+	return doc
+}
@@ -0,0 +1,189 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSBOMTestPlugin installs a plugin with a license and lockfiles for both npm and pip into dir.
+func writeSBOMTestPlugin(t *testing.T, dir string) PluginInfo {
+	_, plugin := newMockPlugin(t)
+	plugin.PluginDir = dir
+	pluginDir, err := plugin.DirPath()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(pluginDir, 0700))
+	for name, contents := range map[string]string{
+		plugin.File():       "binary",
+		"PulumiPlugin.yaml": "runtime: nodejs\nlicense:\n  name: Apache-2.0\n",
+		"package-lock.json": `{
+  "lockfileVersion": 3,
+  "packages": {
+    "": {"name": "mock", "dependencies": {"@pulumi/pulumi": "^3.0.0"}},
+    "node_modules/@pulumi/pulumi": {"version": "3.40.0", "dependencies": {"semver": "^5.0.0"}},
+    "node_modules/@pulumi/pulumi/node_modules/semver": {"version": "5.7.1"},
+    "node_modules/semver": {"version": "7.3.7"},
+    "node_modules/local": {"resolved": "../local", "link": true}
+  }
+}`,
+		"requirements.txt": "# pinned\nPyYAML==6.0\nrequests>=2.0 ; python_version > '3'\n",
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(pluginDir, name), []byte(contents), 0600))
+	}
+	return plugin
+}
+
+func TestPluginSBOMComponent(t *testing.T) {
+	t.Parallel()
+
+	plugin := writeSBOMTestPlugin(t, t.TempDir())
+	component, err := plugin.SBOMComponent()
+	require.NoError(t, err)
+	// The digest of "binary".
+	assert.Equal(t, "9a3a45d01531a20e89ac6ae10b0b0beb0492acd7216a368aa062d1a5fecaf9cd", component.Digest)
+	assert.Equal(t, &PluginLicense{Name: "Apache-2.0"}, component.License)
+	assert.Equal(t, []PluginSBOMDependency{
+		{Ecosystem: "npm", Name: "@pulumi/pulumi", Version: "3.40.0"},
+		{Ecosystem: "npm", Name: "semver", Version: "5.7.1"},
+		{Ecosystem: "npm", Name: "semver", Version: "7.3.7"},
+		{Ecosystem: "pypi", Name: "PyYAML", Version: "6.0"},
+		{Ecosystem: "pypi", Name: "requests"},
+	}, component.Dependencies)
+	assert.Equal(t, "pkg:generic/pulumi-resource-mock@1.0.0", component.PURL())
+	assert.Equal(t, "pkg:npm/%40pulumi/pulumi@3.40.0", component.Dependencies[0].PURL())
+	assert.Equal(t, "pkg:pypi/pyyaml@6.0", component.Dependencies[3].PURL())
+	assert.Equal(t, "pkg:pypi/requests", component.Dependencies[4].PURL())
+}
+
+func TestReadPackageLockV1Dependencies(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "package-lock.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{
+  "lockfileVersion": 1,
+  "dependencies": {
+    "@pulumi/pulumi": {"version": "3.40.0", "requires": {"semver": "^5.0.0"},
+      "dependencies": {"semver": {"version": "5.7.1"}}}
+  }
+}`), 0600))
+	deps, err := readPackageLockDependencies(path)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []PluginSBOMDependency{
+		{Ecosystem: "npm", Name: "@pulumi/pulumi", Version: "3.40.0"},
+		{Ecosystem: "npm", Name: "semver", Version: "5.7.1"},
+	}, deps)
+}
+
+func TestReadPoetryLockDependencies(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "poetry.lock")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`[[package]]
+name = "pulumi"
+version = "3.40.0"
+description = "Pulumi's Python SDK"
+
+[package.dependencies]
+semver = ">=2.8.1"
+
+[[package]]
+name = "semver"
+version = "2.13.0"
+
+[metadata]
+lock-version = "1.1"
+`), 0600))
+	deps, err := readPoetryLockDependencies(path)
+	require.NoError(t, err)
+	assert.Equal(t, []PluginSBOMDependency{
+		{Ecosystem: "pypi", Name: "pulumi", Version: "3.40.0"},
+		{Ecosystem: "pypi", Name: "semver", Version: "2.13.0"},
+	}, deps)
+}
+
+func TestWritePluginSBOM(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeSBOMTestPlugin(t, dir)
+	created := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	ctx := &Context{Home: t.TempDir(), PluginDir: dir, Clock: FixedClock(created)}
+	sbom, err := ctx.GetPluginSBOM()
+	require.NoError(t, err)
+	require.Len(t, sbom.Components, 1)
+	assert.Equal(t, created, sbom.Created)
+
+	var buf bytes.Buffer
+	require.NoError(t, sbom.Write(&buf, PluginSBOMCycloneDX))
+	var cyclonedx struct {
+		BOMFormat  string `json:"bomFormat"`
+		Components []struct {
+			Name     string              `json:"name"`
+			Version  string              `json:"version"`
+			Hashes   []map[string]string `json:"hashes"`
+			Licenses []struct {
+				License map[string]string `json:"license"`
+			} `json:"licenses"`
+			Components []struct {
+				PURL string `json:"purl"`
+			} `json:"components"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &cyclonedx))
+	assert.Equal(t, "CycloneDX", cyclonedx.BOMFormat)
+	require.Len(t, cyclonedx.Components, 1)
+	component := cyclonedx.Components[0]
+	assert.Equal(t, "pulumi-resource-mock", component.Name)
+	assert.Equal(t, "1.0.0", component.Version)
+	assert.Equal(t, "SHA-256", component.Hashes[0]["alg"])
+	assert.Equal(t, "Apache-2.0", component.Licenses[0].License["name"])
+	assert.Len(t, component.Components, 5)
+
+	buf.Reset()
+	require.NoError(t, sbom.Write(&buf, PluginSBOMSPDX))
+	var spdx struct {
+		SPDXVersion  string `json:"spdxVersion"`
+		CreationInfo struct {
+			Created string `json:"created"`
+		} `json:"creationInfo"`
+		Packages []struct {
+			SPDXID          string `json:"SPDXID"`
+			LicenseDeclared string `json:"licenseDeclared"`
+		} `json:"packages"`
+		Relationships []struct {
+			RelationshipType string `json:"relationshipType"`
+		} `json:"relationships"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &spdx))
+	assert.Equal(t, "SPDX-2.3", spdx.SPDXVersion)
+	assert.Equal(t, "2022-09-01T12:00:00Z", spdx.CreationInfo.Created)
+	require.Len(t, spdx.Packages, 6)
+	assert.Equal(t, "SPDXRef-Plugin-1", spdx.Packages[0].SPDXID)
+	assert.Equal(t, "Apache-2.0", spdx.Packages[0].LicenseDeclared)
+	assert.Equal(t, "SPDXRef-Plugin-1-Dependency-1", spdx.Packages[1].SPDXID)
+	assert.Len(t, spdx.Relationships, 6)
+
+	_, err = ParsePluginSBOMFormat("swid")
+	assert.EqualError(t, err, `expected "cyclonedx" or "spdx"; got "swid"`)
+}
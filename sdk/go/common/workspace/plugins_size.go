@@ -0,0 +1,120 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PluginSizeExcludesEnvVar is a comma-separated list of glob patterns, such as `node_modules/.cache`, matching the
+// files and directories in plugins that aren't counted in their sizes. It takes precedence over the `sizeExcludes`
+// section of PluginConfigFile, and setting it to an empty value counts everything.
+const PluginSizeExcludesEnvVar = "PULUMI_PLUGIN_SIZE_EXCLUDES"
+
+// DefaultPluginSizeExcludes are the patterns excluded from plugin sizes unless others are configured: caches that
+// tools write into the dependencies of plugins as they run, which aren't part of what was installed.
+var DefaultPluginSizeExcludes = []string{"node_modules/.cache", "__pycache__"}
+
+// validatePluginSizeExclude checks that pattern is a valid glob.
+func validatePluginSizeExclude(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("%q is not a valid glob pattern", pattern)
+	}
+	return nil
+}
+
+// getPluginSizeExcludes returns the patterns from PluginSizeExcludesEnvVar, or PluginConfigFile if it isn't set, or
+// DefaultPluginSizeExcludes if neither configures any.
+func (ctx *Context) getPluginSizeExcludes() ([]string, error) {
+	if env, ok := os.LookupEnv(PluginSizeExcludesEnvVar); ok {
+		patterns := splitEnvList(env)
+		for _, pattern := range patterns {
+			if err := validatePluginSizeExclude(pattern); err != nil {
+				return nil, fmt.Errorf("%s: %w", PluginSizeExcludesEnvVar, err)
+			}
+		}
+		return patterns, nil
+	}
+	config, err := ctx.GetPluginConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.SizeExcludes == nil {
+		return DefaultPluginSizeExcludes, nil
+	}
+	return config.SizeExcludes, nil
+}
+
+// pluginSizeExcluded returns whether rel, a slash-separated path relative to a plugin's directory, matches one of the
+// patterns. Patterns match the end of the path, so `__pycache__` matches such a directory at any depth.
+func pluginSizeExcluded(rel string, patterns []string) bool {
+	for {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, rel); ok {
+				return true
+			}
+		}
+		slash := strings.Index(rel, "/")
+		if slash < 0 {
+			return false
+		}
+		rel = rel[slash+1:]
+	}
+}
+
+// getPluginSize recursively computes how much space is devoted to a given plugin, leaving out the files and
+// directories matching the given patterns. Symbolic links aren't followed, and only count their own size, so trees
+// linked into the plugin, such as shared virtual environments and development checkouts, aren't counted again.
+func getPluginSize(path string, excludes []string) (int64, error) {
+	return getPluginSizeRel(path, "", excludes)
+}
+
+func getPluginSizeRel(path, rel string, excludes []string) (int64, error) {
+	file, err := os.Lstat(path)
+	if err != nil {
+		return 0, nil
+	}
+
+	size := int64(0)
+	if file.IsDir() {
+		subs, err := ioutil.ReadDir(path)
+		if err != nil {
+			return 0, err
+		}
+		for _, child := range subs {
+			childRel := child.Name()
+			if rel != "" {
+				childRel = rel + "/" + childRel
+			}
+			// Excluded directories aren't walked at all, which is where most of the time goes.
+			if pluginSizeExcluded(childRel, excludes) {
+				continue
+			}
+			add, err := getPluginSizeRel(filepath.Join(path, child.Name()), childRel, excludes)
+			if err != nil {
+				return 0, err
+			}
+			size += add
+		}
+	} else {
+		size += file.Size()
+	}
+	return size, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginSizeExcluded(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		rel      string
+		excluded bool
+	}{
+		{"node_modules/.cache", true},
+		{"node_modules/@pulumi/aws/node_modules/.cache", true},
+		{"node_modules/.cache-keep", false},
+		{"__pycache__", true},
+		{"venv/lib/python3.9/site-packages/pulumi/__pycache__", true},
+		{"venv/lib/python3.9/site-packages/pulumi/__init__.py", false},
+		{"logs/install.log", true},
+		{"pulumi-resource-mock", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.excluded, pluginSizeExcluded(tt.rel, append(DefaultPluginSizeExcludes, "*.log")), tt.rel)
+	}
+}
+
+func TestGetPluginSizeExcludes(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	write := func(rel string, size int) {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, ioutil.WriteFile(path, make([]byte, size), 0600))
+	}
+	write("pulumi-resource-mock", 100)
+	write("node_modules/@pulumi/pulumi/index.js", 10)
+	write("node_modules/.cache/babel/a.json", 1000)
+	write("venv/lib/site-packages/pulumi/__init__.py", 1)
+	write("venv/lib/site-packages/pulumi/__pycache__/__init__.cpython-39.pyc", 1000)
+
+	size, err := getPluginSize(dir, DefaultPluginSizeExcludes)
+	require.NoError(t, err)
+	assert.Equal(t, int64(111), size)
+	size, err = getPluginSize(dir, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2111), size)
+}
+
+// linkedPluginTree writes a tree outside of the plugin directory, returning its path and that of the file in it.
+func linkedPluginTree(t *testing.T) (string, string) {
+	if runtime.GOOS == windowsGOOS {
+		t.Skip("creating symbolic links may require elevated privileges on Windows")
+	}
+	tree := t.TempDir()
+	file := filepath.Join(tree, "index.js")
+	require.NoError(t, ioutil.WriteFile(file, make([]byte, 1000), 0600))
+	return tree, file
+}
+
+func TestGetPluginSizeDoesntFollowSymlinks(t *testing.T) {
+	t.Parallel()
+
+	tree, file := linkedPluginTree(t)
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "pulumi-resource-mock"), make([]byte, 100), 0600))
+	require.NoError(t, os.Symlink(tree, filepath.Join(dir, "venv")))
+	require.NoError(t, os.Symlink(file, filepath.Join(dir, "index.js")))
+
+	size, err := getPluginSize(dir, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100+len(tree)+len(file)), size)
+	// A plugin whose directory is a link to a development tree takes up no more than the link.
+	link := filepath.Join(t.TempDir(), "resource-mock-v1.0.0")
+	require.NoError(t, os.Symlink(tree, link))
+	size, err = getPluginSize(link, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(tree)), size)
+}
+
+func TestDeleteDoesntFollowSymlinks(t *testing.T) {
+	t.Parallel()
+
+	tree, file := linkedPluginTree(t)
+	_, plugin := newMockPlugin(t)
+	dir, err := plugin.DirPath()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(dir, 0700))
+	require.NoError(t, os.Symlink(tree, filepath.Join(dir, "venv")))
+	require.NoError(t, plugin.Delete())
+	assert.NoDirExists(t, dir)
+	assert.FileExists(t, file)
+
+	// A plugin whose directory is a link to a development tree only has the link removed.
+	require.NoError(t, os.Symlink(tree, dir))
+	require.NoError(t, plugin.Delete())
+	_, err = os.Lstat(dir)
+	assert.True(t, os.IsNotExist(err))
+	assert.FileExists(t, file)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestPluginSizeExcludesPrecedence(t *testing.T) {
+	ctx := &Context{Home: t.TempDir()}
+	excludes, err := ctx.getPluginSizeExcludes()
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPluginSizeExcludes, excludes)
+
+	ctx = &Context{Home: t.TempDir()}
+	writePluginConfig(t, ctx.Home, "sizeExcludes: []\n")
+	excludes, err = ctx.getPluginSizeExcludes()
+	require.NoError(t, err)
+	assert.Empty(t, excludes)
+
+	t.Setenv(PluginSizeExcludesEnvVar, "*.log, tmp")
+	excludes, err = ctx.getPluginSizeExcludes()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*.log", "tmp"}, excludes)
+
+	t.Setenv(PluginSizeExcludesEnvVar, "[logs")
+	_, err = ctx.getPluginSizeExcludes()
+	assert.EqualError(t, err, `PULUMI_PLUGIN_SIZE_EXCLUDES: "[logs" is not a valid glob pattern`)
+}
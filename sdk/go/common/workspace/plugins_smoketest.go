@@ -0,0 +1,129 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+)
+
+// PluginSkipSmokeTestsEnvVar skips the smoke tests plugins declare in their PulumiPlugin.yaml when set to a truthy
+// value.
+const PluginSkipSmokeTestsEnvVar = "PULUMI_SKIP_PLUGIN_SMOKE_TESTS"
+
+// DefaultPluginSmokeTestTimeout is how long a plugin's smoke test has to pass, unless the plugin declares a timeout of
+// its own.
+var DefaultPluginSmokeTestTimeout = 30 * time.Second
+
+// PluginSmokeTest is a quick self-test a plugin declares in its PulumiPlugin.yaml, which is run once the plugin and its
+// dependencies are installed. The install fails if it doesn't pass, so broken publishes are caught before first use.
+type PluginSmokeTest struct {
+	// Command is the command to run, and its arguments, in the plugin's directory. A command that's a relative path,
+	// such as `./pulumi-resource-mock`, is inside the plugin's directory, and may leave off the platform's executable
+	// extension; others are looked up on $PATH. The test passes if the command exits successfully.
+	Command []string `json:"command" yaml:"command"`
+	// Timeout is how long the command has to pass, as a duration such as 30s. It defaults to
+	// DefaultPluginSmokeTestTimeout.
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// validate checks the smoke test, returning its timeout.
+func (test *PluginSmokeTest) validate() (time.Duration, error) {
+	if len(test.Command) == 0 || test.Command[0] == "" {
+		return 0, errors.New("smokeTest.command must be set")
+	}
+	if test.Timeout == "" {
+		return DefaultPluginSmokeTestTimeout, nil
+	}
+	timeout, err := time.ParseDuration(test.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("smokeTest.timeout: %q is not a positive duration, such as 30s", test.Timeout)
+	}
+	return timeout, nil
+}
+
+// PluginSmokeTestError is returned when a plugin fails the smoke test declared by its PulumiPlugin.yaml.
+type PluginSmokeTestError struct {
+	// Info is the plugin that failed its smoke test.
+	Info PluginInfo
+	// Command is the smoke test's command.
+	Command []string
+	// Output is what the command wrote to stdout and stderr.
+	Output string
+	// Err is why the smoke test failed.
+	Err error
+}
+
+func (err *PluginSmokeTestError) Error() string {
+	msg := fmt.Sprintf("%s plugin %s failed its smoke test `%s`: %v; set %s=true to install it anyway",
+		err.Info.Kind, err.Info, strings.Join(err.Command, " "), err.Err, PluginSkipSmokeTestsEnvVar)
+	if output := strings.TrimSpace(err.Output); output != "" {
+		msg += "\n" + output
+	}
+	return msg
+}
+
+func (err *PluginSmokeTestError) Unwrap() error {
+	return err.Err
+}
+
+// runPluginSmokeTest runs the smoke test proj declares, if it declares one, for the plugin installed in dir. It
+// returns whether the test was run, and a *PluginSmokeTestError if it failed.
+func runPluginSmokeTest(info PluginInfo, proj *PluginProject, dir string) (bool, error) {
+	if proj.SmokeTest == nil {
+		return false, nil
+	}
+	if cmdutil.IsTruthy(os.Getenv(PluginSkipSmokeTestsEnvVar)) {
+		info.logf(5, "skipping smoke test of plugin %s since %s is set", info, PluginSkipSmokeTestsEnvVar)
+		return false, nil
+	}
+	timeout, err := proj.SmokeTest.validate()
+	if err != nil {
+		return false, err
+	}
+	env, err := pluginEnvironment(info.Kind, info.Name, proj.Env)
+	if err != nil {
+		return false, err
+	}
+
+	command := proj.SmokeTest.Command
+	path := command[0]
+	if strings.HasPrefix(path, ".") {
+		path, _ = resolvePluginBinary(dir, path, getCandidateExtensions(), "")
+	}
+	info.logf(1, "running smoke test of plugin %s", info)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, command[1:]...) //nolint:gosec // the plugin was just installed
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("it didn't finish within %v", timeout)
+		}
+		return true, &PluginSmokeTestError{Info: info, Command: command, Output: output.String(), Err: err}
+	}
+	return true, nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginSmokeTestValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		test PluginSmokeTest
+		err  string
+	}{
+		{test: PluginSmokeTest{Command: []string{"./check"}}},
+		{test: PluginSmokeTest{Command: []string{"./check", "--quick"}, Timeout: "5s"}},
+		{test: PluginSmokeTest{}, err: "smokeTest.command must be set"},
+		{
+			test: PluginSmokeTest{Command: []string{"./check"}, Timeout: "-5s"},
+			err:  `smokeTest.timeout: "-5s" is not a positive duration, such as 30s`,
+		},
+	}
+	for _, tt := range tests {
+		proj := &PluginProject{Binaries: map[string]string{"check": "check"}, SmokeTest: &tt.test}
+		err := proj.Validate()
+		if tt.err == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, tt.err)
+		}
+	}
+}
+
+func TestPluginSmokeTest(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == windowsGOOS {
+		t.Skip("uses a shell script as the smoke test")
+	}
+
+	tests := []struct {
+		name      string
+		smokeTest string
+		script    string
+		err       string
+	}{
+		{name: "passes", smokeTest: "  command: [./check, --quick]", script: `[ "$1" = --quick ] && [ -f check ]`},
+		{
+			name:      "fails",
+			smokeTest: "  command: [./check]",
+			script:    "echo missing credentials helper >&2; exit 3",
+			err: "resource plugin mock-1.0.0 failed its smoke test `./check`: exit status 3; " +
+				"set PULUMI_SKIP_PLUGIN_SMOKE_TESTS=true to install it anyway\nmissing credentials helper",
+		},
+		{
+			name:      "times out",
+			smokeTest: "  command: [./check]\n  timeout: 100ms",
+			script:    "exec sleep 10",
+			err: "resource plugin mock-1.0.0 failed its smoke test `./check`: it didn't finish within 100ms; " +
+				"set PULUMI_SKIP_PLUGIN_SMOKE_TESTS=true to install it anyway",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, plugin := newMockPlugin(t)
+			tgz, err := createTGZWithMode(map[string][]byte{
+				"PulumiPlugin.yaml":    []byte("binaries:\n  check: check\nsmokeTest:\n" + tt.smokeTest),
+				"pulumi-resource-mock": nil,
+				"check":                []byte("#!/bin/sh\n" + tt.script + "\n"),
+			}, 0700)
+			require.NoError(t, err)
+			err = plugin.Install(ioutil.NopCloser(bytes.NewReader(tgz)), false)
+			partial, perr := plugin.PartialFilePath()
+			require.NoError(t, perr)
+			if tt.err == "" {
+				require.NoError(t, err)
+				receipt, err := plugin.GetInstallReceipt()
+				require.NoError(t, err)
+				assert.NotNil(t, receipt.SmokeTestedAt)
+				assert.NoFileExists(t, partial)
+				return
+			}
+
+			var smokeErr *PluginSmokeTestError
+			require.True(t, errors.As(err, &smokeErr), "unexpected error %v", err)
+			assert.EqualError(t, err, tt.err)
+			assert.FileExists(t, partial)
+		})
+	}
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestSkipPluginSmokeTests(t *testing.T) {
+	if runtime.GOOS == windowsGOOS {
+		t.Skip("uses a shell script as the smoke test")
+	}
+	t.Setenv(PluginSkipSmokeTestsEnvVar, "true")
+
+	_, plugin := newMockPlugin(t)
+	tgz, err := createTGZWithMode(map[string][]byte{
+		"PulumiPlugin.yaml":    []byte("binaries:\n  check: check\nsmokeTest:\n  command: [./check]"),
+		"pulumi-resource-mock": nil,
+		"check":                []byte("#!/bin/sh\nexit 1\n"),
+	}, 0700)
+	require.NoError(t, err)
+	require.NoError(t, plugin.Install(ioutil.NopCloser(bytes.NewReader(tgz)), false))
+	receipt, err := plugin.GetInstallReceipt()
+	require.NoError(t, err)
+	assert.Nil(t, receipt.SmokeTestedAt)
+}
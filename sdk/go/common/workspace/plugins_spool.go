@@ -0,0 +1,389 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// pluginSpoolDir is the directory in the plugin cache that holds downloads while they're in progress, so that one
+// that's interrupted, e.g. by Ctrl-C, can be resumed by the next invocation rather than starting over.
+const pluginSpoolDir = ".spool"
+
+const (
+	// pluginSpoolMaxAge is how long an abandoned partial download is kept before maintenance removes it.
+	pluginSpoolMaxAge = 7 * 24 * time.Hour
+	// pluginSpoolMaxSize is how much space abandoned partial downloads may take up. Maintenance removes the oldest of
+	// them until they fit.
+	pluginSpoolMaxSize = 1 << 30
+)
+
+// spoolHeader is the first line of each file in the spool, identifying the download the rest of the file holds the
+// start of. Only downloads whose responses have a validator are spooled, so that a resumed download can be checked to
+// be of the same file.
+type spoolHeader struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// activeSpools holds the keys of the downloads this process is spooling, so that the same URL isn't spooled by two
+// downloads at once.
+var activeSpools = struct {
+	sync.Mutex
+	keys map[string]bool
+}{keys: map[string]bool{}}
+
+// downloadSpool is the spool file of a download. It's named for the digest of the download's URL and the ID of the
+// process writing it, so downloads abandoned by processes that are no longer running can be told apart from those
+// still in progress.
+type downloadSpool struct {
+	ctx    *Context
+	key    string
+	path   string
+	header spoolHeader
+	// offset is how much of the download the spool file already holds after its header, which is headerLen long.
+	offset    int64
+	headerLen int64
+}
+
+// spoolKey returns the key of the download of url in the spool.
+func spoolKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseSpoolFileName returns the key and process ID in the name of a file in the spool.
+func parseSpoolFileName(name string) (string, int, bool) {
+	parts := strings.Split(name, ".")
+	if len(parts) != 3 || parts[2] != "part" {
+		return "", 0, false
+	}
+	pid, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, false
+	}
+	return parts[0], pid, true
+}
+
+// spoolAbandoned returns true if the spool file with the given key and process ID isn't being written.
+func spoolAbandoned(key string, pid int) bool {
+	if pid != os.Getpid() {
+		return !processRunning(pid)
+	}
+	activeSpools.Lock()
+	defer activeSpools.Unlock()
+	return !activeSpools.keys[key]
+}
+
+// isAPIRequest returns true if req is a request to the API of a plugin source, which asks for JSON or XML, rather than
+// for a file.
+func isAPIRequest(req *http.Request) bool {
+	accept := req.Header.Get("Accept")
+	return strings.Contains(accept, "json") || strings.Contains(accept, "xml")
+}
+
+// claimDownloadSpool returns the spool for the download requested by req, or nil if it isn't spooled. Only the GET
+// requests plugin sources download files with are spooled, not their API requests. The largest partial download of the
+// same URL abandoned by another invocation is taken over to be resumed, and any others are removed.
+func (ctx *Context) claimDownloadSpool(req *http.Request) *downloadSpool {
+	if req.Method != http.MethodGet || isAPIRequest(req) {
+		return nil
+	}
+	root, err := ctx.GetPluginDir()
+	if err != nil {
+		return nil
+	}
+	dir := filepath.Join(root, pluginSpoolDir)
+
+	url := req.URL.String()
+	key := spoolKey(url)
+	activeSpools.Lock()
+	if activeSpools.keys[key] {
+		activeSpools.Unlock()
+		return nil
+	}
+	activeSpools.keys[key] = true
+	activeSpools.Unlock()
+
+	spool := &downloadSpool{
+		ctx:    ctx,
+		key:    key,
+		path:   filepath.Join(dir, fmt.Sprintf("%s.%d.part", key, os.Getpid())),
+		header: spoolHeader{URL: url},
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return spool
+	}
+	var largest string
+	var largestSize int64
+	for _, file := range files {
+		fileKey, pid, ok := parseSpoolFileName(file.Name())
+		if !ok || fileKey != key || (pid != os.Getpid() && !spoolAbandoned(fileKey, pid)) {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		if largest == "" || file.Size() > largestSize {
+			if largest != "" {
+				contract.IgnoreError(os.Remove(largest))
+			}
+			largest, largestSize = path, file.Size()
+		} else {
+			contract.IgnoreError(os.Remove(path))
+		}
+	}
+	// Another invocation may take over the same file first, in which case the download starts over.
+	if largest != "" && (largest == spool.path || os.Rename(largest, spool.path) == nil) {
+		spool.load()
+	}
+	return spool
+}
+
+// load reads the header of the spool file, to resume the download it holds the start of. Files that don't hold the
+// start of the same download are removed.
+func (spool *downloadSpool) load() {
+	f, err := os.Open(spool.path)
+	if err != nil {
+		return
+	}
+	defer contract.IgnoreClose(f)
+	stat, err := f.Stat()
+	if err != nil {
+		return
+	}
+	line, err := bufio.NewReader(f).ReadSlice('\n')
+	var header spoolHeader
+	if err == nil {
+		err = json.Unmarshal(line, &header)
+	}
+	if err != nil || header.URL != spool.header.URL || (header.ETag == "" && header.LastModified == "") {
+		spool.ctx.logf(5, "discarding invalid partial download %s", spool.path)
+		contract.IgnoreError(os.Remove(spool.path))
+		return
+	}
+	spool.header, spool.headerLen, spool.offset = header, int64(len(line)), stat.Size()-int64(len(line))
+}
+
+// request returns req, asking for the rest of the download if the spool holds the start of it.
+func (spool *downloadSpool) request(req *http.Request) *http.Request {
+	if spool.offset == 0 {
+		return req
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", spool.offset))
+	if spool.header.ETag != "" {
+		req.Header.Set("If-Range", spool.header.ETag)
+	} else {
+		req.Header.Set("If-Range", spool.header.LastModified)
+	}
+	return req
+}
+
+// resumes returns true if resp is the rest of the download the spool holds the start of.
+func (spool *downloadSpool) resumes(resp *http.Response) bool {
+	var start int64
+	_, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start)
+	return resp.StatusCode == http.StatusPartialContent && err == nil && start == spool.offset
+}
+
+// release lets the download be resumed, by this or another invocation.
+func (spool *downloadSpool) release() {
+	activeSpools.Lock()
+	defer activeSpools.Unlock()
+	delete(activeSpools.keys, spool.key)
+}
+
+// discard removes the spool file.
+func (spool *downloadSpool) discard() {
+	contract.IgnoreError(os.Remove(spool.path))
+	spool.release()
+}
+
+// wrap returns the body of the successful response to the spool's request, which is the rest of the download if
+// spool.resumes(resp), and otherwise all of it. The body is written to the spool as it's read.
+func (spool *downloadSpool) wrap(resp *http.Response) (io.ReadCloser, int64, error) {
+	if spool.offset > 0 && spool.resumes(resp) {
+		f, err := os.OpenFile(spool.path, os.O_RDWR, 0)
+		if err == nil {
+			_, err = f.Seek(0, io.SeekEnd)
+		}
+		if err != nil {
+			contract.IgnoreClose(resp.Body)
+			spool.discard()
+			return nil, -1, fmt.Errorf("resuming download of %s: %w", spool.header.URL, err)
+		}
+		spool.ctx.logf(1, "resuming download of %s from byte %d", spool.header.URL, spool.offset)
+		length := int64(-1)
+		if resp.ContentLength >= 0 {
+			length = spool.offset + resp.ContentLength
+		}
+		existing := io.NewSectionReader(f, spool.headerLen, spool.offset)
+		return &spoolingReader{spool: spool, body: resp.Body, existing: existing, file: f}, length, nil
+	}
+
+	// The download is starting over, so anything already spooled is replaced.
+	header := spoolHeader{
+		URL:          spool.header.URL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if header.ETag == "" && header.LastModified == "" {
+		spool.discard()
+		return resp.Body, resp.ContentLength, nil
+	}
+	b, err := json.Marshal(header)
+	contract.AssertNoError(err)
+	err = os.MkdirAll(filepath.Dir(spool.path), 0700)
+	var f *os.File
+	if err == nil {
+		f, err = os.Create(spool.path)
+	}
+	if err == nil {
+		_, err = f.Write(append(b, '\n'))
+	}
+	if err != nil {
+		spool.ctx.logf(5, "not spooling download of %s: %v", spool.header.URL, err)
+		if f != nil {
+			contract.IgnoreClose(f)
+		}
+		spool.discard()
+		return resp.Body, resp.ContentLength, nil
+	}
+	spool.header, spool.headerLen, spool.offset = header, int64(len(b)+1), 0
+	return &spoolingReader{spool: spool, body: resp.Body, file: f}, resp.ContentLength, nil
+}
+
+// spoolingReader reads a download, starting with the part of it already in the spool, and writes what it reads from
+// the response to the spool. The spool file is removed once the download has been read to the end, and kept to be
+// resumed if reading the response fails.
+type spoolingReader struct {
+	spool    *downloadSpool
+	body     io.ReadCloser
+	existing io.Reader
+	// file is the spool file, or nil once the download has finished or been abandoned.
+	file *os.File
+}
+
+func (r *spoolingReader) Read(p []byte) (int, error) {
+	if r.existing != nil {
+		n, err := r.existing.Read(p)
+		if err == io.EOF {
+			r.existing, err = nil, nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+
+	n, err := r.body.Read(p)
+	if r.file != nil && n > 0 {
+		if _, werr := r.file.Write(p[:n]); werr != nil {
+			r.spool.ctx.logf(5, "could not spool download of %s: %v", r.spool.header.URL, werr)
+			r.finish(true /* remove */)
+		}
+	}
+	if err == io.EOF {
+		r.finish(true /* remove */)
+	} else if err != nil {
+		r.finish(false /* remove */)
+	}
+	return n, err
+}
+
+// finish closes the spool file, removing it unless the download is to be resumed.
+func (r *spoolingReader) finish(remove bool) {
+	if r.file == nil {
+		return
+	}
+	contract.IgnoreClose(r.file)
+	r.file = nil
+	if remove {
+		contract.IgnoreError(os.Remove(r.spool.path))
+	}
+	r.spool.release()
+}
+
+// Close abandons the download if it hasn't been read to the end. The caller may have stopped reading because what
+// was downloaded is corrupt, so it's removed from the spool rather than resumed.
+func (r *spoolingReader) Close() error {
+	r.finish(true /* remove */)
+	return r.body.Close()
+}
+
+// maintainPluginSpool removes the partial downloads in the plugin cache's spool that were abandoned longer ago than
+// pluginSpoolMaxAge, and then the oldest of the rest until they fit in pluginSpoolMaxSize, adding them to the report.
+func (ctx *Context) maintainPluginSpool(root string, report *PluginMaintenanceReport) error {
+	removed, err := removeAbandonedSpools(filepath.Join(root, pluginSpoolDir), ctx.now(), pluginSpoolMaxAge,
+		pluginSpoolMaxSize)
+	for _, path := range removed {
+		ctx.logf(5, "removed abandoned partial download %s", path)
+	}
+	report.Spooled = append(report.Spooled, removed...)
+	return err
+}
+
+// removeAbandonedSpools removes the abandoned partial downloads in dir that are older than maxAge, and then the
+// oldest of the rest until they take up no more than maxSize, returning their paths.
+func removeAbandonedSpools(dir string, now time.Time, maxAge time.Duration, maxSize int64) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var removed []string
+	var abandoned []os.FileInfo
+	for _, file := range files {
+		key, pid, ok := parseSpoolFileName(file.Name())
+		if !ok || file.IsDir() || !spoolAbandoned(key, pid) {
+			continue
+		}
+		abandoned = append(abandoned, file)
+	}
+	sort.Slice(abandoned, func(i, j int) bool { return abandoned[i].ModTime().After(abandoned[j].ModTime()) })
+
+	var size int64
+	for _, file := range abandoned {
+		size += file.Size()
+		if now.Sub(file.ModTime()) <= maxAge && size <= maxSize {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
@@ -0,0 +1,24 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package workspace
+
+// processRunning returns true, as whether a process is running can't be determined on this platform. Partial
+// downloads are then only resumed by the process that started them.
+func processRunning(pid int) bool {
+	return true
+}
@@ -0,0 +1,186 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deadPID is the ID of a process that isn't running.
+const deadPID = 99999999
+
+// serveSpoolTestFile serves data with an ETag, supporting range requests, except that the first response is cut off
+// half way through. It returns the URL data is served at and the Range headers of the requests it received.
+func serveSpoolTestFile(t *testing.T, data []byte, etag string) (string, func() []string) {
+	var m sync.Mutex
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		first := len(ranges) == 0
+		ranges = append(ranges, r.Header.Get("Range"))
+		m.Unlock()
+
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		if first {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			_, err := w.Write(data[:len(data)/2])
+			assert.NoError(t, err)
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "plugin.tar.gz", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/plugin.tar.gz", func() []string {
+		m.Lock()
+		defer m.Unlock()
+		return append([]string{}, ranges...)
+	}
+}
+
+// spoolFiles returns the names of the files in the context's spool.
+func spoolFiles(t *testing.T, ctx *Context) []string {
+	files, err := ioutil.ReadDir(filepath.Join(ctx.PluginDir, pluginSpoolDir))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	require.NoError(t, err)
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	return names
+}
+
+func TestSpooledDownloadResumes(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	url, ranges := serveSpoolTestFile(t, data, `"v1"`)
+	ctx := &Context{Home: t.TempDir(), PluginDir: t.TempDir()}
+	get := func() (io.ReadCloser, int64, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+		return ctx.sendHTTPRequest(req)
+	}
+
+	// The interrupted download is kept in the spool.
+	r, _, err := get()
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	assert.Error(t, err)
+	require.NoError(t, r.Close())
+	key := spoolKey(url)
+	assert.Equal(t, []string{fmt.Sprintf("%s.%d.part", key, os.Getpid())}, spoolFiles(t, ctx))
+
+	// Another invocation resumes it, once the one that started it has gone.
+	spooled := filepath.Join(ctx.PluginDir, pluginSpoolDir, fmt.Sprintf("%s.%d.part", key, os.Getpid()))
+	require.NoError(t, os.Rename(spooled, filepath.Join(filepath.Dir(spooled), fmt.Sprintf("%s.%d.part", key, deadPID))))
+	r, length, err := get()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), length)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.True(t, bytes.Equal(data, b))
+
+	assert.Equal(t, []string{"", fmt.Sprintf("bytes=%d-", len(data)/2)}, ranges())
+	assert.Empty(t, spoolFiles(t, ctx))
+}
+
+func TestSpooledDownloadStartsOver(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	url, ranges := serveSpoolTestFile(t, data, `"v1"`)
+	ctx := &Context{Home: t.TempDir(), PluginDir: t.TempDir()}
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	r, _, err := ctx.sendHTTPRequest(req)
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	assert.Error(t, err)
+
+	// Downloads that are abandoned part way through, e.g. because they're corrupt, aren't resumed.
+	spooled := filepath.Join(ctx.PluginDir, pluginSpoolDir, fmt.Sprintf("%s.%d.part", spoolKey(url), os.Getpid()))
+	require.NoError(t, os.Remove(spooled))
+	r, _, err = ctx.sendHTTPRequest(req)
+	require.NoError(t, err)
+	buf := make([]byte, 10)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	assert.FileExists(t, spooled)
+	require.NoError(t, r.Close())
+	assert.Empty(t, spoolFiles(t, ctx))
+	assert.Equal(t, []string{"", ""}, ranges())
+}
+
+func TestDownloadsWithoutValidatorsArentSpooled(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	url, _ := serveSpoolTestFile(t, data, "")
+	ctx := &Context{Home: t.TempDir(), PluginDir: t.TempDir()}
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	r, _, err := ctx.sendHTTPRequest(req)
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	assert.Error(t, err)
+	require.NoError(t, r.Close())
+	assert.Empty(t, spoolFiles(t, ctx))
+}
+
+func TestRemoveAbandonedSpools(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(name string, size int, age time.Duration) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, make([]byte, size), 0600))
+		require.NoError(t, os.Chtimes(path, now.Add(-age), now.Add(-age)))
+		return path
+	}
+	expired := write(fmt.Sprintf("a.%d.part", deadPID), 10, 8*24*time.Hour)
+	newest := write(fmt.Sprintf("b.%d.part", deadPID), 60, time.Hour)
+	oldest := write(fmt.Sprintf("c.%d.part", deadPID), 60, 2*time.Hour)
+	// Downloads that are still in progress, and other files, are left alone.
+	running := write(fmt.Sprintf("d.%d.part", os.Getppid()), 60, 30*24*time.Hour)
+	other := write("e.part", 10, 30*24*time.Hour)
+
+	removed, err := removeAbandonedSpools(dir, now, 7*24*time.Hour, 100)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{expired, oldest}, removed)
+	for _, path := range []string{newest, running, other} {
+		assert.FileExists(t, path)
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// PluginStallTimeoutEnvVar is how long a plugin download may go without receiving any bytes before it's considered
+// stalled, such as `90s`, or `off` to wait for as long as the connection stays open. It takes precedence over the
+// `stallTimeout` setting of PluginConfigFile.
+//
+// A stalled download is abandoned and retried, from the next mirror if there is one. Retries of the same source resume
+// from the part of the download that was spooled.
+const PluginStallTimeoutEnvVar = "PULUMI_PLUGIN_STALL_TIMEOUT"
+
+// DefaultPluginStallTimeout is how long plugin downloads may go without receiving any bytes unless another timeout is
+// configured.
+const DefaultPluginStallTimeout = time.Minute
+
+const (
+	// pluginStallRetries is how many times a stalled download is retried before it fails.
+	pluginStallRetries = 3
+	// pluginHeartbeatInterval is the longest interval between the heartbeats of a download.
+	pluginHeartbeatInterval = 5 * time.Second
+)
+
+// parsePluginStallTimeout parses a stall timeout, returning a negative duration for `off`.
+func parsePluginStallTimeout(s string) (time.Duration, error) {
+	if s == "off" {
+		return -1, nil
+	}
+	timeout, err := time.ParseDuration(s)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("%q is not a positive duration, such as 90s, or off", s)
+	}
+	return timeout, nil
+}
+
+// pluginStallTimeout returns the stall timeout set by PluginStallTimeoutEnvVar, or PluginConfigFile if it isn't set,
+// or DefaultPluginStallTimeout if neither sets one. It's zero if stall detection is off.
+func (ctx *Context) pluginStallTimeout() (time.Duration, error) {
+	timeout := time.Duration(0)
+	if env := os.Getenv(PluginStallTimeoutEnvVar); env != "" {
+		parsed, err := parsePluginStallTimeout(env)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", PluginStallTimeoutEnvVar, err)
+		}
+		timeout = parsed
+	} else {
+		config, err := ctx.GetPluginConfig()
+		if err != nil {
+			return 0, err
+		}
+		timeout = config.StallTimeout
+	}
+	switch {
+	case timeout < 0:
+		return 0, nil
+	case timeout == 0:
+		return DefaultPluginStallTimeout, nil
+	default:
+		return timeout, nil
+	}
+}
+
+// pluginHeartbeatIntervalFor returns the interval between the heartbeats of downloads with the given stall timeout,
+// so several heartbeats are sent before a download is considered stalled.
+func pluginHeartbeatIntervalFor(timeout time.Duration) time.Duration {
+	if timeout > 0 && timeout/4 < pluginHeartbeatInterval {
+		return timeout / 4
+	}
+	return pluginHeartbeatInterval
+}
+
+// stallWatch cancels a request once no bytes of its response have arrived for the stall timeout, whether it's waiting
+// for the response's headers or reading its body.
+type stallWatch struct {
+	url     string
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	stalled int32
+}
+
+// watchForStalls returns req, canceled if it stalls for the timeout, and the watch that cancels it. The watch is nil if
+// timeout is zero.
+func watchForStalls(req *http.Request, timeout time.Duration) (*http.Request, *stallWatch) {
+	if timeout <= 0 {
+		return req, nil
+	}
+	reqCtx, cancel := context.WithCancel(req.Context())
+	watch := &stallWatch{url: req.URL.Redacted(), timeout: timeout, cancel: cancel}
+	watch.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&watch.stalled, 1)
+		cancel()
+	})
+	return req.WithContext(reqCtx), watch
+}
+
+// err returns the error the request failed with: ErrStalled if it was canceled for stalling, and err otherwise.
+func (watch *stallWatch) err(err error) error {
+	if watch == nil || err == nil || atomic.LoadInt32(&watch.stalled) == 0 {
+		return err
+	}
+	return classifyPluginError(ErrStalled, fmt.Errorf("no data arrived from %s for %s", watch.url, watch.timeout))
+}
+
+// stop stops watching the request, and releases it.
+func (watch *stallWatch) stop() {
+	if watch != nil {
+		watch.timer.Stop()
+		watch.cancel()
+	}
+}
+
+// wrap returns body, which is stopped once it's closed. The watch only runs while body is being read, so the time
+// the reader spends on what it has already read isn't taken for a stall.
+func (watch *stallWatch) wrap(body io.ReadCloser) io.ReadCloser {
+	if watch == nil {
+		return body
+	}
+	watch.timer.Stop()
+	return &stallWatchingReader{watch: watch, body: body}
+}
+
+type stallWatchingReader struct {
+	watch *stallWatch
+	body  io.ReadCloser
+}
+
+func (r *stallWatchingReader) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&r.watch.stalled) == 0 {
+		r.watch.timer.Reset(r.watch.timeout)
+	}
+	n, err := r.body.Read(p)
+	r.watch.timer.Stop()
+	return n, r.watch.err(err)
+}
+
+func (r *stallWatchingReader) Close() error {
+	err := r.body.Close()
+	r.watch.stop()
+	return err
+}
+
+// watchDownload returns the plugin tarball tgz, which DownloadFromMirror downloaded after skipping the given number of
+// mirrors, sending PluginDownloadHeartbeat events while it's read, and downloading it again if it stalls.
+func (ctx *Context) watchDownload(info PluginInfo, tgz io.ReadCloser, length int64, skip int) (io.ReadCloser, error) {
+	timeout, err := ctx.pluginStallTimeout()
+	if err != nil {
+		contract.IgnoreClose(tgz)
+		return nil, err
+	}
+	heartbeats := len(pluginHooksFor(PluginDownloadHeartbeat)) > 0
+	if timeout == 0 && !heartbeats {
+		return tgz, nil
+	}
+
+	r := &heartbeatReader{
+		ctx:      ctx,
+		info:     info,
+		body:     tgz,
+		length:   length,
+		skip:     skip,
+		digest:   sha256.New(),
+		lastRead: time.Now(),
+		done:     make(chan struct{}),
+	}
+	if heartbeats {
+		go r.beat(pluginHeartbeatIntervalFor(timeout))
+	}
+	return r, nil
+}
+
+// heartbeatReader reads a plugin tarball, sending heartbeats as it does, and downloading it again if it stalls. The
+// part that was already read is skipped once it's downloaded again, and must be the same.
+type heartbeatReader struct {
+	ctx    *Context
+	info   PluginInfo
+	body   io.ReadCloser
+	length int64
+	// skip is the number of mirrors that were skipped for the download being read.
+	skip    int
+	retries int
+	digest  hash.Hash
+	// err is the error downloading the plugin again failed with, if it did.
+	err error
+
+	m        sync.Mutex
+	received int64
+	lastRead time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// beat sends a heartbeat every interval until the download is closed.
+func (r *heartbeatReader) beat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			r.m.Lock()
+			received, idle := r.received, now.Sub(r.lastRead)
+			r.m.Unlock()
+			runAfterPluginHooks(PluginHookEvent{
+				Phase:    PluginDownloadHeartbeat,
+				Plugin:   r.info,
+				Size:     r.length,
+				Received: received,
+				Idle:     idle,
+			})
+		}
+	}
+}
+
+func (r *heartbeatReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	for {
+		n, err := r.body.Read(p)
+		if n > 0 {
+			r.digest.Write(p[:n])
+			r.m.Lock()
+			r.received, r.lastRead = r.received+int64(n), time.Now()
+			r.m.Unlock()
+		}
+		if err == nil || !errors.Is(err, ErrStalled) || r.retries == pluginStallRetries {
+			return n, err
+		}
+		if r.err = r.redownload(err); r.err != nil {
+			return n, r.err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// redownload abandons the stalled download, and downloads the plugin again, from the next mirror if there is one,
+// skipping the part of it that was already read.
+func (r *heartbeatReader) redownload(stalled error) error {
+	r.retries++
+	contract.IgnoreClose(r.body)
+	if r.skip < len(r.info.Mirrors()) {
+		r.skip++
+	}
+	r.info.warnf("%v; downloading plugin %s again (attempt %d of %d)", stalled, r.info, r.retries+1,
+		pluginStallRetries+1)
+
+	tgz, _, err := r.ctx.downloadFromMirror(r.info, r.skip)
+	if err != nil {
+		r.body = nil
+		return err
+	}
+	r.body = tgz
+
+	r.m.Lock()
+	received := r.received
+	r.m.Unlock()
+	skipped := sha256.New()
+	if _, err := io.CopyN(skipped, tgz, received); err != nil {
+		return fmt.Errorf("downloading plugin %s again: %w", r.info, err)
+	}
+	if !bytes.Equal(skipped.Sum(nil), r.digest.Sum(nil)) {
+		return fmt.Errorf("downloading plugin %s again: the download differs from the part that was already read",
+			r.info)
+	}
+	return nil
+}
+
+func (r *heartbeatReader) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
@@ -0,0 +1,158 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// urlSource downloads every version of a plugin from a URL.
+type urlSource struct {
+	url string
+}
+
+func (s *urlSource) GetLatestVersion(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *urlSource) Download(version semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequest("GET", s.url, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	return getHTTPResponse(req)
+}
+
+// serveStalling serves the bodies in turn, stalling once half of each of the first stalls of them is written.
+func serveStalling(t *testing.T, stalls int, bodies ...string) *httptest.Server {
+	var m sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		i := requests
+		requests++
+		m.Unlock()
+		w.Header().Set("Content-Length", strconv.Itoa(len(bodies[i])))
+		if i >= stalls {
+			_, err := w.Write([]byte(bodies[i]))
+			assert.NoError(t, err)
+			return
+		}
+		_, err := w.Write([]byte(bodies[i][:len(bodies[i])/2]))
+		assert.NoError(t, err)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+//nolint:paralleltest // mutates environment variables and registers hooks
+func TestDownloadStallRetry(t *testing.T) {
+	t.Setenv(PluginStallTimeoutEnvVar, "200ms")
+
+	var m sync.Mutex
+	var heartbeats []PluginHookEvent
+	unregister := RegisterPluginHook(PluginDownloadHeartbeat, func(event PluginHookEvent) error {
+		m.Lock()
+		defer m.Unlock()
+		heartbeats = append(heartbeats, event)
+		return nil
+	})
+	defer unregister()
+
+	download := func(server *httptest.Server) (string, error) {
+		ctx := &Context{
+			Home:         t.TempDir(),
+			PluginDir:    t.TempDir(),
+			PluginSource: func(PluginInfo) PluginSource { return &urlSource{url: server.URL} },
+		}
+		v := semver.MustParse("1.0.0")
+		info, err := ctx.Plugin(PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &v})
+		require.NoError(t, err)
+		tgz, _, err := ctx.Download(info)
+		require.NoError(t, err)
+		defer func() { assert.NoError(t, tgz.Close()) }()
+		b, err := ioutil.ReadAll(tgz)
+		return string(b), err
+	}
+
+	// The stalled download is abandoned and downloaded again, skipping the part that was already read.
+	body, err := download(serveStalling(t, 1, "0123456789", "0123456789"))
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", body)
+
+	// Heartbeats were sent while the download was stalled.
+	m.Lock()
+	var stalled *PluginHookEvent
+	for i, event := range heartbeats {
+		if event.Received == 5 && event.Idle >= 100*time.Millisecond {
+			stalled = &heartbeats[i]
+		}
+	}
+	m.Unlock()
+	require.NotNil(t, stalled)
+	assert.Equal(t, "mock", stalled.Plugin.Name)
+	assert.Equal(t, int64(10), stalled.Size)
+
+	// The part that was already read must be downloaded again unchanged.
+	_, err = download(serveStalling(t, 1, "0123456789", "abcdefghij"))
+	assert.EqualError(t, err, "downloading plugin mock-1.0.0 again: the download differs from the part that was "+
+		"already read")
+
+	// Downloads that keep stalling fail.
+	_, err = download(serveStalling(t, 4, "0123456789", "0123456789", "0123456789", "0123456789"))
+	assert.True(t, errors.Is(err, ErrStalled), "%v", err)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestPluginStallTimeout(t *testing.T) {
+	t.Setenv(PluginStallTimeoutEnvVar, "")
+
+	ctx := &Context{Home: t.TempDir()}
+	timeout, err := ctx.pluginStallTimeout()
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPluginStallTimeout, timeout)
+
+	// The plugin config is only read once, so it's written to another home.
+	ctx = &Context{Home: t.TempDir()}
+	writePluginConfig(t, ctx.Home, "stallTimeout: off\n")
+	timeout, err = ctx.pluginStallTimeout()
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), timeout)
+
+	t.Setenv(PluginStallTimeoutEnvVar, "90s")
+	timeout, err = ctx.pluginStallTimeout()
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, timeout)
+
+	t.Setenv(PluginStallTimeoutEnvVar, "-1s")
+	_, err = ctx.pluginStallTimeout()
+	assert.EqualError(t, err, `PULUMI_PLUGIN_STALL_TIMEOUT: "-1s" is not a positive duration, such as 90s, or off`)
+}
@@ -0,0 +1,336 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/blang/semver"
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// TerraformRegistryScheme prefixes the PluginDownloadURL of plugins that are downloaded from a Terraform registry,
+// e.g. `terraform://registry.terraform.io/hashicorp/random`. The provider's release archive is verified against the
+// registry's signed checksums and repackaged as a plugin tarball holding the provider's files, ready for a
+// dynamically bridged provider to load.
+const TerraformRegistryScheme = "terraform://"
+
+// terraformRegistrySource downloads Terraform providers using the Terraform Registry provider protocol.
+type terraformRegistrySource struct {
+	name string
+	kind PluginKind
+
+	scheme       string
+	host         string
+	namespace    string
+	providerType string
+}
+
+// newTerraformRegistrySource returns a source for the provider named by a `terraform://host/namespace/type`
+// PluginDownloadURL.
+func newTerraformRegistrySource(name string, kind PluginKind, downloadURL string) (*terraformRegistrySource, error) {
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(downloadURL, TerraformRegistryScheme), "/"), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("expected Terraform registry URL to be %shost/namespace/type; got %q",
+			TerraformRegistryScheme, downloadURL)
+	}
+	return &terraformRegistrySource{
+		name:         name,
+		kind:         kind,
+		scheme:       "https",
+		host:         parts[0],
+		namespace:    parts[1],
+		providerType: parts[2],
+	}, nil
+}
+
+// providersURL returns the base URL of the registry's provider API, found with Terraform's service discovery.
+func (source *terraformRegistrySource) providersURL(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*url.URL, error) {
+	base := &url.URL{Scheme: source.scheme, Host: source.host, Path: "/"}
+	discovery, err := base.Parse("/.well-known/terraform.json")
+	if err != nil {
+		return nil, err
+	}
+	var services struct {
+		ProvidersV1 string `json:"providers.v1"`
+	}
+	if err := getTerraformRegistryJSON(discovery.String(), &services, getHTTPResponse); err != nil {
+		return nil, fmt.Errorf("discovering services of Terraform registry %s: %w", source.host, err)
+	}
+	if services.ProvidersV1 == "" {
+		return nil, fmt.Errorf("Terraform registry %s does not support the provider registry protocol", source.host)
+	}
+	providers, err := base.Parse(services.ProvidersV1)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(providers.Path, "/") {
+		providers.Path += "/"
+	}
+	return providers, nil
+}
+
+// providerURL returns the URL of the given path under the provider in the registry's provider API.
+func (source *terraformRegistrySource) providerURL(path string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (string, error) {
+	providers, err := source.providersURL(getHTTPResponse)
+	if err != nil {
+		return "", err
+	}
+	u, err := providers.Parse(fmt.Sprintf("%s/%s/%s", source.namespace, source.providerType, path))
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (source *terraformRegistrySource) GetLatestVersion(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
+	versions, err := source.ListVersions(getHTTPResponse)
+	if err != nil {
+		return nil, err
+	}
+	latest := latestReleasedVersion(versions)
+	if latest == nil {
+		return nil, classifyPluginError(ErrNotFound, fmt.Errorf("no versions of %s/%s found in Terraform registry %s",
+			source.namespace, source.providerType, source.host))
+	}
+	return latest, nil
+}
+
+// terraformProviderPackage is the Terraform registry's description of a provider release for a platform.
+type terraformProviderPackage struct {
+	Filename            string `json:"filename"`
+	DownloadURL         string `json:"download_url"`
+	SHASumsURL          string `json:"shasums_url"`
+	SHASumsSignatureURL string `json:"shasums_signature_url"`
+	SHASum              string `json:"shasum"`
+	SigningKeys         struct {
+		GPGPublicKeys []struct {
+			KeyID      string `json:"key_id"`
+			ASCIIArmor string `json:"ascii_armor"`
+		} `json:"gpg_public_keys"`
+	} `json:"signing_keys"`
+}
+
+// ListVersions implements versionListingSource, using the versions of the provider the registry lists.
+func (source *terraformRegistrySource) ListVersions(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, error) {
+	versionsURL, err := source.providerURL("versions", getHTTPResponse)
+	if err != nil {
+		return nil, err
+	}
+	var listed struct {
+		Versions []struct {
+			Version string `json:"version"`
+		} `json:"versions"`
+	}
+	if err := getTerraformRegistryJSON(versionsURL, &listed, getHTTPResponse); err != nil {
+		return nil, err
+	}
+	versions := make([]semver.Version, 0, len(listed.Versions))
+	for _, v := range listed.Versions {
+		if version, err := semver.ParseTolerant(v.Version); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
+
+func (source *terraformRegistrySource) Download(
+	version semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	packageURL, err := source.providerURL(fmt.Sprintf("%s/download/%s/%s", version, opSy, arch), getHTTPResponse)
+	if err != nil {
+		return nil, -1, err
+	}
+	var pkg terraformProviderPackage
+	if err := getTerraformRegistryJSON(packageURL, &pkg, getHTTPResponse); err != nil {
+		return nil, -1, err
+	}
+
+	// The registry publishes a checksum of the release archive, but we only trust it if it matches the checksums file
+	// signed by one of the provider's signing keys.
+	if err := source.verifySHASum(pkg, getHTTPResponse); err != nil {
+		return nil, -1, err
+	}
+
+	logf(1, sourceLogFields(source.kind, source.name), "%s downloading from %s", source.name, pkg.DownloadURL)
+	archive, err := getTerraformRegistryBytes(pkg.DownloadURL, getHTTPResponse)
+	if err != nil {
+		return nil, -1, err
+	}
+	sum := sha256.Sum256(archive)
+	if actual := hex.EncodeToString(sum[:]); actual != strings.ToLower(pkg.SHASum) {
+		return nil, -1, checksumMismatchError(pkg.Filename, pkg.SHASum, actual)
+	}
+
+	tarball, extracted, err := zipToTGZ(archive)
+	if err != nil {
+		return nil, -1, fmt.Errorf("repackaging %s: %w", pkg.Filename, err)
+	}
+	return withExtractedSize(ioutil.NopCloser(bytes.NewReader(tarball)), extracted), int64(len(tarball)), nil
+}
+
+// verifySHASum checks that the package's checksum is listed in its checksums file, and that the checksums file is
+// signed by one of its signing keys.
+func (source *terraformRegistrySource) verifySHASum(pkg terraformProviderPackage,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) error {
+	var keyring openpgp.EntityList
+	for _, key := range pkg.SigningKeys.GPGPublicKeys {
+		entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key.ASCIIArmor))
+		if err != nil {
+			return fmt.Errorf("reading signing key %s: %w", key.KeyID, err)
+		}
+		keyring = append(keyring, entities...)
+	}
+	if len(keyring) == 0 {
+		return fmt.Errorf("Terraform registry %s lists no signing keys for %s", source.host, pkg.Filename)
+	}
+
+	shasums, err := getTerraformRegistryBytes(pkg.SHASumsURL, getHTTPResponse)
+	if err != nil {
+		return err
+	}
+	signature, err := getTerraformRegistryBytes(pkg.SHASumsSignatureURL, getHTTPResponse)
+	if err != nil {
+		return err
+	}
+	_, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(shasums), bytes.NewReader(signature))
+	if err != nil {
+		return fmt.Errorf("verifying signature of %s: %w", pkg.SHASumsURL, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(shasums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == pkg.Filename {
+			if !strings.EqualFold(fields[0], pkg.SHASum) {
+				return classifyPluginError(ErrChecksumMismatch,
+					fmt.Errorf("checksum of %s in %s does not match the registry", pkg.Filename, pkg.SHASumsURL))
+			}
+			return nil
+		}
+	}
+	return classifyPluginError(ErrChecksumMismatch, fmt.Errorf("%s is not listed in %s", pkg.Filename, pkg.SHASumsURL))
+}
+
+func getTerraformRegistryBytes(endpoint string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]byte, error) {
+	req, err := buildHTTPRequest(endpoint, "")
+	if err != nil {
+		return nil, err
+	}
+	resp, _, err := getHTTPResponse(req)
+	if err != nil {
+		return nil, err
+	}
+	defer contract.IgnoreClose(resp)
+	return ioutil.ReadAll(resp)
+}
+
+func getTerraformRegistryJSON(endpoint string, v interface{},
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) error {
+	body, err := getTerraformRegistryBytes(endpoint, getHTTPResponse)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("parsing response from %s: %w", endpoint, err)
+	}
+	return nil
+}
+
+// zipToTGZ converts a zip archive, as Terraform providers are distributed in, into a .tar.gz. Zip archives built on
+// Windows often lack Unix file modes, so the provider executable is always marked executable. The number of bytes
+// the archive's files take up once extracted is returned alongside the tarball.
+func zipToTGZ(b []byte) ([]byte, int64, error) {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var buf bytes.Buffer
+	var extracted int64
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		mode := f.Mode().Perm()
+		if strings.HasPrefix(f.Name, "terraform-provider-") {
+			mode |= 0755
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     f.Name,
+			Mode:     int64(mode),
+			Size:     int64(f.UncompressedSize64),
+			ModTime:  f.Modified,
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			return nil, 0, err
+		}
+		extracted += int64(f.UncompressedSize64)
+		r, err := f.Open()
+		if err != nil {
+			return nil, 0, err
+		}
+		_, err = io.Copy(tw, r) //nolint:gosec // the archive's checksum has been verified
+		contract.IgnoreClose(r)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, 0, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), extracted, nil
+}
+
+// errorSource is returned by GetSource for plugins whose source is misconfigured.
+type errorSource struct {
+	err error
+}
+
+func (source *errorSource) GetLatestVersion(
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
+	return nil, source.err
+}
+
+func (source *errorSource) Download(
+	version semver.Version, opSy string, arch string,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	return nil, -1, source.err
+}
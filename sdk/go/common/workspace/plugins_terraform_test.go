@@ -0,0 +1,143 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+func TestNewTerraformRegistrySource(t *testing.T) {
+	t.Parallel()
+
+	source, err := newTerraformRegistrySource(
+		"random", ResourcePlugin, "terraform://registry.terraform.io/hashicorp/random/")
+	require.NoError(t, err)
+	assert.Equal(t, "registry.terraform.io", source.host)
+	assert.Equal(t, "hashicorp", source.namespace)
+	assert.Equal(t, "random", source.providerType)
+
+	_, err = newTerraformRegistrySource("random", ResourcePlugin, "terraform://registry.terraform.io/random")
+	assert.Error(t, err)
+
+	info := PluginInfo{Name: "random", Kind: ResourcePlugin, PluginDownloadURL: "terraform://example.com/acme/random"}
+	assert.IsType(t, &terraformRegistrySource{}, info.GetSource())
+	info.PluginDownloadURL = "terraform://example.com"
+	assert.IsType(t, &errorSource{}, info.GetSource())
+}
+
+// armoredPublicKey returns the ASCII-armored public key of the entity.
+func armoredPublicKey(t *testing.T, entity *openpgp.Entity) string {
+	var b bytes.Buffer
+	w, err := armor.Encode(&b, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+	return b.String()
+}
+
+func TestTerraformRegistrySource(t *testing.T) {
+	t.Parallel()
+
+	signer, err := openpgp.NewEntity("Test Provider", "", "provider@example.com", nil)
+	require.NoError(t, err)
+	impostor, err := openpgp.NewEntity("Impostor", "", "impostor@example.com", nil)
+	require.NoError(t, err)
+
+	// Build a provider release the way the registry serves it.
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	f, err := zw.Create("terraform-provider-random_v3.1.0")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("provider binary"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	sum := sha256.Sum256(zipped.Bytes())
+	shasum := hex.EncodeToString(sum[:])
+
+	filename := "terraform-provider-random_3.1.0_linux_amd64.zip"
+	shasums := fmt.Sprintf("%s  %s\n", shasum, filename)
+	sign := func(entity *openpgp.Entity) string {
+		var sig bytes.Buffer
+		require.NoError(t, openpgp.DetachSign(&sig, entity, bytes.NewReader([]byte(shasums)), nil))
+		return sig.String()
+	}
+
+	pkg := map[string]interface{}{
+		"filename":              filename,
+		"download_url":          "https://releases.example.com/" + filename,
+		"shasums_url":           "https://releases.example.com/SHA256SUMS",
+		"shasums_signature_url": "https://releases.example.com/SHA256SUMS.sig",
+		"shasum":                shasum,
+		"signing_keys": map[string]interface{}{
+			"gpg_public_keys": []interface{}{
+				map[string]interface{}{"key_id": "ABCD", "ascii_armor": armoredPublicKey(t, signer)},
+			},
+		},
+	}
+	pkgJSON, err := json.Marshal(pkg)
+	require.NoError(t, err)
+
+	bodies := map[string]string{
+		"https://registry.example.com/.well-known/terraform.json": `{"providers.v1": "/v1/providers/"}`,
+		"https://registry.example.com/v1/providers/acme/random/versions": `{"versions": [
+			{"version": "3.0.0"}, {"version": "3.1.0"}, {"version": "4.0.0-beta1"}
+		]}`,
+		"https://registry.example.com/v1/providers/acme/random/3.1.0/download/linux/amd64": string(pkgJSON),
+		"https://releases.example.com/" + filename:                                         zipped.String(),
+		"https://releases.example.com/SHA256SUMS":                                          shasums,
+		"https://releases.example.com/SHA256SUMS.sig":                                      sign(signer),
+	}
+
+	source, err := newTerraformRegistrySource("random", ResourcePlugin, "terraform://registry.example.com/acme/random")
+	require.NoError(t, err)
+
+	latest, err := source.GetLatestVersion(serveURLs(bodies))
+	require.NoError(t, err)
+	assert.Equal(t, semver.MustParse("3.1.0"), *latest)
+
+	r, _, err := source.Download(semver.MustParse("3.1.0"), "linux", "amd64", serveURLs(bodies))
+	require.NoError(t, err)
+	gr, err := gzip.NewReader(r)
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+	header, err := tr.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "terraform-provider-random_v3.1.0", header.Name)
+	assert.Equal(t, int64(0755), header.Mode&0755)
+	contents, err := ioutil.ReadAll(tr)
+	require.NoError(t, err)
+	assert.Equal(t, "provider binary", string(contents))
+
+	// Checksums signed by a key the registry doesn't list are rejected.
+	bodies["https://releases.example.com/SHA256SUMS.sig"] = sign(impostor)
+	_, _, err = source.Download(semver.MustParse("3.1.0"), "linux", "amd64", serveURLs(bodies))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "verifying signature of https://releases.example.com/SHA256SUMS")
+}
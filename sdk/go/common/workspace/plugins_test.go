@@ -15,19 +15,80 @@
 package workspace
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
 	"testing"
 
 	"github.com/blang/semver"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// createTGZ creates an in-memory tarball.
+func createTGZ(files map[string][]byte) ([]byte, error) {
+	return createTGZWithMode(files, 0600)
+}
+
+// createTGZWithMode creates an in-memory tarball whose files have the given mode, such as 0700 for the plugins in it
+// to be run.
+func createTGZWithMode(files map[string][]byte, mode int64) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	gw := gzip.NewWriter(buffer)
+	writer := tar.NewWriter(gw)
+
+	for name, content := range files {
+		if err := writer.WriteHeader(&tar.Header{
+			Name: name,
+			Size: int64(len(content)),
+			Mode: mode,
+		}); err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(content); err != nil {
+			return nil, err
+		}
+	}
+
+	// Close the tar and gzip writers to flush and write footers.
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// mockPluginTGZ returns the bytes of a tarball of the mock resource plugin.
+func mockPluginTGZ(t *testing.T) []byte {
+	tgz, err := createTGZWithMode(map[string][]byte{
+		"pulumi-resource-mock":     nil,
+		"pulumi-resource-mock.exe": nil,
+		"data":                     bytes.Repeat([]byte("x"), 4096),
+	}, 0700)
+	require.NoError(t, err)
+	return tgz
+}
+
+// newMockPlugin returns version 1.0.0 of the mock resource plugin, to be installed into a temporary directory.
+func newMockPlugin(t *testing.T) (string, PluginInfo) {
+	dir := t.TempDir()
+	v := semver.MustParse("1.0.0")
+	return dir, PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &v, PluginDir: dir}
+}
+
 func TestPluginSelection_ExactMatch(t *testing.T) {
 	t.Parallel()
 
@@ -491,9 +552,36 @@ func TestPluginGetLatestVersion(t *testing.T) {
 			Kind:              PluginKind("resource"),
 		}
 		source := info.GetSource()
+		getHTTPResponse := func(req *http.Request) (io.ReadCloser, int64, error) {
+			assert.Equal(t, "https://customurl.jfrog.io/artifactory/pulumi-packages/package-name/index.json",
+				req.URL.String())
+			return newMockReadCloserString(`{
+				"versions": [{"version": "1.2.0"}, {"version": "1.10.0"}, {"version": "2.0.0-beta.1"}]
+			}`)
+		}
 		version, err := source.GetLatestVersion(getHTTPResponse)
+		require.NoError(t, err)
+		assert.Equal(t, "1.10.0", version.String())
+
+		// Servers that don't host an index don't support GetLatestVersion.
+		getHTTPResponse = func(req *http.Request) (io.ReadCloser, int64, error) {
+			return nil, -1, &HTTPError{StatusCode: http.StatusNotFound, URL: req.URL.String()}
+		}
+		version, err = source.GetLatestVersion(getHTTPResponse)
 		assert.Nil(t, version)
-		assert.Equal(t, "GetLatestVersion is not supported for plugins using PluginDownloadURL", err.Error())
+		assert.EqualError(t, err, "GetLatestVersion is not supported for plugins using PluginDownloadURL unless the "+
+			"server hosts an index of their versions at "+
+			"https://customurl.jfrog.io/artifactory/pulumi-packages/package-name/index.json")
+	})
+	t.Run("Test GetLatestVersion From Versioned Custom Server URL", func(t *testing.T) {
+		indexURL, err := pluginURLIndexURL("https://plugins.example.com/${OS}/v${VERSION}/${ARCH}", ResourcePlugin, "a")
+		require.NoError(t, err)
+		assert.Equal(t, "https://plugins.example.com/"+runtime.GOOS+"/index.json", indexURL)
+		_, err = pluginURLIndexURL("https://v${VERSION}.plugins.example.com", ResourcePlugin, "a")
+		assert.Error(t, err)
+		indexURL, err = pluginURLIndexURL("https://plugins.example.com/${KIND}/${NAME}/${FILENAME}", ResourcePlugin, "a")
+		require.NoError(t, err)
+		assert.Equal(t, "https://plugins.example.com/resource/a/index.json", indexURL)
 	})
 	t.Run("Test GetLatestVersion From GitHub Private Releases", func(t *testing.T) {
 		os.Setenv("PULUMI_EXPERIMENTAL", "true")
@@ -560,16 +648,54 @@ func TestInterpolateURL(t *testing.T) {
 	version := semver.MustParse("1.0.0")
 	const os = "linux"
 	const arch = "amd64"
-	assert.Equal(t, "", interpolateURL("", version, os, arch))
+	const kind = ResourcePlugin
+	const name = "aws"
+	assert.Equal(t, "", interpolateURL("", kind, name, version, os, arch))
 	assert.Equal(t,
 		"https://get.pulumi.com/releases/plugins",
-		interpolateURL("https://get.pulumi.com/releases/plugins", version, os, arch))
+		interpolateURL("https://get.pulumi.com/releases/plugins", kind, name, version, os, arch))
 	assert.Equal(t,
 		"https://github.com/org/repo/releases/download/1.0.0",
-		interpolateURL("https://github.com/org/repo/releases/download/${VERSION}", version, os, arch))
+		interpolateURL("https://github.com/org/repo/releases/download/${VERSION}", kind, name, version, os, arch))
 	assert.Equal(t,
 		"https://github.com/org/repo/releases/download/1.0.0/linux/amd64",
-		interpolateURL("https://github.com/org/repo/releases/download/${VERSION}/${OS}/${ARCH}", version, os, arch))
+		interpolateURL("https://github.com/org/repo/releases/download/${VERSION}/${OS}/${ARCH}",
+			kind, name, version, os, arch))
+	assert.Equal(t,
+		"https://store.corp/resource/aws/1.0.0/pulumi-resource-aws-v1.0.0-linux-amd64.tar.gz",
+		interpolateURL("https://store.corp/${KIND}/${NAME}/${VERSION}/${FILENAME}", kind, name, version, os, arch))
+}
+
+func TestSplitPluginURL(t *testing.T) {
+	t.Parallel()
+
+	version := semver.MustParse("1.0.0+build.2")
+	tests := []struct {
+		downloadURL string
+		dir         string
+		file        string
+	}{
+		{"https://store.corp/plugins/", "https://store.corp/plugins",
+			"pulumi-resource-aws-v1.0.0+build.2-linux-amd64.tar.gz"},
+		{"https://store.corp/${NAME}/${FILENAME}", "https://store.corp/aws",
+			"pulumi-resource-aws-v1.0.0+build.2-linux-amd64.tar.gz"},
+		{"https://store.corp/${NAME}/${VERSION}/${NAME}-${OS}-${ARCH}.tgz?token=t",
+			"https://store.corp/aws/1.0.0%2Bbuild.2?token=t", "aws-linux-amd64.tgz"},
+		{"s3://bucket/${NAME}-v${VERSION}.tar.gz", "s3://bucket", "aws-v1.0.0+build.2.tar.gz"},
+	}
+	for _, tt := range tests {
+		dir, file := splitPluginURL(tt.downloadURL, ResourcePlugin, "aws", version, "linux", "amd64")
+		assert.Equal(t, tt.dir, dir, tt.downloadURL)
+		assert.Equal(t, tt.file, file, tt.downloadURL)
+	}
+
+	// Custom file names can't be listed to find the latest version.
+	_, err := bucketListingURL("s3://bucket/${NAME}/${NAME}.tgz", ResourcePlugin, "aws")
+	assert.EqualError(t, err, "the latest version of plugins can't be found in s3://bucket/${NAME}/${NAME}.tgz, "+
+		"since it names their tarballs its own way")
+	listingURL, err := bucketListingURL("s3://bucket/${KIND}/${NAME}/${FILENAME}", ResourcePlugin, "aws")
+	require.NoError(t, err)
+	assert.Equal(t, "s3://bucket/resource/aws", listingURL)
 }
 
 func TestParsePluginDownloadURLOverride(t *testing.T) {
@@ -718,7 +844,7 @@ func TestParsePluginDownloadURLOverride(t *testing.T) {
 
 			if len(tt.matches) > 0 {
 				for _, match := range tt.matches {
-					actualURL, actualOK := actual.get(match.name)
+					actualURL, actualOK := actual.get(ResourcePlugin, match.name, nil)
 					assert.Equal(t, match.url, actualURL)
 					assert.Equal(t, match.ok, actualOK)
 				}
@@ -727,6 +853,99 @@ func TestParsePluginDownloadURLOverride(t *testing.T) {
 	}
 }
 
+func TestPluginDownloadURLOverrideSelectors(t *testing.T) {
+	t.Parallel()
+
+	overrides, err := parsePluginDownloadURLOverrides(
+		"resource/aws@>=5.0.0=https://mirror/aws5,language/.*=https://languages,^aws$=https://mirror/aws?a=b")
+	require.NoError(t, err)
+	require.Len(t, overrides, 3)
+	assert.Equal(t, ResourcePlugin, overrides[0].kind)
+	assert.Equal(t, "aws", overrides[0].reg.String())
+	assert.Equal(t, "https://mirror/aws?a=b", overrides[2].url)
+
+	v4, v5 := semver.MustParse("4.9.0"), semver.MustParse("5.1.0")
+	tests := []struct {
+		kind    PluginKind
+		name    string
+		version *semver.Version
+		url     string
+	}{
+		{ResourcePlugin, "aws", &v5, "https://mirror/aws5"},
+		{ResourcePlugin, "aws", &v4, "https://mirror/aws?a=b"},
+		{ResourcePlugin, "aws", nil, "https://mirror/aws?a=b"},
+		{LanguagePlugin, "aws", &v5, "https://languages"},
+		{LanguagePlugin, "nodejs", nil, "https://languages"},
+		{AnalyzerPlugin, "policy", &v5, ""},
+	}
+	for _, tt := range tests {
+		url, ok := overrides.get(tt.kind, tt.name, tt.version)
+		assert.Equal(t, tt.url, url, "%s/%s@%v", tt.kind, tt.name, tt.version)
+		assert.Equal(t, tt.url != "", ok)
+	}
+
+	_, err = parsePluginDownloadURLOverrides("aws@>=five=https://mirror")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid version range")
+	_, err = parsePluginDownloadURLOverrides("resource/@>=5.0.0=https://mirror")
+	assert.Error(t, err)
+}
+
+func TestPluginDownloadURLOverrideSeparators(t *testing.T) {
+	t.Parallel()
+
+	// Selectors with `=` or `://` in them are split at the `=` before the URL's scheme.
+	overrides, err := parsePluginDownloadURLOverrides(
+		"^(?:a=b|c)$=https://mirror/ac,^https://=s3://bucket,resource/aws@>=5.0.0=gs://mirror/aws5")
+	require.NoError(t, err)
+	require.Len(t, overrides, 3)
+	assert.Equal(t, "^(?:a=b|c)$", overrides[0].reg.String())
+	assert.Equal(t, "https://mirror/ac", overrides[0].url)
+	assert.Equal(t, "^https://", overrides[1].reg.String())
+	assert.Equal(t, "s3://bucket", overrides[1].url)
+	assert.Equal(t, "aws", overrides[2].reg.String())
+	assert.Equal(t, "gs://mirror/aws5", overrides[2].url)
+
+	// Selectors that would be split in more than one place must be quoted.
+	_, err = parsePluginDownloadURLOverrides("^(?:a=https://)=https://mirror")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "quote its selector")
+	_, err = parsePluginDownloadURLOverrides("aws=https://mirror/?from=https://github.com")
+	assert.Error(t, err)
+
+	overrides, err = parsePluginDownloadURLOverrides(
+		`"^(?:a=https://)"=https://mirror,resource/"aws"@>=5.0.0=https://mirror/?from=https://github.com,"a=b"=mirror,` +
+			`"^org@.*$"=https://mirror/org,resource/"^org@v1$"@>=1.0.0 <2.0.0=mirror/org1`)
+	require.NoError(t, err)
+	require.Len(t, overrides, 5)
+	assert.Equal(t, "^(?:a=https://)", overrides[0].reg.String())
+	assert.Equal(t, "https://mirror", overrides[0].url)
+	assert.Equal(t, ResourcePlugin, overrides[1].kind)
+	assert.Equal(t, "aws", overrides[1].reg.String())
+	assert.True(t, overrides[1].versions(semver.MustParse("5.1.0")))
+	assert.False(t, overrides[1].versions(semver.MustParse("4.0.0")))
+	assert.Equal(t, "https://mirror/?from=https://github.com", overrides[1].url)
+	assert.Equal(t, "a=b", overrides[2].reg.String())
+	assert.Equal(t, "mirror", overrides[2].url)
+
+	// An `@` inside a quoted regexp is part of the regexp, not a version range.
+	assert.Equal(t, "^org@.*$", overrides[3].reg.String())
+	assert.Nil(t, overrides[3].versions)
+	assert.Equal(t, "https://mirror/org", overrides[3].url)
+	assert.Equal(t, ResourcePlugin, overrides[4].kind)
+	assert.Equal(t, "^org@v1$", overrides[4].reg.String())
+	assert.True(t, overrides[4].versions(semver.MustParse("1.2.0")))
+	assert.False(t, overrides[4].versions(semver.MustParse("2.0.0")))
+	assert.Equal(t, "mirror/org1", overrides[4].url)
+
+	for _, invalid := range []string{
+		`"aws=https://mirror`, `"aws"https://mirror`, `"aws"=`, `"aws"@=https://mirror`, `"aws"@>=five=https://mirror`,
+	} {
+		_, err = parsePluginDownloadURLOverrides(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
 func TestMissingErrorText(t *testing.T) {
 	t.Parallel()
 
@@ -803,3 +1022,105 @@ func TestMissingErrorText(t *testing.T) {
 		})
 	}
 }
+
+func TestMissingErrorInstalledVersions(t *testing.T) {
+	t.Parallel()
+
+	v := func(s string) *semver.Version {
+		version := semver.MustParse(s)
+		return &version
+	}
+	installed := []PluginInfo{
+		{Name: "myplugin", Kind: ResourcePlugin, Version: v("1.4.0")},
+		{Name: "myplugin", Kind: ResourcePlugin, Version: v("0.9.0")},
+		{Name: "myplugin", Kind: ResourcePlugin, Version: v("2.0.0")},
+		{Name: "myplugin", Kind: LanguagePlugin, Version: v("1.3.0")},
+		{Name: "other", Kind: ResourcePlugin, Version: v("1.3.0")},
+	}
+
+	err := newMissingErrorWithInstalled(
+		PluginInfo{Name: "myplugin", Kind: ResourcePlugin, Version: v("1.2.0")}, false, installed)
+	assert.Equal(t, []semver.Version{*v("0.9.0"), *v("1.4.0"), *v("2.0.0")}, err.(*MissingError).InstalledVersions)
+	assert.EqualError(t, err, "no resource plugin 'pulumi-resource-myplugin' found in the workspace at version v1.2.0, "+
+		"install the plugin using `pulumi plugin install resource myplugin v1.2.0`; "+
+		"other installed versions: v0.9.0, v1.4.0, v2.0.0 (compatible with v1.2.0: v1.4.0)")
+
+	err = newMissingErrorWithInstalled(
+		PluginInfo{Name: "myplugin", Kind: ResourcePlugin, Version: v("3.0.0")}, false, installed)
+	assert.EqualError(t, err, "no resource plugin 'pulumi-resource-myplugin' found in the workspace at version v3.0.0, "+
+		"install the plugin using `pulumi plugin install resource myplugin v3.0.0`; "+
+		"other installed versions: v0.9.0, v1.4.0, v2.0.0 (none is compatible with v3.0.0)")
+
+	err = newMissingErrorWithInstalled(PluginInfo{Name: "myplugin", Kind: ResourcePlugin}, false, installed)
+	assert.Empty(t, err.(*MissingError).InstalledVersions)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestPluginMirrors(t *testing.T) {
+	t.Setenv(PluginIndexURLsEnvVar, "https://a.example.com,https://b.example.com")
+
+	info := PluginInfo{Name: "mock", Kind: ResourcePlugin}
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, info.Mirrors())
+
+	source, ok := info.getSource(info.Mirrors()[1:]).(*pluginIndexSource)
+	require.True(t, ok)
+	assert.Equal(t, []string{"https://b.example.com"}, source.indexURLs)
+	_, ok = info.getSource(nil).(*fallbackSource)
+	assert.True(t, ok)
+
+	// Plugins with their own download URL don't use the plugin indexes.
+	info.PluginDownloadURL = "https://example.com/plugins"
+	assert.Empty(t, info.Mirrors())
+}
+
+func TestGetHTTPResponsePreservesCancellation(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := buildHTTPRequest("https://get.pulumi.com/releases/plugins/pulumi-resource-mock.tar.gz", "")
+	require.NoError(t, err)
+	_, _, err = (&Context{}).getHTTPResponse(req.WithContext(ctx))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.Canceled), "expected %v to be context.Canceled", err)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestInstallLockPreservesFSError(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv(PulumiHomeEnvVar, home)
+	// A file where the plugin directory should be stops the plugin root from being created.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(home, "plugins"), nil, 0600))
+
+	v := semver.MustParse("1.0.0")
+	_, err := PluginInfo{Name: "mock", Kind: ResourcePlugin, Version: &v}.installLock()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "creating plugin root")
+	var pathErr *os.PathError
+	assert.True(t, errors.As(err, &pathErr), "expected %v to wrap an *os.PathError", err)
+}
+
+func TestFindPluginExecutable(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	assert.Equal(t, []string{".exe", ".cmd", ".bat", ".ps1"}, candidateExtensionsFor("windows"))
+	assert.Equal(t, []string{""}, candidateExtensionsFor("linux"))
+
+	exts := candidateExtensionsFor("windows")
+	_, ok := findPluginExecutable(dir, "pulumi-resource-foo", exts)
+	assert.False(t, ok)
+
+	err := ioutil.WriteFile(filepath.Join(dir, "pulumi-resource-foo.ps1"), nil, 0600)
+	assert.NoError(t, err)
+	path, ok := findPluginExecutable(dir, "pulumi-resource-foo", exts)
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join(dir, "pulumi-resource-foo.ps1"), path)
+
+	// Earlier extensions are preferred.
+	err = ioutil.WriteFile(filepath.Join(dir, "pulumi-resource-foo.cmd"), nil, 0600)
+	assert.NoError(t, err)
+	path, ok = findPluginExecutable(dir, "pulumi-resource-foo", exts)
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join(dir, "pulumi-resource-foo.cmd"), path)
+}
@@ -0,0 +1,87 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// PluginURLEnvAllowlistEnvVar is a comma-separated list of environment variables, in addition to those starting with
+// `PULUMI_PLUGIN_URL_`, that `${env:VAR}` references in PluginDownloadURLs may expand.
+const PluginURLEnvAllowlistEnvVar = "PULUMI_PLUGIN_URL_ENV_ALLOWLIST"
+
+// pluginURLEnvPrefix is the prefix of the environment variables PluginDownloadURLs may always expand.
+const pluginURLEnvPrefix = "PULUMI_PLUGIN_URL_"
+
+// pluginURLEnvRegexp matches `${env:VAR}` references, and `$${env:VAR}` escapes of them.
+var pluginURLEnvRegexp = regexp.MustCompile(`\$?\$\{env:([^}]*)\}`)
+
+// pluginURLEnvNameRegexp matches valid environment variable names.
+var pluginURLEnvNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// pluginURLEnvValueRegexp matches the values that may be expanded into a URL: host names, ports and path segments.
+// Anything else could change what the URL points at in ways its author didn't intend.
+var pluginURLEnvValueRegexp = regexp.MustCompile(`^[A-Za-z0-9._~:/-]+$`)
+
+// expandURLEnv replaces the `${env:VAR}` references in a PluginDownloadURL with the values of the environment
+// variables they name, so URLs embedded in SDKs can refer to hosts that differ between organizations. Only variables
+// starting with `PULUMI_PLUGIN_URL_`, or listed in `PULUMI_PLUGIN_URL_ENV_ALLOWLIST`, can be expanded, so a package
+// can't send other variables, such as credentials, to a server of its choosing. `$${env:VAR}` is left as the literal
+// `${env:VAR}`.
+func expandURLEnv(rawURL string) (string, error) {
+	allowed := map[string]bool{}
+	for _, name := range splitEnvList(os.Getenv(PluginURLEnvAllowlistEnvVar)) {
+		allowed[name] = true
+	}
+
+	var expandErr error
+	expanded := pluginURLEnvRegexp.ReplaceAllStringFunc(rawURL, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		name := pluginURLEnvRegexp.FindStringSubmatch(ref)[1]
+		value, err := pluginURLEnvValue(name, allowed)
+		if err != nil && expandErr == nil {
+			expandErr = fmt.Errorf("expanding %s in plugin download URL %s: %w", ref, rawURL, err)
+		}
+		return value
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return expanded, nil
+}
+
+// pluginURLEnvValue returns the value of the named environment variable, if it may be expanded into a URL.
+func pluginURLEnvValue(name string, allowed map[string]bool) (string, error) {
+	if !pluginURLEnvNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("%q is not a valid environment variable name", name)
+	}
+	if !strings.HasPrefix(name, pluginURLEnvPrefix) && !allowed[name] {
+		return "", fmt.Errorf("only variables starting with %s, or listed in %s, may be used",
+			pluginURLEnvPrefix, PluginURLEnvAllowlistEnvVar)
+	}
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return "", fmt.Errorf("%s is not set", name)
+	}
+	if !pluginURLEnvValueRegexp.MatchString(value) {
+		return "", fmt.Errorf("the value of %s may only contain letters, digits and any of ._~:/-", name)
+	}
+	return value, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestExpandURLEnv(t *testing.T) {
+	t.Setenv("PULUMI_PLUGIN_URL_HOST", "plugins.corp:8443")
+	t.Setenv("CORP_PLUGIN_PATH", "releases/acme")
+	t.Setenv("CORP_SECRET", "hunter2")
+	t.Setenv("CORP_QUERY", "a?b=c")
+	t.Setenv(PluginURLEnvAllowlistEnvVar, "CORP_PLUGIN_PATH, CORP_QUERY")
+
+	tests := []struct {
+		url      string
+		expected string
+		err      string
+	}{
+		{url: "https://get.pulumi.com/releases", expected: "https://get.pulumi.com/releases"},
+		{url: "https://${env:PULUMI_PLUGIN_URL_HOST}/plugins", expected: "https://plugins.corp:8443/plugins"},
+		{
+			url:      "https://${env:PULUMI_PLUGIN_URL_HOST}/${env:CORP_PLUGIN_PATH}/${VERSION}",
+			expected: "https://plugins.corp:8443/releases/acme/${VERSION}",
+		},
+		{url: "https://example.com/$${env:CORP_SECRET}", expected: "https://example.com/${env:CORP_SECRET}"},
+		{url: "https://example.com/${env:CORP_SECRET}", err: "only variables starting with PULUMI_PLUGIN_URL_"},
+		{url: "https://${env:PULUMI_PLUGIN_URL_UNSET}/plugins", err: "PULUMI_PLUGIN_URL_UNSET is not set"},
+		{url: "https://example.com/${env:CORP-PATH}", err: `"CORP-PATH" is not a valid environment variable name`},
+		{url: "https://example.com/${env:CORP_QUERY}", err: "the value of CORP_QUERY may only contain"},
+	}
+	for _, tt := range tests {
+		expanded, err := expandURLEnv(tt.url)
+		if tt.err != "" {
+			require.Error(t, err, tt.url)
+			assert.Contains(t, err.Error(), tt.err)
+			assert.NotContains(t, err.Error(), "hunter2")
+			continue
+		}
+		require.NoError(t, err, tt.url)
+		assert.Equal(t, tt.expected, expanded)
+	}
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestPluginURLSourceExpandsEnv(t *testing.T) {
+	t.Setenv("PULUMI_PLUGIN_URL_HOST", "plugins.corp")
+
+	var requested string
+	source := newPluginURLSource("acme", ResourcePlugin, "https://${env:PULUMI_PLUGIN_URL_HOST}/${VERSION}")
+	_, _, err := source.Download(semver.MustParse("1.2.3"), "linux", "amd64",
+		func(req *http.Request) (io.ReadCloser, int64, error) {
+			requested = req.URL.String()
+			return ioutil.NopCloser(bytes.NewReader(nil)), 0, nil
+		})
+	require.NoError(t, err)
+	assert.Equal(t, "https://plugins.corp/1.2.3/pulumi-resource-acme-v1.2.3-linux-amd64.tar.gz", requested)
+}
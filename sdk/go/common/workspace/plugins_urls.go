@@ -0,0 +1,81 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// dryRunMessage is the message of the errors dry runs respond to every request with.
+const dryRunMessage = "request not sent: dry run"
+
+// dryRun records the URLs of the requests a plugin source sends, without sending them. Every request gets a 404
+// response, so sources that fall back to others when a plugin isn't found go on to request it from the next source.
+type dryRun struct {
+	urls []string
+}
+
+func (run *dryRun) getHTTPResponse(req *http.Request) (io.ReadCloser, int64, error) {
+	run.urls = append(run.urls, req.URL.String())
+	return nil, -1, &HTTPError{StatusCode: http.StatusNotFound, URL: req.URL.String(), message: dryRunMessage}
+}
+
+// result returns the URLs that were recorded, and err unless it's the response to a recorded request.
+func (run *dryRun) result(err error) ([]string, error) {
+	var httpErr *HTTPError
+	if err == nil || errors.As(err, &httpErr) && httpErr.message == dryRunMessage {
+		return run.urls, nil
+	}
+	return run.urls, err
+}
+
+// PluginDownloadURLs returns the URLs that would be requested, in order, to download the plugin for the platform from
+// its source, without sending any requests. Every request is assumed to fail with a 404, so the URLs of every source
+// the plugin would be looked for in are included, e.g. each of the configured mirrors before the plugin's default
+// sources. Sources that need the response to a request to work out what to request next, such as GitHub releases
+// and Terraform registries, only contribute the first URLs they request.
+func PluginDownloadURLs(info PluginInfo, platform Platform) ([]string, error) {
+	return (&Context{}).PluginDownloadURLs(info, platform)
+}
+
+// PluginDownloadURLs returns the URLs that would be requested to download the plugin like the package-level
+// PluginDownloadURLs, using the context's plugin source if it has one.
+func (ctx *Context) PluginDownloadURLs(info PluginInfo, platform Platform) ([]string, error) {
+	if info.Version == nil {
+		return nil, fmt.Errorf("unknown version for plugin %s", info.Name)
+	}
+	run := &dryRun{}
+	source := ctx.pluginSource(info, info.Mirrors())
+	_, _, err := source.Download(*info.Version, platform.assetOS(), platform.Arch, run.getHTTPResponse)
+	return run.result(err)
+}
+
+// PluginLatestVersionURLs returns the URLs that would be requested, in order, to look up the latest version of the
+// plugin, without sending any requests, like PluginDownloadURLs.
+func PluginLatestVersionURLs(info PluginInfo) ([]string, error) {
+	return (&Context{}).PluginLatestVersionURLs(info)
+}
+
+// PluginLatestVersionURLs returns the URLs that would be requested to look up the latest version of the plugin like
+// the package-level PluginLatestVersionURLs, using the context's plugin source if it has one.
+func (ctx *Context) PluginLatestVersionURLs(info PluginInfo) ([]string, error) {
+	run := &dryRun{}
+	source := ctx.pluginSource(info, info.Mirrors())
+	_, err := source.GetLatestVersion(run.getHTTPResponse)
+	return run.result(err)
+}
@@ -0,0 +1,105 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"os"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestPluginDownloadURLs(t *testing.T) {
+	// GitHub releases are requested through its API when a token is set, and from private repositories too in
+	// experimental mode.
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("PULUMI_EXPERIMENTAL", "")
+	require.NoError(t, os.Unsetenv("PULUMI_EXPERIMENTAL"))
+
+	v := semver.MustParse("1.2.3")
+	tests := []struct {
+		name     string
+		info     PluginInfo
+		mirrors  string
+		download []string
+		latest   []string
+	}{
+		{
+			name: "default sources",
+			info: PluginInfo{Name: "aws", Kind: ResourcePlugin, Version: &v},
+			download: []string{
+				"https://github.com/pulumi/pulumi-aws/releases/download/v1.2.3/pulumi-resource-aws-v1.2.3-linux-amd64.tar.gz",
+				"https://get.pulumi.com/releases/plugins/pulumi-resource-aws-v1.2.3-linux-amd64.tar.gz",
+			},
+			latest: []string{"https://api.github.com/repos/pulumi/pulumi-aws/releases/latest"},
+		},
+		{
+			name:    "mirrors",
+			info:    PluginInfo{Name: "aws", Kind: ResourcePlugin, Version: &v},
+			mirrors: "https://mirror.corp/index,https://other.corp/",
+			download: []string{
+				"https://mirror.corp/index/resource/aws.json",
+				"https://other.corp/resource/aws.json",
+				"https://github.com/pulumi/pulumi-aws/releases/download/v1.2.3/pulumi-resource-aws-v1.2.3-linux-amd64.tar.gz",
+				"https://get.pulumi.com/releases/plugins/pulumi-resource-aws-v1.2.3-linux-amd64.tar.gz",
+			},
+			latest: []string{
+				"https://mirror.corp/index/resource/aws.json",
+				"https://other.corp/resource/aws.json",
+				"https://api.github.com/repos/pulumi/pulumi-aws/releases/latest",
+			},
+		},
+		{
+			name: "plugin download URL",
+			info: PluginInfo{
+				Name: "acme", Kind: ResourcePlugin, Version: &v, PluginDownloadURL: "https://example.com/${VERSION}/",
+			},
+			download: []string{"https://example.com/1.2.3/pulumi-resource-acme-v1.2.3-linux-amd64.tar.gz"},
+		},
+		{
+			name: "terraform registry",
+			info: PluginInfo{
+				Name: "acme", Kind: ResourcePlugin, Version: &v,
+				PluginDownloadURL: TerraformRegistryScheme + "registry.terraform.io/acme/acme",
+			},
+			download: []string{"https://registry.terraform.io/.well-known/terraform.json"},
+			latest:   []string{"https://registry.terraform.io/.well-known/terraform.json"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(PulumiHomeEnvVar, t.TempDir())
+			t.Setenv(PluginIndexURLsEnvVar, tt.mirrors)
+
+			urls, err := PluginDownloadURLs(tt.info, Platform{OS: "linux", Arch: "amd64"})
+			require.NoError(t, err)
+			assert.Equal(t, tt.download, urls)
+
+			urls, err = PluginLatestVersionURLs(tt.info)
+			if tt.latest == nil {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.latest, urls)
+			}
+		})
+	}
+
+	_, err := PluginDownloadURLs(PluginInfo{Name: "aws", Kind: ResourcePlugin}, Platform{OS: "linux", Arch: "amd64"})
+	assert.Error(t, err)
+}
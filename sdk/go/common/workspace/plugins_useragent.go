@@ -0,0 +1,103 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// PluginUserAgentEnvVar is the product identifier appended to the User-Agent of plugin downloads of contexts that
+// don't set Context.UserAgent, such as `my-ci/2.1`. Programs using the Automation API can set it in the environment of
+// the CLI they run.
+const PluginUserAgentEnvVar = "PULUMI_PLUGIN_USER_AGENT"
+
+// PluginAttributionEnvVar is the attribution sent with plugin downloads of contexts that don't set
+// Context.Attribution.
+const PluginAttributionEnvVar = "PULUMI_PLUGIN_ATTRIBUTION"
+
+// PluginAttributionHeader is the header plugin downloads send their attribution in.
+const PluginAttributionHeader = "X-Pulumi-Attribution"
+
+// isHTTPTokenChar returns true if c may appear in an HTTP token, as RFC 7230 defines it.
+func isHTTPTokenChar(c rune) bool {
+	return c < 0x7f && (c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		strings.ContainsRune("!#$%&'*+-.^_`|~", c))
+}
+
+// validatePluginUserAgent checks that products is a list of products, such as `my-ci/2.1 deployer`, that can be
+// appended to a User-Agent.
+func validatePluginUserAgent(products string) error {
+	for _, product := range strings.Fields(products) {
+		for _, token := range strings.SplitN(product, "/", 2) {
+			if token == "" || strings.IndexFunc(token, func(c rune) bool { return !isHTTPTokenChar(c) }) != -1 {
+				return fmt.Errorf("%q is not a product, such as my-ci/2.1", product)
+			}
+		}
+	}
+	return nil
+}
+
+// validatePluginAttribution checks that attribution can be sent in a header.
+func validatePluginAttribution(attribution string) error {
+	for _, c := range attribution {
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return fmt.Errorf("%q contains control characters", attribution)
+		}
+	}
+	return nil
+}
+
+// pluginUserAgent returns the products the context appends to the User-Agent of plugin downloads, and the attribution
+// it sends with them.
+func (ctx *Context) pluginUserAgent() (products string, attribution string, err error) {
+	products, attribution = ctx.UserAgent, ctx.Attribution
+	productsFrom, attributionFrom := "UserAgent", "Attribution"
+	if products == "" {
+		products, productsFrom = os.Getenv(PluginUserAgentEnvVar), PluginUserAgentEnvVar
+	}
+	if attribution == "" {
+		attribution, attributionFrom = os.Getenv(PluginAttributionEnvVar), PluginAttributionEnvVar
+	}
+	if err := validatePluginUserAgent(products); err != nil {
+		return "", "", fmt.Errorf("%s: %w", productsFrom, err)
+	}
+	if err := validatePluginAttribution(attribution); err != nil {
+		return "", "", fmt.Errorf("%s: %w", attributionFrom, err)
+	}
+	return strings.Join(strings.Fields(products), " "), strings.TrimSpace(attribution), nil
+}
+
+// attributeRequest returns req, identifying the context's embedder in its User-Agent and attribution header if it's
+// configured to.
+func (ctx *Context) attributeRequest(req *http.Request) (*http.Request, error) {
+	products, attribution, err := ctx.pluginUserAgent()
+	if err != nil || (products == "" && attribution == "") {
+		return req, err
+	}
+	req = req.Clone(req.Context())
+	if products != "" {
+		if userAgent := req.Header.Get("User-Agent"); userAgent != "" {
+			products = userAgent + " " + products
+		}
+		req.Header.Set("User-Agent", products)
+	}
+	if attribution != "" {
+		req.Header.Set(PluginAttributionHeader, attribution)
+	}
+	return req, nil
+}
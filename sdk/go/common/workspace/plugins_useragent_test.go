@@ -0,0 +1,67 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestPluginUserAgent(t *testing.T) {
+	t.Setenv(PluginUserAgentEnvVar, "")
+	t.Setenv(PluginAttributionEnvVar, "")
+
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer server.Close()
+
+	get := func(ctx *Context) error {
+		req, err := buildHTTPRequest(server.URL, "")
+		require.NoError(t, err)
+		body, _, err := ctx.getHTTPResponse(req)
+		if err == nil {
+			assert.NoError(t, body.Close())
+		}
+		return err
+	}
+
+	require.NoError(t, get(&Context{Home: t.TempDir()}))
+	assert.Regexp(t, `^pulumi-cli/1 \([^)]*\)$`, header.Get("User-Agent"))
+	assert.Empty(t, header.Get(PluginAttributionHeader))
+
+	require.NoError(t, get(&Context{Home: t.TempDir(), UserAgent: " my-ci/2.1  deployer ", Attribution: "team-infra"}))
+	assert.Regexp(t, `^pulumi-cli/1 \([^)]*\) my-ci/2\.1 deployer$`, header.Get("User-Agent"))
+	assert.Equal(t, "team-infra", header.Get(PluginAttributionHeader))
+
+	// The environment is used by contexts that don't identify their embedder.
+	t.Setenv(PluginUserAgentEnvVar, "automation/3")
+	t.Setenv(PluginAttributionEnvVar, "nightly")
+	require.NoError(t, get(&Context{Home: t.TempDir(), Attribution: "team-infra"}))
+	assert.Regexp(t, `\) automation/3$`, header.Get("User-Agent"))
+	assert.Equal(t, "team-infra", header.Get(PluginAttributionHeader))
+
+	assert.EqualError(t, get(&Context{Home: t.TempDir(), UserAgent: "my-ci/2.1/beta"}),
+		`UserAgent: "my-ci/2.1/beta" is not a product, such as my-ci/2.1`)
+	t.Setenv(PluginAttributionEnvVar, "nightly\r\nX-Admin: true")
+	assert.EqualError(t, get(&Context{Home: t.TempDir()}),
+		`PULUMI_PLUGIN_ATTRIBUTION: "nightly\r\nX-Admin: true" contains control characters`)
+}
@@ -0,0 +1,171 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/blang/semver"
+)
+
+// PluginVariantsEnvVar selects alternate builds of plugins, such as debug or race-enabled builds, that GetPluginPath
+// uses instead of their release builds. It's a comma-separated list of NAME=VARIANT pairs, such as `aws=debug`, and
+// takes precedence over the `variants` section of PluginConfigFile.
+const PluginVariantsEnvVar = "PULUMI_PLUGIN_VARIANTS"
+
+// pluginVariantsDir is the directory in each plugin directory that variants are installed into, in a directory per
+// variant.
+const pluginVariantsDir = ".variants"
+
+// pluginVariantRegexp matches valid variant names.
+var pluginVariantRegexp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// ValidatePluginVariant checks that variant is a valid variant name: lowercase letters and digits, separated by
+// dashes, such as "debug" or "race".
+func ValidatePluginVariant(variant string) error {
+	if !pluginVariantRegexp.MatchString(variant) {
+		return fmt.Errorf("%q is not a valid plugin variant; expected lowercase letters and digits, such as \"debug\"",
+			variant)
+	}
+	return nil
+}
+
+// parsePluginVariants parses the value of PluginVariantsEnvVar into the variant selected for each plugin name.
+func parsePluginVariants(s string) (map[string]string, error) {
+	variants := map[string]string{}
+	for _, entry := range splitEnvList(s) {
+		eq := strings.Index(entry, "=")
+		if eq <= 0 {
+			return nil, fmt.Errorf("%q is not of the form NAME=VARIANT", entry)
+		}
+		name, variant := strings.TrimSpace(entry[:eq]), strings.TrimSpace(entry[eq+1:])
+		if err := ValidatePluginVariant(variant); err != nil {
+			return nil, err
+		}
+		variants[name] = variant
+	}
+	return variants, nil
+}
+
+// getPluginVariant returns the variant of the named plugin selected by PluginVariantsEnvVar, or PluginConfigFile if it
+// isn't set, or "" if the release build is used.
+func (ctx *Context) getPluginVariant(name string) (string, error) {
+	if env := os.Getenv(PluginVariantsEnvVar); env != "" {
+		variants, err := parsePluginVariants(env)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", PluginVariantsEnvVar, err)
+		}
+		return variants[name], nil
+	}
+	config, err := ctx.GetPluginConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.Variants[name], nil
+}
+
+// GetPluginVariants returns the variants of plugins installed in the context's plugin directories. Variants aren't
+// returned by GetPlugins, which only lists release builds.
+func (ctx *Context) GetPluginVariants() ([]PluginInfo, error) {
+	dirs, err := ctx.getPluginDirs()
+	if err != nil {
+		return nil, err
+	}
+	var plugins []PluginInfo
+	for _, dir := range dirs {
+		variants, err := ioutil.ReadDir(filepath.Join(dir, pluginVariantsDir))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		for _, variant := range variants {
+			if !variant.IsDir() || ValidatePluginVariant(variant.Name()) != nil {
+				continue
+			}
+			found, err := getPlugins(filepath.Join(dir, pluginVariantsDir, variant.Name()), true /* skipMetadata */)
+			if err != nil {
+				return nil, err
+			}
+			for _, plugin := range found {
+				plugin.PluginDir, plugin.Variant, plugin.ctx = dir, variant.Name(), ctx
+				plugins = append(plugins, plugin)
+			}
+		}
+	}
+	return plugins, nil
+}
+
+// getPluginVariantPath finds the variant of a plugin like GetPluginPath finds its release build. Variants are only
+// used once they're installed: there's no falling back to the release build, so debugging sessions don't silently
+// run the wrong build.
+func (ctx *Context) getPluginVariantPath(kind PluginKind, name string, version *semver.Version,
+	variant string) (pluginPathEntry, error) {
+	key, err := ctx.pluginPathCacheKey(kind, name, version)
+	if err != nil {
+		return pluginPathEntry{}, err
+	}
+	key.version = fmt.Sprintf("variant:%s:%s", variant, key.version)
+	if entry, ok := cachedPluginPath(key); ok {
+		return entry, nil
+	}
+
+	installed, err := ctx.GetPluginVariants()
+	if err != nil {
+		return pluginPathEntry{}, fmt.Errorf("loading plugin variant list: %w", err)
+	}
+	var plugins []PluginInfo
+	for _, plugin := range installed {
+		if plugin.Variant == variant {
+			plugins = append(plugins, plugin)
+		}
+	}
+	match := ctx.matchPlugin(plugins, kind, name, version, ctx.legacyPluginSearch())
+	if match == nil {
+		desc := name
+		if version != nil {
+			desc = fmt.Sprintf("%s v%s", name, version)
+		}
+		return pluginPathEntry{}, fmt.Errorf("the %s variant of %s plugin %s is selected but not installed; install "+
+			"it with `pulumi plugin install %s %s VERSION --file TARBALL --variant %s`, or stop selecting it",
+			variant, kind, desc, kind, name, variant)
+	}
+
+	matchDir, err := match.DirPath()
+	if err != nil {
+		return pluginPathEntry{}, err
+	}
+	matchPath, err := match.FilePath()
+	if err != nil {
+		return pluginPathEntry{}, err
+	}
+	ctx.logf(6, "GetPluginPath(%s, %s, %v): found %s variant at %s", kind, name, version, variant, matchPath)
+	unsealedDir, unsealedPath, sealed, err := ctx.unsealPlugin(*match, matchDir)
+	if err != nil {
+		return pluginPathEntry{}, err
+	} else if sealed {
+		matchDir, matchPath = unsealedDir, unsealedPath
+	}
+	entry := pluginPathEntry{info: *match, dir: matchDir, path: matchPath}
+	cachePluginPath(key, entry)
+	return entry, nil
+}
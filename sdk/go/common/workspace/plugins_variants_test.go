@@ -0,0 +1,101 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePluginVariants(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value    string
+		expected map[string]string
+		err      string
+	}{
+		{value: "", expected: map[string]string{}},
+		{value: "aws=debug", expected: map[string]string{"aws": "debug"}},
+		{value: "aws=debug, gcp = race-1", expected: map[string]string{"aws": "debug", "gcp": "race-1"}},
+		{value: "debug", err: `"debug" is not of the form NAME=VARIANT`},
+		{value: "=debug", err: `"=debug" is not of the form NAME=VARIANT`},
+		{value: "aws=", err: `"" is not a valid plugin variant; expected lowercase letters and digits, such as "debug"`},
+		{value: "aws=../debug", err: `"../debug" is not a valid plugin variant; expected lowercase letters and digits, ` +
+			`such as "debug"`},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.value, func(t *testing.T) {
+			t.Parallel()
+
+			variants, err := parsePluginVariants(tt.value)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, variants)
+		})
+	}
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestGetPluginPathVariant(t *testing.T) {
+	dir, release := newMockPlugin(t)
+	ctx := &Context{Home: t.TempDir(), PluginDir: dir}
+	debug := release
+	debug.Variant = "debug"
+	for _, info := range []PluginInfo{release, debug} {
+		require.NoError(t, info.Install(ioutil.NopCloser(bytes.NewReader(mockPluginTGZ(t))), false))
+	}
+	pluginDir := func() string {
+		pluginDir, _, err := ctx.GetPluginPath(ResourcePlugin, "mock", nil)
+		require.NoError(t, err)
+		return pluginDir
+	}
+
+	// The variant is installed alongside the release build, which is used unless the variant is selected.
+	assert.Equal(t, filepath.Join(dir, "resource-mock-v1.0.0"), pluginDir())
+	plugins, err := ctx.GetPlugins()
+	require.NoError(t, err)
+	assert.Len(t, plugins, 1)
+	variants, err := ctx.GetPluginVariants()
+	require.NoError(t, err)
+	require.Len(t, variants, 1)
+	assert.Equal(t, "mock-1.0.0 (debug)", variants[0].String())
+
+	t.Setenv(PluginVariantsEnvVar, "mock=debug")
+	assert.Equal(t, filepath.Join(dir, pluginVariantsDir, "debug", "resource-mock-v1.0.0"), pluginDir())
+
+	// Variants that aren't installed aren't silently replaced by the release build.
+	t.Setenv(PluginVariantsEnvVar, "mock=race")
+	_, _, err = ctx.GetPluginPath(ResourcePlugin, "mock", nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "the race variant of resource plugin mock is selected but not installed")
+
+	// Removing the variant leaves the release build alone.
+	t.Setenv(PluginVariantsEnvVar, "")
+	require.NoError(t, variants[0].Delete())
+	variants, err = ctx.GetPluginVariants()
+	require.NoError(t, err)
+	assert.Empty(t, variants)
+	assert.Equal(t, filepath.Join(dir, "resource-mock-v1.0.0"), pluginDir())
+}
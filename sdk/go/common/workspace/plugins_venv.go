@@ -0,0 +1,255 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/fsutil"
+	"github.com/pulumi/pulumi/sdk/v3/python"
+)
+
+// PluginSharedVirtualEnvsEnvVar, when truthy, makes Python plugins with identical dependencies share a single virtual
+// environment instead of each installed version creating its own. Shared virtual environments live in the `.venvs`
+// directory of the plugin cache, and each plugin's `venv` is a symlink to one of them. They're removed once no
+// installed plugin links to them. Plugins aren't given shared virtual environments when the plugin cache is encrypted,
+// since the links would leave their encrypted directories.
+const PluginSharedVirtualEnvsEnvVar = "PULUMI_PLUGIN_SHARED_VIRTUALENVS"
+
+// sharedVirtualEnvsDir is the directory in the plugin cache that holds shared virtual environments.
+const sharedVirtualEnvsDir = ".venvs"
+
+// pythonDependencyFiles are the files that determine what gets installed into a Python plugin's virtual environment.
+var pythonDependencyFiles = []string{"requirements.txt", "pyproject.toml", "poetry.lock"}
+
+// installPythonVirtualEnv creates the `venv` virtual environment for the Python plugin installed in dir. If shareable
+// is true and `PULUMI_PLUGIN_SHARED_VIRTUALENVS` is set, the virtual environment is shared with other plugins that have
+// the same dependencies and are installed the same way.
+func installPythonVirtualEnv(info PluginInfo, dir string, opts python.InstallDependenciesOptions,
+	shareable bool) error {
+	if shareable && cmdutil.IsTruthy(os.Getenv(PluginSharedVirtualEnvsEnvVar)) {
+		command, err := info.context().getPluginCacheKeyCommand()
+		if err != nil {
+			return err
+		}
+		if command != nil {
+			info.logf(5, "not sharing the virtual environment of plugin %s, since the plugin cache is encrypted", info)
+			return python.InstallDependenciesWithOptions(dir, "venv", opts)
+		}
+		pluginDir, err := info.pluginCacheDir()
+		if err != nil {
+			return err
+		}
+		return installSharedVirtualEnv(filepath.Join(pluginDir, sharedVirtualEnvsDir), dir, opts)
+	}
+	return python.InstallDependenciesWithOptions(dir, "venv", opts)
+}
+
+// installSharedVirtualEnv links the `venv` of the Python plugin in dir to the virtual environment in venvsDir keyed by
+// the digest of how it's installed, creating it first if it doesn't exist yet.
+func installSharedVirtualEnv(venvsDir, dir string, opts python.InstallDependenciesOptions) error {
+	interpreter, err := pythonInterpreterVersion()
+	if err != nil {
+		return err
+	}
+	digest, err := pythonDependencyDigest(dir, interpreter, opts)
+	if err != nil {
+		return err
+	}
+	shared := filepath.Join(venvsDir, digest)
+
+	if err := os.MkdirAll(venvsDir, 0700); err != nil {
+		return fmt.Errorf("creating shared virtual environment directory: %w", err)
+	}
+
+	// As with plugin installs, a lock prevents concurrent installs into the same virtual environment and a partial
+	// file marks one that didn't finish installing. Pruning takes the lock too, so it can't remove a virtual
+	// environment before it's linked.
+	mutex := fsutil.NewFileMutex(shared + ".lock")
+	if err := mutex.Lock(); err != nil {
+		return err
+	}
+	defer func() {
+		contract.IgnoreError(mutex.Unlock())
+	}()
+
+	partial := shared + ".partial"
+	_, sharedErr := os.Stat(shared)
+	_, partialErr := os.Stat(partial)
+	if sharedErr == nil && errors.Is(partialErr, os.ErrNotExist) {
+		logf(5, nil, "reusing shared virtual environment %s for %s", shared, dir)
+	} else {
+		if err := os.RemoveAll(shared); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(partial, nil, 0600); err != nil {
+			return err
+		}
+		if err := python.InstallDependenciesWithOptions(dir, shared, opts); err != nil {
+			return err
+		}
+		if err := os.Remove(partial); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Symlink(shared, filepath.Join(dir, "venv")); err != nil {
+		return fmt.Errorf("linking shared virtual environment %s: %w", shared, err)
+	}
+	return nil
+}
+
+// pythonInterpreterVersion returns the path and `--version` of the Python interpreter virtual environments are
+// created with.
+func pythonInterpreterVersion() (string, error) {
+	cmd, err := python.Command("--version")
+	if err != nil {
+		return "", err
+	}
+	// Python 2 prints its version to stderr.
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("getting the version of %s: %w", cmd.Path, err)
+	}
+	return cmd.Path + "\x00" + strings.TrimSpace(string(out)), nil
+}
+
+// pythonDependencyDigest returns a digest of everything that determines what's installed into the virtual environment
+// of the Python plugin in dir: its dependency files, the interpreter, and the options it's installed with.
+func pythonDependencyDigest(dir, interpreter string, opts python.InstallDependenciesOptions) (string, error) {
+	h := sha256.New()
+	for _, name := range pythonDependencyFiles {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", name, len(b))
+		h.Write(b)
+	}
+
+	env := append([]string{}, opts.Env...)
+	sort.Strings(env)
+	fields := []string{
+		"interpreter=" + interpreter,
+		"pip=" + strings.Join(opts.Pip.Args(), "\x01"),
+		fmt.Sprintf("requireHashes=%v", opts.RequireHashes),
+		fmt.Sprintf("poetry=%v", opts.Poetry),
+		"env=" + strings.Join(env, "\x01"),
+	}
+	for _, field := range fields {
+		fmt.Fprintf(h, "%d\x00%s", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sharedVirtualEnvRefs returns the names of the shared virtual environments in the plugin directory root that the
+// `venv` links of the plugins installed there, including their variants, point to.
+func sharedVirtualEnvRefs(root string) (map[string]bool, error) {
+	venvsDir := filepath.Join(root, sharedVirtualEnvsDir)
+	refs := map[string]bool{}
+	for _, pattern := range []string{
+		filepath.Join(root, "*", "venv"),
+		filepath.Join(root, pluginVariantsDir, "*", "*", "venv"),
+	} {
+		links, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, link := range links {
+			target, err := os.Readlink(link)
+			if err != nil {
+				// Plugins that aren't sharing a virtual environment have a directory.
+				continue
+			}
+			if filepath.Dir(target) == venvsDir {
+				refs[filepath.Base(target)] = true
+			}
+		}
+	}
+	return refs, nil
+}
+
+// pruneSharedVirtualEnvs removes the shared virtual environments in the plugin directory root that no installed plugin
+// links to, returning their paths.
+func pruneSharedVirtualEnvs(root string) ([]string, error) {
+	venvsDir := filepath.Join(root, sharedVirtualEnvsDir)
+	entries, err := ioutil.ReadDir(venvsDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	refs, err := sharedVirtualEnvRefs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []string
+	for _, entry := range entries {
+		if !entry.IsDir() || refs[entry.Name()] {
+			continue
+		}
+		shared := filepath.Join(venvsDir, entry.Name())
+		removed, err := pruneSharedVirtualEnv(root, shared)
+		if err != nil {
+			return pruned, err
+		}
+		if removed {
+			// As with plugin directories, don't fail if the lock file of the removed virtual environment can't be.
+			contract.IgnoreError(os.Remove(shared + ".lock"))
+			pruned = append(pruned, shared)
+		}
+	}
+	return pruned, nil
+}
+
+// pruneSharedVirtualEnv removes the shared virtual environment if no installed plugin links to it once its lock is
+// held, since an install may have linked it in the meantime.
+func pruneSharedVirtualEnv(root, shared string) (bool, error) {
+	mutex := fsutil.NewFileMutex(shared + ".lock")
+	if err := mutex.Lock(); err != nil {
+		return false, err
+	}
+	defer func() {
+		contract.IgnoreError(mutex.Unlock())
+	}()
+
+	refs, err := sharedVirtualEnvRefs(root)
+	if err != nil || refs[filepath.Base(shared)] {
+		return false, err
+	}
+	logf(5, nil, "removing shared virtual environment %s, which no plugin uses", shared)
+	if err := os.RemoveAll(shared); err != nil {
+		return false, err
+	}
+	if err := os.Remove(shared + ".partial"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/sdk/v3/python"
+)
+
+func TestPythonDependencyDigest(t *testing.T) {
+	t.Parallel()
+
+	write := func(files map[string]string) string {
+		dir := t.TempDir()
+		for name, content := range files {
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+		}
+		return dir
+	}
+	digest := func(dir string) string {
+		d, err := pythonDependencyDigest(dir, "python 3.10.4", python.InstallDependenciesOptions{})
+		require.NoError(t, err)
+		return d
+	}
+
+	a := digest(write(map[string]string{"requirements.txt": "pulumi>=3.0.0\n", "PulumiPlugin.yaml": "runtime: python"}))
+	b := digest(write(map[string]string{"requirements.txt": "pulumi>=3.0.0\n"}))
+	c := digest(write(map[string]string{"requirements.txt": "pulumi>=3.1.0\n"}))
+	d := digest(write(map[string]string{"pyproject.toml": "pulumi>=3.0.0\n"}))
+
+	assert.Equal(t, a, b, "files other than dependency files should not affect the digest")
+	assert.NotEqual(t, b, c)
+	assert.NotEqual(t, b, d)
+
+	// The interpreter and the options of the install are part of the digest, so virtual environments are only
+	// shared by plugins installed the same way.
+	dir := write(map[string]string{"requirements.txt": "pulumi>=3.0.0\n"})
+	for _, tt := range []struct {
+		interpreter string
+		opts        python.InstallDependenciesOptions
+	}{
+		{interpreter: "python 3.11.0"},
+		{interpreter: "python 3.10.4", opts: python.InstallDependenciesOptions{
+			Pip: python.PipOptions{IndexURL: "https://pypi.corp/simple"},
+		}},
+		{interpreter: "python 3.10.4", opts: python.InstallDependenciesOptions{RequireHashes: true}},
+		{interpreter: "python 3.10.4", opts: python.InstallDependenciesOptions{Poetry: true}},
+		{interpreter: "python 3.10.4", opts: python.InstallDependenciesOptions{Env: []string{"PIP_NO_CACHE_DIR=1"}}},
+	} {
+		other, err := pythonDependencyDigest(dir, tt.interpreter, tt.opts)
+		require.NoError(t, err)
+		assert.NotEqual(t, b, other, "%+v", tt)
+	}
+
+	// The order the environment variables are given in doesn't matter.
+	e, err := pythonDependencyDigest(dir, "python 3.10.4", python.InstallDependenciesOptions{Env: []string{"A=1", "B=2"}})
+	require.NoError(t, err)
+	f, err := pythonDependencyDigest(dir, "python 3.10.4", python.InstallDependenciesOptions{Env: []string{"B=2", "A=1"}})
+	require.NoError(t, err)
+	assert.Equal(t, e, f)
+}
+
+func TestInstallSharedVirtualEnvReuse(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks requires elevated privileges on Windows")
+	}
+
+	venvs := t.TempDir()
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "requirements.txt"), []byte("pulumi\n"), 0600))
+
+	// A complete shared virtual environment already exists, so it's linked without installing anything.
+	interpreter, err := pythonInterpreterVersion()
+	if err != nil {
+		t.Skipf("no Python interpreter: %v", err)
+	}
+	digest, err := pythonDependencyDigest(dir, interpreter, python.InstallDependenciesOptions{})
+	require.NoError(t, err)
+	shared := filepath.Join(venvs, digest)
+	require.NoError(t, os.Mkdir(shared, 0700))
+
+	require.NoError(t, installSharedVirtualEnv(venvs, dir, python.InstallDependenciesOptions{}))
+	target, err := os.Readlink(filepath.Join(dir, "venv"))
+	require.NoError(t, err)
+	assert.Equal(t, shared, target)
+}
+
+func TestPruneSharedVirtualEnvs(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks requires elevated privileges on Windows")
+	}
+
+	root := t.TempDir()
+	venvs := filepath.Join(root, sharedVirtualEnvsDir)
+	for _, name := range []string{"used", "used-by-variant", "unused"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(venvs, name), 0700))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(venvs, name+".lock"), nil, 0600))
+	}
+	for plugin, venv := range map[string]string{
+		"resource-a-v1.0.0": "used",
+		filepath.Join(pluginVariantsDir, "debug", "resource-a-v1.0.0"): "used-by-variant",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, plugin), 0700))
+		require.NoError(t, os.Symlink(filepath.Join(venvs, venv), filepath.Join(root, plugin, "venv")))
+	}
+	// Plugins with virtual environments of their own don't keep any shared one.
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "resource-b-v1.0.0", "venv"), 0700))
+
+	pruned, err := pruneSharedVirtualEnvs(root)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(venvs, "unused")}, pruned)
+	assert.DirExists(t, filepath.Join(venvs, "used"))
+	assert.DirExists(t, filepath.Join(venvs, "used-by-variant"))
+	assert.NoDirExists(t, filepath.Join(venvs, "unused"))
+	assert.NoFileExists(t, filepath.Join(venvs, "unused.lock"))
+	assert.FileExists(t, filepath.Join(venvs, "used.lock"))
+
+	// Once the last plugin using it is deleted, a shared virtual environment goes too.
+	v := semver.MustParse("1.0.0")
+	plugin := PluginInfo{Kind: ResourcePlugin, Name: "a", Version: &v, PluginDir: root}
+	require.NoError(t, plugin.Delete())
+	assert.NoDirExists(t, filepath.Join(venvs, "used"))
+	assert.NoFileExists(t, filepath.Join(venvs, "used.lock"))
+	assert.DirExists(t, filepath.Join(venvs, "used-by-variant"))
+}
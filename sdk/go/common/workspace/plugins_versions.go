@@ -0,0 +1,102 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/blang/semver"
+)
+
+// versionListingSource is implemented by plugin sources that can list the versions of the plugin they publish.
+type versionListingSource interface {
+	// ListVersions returns the versions of the plugin the source publishes, in no particular order. Prereleases are
+	// included.
+	ListVersions(getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, error)
+}
+
+// listSourceVersions returns the versions the source publishes, if it can list them, and otherwise just its latest
+// version.
+func listSourceVersions(source PluginSource,
+	getHTTPResponse func(*http.Request) (io.ReadCloser, int64, error)) ([]semver.Version, error) {
+	if lister, ok := source.(versionListingSource); ok {
+		return lister.ListVersions(getHTTPResponse)
+	}
+	latest, err := source.GetLatestVersion(getHTTPResponse)
+	if err != nil {
+		return nil, err
+	}
+	return []semver.Version{*latest}, nil
+}
+
+// latestReleasedVersion returns the latest of the versions that isn't a prerelease, or nil if there is none.
+func latestReleasedVersion(versions []semver.Version) *semver.Version {
+	var latest *semver.Version
+	for i, version := range versions {
+		if len(version.Pre) > 0 {
+			continue
+		}
+		if latest == nil || version.GT(*latest) {
+			latest = &versions[i]
+		}
+	}
+	return latest
+}
+
+// ResolveVersionRange returns the plugin with its Version set to the latest version its source publishes in its
+// VersionRange, such as `>=5.0.0 <6.0.0`, that isn't a prerelease. Plugins that already have a Version, or that have
+// no VersionRange, are returned as they are.
+func (info PluginInfo) ResolveVersionRange() (PluginInfo, error) {
+	return (&Context{}).ResolveVersionRange(info)
+}
+
+// ResolveVersionRange resolves the plugin's VersionRange like PluginInfo.ResolveVersionRange, sending requests with
+// the context's HTTP client. Sources that can't list the versions they publish only resolve ranges that include their
+// latest version. If the context considers Prereleases, the latest version in the range may be a prerelease.
+func (ctx *Context) ResolveVersionRange(info PluginInfo) (PluginInfo, error) {
+	if info.Version != nil || info.VersionRange == "" {
+		return info, nil
+	}
+	inRange, err := semver.ParseRange(info.VersionRange)
+	if err != nil {
+		return PluginInfo{}, fmt.Errorf("invalid version range %q for plugin %s: %w", info.VersionRange, info.Name, err)
+	}
+
+	versions, err := listSourceVersions(ctx.pluginSource(info, info.Mirrors()), ctx.getHTTPResponse)
+	if err != nil {
+		return PluginInfo{}, fmt.Errorf("listing the versions of %s plugin %s: %w", info.Kind, info.Name, err)
+	}
+
+	matching := make([]semver.Version, 0, len(versions))
+	for _, version := range versions {
+		if inRange(version) {
+			matching = append(matching, version)
+		}
+	}
+	latest := latestVersion(matching, ctx.prereleases())
+	if latest == nil {
+		released := "released "
+		if ctx.prereleases() {
+			released = ""
+		}
+		return PluginInfo{}, classifyPluginError(ErrNotFound, fmt.Errorf(
+			"no %sversion of %s plugin %s is in range %q", released, info.Kind, info.Name, info.VersionRange))
+	}
+	info.logf(5, "resolved version range %q of plugin %s to %s", info.VersionRange, info.Name, latest)
+	info.Version = latest
+	return info, nil
+}
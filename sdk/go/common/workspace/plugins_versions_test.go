@@ -0,0 +1,117 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // mutates environment variables
+func TestResolveVersionRange(t *testing.T) {
+	t.Setenv(PluginIndexURLsEnvVar, "")
+
+	var requested []string
+	ctx := &Context{Home: t.TempDir(), HTTPClient: &http.Client{Transport: roundTripperFunc(
+		func(req *http.Request) (*http.Response, error) {
+			requested = append(requested, req.URL.String())
+			body := "tarball"
+			if req.URL.Path == "/index.json" {
+				body = `{"versions": [
+					{"version": "4.9.0"}, {"version": "5.0.0"}, {"version": "5.3.1"}, {"version": "5.4.0-alpha.1"},
+					{"version": "6.0.0"}
+				]}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       ioutil.NopCloser(strings.NewReader(body)),
+				Request:    req,
+			}, nil
+		})}}
+	info := PluginInfo{
+		Name:              "test",
+		Kind:              ResourcePlugin,
+		PluginDownloadURL: "https://plugins.example.com",
+		VersionRange:      ">=5.0.0 <6.0.0",
+	}
+
+	// The latest version in the range that isn't a prerelease is resolved.
+	resolved, err := ctx.ResolveVersionRange(info)
+	require.NoError(t, err)
+	require.NotNil(t, resolved.Version)
+	assert.Equal(t, "5.3.1", resolved.Version.String())
+	assert.Equal(t, []string{"https://plugins.example.com/index.json"}, requested)
+
+	// Downloads resolve the range first.
+	body, _, err := ctx.Download(info)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.Equal(t, fmt.Sprintf("https://plugins.example.com/pulumi-resource-test-v5.3.1-%s-%s.tar.gz",
+		runtime.GOOS, runtime.GOARCH), requested[len(requested)-1])
+
+	// Plugins with a version aren't resolved.
+	resolved.VersionRange = "<1.0.0"
+	again, err := ctx.ResolveVersionRange(resolved)
+	require.NoError(t, err)
+	assert.Equal(t, resolved, again)
+
+	// Ranges no version is in aren't resolved.
+	info.VersionRange = ">=7.0.0"
+	_, err = ctx.ResolveVersionRange(info)
+	assert.True(t, errors.Is(err, ErrNotFound), "%v", err)
+	info.VersionRange = "not a range"
+	_, err = ctx.ResolveVersionRange(info)
+	assert.Error(t, err)
+
+	// Ranges must be resolved before plugins are installed.
+	info.VersionRange = ">=5.0.0"
+	info.PluginDir = t.TempDir()
+	err = info.Install(ioutil.NopCloser(strings.NewReader("")), false)
+	assert.EqualError(t, err, `the version range ">=5.0.0" of plugin test must be resolved with ResolveVersionRange `+
+		`before the plugin is installed`)
+}
+
+func TestGitHubSourceListVersions(t *testing.T) {
+	t.Parallel()
+
+	var requested []string
+	source := newGithubSource("pulumi", "test", ResourcePlugin)
+	versions, err := source.ListVersions(func(req *http.Request) (io.ReadCloser, int64, error) {
+		requested = append(requested, req.URL.RawQuery)
+		if req.URL.Query().Get("page") == "1" {
+			releases := make([]string, 100)
+			for i := range releases {
+				releases[i] = fmt.Sprintf(`{"tag_name": "v1.%d.0"}`, i)
+			}
+			releases[99] = `{"tag_name": "v2.0.0", "draft": true}`
+			return newMockReadCloserString("[" + strings.Join(releases, ",") + "]")
+		}
+		return newMockReadCloserString(`[{"tag_name": "v0.1.0"}, {"tag_name": "not-a-version"}]`)
+	})
+	require.NoError(t, err)
+	assert.Len(t, versions, 100)
+	assert.Equal(t, []string{"per_page=100&page=1", "per_page=100&page=2"}, requested)
+	assert.Equal(t, "1.98.0", latestReleasedVersion(versions).String())
+}
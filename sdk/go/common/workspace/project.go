@@ -40,6 +40,21 @@ type ProjectTemplate struct {
 	Config map[string]ProjectTemplateConfigValue `json:"config,omitempty" yaml:"config,omitempty"`
 	// Important indicates the template is important and should be listed by default.
 	Important bool `json:"important,omitempty" yaml:"important,omitempty"`
+	// Plugins are the plugins projects created from the template need, so they can be downloaded ahead of time.
+	Plugins []ProjectTemplatePlugin `json:"plugins,omitempty" yaml:"plugins,omitempty"`
+}
+
+// ProjectTemplatePlugin is a plugin that projects created from a template need.
+type ProjectTemplatePlugin struct {
+	// Name is the name of the plugin.
+	Name string `json:"name" yaml:"name"`
+	// Kind is the kind of the plugin. It defaults to resource.
+	Kind string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	// Version is the version of the plugin, or a range of versions, such as `>=5.0.0 <6.0.0`, to use the latest of. If
+	// empty, the latest version is used.
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	// Server is the URL the plugin is downloaded from, if it isn't the default.
+	Server string `json:"server,omitempty" yaml:"server,omitempty"`
 }
 
 // ProjectTemplateConfigValue is a config value included in the project template manifest.
@@ -169,14 +184,38 @@ func (proj *PolicyPackProject) Save(path string) error {
 type PluginProject struct {
 	// Runtime is a required runtime that executes code.
 	Runtime ProjectRuntimeInfo `json:"runtime" yaml:"runtime"`
+	// Env are environment variables that must be set when the plugin runs and when its dependencies are installed.
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	// License is the license the plugin is distributed under. Plugins whose license requires acceptance are only
+	// installed once the user has accepted it.
+	License *PluginLicense `json:"license,omitempty" yaml:"license,omitempty"`
+	// Binaries are the named executables the plugin ships besides its entry point, such as companion tools, as paths
+	// relative to the plugin's directory. Paths may leave off the platform's executable extension.
+	Binaries map[string]string `json:"binaries,omitempty" yaml:"binaries,omitempty"`
+	// SmokeTest is a quick self-test that's run once the plugin is installed, failing the install if it doesn't pass.
+	SmokeTest *PluginSmokeTest `json:"smokeTest,omitempty" yaml:"smokeTest,omitempty"`
+	// Build is how the plugin is built when it's installed from its git repository, if it isn't built the default way.
+	Build *PluginBuild `json:"build,omitempty" yaml:"build,omitempty"`
 }
 
+// Validate checks the plugin project. Plugins that only ship executables may declare binaries instead of a runtime,
+// and the PulumiPlugin.yaml of a repository plugins are built from may declare just their build.
 func (proj *PluginProject) Validate() error {
-	if proj.Runtime.Name() == "" {
+	if proj.Runtime.Name() == "" && len(proj.Binaries) == 0 && proj.Build == nil {
 		return errors.New("project is missing a 'runtime' attribute")
 	}
+	if proj.Build != nil {
+		if err := proj.Build.validate(); err != nil {
+			return err
+		}
+	}
 
-	return nil
+	if proj.SmokeTest != nil {
+		if _, err := proj.SmokeTest.validate(); err != nil {
+			return err
+		}
+	}
+	return validatePluginBinaries(proj.Binaries)
 }
 
 // ProjectStack holds stack specific information about a project.
@@ -195,6 +195,7 @@ type Template struct {
 	Quickstart  string                                // Optional text to be displayed after template creation.
 	Config      map[string]ProjectTemplateConfigValue // Optional template config.
 	Important   bool                                  // Indicates whether the template should be listed by default.
+	Plugins     []ProjectTemplatePlugin               // The plugins projects created from the template need.
 
 	ProjectName        string // Name of the project.
 	ProjectDescription string // Optional description of the project.
@@ -443,6 +444,7 @@ func LoadTemplate(path string) (Template, error) {
 		template.Quickstart = proj.Template.Quickstart
 		template.Config = proj.Template.Config
 		template.Important = proj.Template.Important
+		template.Plugins = proj.Template.Plugins
 	}
 	if proj.Description != nil {
 		template.ProjectDescription = *proj.Description
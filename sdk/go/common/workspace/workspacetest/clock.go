@@ -0,0 +1,52 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspacetest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a workspace.Clock that only moves when it's told to. It is safe for concurrent use.
+type Clock struct {
+	m   sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a clock stopped at now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the clock's time.
+func (clock *Clock) Now() time.Time {
+	clock.m.Lock()
+	defer clock.m.Unlock()
+	return clock.now
+}
+
+// Advance moves the clock forward by d.
+func (clock *Clock) Advance(d time.Duration) {
+	clock.m.Lock()
+	defer clock.m.Unlock()
+	clock.now = clock.now.Add(d)
+}
+
+// Set moves the clock to now.
+func (clock *Clock) Set(now time.Time) {
+	clock.m.Lock()
+	defer clock.m.Unlock()
+	clock.now = now
+}
@@ -0,0 +1,226 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspacetest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+// ServerPlatforms are the platforms a Server lists the plugins published to its source for. It serves tarballs for
+// any platform, but plugin indexes only list these, and the host platform.
+var ServerPlatforms = []workspace.Platform{
+	{OS: "darwin", Arch: "amd64"},
+	{OS: "darwin", Arch: "arm64"},
+	{OS: "linux", Arch: "amd64"},
+	{OS: "linux", Arch: "arm64"},
+	{OS: "windows", Arch: "amd64"},
+}
+
+// Server is a fake plugin server that serves the plugins published to a Source over HTTP. Its URL can be used both as
+// a plugin's PluginDownloadURL, from which tarballs are downloaded as `pulumi-<kind>-<name>-v<version>-<os>-<arch>
+// .tar.gz`, and as a plugin index URL, which lists every published version of each plugin along with the checksums of
+// its tarballs. Requests can be made to fail or respond slowly, to test how clients cope.
+type Server struct {
+	*httptest.Server
+
+	source   *Source
+	m        sync.Mutex
+	faults   []*serverFault
+	requests []string
+}
+
+// serverFault is a way in which a Server misbehaves for requests for paths with a prefix.
+type serverFault struct {
+	prefix string
+	status int
+	count  int
+	delay  time.Duration
+}
+
+// NewServer starts a server for the plugins published to source. It's closed when the test finishes.
+func NewServer(t testing.TB, source *Source) *Server {
+	server := &Server{source: source}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Fail makes the next count requests for paths starting with prefix fail with the given HTTP status code, or all of
+// them if count is negative. Rate limited responses carry a Retry-After header.
+func (server *Server) Fail(prefix string, status, count int) {
+	server.m.Lock()
+	defer server.m.Unlock()
+	server.faults = append(server.faults, &serverFault{prefix: prefix, status: status, count: count})
+}
+
+// Delay makes responses to requests for paths starting with prefix wait for the given duration, or until the request
+// is canceled, before they're sent.
+func (server *Server) Delay(prefix string, delay time.Duration) {
+	server.m.Lock()
+	defer server.m.Unlock()
+	server.faults = append(server.faults, &serverFault{prefix: prefix, count: -1, delay: delay})
+}
+
+// Requests returns the paths requested from the server so far, in the order they were requested.
+func (server *Server) Requests() []string {
+	server.m.Lock()
+	defer server.m.Unlock()
+	return append([]string(nil), server.requests...)
+}
+
+// fault records the request for path, and returns the status it should fail with, if any, and how long it should be
+// delayed.
+func (server *Server) fault(path string) (int, time.Duration) {
+	server.m.Lock()
+	defer server.m.Unlock()
+	server.requests = append(server.requests, path)
+
+	status, delay := 0, time.Duration(0)
+	for _, fault := range server.faults {
+		if fault.count == 0 || !strings.HasPrefix(path, fault.prefix) {
+			continue
+		}
+		delay += fault.delay
+		if fault.status != 0 && status == 0 {
+			status = fault.status
+			if fault.count > 0 {
+				fault.count--
+			}
+		}
+	}
+	return status, delay
+}
+
+func (server *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	status, delay := server.fault(r.URL.Path)
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if status != 0 {
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	if body, ok := server.index(r.URL.Path); ok {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+		return
+	}
+	if tarball, ok := server.tarball(r.URL.Path); ok {
+		w.Header().Set("Content-Type", "application/gzip")
+		_, _ = w.Write(tarball)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// tarballName returns the name a plugin's tarball is downloaded from a PluginDownloadURL as.
+func tarballName(kind workspace.PluginKind, name, version string, platform workspace.Platform) string {
+	return fmt.Sprintf("pulumi-%s-%s-v%s-%s-%s.tar.gz", kind, name, version, platform.OS, platform.Arch)
+}
+
+// tarball returns the published tarball at path, which is served for every platform.
+func (server *Server) tarball(path string) ([]byte, bool) {
+	source := server.source
+	source.m.Lock()
+	defer source.m.Unlock()
+
+	for _, plugin := range source.plugins {
+		prefix := fmt.Sprintf("/pulumi-%s-%s-v", plugin.kind, plugin.name)
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		for version, tarball := range plugin.tarballs {
+			platform := strings.TrimPrefix(path, prefix+version+"-")
+			if platform == path || !strings.HasSuffix(platform, ".tar.gz") {
+				continue
+			}
+			parts := strings.Split(strings.TrimSuffix(platform, ".tar.gz"), "-")
+			if len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+				return tarball, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// index returns the plugin index file at path, which lists the published versions of a plugin.
+func (server *Server) index(path string) ([]byte, bool) {
+	source := server.source
+	source.m.Lock()
+	defer source.m.Unlock()
+
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(path, "/"), ".json"), "/")
+	if len(parts) != 2 || !strings.HasSuffix(path, ".json") {
+		return nil, false
+	}
+	plugin := source.plugin(workspace.PluginKind(parts[0]), parts[1], false)
+	if plugin == nil || len(plugin.versions) == 0 {
+		return nil, false
+	}
+
+	platforms := ServerPlatforms
+	host := workspace.Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	if !containsPlatform(platforms, host) {
+		platforms = append(append([]workspace.Platform(nil), platforms...), host)
+	}
+
+	var index workspace.PluginIndex
+	for _, version := range plugin.versions {
+		tarball := plugin.tarballs[version.String()]
+		digest := sha256.Sum256(tarball)
+		entry := workspace.PluginIndexVersion{Version: version.String(), Assets: map[string]workspace.PluginIndexAsset{}}
+		for _, platform := range platforms {
+			entry.Assets[platform.OS+"-"+platform.Arch] = workspace.PluginIndexAsset{
+				URL:    "/" + tarballName(plugin.kind, plugin.name, version.String(), platform),
+				SHA256: hex.EncodeToString(digest[:]),
+			}
+		}
+		index.Versions = append(index.Versions, entry)
+	}
+	body, err := json.Marshal(index)
+	if err != nil {
+		return nil, false
+	}
+	return body, true
+}
+
+func containsPlatform(platforms []workspace.Platform, platform workspace.Platform) bool {
+	for _, p := range platforms {
+		if p == platform {
+			return true
+		}
+	}
+	return false
+}
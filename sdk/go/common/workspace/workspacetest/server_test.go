@@ -0,0 +1,95 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspacetest
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+func download(t *testing.T, ctx *workspace.Context, info workspace.PluginInfo) ([]byte, error) {
+	r, _, err := ctx.Download(info)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	return b, nil
+}
+
+func TestServerPluginDownloadURL(t *testing.T) {
+	t.Parallel()
+
+	source := NewSource()
+	tarball := PluginTarball(workspace.ResourcePlugin, "acme")
+	source.Publish(ResourcePlugin("acme", "1.0.0"), tarball)
+	server := NewServer(t, source)
+	ctx := NewContext(t, nil)
+
+	info := ResourcePlugin("acme", "1.0.0")
+	info.PluginDownloadURL = server.URL
+	b, err := download(t, ctx, info)
+	require.NoError(t, err)
+	assert.Equal(t, tarball, b)
+
+	missing := ResourcePlugin("acme", "2.0.0")
+	missing.PluginDownloadURL = server.URL
+	_, err = download(t, ctx, missing)
+	assert.True(t, errors.Is(err, workspace.ErrNotFound), "expected %v to be ErrNotFound", err)
+
+	server.Fail("/pulumi-resource-acme-", http.StatusTooManyRequests, 1)
+	_, err = download(t, ctx, info)
+	assert.True(t, errors.Is(err, workspace.ErrRateLimited), "expected %v to be ErrRateLimited", err)
+	_, err = download(t, ctx, info)
+	assert.NoError(t, err)
+
+	server.Delay("/pulumi-resource-acme-", 100*time.Millisecond)
+	start := time.Now()
+	_, err = download(t, ctx, info)
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond, "expected the download to be delayed")
+
+	assert.Len(t, server.Requests(), 5)
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestServerPluginIndex(t *testing.T) {
+	source := NewSource()
+	source.Publish(ResourcePlugin("aws", "4.38.1"), nil)
+	source.Publish(ResourcePlugin("aws", "5.1.0"), nil)
+	server := NewServer(t, source)
+	t.Setenv(workspace.PulumiHomeEnvVar, t.TempDir())
+	t.Setenv(workspace.PluginIndexURLsEnvVar, server.URL)
+	ctx := NewContext(t, nil)
+
+	latest, err := ctx.GetLatestVersion(ResourcePlugin("aws", ""))
+	require.NoError(t, err)
+	assert.Equal(t, semver.MustParse("5.1.0"), *latest)
+
+	b, err := download(t, ctx, ResourcePlugin("aws", "4.38.1"))
+	require.NoError(t, err)
+	assert.Equal(t, PluginTarball(workspace.ResourcePlugin, "aws"), b)
+	assert.Contains(t, server.Requests(), "/resource/aws.json")
+}
@@ -0,0 +1,154 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspacetest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/blang/semver"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+// Download records a request for a plugin's tarball.
+type Download struct {
+	Plugin  workspace.PluginInfo
+	Version semver.Version
+	OS      string
+	Arch    string
+}
+
+// Source serves plugin tarballs published to it from memory, and records the downloads requested from it. It is safe
+// for concurrent use.
+type Source struct {
+	m         sync.Mutex
+	plugins   map[string]*publishedPlugin
+	downloads []Download
+}
+
+// publishedPlugin holds the versions of a plugin published to a Source.
+type publishedPlugin struct {
+	kind     workspace.PluginKind
+	name     string
+	versions []semver.Version
+	tarballs map[string][]byte
+	err      error
+}
+
+// NewSource returns a source with no plugins published to it.
+func NewSource() *Source {
+	return &Source{plugins: map[string]*publishedPlugin{}}
+}
+
+// plugin returns the published versions of the plugin, creating an entry for it if create is true.
+func (source *Source) plugin(kind workspace.PluginKind, name string, create bool) *publishedPlugin {
+	key := string(kind) + "/" + name
+	plugin, ok := source.plugins[key]
+	if !ok && create {
+		plugin = &publishedPlugin{kind: kind, name: name, tarballs: map[string][]byte{}}
+		source.plugins[key] = plugin
+	}
+	return plugin
+}
+
+// Publish makes the given tarball available for every platform as the plugin's version. A nil tarball publishes a
+// fake build of the plugin like PluginTarball's. The plugin's latest version is the highest published.
+func (source *Source) Publish(info workspace.PluginInfo, tarball []byte) {
+	contract.Requiref(info.Version != nil, "info", "plugin %s must have a version", info.Name)
+	if tarball == nil {
+		tarball = PluginTarball(info.Kind, info.Name)
+	}
+
+	source.m.Lock()
+	defer source.m.Unlock()
+	plugin := source.plugin(info.Kind, info.Name, true)
+	if _, ok := plugin.tarballs[info.Version.String()]; !ok {
+		plugin.versions = append(plugin.versions, *info.Version)
+		semver.Sort(plugin.versions)
+	}
+	plugin.tarballs[info.Version.String()] = tarball
+}
+
+// Fail makes every request for the plugin fail with err, until it's called again with a nil error. Errors such as
+// workspace.ErrRateLimited or an *workspace.HTTPError simulate a misbehaving source.
+func (source *Source) Fail(kind workspace.PluginKind, name string, err error) {
+	source.m.Lock()
+	defer source.m.Unlock()
+	source.plugin(kind, name, true).err = err
+}
+
+// Downloads returns the downloads requested from the source so far, in the order they were requested.
+func (source *Source) Downloads() []Download {
+	source.m.Lock()
+	defer source.m.Unlock()
+	return append([]Download(nil), source.downloads...)
+}
+
+// For returns the source of the given plugin. It can be used as a workspace.Context's PluginSource.
+func (source *Source) For(info workspace.PluginInfo) workspace.PluginSource {
+	return &pluginSource{source: source, info: info}
+}
+
+// pluginSource serves a single plugin from a Source.
+type pluginSource struct {
+	source *Source
+	info   workspace.PluginInfo
+}
+
+func (s *pluginSource) Download(version semver.Version, opSy string, arch string,
+	_ func(*http.Request) (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	source := s.source
+	source.m.Lock()
+	defer source.m.Unlock()
+
+	source.downloads = append(source.downloads, Download{Plugin: s.info, Version: version, OS: opSy, Arch: arch})
+	plugin := source.plugin(s.info.Kind, s.info.Name, false)
+	if plugin != nil && plugin.err != nil {
+		return nil, -1, plugin.err
+	}
+	var tarball []byte
+	if plugin != nil {
+		tarball = plugin.tarballs[version.String()]
+	}
+	if tarball == nil {
+		return nil, -1, fmt.Errorf("%w: %s plugin %s v%s has not been published", workspace.ErrNotFound,
+			s.info.Kind, s.info.Name, version)
+	}
+	return ioutil.NopCloser(bytes.NewReader(tarball)), int64(len(tarball)), nil
+}
+
+func (s *pluginSource) GetLatestVersion(
+	_ func(*http.Request) (io.ReadCloser, int64, error)) (*semver.Version, error) {
+	source := s.source
+	source.m.Lock()
+	defer source.m.Unlock()
+
+	plugin := source.plugin(s.info.Kind, s.info.Name, false)
+	if plugin != nil && plugin.err != nil {
+		return nil, plugin.err
+	}
+	if plugin == nil || len(plugin.versions) == 0 {
+		return nil, fmt.Errorf("%w: no version of %s plugin %s has been published", workspace.ErrNotFound,
+			s.info.Kind, s.info.Name)
+	}
+	latest := plugin.versions[len(plugin.versions)-1]
+	return &latest, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package workspacetest provides helpers for testing code that installs, looks up or downloads plugins without
+// touching the user's plugin cache or the network: a workspace context with its own plugin directory, a PluginSource
+// that serves tarballs from memory, a fake plugin server that serves them over HTTP, and plugin fixtures.
+package workspacetest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+// NewContext returns a workspace context whose Pulumi home and plugin directory are temporary directories that are
+// removed when the test finishes. Its plugins are downloaded from source, if it isn't nil.
+func NewContext(t testing.TB, source *Source) *workspace.Context {
+	home := t.TempDir()
+	ctx := &workspace.Context{Home: home, PluginDir: filepath.Join(home, workspace.PluginDir)}
+	if source != nil {
+		ctx.PluginSource = source.For
+	}
+	return ctx
+}
+
+// InstallPlugins installs a fake build of each of the plugins into the context's plugin directories, as if they had
+// been downloaded. Each plugin's version must be set.
+func InstallPlugins(t testing.TB, ctx *workspace.Context, plugins ...workspace.PluginInfo) {
+	for _, info := range plugins {
+		require.NotNil(t, info.Version, "plugin %s must have a version", info.Name)
+		info, err := ctx.Plugin(info)
+		require.NoError(t, err)
+		require.NoError(t, info.InstallWithProgress(
+			ioutil.NopCloser(bytes.NewReader(PluginTarball(info.Kind, info.Name))), false, nil))
+	}
+}
+
+// PluginTarball returns a gzipped tarball holding a fake build of the plugin, with the executables a plugin of its
+// kind and name is loaded from.
+func PluginTarball(kind workspace.PluginKind, name string) []byte {
+	executable := fmt.Sprintf("pulumi-%s-%s", kind, name)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{executable, []byte("#!/bin/sh\n")},
+		{executable + ".exe", nil},
+		{"README.md", []byte(fmt.Sprintf("# The %s %s plugin\n", name, kind))},
+	}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, file := range files {
+		err := tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0700, Size: int64(len(file.data))})
+		contract.AssertNoError(err)
+		_, err = tw.Write(file.data)
+		contract.AssertNoError(err)
+	}
+	contract.AssertNoError(tw.Close())
+	contract.AssertNoError(gw.Close())
+	return buf.Bytes()
+}
+
+// Plugin returns the plugin of the given kind, name and version. An empty version leaves the plugin's version unset.
+func Plugin(kind workspace.PluginKind, name, version string) workspace.PluginInfo {
+	info := workspace.PluginInfo{Name: name, Kind: kind}
+	if version != "" {
+		v := semver.MustParse(version)
+		info.Version = &v
+	}
+	return info
+}
+
+// ResourcePlugin returns the resource plugin with the given name and version, like Plugin.
+func ResourcePlugin(name, version string) workspace.PluginInfo {
+	return Plugin(workspace.ResourcePlugin, name, version)
+}
+
+// Fixtures returns a fixed set of plugins of each kind, sorted by kind, name and version. It returns a new slice each
+// time, so tests can change it.
+func Fixtures() []workspace.PluginInfo {
+	return []workspace.PluginInfo{
+		Plugin(workspace.AnalyzerPlugin, "policy", "1.0.0"),
+		Plugin(workspace.LanguagePlugin, "nodejs", "3.30.0"),
+		ResourcePlugin("aws", "4.38.1"),
+		ResourcePlugin("aws", "5.1.0"),
+		ResourcePlugin("random", "4.2.0"),
+	}
+}
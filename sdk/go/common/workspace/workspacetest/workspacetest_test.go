@@ -0,0 +1,97 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspacetest
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+func TestSource(t *testing.T) {
+	t.Parallel()
+
+	source := NewSource()
+	source.Publish(ResourcePlugin("aws", "4.38.1"), nil)
+	source.Publish(ResourcePlugin("aws", "5.1.0"), nil)
+	ctx := NewContext(t, source)
+
+	latest, err := ctx.GetLatestVersion(ResourcePlugin("aws", ""))
+	require.NoError(t, err)
+	assert.Equal(t, semver.MustParse("5.1.0"), *latest)
+
+	info, err := ctx.Plugin(ResourcePlugin("aws", "5.1.0"))
+	require.NoError(t, err)
+	tarball, _, err := ctx.Download(info)
+	require.NoError(t, err)
+	require.NoError(t, info.InstallWithProgress(tarball, false, nil))
+	plugins, err := ctx.GetPlugins()
+	require.NoError(t, err)
+	require.Len(t, plugins, 1)
+	assert.Equal(t, "aws", plugins[0].Name)
+	assert.Equal(t, []Download{
+		{Plugin: info, Version: semver.MustParse("5.1.0"), OS: runtime.GOOS, Arch: runtime.GOARCH},
+	}, source.Downloads())
+
+	_, _, err = ctx.Download(ResourcePlugin("aws", "6.0.0"))
+	assert.True(t, errors.Is(err, workspace.ErrNotFound), "expected %v to be ErrNotFound", err)
+
+	source.Fail(workspace.ResourcePlugin, "aws", workspace.ErrRateLimited)
+	_, err = ctx.GetLatestVersion(ResourcePlugin("aws", ""))
+	assert.True(t, errors.Is(err, workspace.ErrRateLimited), "expected %v to be ErrRateLimited", err)
+	source.Fail(workspace.ResourcePlugin, "aws", nil)
+	_, err = ctx.GetLatestVersion(ResourcePlugin("aws", ""))
+	assert.NoError(t, err)
+}
+
+func TestInstallPlugins(t *testing.T) {
+	t.Parallel()
+
+	ctx := NewContext(t, nil)
+	InstallPlugins(t, ctx, Fixtures()...)
+	plugins, err := ctx.GetPlugins()
+	require.NoError(t, err)
+
+	var installed []string
+	for _, info := range plugins {
+		installed = append(installed, info.String())
+	}
+	var expected []string
+	for _, info := range Fixtures() {
+		expected = append(expected, info.String())
+	}
+	assert.ElementsMatch(t, expected, installed)
+}
+
+func TestClock(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	ctx := NewContext(t, nil)
+	ctx.Clock = clock
+	assert.Equal(t, start, clock.Now())
+	clock.Advance(time.Hour)
+	assert.Equal(t, start.Add(time.Hour), ctx.Clock.Now())
+	clock.Set(start)
+	assert.Equal(t, start, ctx.Clock.Now())
+}
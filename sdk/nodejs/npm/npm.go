@@ -16,12 +16,15 @@ package npm
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 
 	uuid "github.com/gofrs/uuid"
@@ -80,6 +83,57 @@ func Pack(dir string, stderr io.Writer) ([]byte, error) {
 	return packTarball, nil
 }
 
+// PackageManager identifies a Node.js package manager that can be used to install dependencies.
+type PackageManager string
+
+const (
+	// NPMPackageManager is the `npm` package manager that ships with Node.js.
+	NPMPackageManager PackageManager = "npm"
+	// YarnPackageManager is the `yarn` package manager.
+	YarnPackageManager PackageManager = "yarn"
+	// PnpmPackageManager is the `pnpm` package manager.
+	PnpmPackageManager PackageManager = "pnpm"
+)
+
+// ParsePackageManager parses the name of a package manager, returning an error if it isn't one we support.
+func ParsePackageManager(name string) (PackageManager, error) {
+	switch pm := PackageManager(strings.ToLower(name)); pm {
+	case NPMPackageManager, YarnPackageManager, PnpmPackageManager:
+		return pm, nil
+	default:
+		return "", errors.Errorf("unsupported package manager %q; expected one of npm, yarn or pnpm", name)
+	}
+}
+
+// ResolvePackageManager determines which package manager should be used to install the dependencies of the Node.js
+// app located in dir. An explicitly preferred package manager always wins. Otherwise, the package manager is inferred
+// from the lockfile present in dir (`pnpm-lock.yaml`, `yarn.lock` or `package-lock.json`), and finally from the
+// `PULUMI_PREFER_YARN` environment variable, defaulting to `npm`.
+func ResolvePackageManager(dir string, preferred PackageManager) PackageManager {
+	if preferred != "" {
+		return preferred
+	}
+
+	lockfiles := []struct {
+		file string
+		pm   PackageManager
+	}{
+		{"pnpm-lock.yaml", PnpmPackageManager},
+		{"yarn.lock", YarnPackageManager},
+		{"package-lock.json", NPMPackageManager},
+	}
+	for _, lockfile := range lockfiles {
+		if _, err := os.Stat(filepath.Join(dir, lockfile.file)); err == nil {
+			return lockfile.pm
+		}
+	}
+
+	if preferYarn() {
+		return YarnPackageManager
+	}
+	return NPMPackageManager
+}
+
 // Install runs `npm install` in the given directory, installing the dependencies for the Node.js
 // app located there. If the `PULUMI_PREFER_YARN` environment variable is set, `yarn install` is used
 // instead of `npm install`.
@@ -88,10 +142,59 @@ func Install(dir string, production bool, stdout, stderr io.Writer) (string, err
 	if err != nil {
 		return bin, err
 	}
+	return install(c, npm, bin, dir, stdout, stderr)
+}
+
+// InstallOptions configures InstallWithOptions.
+type InstallOptions struct {
+	// PackageManager is the package manager to use. If it can't be found on the $PATH, `npm` is used instead.
+	PackageManager PackageManager
+	// Production installs only production dependencies.
+	Production bool
+	// Frozen requires the install to exactly match the lockfile for the package manager, failing rather than
+	// resolving new versions (`npm ci`, `install --immutable` for yarn 2 and later, or `install --frozen-lockfile` for
+	// older yarn and pnpm).
+	Frozen bool
+	// NodePath is the path to the `node` executable that the package manager should run with. Its directory is put at
+	// the front of the $PATH for the install. If empty, whichever `node` is on the $PATH is used.
+	NodePath string
+	// Env are additional environment variables, as `KEY=value` pairs, to set for the install.
+	Env []string
+}
+
+// InstallWithOptions installs the dependencies for the Node.js app located in the given directory.
+func InstallWithOptions(dir string, opts InstallOptions, stdout, stderr io.Writer) (string, error) {
+	pm := opts.PackageManager
+	if pm == "" {
+		pm = NPMPackageManager
+	}
+	c, npm, bin, err := getInstallCmd(dir, pm, opts)
+	if err != nil {
+		return bin, err
+	}
+	return install(c, npm, bin, dir, stdout, stderr)
+}
+
+// HasLockfile returns true if the Node.js app located in dir has a lockfile for the given package manager.
+func HasLockfile(dir string, pm PackageManager) bool {
+	var file string
+	switch pm {
+	case PnpmPackageManager:
+		file = "pnpm-lock.yaml"
+	case YarnPackageManager:
+		file = "yarn.lock"
+	default:
+		file = "package-lock.json"
+	}
+	_, err := os.Stat(filepath.Join(dir, file))
+	return err == nil
+}
+
+func install(c *exec.Cmd, npm bool, bin, dir string, stdout, stderr io.Writer) (string, error) {
 	c.Dir = dir
 
 	// Run the command.
-	if err = runCmd(c, npm, stdout, stderr); err != nil {
+	if err := runCmd(c, npm, stdout, stderr); err != nil {
 		return bin, err
 	}
 
@@ -108,19 +211,140 @@ func Install(dir string, production bool, stdout, stderr io.Writer) (string, err
 // on what is available on the current path, and if `PULUMI_PREFER_YARN` is truthy.
 // The boolean return parameter indicates if `npm` is chosen or not (instead of `yarn`).
 func getCmd(command string, production bool) (*exec.Cmd, bool, string, error) {
-	args := []string{command}
-	if production {
-		args = append(args, "--production")
-	}
 	if preferYarn() {
-		const file = "yarn"
-		yarnPath, err := exec.LookPath(file)
+		return getCmdFor(YarnPackageManager, command, production)
+	}
+	return getCmdFor(NPMPackageManager, command, production)
+}
+
+// getInstallCmd returns the exec.Cmd used to install the dependencies of the Node.js app in dir with the given package
+// manager and options. When frozen is set, the install must match the package manager's lockfile exactly. If we had to
+// fall back to `npm` and there's no `package-lock.json` to install from, a regular install is done instead.
+func getInstallCmd(dir string, pm PackageManager, opts InstallOptions) (*exec.Cmd, bool, string, error) {
+	production, frozen := opts.Production, opts.Frozen
+	c, npm, bin, err := getCmdFor(pm, "install", production)
+	if err != nil {
+		return c, npm, bin, err
+	}
+	if len(opts.Env) > 0 {
+		c.Env = append(os.Environ(), opts.Env...)
+	}
+	if opts.NodePath != "" {
+		useNode(c, opts.NodePath)
+	}
+	if npm && production {
+		// npm 7 and later deprecate `--production` in favor of `--omit=dev`, and warn about it on every install.
+		if major, err := npmMajorVersion(c); err != nil {
+			logging.V(5).Infof("could not determine the version of npm: %v", err)
+		} else if major >= 7 {
+			c.Args = append(removeArg(c.Args, "--production"), "--omit=dev")
+		}
+	}
+	berry := !npm && pm == YarnPackageManager && isYarnBerry(dir)
+	if berry && production {
+		// Yarn 2 and later reject `--production`, and can only leave out development dependencies with a plugin.
+		logging.V(5).Infof("yarn 2+ found in %s, installing development dependencies too", dir)
+		c.Args = removeArg(c.Args, "--production")
+	}
+	if !frozen {
+		return c, npm, bin, nil
+	}
+
+	if npm {
+		if !HasLockfile(dir, NPMPackageManager) {
+			logging.Warningf("no package-lock.json found in %s, installing without a lockfile", dir)
+			return c, npm, bin, nil
+		}
+		// `npm ci` installs exactly what's in package-lock.json, failing if it is out of sync with package.json.
+		c.Args[1] = "ci"
+	} else if berry {
+		// Yarn 2 and later replaced `--frozen-lockfile` with `--immutable`.
+		c.Args = append(c.Args, "--immutable")
+	} else {
+		c.Args = append(c.Args, "--frozen-lockfile")
+	}
+	return c, npm, bin, nil
+}
+
+// isYarnBerry returns true if the Node.js app located in dir uses yarn 2 or later, as indicated by a `.yarnrc.yml`
+// file or a `packageManager` field in its package.json naming such a version.
+func isYarnBerry(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, ".yarnrc.yml")); err == nil {
+		return true
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return false
+	}
+	var pkg struct {
+		PackageManager string `json:"packageManager"`
+	}
+	if err := json.Unmarshal(b, &pkg); err != nil {
+		return false
+	}
+	// The field is a name and version, optionally followed by a hash, as in `yarn@3.2.0+sha224.953c8233`.
+	version := strings.TrimPrefix(pkg.PackageManager, "yarn@")
+	if version == pkg.PackageManager {
+		return false
+	}
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	return err == nil && major >= 2
+}
+
+// npmMajorVersion returns the major version of the `npm` that runs c. It's run with the same environment as c, so
+// that npm runs with the same `node`.
+func npmMajorVersion(c *exec.Cmd) (int, error) {
+	probe := exec.Command(c.Path, "--version")
+	probe.Env = c.Env
+	out, err := probe.Output()
+	if err != nil {
+		return 0, err
+	}
+	version := strings.TrimSpace(string(out))
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil {
+		return 0, fmt.Errorf("unexpected npm version %q", version)
+	}
+	return major, nil
+}
+
+// removeArg returns args without any occurrences of arg.
+func removeArg(args []string, arg string) []string {
+	var result []string
+	for _, a := range args {
+		if a != arg {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// getCmdFor returns the exec.Cmd used to run the given command with the given package manager, falling back to `npm`
+// if the package manager isn't available on the current path. The boolean return parameter indicates if `npm` is
+// chosen or not.
+func getCmdFor(pm PackageManager, command string, production bool) (*exec.Cmd, bool, string, error) {
+	args := []string{command}
+	switch pm {
+	case YarnPackageManager, PnpmPackageManager:
+		if production {
+			if pm == PnpmPackageManager {
+				args = append(args, "--prod")
+			} else {
+				args = append(args, "--production")
+			}
+		}
+		file := string(pm)
+		pmPath, err := exec.LookPath(file)
 		if err == nil {
-			return exec.Command(yarnPath, args...), false, file, nil
+			return exec.Command(pmPath, args...), false, file, nil
 		}
-		logging.Warningf("could not find yarn on the $PATH, trying npm instead: %v", err)
+		logging.Warningf("could not find %s on the $PATH, trying npm instead: %v", file, err)
+		args = []string{command}
 	}
 
+	if production {
+		args = append(args, "--production")
+	}
 	const file = "npm"
 	npmPath, err := exec.LookPath(file)
 	if err != nil {
@@ -133,11 +357,41 @@ func getCmd(command string, production bool) (*exec.Cmd, bool, string, error) {
 	return exec.Command(npmPath, args...), true, file, nil
 }
 
+// useNode makes the command run with the given `node` executable by putting its directory at the front of the $PATH
+// the command would otherwise run with.
+func useNode(c *exec.Cmd, nodePath string) {
+	env := c.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	path := filepath.Dir(nodePath)
+	if current := envPath(env); current != "" {
+		path += string(os.PathListSeparator) + current
+	}
+	c.Env = append(env, "PATH="+path)
+}
+
+// envPath returns the $PATH set by env. As with exec.Cmd, the last value wins, and names are matched regardless of
+// case on Windows, where the variable is usually named `Path`.
+func envPath(env []string) string {
+	path := ""
+	for _, kv := range env {
+		eq := strings.Index(kv, "=")
+		if eq < 0 {
+			continue
+		}
+		if name := kv[:eq]; name == "PATH" || (runtime.GOOS == "windows" && strings.EqualFold(name, "PATH")) {
+			path = kv[eq+1:]
+		}
+	}
+	return path
+}
+
 // runCmd handles hooking up `stdout` and `stderr` and then runs the command.
 func runCmd(c *exec.Cmd, npm bool, stdout, stderr io.Writer) error {
 	// Setup `stdout` and `stderr`.
-	// `stderr` is ignored when `yarn` is used because it outputs warnings like "package.json: No license field"
-	// to `stderr` that we don't need to show.
+	// `stderr` is ignored when `yarn` or `pnpm` is used because they output warnings like "package.json: No license
+	// field" to `stderr` that we don't need to show.
 	c.Stdout = stdout
 	var stderrBuffer bytes.Buffer
 	if npm {
@@ -148,7 +402,7 @@ func runCmd(c *exec.Cmd, npm bool, stdout, stderr io.Writer) error {
 
 	// Run the command.
 	if err := c.Run(); err != nil {
-		// If we failed, and we're using `yarn`, write out any bytes that were written to `stderr`.
+		// If we failed, and we're not using `npm`, write out any bytes that were written to `stderr`.
 		if !npm {
 			stderr.Write(stderrBuffer.Bytes())
 		}
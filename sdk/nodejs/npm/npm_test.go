@@ -17,10 +17,14 @@ package npm
 import (
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func chdir(t *testing.T, dir string) {
@@ -79,3 +83,175 @@ func testInstall(t *testing.T, expectedBin string, production bool) {
 	assert.NoError(t, err)
 	assert.Equal(t, expectedBin, bin)
 }
+
+//nolint:paralleltest // mutates environment variables
+func TestResolvePackageManager(t *testing.T) {
+	os.Setenv("PULUMI_PREFER_YARN", "")
+
+	dir := t.TempDir()
+	assert.Equal(t, NPMPackageManager, ResolvePackageManager(dir, ""))
+	assert.Equal(t, PnpmPackageManager, ResolvePackageManager(dir, PnpmPackageManager))
+
+	os.Setenv("PULUMI_PREFER_YARN", "true")
+	assert.Equal(t, YarnPackageManager, ResolvePackageManager(dir, ""))
+	os.Setenv("PULUMI_PREFER_YARN", "")
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "yarn.lock"), nil, 0600))
+	assert.Equal(t, YarnPackageManager, ResolvePackageManager(dir, ""))
+
+	// pnpm lockfiles take precedence, e.g. in pnpm workspaces that were migrated from yarn.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "pnpm-lock.yaml"), nil, 0600))
+	assert.Equal(t, PnpmPackageManager, ResolvePackageManager(dir, ""))
+	assert.Equal(t, NPMPackageManager, ResolvePackageManager(dir, NPMPackageManager))
+}
+
+func TestParsePackageManager(t *testing.T) {
+	t.Parallel()
+
+	pm, err := ParsePackageManager("PNPM")
+	assert.NoError(t, err)
+	assert.Equal(t, PnpmPackageManager, pm)
+
+	_, err = ParsePackageManager("bun")
+	assert.EqualError(t, err, `unsupported package manager "bun"; expected one of npm, yarn or pnpm`)
+}
+
+func TestHasLockfile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	assert.False(t, HasLockfile(dir, NPMPackageManager))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "package-lock.json"), nil, 0600))
+	assert.True(t, HasLockfile(dir, NPMPackageManager))
+	assert.False(t, HasLockfile(dir, YarnPackageManager))
+	assert.False(t, HasLockfile(dir, PnpmPackageManager))
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestFrozenInstallCmd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as a fake npm executable")
+	}
+
+	bin := t.TempDir()
+	fakeNPM := func(version string) {
+		script := "#!/bin/sh\necho " + version + "\n"
+		require.NoError(t, ioutil.WriteFile(filepath.Join(bin, "npm"), []byte(script), 0700)) //nolint:gosec
+	}
+	t.Setenv("PATH", bin)
+	fakeNPM("8.19.2")
+
+	dir := t.TempDir()
+
+	// Without a lockfile, we can't run `npm ci`.
+	c, npm, _, err := getInstallCmd(dir, NPMPackageManager, InstallOptions{Production: true, Frozen: true})
+	assert.NoError(t, err)
+	assert.True(t, npm)
+	assert.Equal(t, []string{"install", "--loglevel=error", "--omit=dev"}, c.Args[1:])
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "package-lock.json"), nil, 0600))
+	c, _, _, err = getInstallCmd(dir, NPMPackageManager, InstallOptions{Production: true, Frozen: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ci", "--loglevel=error", "--omit=dev"}, c.Args[1:])
+
+	// npm 6 and earlier don't know `--omit=dev`.
+	fakeNPM("6.14.17")
+	c, _, _, err = getInstallCmd(dir, NPMPackageManager, InstallOptions{Production: true, Frozen: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ci", "--production", "--loglevel=error"}, c.Args[1:])
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestInstallCmdNodePath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as fake node and npm executables")
+	}
+
+	// npm runs with whichever `node` is first on the $PATH, which decides the version it reports.
+	fakeNode := func(version string) string {
+		dir := t.TempDir()
+		script := "#!/bin/sh\necho " + version + "\n"
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "node"), []byte(script), 0700)) //nolint:gosec
+		return dir
+	}
+	ambient, configured := fakeNode("6.14.17"), fakeNode("8.19.2")
+	npm := "#!/bin/sh\nexec node \"$@\"\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(ambient, "npm"), []byte(npm), 0700)) //nolint:gosec
+	t.Setenv("PATH", ambient)
+
+	dir := t.TempDir()
+	c, _, _, err := getInstallCmd(dir, NPMPackageManager, InstallOptions{Production: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"install", "--production", "--loglevel=error"}, c.Args[1:])
+
+	c, _, _, err = getInstallCmd(dir, NPMPackageManager, InstallOptions{
+		Production: true,
+		NodePath:   filepath.Join(configured, "node"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"install", "--loglevel=error", "--omit=dev"}, c.Args[1:])
+}
+
+func TestIsYarnBerry(t *testing.T) {
+	t.Parallel()
+
+	for packageManager, expected := range map[string]bool{
+		"":                           false,
+		"yarn@1.22.19":               false,
+		"yarn@3.2.0+sha224.953c8233": true,
+		"pnpm@7.0.0":                 false,
+	} {
+		dir := t.TempDir()
+		packageJSON := `{"name": "test-package", "packageManager": "` + packageManager + `"}`
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "package.json"), []byte(packageJSON), 0600))
+		assert.Equal(t, expected, isYarnBerry(dir), packageManager)
+	}
+
+	dir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".yarnrc.yml"), nil, 0600))
+	assert.True(t, isYarnBerry(dir))
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestFrozenInstallCmdYarn(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as a fake yarn executable")
+	}
+
+	bin := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bin, "yarn"), []byte("#!/bin/sh\n"), 0700)) //nolint:gosec
+	t.Setenv("PATH", bin)
+
+	dir := t.TempDir()
+	c, npm, _, err := getInstallCmd(dir, YarnPackageManager, InstallOptions{Production: true, Frozen: true})
+	require.NoError(t, err)
+	assert.False(t, npm)
+	assert.Equal(t, []string{"install", "--production", "--frozen-lockfile"}, c.Args[1:])
+
+	// Yarn 2 and later need `--immutable`, and reject `--production`.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".yarnrc.yml"), nil, 0600))
+	c, _, _, err = getInstallCmd(dir, YarnPackageManager, InstallOptions{Production: true, Frozen: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"install", "--immutable"}, c.Args[1:])
+}
+
+func TestUseNode(t *testing.T) {
+	t.Parallel()
+
+	c := exec.Command("npm", "install")
+	useNode(c, filepath.Join("opt", "node16", "bin", "node"))
+	require.NotEmpty(t, c.Env)
+	last := c.Env[len(c.Env)-1]
+	assert.True(t, strings.HasPrefix(last, "PATH="+filepath.Join("opt", "node16", "bin")+string(os.PathListSeparator)))
+}
+
+func TestUseNodeKeepsEnvPath(t *testing.T) {
+	t.Parallel()
+
+	// A $PATH set for the install, such as one a plugin declares, is kept behind node's directory.
+	c := exec.Command("npm", "install")
+	c.Env = []string{"PATH=/usr/bin", "PATH=/plugin/bin"}
+	useNode(c, filepath.Join("opt", "node16", "bin", "node"))
+	last := c.Env[len(c.Env)-1]
+	assert.Equal(t, "PATH="+filepath.Join("opt", "node16", "bin")+string(os.PathListSeparator)+"/plugin/bin", last)
+}
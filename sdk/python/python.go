@@ -17,12 +17,14 @@ package python
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
 
+	"github.com/blang/semver"
 	"github.com/pkg/errors"
 )
 
@@ -220,6 +222,239 @@ func InstallDependencies(root, venvDir string, showOutput bool) error {
 }
 
 func InstallDependenciesWithWriters(root, venvDir string, showOutput bool, infoWriter, errorWriter io.Writer) error {
+	return InstallDependenciesWithOptions(root, venvDir, InstallDependenciesOptions{
+		ShowOutput:  showOutput,
+		InfoWriter:  infoWriter,
+		ErrorWriter: errorWriter,
+	})
+}
+
+// InstallDependenciesOptions configures InstallDependenciesWithOptions.
+type InstallDependenciesOptions struct {
+	// ShowOutput shows the output of the commands that are run. Otherwise, output is only shown if there is an error.
+	ShowOutput bool
+	// InfoWriter receives informational output. Defaults to os.Stdout.
+	InfoWriter io.Writer
+	// ErrorWriter receives error output. Defaults to os.Stderr.
+	ErrorWriter io.Writer
+	// RequireHashes passes `--require-hashes` when installing from requirements.txt, so that every requirement must be
+	// pinned with a hash and installs are reproducible.
+	RequireHashes bool
+	// Pip configures the package index and TLS settings passed to every `pip install`.
+	Pip PipOptions
+	// Poetry installs the dependencies declared in the root directory's pyproject.toml (and poetry.lock, if present)
+	// with poetry instead of installing requirements.txt with pip.
+	Poetry bool
+	// Env are additional environment variables, as `KEY=value` pairs, to set for the commands that are run.
+	Env []string
+}
+
+// PipOptions configures where pip installs packages from, e.g. to install from a corporate package mirror.
+type PipOptions struct {
+	// IndexURL replaces the default package index (`--index-url`).
+	IndexURL string
+	// ExtraIndexURLs are additional package indexes to use (`--extra-index-url`).
+	ExtraIndexURLs []string
+	// TrustedHosts are hosts that are trusted even without valid HTTPS (`--trusted-host`).
+	TrustedHosts []string
+	// Cert is the path to an alternate CA bundle (`--cert`).
+	Cert string
+	// NoIndex installs only from FindLinks, never contacting a package index (`--no-index`). pip, setuptools and
+	// wheel are not upgraded in the virtual environment when this is set.
+	NoIndex bool
+	// FindLinks are local directories or URLs to look for archives in (`--find-links`).
+	FindLinks []string
+}
+
+// Args returns the pip command line arguments for these options.
+func (opts PipOptions) Args() []string {
+	var args []string
+	if opts.IndexURL != "" {
+		args = append(args, "--index-url", opts.IndexURL)
+	}
+	for _, url := range opts.ExtraIndexURLs {
+		args = append(args, "--extra-index-url", url)
+	}
+	for _, host := range opts.TrustedHosts {
+		args = append(args, "--trusted-host", host)
+	}
+	if opts.Cert != "" {
+		args = append(args, "--cert", opts.Cert)
+	}
+	if opts.NoIndex {
+		args = append(args, "--no-index")
+	}
+	for _, link := range opts.FindLinks {
+		args = append(args, "--find-links", link)
+	}
+	return args
+}
+
+// IsPoetryProject returns true if the Python project in the given directory manages its dependencies with poetry:
+// either it has a poetry.lock, or its pyproject.toml has a `[tool.poetry]` section and there is no requirements.txt.
+func IsPoetryProject(root string) bool {
+	pyproject, err := ioutil.ReadFile(filepath.Join(root, "pyproject.toml"))
+	if err != nil {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(root, "poetry.lock")); err == nil {
+		return true
+	}
+	if _, err := os.Stat(filepath.Join(root, "requirements.txt")); err == nil {
+		return false
+	}
+	return strings.Contains(string(pyproject), "[tool.poetry]")
+}
+
+// poetryOnlyVersion is the first version of poetry with dependency groups, and so `install --only`.
+var poetryOnlyVersion = semver.MustParse("1.2.0")
+
+// parsePoetryVersion parses the output of `poetry --version`, which is `Poetry version 1.1.15` before 1.2 and
+// `Poetry (version 1.2.0)` since.
+func parsePoetryVersion(output string) (semver.Version, error) {
+	fields := strings.Fields(strings.TrimSpace(output))
+	if len(fields) == 0 {
+		return semver.Version{}, errors.New("poetry printed no version")
+	}
+	return semver.ParseTolerant(strings.TrimSuffix(fields[len(fields)-1], ")"))
+}
+
+// poetryLockRequirements returns the `name==version` requirements of the main dependencies pinned in a poetry.lock.
+// Lockfiles written before poetry 1.5 record the group of each package as its `category`, and the packages of the
+// `dev` category are skipped; later lockfiles don't record groups, so all of their packages are returned.
+func poetryLockRequirements(lock string) []string {
+	var requirements []string
+	var name, version, category string
+	inPackage := false
+	end := func() {
+		if inPackage && name != "" && version != "" && category != "dev" {
+			requirements = append(requirements, name+"=="+version)
+		}
+		name, version, category = "", "", ""
+	}
+	for _, line := range strings.Split(lock, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			// Every table other than a `[[package]]` ends the current package, including its subtables such as
+			// `[package.dependencies]`, whose keys aren't the package's.
+			end()
+			inPackage = line == "[[package]]"
+			continue
+		}
+		if !inPackage {
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			continue
+		}
+		key, value := strings.TrimSpace(line[:eq]), strings.Trim(strings.TrimSpace(line[eq+1:]), `"'`)
+		switch key {
+		case "name":
+			name = value
+		case "version":
+			version = value
+		case "category":
+			category = value
+		}
+	}
+	end()
+	return requirements
+}
+
+// installPoetryLock installs the main dependencies pinned in the poetry.lock at lockPath with pip.
+func installPoetryLock(lockPath, venvDir string, runPipInstall func(errorMsg string, arg ...string) error) error {
+	lock, err := ioutil.ReadFile(lockPath)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", lockPath)
+	}
+	requirements := poetryLockRequirements(string(lock))
+	if len(requirements) == 0 {
+		return nil
+	}
+	f, err := ioutil.TempFile(venvDir, "poetry-requirements-*.txt")
+	if err != nil {
+		return errors.Wrap(err, "writing the requirements of poetry.lock")
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(strings.Join(requirements, "\n") + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "writing the requirements of poetry.lock")
+	}
+	return runPipInstall("installing dependencies", "-r", f.Name())
+}
+
+// installPoetryDependencies installs a poetry project's dependencies into the virtual environment at venvDir. Poetry
+// installs into the active virtual environment when `VIRTUAL_ENV` is set, so we activate ours rather than letting
+// poetry create its own. If poetry isn't on the $PATH, the packages pinned in poetry.lock are installed with pip
+// instead, the way `poetry export` would list them; a project without a poetry.lock is installed with pip from its
+// pyproject.toml.
+func installPoetryDependencies(root, venvDir string, env []string, showOutput bool, infoWriter, errorWriter io.Writer,
+	runPipInstall func(errorMsg string, arg ...string) error) error {
+	poetryPath, err := exec.LookPath("poetry")
+	if err != nil {
+		lockPath := filepath.Join(root, "poetry.lock")
+		if _, err := os.Stat(lockPath); err == nil {
+			fmt.Fprintf(errorWriter, "warning: could not find poetry on the $PATH, installing the packages pinned "+
+				"in poetry.lock with pip instead\n")
+			return installPoetryLock(lockPath, venvDir, runPipInstall)
+		}
+		fmt.Fprintf(errorWriter, "warning: could not find poetry on the $PATH, installing with pip instead\n")
+		return runPipInstall("installing dependencies", ".")
+	}
+
+	poetryEnv := append(append(ActivateVirtualEnv(os.Environ(), venvDir), env...), "VIRTUAL_ENV="+venvDir)
+
+	// Dependency groups, and so `--only`, are new in poetry 1.2; earlier versions leave out the dev dependencies
+	// with `--no-dev`, which later versions deprecate.
+	versionCmd := exec.Command(poetryPath, "--version")
+	versionCmd.Env = poetryEnv
+	output, err := versionCmd.Output()
+	if err != nil {
+		return errors.Wrapf(err, "getting the version of poetry via '%s'", strings.Join(versionCmd.Args, " "))
+	}
+	version, err := parsePoetryVersion(string(output))
+	if err != nil {
+		return errors.Wrapf(err, "parsing the version of poetry %q", strings.TrimSpace(string(output)))
+	}
+	args := []string{"install", "--no-root", "--no-interaction", "--no-dev"}
+	if version.GTE(poetryOnlyVersion) {
+		args = []string{"install", "--no-root", "--no-interaction", "--only", "main"}
+	}
+
+	cmd := exec.Command(poetryPath, args...)
+	cmd.Dir = root
+	cmd.Env = poetryEnv
+	if showOutput {
+		cmd.Stdout, cmd.Stderr = infoWriter, errorWriter
+		if err := cmd.Run(); err != nil {
+			return errors.Wrapf(err, "installing dependencies via '%s'", strings.Join(cmd.Args, " "))
+		}
+		return nil
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if len(output) > 0 {
+			fmt.Fprintf(errorWriter, "%s\n", string(output))
+		}
+		return errors.Wrapf(err, "installing dependencies via '%s'", strings.Join(cmd.Args, " "))
+	}
+	return nil
+}
+
+// InstallDependenciesWithOptions will create a new virtual environment and install dependencies in the root directory.
+func InstallDependenciesWithOptions(root, venvDir string, opts InstallDependenciesOptions) error {
+	showOutput := opts.ShowOutput
+	infoWriter, errorWriter := opts.InfoWriter, opts.ErrorWriter
+	if infoWriter == nil {
+		infoWriter = os.Stdout
+	}
+	if errorWriter == nil {
+		errorWriter = os.Stderr
+	}
+
 	print := func(message string) {
 		if showOutput {
 			fmt.Fprintf(infoWriter, "%s\n", message)
@@ -237,6 +472,9 @@ func InstallDependenciesWithWriters(root, venvDir string, showOutput bool, infoW
 	if err != nil {
 		return err
 	}
+	if len(opts.Env) > 0 {
+		cmd.Env = append(os.Environ(), opts.Env...)
+	}
 	if output, err := cmd.CombinedOutput(); err != nil {
 		if len(output) > 0 {
 			fmt.Fprintf(errorWriter, "%s\n", string(output))
@@ -247,9 +485,10 @@ func InstallDependenciesWithWriters(root, venvDir string, showOutput bool, infoW
 	print("Finished creating virtual environment")
 
 	runPipInstall := func(errorMsg string, arg ...string) error {
-		pipCmd := VirtualEnvCommand(venvDir, "python", append([]string{"-m", "pip", "install"}, arg...)...)
+		pipArgs := append(append([]string{"-m", "pip", "install"}, opts.Pip.Args()...), arg...)
+		pipCmd := VirtualEnvCommand(venvDir, "python", pipArgs...)
 		pipCmd.Dir = root
-		pipCmd.Env = ActivateVirtualEnv(os.Environ(), venvDir)
+		pipCmd.Env = append(ActivateVirtualEnv(os.Environ(), venvDir), opts.Env...)
 
 		wrapError := func(err error) error {
 			return errors.Wrapf(err, "%s via '%s'", errorMsg, strings.Join(pipCmd.Args, " "))
@@ -274,14 +513,26 @@ func InstallDependenciesWithWriters(root, venvDir string, showOutput bool, infoW
 		return nil
 	}
 
-	print("Updating pip, setuptools, and wheel in virtual environment...")
+	if !opts.Pip.NoIndex {
+		print("Updating pip, setuptools, and wheel in virtual environment...")
 
-	err = runPipInstall("updating pip, setuptools, and wheel", "--upgrade", "pip", "setuptools", "wheel")
-	if err != nil {
-		return err
+		err = runPipInstall("updating pip, setuptools, and wheel", "--upgrade", "pip", "setuptools", "wheel")
+		if err != nil {
+			return err
+		}
+
+		print("Finished updating")
 	}
 
-	print("Finished updating")
+	if opts.Poetry {
+		print("Installing dependencies in virtual environment with poetry...")
+		err := installPoetryDependencies(root, venvDir, opts.Env, showOutput, infoWriter, errorWriter, runPipInstall)
+		if err != nil {
+			return err
+		}
+		print("Finished installing dependencies")
+		return nil
+	}
 
 	// If `requirements.txt` doesn't exist, exit early.
 	requirementsPath := filepath.Join(root, "requirements.txt")
@@ -291,7 +542,11 @@ func InstallDependenciesWithWriters(root, venvDir string, showOutput bool, infoW
 
 	print("Installing dependencies in virtual environment...")
 
-	err = runPipInstall("installing dependencies", "-r", "requirements.txt")
+	args := []string{"-r", "requirements.txt"}
+	if opts.RequireHashes {
+		args = append(args, "--require-hashes")
+	}
+	err = runPipInstall("installing dependencies", args...)
 	if err != nil {
 		return err
 	}
@@ -113,3 +113,93 @@ func TestRunningPipInVirtualEnvironment(t *testing.T) {
 		assert.Failf(t, "pip install command failed with output: %s", string(output))
 	}
 }
+
+func TestIsPoetryProject(t *testing.T) {
+	t.Parallel()
+
+	tempdir := t.TempDir()
+	assert.False(t, IsPoetryProject(tempdir))
+
+	pyproject := []byte("[tool.poetry]\nname = \"plugin\"\n")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "pyproject.toml"), pyproject, 0600))
+	assert.True(t, IsPoetryProject(tempdir))
+
+	// A requirements.txt takes precedence over a pyproject.toml without a lockfile.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "requirements.txt"), nil, 0600))
+	assert.False(t, IsPoetryProject(tempdir))
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "poetry.lock"), nil, 0600))
+	assert.True(t, IsPoetryProject(tempdir))
+}
+
+func TestParsePoetryVersion(t *testing.T) {
+	t.Parallel()
+
+	for output, expected := range map[string]string{
+		"Poetry version 1.1.15\n":  "1.1.15",
+		"Poetry (version 1.2.0)\n": "1.2.0",
+		"Poetry (version 1.8.3)":   "1.8.3",
+	} {
+		version, err := parsePoetryVersion(output)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, version.String())
+	}
+
+	_, err := parsePoetryVersion("")
+	assert.Error(t, err)
+}
+
+func TestPoetryLockRequirements(t *testing.T) {
+	t.Parallel()
+
+	lock := `[[package]]
+name = "pulumi"
+version = "3.40.0"
+description = "Pulumi's Python SDK"
+category = "main"
+
+[package.dependencies]
+grpcio = ">=1.33.2"
+
+[[package]]
+name = "grpcio"
+version = "1.49.1"
+category = "main"
+
+[[package]]
+name = "pytest"
+version = "7.1.3"
+category = "dev"
+
+[metadata]
+lock-version = "1.1"
+content-hash = "abc"
+`
+	assert.Equal(t, []string{"pulumi==3.40.0", "grpcio==1.49.1"}, poetryLockRequirements(lock))
+
+	// Lockfiles written by poetry 1.5 and later don't record categories.
+	assert.Equal(t, []string{"pulumi==3.40.0"},
+		poetryLockRequirements("[[package]]\nname = \"pulumi\"\nversion = \"3.40.0\"\n"))
+}
+
+func TestPipOptionsArgs(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, PipOptions{}.Args())
+	assert.Equal(t, []string{
+		"--index-url", "https://pypi.corp/simple",
+		"--extra-index-url", "https://a.corp/simple",
+		"--extra-index-url", "https://b.corp/simple",
+		"--trusted-host", "a.corp",
+		"--cert", "/etc/ssl/corp.pem",
+	}, PipOptions{
+		IndexURL:       "https://pypi.corp/simple",
+		ExtraIndexURLs: []string{"https://a.corp/simple", "https://b.corp/simple"},
+		TrustedHosts:   []string{"a.corp"},
+		Cert:           "/etc/ssl/corp.pem",
+	}.Args())
+	assert.Equal(t, []string{"--no-index", "--find-links", "wheels"}, PipOptions{
+		NoIndex:   true,
+		FindLinks: []string{"wheels"},
+	}.Args())
+}